@@ -0,0 +1,115 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package cmd
+
+import (
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/hexya-erp/hexya/hexya/models"
+	"github.com/hexya-erp/hexya/hexya/models/security"
+	"github.com/hexya-erp/hexya/hexya/server"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const genDataFileName string = "gendata.go"
+
+var genDataCmd = &cobra.Command{
+	Use:   "gen-data [projectDir]",
+	Short: "Generate fake records for load testing",
+	Long: `Create fake records with random but plausible values in the database of the project.
+Models and number of records are given with the --models flag, for instance:
+
+    hexya gen-data --models Partner=10000,SaleOrder=50000
+
+Models are filled in the given order. Relation fields are given existing records,
+and records are created for required relations if the related model is empty.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		projectDir := "."
+		if len(args) > 0 {
+			projectDir = args[0]
+		}
+		generateAndRunFile(projectDir, genDataFileName, genDataTemplate)
+	},
+}
+
+// GenerateData creates fake records in the database for the models given
+// in the GenData.Models configuration key. It is meant to be called from a
+// project start file which imports all the project's module.
+func GenerateData(config map[string]interface{}) {
+	setupConfig(config)
+	setupLogger()
+	server.PreInit()
+	connectToDB()
+	models.BootStrap()
+	seed := viper.GetInt64("GenData.Seed")
+	batchSize := viper.GetInt("GenData.BatchSize")
+	if batchSize <= 0 {
+		log.Panic("Batch size must be positive", "batchSize", batchSize)
+	}
+	for i, spec := range viper.GetStringSlice("GenData.Models") {
+		modelName, count := parseGenDataSpec(spec)
+		factory := models.NewFactory(models.Registry.MustGet(modelName), seed+int64(i))
+		factory.FillRate = viper.GetFloat64("GenData.FillRate")
+		for done := 0; done < count; done += batchSize {
+			size := batchSize
+			if count-done < size {
+				size = count - done
+			}
+			err := models.ExecuteInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+				factory.Create(env, size)
+			})
+			if err != nil {
+				log.Panic("Unable to generate records", "model", modelName, "error", err)
+			}
+			log.Info("Records generated", "model", modelName, "count", done+size, "total", count)
+		}
+	}
+	log.Info("Data generated successfully")
+}
+
+// parseGenDataSpec returns the model name and the number
+// of records of the given "Model=count" specification.
+func parseGenDataSpec(spec string) (string, int) {
+	parts := strings.SplitN(spec, "=", 2)
+	if len(parts) != 2 {
+		log.Panic("Invalid model specification, expected Model=count", "spec", spec)
+	}
+	count, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+	if err != nil || count < 0 {
+		log.Panic("Invalid number of records", "spec", spec)
+	}
+	return strings.TrimSpace(parts[0]), count
+}
+
+func init() {
+	genDataCmd.PersistentFlags().StringSlice("models", []string{}, "Models to fill with the number of records to create, as Model=count")
+	viper.BindPFlag("GenData.Models", genDataCmd.PersistentFlags().Lookup("models"))
+	genDataCmd.PersistentFlags().Int64("seed", 1, "Seed of the random values. Runs with the same seed on the same data give the same records")
+	viper.BindPFlag("GenData.Seed", genDataCmd.PersistentFlags().Lookup("seed"))
+	genDataCmd.PersistentFlags().Int("batch-size", 1000, "Number of records created in each transaction")
+	viper.BindPFlag("GenData.BatchSize", genDataCmd.PersistentFlags().Lookup("batch-size"))
+	genDataCmd.PersistentFlags().Float64("fill-rate", 0.8, "Probability that an optional field is given a value")
+	viper.BindPFlag("GenData.FillRate", genDataCmd.PersistentFlags().Lookup("fill-rate"))
+	HexyaCmd.AddCommand(genDataCmd)
+}
+
+var genDataTemplate = template.Must(template.New("").Parse(`
+// This file is autogenerated by hexya-gen-data
+// DO NOT MODIFY THIS FILE - ANY CHANGES WILL BE OVERWRITTEN
+
+package main
+
+import (
+	"github.com/hexya-erp/hexya/cmd"
+{{ range .Imports }}	_ "{{ . }}"
+{{ end }}
+)
+
+func main() {
+	cmd.GenerateData({{ .Config }})
+}
+`))
@@ -20,6 +20,7 @@ import (
 	"path/filepath"
 	"runtime"
 	"strings"
+	"time"
 
 	"github.com/hexya-erp/hexya/hexya/tools/logging"
 	"github.com/spf13/cobra"
@@ -81,6 +82,21 @@ func init() {
 	viper.BindPFlag("DB.SSLKey", HexyaCmd.PersistentFlags().Lookup("db-ssl-key"))
 	HexyaCmd.PersistentFlags().String("db-ssl-ca", "", "Path to certificate authority certificate(s) file")
 	viper.BindPFlag("DB.SSLCA", HexyaCmd.PersistentFlags().Lookup("db-ssl-ca"))
+	HexyaCmd.PersistentFlags().Int("db-query-budget", 0, "Maximum number of SQL queries per transaction before a warning is logged (an error in tests). 0 means no limit")
+	viper.BindPFlag("DB.QueryBudget", HexyaCmd.PersistentFlags().Lookup("db-query-budget"))
+	HexyaCmd.PersistentFlags().Duration("db-slow-query", 0, "Duration above which SQL queries are logged as slow with the model method that executed them. 0 means no report")
+	viper.BindPFlag("DB.SlowQueryThreshold", HexyaCmd.PersistentFlags().Lookup("db-slow-query"))
+	HexyaCmd.PersistentFlags().Duration("db-migration-timeout", 10*time.Minute, "Maximum time to wait for another instance to finish updating the database. 0 means no limit")
+	viper.BindPFlag("DB.MigrationTimeout", HexyaCmd.PersistentFlags().Lookup("db-migration-timeout"))
+	HexyaCmd.PersistentFlags().Bool("db-destructive-sync", false, "Apply the schema changes that may lose data when updating the database, such as dropping the tables and columns of removed models and fields. Otherwise, they are only logged")
+	viper.BindPFlag("DB.DestructiveSync", HexyaCmd.PersistentFlags().Lookup("db-destructive-sync"))
+	HexyaCmd.PersistentFlags().Bool("db-unaccent-search", false, "Ignore accents in case-insensitive searches on all char and text fields")
+	viper.BindPFlag("DB.UnaccentSearch", HexyaCmd.PersistentFlags().Lookup("db-unaccent-search"))
+
+	HexyaCmd.PersistentFlags().String("filestore", "db", "Storage of attachment binary fields. Must be one of 'db' (default), 'local' or 's3'. S3 parameters are read from the Filestore.S3 configuration keys")
+	viper.BindPFlag("Filestore.Type", HexyaCmd.PersistentFlags().Lookup("filestore"))
+	HexyaCmd.PersistentFlags().String("filestore-dir", "", "Directory of the local filestore. Defaults to the 'filestore' subdirectory of the data directory")
+	viper.BindPFlag("Filestore.Dir", HexyaCmd.PersistentFlags().Lookup("filestore-dir"))
 }
 
 func initConfig() {
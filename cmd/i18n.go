@@ -8,6 +8,7 @@ import (
 	"go/ast"
 	"go/parser"
 	"go/token"
+	"os"
 	"path/filepath"
 	"strings"
 
@@ -50,6 +51,21 @@ in the i18n directory of the module.`,
 	},
 }
 
+var i18nExtract = &cobra.Command{
+	Use:   "extract [dir]",
+	Short: "Extract translatable terms into a POT file",
+	Long: `Extract the translatable terms of the module specified by 'dir'
+(field labels, help strings, selection values, view texts and strings
+passed to T()) into a POT file named after the module in its i18n directory.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		moduleDir := "."
+		if len(args) > 0 {
+			moduleDir = args[0]
+		}
+		extractPOTFile(moduleDir)
+	},
+}
+
 // A messageRef identifies unique messages
 type messageRef struct {
 	msgId   string
@@ -61,6 +77,51 @@ type messageRef struct {
 func updatePOFiles(moduleDir string, langs []string) {
 	i18nDir := filepath.Join(moduleDir, "i18n")
 	server.LoadModuleTranslations(i18nDir, langs)
+	_, modelsASTData := loadModuleASTData(moduleDir)
+	for _, lang := range langs {
+		file := po.File{
+			Messages: extractMessages(lang, moduleDir, modelsASTData),
+			MimeHeader: po.Header{
+				Language:                lang,
+				ContentType:             "text/plain; charset=utf-8",
+				ContentTransferEncoding: "8bit",
+				MimeVersion:             "1.0",
+			},
+		}
+		err := file.Save(fmt.Sprintf("%s/%s.po", i18nDir, lang))
+		if err != nil {
+			log.Panic("Error while saving PO file", "error", err)
+		}
+	}
+}
+
+// extractPOTFile creates or overwrites the POT file of the module in the
+// given dir with all the translatable terms of the module. The POT file
+// is named after the module and has no translations.
+func extractPOTFile(moduleDir string) {
+	i18nDir := filepath.Join(moduleDir, "i18n")
+	if err := os.MkdirAll(i18nDir, 0755); err != nil {
+		log.Panic("Unable to create i18n directory", "dir", i18nDir, "error", err)
+	}
+	modName, modelsASTData := loadModuleASTData(moduleDir)
+	file := po.File{
+		Messages: extractMessages("", moduleDir, modelsASTData),
+		MimeHeader: po.Header{
+			ProjectIdVersion:        modName,
+			ContentType:             "text/plain; charset=utf-8",
+			ContentTransferEncoding: "8bit",
+			MimeVersion:             "1.0",
+		},
+	}
+	err := file.Save(filepath.Join(i18nDir, fmt.Sprintf("%s.pot", modName)))
+	if err != nil {
+		log.Panic("Error while saving POT file", "error", err)
+	}
+}
+
+// loadModuleASTData parses the module in the given dir and returns
+// its name and the AST data of the models it defines.
+func loadModuleASTData(moduleDir string) (string, map[string]generate.ModelASTData) {
 	conf := loader.Config{}
 	conf.Import(moduleDir)
 	program, err := conf.Load()
@@ -72,41 +133,32 @@ func updatePOFiles(moduleDir string, langs []string) {
 		log.Panic("Something has gone wrong, we have more than one package", "packs", packs)
 	}
 	modInfos := []*generate.ModuleInfo{{PackageInfo: *packs[0], ModType: generate.Base}}
-	modelsASTData := generate.GetModelsASTDataForModules(modInfos, false)
+	return packs[0].Pkg.Name(), generate.GetModelsASTDataForModules(modInfos, false)
+}
 
-	for _, lang := range langs {
-		messages := make(map[messageRef]po.Message)
-		for model, modelASTData := range modelsASTData {
-			for field, fieldASTData := range modelASTData.Fields {
-				messages = addDescriptionToMessages(lang, model, field, fieldASTData, messages)
-				messages = addHelpToMessages(lang, model, field, fieldASTData, messages)
-				messages = addSelectionToMessages(lang, model, field, fieldASTData, messages)
-			}
+// extractMessages returns the translatable terms of the module in the given
+// dir as PO messages, with their current translation in the given lang.
+// If lang is empty, the messages are returned without translation.
+func extractMessages(lang string, moduleDir string, modelsASTData map[string]generate.ModelASTData) []po.Message {
+	messages := make(map[messageRef]po.Message)
+	for model, modelASTData := range modelsASTData {
+		for field, fieldASTData := range modelASTData.Fields {
+			messages = addDescriptionToMessages(lang, model, field, fieldASTData, messages)
+			messages = addHelpToMessages(lang, model, field, fieldASTData, messages)
+			messages = addSelectionToMessages(lang, model, field, fieldASTData, messages)
 		}
-		messages = addResourceItemsToMessages(lang, filepath.Join(moduleDir, "resources"), messages)
-		messages = addCodeToMessages(lang, moduleDir, messages)
+	}
+	messages = addResourceItemsToMessages(lang, filepath.Join(moduleDir, "resources"), messages)
+	messages = addCodeToMessages(lang, moduleDir, messages)
 
-		msgs := make([]po.Message, len(messages))
-		i := 0
-		for _, m := range messages {
-			m.ExtractedComment = strings.TrimSuffix(m.ExtractedComment, "\n")
-			msgs[i] = m
-			i += 1
-		}
-		file := po.File{
-			Messages: msgs,
-			MimeHeader: po.Header{
-				Language:                lang,
-				ContentType:             "text/plain; charset=utf-8",
-				ContentTransferEncoding: "8bit",
-				MimeVersion:             "1.0",
-			},
-		}
-		err = file.Save(fmt.Sprintf("%s/%s.po", i18nDir, lang))
-		if err != nil {
-			log.Panic("Error while saving PO file", "error", err)
-		}
+	msgs := make([]po.Message, len(messages))
+	i := 0
+	for _, m := range messages {
+		m.ExtractedComment = strings.TrimSuffix(m.ExtractedComment, "\n")
+		msgs[i] = m
+		i++
 	}
+	return msgs
 }
 
 // addCodeToMessages adds to the given messages map the translatable fields of the code
@@ -276,4 +328,5 @@ func init() {
 	i18nUpdate.PersistentFlags().StringSliceP("languages", "l", []string{}, "Comma separated list of languages codes to load (ex: fr,de,es).")
 	HexyaCmd.AddCommand(i18nCmd)
 	i18nCmd.AddCommand(i18nUpdate)
+	i18nCmd.AddCommand(i18nExtract)
 }
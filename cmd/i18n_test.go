@@ -0,0 +1,42 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hexya-erp/hexya/hexya/tools/po"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestExtractPOTFile(t *testing.T) {
+	Convey("Extracting the POT file of a module", t, func() {
+		moduleDir := "./testdata/i18nmodule"
+		defer os.RemoveAll(filepath.Join(moduleDir, "i18n"))
+		So(func() { extractPOTFile(moduleDir) }, ShouldNotPanic)
+		file, err := po.Load(filepath.Join(moduleDir, "i18n", "i18nmodule.pot"))
+		So(err, ShouldBeNil)
+		Convey("The header should hold the module name", func() {
+			So(file.MimeHeader.ProjectIdVersion, ShouldEqual, "i18nmodule")
+		})
+		Convey("All translatable terms should be extracted without translation", func() {
+			comments := make(map[string]string)
+			for _, msg := range file.Messages {
+				So(msg.MsgStr, ShouldBeEmpty)
+				comments[msg.MsgId] = msg.ExtractedComment
+			}
+			So(comments, ShouldResemble, map[string]string{
+				"Partner Name":            "field:I18NPartner.Name",
+				"The name of the partner": "help:I18NPartner.Name",
+				"Kind":                    "field:I18NPartner.Kind",
+				"Person":                  "selection:I18NPartner.Kind",
+				"Company":                 "selection:I18NPartner.Kind",
+				"Partners":                "resource:i18n_partner_menu",
+				"Unknown partner":         "code:",
+			})
+		})
+	})
+}
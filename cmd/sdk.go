@@ -0,0 +1,78 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"text/template"
+
+	"github.com/hexya-erp/hexya/hexya/models"
+	"github.com/hexya-erp/hexya/hexya/tools/sdk"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const sdkFileName string = "sdk.go"
+
+var sdkCmd = &cobra.Command{
+	Use:   "sdk [projectDir]",
+	Short: "Generate a client SDK of the RPC API",
+	Long: `Generate a typed client SDK in TypeScript or Go for the RPC API of the project.
+The SDK includes the data types, selection values and methods of all the models of the project's modules.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		projectDir := "."
+		if len(args) > 0 {
+			projectDir = args[0]
+		}
+		output, _ := filepath.Abs(viper.GetString("SDK.Output"))
+		viper.Set("SDK.Output", output)
+		generateAndRunFile(projectDir, sdkFileName, sdkTemplate)
+	},
+}
+
+// GenerateSDK writes the client SDK of the project's models. It is meant to
+// be called from a project start file which imports all the project's module.
+func GenerateSDK(config map[string]interface{}) {
+	setupConfig(config)
+	setupLogger()
+	models.BootStrap()
+	outFile, err := os.Create(viper.GetString("SDK.Output"))
+	if err != nil {
+		log.Panic("Unable to create SDK file", "file", viper.GetString("SDK.Output"), "error", err)
+	}
+	defer outFile.Close()
+	lang := sdk.Language(viper.GetString("SDK.Lang"))
+	if err = sdk.Generate(outFile, lang, models.Registry.Describe(), viper.GetString("SDK.Package")); err != nil {
+		log.Panic("Unable to generate SDK", "language", lang, "error", err)
+	}
+	log.Info("SDK generated successfully", "file", viper.GetString("SDK.Output"))
+}
+
+func init() {
+	sdkCmd.PersistentFlags().String("lang", "ts", "Language of the SDK. Must be one of 'ts' (TypeScript) or 'go'")
+	viper.BindPFlag("SDK.Lang", sdkCmd.PersistentFlags().Lookup("lang"))
+	sdkCmd.PersistentFlags().String("output", "hexya-sdk.ts", "File to which the SDK is written")
+	viper.BindPFlag("SDK.Output", sdkCmd.PersistentFlags().Lookup("output"))
+	sdkCmd.PersistentFlags().String("package", "hexyaclient", "Package name of the Go SDK")
+	viper.BindPFlag("SDK.Package", sdkCmd.PersistentFlags().Lookup("package"))
+	HexyaCmd.AddCommand(sdkCmd)
+}
+
+var sdkTemplate = template.Must(template.New("").Parse(`
+// This file is autogenerated by hexya-sdk
+// DO NOT MODIFY THIS FILE - ANY CHANGES WILL BE OVERWRITTEN
+
+package main
+
+import (
+	"github.com/hexya-erp/hexya/cmd"
+{{ range .Imports }}	_ "{{ . }}"
+{{ end }}
+)
+
+func main() {
+	cmd.GenerateSDK({{ .Config }})
+}
+`))
@@ -15,25 +15,43 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
+	"net"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
+	"strings"
+	"syscall"
 	"text/template"
+	"time"
 
 	"github.com/gin-contrib/pprof"
 	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis"
 	"github.com/hexya-erp/hexya/hexya/actions"
+	"github.com/hexya-erp/hexya/hexya/bus"
 	"github.com/hexya-erp/hexya/hexya/controllers"
 	"github.com/hexya-erp/hexya/hexya/i18n"
 	"github.com/hexya-erp/hexya/hexya/menus"
 	"github.com/hexya-erp/hexya/hexya/models"
+	"github.com/hexya-erp/hexya/hexya/reports"
 	"github.com/hexya-erp/hexya/hexya/server"
+	"github.com/hexya-erp/hexya/hexya/tools/filestore"
 	"github.com/hexya-erp/hexya/hexya/tools/generate"
 	"github.com/hexya-erp/hexya/hexya/tools/logging"
 	"github.com/hexya-erp/hexya/hexya/views"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 )
 
 const startFileName = "start.go"
@@ -81,17 +99,66 @@ func StartServer(config map[string]interface{}) {
 	setupConfig(config)
 	setupLogger()
 	setupDebug()
+	stopTracing := setupTracing()
+	setupRoles()
+	setupQuotas()
+	models.SetSnowflakeNode(viper.GetInt64("Server.NodeID"))
+	setupBaseURL()
+	setupSessions()
+	if interval := viper.GetDuration("Server.CronInterval"); interval > 0 {
+		server.CronPollInterval = interval
+	}
+	server.MetricsPath = viper.GetString("Server.MetricsPath")
+	reports.PDFEngineInUse = reports.PDFEngine(viper.GetString("Server.PDFEngine"))
+	reports.PDFCommand = viper.GetString("Server.PDFCommand")
+	var httpErrors chan error
+	if server.HasRole(server.RoleHTTP) {
+		// We listen as soon as possible so that the readiness endpoint
+		// reports the status during the startup. Only the health and
+		// metrics endpoints are served until the router is enabled.
+		httpErrors = make(chan error, 2)
+		go func() {
+			httpErrors <- runHTTPServer()
+		}()
+	}
 	server.PreInit()
 	connectToDB()
+	setupBus()
+	if err := models.EnableCacheInvalidation(); err != nil {
+		log.Panic("Unable to enable cache invalidation", "error", err)
+	}
 	models.BootStrap()
 	i18n.BootStrap()
 	server.LoadTranslations(i18n.Langs)
+	if viper.GetBool("Server.UpdateDB") {
+		updateDatabase(false)
+	}
 	server.LoadInternalResources()
 	views.BootStrap()
 	actions.BootStrap()
 	controllers.BootStrap()
 	menus.BootStrap()
 	server.PostInit()
+	models.LoadLDAPMemberships()
+	server.StartWorkers()
+	if server.HasRole(server.RoleHTTP) && viper.GetString("Server.GRPCPort") != "" {
+		go func() {
+			httpErrors <- runGRPCServer()
+		}()
+	}
+	// All routes are registered: the HTTP server can now use the router
+	server.GetServer().EnableRouter()
+	server.SetStatus(server.StatusReady)
+	log.Info("Hexya is up and running", "roles", viper.GetStringSlice("Server.Roles"))
+	waitForStopSignal(httpErrors)
+	shutdown(viper.GetDuration("Server.ShutdownTimeout"))
+	stopTracing()
+	models.DBClose()
+}
+
+// runHTTPServer runs the HTTP server according to the configuration.
+// It blocks until the HTTP server stops and returns its error.
+func runHTTPServer() error {
 	srv := server.GetServer()
 	address := fmt.Sprintf("%s:%s", viper.GetString("Server.Interface"), viper.GetString("Server.Port"))
 	cert := viper.GetString("Server.Certificate")
@@ -99,12 +166,36 @@ func StartServer(config map[string]interface{}) {
 	domain := viper.GetString("Server.Domain")
 	switch {
 	case cert != "":
-		srv.RunTLS(address, cert, key)
+		return srv.RunTLS(address, cert, key)
 	case domain != "":
-		srv.RunAutoTLS(domain)
+		return srv.RunAutoTLS(domain)
 	default:
-		srv.Run(address)
+		return srv.Run(address)
+	}
+}
+
+// grpcServer is the gRPC server started by runGRPCServer, if any
+var grpcServer *grpc.Server
+
+// runGRPCServer runs the gRPC server of model operations on the Server.GRPCPort
+// port, with the certificate of the HTTP server if any. It blocks until the
+// gRPC server stops and returns its error.
+func runGRPCServer() error {
+	address := fmt.Sprintf("%s:%s", viper.GetString("Server.Interface"), viper.GetString("Server.GRPCPort"))
+	lis, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	var opts []grpc.ServerOption
+	if cert := viper.GetString("Server.Certificate"); cert != "" {
+		creds, err := credentials.NewServerTLSFromFile(cert, viper.GetString("Server.PrivateKey"))
+		if err != nil {
+			return err
+		}
+		opts = append(opts, grpc.Creds(creds))
 	}
+	grpcServer = server.NewGRPCServer(opts...)
+	return grpcServer.Serve(lis)
 }
 
 // setupConfig takes the given config map and stores it into the viper configuration
@@ -129,6 +220,172 @@ func setupDebug() {
 	pprof.Register(server.GetServer().Engine)
 }
 
+// setupRoles sets the roles of the server process from the Server.Roles configuration key
+func setupRoles() {
+	var roles []server.Role
+	for _, role := range viper.GetStringSlice("Server.Roles") {
+		roles = append(roles, server.Role(strings.TrimSpace(role)))
+	}
+	server.SetRoles(roles...)
+}
+
+// setupQuotas sets the warning thresholds and limits of the quotas from
+// the Quotas.<name>.Warning and Quotas.<name>.Limit configuration keys
+func setupQuotas() {
+	for _, name := range models.QuotaNames() {
+		models.SetQuota(name, models.Quota{
+			Warning: viper.GetInt64(fmt.Sprintf("Quotas.%s.Warning", name)),
+			Limit:   viper.GetInt64(fmt.Sprintf("Quotas.%s.Limit", name)),
+		})
+	}
+}
+
+// setupBaseURL sets the URL of the links to records from the Server.BaseURL
+// configuration key, or from Server.Domain if it is not set.
+func setupBaseURL() {
+	baseURL := viper.GetString("Server.BaseURL")
+	if domain := viper.GetString("Server.Domain"); baseURL == "" && domain != "" {
+		baseURL = "https://" + domain
+	}
+	models.SetBaseURL(baseURL)
+}
+
+// setupSessions sets the session store of the server from the Server.SessionStore
+// configuration key. The session cookies are signed with Server.SessionSecret.
+func setupSessions() {
+	var keyPairs [][]byte
+	if secret := viper.GetString("Server.SessionSecret"); secret != "" {
+		keyPairs = append(keyPairs, []byte(secret))
+	}
+	if maxAge := viper.GetDuration("Server.SessionMaxAge"); maxAge > 0 {
+		server.SessionMaxAge = maxAge
+	}
+	switch store := viper.GetString("Server.SessionStore"); store {
+	case "cookie":
+		server.SetSessionStore(nil, keyPairs...)
+	case "memory":
+		server.SetSessionStore(server.NewMemorySessionStore(), keyPairs...)
+	case "database":
+		server.SetSessionStore(server.NewDBSessionStore(), keyPairs...)
+	case "redis":
+		opts, err := redis.ParseURL(viper.GetString("Server.RedisURL"))
+		if err != nil {
+			log.Panic("Invalid Redis URL", "url", viper.GetString("Server.RedisURL"), "error", err)
+		}
+		server.SetSessionStore(server.NewRedisSessionStore(redis.NewClient(opts), "hexya:session:"), keyPairs...)
+	default:
+		log.Panic("Unknown session store", "store", store)
+	}
+}
+
+// setupBus sets the backend through which the bus delivers messages
+// to the other processes from the Server.BusBackend configuration key.
+// The database must be connected.
+func setupBus() {
+	var backend bus.Backend
+	switch busBackend := viper.GetString("Server.BusBackend"); busBackend {
+	case "memory":
+		return
+	case "database":
+		backend = bus.NewPostgresBackend()
+	case "redis":
+		opts, err := redis.ParseURL(viper.GetString("Server.RedisURL"))
+		if err != nil {
+			log.Panic("Invalid Redis URL", "url", viper.GetString("Server.RedisURL"), "error", err)
+		}
+		backend = bus.NewRedisBackend(redis.NewClient(opts), "hexya:bus")
+	default:
+		log.Panic("Unknown bus backend", "backend", busBackend)
+	}
+	if err := bus.Registry.SetBackend(backend); err != nil {
+		log.Panic("Unable to set bus backend", "error", err)
+	}
+}
+
+// setupTracing sets the OpenTelemetry tracer provider that exports the traces
+// to the OTLP collector given by the Server.TracingEndpoint configuration key.
+// Tracing is disabled if the endpoint is empty. It returns the function that
+// flushes the remaining spans and stops the exporter.
+func setupTracing() func() {
+	endpoint := viper.GetString("Server.TracingEndpoint")
+	if endpoint == "" {
+		return func() {}
+	}
+	opts := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(endpoint)}
+	if viper.GetBool("Server.TracingInsecure") {
+		opts = append(opts, otlptracegrpc.WithInsecure())
+	}
+	exporter, err := otlptracegrpc.New(context.Background(), opts...)
+	if err != nil {
+		log.Panic("Unable to create trace exporter", "endpoint", endpoint, "error", err)
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(viper.GetFloat64("Server.TracingSampleRatio")))),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceNameKey.String("hexya"))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := provider.Shutdown(ctx); err != nil {
+			log.Warn("Error while stopping trace exporter", "error", err)
+		}
+	}
+}
+
+// waitForStopSignal blocks until the process receives an interrupt or terminate
+// signal, or until an error is received from the given HTTP server channel.
+func waitForStopSignal(httpErrors <-chan error) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	select {
+	case sig := <-sigs:
+		log.Info("Stopping Hexya", "signal", sig)
+	case err := <-httpErrors:
+		log.Error("Stopping Hexya after HTTP server failure", "error", err)
+	}
+}
+
+// shutdown gracefully stops this instance within the given timeout. The HTTP
+// and gRPC servers stop accepting connections and finish the requests in
+// progress, then the workers finish their current job. Finally, shutdown
+// waits for the transactions that are still running, such as those of
+// goroutines started by requests.
+//
+// Timeouts are logged, so that the database connections are closed anyway.
+func shutdown(timeout time.Duration) {
+	server.SetStatus(server.StatusStopping)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	grpcStopped := make(chan struct{})
+	go func() {
+		if grpcServer != nil {
+			grpcServer.GracefulStop()
+		}
+		close(grpcStopped)
+	}()
+	if err := server.GetServer().Shutdown(ctx); err != nil {
+		log.Warn("HTTP requests interrupted by shutdown", "error", err)
+	}
+	if grpcServer != nil {
+		select {
+		case <-grpcStopped:
+		case <-ctx.Done():
+			log.Warn("gRPC calls interrupted by shutdown", "error", ctx.Err())
+			grpcServer.Stop()
+		}
+	}
+	if err := server.StopWorkers(ctx); err != nil {
+		log.Warn("Workers still running after shutdown timeout", "error", err)
+	}
+	if err := models.WaitForTransactions(ctx); err != nil {
+		log.Warn("Transactions still running after shutdown timeout", "count", models.RunningTransactions(), "error", err)
+	}
+	log.Info("Hexya stopped")
+}
+
 // connectToDB creates the connection to the database
 func connectToDB() {
 	models.DBConnect(viper.GetString("DB.Driver"), models.ConnectionParams{
@@ -142,6 +399,41 @@ func connectToDB() {
 		SSLKey:   viper.GetString("DB.SSLKey"),
 		SSLCA:    viper.GetString("DB.SSLCA"),
 	})
+	models.QueryBudget = viper.GetInt("DB.QueryBudget")
+	models.SlowQueryThreshold = viper.GetDuration("DB.SlowQueryThreshold")
+	models.AllowDestructiveSchemaChanges = viper.GetBool("DB.DestructiveSync")
+	models.UnaccentSearch = viper.GetBool("DB.UnaccentSearch")
+	setupFilestore()
+}
+
+// setupFilestore sets the filestore of attachment binary fields
+// according to the Filestore configuration keys.
+func setupFilestore() {
+	switch viper.GetString("Filestore.Type") {
+	case "", "db":
+		models.DefaultFilestore = nil
+	case "local":
+		dir := viper.GetString("Filestore.Dir")
+		if dir == "" {
+			dir = filepath.Join(viper.GetString("DataDir"), "filestore", viper.GetString("DB.Name"))
+		}
+		store, err := filestore.NewLocal(dir)
+		if err != nil {
+			log.Panic("Unable to initialize local filestore", "dir", dir, "error", err)
+		}
+		models.DefaultFilestore = store
+	case "s3":
+		models.DefaultFilestore = &filestore.S3{
+			Endpoint:  viper.GetString("Filestore.S3.Endpoint"),
+			Region:    viper.GetString("Filestore.S3.Region"),
+			Bucket:    viper.GetString("Filestore.S3.Bucket"),
+			Prefix:    viper.GetString("Filestore.S3.Prefix"),
+			AccessKey: viper.GetString("Filestore.S3.AccessKey"),
+			SecretKey: viper.GetString("Filestore.S3.SecretKey"),
+		}
+	default:
+		log.Panic("Unknown filestore type", "type", viper.GetString("Filestore.Type"))
+	}
 }
 
 func init() {
@@ -157,6 +449,42 @@ func init() {
 	viper.BindPFlag("Server.Certificate", serverCmd.PersistentFlags().Lookup("certificate"))
 	serverCmd.PersistentFlags().StringP("private-key", "K", "", "Private key file for HTTPS.")
 	viper.BindPFlag("Server.PrivateKey", serverCmd.PersistentFlags().Lookup("private-key"))
+	serverCmd.PersistentFlags().StringSlice("roles", []string{"http", "cron", "jobrunner"}, "Comma separated list of roles of this process, among 'http' (serve clients), 'cron' (run scheduled actions) and 'jobrunner' (run background jobs).")
+	viper.BindPFlag("Server.Roles", serverCmd.PersistentFlags().Lookup("roles"))
+	serverCmd.PersistentFlags().Bool("update-db", false, "Synchronize the database schema and load data records at startup. When several instances start at the same time, only one of them updates the database while the others wait for it.")
+	viper.BindPFlag("Server.UpdateDB", serverCmd.PersistentFlags().Lookup("update-db"))
+	serverCmd.PersistentFlags().Duration("cron-interval", time.Minute, "Time between two checks for due cron jobs by processes with the 'cron' role.")
+	viper.BindPFlag("Server.CronInterval", serverCmd.PersistentFlags().Lookup("cron-interval"))
+	serverCmd.PersistentFlags().Int64("node-id", 0, "Number of this process between 0 and 1023, which must be unique among the processes sharing the database for snowflake ids to be unique.")
+	viper.BindPFlag("Server.NodeID", serverCmd.PersistentFlags().Lookup("node-id"))
+	serverCmd.PersistentFlags().String("base-url", "", "URL at which users reach the server (ex: https://erp.example.com), used in the links to records sent in mails and webhooks. Defaults to https://<domain> when domain is set.")
+	viper.BindPFlag("Server.BaseURL", serverCmd.PersistentFlags().Lookup("base-url"))
+	serverCmd.PersistentFlags().String("grpc-port", "", "Port on which processes with the 'http' role serve the gRPC service of model operations. The gRPC server is disabled if empty.")
+	viper.BindPFlag("Server.GRPCPort", serverCmd.PersistentFlags().Lookup("grpc-port"))
+	serverCmd.PersistentFlags().String("session-store", "database", "Store of the HTTP sessions, among 'cookie' (data in the cookie), 'memory' (single process only), 'database' and 'redis'.")
+	viper.BindPFlag("Server.SessionStore", serverCmd.PersistentFlags().Lookup("session-store"))
+	serverCmd.PersistentFlags().String("session-secret", "", "Key with which session cookies are signed. Must be set in production.")
+	viper.BindPFlag("Server.SessionSecret", serverCmd.PersistentFlags().Lookup("session-secret"))
+	serverCmd.PersistentFlags().Duration("session-max-age", 7*24*time.Hour, "Duration after which an inactive session expires.")
+	viper.BindPFlag("Server.SessionMaxAge", serverCmd.PersistentFlags().Lookup("session-max-age"))
+	serverCmd.PersistentFlags().String("bus-backend", "database", "Backend through which bus messages are delivered to all processes, among 'memory' (single process only), 'database' and 'redis'.")
+	viper.BindPFlag("Server.BusBackend", serverCmd.PersistentFlags().Lookup("bus-backend"))
+	serverCmd.PersistentFlags().String("redis-url", "redis://localhost:6379/0", "URL of the Redis server of the 'redis' session store and bus backend.")
+	viper.BindPFlag("Server.RedisURL", serverCmd.PersistentFlags().Lookup("redis-url"))
+	serverCmd.PersistentFlags().String("metrics-path", "/metrics", "Path of the endpoint exporting the metrics in the Prometheus format. The endpoint is disabled if empty.")
+	viper.BindPFlag("Server.MetricsPath", serverCmd.PersistentFlags().Lookup("metrics-path"))
+	serverCmd.PersistentFlags().String("tracing-endpoint", "", "Address (host:port) of the OTLP gRPC collector to which traces are exported. Tracing is disabled if empty.")
+	viper.BindPFlag("Server.TracingEndpoint", serverCmd.PersistentFlags().Lookup("tracing-endpoint"))
+	serverCmd.PersistentFlags().Bool("tracing-insecure", false, "Connect to the OTLP collector without TLS.")
+	viper.BindPFlag("Server.TracingInsecure", serverCmd.PersistentFlags().Lookup("tracing-insecure"))
+	serverCmd.PersistentFlags().Float64("tracing-sample-ratio", 1, "Ratio of the traces started by this server that are sampled.")
+	viper.BindPFlag("Server.TracingSampleRatio", serverCmd.PersistentFlags().Lookup("tracing-sample-ratio"))
+	serverCmd.PersistentFlags().Duration("shutdown-timeout", 30*time.Second, "Maximum time given to the requests and jobs in progress to finish when the server stops.")
+	viper.BindPFlag("Server.ShutdownTimeout", serverCmd.PersistentFlags().Lookup("shutdown-timeout"))
+	serverCmd.PersistentFlags().String("pdf-engine", "wkhtmltopdf", "Program with which reports are rendered to PDF, among 'wkhtmltopdf' and 'chromium' (headless).")
+	viper.BindPFlag("Server.PDFEngine", serverCmd.PersistentFlags().Lookup("pdf-engine"))
+	serverCmd.PersistentFlags().String("pdf-command", "", "Path of the program of the PDF engine. Defaults to the engine name, looked up in the PATH.")
+	viper.BindPFlag("Server.PDFCommand", serverCmd.PersistentFlags().Lookup("pdf-command"))
 	HexyaCmd.AddCommand(serverCmd)
 }
 
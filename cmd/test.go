@@ -0,0 +1,126 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"sync"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var testCmd = &cobra.Command{
+	Use:   "test [packages]",
+	Short: "Run the tests of the project modules",
+	Long: `Run the tests of the given module packages in parallel.
+If no package is given, the tests of all the project's modules are run.
+
+A template database is first created and synchronized with all the modules of the
+project. Each module's test suite then clones this template into its own database,
+so that the schema creation is done only once.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		packages := args
+		if len(packages) == 0 {
+			packages = viper.GetStringSlice("Modules")
+		}
+		os.Exit(runTests(".", packages))
+	},
+}
+
+// runTests creates the template database for the project in projectDir and runs
+// the tests of the given packages against it. It returns the exit code of the command.
+func runTests(projectDir string, packages []string) int {
+	templateDB := viper.GetString("Test.TemplateDB")
+	createTemplateDB(projectDir, templateDB)
+	defer dropTestDB(templateDB)
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		failed  []string
+		limiter = make(chan bool, viper.GetInt("Test.Parallel"))
+	)
+	for _, pkg := range packages {
+		wg.Add(1)
+		go func(p string) {
+			defer wg.Done()
+			limiter <- true
+			defer func() { <-limiter }()
+			out, err := runPackageTests(p, templateDB)
+			mu.Lock()
+			defer mu.Unlock()
+			fmt.Printf("=== %s\n%s", p, out)
+			if err != nil {
+				failed = append(failed, p)
+			}
+		}(pkg)
+	}
+	wg.Wait()
+	if len(failed) > 0 {
+		fmt.Printf("FAIL: %d package(s) failed: %v\n", len(failed), failed)
+		return 1
+	}
+	fmt.Println("ok: all packages passed")
+	return 0
+}
+
+// runPackageTests runs 'go test' on the given package, telling the
+// tests harness to clone its database from templateDB.
+func runPackageTests(pkg, templateDB string) ([]byte, error) {
+	cmd := exec.Command("go", "test", pkg)
+	cmd.Env = append(os.Environ(),
+		fmt.Sprintf("HEXYA_DB_TEMPLATE=%s", templateDB),
+		fmt.Sprintf("HEXYA_DB_DRIVER=%s", viper.GetString("DB.Driver")),
+		fmt.Sprintf("HEXYA_DB_USER=%s", viper.GetString("DB.User")),
+		fmt.Sprintf("HEXYA_DB_PASSWORD=%s", viper.GetString("DB.Password")),
+		fmt.Sprintf("HEXYA_DB_QUERY_BUDGET=%d", viper.GetInt("DB.QueryBudget")),
+	)
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	err := cmd.Run()
+	return out.Bytes(), err
+}
+
+// createTemplateDB creates the given template database from scratch
+// and synchronizes it with all the modules of the project.
+func createTemplateDB(projectDir, templateDB string) {
+	dropTestDB(templateDB)
+	adminDB := connectToAdminDB()
+	adminDB.MustExec(fmt.Sprintf("CREATE DATABASE %s", templateDB))
+	adminDB.Close()
+
+	dbName := viper.GetString("DB.Name")
+	viper.Set("DB.Name", templateDB)
+	defer viper.Set("DB.Name", dbName)
+	generateAndRunFile(projectDir, updateDBFileName, updateDBTemplate)
+}
+
+// dropTestDB drops the given database if it exists
+func dropTestDB(dbName string) {
+	adminDB := connectToAdminDB()
+	adminDB.MustExec(fmt.Sprintf("DROP DATABASE IF EXISTS %s", dbName))
+	adminDB.Close()
+}
+
+// connectToAdminDB returns a connection to the 'postgres' database
+// that can be used to create and drop databases.
+func connectToAdminDB() *sqlx.DB {
+	return sqlx.MustConnect(viper.GetString("DB.Driver"), fmt.Sprintf("dbname=postgres sslmode=disable user=%s password=%s",
+		viper.GetString("DB.User"), viper.GetString("DB.Password")))
+}
+
+func init() {
+	testCmd.Flags().String("template-db", "hexya_template_tests", "Name of the template database to create for the tests")
+	viper.BindPFlag("Test.TemplateDB", testCmd.Flags().Lookup("template-db"))
+	testCmd.Flags().IntP("parallel", "P", runtime.NumCPU(), "Number of packages to test in parallel")
+	viper.BindPFlag("Test.Parallel", testCmd.Flags().Lookup("parallel"))
+	HexyaCmd.AddCommand(testCmd)
+}
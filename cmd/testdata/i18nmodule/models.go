@@ -0,0 +1,24 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package i18nmodule
+
+import (
+	"github.com/hexya-erp/hexya/hexya/models"
+	"github.com/hexya-erp/hexya/hexya/models/types"
+)
+
+// T returns the given string. It stands for the translation
+// function whose arguments are extracted.
+func T(s string) string {
+	return s
+}
+
+func init() {
+	partner := models.NewModel("I18NPartner")
+	partner.AddFields(map[string]models.FieldDefinition{
+		"Name": models.CharField{String: "Partner Name", Help: "The name of the partner"},
+		"Kind": models.SelectionField{Selection: types.Selection{"person": "Person", "company": "Company"}},
+	})
+	T("Unknown partner")
+}
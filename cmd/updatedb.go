@@ -4,8 +4,10 @@
 package cmd
 
 import (
+	"strings"
 	"text/template"
 
+	"github.com/hexya-erp/hexya/hexya/i18n"
 	"github.com/hexya-erp/hexya/hexya/models"
 	"github.com/hexya-erp/hexya/hexya/server"
 	"github.com/spf13/cobra"
@@ -36,8 +38,43 @@ func UpdateDB(config map[string]interface{}) {
 	server.PreInit()
 	connectToDB()
 	models.BootStrap()
+	i18n.BootStrap()
+	server.LoadTranslations(i18n.Langs)
+	updateDatabase(true)
+}
+
+// updateDatabase runs the migrations of the modules, synchronizes the database
+// schema and loads the data records while holding the migration lock, so that
+// only one instance updates the database at a time.
+//
+// If another instance holds the lock, updateDatabase waits for it to release
+// the lock. Then, unless force is true, it returns without updating the
+// database, since the other instance has just done it. It panics if the lock
+// could not be taken before the DB.MigrationTimeout delay.
+func updateDatabase(force bool) {
+	if !models.TryMigrationLock() {
+		server.SetStatus(server.StatusWaitingMigrations)
+		timeout := viper.GetDuration("DB.MigrationTimeout")
+		log.Info("Another instance is updating the database, waiting for it", "timeout", timeout)
+		if err := models.WaitMigrationLock(timeout); err != nil {
+			log.Panic("Unable to update the database", "error", err)
+		}
+		if !force {
+			models.ReleaseMigrationLock()
+			log.Info("Database updated by another instance")
+			return
+		}
+	}
+	defer models.ReleaseMigrationLock()
+	server.SetStatus(server.StatusMigrating)
+	server.RunMigrations(models.MigrationPre)
 	models.SyncDatabase()
+	if skipped := models.SkippedSchemaChanges(); len(skipped) > 0 {
+		log.Warn("Some schema changes have not been applied, review them and apply them manually or with --db-destructive-sync",
+			"changes", strings.Join(skipped, "; "))
+	}
 	server.LoadDataRecords()
+	server.RunMigrations(models.MigrationPost)
 	if viper.GetBool("Demo") {
 		log.Info("Demo mode detected: loading demo data")
 		server.LoadDemoRecords()
@@ -23,6 +23,7 @@ import (
 	"sync"
 
 	"github.com/beevik/etree"
+	"github.com/hexya-erp/hexya/hexya/models/security"
 	"github.com/hexya-erp/hexya/hexya/models/types"
 	"github.com/hexya-erp/hexya/hexya/tools/xmlutils"
 	"github.com/hexya-erp/hexya/hexya/views"
@@ -37,6 +38,7 @@ const (
 	ActionServer      ActionType = "ir.actions.server"
 	ActionClient      ActionType = "ir.actions.client"
 	ActionCloseWindow ActionType = "ir.actions.act_window_close"
+	ActionReport      ActionType = "ir.actions.report.xml"
 )
 
 // ActionViewType defines the type of view of an action
@@ -170,6 +172,21 @@ func (ar *Collection) GetActionLinksForModel(modelName string) []*Action {
 	return ar.links[modelName]
 }
 
+// GetFirstWindowActionForModel returns the window action with the lowest id
+// among those showing the model with the given name, or nil if there is none.
+func (ar *Collection) GetFirstWindowActionForModel(modelName string) *Action {
+	var res *Action
+	for _, action := range ar.actions {
+		if action.Type != ActionActWindow || action.Model != modelName {
+			continue
+		}
+		if res == nil || action.ID < res.ID {
+			res = action
+		}
+	}
+	return res
+}
+
 // LoadFromEtree reads the action given etree.Element, creates or updates the action
 // and adds it to the given Collection if it not already.
 func (ar *Collection) LoadFromEtree(element *etree.Element) {
@@ -216,10 +233,27 @@ type Action struct {
 	Limit        int64                  `json:"limit" xml:"limit,attr"`
 	Context      *types.Context         `json:"context" xml:"context,attr"`
 	Flags        map[string]interface{} `json:"flags"`
-	Tag          string                 `json:"tag"`
+	Tag          string                 `json:"tag" xml:"tag,attr"`
+	Report       string                 `json:"report_name" xml:"report,attr"`
+	ReportFormat string                 `json:"report_type" xml:"report_format,attr"`
 	names        map[string]string
 }
 
+// IsVisibleFor returns true if this action can be shown to a user with
+// the given groups, i.e. if it has no groups or if the user belongs to
+// one of them.
+func (a Action) IsVisibleFor(userGroups map[*security.Group]security.InheritanceInfo) bool {
+	if len(a.Groups) == 0 {
+		return true
+	}
+	for _, groupID := range a.Groups {
+		if _, ok := userGroups[security.Registry.GetGroup(groupID)]; ok {
+			return true
+		}
+	}
+	return false
+}
+
 // TranslatedName returns the translated name of this action
 // in the given language
 func (a Action) TranslatedName(lang string) string {
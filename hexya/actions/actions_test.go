@@ -20,6 +20,7 @@ import (
 	"testing"
 
 	"github.com/hexya-erp/hexya/hexya/models"
+	"github.com/hexya-erp/hexya/hexya/models/security"
 	"github.com/hexya-erp/hexya/hexya/tools/xmlutils"
 	"github.com/hexya-erp/hexya/hexya/views"
 	. "github.com/smartystreets/goconvey/convey"
@@ -31,7 +32,8 @@ var actionDef1 = `
 `
 
 var actionDef2 = `
-<action id="my_action_2" name="My Second Action" model="Partner" type="ir.actions.act_window" view_mode="tree,form">
+<action id="my_action_2" name="My Second Action" model="Partner" type="ir.actions.act_window" view_mode="tree,form"
+        groups="admin, everyone">
 	<help>
 		This is the help message.
 		<strong>And this is important!</strong>
@@ -102,8 +104,15 @@ func TestActions(t *testing.T) {
 		So(userLinkedActions, ShouldHaveLength, 1)
 		tName := userLinkedActions[0].TranslatedName("fr")
 		So(tName, ShouldEqual, "My Action")
+		So(Registry.GetFirstWindowActionForModel("Partner").ID, ShouldEqual, "my_action")
+		So(Registry.GetFirstWindowActionForModel("User"), ShouldBeNil)
+		So(models.RecordURL("Partner", 3), ShouldEqual, "/web#action=my_action&id=3&model=Partner&view_type=form")
 		action2 := Registry.MustGetById("my_action_2")
 		So(action2.Help, ShouldEqual, "\n\t\tThis is the help message.\n\t\t\n\t\t<strong>And this is important!</strong>\n\t")
+		So(action2.Groups, ShouldResemble, []string{"admin", "everyone"})
+		So(action2.IsVisibleFor(map[*security.Group]security.InheritanceInfo{security.GroupAdmin: security.NativeGroup}), ShouldBeTrue)
+		So(action2.IsVisibleFor(map[*security.Group]security.InheritanceInfo{}), ShouldBeFalse)
+		So(Registry.MustGetById("my_action").IsVisibleFor(map[*security.Group]security.InheritanceInfo{}), ShouldBeTrue)
 	})
 	Convey("Testing ActionRef objects", t, func() {
 		actionRef := MakeActionRef("my_action")
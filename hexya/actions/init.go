@@ -7,6 +7,8 @@ import (
 	"strings"
 
 	"github.com/hexya-erp/hexya/hexya/i18n"
+	"github.com/hexya-erp/hexya/hexya/models"
+	"github.com/hexya-erp/hexya/hexya/models/security"
 	"github.com/hexya-erp/hexya/hexya/tools/logging"
 	"github.com/hexya-erp/hexya/hexya/views"
 )
@@ -17,6 +19,7 @@ var log *logging.Logger
 // This function must be called prior to any access to the actions Registry.
 func BootStrap() {
 	for _, a := range Registry.actions {
+		bootStrapGroups(a)
 		switch a.Type {
 		case ActionActWindow:
 			bootStrapWindowAction(a)
@@ -30,6 +33,34 @@ func BootStrap() {
 			a.names[lang] = nameTrans
 		}
 	}
+	models.SetRecordActionResolver(recordAction)
+}
+
+// recordAction returns the id of the action with which
+// records of the given model are opened by the web client.
+func recordAction(modelName string) string {
+	if action := Registry.GetFirstWindowActionForModel(modelName); action != nil {
+		return action.ID
+	}
+	return ""
+}
+
+// bootStrapGroups splits the comma separated groups of the given
+// action, as given in XML, and checks that they exist.
+func bootStrapGroups(a *Action) {
+	var groups []string
+	for _, groupsStr := range a.Groups {
+		for _, groupID := range strings.Split(groupsStr, ",") {
+			if groupID = strings.TrimSpace(groupID); groupID == "" {
+				continue
+			}
+			if security.Registry.GetGroup(groupID) == nil {
+				log.Panic("Unknown group in action", "action", a.ID, "group", groupID)
+			}
+			groups = append(groups, groupID)
+		}
+	}
+	a.Groups = groups
 }
 
 // bootStrapWindowAction makes the necessary updates to action definitions. In particular:
@@ -0,0 +1,129 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package bus
+
+import (
+	"encoding/json"
+	"sync"
+
+	"github.com/go-redis/redis"
+	"github.com/hexya-erp/hexya/hexya/models"
+)
+
+// A MemoryBackend is a Backend that delivers notifications to the
+// Buses of the same process. It is mainly useful for tests.
+type MemoryBackend struct {
+	sync.RWMutex
+	handlers []func(Notification)
+}
+
+// NewMemoryBackend returns a new MemoryBackend
+func NewMemoryBackend() *MemoryBackend {
+	return new(MemoryBackend)
+}
+
+// Publish sends the given notification to all subscribers
+func (mb *MemoryBackend) Publish(n Notification) error {
+	mb.RLock()
+	defer mb.RUnlock()
+	for _, handler := range mb.handlers {
+		handler(n)
+	}
+	return nil
+}
+
+// Subscribe registers handler to be called with all published notifications
+func (mb *MemoryBackend) Subscribe(handler func(Notification)) error {
+	mb.Lock()
+	defer mb.Unlock()
+	mb.handlers = append(mb.handlers, handler)
+	return nil
+}
+
+// decodeNotification calls handler with the notification
+// encoded in JSON in the given payload.
+func decodeNotification(payload string, handler func(Notification)) {
+	var n Notification
+	if err := json.Unmarshal([]byte(payload), &n); err != nil {
+		log.Warn("Unable to decode bus notification", "payload", payload, "error", err)
+		return
+	}
+	handler(n)
+}
+
+// PostgresChannel is the database channel on which
+// the PostgresBackend exchanges notifications.
+const PostgresChannel = "hexya_bus"
+
+// A PostgresBackend is a Backend that delivers notifications to all the
+// instances connected to the same database with LISTEN/NOTIFY.
+//
+// The JSON encoding of a notification must not be larger than
+// models.MaxNotificationPayload, so that large payloads should be
+// replaced by a reference to the data to fetch, such as a record id.
+type PostgresBackend struct{}
+
+// NewPostgresBackend returns a new PostgresBackend.
+// The database must be connected.
+func NewPostgresBackend() *PostgresBackend {
+	return new(PostgresBackend)
+}
+
+// Publish sends the given notification to all instances
+func (pb *PostgresBackend) Publish(n Notification) error {
+	data, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	return models.Notify(PostgresChannel, string(data))
+}
+
+// Subscribe registers handler to be called with the notifications
+// published by any instance, including this one.
+func (pb *PostgresBackend) Subscribe(handler func(Notification)) error {
+	return models.Listen(PostgresChannel, func(payload string) {
+		decodeNotification(payload, handler)
+	})
+}
+
+// A RedisBackend is a Backend that delivers notifications to all the
+// instances connected to the same Redis server with Pub/Sub.
+type RedisBackend struct {
+	client  *redis.Client
+	channel string
+}
+
+// NewRedisBackend returns a new RedisBackend exchanging
+// notifications on the given Redis channel.
+func NewRedisBackend(client *redis.Client, channel string) *RedisBackend {
+	return &RedisBackend{
+		client:  client,
+		channel: channel,
+	}
+}
+
+// Publish sends the given notification to all instances
+func (rb *RedisBackend) Publish(n Notification) error {
+	data, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	return rb.client.Publish(rb.channel, data).Err()
+}
+
+// Subscribe registers handler to be called with the notifications
+// published by any instance, including this one.
+func (rb *RedisBackend) Subscribe(handler func(Notification)) error {
+	pubsub := rb.client.Subscribe(rb.channel)
+	if _, err := pubsub.Receive(); err != nil {
+		pubsub.Close()
+		return err
+	}
+	go func() {
+		for msg := range pubsub.Channel() {
+			decodeNotification(msg.Payload, handler)
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,221 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+// Package bus delivers messages published on the server to the
+// clients that subscribe to their channels, so that clients can be
+// updated live without reloading.
+//
+// Messages are published with Send and kept in memory for
+// Retention. Clients get them by polling with Poll, which waits until
+// a message is available. When the application runs on several
+// instances, messages are delivered to all instances through a Backend.
+package bus
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DefaultRetention is the default duration during
+// which messages are kept for polling clients.
+const DefaultRetention = 2 * time.Minute
+
+// BroadcastChannel is the channel to which all users can subscribe
+const BroadcastChannel = "broadcast"
+
+// Registry is the Bus of this instance
+var Registry *Bus
+
+// A Notification is a message published on a channel.
+// Notifications are exchanged between instances through the Backend.
+type Notification struct {
+	Channel string          `json:"channel"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// A Message is a Notification received by this instance.
+//
+// Message IDs are increasing on a given instance, so that a client only
+// needs to send the ID of the last message it received when polling.
+type Message struct {
+	ID      int64           `json:"id"`
+	Channel string          `json:"channel"`
+	Payload json.RawMessage `json:"payload"`
+	Time    time.Time       `json:"time"`
+}
+
+// A Backend delivers notifications between the instances of the application.
+type Backend interface {
+	// Publish sends the given notification to all instances
+	Publish(n Notification) error
+	// Subscribe registers handler to be called with the notifications
+	// published by any instance, including this one.
+	Subscribe(handler func(Notification)) error
+}
+
+// An Authorizer returns true if the user uid may subscribe to the given channel
+type Authorizer func(uid int64, channel string) bool
+
+// A Bus keeps the messages of the last Retention in memory
+// and delivers them to polling clients.
+type Bus struct {
+	sync.RWMutex
+	// Retention is the duration during which
+	// messages are kept for polling clients.
+	Retention   time.Duration
+	backend     Backend
+	lastID      int64
+	messages    []Message
+	wakeup      chan struct{}
+	authorizers []Authorizer
+	now         func() time.Time
+}
+
+// NewBus returns a new Bus without Backend
+func NewBus() *Bus {
+	return &Bus{
+		Retention: DefaultRetention,
+		wakeup:    make(chan struct{}),
+		now:       time.Now,
+	}
+}
+
+// SetBackend sets the Backend through which this Bus
+// exchanges messages with other instances.
+func (b *Bus) SetBackend(backend Backend) error {
+	b.Lock()
+	b.backend = backend
+	b.Unlock()
+	return backend.Subscribe(b.receive)
+}
+
+// Send publishes the JSON encoding of the given payload on the given channel.
+//
+// Sending a message does not take part in any transaction: the message is
+// delivered immediately, even if the current transaction is rolled back later.
+func (b *Bus) Send(channel string, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	n := Notification{Channel: channel, Payload: data}
+	b.RLock()
+	backend := b.backend
+	b.RUnlock()
+	if backend == nil {
+		b.receive(n)
+		return nil
+	}
+	if err := backend.Publish(n); err != nil {
+		log.Warn("Unable to publish bus message", "channel", channel, "error", err)
+		return err
+	}
+	return nil
+}
+
+// receive stores the given notification as a new message
+// and wakes up the polling clients.
+func (b *Bus) receive(n Notification) {
+	b.Lock()
+	defer b.Unlock()
+	now := b.now()
+	b.lastID++
+	b.messages = append(b.messages, Message{
+		ID:      b.lastID,
+		Channel: n.Channel,
+		Payload: n.Payload,
+		Time:    now,
+	})
+	b.vacuum(now)
+	close(b.wakeup)
+	b.wakeup = make(chan struct{})
+}
+
+// vacuum removes the messages older than Retention.
+// The caller must hold the lock.
+func (b *Bus) vacuum(now time.Time) {
+	var i int
+	for i < len(b.messages) && now.Sub(b.messages[i].Time) > b.Retention {
+		i++
+	}
+	b.messages = b.messages[i:]
+}
+
+// LastID returns the ID of the last message received by this instance.
+// Clients should start polling from this ID.
+func (b *Bus) LastID() int64 {
+	b.RLock()
+	defer b.RUnlock()
+	return b.lastID
+}
+
+// Poll returns the messages of the given channels with an ID greater than
+// last. If there is no such message, Poll waits until one is received, the
+// timeout expires or ctx is done, in which case it returns an empty slice.
+func (b *Bus) Poll(ctx context.Context, channels []string, last int64, timeout time.Duration) []Message {
+	chans := make(map[string]bool)
+	for _, channel := range channels {
+		chans[channel] = true
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		b.RLock()
+		var res []Message
+		for _, msg := range b.messages {
+			if msg.ID > last && chans[msg.Channel] {
+				res = append(res, msg)
+			}
+		}
+		wakeup := b.wakeup
+		b.RUnlock()
+		if len(res) > 0 {
+			return res
+		}
+		select {
+		case <-wakeup:
+		case <-timer.C:
+			return []Message{}
+		case <-ctx.Done():
+			return []Message{}
+		}
+	}
+}
+
+// AddAuthorizer adds an Authorizer to this Bus. A user may subscribe
+// to a channel if any Authorizer returns true. Users may always subscribe
+// to BroadcastChannel and to their own UserChannel.
+func (b *Bus) AddAuthorizer(authorizer Authorizer) {
+	b.Lock()
+	defer b.Unlock()
+	b.authorizers = append(b.authorizers, authorizer)
+}
+
+// CanSubscribe returns true if the user uid may subscribe to the given channel
+func (b *Bus) CanSubscribe(uid int64, channel string) bool {
+	if channel == BroadcastChannel || channel == UserChannel(uid) {
+		return true
+	}
+	b.RLock()
+	defer b.RUnlock()
+	for _, authorizer := range b.authorizers {
+		if authorizer(uid, channel) {
+			return true
+		}
+	}
+	return false
+}
+
+// Send publishes the JSON encoding of the given payload
+// on the given channel of the Registry Bus.
+func Send(channel string, payload interface{}) error {
+	return Registry.Send(channel, payload)
+}
+
+// UserChannel returns the private channel of the user with the given uid
+func UserChannel(uid int64) string {
+	return fmt.Sprintf("user,%d", uid)
+}
@@ -0,0 +1,76 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package bus
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestBus(t *testing.T) {
+	Convey("Testing the bus", t, func() {
+		now := time.Date(2017, 6, 1, 10, 0, 0, 0, time.UTC)
+		clock := func() time.Time { return now }
+		backend := NewMemoryBackend()
+		bus1, bus2 := NewBus(), NewBus()
+		bus1.now, bus2.now = clock, clock
+		So(bus1.SetBackend(backend), ShouldBeNil)
+		So(bus2.SetBackend(backend), ShouldBeNil)
+		ctx := context.Background()
+		Convey("Messages should be delivered to all instances", func() {
+			So(bus1.Send(UserChannel(1), map[string]int64{"id": 5}), ShouldBeNil)
+			So(bus1.Send(UserChannel(2), "hello"), ShouldBeNil)
+			So(bus2.LastID(), ShouldEqual, 2)
+			msgs := bus2.Poll(ctx, []string{UserChannel(1), BroadcastChannel}, 0, time.Second)
+			So(msgs, ShouldResemble, []Message{
+				{ID: 1, Channel: "user,1", Payload: json.RawMessage(`{"id":5}`), Time: now},
+			})
+			So(bus2.Poll(ctx, []string{UserChannel(2)}, 1, time.Second), ShouldHaveLength, 1)
+		})
+		Convey("Polling should wait for new messages", func() {
+			go func() {
+				time.Sleep(10 * time.Millisecond)
+				bus1.Send(BroadcastChannel, "ping")
+			}()
+			msgs := bus2.Poll(ctx, []string{BroadcastChannel}, bus2.LastID(), 5*time.Second)
+			So(msgs, ShouldHaveLength, 1)
+			So(string(msgs[0].Payload), ShouldEqual, `"ping"`)
+		})
+		Convey("Polling should return no message after timeout", func() {
+			bus1.Send(UserChannel(2), "hello")
+			So(bus1.Poll(ctx, []string{UserChannel(1)}, 0, 10*time.Millisecond), ShouldBeEmpty)
+			cancelled, cancel := context.WithCancel(ctx)
+			cancel()
+			So(bus1.Poll(cancelled, []string{UserChannel(1)}, 0, time.Minute), ShouldBeEmpty)
+		})
+		Convey("Old messages should be removed", func() {
+			bus1.Send(BroadcastChannel, 1)
+			now = now.Add(DefaultRetention + time.Second)
+			bus1.Send(BroadcastChannel, 2)
+			So(bus1.messages, ShouldHaveLength, 1)
+			So(bus1.messages[0].ID, ShouldEqual, 2)
+		})
+		Convey("Buses without backend should deliver messages locally", func() {
+			bus := NewBus()
+			So(bus.Send(BroadcastChannel, true), ShouldBeNil)
+			So(bus.Poll(ctx, []string{BroadcastChannel}, 0, time.Second), ShouldHaveLength, 1)
+		})
+		Convey("Subscriptions should be authorized", func() {
+			So(bus1.CanSubscribe(1, UserChannel(1)), ShouldBeTrue)
+			So(bus1.CanSubscribe(1, BroadcastChannel), ShouldBeTrue)
+			So(bus1.CanSubscribe(1, UserChannel(2)), ShouldBeFalse)
+			So(bus1.CanSubscribe(1, "Partner,5"), ShouldBeFalse)
+			bus1.AddAuthorizer(func(uid int64, channel string) bool {
+				return strings.HasPrefix(channel, "Partner,")
+			})
+			So(bus1.CanSubscribe(1, "Partner,5"), ShouldBeTrue)
+			So(bus2.CanSubscribe(1, "Partner,5"), ShouldBeFalse)
+		})
+	})
+}
@@ -6,6 +6,7 @@ package menus
 import (
 	"github.com/hexya-erp/hexya/hexya/actions"
 	"github.com/hexya-erp/hexya/hexya/i18n"
+	"github.com/hexya-erp/hexya/hexya/models/security"
 	"github.com/hexya-erp/hexya/hexya/tools/logging"
 )
 
@@ -22,6 +23,13 @@ func BootStrap() {
 			}
 			menu.Parent = parentMenu
 		}
+		for _, groupID := range menu.GroupIDs {
+			group := security.Registry.GetGroup(groupID)
+			if group == nil {
+				log.Panic("Unknown group in menu", "menu", menu.ID, "group", groupID)
+			}
+			menu.Groups = append(menu.Groups, group)
+		}
 		var noName bool
 		if menu.ActionID != "" {
 			menu.Action = actions.Registry.MustGetById(menu.ActionID)
@@ -17,10 +17,12 @@ package menus
 import (
 	"sort"
 	"strconv"
+	"strings"
 	"sync"
 
 	"github.com/beevik/etree"
 	"github.com/hexya-erp/hexya/hexya/actions"
+	"github.com/hexya-erp/hexya/hexya/models/security"
 )
 
 // Registry is the menu Collection of the application
@@ -45,6 +47,9 @@ func (mc *Collection) Swap(i, j int) {
 }
 
 func (mc *Collection) Less(i, j int) bool {
+	if mc.Menus[i].Sequence == mc.Menus[j].Sequence {
+		return mc.Menus[i].ID < mc.Menus[j].ID
+	}
 	return mc.Menus[i].Sequence < mc.Menus[j].Sequence
 }
 
@@ -78,6 +83,46 @@ func (mc *Collection) GetByID(id string) *Menu {
 	return mc.menusMap[id]
 }
 
+// A MenuData is a menu item as sent to the web client
+type MenuData struct {
+	ID       string     `json:"id"`
+	Name     string     `json:"name"`
+	Sequence uint8      `json:"sequence"`
+	Action   string     `json:"action,omitempty"`
+	Children []MenuData `json:"children"`
+}
+
+// UserTree returns the tree of the menus of this Collection that are visible
+// to a user with the given groups, with their names in the given language.
+//
+// A menu is visible if it has no groups or if the user belongs to one of
+// them, and if its action is visible to the user. Menus without action are
+// only visible if at least one of their children is visible.
+func (mc *Collection) UserTree(lang string, userGroups map[*security.Group]security.InheritanceInfo) []MenuData {
+	res := make([]MenuData, 0)
+	for _, menu := range mc.Menus {
+		if !menu.visibleFor(userGroups) {
+			continue
+		}
+		data := MenuData{
+			ID:       menu.ID,
+			Name:     menu.TranslatedName(lang),
+			Sequence: menu.Sequence,
+			Children: make([]MenuData, 0),
+		}
+		if menu.Children != nil {
+			data.Children = menu.Children.UserTree(lang, userGroups)
+		}
+		if menu.Action != nil {
+			data.Action = menu.Action.ID
+		} else if len(data.Children) == 0 {
+			continue
+		}
+		res = append(res, data)
+	}
+	return res
+}
+
 // NewCollection returns a pointer to a new
 // Collection instance
 func NewCollection() *Collection {
@@ -98,11 +143,30 @@ type Menu struct {
 	Sequence         uint8
 	ActionID         string
 	Action           *actions.Action
+	GroupIDs         []string
+	Groups           []*security.Group
 	HasChildren      bool
 	HasAction        bool
 	names            map[string]string
 }
 
+// visibleFor returns true if this menu and its action are
+// visible to a user with the given groups.
+func (m *Menu) visibleFor(userGroups map[*security.Group]security.InheritanceInfo) bool {
+	if m.Action != nil && !m.Action.IsVisibleFor(userGroups) {
+		return false
+	}
+	if len(m.Groups) == 0 {
+		return true
+	}
+	for _, group := range m.Groups {
+		if _, ok := userGroups[group]; ok {
+			return true
+		}
+	}
+	return false
+}
+
 // TranslatedName returns the translated name of this menu
 // in the given language
 func (m Menu) TranslatedName(lang string) string {
@@ -130,6 +194,11 @@ func AddMenuToMapFromEtree(element *etree.Element, mMap map[string]*Menu) map[st
 		ParentID: element.SelectAttrValue("parent", ""),
 		Sequence: uint8(seq),
 	}
+	for _, groupID := range strings.Split(element.SelectAttrValue("groups", ""), ",") {
+		if groupID = strings.TrimSpace(groupID); groupID != "" {
+			menu.GroupIDs = append(menu.GroupIDs, groupID)
+		}
+	}
 	mMap[menu.ID] = &menu
 	return mMap
 }
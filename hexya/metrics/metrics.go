@@ -0,0 +1,101 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+// Package metrics holds the Prometheus metrics of the application.
+//
+// Metrics are updated by the packages they measure, such as models for the
+// ORM metrics and server for the HTTP metrics, and exported by the server on
+// its metrics endpoint. Modules can register their own collectors in Registry.
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Namespace is the namespace of the metrics of the application
+const Namespace = "hexya"
+
+// Registry is the Prometheus registry of the metrics exported by the server
+var Registry = prometheus.NewRegistry()
+
+var (
+	// SQLQueries counts the SQL queries by model of the method that executed them
+	SQLQueries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Subsystem: "orm",
+		Name:      "sql_queries_total",
+		Help:      "Number of SQL queries by model of the method that executed them.",
+	}, []string{"model"})
+	// SQLQueryDuration measures the execution time of the SQL queries
+	// by model of the method that executed them
+	SQLQueryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: Namespace,
+		Subsystem: "orm",
+		Name:      "sql_query_duration_seconds",
+		Help:      "Execution time of the SQL queries by model of the method that executed them.",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+	}, []string{"model"})
+	// CacheLookups counts the lookups in the caches of the ORM by cache
+	// ("records", "computed" or "query") and result ("hit" or "miss")
+	CacheLookups = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Subsystem: "orm",
+		Name:      "cache_lookups_total",
+		Help:      "Number of lookups in the caches of the ORM by cache and result.",
+	}, []string{"cache", "result"})
+	// CacheEvictions counts the records evicted from the
+	// caches of the environments because they were full
+	CacheEvictions = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Subsystem: "orm",
+		Name:      "cache_evictions_total",
+		Help:      "Number of records evicted from the caches of the environments.",
+	})
+	// TransactionDuration measures the duration of the transactions by
+	// result ("commit", "rollback" or "error")
+	TransactionDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: Namespace,
+		Subsystem: "orm",
+		Name:      "transaction_duration_seconds",
+		Help:      "Duration of the transactions by result.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"result"})
+	// HTTPRequests counts the HTTP requests by method, route and status code
+	HTTPRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Subsystem: "http",
+		Name:      "requests_total",
+		Help:      "Number of HTTP requests by method, route and status code.",
+	}, []string{"method", "route", "status"})
+	// HTTPRequestDuration measures the duration of the HTTP requests by method and route
+	HTTPRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: Namespace,
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "Duration of the HTTP requests by method and route.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+)
+
+func init() {
+	Registry.MustRegister(
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		SQLQueries,
+		SQLQueryDuration,
+		CacheLookups,
+		CacheEvictions,
+		TransactionDuration,
+		HTTPRequests,
+		HTTPRequestDuration,
+	)
+}
+
+// Handler returns the HTTP handler that exports the metrics of Registry
+// in the Prometheus format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
@@ -0,0 +1,28 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMetrics(t *testing.T) {
+	Convey("Testing metrics export", t, func() {
+		SQLQueries.WithLabelValues("Partner").Inc()
+		CacheLookups.WithLabelValues("records", "hit").Inc()
+		HTTPRequests.WithLabelValues("GET", "/web/report/:id/:format", "200").Inc()
+		rec := httptest.NewRecorder()
+		Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		So(rec.Code, ShouldEqual, http.StatusOK)
+		body := rec.Body.String()
+		So(body, ShouldContainSubstring, `hexya_orm_sql_queries_total{model="Partner"} 1`)
+		So(body, ShouldContainSubstring, `hexya_orm_cache_lookups_total{cache="records",result="hit"} 1`)
+		So(body, ShouldContainSubstring, `hexya_http_requests_total{method="GET",route="/web/report/:id/:format",status="200"} 1`)
+		So(body, ShouldContainSubstring, "go_goroutines")
+	})
+}
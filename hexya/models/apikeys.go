@@ -0,0 +1,186 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/hexya-erp/hexya/hexya/models/security"
+	"github.com/hexya-erp/hexya/hexya/models/types/dates"
+)
+
+// APIKeyPrefix is the prefix of all API keys, so that
+// they can be recognized, e.g. by secret scanners.
+const APIKeyPrefix = "hxk_"
+
+// APIKeyLastUsedPrecision is the precision of the last use date of API keys,
+// so that the key record is not written at each request.
+var APIKeyLastUsedPrecision = time.Minute
+
+// APIScopes restrict the models and methods that can be called with an API
+// key. Each scope is either:
+//   - "*" to allow all methods of all models,
+//   - a model name, such as "Partner", to allow all methods of this model,
+//   - a model and method name, such as "Partner.Read", to allow this method.
+//
+// Method names are matched case insensitively and regardless of underscores,
+// so that "Partner.SearchRead" also allows the search_read method of the
+// external API. Empty APIScopes allow everything.
+type APIScopes []string
+
+// Allows returns true if these scopes allow calling the given method of the given model
+func (s APIScopes) Allows(model, method string) bool {
+	if len(s) == 0 {
+		return true
+	}
+	for _, scope := range s {
+		scopeModel, scopeMethod := scope, ""
+		if i := strings.Index(scope, "."); i >= 0 {
+			scopeModel, scopeMethod = scope[:i], scope[i+1:]
+		}
+		switch {
+		case scopeModel == "*":
+			return true
+		case scopeModel != model:
+			continue
+		case scopeMethod == "" || normalizeAPIMethod(scopeMethod) == normalizeAPIMethod(method):
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeAPIMethod returns the given method name in lower case and without underscores
+func normalizeAPIMethod(method string) string {
+	return strings.ToLower(strings.Replace(method, "_", "", -1))
+}
+
+// declareAPIKeyModel creates the APIKey model.
+//
+// API keys authenticate integrations as a user without their password. Only
+// a prefix and the SHA-256 hash of each key are stored: the key itself is
+// only given back once, by CreateAPIKey. Keys can be restricted to APIScopes
+// and can expire. A key is revoked by archiving it.
+//
+// API keys can only be accessed by the admin: users manage their own keys
+// with the APIKeys, CreateAPIKey and RevokeAPIKey methods of Environment.
+func declareAPIKeyModel() {
+	apiKey := NewModel("APIKey")
+	apiKey.AddFields(map[string]FieldDefinition{
+		"Name":   CharField{Required: true, Help: "Description of the integration using this key"},
+		"UserID": IntegerField{String: "User ID", Required: true, Index: true},
+		"Prefix": CharField{Required: true, Unique: true, NoCopy: true,
+			Help: "First characters of the key, by which it is looked up"},
+		"KeyHash": CharField{Required: true, NoCopy: true, Help: "SHA-256 hash of the key"},
+		"Scopes": CharField{
+			Help: "Comma separated list of the models and methods that can be called with this key. All if empty."},
+		"ExpirationDate": DateTimeField{Index: true, Help: "The key never expires if empty"},
+		"LastUsed":       DateTimeField{NoCopy: true},
+		"Active":         BooleanField{Default: DefaultValue(true)},
+	})
+	apiKey.SetDefaultOrder("ID desc")
+}
+
+// CreateAPIKey creates an API key for the user of this Environment and
+// returns it. The key is restricted to the given scopes, if any, and expires
+// at the given expiration date, unless it is zero.
+//
+// The returned key is not stored and cannot be retrieved afterwards.
+func (env Environment) CreateAPIKey(name string, expiration dates.DateTime, scopes ...string) string {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		log.Panic("Unable to generate API key", "error", err)
+	}
+	secret := hex.EncodeToString(buf)
+	key := APIKeyPrefix + secret
+	env.Pool("APIKey").Sudo().Call("Create", FieldMap{
+		"Name":           name,
+		"UserID":         env.uid,
+		"Prefix":         secret[:16],
+		"KeyHash":        hashAPIKey(key),
+		"Scopes":         strings.Join(scopes, ","),
+		"ExpirationDate": expiration,
+	})
+	return key
+}
+
+// APIKeys returns the active API keys of the user of this
+// Environment, as superuser, so that they can be read.
+func (env Environment) APIKeys() *RecordCollection {
+	keys := env.Pool("APIKey").Sudo()
+	return keys.Search(keys.model.Field("UserID").Equals(env.uid).And().Field("Active").Equals(true))
+}
+
+// RevokeAPIKey revokes the API key with the given id. Only the admin
+// can revoke the keys of other users.
+func (env Environment) RevokeAPIKey(id int64) {
+	keys := env.Pool("APIKey").Sudo()
+	key := keys.Search(keys.model.Field("ID").Equals(id))
+	if key.IsEmpty() {
+		log.Panic("Unknown API key", "id", id)
+	}
+	if key.Get("UserID").(int64) != env.uid && env.uid != security.SuperUserID && !env.User().HasGroup(security.GroupAdmin) {
+		log.Panic("You are not allowed to revoke this API key", "id", id, "uid", env.uid)
+	}
+	key.Call("Write", FieldMap{"Active": false})
+}
+
+// CheckAPIKey returns the id of the user of the given API key and
+// the scopes of the key. It returns false if the key is invalid,
+// revoked or expired. The last use date of the key is updated.
+func (env Environment) CheckAPIKey(key string) (int64, APIScopes, bool) {
+	secret := strings.TrimPrefix(key, APIKeyPrefix)
+	if secret == key || len(secret) < 16 {
+		return 0, nil, false
+	}
+	keys := env.Pool("APIKey").Sudo()
+	rec := keys.Search(keys.model.Field("Prefix").Equals(secret[:16]).And().Field("Active").Equals(true))
+	if rec.IsEmpty() {
+		return 0, nil, false
+	}
+	if subtle.ConstantTimeCompare([]byte(hashAPIKey(key)), []byte(rec.Get("KeyHash").(string))) != 1 {
+		return 0, nil, false
+	}
+	now := dates.Now()
+	if expiration := rec.Get("ExpirationDate").(dates.DateTime); !expiration.IsZero() && expiration.Before(now.Time) {
+		return 0, nil, false
+	}
+	if lastUsed := rec.Get("LastUsed").(dates.DateTime); lastUsed.IsZero() || now.Sub(lastUsed.Time) >= APIKeyLastUsedPrecision {
+		rec.Call("Write", FieldMap{"LastUsed": now})
+	}
+	var scopes APIScopes
+	if s := rec.Get("Scopes").(string); s != "" {
+		for _, scope := range strings.Split(s, ",") {
+			scopes = append(scopes, strings.TrimSpace(scope))
+		}
+	}
+	return rec.Get("UserID").(int64), scopes, true
+}
+
+// hashAPIKey returns the hash under which the given API key is stored
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// WithAPIScopes returns a copy of this Environment in which only the model
+// methods allowed by the given scopes can be called through the external
+// APIs, i.e. CallRPC and ExecuteKW.
+func (env Environment) WithAPIScopes(scopes APIScopes) Environment {
+	env.scopes = scopes
+	return env
+}
+
+// checkAPIScopes panics if the scopes of this Environment do
+// not allow calling the given method of the given model.
+func (env Environment) checkAPIScopes(model, method string) {
+	if !env.scopes.Allows(model, method) {
+		log.Panic("This API key does not allow calling this method", "model", model, "method", method, "uid", env.uid)
+	}
+}
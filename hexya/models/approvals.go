@@ -0,0 +1,318 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hexya-erp/hexya/hexya/models/security"
+	"github.com/hexya-erp/hexya/hexya/models/types"
+	"github.com/hexya-erp/hexya/hexya/models/types/dates"
+	"github.com/hexya-erp/hexya/hexya/tools/nbutils"
+)
+
+// Approval states
+const (
+	approvalPending  = "pending"
+	approvalAccepted = "accepted"
+	approvalRefused  = "refused"
+)
+
+// An ApprovalRule declares an operation on the records of a model that
+// requires the approval of a member of one of the given groups.
+//
+// The operation is performed by setting Field to Value, typically a
+// transition of a state selection field.
+type ApprovalRule struct {
+	// Operation is the name of the operation, unique within the model
+	Operation string
+	// Field is the field which is set by the operation
+	Field FieldNamer
+	// Value is the value of Field after the operation
+	Value interface{}
+	// Groups are the groups whose members can approve the operation.
+	// Members of the admin group can approve all operations.
+	Groups []*security.Group
+	// AmountField is an optional numeric field of the model. If set,
+	// approval is only required if its value is at least Threshold.
+	AmountField FieldNamer
+	Threshold   float64
+}
+
+// AddApprovalRule adds the given rule to this model, which
+// must inherit ApprovalMixin. It panics if the rule is invalid
+// or if the model has already a rule for the same operation.
+func (m *Model) AddApprovalRule(rule ApprovalRule) {
+	if rule.Operation == "" || rule.Field == nil || len(rule.Groups) == 0 {
+		log.Panic("Approval rules must have an operation, a field and groups", "model", m.name, "rule", rule)
+	}
+	for _, r := range m.approvalRules {
+		if r.Operation == rule.Operation {
+			log.Panic("Approval rule already exists for this operation", "model", m.name, "operation", rule.Operation)
+		}
+	}
+	m.approvalRules = append(m.approvalRules, rule)
+}
+
+// approvalRule returns the approval rule of this model for the
+// given operation. It panics if there is no such rule.
+func (m *Model) approvalRule(operation string) ApprovalRule {
+	for _, rule := range m.approvalRules {
+		if rule.Operation == operation {
+			return rule
+		}
+	}
+	log.Panic("Unknown approval operation", "model", m.name, "operation", operation)
+	return ApprovalRule{}
+}
+
+// groupIDs returns the comma separated IDs of the groups of this rule
+func (rule ApprovalRule) groupIDs() string {
+	ids := make([]string, len(rule.Groups))
+	for i, group := range rule.Groups {
+		ids[i] = group.ID
+	}
+	return strings.Join(ids, ",")
+}
+
+// declareApprovalModels creates the Approval model and the ApprovalMixin.
+//
+// Models that inherit ApprovalMixin can declare operations requiring approval
+// with AddApprovalRule. Creating or writing records with the field of such an
+// operation set to its value panics until an Approval of the operation for the
+// record has been accepted by a member of one of the groups of the rule.
+func declareApprovalModels() {
+	approval := NewModel("Approval")
+	approval.AddFields(map[string]FieldDefinition{
+		"ResModel":  CharField{String: "Resource Model", Required: true, Index: true},
+		"ResID":     IntegerField{String: "Resource ID", BigInt: true, Required: true, Index: true},
+		"Operation": CharField{Required: true},
+		"State": SelectionField{Required: true, Default: DefaultValue(approvalPending), Index: true,
+			Selection: types.Selection{approvalPending: "Pending", approvalAccepted: "Accepted", approvalRefused: "Refused"}},
+		"ApproverGroups": CharField{Help: "Comma separated IDs of the groups whose members can decide"},
+		"Amount": FloatField{
+			Help: "Amount of the record when approval was requested. The approval is not valid for higher amounts."},
+		"RequestUID":   IntegerField{String: "Requested By"},
+		"DecisionUID":  IntegerField{String: "Decided By"},
+		"DecisionDate": DateTimeField{},
+		"Reason":       TextField{Help: "Reason of the refusal"},
+	})
+	approval.SetDefaultOrder("ID desc")
+
+	approval.AddMethod("CanDecide",
+		`CanDecide returns true if the current user can accept or refuse this approval.`,
+		func(rc *RecordCollection) bool {
+			rc.EnsureOne()
+			user := rc.env.User()
+			if user.ID() == security.SuperUserID || user.HasGroup(security.GroupAdmin) {
+				return true
+			}
+			for _, groupID := range strings.Split(rc.Get("ApproverGroups").(string), ",") {
+				group := security.Registry.GetGroup(groupID)
+				if group != nil && user.HasGroup(group) {
+					return true
+				}
+			}
+			return false
+		}).AllowGroup(security.GroupEveryone)
+
+	approval.AddMethod("Accept",
+		`Accept accepts these pending approvals.`,
+		func(rc *RecordCollection) {
+			rc.decideApprovals(approvalAccepted, "")
+		}).AllowGroup(security.GroupEveryone)
+
+	approval.AddMethod("Refuse",
+		`Refuse refuses these pending approvals for the given reason.`,
+		func(rc *RecordCollection, reason string) {
+			rc.decideApprovals(approvalRefused, reason)
+		}).AllowGroup(security.GroupEveryone)
+
+	approval.AddMethod("Inbox",
+		`Inbox returns the pending approvals that the current user can decide.`,
+		func(rc *RecordCollection) *RecordCollection {
+			pending := rc.Search(approval.Field("State").Equals(approvalPending)).Fetch()
+			var ids []int64
+			for _, rec := range pending.Records() {
+				if rec.Call("CanDecide").(bool) {
+					ids = append(ids, rec.ids[0])
+				}
+			}
+			return rc.env.Pool(approval.name).withIds(ids)
+		}).AllowGroup(security.GroupEveryone)
+
+	for _, method := range []string{"Load", "Read"} {
+		approval.methods.MustGet(method).AllowGroup(security.GroupEveryone)
+	}
+
+	approvalMixin := NewMixinModel("ApprovalMixin")
+
+	approvalMixin.AddMethod("Approvals",
+		`Approvals returns the approvals of the given operation for the records
+		of this RecordSet, the most recent first.`,
+		func(rc *RecordCollection, operation string) *RecordCollection {
+			approvals := rc.env.Pool(approval.name)
+			return approvals.Search(approval.Field("ResModel").Equals(rc.model.name).
+				And().Field("ResID").In(rc.Ids()).
+				And().Field("Operation").Equals(operation))
+		}).AllowGroup(security.GroupEveryone)
+
+	approvalMixin.AddMethod("ApprovalState",
+		`ApprovalState returns the state of the last approval of the given operation
+		for this record: "pending", "accepted", "refused", or an empty string if no
+		approval has been requested.`,
+		func(rc *RecordCollection, operation string) string {
+			rc.EnsureOne()
+			last := rc.Call("Approvals", operation).(RecordSet).Collection().Limit(1)
+			if last.IsEmpty() {
+				return ""
+			}
+			return last.Get("State").(string)
+		}).AllowGroup(security.GroupEveryone)
+
+	approvalMixin.AddMethod("RequestApproval",
+		`RequestApproval requests the approval of the given operation for the
+		records of this RecordSet that need it, and returns the pending approvals.
+		No new approval is requested for records that have a pending approval or
+		a valid accepted approval.`,
+		func(rc *RecordCollection, operation string) *RecordCollection {
+			rule := rc.model.approvalRule(operation)
+			approvals := rc.env.Pool(approval.name).Sudo()
+			var ids []int64
+			for _, rec := range rc.Records() {
+				amount := rec.approvalAmount(rule, nil)
+				if !rule.requiresApproval(amount) || rec.hasValidApproval(rule, amount) {
+					continue
+				}
+				existing := rec.Call("Approvals", operation).(RecordSet).Collection().Sudo().
+					Search(approval.Field("State").Equals(approvalPending)).Limit(1)
+				if existing.IsEmpty() {
+					existing = approvals.Call("Create", FieldMap{
+						"ResModel":       rc.model.name,
+						"ResID":          rec.ids[0],
+						"Operation":      operation,
+						"ApproverGroups": rule.groupIDs(),
+						"Amount":         amount,
+						"RequestUID":     rc.env.uid,
+					}).(RecordSet).Collection()
+				}
+				ids = append(ids, existing.Ids()[0])
+			}
+			return rc.env.Pool(approval.name).withIds(ids)
+		}).AllowGroup(security.GroupEveryone)
+
+	approvalMixin.AddMethod("Create",
+		`Create panics if data performs an operation requiring approval, since
+		new records cannot have been approved.`,
+		func(rc *RecordCollection, data FieldMapper) *RecordCollection {
+			fMap := data.FieldMap()
+			for _, rule := range rc.model.approvalRules {
+				if !rule.performedBy(fMap, rc.model) {
+					continue
+				}
+				if rule.requiresApproval(rc.approvalAmount(rule, fMap)) {
+					log.Panic("Approval required: create the record first, then request approval",
+						"model", rc.model.name, "operation", rule.Operation)
+				}
+			}
+			return rc.Super().Call("Create", fMap).(RecordSet).Collection()
+		})
+
+	approvalMixin.AddMethod("Write",
+		`Write panics if data performs an operation requiring approval on
+		records without a valid accepted approval of this operation.`,
+		func(rc *RecordCollection, data FieldMapper) bool {
+			fMap := data.FieldMap()
+			for _, rule := range rc.model.approvalRules {
+				if !rule.performedBy(fMap, rc.model) {
+					continue
+				}
+				for _, rec := range rc.Records() {
+					if approvalValuesEqual(rec.Get(string(rule.Field.FieldName())), rule.Value) {
+						// Not a transition
+						continue
+					}
+					amount := rec.approvalAmount(rule, fMap)
+					if rule.requiresApproval(amount) && !rec.hasValidApproval(rule, amount) {
+						log.Panic("Approval required: request approval first",
+							"model", rc.model.name, "id", rec.ids[0], "operation", rule.Operation)
+					}
+				}
+			}
+			return rc.Super().Call("Write", fMap).(bool)
+		})
+}
+
+// decideApprovals sets the state of the pending approvals of this RecordCollection
+// to the given state. It panics if the current user cannot decide one of them.
+func (rc *RecordCollection) decideApprovals(state, reason string) {
+	for _, rec := range rc.Records() {
+		if rec.Get("State") != approvalPending {
+			log.Panic("Approval has already been decided", "id", rec.ids[0], "state", rec.Get("State"))
+		}
+		if !rec.Call("CanDecide").(bool) {
+			log.Panic("You are not allowed to decide this approval", "id", rec.ids[0], "uid", rc.env.uid)
+		}
+	}
+	rc.Sudo().Call("Write", FieldMap{
+		"State":        state,
+		"Reason":       reason,
+		"DecisionUID":  rc.env.uid,
+		"DecisionDate": dates.Now(),
+	})
+}
+
+// performedBy returns true if the given FieldMap
+// performs the operation of this rule.
+func (rule ApprovalRule) performedBy(fMap FieldMap, model *Model) bool {
+	val, ok := fMap.Get(string(rule.Field.FieldName()), model)
+	return ok && approvalValuesEqual(val, rule.Value)
+}
+
+// requiresApproval returns true if the operation of this
+// rule requires approval for a record with the given amount.
+func (rule ApprovalRule) requiresApproval(amount float64) bool {
+	return rule.AmountField == nil || amount >= rule.Threshold
+}
+
+// approvalValuesEqual returns true if the given field values
+// are equal, regardless of their numeric type.
+func approvalValuesEqual(val1, val2 interface{}) bool {
+	return fmt.Sprint(val1) == fmt.Sprint(val2)
+}
+
+// approvalAmount returns the amount of the given rule for this
+// record, taken from fMap if it is set there. It returns 0 if
+// the rule has no amount field.
+func (rc *RecordCollection) approvalAmount(rule ApprovalRule, fMap FieldMap) float64 {
+	if rule.AmountField == nil {
+		return 0
+	}
+	fieldName := string(rule.AmountField.FieldName())
+	val, ok := fMap.Get(fieldName, rc.model)
+	if !ok {
+		if rc.IsEmpty() {
+			return 0
+		}
+		val = rc.Get(fieldName)
+	}
+	amount, err := nbutils.CastToFloat(val)
+	if err != nil {
+		log.Panic("Approval amount field must be numeric", "model", rc.model.name, "field", fieldName, "value", val)
+	}
+	return amount
+}
+
+// hasValidApproval returns true if this record has an accepted approval of the
+// operation of the given rule for an amount greater or equal to the given amount.
+func (rc *RecordCollection) hasValidApproval(rule ApprovalRule, amount float64) bool {
+	approvals := rc.env.Pool("Approval").Sudo()
+	approvalModel := approvals.model
+	return !approvals.Search(approvalModel.Field("ResModel").Equals(rc.model.name).
+		And().Field("ResID").Equals(rc.ids[0]).
+		And().Field("Operation").Equals(rule.Operation).
+		And().Field("State").Equals(approvalAccepted).
+		And().Field("Amount").GreaterOrEqual(amount)).IsEmpty()
+}
@@ -0,0 +1,223 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/hexya-erp/hexya/hexya/models/security"
+)
+
+// declareAttachmentModel creates the Attachment model which stores
+// files linked to any record through its model name and id.
+//
+// Access to an attachment is granted by the linked record: reading an
+// attachment requires read access to the linked record, and creating,
+// modifying or deleting it requires write access to the linked record.
+// Attachments that are not linked to any record are only accessible
+// by the user who created them.
+func declareAttachmentModel() {
+	attachment := NewModel("Attachment")
+	attachment.AddFields(map[string]FieldDefinition{
+		"Name": CharField{Required: true},
+		"ResModel": CharField{String: "Resource Model", Index: true,
+			Help: "Name of the model of the record this attachment is linked to."},
+		"ResID": IntegerField{String: "Resource ID", BigInt: true, Index: true,
+			Help: "ID of the record this attachment is linked to."},
+		"ResField": CharField{String: "Resource Field",
+			Help: "Name of the field of the linked record this attachment holds, if any."},
+		"Datas":    BinaryField{String: "Content", Attachment: true},
+		"MimeType": CharField{String: "Mime Type"},
+		"FileSize": IntegerField{String: "File Size", Help: "Size of the content in bytes."},
+	})
+
+	attachment.AddMethod("Create",
+		`Create sets the mime type and the size of the attachment from its content
+		and checks that the user can write on the linked record.`,
+		func(rc *RecordCollection, data FieldMapper) *RecordCollection {
+			fMap := data.FieldMap()
+			name, _ := fMap.Get("Name", rc.model)
+			setAttachmentMetadata(rc, fMap, name)
+			res := rc.Super().Call("Create", fMap).(RecordSet).Collection()
+			checkAttachmentAccess(res, "Write")
+			return res
+		})
+
+	attachment.AddMethod("Read",
+		`Read checks that the user can read the linked records before reading the attachments.`,
+		func(rc *RecordCollection, fields []string) []FieldMap {
+			checkAttachmentAccess(rc, "Load")
+			return rc.Super().Call("Read", fields).([]FieldMap)
+		})
+
+	attachment.AddMethod("Write",
+		`Write updates the mime type and the size of the attachments if their content
+		is modified and checks that the user can write on the linked records, before
+		and after the modification.`,
+		func(rc *RecordCollection, data FieldMapper, fieldsToUnset ...FieldNamer) bool {
+			checkAttachmentAccess(rc, "Write")
+			fMap := data.FieldMap()
+			name, ok := fMap.Get("Name", rc.model)
+			if !ok && rc.Len() == 1 {
+				name = rc.Get("Name")
+			}
+			setAttachmentMetadata(rc, fMap, name)
+			args := []interface{}{fMap}
+			for _, f := range fieldsToUnset {
+				args = append(args, f)
+			}
+			res := rc.Super().Call("Write", args...).(bool)
+			checkAttachmentAccess(rc, "Write")
+			return res
+		})
+
+	attachment.AddMethod("Unlink",
+		`Unlink checks that the user can write on the linked records before deleting the attachments.`,
+		func(rc *RecordCollection) int64 {
+			checkAttachmentAccess(rc, "Write")
+			return rc.Super().Call("Unlink").(int64)
+		})
+
+	attachment.AddMethod("GarbageCollect",
+		`GarbageCollect deletes the attachments linked to records that do not exist
+		anymore and returns the number of deleted attachments.
+
+		The contents of the deleted attachments are not removed from the filestore
+		by this method. Call GarbageCollectFilestore to remove them.`,
+		func(rc *RecordCollection) int64 {
+			return garbageCollectAttachments(rc.Sudo())
+		})
+
+	for _, method := range []string{"Create", "Read", "Load", "Write", "Unlink"} {
+		attachment.methods.MustGet(method).AllowGroup(security.GroupEveryone)
+	}
+}
+
+// setAttachmentMetadata sets the FileSize and MimeType fields in fMap
+// from the content of the Datas field if it is given.
+//
+// The mime type is guessed from the extension of name first, and from
+// the content if the extension is unknown. It is not modified if a
+// mime type is given in fMap.
+func setAttachmentMetadata(rc *RecordCollection, fMap FieldMap, name interface{}) {
+	value, ok := fMap.Get("Datas", rc.model)
+	if !ok {
+		return
+	}
+	var reader io.Reader
+	switch v := value.(type) {
+	case binaryChecksum:
+		rCloser := rc.openChecksum(rc.model.fields.MustGet("Datas"), string(v))
+		defer rCloser.Close()
+		reader = rCloser
+	case string:
+		reader = base64.NewDecoder(base64.StdEncoding, strings.NewReader(v))
+	default:
+		return
+	}
+	head := make([]byte, 512)
+	n, err := io.ReadFull(reader, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		log.Panic("Unable to read attachment content", "error", err)
+	}
+	rest, err := io.Copy(ioutil.Discard, reader)
+	if err != nil {
+		log.Panic("Unable to read attachment content", "error", err)
+	}
+	fMap.Set("FileSize", int64(n)+rest, rc.model)
+	if _, given := fMap.Get("MimeType", rc.model); given || n == 0 {
+		return
+	}
+	nameStr, _ := name.(string)
+	mimeType := mime.TypeByExtension(filepath.Ext(nameStr))
+	if mimeType == "" {
+		mimeType = http.DetectContentType(head[:n])
+	}
+	fMap.Set("MimeType", mimeType, rc.model)
+}
+
+// checkAttachmentAccess panics if the user of the given attachments is not
+// allowed to execute the given method on the records they are linked to.
+func checkAttachmentAccess(rc *RecordCollection, method string) {
+	if rc.env.uid == security.SuperUserID || rc.IsEmpty() {
+		return
+	}
+	linked := make(map[string][]int64)
+	for _, att := range rc.Sudo().Records() {
+		resModel := att.Get("ResModel").(string)
+		if resModel == "" {
+			if att.Get("CreateUID").(int64) != rc.env.uid {
+				log.Panic("You are not allowed to access this attachment", "id", att.ids[0], "uid", rc.env.uid)
+			}
+			continue
+		}
+		if _, exists := linked[resModel]; !exists {
+			linked[resModel] = nil
+		}
+		if resID := att.Get("ResID").(int64); resID != 0 {
+			linked[resModel] = append(linked[resModel], resID)
+		}
+	}
+	var checks []PermissionCheck
+	for resModel, ids := range linked {
+		checks = append(checks, PermissionCheck{Model: resModel, Method: method, IDs: ids})
+	}
+	for _, res := range rc.env.CheckPermissions(checks) {
+		if !res.Allowed {
+			log.Panic("You are not allowed to access the records linked to these attachments",
+				"model", res.Model, "method", res.Method, "ids", res.DeniedIDs, "uid", rc.env.uid)
+		}
+	}
+}
+
+// garbageCollectAttachments deletes the attachments that are linked to
+// records that do not exist anymore and returns the number of deleted
+// attachments. rc must be a superuser RecordCollection of Attachment.
+func garbageCollectAttachments(rc *RecordCollection) int64 {
+	var orphans []int64
+	attModel := Registry.MustGet("Attachment")
+	linked := make(map[string][]int64)
+	var links []struct {
+		ID       int64  `db:"id"`
+		ResModel string `db:"res_model"`
+		ResID    int64  `db:"res_id"`
+	}
+	query := fmt.Sprintf(`SELECT id, res_model, res_id FROM %s WHERE res_model IS NOT NULL AND res_model != '' AND res_id != 0`,
+		adapters[db.DriverName()].quoteTableName(attModel.tableName))
+	rc.env.cr.Select(&links, query)
+	for _, link := range links {
+		linked[link.ResModel] = append(linked[link.ResModel], link.ResID)
+	}
+	existing := make(map[string]map[int64]bool)
+	for resModel, ids := range linked {
+		existing[resModel] = make(map[int64]bool)
+		model, ok := Registry.Get(resModel)
+		if !ok || model.isMixin() {
+			continue
+		}
+		// We query the table directly so that archived records are kept
+		var existingIDs []int64
+		query := fmt.Sprintf(`SELECT id FROM %s WHERE id IN (?)`, adapters[db.DriverName()].quoteTableName(model.tableName))
+		rc.env.cr.Select(&existingIDs, query, ids)
+		for _, id := range existingIDs {
+			existing[resModel][id] = true
+		}
+	}
+	for _, link := range links {
+		if !existing[link.ResModel][link.ResID] {
+			orphans = append(orphans, link.ID)
+		}
+	}
+	if len(orphans) == 0 {
+		return 0
+	}
+	return rc.withIds(orphans).Call("Unlink").(int64)
+}
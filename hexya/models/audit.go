@@ -0,0 +1,181 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/hexya-erp/hexya/hexya/models/fieldtype"
+	"github.com/hexya-erp/hexya/hexya/models/types"
+	"github.com/hexya-erp/hexya/hexya/models/types/dates"
+)
+
+// Audit log operations
+const (
+	auditCreate = "create"
+	auditWrite  = "write"
+	auditUnlink = "unlink"
+)
+
+// auditLogModel is the handle of the AuditLog model
+var auditLogModel = NewModelHandle("AuditLog")
+
+// EnableAudit logs all the changes of the records of this model in the
+// AuditLog model: each creation, modification and deletion of a record
+// creates an AuditLog entry with the user, the date and the old and new
+// values of the changed fields.
+//
+// Binary fields and the fields that are set automatically, such as
+// WriteDate, are not logged.
+func (m *Model) EnableAudit() {
+	if m.name == "AuditLog" || m.isMixin() {
+		log.Panic("Audit cannot be enabled on this model", "model", m.name)
+	}
+	m.options |= AuditedModel
+}
+
+// declareAuditLogModel creates the AuditLog model, which stores
+// the changes of the records of audited models.
+//
+// Audit log entries are immutable. By default, they can only be read by
+// the admin. Other groups can be allowed to read them by granting them
+// the Load and Read methods of the model.
+func declareAuditLogModel() {
+	auditLog := NewImmutableModel("AuditLog")
+	auditLog.AddFields(map[string]FieldDefinition{
+		"ResModel": CharField{String: "Resource Model", Required: true, Index: true,
+			Help: "Name of the model of the changed record"},
+		"ResID": IntegerField{String: "Resource ID", BigInt: true, Required: true, Index: true,
+			Help: "ID of the changed record"},
+		"Operation": SelectionField{Required: true,
+			Selection: types.Selection{auditCreate: "Creation", auditWrite: "Modification", auditUnlink: "Deletion"}},
+		"UserID": IntegerField{String: "User ID", Required: true, Help: "ID of the user who made the change"},
+		"Date": DateTimeField{Required: true, Index: true,
+			Default: func(env Environment) interface{} { return dates.Now() }},
+		"Changes": TextField{Help: "JSON list of the old and new values of the changed fields"},
+	})
+	auditLog.SetDefaultOrder("Date desc", "ID desc")
+
+	auditLog.AddMethod("FieldDiffs",
+		`FieldDiffs returns the old and new values of the fields changed by
+		this audit log entry, sorted by field name. Relation fields values are
+		given as slices of ids.`,
+		func(rc *RecordCollection) []FieldDiff {
+			rc.EnsureOne()
+			var res []FieldDiff
+			if changes, _ := rc.Get("Changes").(string); changes != "" {
+				if err := json.Unmarshal([]byte(changes), &res); err != nil {
+					log.Panic("Invalid audit log changes", "id", rc.ids[0], "error", err)
+				}
+			}
+			return res
+		})
+}
+
+// AuditTrail returns the AuditLog entries of the records of this
+// RecordCollection, the most recent first.
+func (rc *RecordCollection) AuditTrail() *RecordCollection {
+	logs := auditLogModel.Pool(rc.env)
+	return logs.Search(logs.Model().Field("ResModel").Equals(rc.model.name).
+		And().Field("ResID").In(rc.Ids()))
+}
+
+// An auditSnapshot holds the values of the audited
+// fields of records, by record id and field name.
+type auditSnapshot map[int64]map[string]interface{}
+
+// auditFields returns the audited fields of this RecordCollection's
+// model that are in fMap, or all the audited fields if fMap is nil.
+// It returns nil if the model is not audited.
+func (rc *RecordCollection) auditFields(fMap FieldMap) []*Field {
+	if !rc.model.isAudited() {
+		return nil
+	}
+	var res []*Field
+	for _, fi := range rc.model.fields.registryByName {
+		if !fi.isStored() || diffIgnoredFields[fi.name] || fi.fieldType == fieldtype.Binary {
+			continue
+		}
+		if _, ok := fMap.Get(fi.name, rc.model); fMap != nil && !ok {
+			continue
+		}
+		res = append(res, fi)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].name < res[j].name
+	})
+	return res
+}
+
+// auditValues returns the current values of the given fields
+// for all the records of this RecordCollection.
+func (rc *RecordCollection) auditValues(fields []*Field) auditSnapshot {
+	if len(fields) == 0 {
+		return nil
+	}
+	res := make(auditSnapshot)
+	for _, rec := range rc.Records() {
+		values := make(map[string]interface{})
+		for _, fi := range fields {
+			values[fi.name] = auditValue(rec.Get(fi.name))
+		}
+		res[rec.ids[0]] = values
+	}
+	return res
+}
+
+// auditValue returns the given field value as it is stored in the audit log
+func auditValue(value interface{}) interface{} {
+	if rs, ok := value.(RecordSet); ok {
+		ids := rs.Ids()
+		if ids == nil {
+			ids = []int64{}
+		}
+		return ids
+	}
+	return value
+}
+
+// logAudit creates the AuditLog entries of the given operation on the given
+// fields of the records of this RecordCollection. old holds the values of the
+// fields before the operation. New values are read from the records, except
+// for deleted records.
+func (rc *RecordCollection) logAudit(operation string, fields []*Field, old auditSnapshot) {
+	if len(fields) == 0 {
+		return
+	}
+	logs := auditLogModel.Pool(rc.env).Sudo()
+	for _, id := range rc.Ids() {
+		rec := rc.env.Pool(rc.model.name).withIds([]int64{id})
+		var diffs []FieldDiff
+		for _, fi := range fields {
+			var oldVal, newVal interface{}
+			if old != nil {
+				oldVal = old[id][fi.name]
+			}
+			if operation != auditUnlink {
+				newVal = auditValue(rec.Get(fi.name))
+			}
+			if operation == auditWrite && diffValuesEqual(oldVal, newVal) {
+				continue
+			}
+			diffs = append(diffs, FieldDiff{Field: fi.name, Old: oldVal, New: newVal})
+		}
+		if operation == auditWrite && len(diffs) == 0 {
+			continue
+		}
+		changes, err := json.Marshal(diffs)
+		if err != nil {
+			log.Panic("Unable to encode audit log changes", "model", rc.model.name, "id", id, "error", err)
+		}
+		logs.Call("Create", FieldMap{
+			"ResModel":  rc.model.name,
+			"ResID":     id,
+			"Operation": operation,
+			"UserID":    rc.env.uid,
+			"Changes":   string(changes),
+		})
+	}
+}
@@ -0,0 +1,261 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hexya-erp/hexya/hexya/models/fieldtype"
+	"github.com/hexya-erp/hexya/hexya/models/security"
+	"github.com/hexya-erp/hexya/hexya/models/types"
+	"github.com/hexya-erp/hexya/hexya/models/types/dates"
+	"github.com/hexya-erp/hexya/hexya/tools/expr"
+)
+
+// Automation rule triggers
+const (
+	automationOnCreate      = "create"
+	automationOnWrite       = "write"
+	automationOnCreateWrite = "create_write"
+	automationOnTime        = "time"
+)
+
+// automationContextKey is the context key holding the ids of the automation
+// rules being run, so that their actions do not trigger them again.
+const automationContextKey = "automation_rule_ids"
+
+// automationRuleModel is the handle of the AutomationRule model,
+// which is searched at each creation or update of records.
+var automationRuleModel = NewModelHandle("AutomationRule")
+
+// declareAutomationRuleModel creates the AutomationRule model.
+//
+// An AutomationRule runs server actions on the records of a model when they
+// are created or updated, or when a date of the records is reached. Rules on
+// time are run by ProcessAutomationRules, which is called periodically by the
+// cron worker of the server.
+//
+// Automation rules can only be accessed by the admin. Their actions are run
+// as the superuser.
+func declareAutomationRuleModel() {
+	rule := NewModel("AutomationRule")
+
+	rule.AddMethod("CheckRule",
+		`CheckRule checks that the model of this rule exists and
+		that the parameters of its trigger are valid.`,
+		func(rc *RecordCollection) {
+			model, ok := Registry.Get(rc.Get("Model").(string))
+			if !ok {
+				log.Panic("Unknown model in automation rule", "rule", rc.Get("Name"), "model", rc.Get("Model"))
+			}
+			for _, field := range rc.automationWatchedFields() {
+				model.fields.MustGet(field)
+			}
+			if rc.Get("Trigger").(string) == automationOnTime {
+				fi, ok := model.fields.Get(rc.Get("DateField").(string))
+				if !ok || (fi.fieldType != fieldtype.Date && fi.fieldType != fieldtype.DateTime) || !fi.isStored() {
+					log.Panic("Time based automation rules need a stored date field", "rule", rc.Get("Name"),
+						"model", model.name, "field", rc.Get("DateField"))
+				}
+			}
+			if filter := strings.TrimSpace(rc.Get("Filter").(string)); filter != "" {
+				if _, err := expr.Parse(filter); err != nil {
+					log.Panic("Invalid filter in automation rule", "rule", rc.Get("Name"), "error", err)
+				}
+			}
+		})
+
+	rule.AddFields(map[string]FieldDefinition{
+		"Name":  CharField{Required: true},
+		"Model": CharField{Required: true, Index: true, Constraint: rule.methods.MustGet("CheckRule")},
+		"Trigger": SelectionField{Required: true, Default: DefaultValue(automationOnCreateWrite),
+			Constraint: rule.methods.MustGet("CheckRule"),
+			Selection: types.Selection{
+				automationOnCreate:      "On Creation",
+				automationOnWrite:       "On Update",
+				automationOnCreateWrite: "On Creation & Update",
+				automationOnTime:        "Based on Date Field",
+			}},
+		"Filter": TextField{Constraint: rule.methods.MustGet("CheckRule"),
+			Help: "Expression evaluated on each record. Actions are only run on the records for which it is true."},
+		"WatchedFields": CharField{Constraint: rule.methods.MustGet("CheckRule"),
+			Help: "Comma separated list of fields. If set, the rule is only triggered on update when one of them is written."},
+		"DateField": CharField{Constraint: rule.methods.MustGet("CheckRule"),
+			Help: "Date or DateTime field of the records which triggers time based rules"},
+		"DelayCount": IntegerField{String: "Delay",
+			Help: "Delay after the date of the records at which time based rules are triggered. Negative for a delay before the date."},
+		"DelayUnit": SelectionField{Default: DefaultValue("days"),
+			Selection: types.Selection{"minutes": "Minutes", "hours": "Hours", "days": "Days"}},
+		"LastRun": DateTimeField{String: "Last Run", NoCopy: true,
+			Help: "Date up to which time based rules have been triggered"},
+		"Actions": Many2ManyField{String: "Server Actions", RelationModel: Registry.MustGet("ServerAction"),
+			Help: "Actions run on the records, in sequence order"},
+		"Active":   BooleanField{Default: DefaultValue(true)},
+		"Sequence": IntegerField{Default: DefaultValue(int64(10))},
+	})
+	rule.SetDefaultOrder("Sequence", "ID")
+
+	rule.AddMethod("RunTimed",
+		`RunTimed runs the actions of this time based rule on the records whose
+		date plus the delay of the rule has been reached since its last run,
+		and updates its last run date.`,
+		func(rc *RecordCollection) {
+			rc.EnsureOne()
+			if rc.Get("Trigger").(string) != automationOnTime {
+				log.Panic("Automation rule is not time based", "rule", rc.Get("Name"))
+			}
+			now := dates.Now()
+			lastRun := rc.Get("LastRun").(dates.DateTime)
+			if lastRun.IsZero() {
+				lastRun = rc.Get("CreateDate").(dates.DateTime)
+			}
+			delay := rc.automationDelay()
+			records := rc.env.Pool(rc.Get("Model").(string))
+			dateField := rc.Get("DateField").(string)
+			records = records.Search(records.model.Field(dateField).LowerOrEqual(now.Add(-delay)).
+				And().Field(dateField).Greater(lastRun.Add(-delay)))
+			rc.runAutomationRule(records)
+			rc.Call("Write", FieldMap{"LastRun": now})
+		})
+
+	OnRecordCreated("", automationHook(automationOnCreate))
+	OnRecordWritten("", automationHook(automationOnWrite))
+}
+
+// automationHook returns the RecordHook that runs the automation
+// rules triggered by the given event, automationOnCreate or
+// automationOnWrite.
+func automationHook(event string) RecordHook {
+	return func(rc *RecordCollection, fields []string) {
+		if rc.model.isSystem() || rc.model.name == "AutomationRule" || rc.model.name == "ServerAction" {
+			return
+		}
+		rules := automationRuleModel.Pool(rc.env).Sudo()
+		found := rules.Search(rules.Model().Field("Model").Equals(rc.model.name).
+			And().Field("Trigger").In([]string{event, automationOnCreateWrite}).
+			And().Field("Active").Equals(true))
+		for _, r := range found.Records() {
+			if r.automationRunning() || (event == automationOnWrite && !r.automationWatches(fields)) {
+				continue
+			}
+			r.runAutomationRule(rc)
+		}
+	}
+}
+
+// automationWatchedFields returns the names of the watched fields of this rule
+func (rc *RecordCollection) automationWatchedFields() []string {
+	var res []string
+	for _, f := range strings.Split(rc.Get("WatchedFields").(string), ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			res = append(res, f)
+		}
+	}
+	return res
+}
+
+// automationWatches returns true if this rule must be triggered when the
+// given fields are updated. Rules without watched fields watch all fields.
+func (rc *RecordCollection) automationWatches(fields []string) bool {
+	watched := rc.automationWatchedFields()
+	if len(watched) == 0 {
+		return true
+	}
+	model := Registry.MustGet(rc.Get("Model").(string))
+	for _, w := range watched {
+		wName := model.fields.MustGet(w).name
+		for _, f := range fields {
+			if f == wName {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// automationRunning returns true if the actions of this rule are being
+// run, i.e. if the current operation has been made by one of them.
+func (rc *RecordCollection) automationRunning() bool {
+	for _, id := range rc.env.context.GetIntegerSlice(automationContextKey) {
+		if id == rc.ids[0] {
+			return true
+		}
+	}
+	return false
+}
+
+// automationDelay returns the delay of this time based rule
+func (rc *RecordCollection) automationDelay() time.Duration {
+	unit := time.Hour * 24
+	switch rc.Get("DelayUnit").(string) {
+	case "minutes":
+		unit = time.Minute
+	case "hours":
+		unit = time.Hour
+	}
+	return time.Duration(rc.Get("DelayCount").(int64)) * unit
+}
+
+// runAutomationRule runs the actions of this rule on the given records
+// for which the filter of the rule is true.
+//
+// Actions are run with the id of the rule in the context, so that
+// the operations they make do not trigger the rule again.
+func (rc *RecordCollection) runAutomationRule(records *RecordCollection) {
+	records = records.Sudo()
+	if filter := strings.TrimSpace(rc.Get("Filter").(string)); filter != "" {
+		var ids []int64
+		for _, rec := range records.Records() {
+			if rec.EvaluateCondition(filter) {
+				ids = append(ids, rec.ids[0])
+			}
+		}
+		records = records.withIds(ids)
+	}
+	if records.IsEmpty() {
+		return
+	}
+	running := append(rc.env.context.GetIntegerSlice(automationContextKey), rc.ids[0])
+	actions := rc.Get("Actions").(RecordSet).Collection().Sudo().WithContext(automationContextKey, running)
+	for _, action := range actions.Records() {
+		action.Call("Run", records)
+	}
+}
+
+// ProcessAutomationRules runs all the active time based automation rules
+// and returns the number of rules that have been run.
+//
+// Each rule runs in its own transaction, in which it is first claimed with
+// a row lock that other transactions skip, so that this function can be
+// called concurrently by several server processes. Rules that fail are
+// rolled back and their error is logged. No rule is started once
+// StopProcessing has been called.
+func ProcessAutomationRules() int {
+	ruleModel := Registry.MustGet("AutomationRule")
+	table := adapters[db.DriverName()].quoteTableName(ruleModel.tableName)
+	var ids []int64
+	dbSelectNoTx(&ids, fmt.Sprintf(`SELECT id FROM %s WHERE active = TRUE AND trigger = ? ORDER BY sequence, id`, table),
+		automationOnTime)
+	var count int
+	for _, id := range ids {
+		if ProcessingStopped() {
+			break
+		}
+		err := ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+			var claimed []int64
+			env.cr.Select(&claimed, fmt.Sprintf(`SELECT id FROM %s WHERE id = ? FOR UPDATE SKIP LOCKED`, table), id)
+			if len(claimed) == 0 {
+				return
+			}
+			env.Pool(ruleModel.name).withIds(claimed).Call("RunTimed")
+			count++
+		})
+		if err != nil {
+			log.Warn("Automation rule failed", "id", id, "error", err)
+		}
+	}
+	return count
+}
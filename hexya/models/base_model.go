@@ -18,6 +18,7 @@ import (
 	"database/sql"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/hexya-erp/hexya/hexya/i18n"
 	"github.com/hexya-erp/hexya/hexya/models/fieldtype"
@@ -42,9 +43,21 @@ const (
 	ManualModel
 	// SystemModel is a model that is used internally by the Hexya Framework
 	SystemModel
+	// ImmutableModel is a model whose records cannot be modified
+	// nor deleted once created, such as audit logs.
+	ImmutableModel
+	// HashChainedModel is an immutable model whose records are chained
+	// by a hash of their values to detect tampering in the database.
+	HashChainedModel
+	// AuditedModel is a model whose record changes are logged
+	// in the AuditLog model.
+	AuditedModel
+	// FuzzySearchModel is a model whose records can be searched
+	// by similarity of their record name with NameSearchFuzzy.
+	FuzzySearchModel
 )
 
-//  declareCommonMixin creates the common mixin that is needed for all models
+// declareCommonMixin creates the common mixin that is needed for all models
 func declareCommonMixin() {
 	NewMixinModel("CommonMixin")
 	declareCRUDMethods()
@@ -52,6 +65,13 @@ func declareCommonMixin() {
 	declareRecordSetSpecificMethods()
 	declareSearchMethods()
 	declareEnvironmentMethods()
+	// These methods only make sense inside the server
+	commonMixin := Registry.MustGet("CommonMixin")
+	for _, meth := range []string{"Browse", "Cached", "CartesianProduct", "Collate", "Equals", "Fetch", "Filtered",
+		"Intersect", "Limit", "Load", "Offset", "OrderBy", "OrderByRank", "OrderBySimilarity", "Sorted",
+		"SortedByField", "SortedDefault", "Subtract", "Sudo", "Union", "WithContext", "WithEnv", "WithNewContext"} {
+		commonMixin.methods.MustGet(meth).SetPrivate(true)
+	}
 }
 
 // declareBaseMixin creates the mixin that implements all the necessary base methods of a model
@@ -85,6 +105,20 @@ func declareModelMixin() {
 	modelMixin.InheritModel(Registry.MustGet("BaseMixin"))
 }
 
+// declareModelDataModel creates the ModelData system model which maps
+// module qualified external IDs to the records they reference.
+func declareModelDataModel() {
+	modelData := createModel("ModelData", SystemModel)
+	modelData.InheritModel(Registry.MustGet("CommonMixin"))
+	modelData.AddFields(map[string]FieldDefinition{
+		"Name":  CharField{String: "External ID", Required: true, Unique: true, Index: true},
+		"Model": CharField{Required: true},
+		"ResID": IntegerField{String: "Record ID", BigInt: true, Required: true},
+		"NoUpdate": BooleanField{String: "Non Updatable",
+			Help: "If set, the referenced record is not updated when data files are loaded again."},
+	})
+}
+
 // declareComputeMethods declares methods used to compute fields
 func declareBaseComputeMethods() {
 	model := Registry.MustGet("BaseMixin")
@@ -162,7 +196,9 @@ func declareCRUDMethods() {
 		})
 
 	commonMixin.AddMethod("Copy",
-		`Copy duplicates the given record
+		`Copy duplicates the given record, including its One2many children.
+		Fields with the NoCopy attribute are not copied, and unique char fields
+		get a " (copy)" suffix unless a value is given in overrides.
 		It panics if rs is not a singleton`,
 		func(rc *RecordCollection, overrides FieldMapper, fieldsToUnset ...FieldNamer) *RecordCollection {
 			rc.EnsureOne()
@@ -182,10 +218,32 @@ func declareCRUDMethods() {
 
 			fMap := rc.env.cache.getRecord(rc.Model(), rc.Get("id").(int64))
 			fMap.RemovePK()
+			// Unique char fields get a suffix so that the copy does not violate the constraint
+			for _, fi := range rc.model.fields.registryByName {
+				if !fi.unique || fi.fieldType != fieldtype.Char {
+					continue
+				}
+				if val, ok := fMap[fi.json].(string); ok && val != "" {
+					fMap[fi.json] = fmt.Sprintf("%s (copy)", val)
+				}
+			}
 			fMap.MergeWith(overrides.FieldMap(fieldsToUnset...), rc.model)
 			// Reload original record to prevent cache discrepancies
 			rc.Load()
 			newRs := rc.WithContext("hexya_force_compute_write", true).Call("Create", fMap).(RecordSet).Collection()
+
+			// Copy One2many children and make them point to the new record
+			for _, fi := range rc.model.fields.registryByName {
+				if fi.noCopy || fi.fieldType != fieldtype.One2Many || fi.isComputedField() || fi.isRelatedField() || fi.filter != nil {
+					continue
+				}
+				if _, overridden := fMap[fi.json]; overridden {
+					continue
+				}
+				for _, child := range rc.Get(fi.name).(RecordSet).Collection().Records() {
+					child.Call("Copy", FieldMap{fi.jsonReverseFK: newRs.ids[0]})
+				}
+			}
 			return newRs
 		})
 
@@ -196,22 +254,31 @@ func declareRecordSetMethods() {
 	commonMixin := Registry.MustGet("CommonMixin")
 
 	commonMixin.AddMethod("NameGet",
-		`NameGet retrieves the human readable name of this record.`,
+		`NameGet retrieves the human readable name of this record.
+		It is built from the values of the model's record name fields,
+		or from the record's string representation if there are none.`,
 		func(rc *RecordCollection) string {
-			if _, nameExists := rc.model.fields.Get("Name"); nameExists {
-				if !rc.env.cache.checkIfInCache(rc.model, rc.ids, []string{"Name"}) {
-					rc.Load("Name")
-				}
-				switch name := rc.Get("Name").(type) {
+			nameFields := rc.model.RecordNameFields()
+			if len(nameFields) == 0 {
+				return rc.String()
+			}
+			if !rc.env.cache.checkIfInCache(rc.model, rc.ids, nameFields) {
+				rc.Load(nameFields...)
+			}
+			var names []string
+			for _, fName := range nameFields {
+				switch name := rc.Get(fName).(type) {
 				case string:
-					return name
+					if name != "" {
+						names = append(names, name)
+					}
 				case fmt.Stringer:
-					return name.String()
+					names = append(names, name.String())
 				default:
-					log.Panic("Name field is neither a string nor a fmt.Stringer", "model", rc.model)
+					log.Panic("Record name field is neither a string nor a fmt.Stringer", "model", rc.model, "field", fName)
 				}
 			}
-			return rc.String()
+			return strings.Join(names, " ")
 		}).AllowGroup(security.GroupEveryone)
 
 	commonMixin.AddMethod("SearchByName",
@@ -219,6 +286,8 @@ func declareRecordSetMethods() {
 		"name" pattern when compared with the given "op" operator, while also
 		matching the optional search condition ("additionalCond").
 
+		The search is performed on the model's record name fields.
+
 		This is used for example to provide suggestions based on a partial
 		value for a relational field. Sometimes be seen as the inverse
 		function of NameGet but it is not guaranteed to be.`,
@@ -226,7 +295,15 @@ func declareRecordSetMethods() {
 			if op == "" {
 				op = operator.IContains
 			}
-			cond := rc.Model().Field("Name").AddOperator(op, name)
+			nameFields := rc.model.RecordNameFields()
+			if len(nameFields) == 0 {
+				// No record name field to search on
+				return newRecordCollection(rc.Env(), rc.model)
+			}
+			cond := rc.Model().Field(nameFields[0]).AddOperator(op, name)
+			for _, fName := range nameFields[1:] {
+				cond = cond.Or().Field(fName).AddOperator(op, name)
+			}
 			if !additionalCond.Underlying().IsEmpty() {
 				cond = cond.AndCond(additionalCond.Underlying())
 			}
@@ -234,10 +311,32 @@ func declareRecordSetMethods() {
 
 		})
 
+	commonMixin.AddMethod("NameSearch",
+		`NameSearch searches for records whose record name fields match the given
+		"name" pattern when compared with the given "op" operator.
+		At most "limit" records are returned, 0 meaning no limit.
+
+		It is a shortcut for SearchByName without additional condition.`,
+		func(rc *RecordCollection, name string, op operator.Operator, limit int) *RecordCollection {
+			return rc.Call("SearchByName", name, op, newCondition(), limit).(RecordSet).Collection()
+		}).AllowGroup(security.GroupEveryone)
+
+	commonMixin.AddMethod("NameSearchFuzzy",
+		`NameSearchFuzzy searches for records whose record name fields are similar
+		to the given "name", even with typos, and returns them ordered by decreasing
+		similarity. At most "limit" records are returned, 0 meaning no limit.
+
+		Fuzzy search must have been enabled on the model with EnableFuzzySearch.`,
+		func(rc *RecordCollection, name string, limit int) *RecordCollection {
+			return rc.nameSearchFuzzy(name, limit)
+		}).AllowGroup(security.GroupEveryone)
+
 	commonMixin.AddMethod("FieldsGet",
 		`FieldsGet returns the definition of each field.
 		The embedded fields are included.
 		The string, help, and selection (if present) attributes are translated.
+		The readable and writable attributes tell whether the current user can
+		read and write each field. Read only fields are never writable.
 
 		The result map is indexed by the fields JSON names.`,
 		func(rc *RecordCollection, args FieldsGetArgs) map[string]*FieldInfo {
@@ -251,12 +350,19 @@ func declareRecordSetMethods() {
 			res := rc.model.FieldsGet(fields...)
 
 			// Translate attributes when required
-			lang := rc.Env().Context().GetString("lang")
+			lang := rc.Env().Context().Lang()
 			for fieldName, fInfo := range res {
 				res[fieldName].Help = i18n.Registry.TranslateFieldHelp(lang, rc.model.name, fieldName, fInfo.Help)
 				res[fieldName].String = i18n.Registry.TranslateFieldDescription(lang, rc.model.name, fieldName, fInfo.String)
 				res[fieldName].Selection = i18n.Registry.TranslateFieldSelection(lang, rc.model.name, fieldName, fInfo.Selection)
 			}
+
+			// Add the access rights of the user
+			for fieldName, fInfo := range res {
+				fi := rc.model.fields.MustGet(fieldName)
+				fInfo.Readable = checkFieldPermission(fi, *rc.env, security.Read)
+				fInfo.Writable = !fInfo.ReadOnly && checkFieldPermission(fi, *rc.env, security.Write)
+			}
 			return res
 		}).AllowGroup(security.GroupEveryone)
 
@@ -373,16 +479,23 @@ func declareSearchMethods() {
 
 	commonMixin.AddMethod("Search",
 		`Search returns a new RecordSet filtering on the current one with the
-		additional given Condition`,
+		additional given Condition.
+
+		If the model has an Active field, archived records are excluded unless
+		the condition filters on the Active field or the context has the
+		active_test key set to false.`,
 		func(rc *RecordCollection, cond Conditioner) *RecordCollection {
-			return rc.Search(cond.Underlying())
+			return rc.Search(rc.withActiveTest(cond.Underlying()))
 		}).AllowGroup(security.GroupEveryone)
 
 	commonMixin.AddMethod("Browse",
 		`Browse returns a new RecordSet with only the records with the given ids.
-		Note that this function is just a shorcut for Search on a list of ids.`,
+		Note that this function is just a shorcut for Search on a list of ids,
+		which includes archived records.`,
 		func(rc *RecordCollection, ids []int64) *RecordCollection {
-			return rc.Call("Search", rc.Model().Field("ID").In(ids)).(RecordSet).Collection()
+			return rc.WithContext("active_test", false).
+				Call("Search", rc.Model().Field("ID").In(ids)).(RecordSet).Collection().
+				WithNewContext(rc.Env().Context())
 		}).AllowGroup(security.GroupEveryone)
 
 	commonMixin.AddMethod("SearchCount",
@@ -391,6 +504,16 @@ func declareSearchMethods() {
 			return rc.SearchCount()
 		}).AllowGroup(security.GroupEveryone)
 
+	commonMixin.AddMethod("SearchRead",
+		`SearchRead searches the records matching the given condition and reads the given
+		fields of the records between offset and offset + limit (0 meaning no limit).
+
+		The returned SearchReadResult also holds the total number of records matching
+		cond so that list views can be paginated with a single call.`,
+		func(rc *RecordCollection, cond Conditioner, fields []string, offset, limit int, order string) SearchReadResult {
+			return rc.SearchRead(cond.Underlying(), fields, offset, limit, order)
+		}).AllowGroup(security.GroupEveryone)
+
 	commonMixin.AddMethod("Fetch",
 		`Fetch query the database with the current filter and returns a RecordSet
 		with the queries ids.
@@ -402,9 +525,22 @@ func declareSearchMethods() {
 
 	commonMixin.AddMethod("SearchAll",
 		`SearchAll returns a RecordSet with all items of the table, regardless of the
-		current RecordSet query. It is mainly meant to be used on an empty RecordSet`,
+		current RecordSet query. It is mainly meant to be used on an empty RecordSet.
+
+		Archived records are excluded as in Search.`,
 		func(rc *RecordCollection) *RecordCollection {
-			return rc.SearchAll()
+			res := rc.SearchAll()
+			if cond := rc.withActiveTest(newCondition()); !cond.IsEmpty() {
+				res = res.Search(cond)
+			}
+			return res
+		}).AllowGroup(security.GroupEveryone)
+
+	commonMixin.AddMethod("ToggleActive",
+		`ToggleActive archives the active records of this RecordSet
+		and restores the archived ones.`,
+		func(rc *RecordCollection) {
+			rc.ToggleActive()
 		}).AllowGroup(security.GroupEveryone)
 
 	commonMixin.AddMethod("GroupBy",
@@ -433,13 +569,53 @@ func declareSearchMethods() {
 
 	commonMixin.AddMethod("OrderBy",
 		`OrderBy returns a new RecordSet ordered by the given ORDER BY expressions.
-		Each expression contains a field name and optionally one of "asc" or "desc", such as:
+		Each expression contains a field name or path, optionally one of "asc" or "desc"
+		and optionally one of "nulls first" or "nulls last", such as:
 
-		rs.OrderBy("Company", "Name desc")`,
+		rs.OrderBy("Company", "Name desc", "Parent.Date asc nulls last")`,
 		func(rc *RecordCollection, exprs ...string) *RecordCollection {
 			return rc.OrderBy(exprs...)
 		}).AllowGroup(security.GroupEveryone)
 
+	commonMixin.AddMethod("Collate",
+		`Collate returns a new RecordSet whose char and text fields are ordered with
+		the given database collation, instead of the collation of the fields, such as:
+
+		rs.OrderBy("Name").Collate("fr-x-icu")`,
+		func(rc *RecordCollection, collation string) *RecordCollection {
+			return rc.Collate(collation)
+		}).AllowGroup(security.GroupEveryone)
+
+	commonMixin.AddMethod("OrderByRank",
+		`OrderByRank returns a new RecordSet ordered by decreasing full-text search rank
+		of the given field for the given text, before any other order, such as:
+
+		rs.Search(q.Post().Content().Match("hexya")).OrderByRank(h.Post().Fields().Content(), "hexya")`,
+		func(rc *RecordCollection, field FieldNamer, text string) *RecordCollection {
+			return rc.OrderByRank(field, text)
+		}).AllowGroup(security.GroupEveryone)
+
+	commonMixin.AddMethod("OrderBySimilarity",
+		`OrderBySimilarity returns a new RecordSet ordered by decreasing trigram similarity
+		of the given char or text field with the given text, before any other order, such as:
+
+		rs.Search(q.Partner().Name().Similar("Jonh")).OrderBySimilarity(h.Partner().Fields().Name(), "Jonh")`,
+		func(rc *RecordCollection, field FieldNamer, text string) *RecordCollection {
+			return rc.OrderBySimilarity(field, text)
+		}).AllowGroup(security.GroupEveryone)
+
+	commonMixin.AddMethod("Cached",
+		`Cached returns a new RecordSet whose search results are kept in a cache
+		shared by all environments during ttl, such as:
+
+		rs.Search(cond).Cached(time.Minute)
+
+		Cached results are discarded when records of the searched models are
+		modified by a committed transaction.`,
+		func(rc *RecordCollection, ttl time.Duration) *RecordCollection {
+			return rc.Cached(ttl)
+		}).AllowGroup(security.GroupEveryone)
+
 	commonMixin.AddMethod("Union",
 		`Union returns a new RecordSet that is the union of this RecordSet and the given
 		"other" RecordSet. The result is guaranteed to be a set of unique records.`,
@@ -562,25 +738,28 @@ func ConvertLimitToInt(limit interface{}) int {
 
 // FieldInfo is the exportable field information struct
 type FieldInfo struct {
-	ChangeDefault    bool                   `json:"change_default"`
-	Help             string                 `json:"help"`
-	Searchable       bool                   `json:"searchable"`
-	Views            map[string]interface{} `json:"views"`
-	Required         bool                   `json:"required"`
-	Manual           bool                   `json:"manual"`
-	ReadOnly         bool                   `json:"readonly"`
-	Depends          []string               `json:"depends"`
-	CompanyDependent bool                   `json:"company_dependent"`
-	Sortable         bool                   `json:"sortable"`
-	Translate        bool                   `json:"translate"`
-	Type             fieldtype.Type         `json:"type"`
-	Store            bool                   `json:"store"`
-	String           string                 `json:"string"`
-	Relation         string                 `json:"relation"`
-	Selection        types.Selection        `json:"selection"`
-	Domain           interface{}            `json:"domain"`
-	OnChange         bool                   `json:"-"`
-	ReverseFK        string                 `json:"-"`
+	ChangeDefault    bool                      `json:"change_default"`
+	Help             string                    `json:"help"`
+	Searchable       bool                      `json:"searchable"`
+	Views            map[string]interface{}    `json:"views"`
+	Required         bool                      `json:"required"`
+	Manual           bool                      `json:"manual"`
+	ReadOnly         bool                      `json:"readonly"`
+	Depends          []string                  `json:"depends"`
+	CompanyDependent bool                      `json:"company_dependent"`
+	Sortable         bool                      `json:"sortable"`
+	Translate        bool                      `json:"translate"`
+	Type             fieldtype.Type            `json:"type"`
+	Store            bool                      `json:"store"`
+	String           string                    `json:"string"`
+	Relation         string                    `json:"relation"`
+	Selection        types.Selection           `json:"selection"`
+	Domain           interface{}               `json:"domain"`
+	EmbeddedSchema   map[string]fieldtype.Type `json:"embedded_schema,omitempty"`
+	Readable         bool                      `json:"readable"`
+	Writable         bool                      `json:"writable"`
+	OnChange         bool                      `json:"-"`
+	ReverseFK        string                    `json:"-"`
 }
 
 // FieldsGetArgs is the args struct for the FieldsGet method
@@ -589,6 +768,14 @@ type FieldsGetArgs struct {
 	Fields []FieldName `json:"allfields"`
 }
 
+// SearchReadResult is the result struct type of the SearchRead function
+type SearchReadResult struct {
+	// Records are the read records of the requested page
+	Records []FieldMap `json:"records"`
+	// Length is the total number of records matching the search condition
+	Length int `json:"length"`
+}
+
 // OnchangeParams is the args struct of the Onchange function
 type OnchangeParams struct {
 	Values   FieldMap          `json:"values"`
@@ -16,6 +16,8 @@ package models
 
 import (
 	"fmt"
+	"sort"
+	"strings"
 
 	"github.com/hexya-erp/hexya/hexya/models/fieldtype"
 	"github.com/hexya-erp/hexya/hexya/models/security"
@@ -43,15 +45,21 @@ func BootStrap() {
 	Registry.bootstrapped = true
 
 	inflateMixIns()
+	addParentPathFields()
+	validateRegistry()
+	resolveModelHandles()
 	createModelLinks()
 	inflateEmbeddings()
 	processUpdates()
 	syncRelatedFieldInfo()
+	bootStrapCounters()
 	bootStrapMethods()
 	processDepends()
 	checkFieldMethodsExist()
 	checkComputeMethodsSignature()
 	setupSecurity()
+	setupCompanyRules()
+	setupImmutableModels()
 }
 
 // BootStrapped returns true if the models have been bootstrapped
@@ -98,6 +106,9 @@ func createModelLinks() {
 			if fi.fieldType.IsReverseRelationType() {
 				fi.jsonReverseFK = relatedMI.fields.MustGet(fi.reverseFK).json
 			}
+			if fi.fieldType == fieldtype.One2Many && fi.filter != nil {
+				relatedMI.filteredO2Ms = append(relatedMI.filteredO2Ms, fi)
+			}
 			fi.relatedModel = relatedMI
 		}
 		mi.fields.bootstrapped = true
@@ -178,6 +189,9 @@ func addMixinMethods(mixinModel, model *Model) {
 		for group := range methInfo.groups {
 			model.methods.MustGet(methName).groups[group] = true
 		}
+		if methInfo.private {
+			model.methods.MustGet(methName).private = true
+		}
 	}
 }
 
@@ -287,10 +301,17 @@ func runInit(model *Model) {
 // SyncDatabase creates or updates database tables with the data in the model registry
 func SyncDatabase() {
 	adapter := adapters[db.DriverName()]
+	skippedSchemaChanges = nil
 	dbTables := adapter.tables()
+	// Create extensions required by fields
+	updateDBExtensions()
 	// Create or update sequences
 	updateDBSequences()
+	// Rename the tables and columns of renamed models and fields
+	updateDBRenames()
+	dbTables = adapter.tables()
 	// Create or update existing tables
+	var newCounters []*Field
 	for tableName, model := range Registry.registryByTableName {
 		if model.isMixin() {
 			// Don't create table for mixin models
@@ -301,10 +322,24 @@ func SyncDatabase() {
 			continue
 		}
 		if _, ok := dbTables[tableName]; !ok {
-			createDBTable(model.tableName)
+			createDBTable(model)
 		}
-		updateDBColumns(model)
+		updateDBIDColumn(model)
+		newCounters = append(newCounters, updateDBColumns(model)...)
 		updateDBIndexes(model)
+		updateDBFullText(model)
+		updateDBTrigramIndexes(model)
+		updateDBUnaccentIndexes(model)
+	}
+	// Initialize counter fields that have just been created
+	for _, fi := range newCounters {
+		recomputeCounter(fi)
+	}
+	// Initialize parent paths of records created before they were maintained
+	for _, model := range Registry.registryByTableName {
+		if !model.isMixin() && !model.isManual() && model.hasParentPath() {
+			recomputeParentPaths(model)
+		}
 	}
 	// Setup constraints
 	for _, model := range Registry.registryByTableName {
@@ -317,6 +352,8 @@ func SyncDatabase() {
 		buildSQLErrorSubstitutionMap(model)
 		updateDBForeignKeyConstraints(model)
 		updateDBConstraints(model)
+		updateDBUniqueConstraints(model)
+		updateDBModelIndexes(model)
 	}
 	// Run init method on each model
 	for _, model := range Registry.registryByTableName {
@@ -341,7 +378,7 @@ func SyncDatabase() {
 			break
 		}
 		if !modelExists {
-			dropDBTable(dbTable)
+			applyDestructiveChange(dropTableSQL(dbTable), "table", dbTable)
 		}
 	}
 }
@@ -352,6 +389,9 @@ func buildSQLErrorSubstitutionMap(model *Model) {
 	for sqlConstraintName, sqlConstraint := range model.sqlConstraints {
 		model.sqlErrors[sqlConstraintName] = sqlConstraint.errorString
 	}
+	for uniqueConstraintName, uniqueConstraint := range model.uniqueConstraints {
+		model.sqlErrors[uniqueConstraintName] = uniqueConstraint.errorString
+	}
 	for _, field := range model.fields.registryByJSON {
 		if field.unique {
 			cName := fmt.Sprintf("%s_%s_key", model.tableName, field.json)
@@ -364,6 +404,32 @@ func buildSQLErrorSubstitutionMap(model *Model) {
 	}
 }
 
+// updateDBExtensions creates the DB extensions that are
+// required by the fields and the models of the registry.
+func updateDBExtensions() {
+	adapter := adapters[db.DriverName()]
+	extensions := make(map[string]bool)
+	for _, model := range Registry.registryByName {
+		if model.isFuzzySearchable() {
+			extensions["pg_trgm"] = true
+		}
+		for _, fi := range model.fields.registryByName {
+			if fi.unaccent || isUnaccentSearchable(fi) {
+				extensions["unaccent"] = true
+			}
+			if isUnaccentSearchable(fi) && fi.index {
+				extensions["pg_trgm"] = true
+			}
+		}
+	}
+	for extension := range extensions {
+		adapter.createExtension(extension)
+	}
+	if extensions["unaccent"] {
+		dbExecuteNoTx(adapter.unaccentFunctionSQL())
+	}
+}
+
 // updateDBSequences synchronizes sequences between the DB
 // and the registry.
 func updateDBSequences() {
@@ -391,33 +457,38 @@ func updateDBSequences() {
 			break
 		}
 		if !sequenceExists {
-			adapter.dropSequence(dbSeq)
+			applyDestructiveChange(adapter.dropSequenceSQL(dbSeq), "sequence", dbSeq)
 		}
 	}
 }
 
 // createDBTable creates a table in the database from the given Model
 // It only creates the primary key. Call updateDBColumns to create columns.
-func createDBTable(tableName string) {
+func createDBTable(m *Model) {
+	dbExecuteNoTx(createTableSQL(m))
+}
+
+// createTableSQL returns the SQL statement that creates
+// the table of the given Model with only its primary key.
+func createTableSQL(m *Model) string {
 	adapter := adapters[db.DriverName()]
-	query := fmt.Sprintf(`
-	CREATE TABLE %s (
-		id serial NOT NULL PRIMARY KEY
-	)
-	`, adapter.quoteTableName(tableName))
-	dbExecuteNoTx(query)
+	idType := "serial"
+	if m.hasBigIDs() {
+		idType = "bigserial"
+	}
+	return fmt.Sprintf(`CREATE TABLE %s (id %s NOT NULL PRIMARY KEY)`, adapter.quoteTableName(m.tableName), idType)
 }
 
-// dropDBTable drops the given table in the database
-func dropDBTable(tableName string) {
+// dropTableSQL returns the SQL statement that drops the given table
+func dropTableSQL(tableName string) string {
 	adapter := adapters[db.DriverName()]
-	query := fmt.Sprintf(`DROP TABLE %s`, adapter.quoteTableName(tableName))
-	dbExecuteNoTx(query)
+	return fmt.Sprintf(`DROP TABLE %s`, adapter.quoteTableName(tableName))
 }
 
 // updateDBColumns synchronizes the colums of the database with the
-// given Model.
-func updateDBColumns(mi *Model) {
+// given Model. It returns the counter fields whose column has been created.
+func updateDBColumns(mi *Model) []*Field {
+	var newCounters []*Field
 	adapter := adapters[db.DriverName()]
 	dbColumns := adapter.columns(mi.tableName)
 	// create or update columns from registry data
@@ -428,9 +499,13 @@ func updateDBColumns(mi *Model) {
 		dbColData, ok := dbColumns[colName]
 		if !ok {
 			createDBColumn(fi)
+			if fi.counterOf != "" {
+				newCounters = append(newCounters, fi)
+			}
+			continue
 		}
-		if dbColData.DataType != adapter.typeSQL(fi) {
-			updateDBColumnDataType(fi)
+		if current, target := dbColumnType(dbColData), fieldColumnType(fi); current != target {
+			updateDBColumnDataType(fi, current, target)
 		}
 		if (dbColData.IsNullable == "NO" && !adapter.fieldIsNotNull(fi)) ||
 			(dbColData.IsNullable == "YES" && adapter.fieldIsNotNull(fi)) {
@@ -444,10 +519,11 @@ func updateDBColumns(mi *Model) {
 	}
 	// drop columns that no longer exist
 	for colName := range dbColumns {
-		if _, ok := mi.fields.registryByJSON[colName]; !ok {
-			dropDBColumn(mi.tableName, colName)
+		if _, ok := mi.fields.registryByJSON[colName]; !ok && !isFullTextColumn(mi, colName) {
+			applyDestructiveChange(dropColumnSQL(mi.tableName, colName), "table", mi.tableName, "column", colName)
 		}
 	}
+	return newCounters
 }
 
 // createDBColumn insert the column described by Field in the database
@@ -455,37 +531,55 @@ func createDBColumn(fi *Field) {
 	if !fi.isStored() {
 		log.Panic("createDBColumn should not be called on non stored fields", "model", fi.model.name, "field", fi.json)
 	}
+	dbExecuteNoTx(addColumnSQL(fi))
+}
+
+// addColumnSQL returns the SQL statement that adds
+// the column of the given Field to its table.
+func addColumnSQL(fi *Field) string {
 	adapter := adapters[db.DriverName()]
-	query := fmt.Sprintf(`
-		ALTER TABLE %s
-		ADD COLUMN %s %s
-	`, adapter.quoteTableName(fi.model.tableName), fi.json, adapter.columnSQLDefinition(fi))
-	dbExecuteNoTx(query)
+	return fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s %s`,
+		adapter.quoteTableName(fi.model.tableName), fi.json, adapter.columnSQLDefinition(fi))
 }
 
 // updateDBColumnDataType updates the data type in database for the given Field
-func updateDBColumnDataType(fi *Field) {
+// from the current type to the target type. The change is only applied as a
+// destructive change if the target type does not hold all the current values.
+func updateDBColumnDataType(fi *Field, current, target columnType) {
 	adapter := adapters[db.DriverName()]
-	query := fmt.Sprintf(`
-		ALTER TABLE %s
-		ALTER COLUMN %s SET DATA TYPE %s
-	`, adapter.quoteTableName(fi.model.tableName), fi.json, adapter.typeSQL(fi))
+	query := fmt.Sprintf(`ALTER TABLE %s ALTER COLUMN %s SET DATA TYPE %s USING %s::%s`,
+		adapter.quoteTableName(fi.model.tableName), fi.json, target, fi.json, target)
+	if !current.widensTo(target) {
+		applyDestructiveChange(query, "table", fi.model.tableName, "column", fi.json, "from", current, "to", target)
+		return
+	}
 	dbExecuteNoTx(query)
 }
 
 // updateDBColumnNullable updates the NULL/NOT NULL data in database for the given Field
+//
+// NULL values are replaced by the SQL default of the field before setting
+// NOT NULL. If the field has no SQL default, such as required relation
+// fields, the change is skipped as long as NULL values remain.
 func updateDBColumnNullable(fi *Field) {
 	adapter := adapters[db.DriverName()]
-	var verb string
+	table := adapter.quoteTableName(fi.model.tableName)
+	verb := "DROP"
 	if adapter.fieldIsNotNull(fi) {
 		verb = "SET"
-	} else {
-		verb = "DROP"
 	}
-	query := fmt.Sprintf(`
-		ALTER TABLE %s
-		ALTER COLUMN %s %s NOT NULL
-	`, adapter.quoteTableName(fi.model.tableName), fi.json, verb)
+	query := fmt.Sprintf(`ALTER TABLE %s ALTER COLUMN %s %s NOT NULL`, table, fi.json, verb)
+	if verb == "SET" {
+		if defValue := adapter.fieldSQLDefault(fi); defValue != "" {
+			dbExecuteNoTx(fmt.Sprintf(`UPDATE %s SET %s = %s WHERE %s IS NULL`, table, fi.json, defValue, fi.json))
+		}
+		var hasNulls bool
+		dbGetNoTx(&hasNulls, fmt.Sprintf(`SELECT EXISTS (SELECT 1 FROM %s WHERE %s IS NULL)`, table, fi.json))
+		if hasNulls {
+			skipSchemaChange(query, "column has NULL values", "table", fi.model.tableName, "column", fi.json)
+			return
+		}
+	}
 	dbExecuteNoTx(query)
 }
 
@@ -508,14 +602,11 @@ func updateDBColumnDefault(fi *Field) {
 	dbExecuteNoTx(query)
 }
 
-// dropDBColumn drops the column colName from table tableName in database
-func dropDBColumn(tableName, colName string) {
+// dropColumnSQL returns the SQL statement that drops
+// the column colName from table tableName
+func dropColumnSQL(tableName, colName string) string {
 	adapter := adapters[db.DriverName()]
-	query := fmt.Sprintf(`
-		ALTER TABLE %s
-		DROP COLUMN %s
-	`, adapter.quoteTableName(tableName), colName)
-	dbExecuteNoTx(query)
+	return fmt.Sprintf(`ALTER TABLE %s DROP COLUMN %s`, adapter.quoteTableName(tableName), colName)
 }
 
 // updateDBForeignKeyConstraints creates or updates fk constraints
@@ -554,11 +645,84 @@ dbConLoop:
 	}
 }
 
+// updateDBUniqueConstraints creates the partial unique indexes of the unique
+// constraints of the given model that do not exist in the database, recreates
+// those whose definition has changed and drops those that are not declared
+// anymore.
+//
+// As for model indexes, the definition of the index is stored as its comment.
+func updateDBUniqueConstraints(m *Model) {
+	adapter := adapters[db.DriverName()]
+	for indexName, constraint := range m.uniqueConstraints {
+		query := uniqueIndexSQL(m, constraint)
+		if adapter.indexExists(m.tableName, indexName) {
+			if adapter.indexComment(indexName) == query {
+				continue
+			}
+			log.Info("Recreating unique constraint with new definition", "model", m.name, "constraint", indexName)
+			dropIndex(indexName)
+		}
+		createUniqueIndex(m, constraint)
+	}
+dbIdxLoop:
+	for _, dbIndexName := range adapter.indexes(m.tableName, fmt.Sprintf("%%_%s_manidx", m.tableName)) {
+		for indexName := range m.uniqueConstraints {
+			if indexName == dbIndexName {
+				continue dbIdxLoop
+			}
+		}
+		dropIndex(dbIndexName)
+	}
+}
+
+// createUniqueIndex creates the partial unique index of the given unique
+// constraint and stores its definition as its comment
+func createUniqueIndex(m *Model, constraint uniqueConstraint) {
+	adapter := adapters[db.DriverName()]
+	query := uniqueIndexSQL(m, constraint)
+	dbExecuteNoTx(query)
+	dbExecuteNoTx(adapter.commentIndexSQL(constraint.name, query))
+}
+
+// uniqueIndexSQL returns the SQL statement that creates the
+// partial unique index of the given unique constraint
+func uniqueIndexSQL(m *Model, constraint uniqueConstraint) string {
+	adapter := adapters[db.DriverName()]
+	cols := make([]string, len(constraint.fields))
+	for i, f := range constraint.fields {
+		fi := m.fields.MustGet(f.String())
+		if !fi.isStored() {
+			log.Panic("Unique constraints can only be set on stored fields", "model", m.name,
+				"constraint", constraint.name, "field", fi.name)
+		}
+		cols[i] = fi.json
+	}
+	query := fmt.Sprintf(`CREATE UNIQUE INDEX %s ON %s (%s)`,
+		constraint.name, adapter.quoteTableName(m.tableName), strings.Join(cols, ", "))
+	if constraint.where != "" {
+		query += fmt.Sprintf(" WHERE %s", constraint.where)
+	}
+	return query
+}
+
+// dropIndex drops the index with the given name
+func dropIndex(indexName string) {
+	query := fmt.Sprintf(`
+		DROP INDEX IF EXISTS %s
+	`, indexName)
+	dbExecuteNoTx(query)
+}
+
 // createFKConstraint creates an FK constraint for the given column that references the given targetTable
 func createFKConstraint(tableName, colName, targetTable, ondelete string) {
+	createConstraint(tableName, fmt.Sprintf("%s_%s_fkey", tableName, colName), fkConstraintSQL(colName, targetTable, ondelete))
+}
+
+// fkConstraintSQL returns the SQL definition of an FK
+// constraint of colName that references the given targetTable
+func fkConstraintSQL(colName, targetTable, ondelete string) string {
 	adapter := adapters[db.DriverName()]
-	constraint := fmt.Sprintf("FOREIGN KEY (%s) REFERENCES %s ON DELETE %s", colName, adapter.quoteTableName(targetTable), ondelete)
-	createConstraint(tableName, fmt.Sprintf("%s_%s_fkey", tableName, colName), constraint)
+	return fmt.Sprintf("FOREIGN KEY (%s) REFERENCES %s ON DELETE %s", colName, adapter.quoteTableName(targetTable), ondelete)
 }
 
 // dropFKConstraint drops an FK constraint for colName in the given table
@@ -568,11 +732,14 @@ func dropFKConstraint(tableName, colName string) {
 
 // createConstraint creates a constraint in the given table
 func createConstraint(tableName, constraintName, sql string) {
+	dbExecuteNoTx(addConstraintSQL(tableName, constraintName, sql))
+}
+
+// addConstraintSQL returns the SQL statement that adds
+// a constraint with the given definition to the given table
+func addConstraintSQL(tableName, constraintName, sql string) string {
 	adapter := adapters[db.DriverName()]
-	query := fmt.Sprintf(`
-		ALTER TABLE %s ADD CONSTRAINT %s %s
-	`, adapter.quoteTableName(tableName), constraintName, sql)
-	dbExecuteNoTx(query)
+	return fmt.Sprintf(`ALTER TABLE %s ADD CONSTRAINT %s %s`, adapter.quoteTableName(tableName), constraintName, sql)
 }
 
 // dropConstraint drops a constraint with the given name
@@ -601,11 +768,14 @@ func updateDBIndexes(m *Model) {
 
 // createColumnIndex creates an column index for colName in the given table
 func createColumnIndex(tableName, colName string) {
+	dbExecuteNoTx(columnIndexSQL(tableName, colName))
+}
+
+// columnIndexSQL returns the SQL statement that creates
+// the index of colName in the given table
+func columnIndexSQL(tableName, colName string) string {
 	adapter := adapters[db.DriverName()]
-	query := fmt.Sprintf(`
-		CREATE INDEX %s ON %s (%s)
-	`, fmt.Sprintf("%s_%s_index", tableName, colName), adapter.quoteTableName(tableName), colName)
-	dbExecuteNoTx(query)
+	return fmt.Sprintf(`CREATE INDEX %s_%s_index ON %s (%s)`, tableName, colName, adapter.quoteTableName(tableName), colName)
 }
 
 // dropColumnIndex drops a column index for colName in the given table
@@ -663,3 +833,154 @@ func checkFieldMethodsExist() {
 		}
 	}
 }
+
+// validateRegistry checks the whole registry for declaration errors such as
+// dangling relation targets, related paths that do not resolve, missing
+// field methods or conflicting JSON names.
+//
+// All errors are collected and reported at once, so that the developer does
+// not have to fix them one by one at first use. It panics if any error is found.
+func validateRegistry() {
+	var errs []string
+	for _, mi := range Registry.registryByName {
+		errs = append(errs, validateModel(mi)...)
+	}
+	if len(errs) == 0 {
+		return
+	}
+	sort.Strings(errs)
+	log.Panic(fmt.Sprintf("Models validation failed with %d error(s):\n- %s", len(errs), strings.Join(errs, "\n- ")))
+}
+
+// validateModel returns the list of declaration errors found in the given model.
+func validateModel(mi *Model) []string {
+	var errs []string
+	jsonNames := make(map[string]string)
+	for _, fi := range mi.fields.registryByName {
+		if other, exists := jsonNames[fi.json]; exists {
+			errs = append(errs, fmt.Sprintf("%s: fields %s and %s have the same JSON name '%s'", mi.name, other, fi.name, fi.json))
+		}
+		jsonNames[fi.json] = fi.name
+		if fi.fieldType.IsRelationType() {
+			relatedMI, ok := Registry.Get(fi.relatedModelName)
+			switch {
+			case !ok:
+				errs = append(errs, fmt.Sprintf("%s.%s: unknown relation model '%s'", mi.name, fi.name, fi.relatedModelName))
+			case fi.fieldType.IsReverseRelationType():
+				if _, exists := relatedMI.fields.Get(fi.reverseFK); !exists {
+					errs = append(errs, fmt.Sprintf("%s.%s: unknown reverse field '%s' in model %s", mi.name, fi.name, fi.reverseFK, relatedMI.name))
+				}
+			}
+		}
+		if fi.fieldType == fieldtype.Monetary && !mi.isMixin() {
+			curFI, ok := findFieldWithEmbeddings(mi, fi.currencyField)
+			if !ok || curFI.fieldType != fieldtype.Many2One || curFI.relatedModelName != "Currency" {
+				errs = append(errs, fmt.Sprintf("%s.%s: currency field '%s' is not a many2one to the Currency model", mi.name, fi.name, fi.currencyField))
+			}
+		}
+		if relPath := pendingFieldProperty(fi, "relatedPath"); relPath != "" {
+			target, err := relatedPathTarget(mi, relPath)
+			switch {
+			case err != "":
+				errs = append(errs, fmt.Sprintf("%s.%s: related path '%s' does not resolve: %s", mi.name, fi.name, relPath, err))
+			case fi.fieldType.IsRelationType() && (!target.fieldType.IsRelationType() || target.relatedModelName != fi.relatedModelName):
+				errs = append(errs, fmt.Sprintf("%s.%s: related path '%s' does not lead to a relation to model %s", mi.name, fi.name, relPath, fi.relatedModelName))
+			}
+		}
+		if fi.companyDependent && (pendingFieldProperty(fi, "compute") != "" || pendingFieldProperty(fi, "relatedPath") != "") {
+			errs = append(errs, fmt.Sprintf("%s.%s: computed and related fields cannot be company dependent", mi.name, fi.name))
+		}
+		if mi.isImmutable() && (fi.companyDependent || fi.stored && (pendingFieldProperty(fi, "compute") != "" || pendingFieldProperty(fi, "relatedPath") != "")) {
+			errs = append(errs, fmt.Sprintf("%s.%s: immutable models cannot have stored computed, related or company dependent fields", mi.name, fi.name))
+		}
+		for _, prop := range []string{"compute", "onChange", "constraint", "inverse"} {
+			methName := pendingFieldProperty(fi, prop)
+			if methName == "" {
+				continue
+			}
+			if _, exists := mi.methods.get(methName); !exists {
+				errs = append(errs, fmt.Sprintf("%s.%s: unknown %s method '%s'", mi.name, fi.name, prop, methName))
+			}
+		}
+	}
+	return errs
+}
+
+// pendingFieldProperty returns the value of the given string property of fi
+// taking into account the updates that have not yet been processed.
+func pendingFieldProperty(fi *Field, property string) string {
+	var res string
+	switch property {
+	case "relatedPath":
+		res = fi.relatedPath
+	case "compute":
+		res = fi.compute
+	case "onChange":
+		res = fi.onChange
+	case "constraint":
+		res = fi.constraint
+	case "inverse":
+		res = fi.inverse
+	}
+	for _, update := range fi.updates {
+		if value, ok := update[property]; ok {
+			res = value.(string)
+		}
+	}
+	return res
+}
+
+// checkRelatedPath follows the given related path from the given model
+// before the model links are created. It returns an empty string if the path
+// is valid or an error message otherwise.
+func checkRelatedPath(mi *Model, path string) string {
+	_, err := relatedPathTarget(mi, path)
+	return err
+}
+
+// relatedPathTarget returns the field at the end of the given related path
+// from the given model, before the model links are created. If the path is
+// not valid, it returns an error message instead.
+func relatedPathTarget(mi *Model, path string) (*Field, string) {
+	exprs := strings.Split(path, ExprSep)
+	currentMI := mi
+	var fi *Field
+	for i, expr := range exprs {
+		var ok bool
+		fi, ok = findFieldWithEmbeddings(currentMI, expr)
+		if !ok {
+			return nil, fmt.Sprintf("unknown field '%s' in model %s", expr, currentMI.name)
+		}
+		if i == len(exprs)-1 {
+			break
+		}
+		if !fi.fieldType.IsRelationType() {
+			return nil, fmt.Sprintf("field '%s' of model %s is not a relation", expr, currentMI.name)
+		}
+		relatedMI, exists := Registry.Get(fi.relatedModelName)
+		if !exists {
+			return nil, fmt.Sprintf("unknown relation model '%s'", fi.relatedModelName)
+		}
+		currentMI = relatedMI
+	}
+	return fi, ""
+}
+
+// findFieldWithEmbeddings returns the field with the given name in the given model,
+// looking also into the embedded models that have not been inflated yet.
+func findFieldWithEmbeddings(mi *Model, name string) (*Field, bool) {
+	if fi, ok := mi.fields.Get(name); ok {
+		return fi, true
+	}
+	for _, fi := range mi.fields.registryByName {
+		if !fi.embed {
+			continue
+		}
+		if embeddedMI, ok := Registry.Get(fi.relatedModelName); ok {
+			if efi, exists := embeddedMI.fields.Get(name); exists {
+				return efi, true
+			}
+		}
+	}
+	return nil, false
+}
@@ -16,22 +16,72 @@ package models
 
 import (
 	"errors"
+	"sort"
 	"strings"
 
+	"github.com/hexya-erp/hexya/hexya/metrics"
 	"github.com/hexya-erp/hexya/hexya/models/fieldtype"
 )
 
+var (
+	recordCacheHits   = metrics.CacheLookups.WithLabelValues("records", "hit")
+	recordCacheMisses = metrics.CacheLookups.WithLabelValues("records", "miss")
+)
+
 // A cacheRef is a key to find a record in a cache
 type cacheRef struct {
 	model *Model
 	id    int64
 }
 
+// CacheMaxRecords is the default maximum number of records held by the
+// cache of an Environment. When it is exceeded, the least recently used
+// records are evicted from the cache, so that they are read again from the
+// database if they are needed. 0 means no limit.
+//
+// It must be well above the number of records loaded at once.
+var CacheMaxRecords = 100000
+
+// cacheEvictionRatio is the ratio of CacheMaxRecords that
+// is evicted at once when the cache is full.
+const cacheEvictionRatio = 0.1
+
+// CacheStats are the statistics of the cache of an Environment
+type CacheStats struct {
+	// Records is the number of records in the cache
+	Records int
+	// MaxRecords is the maximum number of records of the cache
+	MaxRecords int
+	// Hits is the number of record lookups found in the cache
+	Hits int64
+	// Misses is the number of record lookups not found in the
+	// cache, which are usually followed by a database query
+	Misses int64
+	// Evictions is the number of records evicted from the
+	// cache because it was full
+	Evictions int64
+}
+
 // A cache holds records field values for caching the database to
 // improve performance. cache is not safe for concurrent access.
 type cache struct {
-	data     map[cacheRef]FieldMap
-	m2mLinks map[*Model]map[[2]int64]bool
+	data         map[cacheRef]FieldMap
+	m2mLinks     map[*Model]map[[2]int64]bool
+	translations map[translationRef]cachedTranslation
+	properties   map[propertyRef]interface{}
+	userGroups   map[int64]cachedUserGroups
+	filteredO2Ms map[*Field]map[int64]bool
+	maxRecords   int
+	lastUse      map[cacheRef]uint64
+	clock        uint64
+	updating     int
+	stats        CacheStats
+}
+
+// touch marks the record of the given ref as used
+func (c *cache) touch(ref cacheRef) {
+	c.clock++
+	c.lastUse[ref] = c.clock
 }
 
 // updateEntry creates or updates an entry in the cache defined by its model, id and fieldName.
@@ -48,10 +98,18 @@ func (c *cache) updateEntry(mi *Model, id int64, fieldName string, value interfa
 // updateEntryByRef creates or updates an entry to the cache from a cacheRef
 // and a field json name (no path).
 func (c *cache) updateEntryByRef(ref cacheRef, jsonName string, value interface{}) {
+	c.updating++
+	defer func() {
+		c.updating--
+		if c.updating == 0 {
+			c.evictIfFull()
+		}
+	}()
 	if _, ok := c.data[ref]; !ok {
 		c.data[ref] = make(FieldMap)
 		c.data[ref]["id"] = ref.id
 	}
+	c.touch(ref)
 	fi := ref.model.fields.MustGet(jsonName)
 	switch fi.fieldType {
 	case fieldtype.One2Many:
@@ -60,6 +118,14 @@ func (c *cache) updateEntryByRef(ref cacheRef, jsonName string, value interface{
 			c.updateEntry(fi.relatedModel, id, fi.jsonReverseFK, ref.id)
 		}
 		c.data[ref][jsonName] = true
+		if fi.filter != nil {
+			// Filtered one2many cannot be deduced from the reverse FK of cached records
+			c.data[ref][jsonName] = ids
+			if c.filteredO2Ms[fi] == nil {
+				c.filteredO2Ms[fi] = make(map[int64]bool)
+			}
+			c.filteredO2Ms[fi][ref.id] = true
+		}
 	case fieldtype.Rev2One:
 		id := value.(int64)
 		c.updateEntry(fi.relatedModel, id, fi.jsonReverseFK, ref.id)
@@ -123,18 +189,28 @@ func (c *cache) getM2MLinks(fi *Field, id int64) []int64 {
 // addRecord successively adds each entry of the given FieldMap to the cache.
 // fMap keys may be a paths relative to this Model (e.g. "User.Profile.Age").
 func (c *cache) addRecord(mi *Model, id int64, fMap FieldMap) {
-	paths := make(map[int][]string)
-	var maxLen int
-	// We create our exprsMap with the length of the path as key
-	for _, path := range fMap.Keys() {
-		exprs := strings.Split(path, ExprSep)
-		paths[len(exprs)] = append(paths[len(exprs)], path)
-		if len(exprs) > maxLen {
-			maxLen = len(exprs)
+	var (
+		paths  map[int][]string
+		maxLen int
+	)
+	// We add the fields of the record itself first and we create our
+	// exprsMap with the length of the other paths as key
+	for path, value := range fMap {
+		depth := strings.Count(path, ExprSep)
+		if depth == 0 {
+			c.updateEntry(mi, id, path, value)
+			continue
+		}
+		if paths == nil {
+			paths = make(map[int][]string)
+		}
+		paths[depth] = append(paths[depth], path)
+		if depth > maxLen {
+			maxLen = depth
 		}
 	}
-	// We add entries into the cache, starting from the smallest paths
-	for i := 0; i <= maxLen; i++ {
+	// We add related entries into the cache, starting from the smallest paths
+	for i := 1; i <= maxLen; i++ {
 		for _, path := range paths[i] {
 			c.updateEntry(mi, id, path, fMap[path])
 		}
@@ -148,6 +224,7 @@ func (c *cache) addRecord(mi *Model, id int64, fMap FieldMap) {
 // records references (One2Many and Many2Many fields).
 func (c *cache) invalidateRecord(mi *Model, id int64) {
 	delete(c.data, cacheRef{model: mi, id: id})
+	delete(c.lastUse, cacheRef{model: mi, id: id})
 	for _, fi := range mi.fields.registryByJSON {
 		if fi.fieldType == fieldtype.Many2Many {
 			c.removeM2MLinks(fi, id)
@@ -155,6 +232,23 @@ func (c *cache) invalidateRecord(mi *Model, id int64) {
 	}
 }
 
+// invalidateFilteredO2Ms removes from the cache the values of the filtered
+// one2many fields pointing to the given model, since creating, updating or
+// deleting records of this model may change the records they select.
+//
+// Only the records whose values have been cached are visited, as
+// they are indexed by field in c.filteredO2Ms.
+func (c *cache) invalidateFilteredO2Ms(mi *Model) {
+	for _, fi := range mi.filteredO2Ms {
+		for id := range c.filteredO2Ms[fi] {
+			if cVal, ok := c.data[cacheRef{model: fi.model, id: id}]; ok {
+				delete(cVal, fi.json)
+			}
+		}
+		delete(c.filteredO2Ms, fi)
+	}
+}
+
 // removeEntry removes the given entry from cache
 func (c *cache) removeEntry(mi *Model, id int64, fieldName string) {
 	if !c.checkIfInCache(mi, []int64{id}, []string{fieldName}) {
@@ -177,9 +271,12 @@ func (c *cache) get(mi *Model, id int64, fieldName string) interface{} {
 	if err != nil {
 		return nil
 	}
+	c.touch(ref)
 	fi := ref.model.fields.MustGet(fName)
-	switch fi.fieldType {
-	case fieldtype.One2Many:
+	switch {
+	case fi.fieldType == fieldtype.One2Many && fi.filter != nil:
+		return c.data[ref][fName]
+	case fi.fieldType == fieldtype.One2Many:
 		var relIds []int64
 		for cRef, cVal := range c.data {
 			if cRef.model != fi.relatedModel {
@@ -191,7 +288,7 @@ func (c *cache) get(mi *Model, id int64, fieldName string) interface{} {
 			relIds = append(relIds, cRef.id)
 		}
 		return relIds
-	case fieldtype.Rev2One:
+	case fi.fieldType == fieldtype.Rev2One:
 		for cRef, cVal := range c.data {
 			if cRef.model != fi.relatedModel {
 				continue
@@ -202,7 +299,7 @@ func (c *cache) get(mi *Model, id int64, fieldName string) interface{} {
 			return cRef.id
 		}
 		return nil
-	case fieldtype.Many2Many:
+	case fi.fieldType == fieldtype.Many2Many:
 		return c.getM2MLinks(fi, ref.id)
 	default:
 		return c.data[ref][fName]
@@ -230,19 +327,30 @@ func (c *cache) checkIfInCache(mi *Model, ids []int64, fieldNames []string) bool
 		for _, fName := range fieldNames {
 			ref, path, err := c.getRelatedRef(mi, id, fName)
 			if err != nil {
+				c.stats.Misses++
+				recordCacheMisses.Inc()
 				return false
 			}
 			if _, ok := c.data[ref][path]; !ok {
+				c.stats.Misses++
+				recordCacheMisses.Inc()
 				return false
 			}
+			c.touch(ref)
 		}
 	}
+	c.stats.Hits++
+	recordCacheHits.Inc()
 	return true
 }
 
 // getRelatedRef returns the cacheRef and field name of the field that is
 // defined by path when walking from the given model with the given ID.
 func (c *cache) getRelatedRef(mi *Model, id int64, path string) (cacheRef, string, error) {
+	if !strings.Contains(path, ExprSep) {
+		// Fast path for fields of the record itself
+		return cacheRef{model: mi, id: id}, mi.fields.MustGet(path).json, nil
+	}
 	exprs := jsonizeExpr(mi, strings.Split(path, ExprSep))
 	if len(exprs) > 1 {
 		relMI := mi.getRelatedModelInfo(exprs[0])
@@ -258,8 +366,134 @@ func (c *cache) getRelatedRef(mi *Model, id int64, path string) (cacheRef, strin
 // newCache creates a pointer to a new cache instance.
 func newCache() *cache {
 	res := cache{
-		data:     make(map[cacheRef]FieldMap),
-		m2mLinks: make(map[*Model]map[[2]int64]bool),
+		data:         make(map[cacheRef]FieldMap),
+		m2mLinks:     make(map[*Model]map[[2]int64]bool),
+		translations: make(map[translationRef]cachedTranslation),
+		properties:   make(map[propertyRef]interface{}),
+		userGroups:   make(map[int64]cachedUserGroups),
+		filteredO2Ms: make(map[*Field]map[int64]bool),
+		maxRecords:   CacheMaxRecords,
+		lastUse:      make(map[cacheRef]uint64),
 	}
 	return &res
 }
+
+// evictIfFull evicts the least recently used records from the cache if it
+// holds more than maxRecords records, so that it is left with maxRecords
+// minus cacheEvictionRatio records.
+func (c *cache) evictIfFull() {
+	if c.maxRecords <= 0 || len(c.data) <= c.maxRecords {
+		return
+	}
+	toEvict := len(c.data) - c.maxRecords + int(float64(c.maxRecords)*cacheEvictionRatio)
+	refs := make([]cacheRef, 0, len(c.data))
+	for ref := range c.data {
+		refs = append(refs, ref)
+	}
+	sort.Slice(refs, func(i, j int) bool { return c.lastUse[refs[i]] < c.lastUse[refs[j]] })
+	evicted := make(map[cacheRef]bool, toEvict)
+	for _, ref := range refs[:toEvict] {
+		evicted[ref] = true
+	}
+	c.removeRecords(evicted)
+	c.stats.Evictions += int64(toEvict)
+	metrics.CacheEvictions.Add(float64(toEvict))
+}
+
+// removeRecords removes the given records from the cache.
+//
+// Since one2many, rev2one and many2many fields are deduced from the cached
+// records they point to, these fields are also removed from the other
+// records of the cache if they point to the model of a removed record.
+func (c *cache) removeRecords(refs map[cacheRef]bool) {
+	removedModels := make(map[*Model]bool)
+	for ref := range refs {
+		for _, fi := range ref.model.fields.registryByJSON {
+			if fi.fieldType == fieldtype.Many2Many {
+				c.removeM2MLinks(fi, ref.id)
+			}
+		}
+		delete(c.data, ref)
+		delete(c.lastUse, ref)
+		removedModels[ref.model] = true
+	}
+	relFields := make(map[*Model][]string)
+	for cRef, cVal := range c.data {
+		fields, ok := relFields[cRef.model]
+		if !ok {
+			for _, fi := range cRef.model.fields.registryByJSON {
+				switch {
+				case fi.fieldType == fieldtype.One2Many && fi.filter != nil:
+					// Filtered one2many fields hold their own ids
+				case fi.fieldType == fieldtype.One2Many, fi.fieldType == fieldtype.Rev2One, fi.fieldType == fieldtype.Many2Many:
+					if removedModels[fi.relatedModel] {
+						fields = append(fields, fi.json)
+					}
+				}
+			}
+			relFields[cRef.model] = fields
+		}
+		for _, field := range fields {
+			delete(cVal, field)
+		}
+	}
+}
+
+// clearModels removes all the records of the given models from the cache.
+func (c *cache) clearModels(models ...*Model) {
+	mis := make(map[*Model]bool)
+	for _, mi := range models {
+		mis[mi] = true
+	}
+	refs := make(map[cacheRef]bool)
+	for ref := range c.data {
+		if mis[ref.model] {
+			refs[ref] = true
+		}
+	}
+	c.removeRecords(refs)
+}
+
+// A Cache gives access to the cache of the records of an Environment
+type Cache struct {
+	cache *cache
+}
+
+// Cache returns the cache of the records of this Environment
+func (env Environment) Cache() Cache {
+	return Cache{cache: env.cache}
+}
+
+// Stats returns the statistics of this Cache
+func (c Cache) Stats() CacheStats {
+	res := c.cache.stats
+	res.Records = len(c.cache.data)
+	res.MaxRecords = c.cache.maxRecords
+	return res
+}
+
+// SetMaxRecords sets the maximum number of records of this Cache,
+// overriding CacheMaxRecords. 0 means no limit.
+func (c Cache) SetMaxRecords(maxRecords int) {
+	c.cache.maxRecords = maxRecords
+	c.cache.evictIfFull()
+}
+
+// Clear removes the records of the given models from this Cache, so that they
+// are read again from the database when they are needed. All the records
+// are removed if no model is given. Statistics are kept.
+func (c Cache) Clear(modelNames ...string) {
+	if len(modelNames) == 0 {
+		stats := c.cache.stats
+		maxRecords := c.cache.maxRecords
+		*c.cache = *newCache()
+		c.cache.stats = stats
+		c.cache.maxRecords = maxRecords
+		return
+	}
+	models := make([]*Model, len(modelNames))
+	for i, modelName := range modelNames {
+		models[i] = Registry.MustGet(modelName)
+	}
+	c.cache.clearModels(models...)
+}
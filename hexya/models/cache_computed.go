@@ -0,0 +1,256 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"sync"
+	"time"
+
+	"github.com/hexya-erp/hexya/hexya/metrics"
+)
+
+var (
+	computedCacheHits   = metrics.CacheLookups.WithLabelValues("computed", "hit")
+	computedCacheMisses = metrics.CacheLookups.WithLabelValues("computed", "miss")
+)
+
+// A CachePolicy defines how the values of a non stored computed field
+// are kept across environments, so that they are not recomputed at each call.
+//
+// A cached value is discarded when its TTL has expired (if TTL is not zero),
+// when one of its Keys is invalidated with InvalidateComputedCache or when
+// a field of its Depends is modified.
+//
+// Values are cached per user, but not per context. Cache policies are
+// ignored on relation fields. Values computed in a transaction are only
+// shared with other environments once it is committed, and values are neither
+// read from nor written to the cache by transactions that have modified the
+// records the field depends on.
+type CachePolicy struct {
+	TTL  time.Duration
+	Keys []string
+}
+
+// computedCacheVacuumInterval is the minimum interval between
+// two removals of the expired entries of the computed cache.
+const computedCacheVacuumInterval = time.Minute
+
+// A computedCacheRef is the key of a value in the computedCache
+type computedCacheRef struct {
+	model *Model
+	field string
+	id    int64
+	uid   int64
+}
+
+// A computedValueKey is the key of a value among
+// the cached values of a record in the computedCache
+type computedValueKey struct {
+	field string
+	uid   int64
+}
+
+// A computedCacheEntry is a value in the computedCache with
+// the data to check its validity.
+type computedCacheEntry struct {
+	value       interface{}
+	expiry      time.Time
+	generations map[string]uint64
+	sequence    uint64
+}
+
+// expired returns true if this entry's TTL has expired at
+// the given time or if one of its keys has been invalidated.
+func (e computedCacheEntry) expired(now time.Time, generations map[string]uint64) bool {
+	if !e.expiry.IsZero() && now.After(e.expiry) {
+		return true
+	}
+	for key, gen := range e.generations {
+		if generations[key] != gen {
+			return true
+		}
+	}
+	return false
+}
+
+// A computedCache holds the values of computed fields with a CachePolicy.
+// Values are indexed by model and record id, so that the values of modified
+// records are found without scanning the whole cache. It is shared by all
+// environments and is safe for concurrent access.
+//
+// Each invalidation of records increments the sequence of the cache, and the
+// sequence of the last invalidation of each model is kept in invalidated.
+type computedCache struct {
+	sync.RWMutex
+	data        map[*Model]map[int64]map[computedValueKey]computedCacheEntry
+	generations map[string]uint64
+	sequence    uint64
+	invalidated map[*Model]uint64
+	lastVacuum  time.Time
+}
+
+// sharedComputedCache is the computedCache of the application
+var sharedComputedCache = newComputedCache()
+
+// newComputedCache returns a new empty computedCache
+func newComputedCache() *computedCache {
+	return &computedCache{
+		data:        make(map[*Model]map[int64]map[computedValueKey]computedCacheEntry),
+		generations: make(map[string]uint64),
+		invalidated: make(map[*Model]uint64),
+	}
+}
+
+// get returns the value for the given ref and true if it
+// is in the cache and still valid.
+func (cc *computedCache) get(ref computedCacheRef) (interface{}, bool) {
+	cc.RLock()
+	defer cc.RUnlock()
+	entry, ok := cc.data[ref.model][ref.id][computedValueKey{field: ref.field, uid: ref.uid}]
+	if !ok || entry.expired(time.Now(), cc.generations) {
+		computedCacheMisses.Inc()
+		return nil, false
+	}
+	computedCacheHits.Inc()
+	return entry.value, true
+}
+
+// newEntry returns an entry for the given value with the given policy,
+// computed from the data read after the given sequence of the cache. It
+// records the current generations of the keys of the policy, so that the
+// entry is discarded if they are invalidated before it is stored with set.
+func (cc *computedCache) newEntry(value interface{}, policy *CachePolicy, sequence uint64) computedCacheEntry {
+	cc.RLock()
+	defer cc.RUnlock()
+	entry := computedCacheEntry{
+		value:       value,
+		generations: make(map[string]uint64),
+		sequence:    sequence,
+	}
+	if policy.TTL > 0 {
+		entry.expiry = time.Now().Add(policy.TTL)
+	}
+	for _, key := range policy.Keys {
+		entry.generations[key] = cc.generations[key]
+	}
+	return entry
+}
+
+// set stores the given entry for ref in the cache, unless records of the
+// model of ref have been invalidated after the sequence of the entry.
+func (cc *computedCache) set(ref computedCacheRef, entry computedCacheEntry) {
+	cc.Lock()
+	defer cc.Unlock()
+	now := time.Now()
+	if now.Sub(cc.lastVacuum) > computedCacheVacuumInterval {
+		cc.vacuum(now)
+	}
+	if cc.invalidated[ref.model] > entry.sequence {
+		return
+	}
+	if _, ok := cc.data[ref.model]; !ok {
+		cc.data[ref.model] = make(map[int64]map[computedValueKey]computedCacheEntry)
+	}
+	if _, ok := cc.data[ref.model][ref.id]; !ok {
+		cc.data[ref.model][ref.id] = make(map[computedValueKey]computedCacheEntry)
+	}
+	cc.data[ref.model][ref.id][computedValueKey{field: ref.field, uid: ref.uid}] = entry
+}
+
+// vacuum removes the expired entries from the cache.
+// It must be called with the lock held.
+func (cc *computedCache) vacuum(now time.Time) {
+	for mi, records := range cc.data {
+		for id, values := range records {
+			for key, entry := range values {
+				if entry.expired(now, cc.generations) {
+					delete(values, key)
+				}
+			}
+			if len(values) == 0 {
+				delete(records, id)
+			}
+		}
+		if len(records) == 0 {
+			delete(cc.data, mi)
+		}
+	}
+	cc.lastVacuum = now
+}
+
+// invalidateRecords removes the cached values of the given field of the
+// records of the given model with the given ids for all users. All fields
+// are invalidated if fieldName is empty and all records if ids is nil.
+func (cc *computedCache) invalidateRecords(mi *Model, fieldName string, ids []int64) {
+	cc.Lock()
+	defer cc.Unlock()
+	cc.sequence++
+	cc.invalidated[mi] = cc.sequence
+	records := cc.data[mi]
+	if ids == nil {
+		for id := range records {
+			ids = append(ids, id)
+		}
+	}
+	for _, id := range ids {
+		if fieldName == "" {
+			delete(records, id)
+			continue
+		}
+		for key := range records[id] {
+			if key.field == fieldName {
+				delete(records[id], key)
+			}
+		}
+	}
+}
+
+// invalidateKeys marks as invalid all the values cached with one of the given keys
+func (cc *computedCache) invalidateKeys(keys ...string) {
+	cc.Lock()
+	defer cc.Unlock()
+	for _, key := range keys {
+		cc.generations[key]++
+	}
+}
+
+// computedCacheValues holds the values computed in the transaction of an
+// Environment for the computedCache, which are stored in the cache when it
+// is committed. sequence is the sequence of the cache when the Environment
+// has been created, before its transaction read any data.
+type computedCacheValues struct {
+	sequence uint64
+	values   map[computedCacheRef]computedCacheEntry
+}
+
+// newComputedCacheValues returns a new computedCacheValues
+// with the current sequence of the shared computed cache.
+func newComputedCacheValues() *computedCacheValues {
+	sharedComputedCache.RLock()
+	defer sharedComputedCache.RUnlock()
+	return &computedCacheValues{
+		sequence: sharedComputedCache.sequence,
+		values:   make(map[computedCacheRef]computedCacheEntry),
+	}
+}
+
+// add adds the given value for ref with the given policy
+func (cv *computedCacheValues) add(ref computedCacheRef, value interface{}, policy *CachePolicy) {
+	cv.values[ref] = sharedComputedCache.newEntry(value, policy, cv.sequence)
+}
+
+// publishComputedValues stores in the shared computed cache the values
+// computed in the transaction of this Environment. It must be called
+// after the transaction is committed.
+func (env Environment) publishComputedValues() {
+	for ref, entry := range env.computedValues.values {
+		sharedComputedCache.set(ref, entry)
+	}
+}
+
+// InvalidateComputedCache discards all the cached computed values
+// whose CachePolicy has one of the given keys.
+func InvalidateComputedCache(keys ...string) {
+	sharedComputedCache.invalidateKeys(keys...)
+}
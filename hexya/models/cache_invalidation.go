@@ -0,0 +1,171 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"encoding/json"
+	"sort"
+)
+
+// CacheInvalidationChannel is the database channel on which the instances
+// connected to the same database exchange cache invalidations.
+const CacheInvalidationChannel = "hexya_cache_invalidation"
+
+// cacheInvalidationEnabled is true if cache invalidations are exchanged
+// with other instances. It is set by EnableCacheInvalidation.
+var cacheInvalidationEnabled bool
+
+// A cacheInvalidation describes modified records whose values may be
+// cached across environments. IDs is nil if all the records of the model
+// must be invalidated and Fields is nil if all their fields must be.
+type cacheInvalidation struct {
+	Model  string   `json:"model"`
+	IDs    []int64  `json:"ids"`
+	Fields []string `json:"fields"`
+}
+
+// A pendingInvalidation holds the records and fields of a model
+// modified in a transaction.
+type pendingInvalidation struct {
+	ids       map[int64]bool
+	fields    map[string]bool
+	allFields bool
+}
+
+// cacheInvalidations holds the cache invalidations of the records modified
+// in the transaction of an Environment, which are sent when it is committed.
+type cacheInvalidations map[*Model]*pendingInvalidation
+
+// add adds the given fields of the records of mi with the given ids to the
+// invalidations. All fields are invalidated if fields is nil.
+func (ci cacheInvalidations) add(mi *Model, ids []int64, fields []string) {
+	pi, ok := ci[mi]
+	if !ok {
+		pi = &pendingInvalidation{ids: make(map[int64]bool), fields: make(map[string]bool)}
+		ci[mi] = pi
+	}
+	for _, id := range ids {
+		pi.ids[id] = true
+	}
+	if fields == nil {
+		pi.allFields = true
+	}
+	for _, f := range fields {
+		pi.fields[f] = true
+	}
+}
+
+// list returns the invalidations of ci, one per model sorted by model name
+func (ci cacheInvalidations) list() []cacheInvalidation {
+	res := make([]cacheInvalidation, 0, len(ci))
+	for mi, pi := range ci {
+		inv := cacheInvalidation{Model: mi.name, IDs: make([]int64, 0, len(pi.ids))}
+		for id := range pi.ids {
+			inv.IDs = append(inv.IDs, id)
+		}
+		sort.Slice(inv.IDs, func(i, j int) bool { return inv.IDs[i] < inv.IDs[j] })
+		if !pi.allFields {
+			inv.Fields = make([]string, 0, len(pi.fields))
+			for f := range pi.fields {
+				inv.Fields = append(inv.Fields, f)
+			}
+			sort.Strings(inv.Fields)
+		}
+		res = append(res, inv)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Model < res[j].Model })
+	return res
+}
+
+// EnableCacheInvalidation makes this instance exchange cache invalidations
+// with all the instances connected to the same database, so that records
+// modified by any instance are removed from the caches that are shared
+// across environments, such as the cache of computed fields.
+//
+// Invalidations are sent on CacheInvalidationChannel when the transaction
+// that modified the records is committed. The database must be connected.
+func EnableCacheInvalidation() error {
+	err := Listen(CacheInvalidationChannel, func(payload string) {
+		var inv cacheInvalidation
+		if err := json.Unmarshal([]byte(payload), &inv); err != nil {
+			log.Warn("Unable to decode cache invalidation", "payload", payload, "error", err)
+			return
+		}
+		applyCacheInvalidation(inv)
+	})
+	if err != nil {
+		return err
+	}
+	cacheInvalidationEnabled = true
+	return nil
+}
+
+// sendCacheInvalidations sends the cache invalidations of the records
+// modified in the transaction of this Environment to all instances,
+// including this one. It must be called after the transaction is committed.
+func (env Environment) sendCacheInvalidations() {
+	for _, inv := range env.invalidations.list() {
+		sendCacheInvalidation(inv)
+	}
+}
+
+// sendCacheInvalidation sends the given invalidation to all instances if
+// cache invalidation is enabled, or only applies it to this instance.
+// Invalidations too large to be sent are widened to the whole model.
+func sendCacheInvalidation(inv cacheInvalidation) {
+	if !cacheInvalidationEnabled {
+		applyCacheInvalidation(inv)
+		return
+	}
+	data, err := json.Marshal(inv)
+	if err == nil && len(data) > MaxNotificationPayload {
+		inv.IDs, inv.Fields = nil, nil
+		data, err = json.Marshal(inv)
+	}
+	if err == nil {
+		err = Notify(CacheInvalidationChannel, string(data))
+	}
+	if err != nil {
+		log.Warn("Unable to send cache invalidation", "model", inv.Model, "error", err)
+		applyCacheInvalidation(inv)
+	}
+}
+
+// applyCacheInvalidation removes the records of the given invalidation, the
+// values of the non stored computed fields depending on them and the search
+// results of their model, from the caches shared across environments.
+//
+// Dependent fields reached through a path are invalidated for all records,
+// since the records they belong to cannot be found without the database.
+func applyCacheInvalidation(inv cacheInvalidation) {
+	mi, ok := Registry.Get(inv.Model)
+	if !ok {
+		return
+	}
+	sharedComputedCache.invalidateRecords(mi, "", inv.IDs)
+	sharedQueryCache.invalidateModel(mi)
+	var fields []*Field
+	if inv.Fields == nil {
+		for _, fi := range mi.fields.registryByJSON {
+			fields = append(fields, fi)
+		}
+	}
+	for _, fName := range inv.Fields {
+		if fi, ok := mi.fields.Get(fName); ok {
+			fields = append(fields, fi)
+		}
+	}
+	for _, fi := range fields {
+		for _, dep := range fi.dependencies {
+			if dep.stored {
+				continue
+			}
+			ids := inv.IDs
+			if dep.path != "" {
+				ids = nil
+			}
+			sharedComputedCache.invalidateRecords(dep.model, dep.fieldName, ids)
+		}
+	}
+}
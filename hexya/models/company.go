@@ -0,0 +1,106 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"github.com/hexya-erp/hexya/hexya/models/fieldtype"
+	"github.com/hexya-erp/hexya/hexya/models/security"
+)
+
+// companyRuleName is the name of the record rule added to
+// company dependent models by setupCompanyRules.
+const companyRuleName = "multi_company_rule"
+
+// companyModel is the handle of the Company model
+var companyModel = NewModelHandle("Company")
+
+// declareCompanyModel creates the Company model.
+//
+// Models with a "Company" many2one field to the Company model follow
+// the multi-company convention: their records are restricted to the
+// companies allowed by the context and new records belong to the current
+// company by default. See setupCompanyRules.
+func declareCompanyModel() {
+	company := NewModel("Company")
+	company.AddFields(map[string]FieldDefinition{
+		"Name":     CharField{String: "Company Name", Required: true, Unique: true},
+		"Parent":   Many2OneField{String: "Parent Company", RelationModel: company, Index: true},
+		"Children": One2ManyField{String: "Child Companies", RelationModel: company, ReverseFK: "Parent"},
+		"Currency": Many2OneField{RelationModel: Registry.MustGet("Currency"),
+			Help: "Main currency of the company"},
+		"Sequence": IntegerField{Default: DefaultValue(10),
+			Help: "Used to order companies in the company switcher"},
+		"Active": BooleanField{Default: DefaultValue(true)},
+	})
+	company.SetDefaultOrder("Sequence", "Name")
+
+	for _, method := range []string{"Read", "Load"} {
+		company.methods.MustGet(method).AllowGroup(security.GroupEveryone)
+	}
+}
+
+// setupCompanyRules applies the multi-company convention to all models
+// with a "Company" many2one field to the Company model:
+//
+// - A global record rule restricts their records to those without company
+// or belonging to one of the allowed companies of the context. Records
+// are not filtered if the context does not set any company.
+// - The Company field defaults to the current company.
+func setupCompanyRules() {
+	for _, model := range Registry.registryByName {
+		fi, ok := model.fields.Get("Company")
+		if !ok || fi.fieldType != fieldtype.Many2One || fi.relatedModelName != "Company" {
+			continue
+		}
+		if fi.defaultFunc == nil {
+			fi.defaultFunc = func(env Environment) interface{} {
+				return env.Company()
+			}
+		}
+		model.AddRecordRule(&RecordRule{
+			Name:          companyRuleName,
+			Global:        true,
+			ConditionFunc: companyRuleCondition,
+			Perms:         security.All,
+		})
+	}
+}
+
+// companyRuleCondition returns the condition of the multi-company
+// record rule for the given RecordSet.
+func companyRuleCondition(rs RecordSet) *Condition {
+	rc := rs.Collection()
+	companies := rc.env.context.AllowedCompanyIDs()
+	if len(companies) == 0 {
+		return nil
+	}
+	return rc.model.Field("Company").IsNull().Or().Field("Company").In(companies)
+}
+
+// Company returns the current company of this Environment, given by the
+// "company_id" key of its context. The returned RecordCollection is empty
+// if there is no current company.
+func (env Environment) Company() *RecordCollection {
+	return companyModel.Pool(env).withIds([]int64{env.context.CompanyID()})
+}
+
+// Companies returns the companies whose records can be accessed in this
+// Environment, given by the "allowed_company_ids" key of its context.
+// The current company is always included.
+func (env Environment) Companies() *RecordCollection {
+	return companyModel.Pool(env).withIds(env.context.AllowedCompanyIDs())
+}
+
+// WithCompany returns a copy of this Environment with the given company
+// as current company. The company is added to the allowed companies
+// of the context if needed.
+//
+// The context of this Environment is not modified.
+func (env Environment) WithCompany(company RecordSet) Environment {
+	company.Collection().EnsureOne()
+	companyID := company.Ids()[0]
+	env.context = env.context.WithKey("company_id", companyID)
+	env.context = env.context.WithKey("allowed_company_ids", env.context.AllowedCompanyIDs())
+	return env
+}
@@ -28,8 +28,13 @@ const (
 	sqlSep  = "__"
 )
 
+// JSONPathSep separates the field name from the keys of a path
+// inside a JSON field in condition field names, such as "Meta#color".
+const JSONPathSep = "#"
+
 type predicate struct {
 	exprs    []string
+	jsonPath []string
 	operator operator.Operator
 	arg      interface{}
 	cond     *Condition
@@ -156,7 +161,7 @@ func (c Condition) String() string {
 			res += fmt.Sprintf("(\n%s\n)\n", p.cond.String())
 			continue
 		}
-		res += fmt.Sprintf("%s %s %v\n", strings.Join(p.exprs, ExprSep), p.operator, p.arg)
+		res += fmt.Sprintf("%s %s %v\n", p.fieldPath(), p.operator, p.arg)
 	}
 	return res
 }
@@ -179,8 +184,8 @@ type ConditionStart struct {
 
 // Field adds a field path (dot separated) to this condition
 func (cs ConditionStart) Field(name string) *ConditionField {
-	newExprs := strings.Split(name, ExprSep)
-	cp := ConditionField{cs: cs}
+	newExprs, jsonPath := splitFieldPath(name)
+	cp := ConditionField{cs: cs, jsonPath: jsonPath}
 	cp.exprs = append(cp.exprs, newExprs...)
 	return &cp
 }
@@ -201,8 +206,9 @@ func (cs ConditionStart) FilteredOn(field string, condition *Condition) *Conditi
 // A ConditionField is a partial Condition when we have set
 // a field name in a predicate and are about to add an operator.
 type ConditionField struct {
-	cs    ConditionStart
-	exprs []string
+	cs       ConditionStart
+	exprs    []string
+	jsonPath []string
 }
 
 // FieldName returns the field name of this ConditionField
@@ -232,6 +238,7 @@ func (c ConditionField) AddOperator(op operator.Operator, data interface{}) *Con
 	}
 	cond.predicates = append(cond.predicates, predicate{
 		exprs:    c.exprs,
+		jsonPath: c.jsonPath,
 		operator: op,
 		arg:      data,
 		isNot:    c.cs.nextIsNot,
@@ -336,6 +343,21 @@ func (c ConditionField) ChildOf(data interface{}) *Condition {
 	return c.AddOperator(operator.ChildOf, data)
 }
 
+// Match appends the full-text search operator to the current Condition.
+// It matches the records whose field contains all the words of the given
+// text, according to the text search configuration of the field.
+func (c ConditionField) Match(data interface{}) *Condition {
+	return c.AddOperator(operator.Match, data)
+}
+
+// Similar appends the trigram similarity operator to the current Condition.
+// It matches the records whose field is similar to the given text, even
+// with typos. It requires the pg_trgm extension, which is created when a
+// model has fuzzy search enabled.
+func (c ConditionField) Similar(data interface{}) *Condition {
+	return c.AddOperator(operator.Similar, data)
+}
+
 // IsNull checks if the current condition field is null
 func (c ConditionField) IsNull() *Condition {
 	return c.AddOperator(operator.Equals, nil)
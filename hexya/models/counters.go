@@ -0,0 +1,127 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"fmt"
+
+	"github.com/hexya-erp/hexya/hexya/models/fieldtype"
+)
+
+// bootStrapCounters links each counter field to the reverse FK field of
+// its one2many, so that counters are updated when the FK is modified.
+func bootStrapCounters() {
+	for _, mi := range Registry.registryByName {
+		if mi.isMixin() {
+			continue
+		}
+		for _, fi := range mi.fields.registryByName {
+			if fi.counterOf == "" {
+				continue
+			}
+			o2m, ok := mi.fields.Get(fi.counterOf)
+			if !ok || o2m.fieldType != fieldtype.One2Many {
+				log.Panic("Counter field must count a one2many field of the same model", "model", mi.name,
+					"field", fi.name, "one2many", fi.counterOf)
+			}
+			if o2m.filter != nil {
+				log.Panic("Counter fields cannot count filtered one2many fields", "model", mi.name,
+					"field", fi.name, "one2many", fi.counterOf)
+			}
+			fkField := o2m.relatedModel.fields.MustGet(o2m.reverseFK)
+			fkField.counters = append(fkField.counters, fi)
+		}
+	}
+}
+
+// counterRefs returns for each FK field of the given FieldMap that
+// has counters, the number of records of this RecordCollection that
+// currently reference each related record.
+func (rc *RecordCollection) counterRefs(fMap FieldMap) map[*Field]map[int64]int64 {
+	res := make(map[*Field]map[int64]int64)
+	for _, fi := range rc.model.fields.registryByJSON {
+		if len(fi.counters) == 0 {
+			continue
+		}
+		if fMap != nil {
+			if _, exists := fMap.Get(fi.json, rc.model); !exists {
+				continue
+			}
+		}
+		res[fi] = rc.countByFK(fi)
+	}
+	return res
+}
+
+// countByFK returns the number of records of this RecordCollection
+// referencing each record of the related model of the given FK field.
+func (rc *RecordCollection) countByFK(fi *Field) map[int64]int64 {
+	res := make(map[int64]int64)
+	ids := rc.Ids()
+	if len(ids) == 0 {
+		return res
+	}
+	var rows []struct {
+		FK    int64
+		Count int64
+	}
+	query := fmt.Sprintf(`SELECT %s AS fk, COUNT(*) AS count FROM %s WHERE id IN (?) AND %s IS NOT NULL GROUP BY %s`,
+		fi.json, adapters[db.DriverName()].quoteTableName(rc.model.tableName), fi.json, fi.json)
+	rc.env.cr.Select(&rows, query, ids)
+	for _, row := range rows {
+		res[row.FK] = row.Count
+	}
+	return res
+}
+
+// incrementCounters adds the given delta times the number of referencing
+// records to the counters of the given FK field.
+func (rc *RecordCollection) incrementCounters(fi *Field, refs map[int64]int64, delta int64) {
+	adapter := adapters[db.DriverName()]
+	for _, counter := range fi.counters {
+		for id, num := range refs {
+			query := fmt.Sprintf(`UPDATE %s SET %s = COALESCE(%s, 0) + ? WHERE id = ?`,
+				adapter.quoteTableName(counter.model.tableName), counter.json, counter.json)
+			rc.env.cr.Execute(query, delta*num, id)
+			rc.env.cache.removeEntry(counter.model, id, counter.json)
+		}
+	}
+}
+
+// updateCountersOnCreate increments the counters of the records
+// referenced by the FK fields of the given created values.
+func (rc *RecordCollection) updateCountersOnCreate(fMap FieldMap) {
+	for _, fi := range rc.model.fields.registryByJSON {
+		if len(fi.counters) == 0 {
+			continue
+		}
+		val, exists := fMap.Get(fi.json, rc.model)
+		if !exists {
+			continue
+		}
+		if id, ok := val.(int64); ok && id != 0 {
+			rc.incrementCounters(fi, map[int64]int64{id: 1}, 1)
+		}
+	}
+}
+
+// updateCountersOnWrite updates the counters of the FK fields of oldRefs,
+// which are the references of the records before they were updated.
+func (rc *RecordCollection) updateCountersOnWrite(oldRefs map[*Field]map[int64]int64) {
+	for fi, refs := range oldRefs {
+		rc.incrementCounters(fi, refs, -1)
+		rc.incrementCounters(fi, rc.countByFK(fi), 1)
+	}
+}
+
+// recomputeCounter sets the value of the given counter field
+// of all records from the database.
+func recomputeCounter(fi *Field) {
+	adapter := adapters[db.DriverName()]
+	o2m := fi.model.fields.MustGet(fi.counterOf)
+	query := fmt.Sprintf(`UPDATE %s p SET %s = (SELECT COUNT(*) FROM %s c WHERE c.%s = p.id)`,
+		adapter.quoteTableName(fi.model.tableName), fi.json,
+		adapter.quoteTableName(o2m.relatedModel.tableName), o2m.jsonReverseFK)
+	dbExecuteNoTx(query)
+}
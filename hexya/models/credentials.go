@@ -0,0 +1,245 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"net/url"
+	"time"
+
+	"github.com/hexya-erp/hexya/hexya/models/security"
+	"github.com/hexya-erp/hexya/hexya/models/types"
+	"github.com/hexya-erp/hexya/hexya/models/types/dates"
+)
+
+var (
+	// UserLoginField is the field of the User model holding the login of users
+	UserLoginField = "Login"
+	// UserEmailField is the field of the User model holding the email
+	// address to which password reset links are sent.
+	UserEmailField = "Email"
+	// MaxLoginAttempts is the number of consecutive failed logins after
+	// which a user is locked out for LoginLockDuration.
+	MaxLoginAttempts int64 = 5
+	// LoginLockDuration is the duration during which a user cannot log in
+	// after MaxLoginAttempts consecutive failures. It is doubled at each
+	// new lock until the user logs in successfully, up to MaxLoginLockDuration.
+	LoginLockDuration = 5 * time.Minute
+	// MaxLoginLockDuration is the maximum duration of a login lock
+	MaxLoginLockDuration = 24 * time.Hour
+	// PasswordResetDuration is the validity of password reset tokens
+	PasswordResetDuration = time.Hour
+	// PasswordResetEmailFrom is the sender of password reset mails
+	PasswordResetEmailFrom = "noreply@localhost"
+)
+
+// PasswordResetURL returns the URL of the page at which users reset their
+// password with the given token. It can be overridden by the module serving
+// this page.
+var PasswordResetURL = func(token string) string {
+	return BaseURL() + "/web/reset_password?" + url.Values{"token": {token}}.Encode()
+}
+
+// declareUserCredentialModel creates the UserCredential model.
+//
+// A UserCredential holds the password hash of a user of the User model, which
+// is defined by modules, and the state of its brute-force protection and of
+// its password reset. Credentials can only be accessed by the admin.
+func declareUserCredentialModel() {
+	credential := NewModel("UserCredential")
+	credential.AddFields(map[string]FieldDefinition{
+		"UserID": IntegerField{String: "User ID", Required: true, Unique: true},
+		"PasswordHash": CharField{NoCopy: true,
+			Help: "Hash of the password, as given by security.HashPassword"},
+		"FailedAttempts":  IntegerField{NoCopy: true, Help: "Number of consecutive failed logins"},
+		"LockCount":       IntegerField{NoCopy: true, Help: "Number of locks since the last successful login"},
+		"LockedUntil":     DateTimeField{NoCopy: true},
+		"ResetTokenHash":  CharField{NoCopy: true, Help: "SHA-256 hash of the password reset token"},
+		"ResetExpiration": DateTimeField{String: "Reset Token Expiration", NoCopy: true},
+	})
+}
+
+// userCredential returns the UserCredential record of the user with the
+// given id, as superuser, creating it if create is true and it does not
+// exist. It returns an empty RecordCollection otherwise.
+func (env Environment) userCredential(uid int64, create bool) *RecordCollection {
+	credentials := env.Pool("UserCredential").Sudo()
+	cred := credentials.Search(credentials.model.Field("UserID").Equals(uid))
+	if cred.IsEmpty() && create {
+		cred = credentials.Call("Create", FieldMap{"UserID": uid}).(RecordSet).Collection()
+	}
+	return cred
+}
+
+// userByLogin returns the user with the given login, as superuser
+func (env Environment) userByLogin(login string) *RecordCollection {
+	users := env.Pool("User").Sudo()
+	return users.Search(users.model.Field(UserLoginField).Equals(login)).Limit(1)
+}
+
+// SetPassword sets the password of the user with the given id. The password
+// is stored as a hash given by security.HashPassword, and any pending
+// password reset is cancelled.
+func (env Environment) SetPassword(uid int64, password string) {
+	if password == "" {
+		log.Panic("Password cannot be empty", "uid", uid)
+	}
+	hash, err := security.HashPassword(password)
+	if err != nil {
+		log.Panic("Unable to hash password", "uid", uid, "error", err)
+	}
+	env.userCredential(uid, true).Call("Write", FieldMap{
+		"PasswordHash":    hash,
+		"ResetTokenHash":  "",
+		"ResetExpiration": dates.DateTime{},
+	})
+}
+
+// CheckUserPassword returns the id of the user with the given login if the
+// given password is their password.
+//
+// Failed attempts are counted: after MaxLoginAttempts consecutive failures,
+// the user is locked out for LoginLockDuration, during which the password
+// is not checked. The returned error is a security.UserNotFoundError if no
+// user has this login or if they have no password, and an
+// InvalidCredentialsError otherwise.
+//
+// As failed attempts are written, the transaction of this Environment
+// must be committed even if the authentication fails.
+func (env Environment) CheckUserPassword(login, password string) (int64, error) {
+	user := env.userByLogin(login)
+	if user.IsEmpty() {
+		return 0, security.UserNotFoundError(login)
+	}
+	cred := env.userCredential(user.ids[0], false)
+	if cred.IsEmpty() || cred.Get("PasswordHash").(string) == "" {
+		return 0, security.UserNotFoundError(login)
+	}
+	now := dates.Now()
+	if lockedUntil := cred.Get("LockedUntil").(dates.DateTime); lockedUntil.After(now.Time) {
+		return 0, security.InvalidCredentialsError(login)
+	}
+	hash := cred.Get("PasswordHash").(string)
+	if !security.CheckPassword(password, hash) {
+		attempts := cred.Get("FailedAttempts").(int64) + 1
+		values := FieldMap{"FailedAttempts": attempts}
+		if attempts >= MaxLoginAttempts {
+			lockCount := cred.Get("LockCount").(int64)
+			values["FailedAttempts"] = int64(0)
+			values["LockCount"] = lockCount + 1
+			values["LockedUntil"] = now.Add(loginLockDuration(lockCount))
+			log.Warn("User locked out after failed logins", "login", login, "attempts", attempts)
+		}
+		cred.Call("Write", values)
+		return 0, security.InvalidCredentialsError(login)
+	}
+	values := FieldMap{"FailedAttempts": int64(0), "LockCount": int64(0), "LockedUntil": dates.DateTime{}}
+	if security.PasswordNeedsRehash(hash) {
+		if newHash, err := security.HashPassword(password); err == nil {
+			values["PasswordHash"] = newHash
+		}
+	}
+	cred.Call("Write", values)
+	return user.ids[0], nil
+}
+
+// loginLockDuration returns the duration of the login lock of a user
+// who has already been locked lockCount times since their last login.
+func loginLockDuration(lockCount int64) time.Duration {
+	res := LoginLockDuration
+	for i := int64(0); i < lockCount && res < MaxLoginLockDuration; i++ {
+		res *= 2
+	}
+	if res > MaxLoginLockDuration {
+		return MaxLoginLockDuration
+	}
+	return res
+}
+
+// RequestPasswordReset sends to the user with the given login a mail with a
+// link to reset their password, valid for PasswordResetDuration, and returns the
+// reset token. It returns an empty string without error if no user has this
+// login or if they have no email address, so that callers do not disclose which
+// logins exist.
+func (env Environment) RequestPasswordReset(login string) string {
+	user := env.userByLogin(login)
+	if user.IsEmpty() {
+		return ""
+	}
+	email, _ := user.Get(UserEmailField).(string)
+	if email == "" {
+		return ""
+	}
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		log.Panic("Unable to generate password reset token", "error", err)
+	}
+	token := hex.EncodeToString(buf)
+	env.userCredential(user.ids[0], true).Call("Write", FieldMap{
+		"ResetTokenHash":  hashResetToken(token),
+		"ResetExpiration": dates.Now().Add(PasswordResetDuration),
+	})
+	link := PasswordResetURL(token)
+	env.SendMail(FieldMap{
+		"EmailFrom": PasswordResetEmailFrom,
+		"EmailTo":   email,
+		"Subject":   "Password reset",
+		"Body": fmt.Sprintf(`<p>A password reset has been requested for your account %s.</p>
+<p><a href="%s">Reset your password</a></p>
+<p>This link is valid for %s. If you did not request it, you can ignore this mail.</p>`,
+			html.EscapeString(login), link, PasswordResetDuration),
+		"ResModel": "User",
+		"ResID":    user.ids[0],
+	})
+	return token
+}
+
+// ResetPassword sets the password of the user to whom the given password
+// reset token has been sent. It returns false if the token is invalid or
+// expired. Tokens can only be used once.
+func (env Environment) ResetPassword(token, password string) bool {
+	if token == "" {
+		return false
+	}
+	credentials := env.Pool("UserCredential").Sudo()
+	cred := credentials.Search(credentials.model.Field("ResetTokenHash").Equals(hashResetToken(token)))
+	if cred.IsEmpty() || cred.Get("ResetExpiration").(dates.DateTime).Before(dates.Now().Time) {
+		return false
+	}
+	env.SetPassword(cred.Get("UserID").(int64), password)
+	cred.Call("Write", FieldMap{"FailedAttempts": int64(0), "LockCount": int64(0), "LockedUntil": dates.DateTime{}})
+	return true
+}
+
+// hashResetToken returns the hash under which the
+// given password reset token is stored.
+func hashResetToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// passwordBackend is the security.AuthBackend which authenticates
+// users with the passwords set by Environment.SetPassword.
+type passwordBackend struct{}
+
+// Authenticate the user defined by the given login and password
+func (pb passwordBackend) Authenticate(login, secret string, context *types.Context) (int64, error) {
+	var (
+		uid     int64
+		authErr error
+	)
+	err := ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+		uid, authErr = env.CheckUserPassword(login, secret)
+	})
+	if err != nil {
+		return 0, security.UserNotFoundError(login)
+	}
+	return uid, authErr
+}
+
+var _ security.AuthBackend = passwordBackend{}
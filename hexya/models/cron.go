@@ -0,0 +1,240 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/hexya-erp/hexya/hexya/models/security"
+	"github.com/hexya-erp/hexya/hexya/models/types"
+	"github.com/hexya-erp/hexya/hexya/models/types/dates"
+	"github.com/hexya-erp/hexya/hexya/tools/cronexpr"
+	"github.com/hexya-erp/hexya/hexya/tools/logging"
+)
+
+// declareCronJobModel creates the CronJob model.
+//
+// A CronJob calls a method of a model at regular intervals, given either by
+// an interval or by a cron expression. Due jobs are run by ProcessCronJobs,
+// which is called periodically by the cron worker of the server.
+func declareCronJobModel() {
+	cronJob := NewModel("CronJob")
+
+	cronJob.AddMethod("CheckMethod",
+		`CheckMethod checks that the model and method of this job exist.`,
+		func(rc *RecordCollection) {
+			model, ok := Registry.Get(rc.Get("Model").(string))
+			if !ok {
+				log.Panic("Unknown model in cron job", "job", rc.Get("Name"), "model", rc.Get("Model"))
+			}
+			if _, ok := model.methods.get(rc.Get("Method").(string)); !ok {
+				log.Panic("Unknown method in cron job", "job", rc.Get("Name"), "model", model.name, "method", rc.Get("Method"))
+			}
+		})
+
+	cronJob.AddMethod("CheckCronExpression",
+		`CheckCronExpression checks that the cron expression of this job is valid.`,
+		func(rc *RecordCollection) {
+			expression, _ := rc.Get("CronExpression").(string)
+			if expression == "" {
+				return
+			}
+			if _, err := cronexpr.Parse(expression); err != nil {
+				log.Panic("Invalid cron expression", "job", rc.Get("Name"), "error", err)
+			}
+		})
+
+	cronJob.AddFields(map[string]FieldDefinition{
+		"Name":   CharField{Required: true},
+		"Model":  CharField{Required: true, Help: "Name of the model of the method to call"},
+		"Method": CharField{Required: true, Constraint: cronJob.methods.MustGet("CheckMethod")},
+		"Arguments": TextField{
+			Help: "JSON array of the arguments of the method, e.g. [\"draft\", 30]"},
+		"UserID": IntegerField{String: "User ID", Required: true, Default: DefaultValue(security.SuperUserID),
+			Help: "ID of the user the method is called as"},
+		"IntervalNumber": IntegerField{String: "Repeat Every", Required: true, Default: DefaultValue(int64(1))},
+		"IntervalType": SelectionField{String: "Interval Unit", Required: true, Default: DefaultValue("days"),
+			Selection: types.Selection{"minutes": "Minutes", "hours": "Hours", "days": "Days", "weeks": "Weeks", "months": "Months"}},
+		"CronExpression": CharField{Constraint: cronJob.methods.MustGet("CheckCronExpression"),
+			Help: "Cron expression such as '0 2 * * *' in the local time of the server. If set, the interval is not used."},
+		"NextCall": DateTimeField{String: "Next Execution Date", Required: true, Index: true,
+			Default: func(env Environment) interface{} { return dates.Now() }},
+		"LastCall": DateTimeField{String: "Last Execution Date", ReadOnly: true, NoCopy: true},
+		"NumberCall": IntegerField{String: "Number of Calls", Default: DefaultValue(int64(-1)),
+			Help: "Number of times the job is called before being deactivated. Negative for no limit."},
+		"Active":    BooleanField{Default: DefaultValue(true)},
+		"Priority":  IntegerField{Default: DefaultValue(int64(5)), Help: "Due jobs with lower priority values are run first"},
+		"LastError": TextField{ReadOnly: true, NoCopy: true, Help: "Error of the last execution, if it failed"},
+	})
+	cronJob.SetDefaultOrder("Priority", "Name")
+
+	cronJob.AddMethod("Run",
+		`Run calls the method of this job in the current transaction, without
+		rescheduling it. It panics if the method panics.`,
+		func(rc *RecordCollection) {
+			rc.EnsureOne()
+			modelName, method := rc.Get("Model").(string), rc.Get("Method").(string)
+			records := rc.env.Pool(modelName).Sudo(rc.Get("UserID").(int64))
+			args := cronJobArguments(records.MethodType(method), rc.Get("Arguments").(string))
+			records.Call(method, args...)
+		})
+}
+
+// cronJobArguments returns the given JSON array of arguments decoded
+// with the types of the parameters of the given method type.
+func cronJobArguments(methType reflect.Type, arguments string) []interface{} {
+	if arguments == "" {
+		return nil
+	}
+	var rawArgs []json.RawMessage
+	if err := json.Unmarshal([]byte(arguments), &rawArgs); err != nil {
+		log.Panic("Cron job arguments must be a JSON array", "arguments", arguments, "error", err)
+	}
+	res := make([]interface{}, len(rawArgs))
+	for i, rawArg := range rawArgs {
+		argType, ok := methodArgType(methType, i)
+		if !ok {
+			log.Panic("Too many arguments for cron job method", "arguments", arguments, "expected", methType.NumIn()-1)
+		}
+		arg := reflect.New(argType)
+		if err := json.Unmarshal(rawArg, arg.Interface()); err != nil {
+			log.Panic("Unable to decode cron job argument", "argument", string(rawArg), "type", argType, "error", err)
+		}
+		res[i] = arg.Elem().Interface()
+	}
+	return res
+}
+
+// ProcessCronJobs runs all the active cron jobs whose next call is due
+// and returns the number of jobs that have been run.
+//
+// Each job runs in its own transaction, in which it is first claimed with
+// a row lock that other transactions skip. This function can therefore be
+// called concurrently by several server processes without running a job
+// twice. Jobs that fail are rolled back, their error is logged and stored
+// in their LastError field, and they are rescheduled as usual.
+//
+// ProcessCronJobs returns after the current job once StopProcessing has been
+// called.
+func ProcessCronJobs() int {
+	var count int
+	for !ProcessingStopped() {
+		var claimed bool
+		err := ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+			job := env.claimCronJob()
+			claimed = job != nil
+			if claimed {
+				job.runCronJob()
+			}
+		})
+		if err != nil {
+			log.Warn("Error while processing cron jobs", "error", err)
+			return count
+		}
+		if !claimed {
+			return count
+		}
+		count++
+	}
+	return count
+}
+
+// claimCronJob locks and returns the due cron job with the highest
+// priority that is not locked by another transaction, or nil if there
+// is none. SKIP LOCKED requires PostgreSQL 9.5.
+func (env Environment) claimCronJob() *RecordCollection {
+	cronModel := Registry.MustGet("CronJob")
+	var ids []int64
+	env.cr.Select(&ids, fmt.Sprintf(`SELECT id FROM %s WHERE active = TRUE AND next_call <= ?
+		ORDER BY priority, next_call, id LIMIT 1 FOR UPDATE SKIP LOCKED`,
+		adapters[db.DriverName()].quoteTableName(cronModel.tableName)), dates.Now())
+	if len(ids) == 0 {
+		return nil
+	}
+	return env.Pool(cronModel.name).withIds(ids)
+}
+
+// runCronJob runs this claimed cron job and reschedules it.
+//
+// The job is run inside a savepoint so that a failing job can
+// be rolled back while still being rescheduled.
+func (rc *RecordCollection) runCronJob() {
+	rc.EnsureOne()
+	start := dates.Now()
+	var lastError string
+	rc.env.cr.Execute("SAVEPOINT cron_job")
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				if err, ok := r.(error); ok && adapters[db.DriverName()].isSerializationError(err) {
+					// Let ExecuteInNewEnvironment retry the whole transaction
+					panic(r)
+				}
+				rc.env.cr.Execute("ROLLBACK TO SAVEPOINT cron_job")
+				rc.env.Cache().Clear()
+				log.Warn("Cron job failed", "job", rc.Get("Name"), "id", rc.ids[0])
+				lastError = logging.LogPanicData(r).Error()
+			}
+		}()
+		rc.Call("Run")
+		rc.env.cr.Execute("RELEASE SAVEPOINT cron_job")
+	}()
+
+	nextCall, active := rc.nextCronCall(start)
+	numberCall := rc.Get("NumberCall").(int64)
+	if numberCall > 0 {
+		numberCall--
+		active = active && numberCall != 0
+	}
+	rc.env.cr.Execute(fmt.Sprintf(`UPDATE %s SET next_call = ?, last_call = ?, number_call = ?, active = ?, last_error = ? WHERE id = ?`,
+		adapters[db.DriverName()].quoteTableName(rc.model.tableName)), nextCall, start, numberCall, active, lastError, rc.ids[0])
+	for col, value := range map[string]interface{}{
+		"next_call":   nextCall,
+		"last_call":   start,
+		"number_call": numberCall,
+		"active":      active,
+		"last_error":  lastError,
+	} {
+		rc.env.cache.updateEntry(rc.model, rc.ids[0], col, value)
+	}
+}
+
+// nextCronCall returns the first call of this cron job strictly after the
+// given time, so that calls missed while the server was down are skipped.
+// Intervals are counted from the current next call of the job to avoid
+// drifting. The second returned value is false if the job has no next call.
+func (rc *RecordCollection) nextCronCall(after dates.DateTime) (dates.DateTime, bool) {
+	nextCall := rc.Get("NextCall").(dates.DateTime)
+	if expression, _ := rc.Get("CronExpression").(string); expression != "" {
+		next := cronexpr.MustParse(expression).Next(after.In(time.Local).Time)
+		if next.IsZero() {
+			return nextCall, false
+		}
+		return dates.DateTime{Time: next.UTC()}, true
+	}
+	number := int(rc.Get("IntervalNumber").(int64))
+	if number <= 0 {
+		return nextCall, false
+	}
+	for !nextCall.Greater(after) {
+		switch rc.Get("IntervalType").(string) {
+		case "minutes":
+			nextCall = nextCall.Add(time.Duration(number) * time.Minute)
+		case "hours":
+			nextCall = nextCall.Add(time.Duration(number) * time.Hour)
+		case "days":
+			nextCall = nextCall.AddPeriod(dates.PeriodDay, number)
+		case "weeks":
+			nextCall = nextCall.AddPeriod(dates.PeriodWeek, number)
+		case "months":
+			nextCall = nextCall.AddPeriod(dates.PeriodMonth, number)
+		default:
+			return nextCall, false
+		}
+	}
+	return nextCall, true
+}
@@ -0,0 +1,212 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"math"
+	"strconv"
+
+	"github.com/hexya-erp/hexya/hexya/models/fieldtype"
+	"github.com/hexya-erp/hexya/hexya/models/security"
+	"github.com/hexya-erp/hexya/hexya/models/types"
+	"github.com/hexya-erp/hexya/hexya/models/types/dates"
+	"github.com/hexya-erp/hexya/hexya/tools/nbutils"
+)
+
+// declareCurrencyModel creates the Currency and CurrencyRate models.
+//
+// The rate of a currency at a given date is the rate of its latest
+// CurrencyRate at or before this date, or 1 if there is none. Amounts are
+// converted between currencies through their rates to the same reference
+// currency, which has a rate of 1.
+func declareCurrencyModel() {
+	currency := NewModel("Currency")
+	currencyRate := NewModel("CurrencyRate")
+
+	currency.AddFields(map[string]FieldDefinition{
+		"Name": CharField{String: "Currency", Required: true, Unique: true, Size: 3,
+			Help: "Currency code (ISO 4217)"},
+		"Symbol": CharField{Required: true, Help: "Currency sign, used when printing amounts"},
+		"Rounding": FloatField{String: "Rounding Factor", Digits: nbutils.Digits{Precision: 12, Scale: 6},
+			Default: DefaultValue(0.01), Help: "Amounts in this currency are rounded to a multiple of this factor"},
+		"DecimalPlaces": IntegerField{Compute: currency.Methods().MustGet("ComputeDecimalPlaces"),
+			Depends: []string{"Rounding"}},
+		"Position": SelectionField{Selection: types.Selection{"before": "Before Amount", "after": "After Amount"},
+			Default: DefaultValue("after"), Help: "Position of the currency symbol relatively to the amount"},
+		"Active": BooleanField{Default: DefaultValue(true)},
+		"Rates":  One2ManyField{RelationModel: currencyRate, ReverseFK: "Currency"},
+		"Rate": FloatField{String: "Current Rate", Compute: currency.Methods().MustGet("ComputeRate"),
+			Depends: []string{"Rates", "Rates.Rate", "Rates.Name"}, Digits: nbutils.Digits{Precision: 12, Scale: 6},
+			Help: "Rate of the currency at the date given by the 'date' key of the context, or today"},
+	})
+	currency.SetDefaultOrder("Name")
+
+	currencyRate.AddFields(map[string]FieldDefinition{
+		"Name": DateField{String: "Date", Required: true, Index: true,
+			Default: func(env Environment) interface{} { return dates.TodayIn(env.Context().TZ()) }},
+		"Rate": FloatField{Required: true, Digits: nbutils.Digits{Precision: 12, Scale: 6}, Default: DefaultValue(1.0),
+			Help: "Number of units of the currency for one unit of the reference currency"},
+		"Currency": Many2OneField{RelationModel: currency, Required: true, OnDelete: Cascade, Index: true},
+	})
+	currencyRate.SetDefaultOrder("Name DESC")
+	currencyRate.AddSQLConstraint("unique_name_per_day", "UNIQUE (name, currency_id)",
+		"Only one currency rate per day is allowed")
+
+	currency.AddMethod("ComputeDecimalPlaces",
+		`ComputeDecimalPlaces returns the number of decimal places given by the rounding factor.`,
+		func(rc *RecordCollection) FieldMap {
+			rounding := rc.Get("Rounding").(float64)
+			if rounding <= 0 || rounding >= 1 {
+				return FieldMap{"DecimalPlaces": int64(0)}
+			}
+			return FieldMap{"DecimalPlaces": int64(math.Ceil(-math.Log10(rounding)))}
+		}).AllowGroup(security.GroupEveryone)
+
+	currency.AddMethod("ComputeRate",
+		`ComputeRate returns the rate of the currency at the date of the context.`,
+		func(rc *RecordCollection) FieldMap {
+			date := rc.Env().Context().GetDate("date")
+			if date.IsZero() {
+				date = dates.TodayIn(rc.Env().Context().TZ())
+			}
+			return FieldMap{"Rate": rc.Call("RateAt", date)}
+		}).AllowGroup(security.GroupEveryone)
+
+	currency.AddMethod("RateAt",
+		`RateAt returns the rate of this currency at the given date, that is the
+		rate of its latest CurrencyRate at or before this date, or 1 if there is none.`,
+		func(rc *RecordCollection, date dates.Date) float64 {
+			rc.EnsureOne()
+			rate := rc.Env().Pool(currencyRate.name).Sudo().Search(
+				currencyRate.Field("Currency").Equals(rc.ids[0]).
+					And().Field("Name").LowerOrEqual(date)).Limit(1)
+			if rate.IsEmpty() {
+				return 1
+			}
+			return rate.Get("Rate").(float64)
+		}).AllowGroup(security.GroupEveryone)
+
+	currency.AddMethod("Round",
+		`Round returns the given amount rounded to the rounding factor of this currency.`,
+		func(rc *RecordCollection, amount float64) float64 {
+			rc.EnsureOne()
+			return currencyRound(rc.Get("Rounding").(float64), amount)
+		}).AllowGroup(security.GroupEveryone)
+
+	currency.AddMethod("IsZero",
+		`IsZero returns true if the given amount is zero once rounded in this currency.
+		Amounts should always be checked with IsZero rather than compared with 0, since
+		float computations may leave tiny residues.`,
+		func(rc *RecordCollection, amount float64) bool {
+			return rc.Call("Round", amount).(float64) == 0
+		}).AllowGroup(security.GroupEveryone)
+
+	currency.AddMethod("CompareAmounts",
+		`CompareAmounts compares the given amounts once rounded in this currency.
+		It returns -1 if amount1 is lower than amount2, 1 if it is greater and 0 if
+		both are equal.`,
+		func(rc *RecordCollection, amount1, amount2 float64) int {
+			diff := rc.Call("Round", amount1-amount2).(float64)
+			switch {
+			case diff < 0:
+				return -1
+			case diff > 0:
+				return 1
+			}
+			return 0
+		}).AllowGroup(security.GroupEveryone)
+
+	currency.AddMethod("Convert",
+		`Convert returns the given amount in this currency converted into the
+		toCurrency currency at the rates of the given date. The result is rounded
+		in toCurrency if round is true.`,
+		func(rc *RecordCollection, amount float64, toCurrency RecordSet, date dates.Date, round bool) float64 {
+			rc.EnsureOne()
+			to := toCurrency.Collection()
+			to.EnsureOne()
+			res := amount
+			if !rc.Equals(to) {
+				res = amount * to.Call("RateAt", date).(float64) / rc.Call("RateAt", date).(float64)
+			}
+			if round {
+				res = to.Call("Round", res).(float64)
+			}
+			return res
+		}).AllowGroup(security.GroupEveryone)
+}
+
+// currencyRound returns the given amount rounded
+// to a multiple of the given rounding factor.
+func currencyRound(rounding, amount float64) float64 {
+	if rounding <= 0 {
+		return amount
+	}
+	res := nbutils.Round(amount, rounding)
+	// Remove the binary representation noise of multiplied roundings
+	if decimals := math.Ceil(-math.Log10(rounding)); decimals > 0 {
+		res, _ = strconv.ParseFloat(strconv.FormatFloat(res, 'f', int(decimals), 64), 64)
+	}
+	return res
+}
+
+// roundMonetaryFields rounds the values of the monetary fields of this
+// RecordCollection that are in fMap, or whose currency field is in fMap,
+// to the rounding of their currency.
+//
+// Values are rounded after being written since the currency of
+// a record may be given by a related field. Records are only
+// updated again if a value actually needs rounding.
+func (rc *RecordCollection) roundMonetaryFields(fMap FieldMap) {
+	var fields []*Field
+	for _, fi := range rc.model.fields.registryByName {
+		if fi.fieldType != fieldtype.Monetary || !fi.isStored() {
+			continue
+		}
+		_, valueSet := fMap.Get(fi.name, rc.model)
+		_, currencySet := fMap.Get(fi.currencyField, rc.model)
+		if valueSet || currencySet {
+			fields = append(fields, fi)
+		}
+	}
+	if len(fields) == 0 {
+		return
+	}
+	for _, rec := range rc.Records() {
+		rounded := make(FieldMap)
+		for _, fi := range fields {
+			currency := rec.Get(fi.currencyField).(RecordSet).Collection()
+			if currency.IsEmpty() {
+				continue
+			}
+			value := rec.Get(fi.name).(float64)
+			if res := currencyRound(currency.Sudo().Get("Rounding").(float64), value); res != value {
+				rounded[fi.json] = res
+			}
+		}
+		if len(rounded) > 0 {
+			rec.doUpdate(rounded)
+		}
+	}
+}
+
+// roundAggregates rounds the aggregated values of the monetary
+// fields of the given row values to the digits of their field.
+func (rc *RecordCollection) roundAggregates(vals map[string]interface{}) {
+	for field, value := range vals {
+		fi, ok := rc.model.fields.Get(field)
+		if !ok || fi.fieldType != fieldtype.Monetary || fi.digits == (nbutils.Digits{}) {
+			continue
+		}
+		var amount float64
+		switch v := value.(type) {
+		case []byte:
+			amount, _ = strconv.ParseFloat(string(v), 64)
+		case nil:
+			continue
+		default:
+			amount, _ = nbutils.CastToFloat(v)
+		}
+		vals[field] = nbutils.Round(amount, math.Pow10(-int(fi.digits.Scale)))
+	}
+}
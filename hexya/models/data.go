@@ -6,6 +6,7 @@ package models
 import (
 	"encoding/base64"
 	"encoding/csv"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
@@ -13,11 +14,17 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/beevik/etree"
 	"github.com/hexya-erp/hexya/hexya/models/fieldtype"
 	"github.com/hexya-erp/hexya/hexya/models/security"
+	"github.com/hexya-erp/hexya/hexya/tools/expr"
 )
 
 // LoadCSVDataFile loads the data of the given file into the database.
+//
+// External IDs of the file are qualified with the name of the module,
+// which is the name of the directory of the file. References to
+// external IDs of other modules must be qualified explicitly.
 func LoadCSVDataFile(fileName string) {
 	csvFile, err := os.Open(fileName)
 	defer csvFile.Close()
@@ -25,6 +32,7 @@ func LoadCSVDataFile(fileName string) {
 		log.Panic("Unable to open CSV data file", "error", err, "fileName", fileName)
 	}
 
+	moduleName := filepath.Base(filepath.Dir(fileName))
 	elements := strings.Split(filepath.Base(fileName), "_")
 	modelName := strings.Split(elements[0], ".")[0]
 	modelName = strings.TrimLeft(modelName, "01234567890-")
@@ -63,24 +71,82 @@ func LoadCSVDataFile(fileName string) {
 				break
 			}
 
-			values := getRecordValuesMap(headers, modelName, record, env, line, fileName)
+			values := getRecordValuesMap(headers, moduleName, modelName, record, env, line, fileName)
+			loadRecord(env, modelName, qualifyExternalID(moduleName, values["id"].(string)), values, version, update, false)
+			line++
+		}
+	})
+	if err != nil {
+		log.Panic("Error while loading data", "error", err)
+	}
+}
 
-			externalID := values["id"]
-			delete(values, "id")
-			values["hexya_external_id"] = externalID
-			values["hexya_version"] = version
-			// We deliberately call Search directly without Call so as not to be polluted by Search overrides
-			// such as "Active test".
-			rec := rc.Search(rc.Model().Field("HexyaExternalID").Equals(externalID)).Limit(1)
-			switch {
-			case rec.Len() == 0:
-				rc.Call("Create", values)
-			case rec.Len() == 1:
-				if version > rec.Get("HexyaVersion").(int) || update {
-					rec.Call("Write", values)
+// LoadXMLDataFile loads the records of the given XML data file into the database.
+//
+// Records are declared inside data tags as follows:
+//
+//     <hexya>
+//         <data noupdate="true">
+//             <record id="user_peter" model="User">
+//                 <field name="Name">Peter</field>
+//                 <field name="Profile" ref="profile_peter"/>
+//                 <field name="Tags" ref="tag_book|tag_film"/>
+//                 <field name="Avatar" file="img/peter.png"/>
+//                 <field name="Birthday" eval="addYears(today(), -30)"/>
+//             </record>
+//         </data>
+//     </hexya>
+//
+// Field values are parsed the same way as in CSV data files. External IDs are
+// qualified with the module name as in LoadCSVDataFile.
+//
+// The value of a field with an eval attribute is the result of the given
+// expression (see RecordCollection.Evaluate). External IDs given to the ref()
+// function of these expressions are also qualified with the module name.
+//
+// Records that already exist in the database are updated with the values of
+// the file, unless they were created from a data tag with the noupdate
+// attribute set, in which case they are never modified again.
+func LoadXMLDataFile(fileName string) {
+	doc := etree.NewDocument()
+	if err := doc.ReadFromFile(fileName); err != nil {
+		log.Panic("Unable to open XML data file", "error", err, "fileName", fileName)
+	}
+	moduleName := filepath.Base(filepath.Dir(fileName))
+
+	err := ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+		for _, dataTag := range doc.FindElements("hexya/data") {
+			noUpdate, _ := strconv.ParseBool(dataTag.SelectAttrValue("noupdate", "false"))
+			for i, recordTag := range dataTag.SelectElements("record") {
+				modelName := recordTag.SelectAttrValue("model", "")
+				model, ok := Registry.Get(modelName)
+				if !ok {
+					log.Panic("Unknown model in XML data file", "fileName", fileName, "record", i, "model", modelName)
+				}
+				headers := []string{"id"}
+				record := []string{recordTag.SelectAttrValue("id", "")}
+				evals := make(map[string]string)
+				for _, fieldTag := range recordTag.SelectElements("field") {
+					if fieldTag.SelectAttr("eval") != nil {
+						evals[model.JSONizeFieldName(fieldTag.SelectAttrValue("name", ""))] = fieldTag.SelectAttrValue("eval", "")
+						continue
+					}
+					headers = append(headers, model.JSONizeFieldName(fieldTag.SelectAttrValue("name", "")))
+					value := fieldTag.Text()
+					switch {
+					case fieldTag.SelectAttr("ref") != nil:
+						value = fieldTag.SelectAttrValue("ref", "")
+					case fieldTag.SelectAttr("file") != nil:
+						value = fieldTag.SelectAttrValue("file", "")
+					}
+					record = append(record, value)
 				}
+				values := getRecordValuesMap(headers, moduleName, modelName, record, env, i+1, fileName)
+				for field, expression := range evals {
+					values[field] = env.Pool(modelName).evaluate(expression, expr.Vars{"ref": refFunc(env, moduleName)})
+				}
+				loadRecord(env, modelName, qualifyExternalID(moduleName, values["id"].(string)), values, 0, true, noUpdate)
 			}
-			line++
 		}
 	})
 	if err != nil {
@@ -88,7 +154,66 @@ func LoadCSVDataFile(fileName string) {
 	}
 }
 
-func getRecordValuesMap(headers []string, modelName string, record []string, env Environment, line int, fileName string) FieldMap {
+// loadRecord creates the record with the given externalID and values in the given model,
+// or updates it if it already exists and either version is greater than the
+// version in the database or update is true.
+//
+// Records created with noUpdate set are never updated afterwards.
+func loadRecord(env Environment, modelName, externalID string, values FieldMap, version int, update, noUpdate bool) {
+	rc := env.Pool(modelName)
+	delete(values, "id")
+	values["hexya_external_id"] = externalID
+	values["hexya_version"] = version
+	// We deliberately call Search directly without Call so as not to be polluted by Search overrides
+	// such as "Active test".
+	rec := rc.Search(rc.Model().Field("HexyaExternalID").Equals(externalID)).Limit(1)
+	switch {
+	case rec.Len() == 0:
+		rec = rc.Call("Create", values).(RecordSet).Collection()
+	case isNoUpdateExternalID(env, externalID):
+	case version > rec.Get("HexyaVersion").(int) || update:
+		rec.Call("Write", values)
+	}
+	registerExternalID(env, externalID, rec, noUpdate)
+}
+
+// isNoUpdateExternalID returns true if the record with the given
+// externalID has been loaded with the noupdate flag.
+func isNoUpdateExternalID(env Environment, externalID string) bool {
+	modelData := env.Pool("ModelData")
+	existing := modelData.Search(modelData.Model().Field("Name").Equals(externalID).And().Field("NoUpdate").Equals(true))
+	return existing.Len() > 0
+}
+
+// qualifyExternalID returns the given externalID prefixed with the given
+// module name, unless it is already qualified.
+func qualifyExternalID(moduleName, externalID string) string {
+	if externalID == "" || strings.Contains(externalID, ".") {
+		return externalID
+	}
+	return fmt.Sprintf("%s.%s", moduleName, externalID)
+}
+
+// registerExternalID records in the ModelData table that the given
+// externalID references the given record, if it is not already the case.
+// noUpdate tells whether this record must be protected from further updates.
+func registerExternalID(env Environment, externalID string, rec *RecordCollection, noUpdate bool) {
+	modelData := env.Pool("ModelData")
+	existing := modelData.Search(modelData.Model().Field("Name").Equals(externalID))
+	if existing.Len() > 0 {
+		return
+	}
+	modelData.Call("Create", FieldMap{
+		"Name":  externalID,
+		"Model": rec.ModelName(),
+		"ResID":    rec.Ids()[0],
+		"NoUpdate": noUpdate,
+	})
+}
+
+// getRecordValuesMap returns the values of the given CSV record as a FieldMap.
+// External IDs in relation fields are qualified with moduleName if needed.
+func getRecordValuesMap(headers []string, moduleName, modelName string, record []string, env Environment, line int, fileName string) FieldMap {
 	values := make(map[string]interface{})
 	for i := 0; i < len(headers); i++ {
 		fi := Registry.MustGet(modelName).getRelatedFieldInfo(headers[i])
@@ -104,7 +229,7 @@ func getRecordValuesMap(headers []string, modelName string, record []string, env
 			if err != nil {
 				log.Panic("Error while converting integer", "fileName", fileName, "line", line, "field", headers[i], "value", record[i], "error", err)
 			}
-		case fi.fieldType == fieldtype.Float:
+		case fi.fieldType == fieldtype.Float, fi.fieldType == fieldtype.Monetary:
 			val, err = strconv.ParseFloat(record[i], 64)
 			if err != nil {
 				log.Panic("Error while converting float", "fileName", fileName, "line", line, "field", headers[i], "value", record[i], "error", err)
@@ -112,7 +237,7 @@ func getRecordValuesMap(headers []string, modelName string, record []string, env
 		case fi.fieldType.IsFKRelationType():
 			val = nil
 			if record[i] != "" {
-				relRC := env.Pool(fi.relatedModelName).Search(fi.relatedModel.Field("HexyaExternalID").Equals(record[i]))
+				relRC := env.Pool(fi.relatedModelName).Search(fi.relatedModel.Field("HexyaExternalID").Equals(qualifyExternalID(moduleName, record[i])))
 				if relRC.Len() != 1 {
 					log.Panic("Unable to find related record from external ID", "fileName", fileName, "line", line, "field", headers[i], "value", record[i])
 				}
@@ -120,6 +245,9 @@ func getRecordValuesMap(headers []string, modelName string, record []string, env
 			}
 		case fi.fieldType == fieldtype.Many2Many:
 			ids := strings.Split(record[i], "|")
+			for j, id := range ids {
+				ids[j] = qualifyExternalID(moduleName, id)
+			}
 			relRC := env.Pool(fi.relatedModelName).Search(fi.relatedModel.Field("HexyaExternalID").In(ids))
 			val = relRC.Ids()
 		case fi.fieldType == fieldtype.Binary:
@@ -16,15 +16,18 @@ package models
 
 import (
 	"database/sql"
+	"strings"
 	"time"
 
+	"github.com/hexya-erp/hexya/hexya/metrics"
 	"github.com/hexya-erp/hexya/hexya/models/operator"
 	"github.com/jmoiron/sqlx"
 )
 
 var (
-	db       *sqlx.DB
-	adapters map[string]dbAdapter
+	db         *sqlx.DB
+	dbConnData string
+	adapters   map[string]dbAdapter
 )
 
 // ConnectionParams are the database agnostic parameters to connect to the database
@@ -42,10 +45,13 @@ type ConnectionParams struct {
 
 // A ColumnData holds information from the db schema about one column
 type ColumnData struct {
-	ColumnName    string
-	DataType      string
-	IsNullable    string
-	ColumnDefault sql.NullString
+	ColumnName             string         `db:"column_name"`
+	DataType               string         `db:"data_type"`
+	IsNullable             string         `db:"is_nullable"`
+	ColumnDefault          sql.NullString `db:"column_default"`
+	CharacterMaximumLength sql.NullInt64  `db:"character_maximum_length"`
+	NumericPrecision       sql.NullInt64  `db:"numeric_precision"`
+	NumericScale           sql.NullInt64  `db:"numeric_scale"`
 }
 
 type dbAdapter interface {
@@ -70,6 +76,16 @@ type dbAdapter interface {
 	quoteTableName(string) string
 	// indexExists returns true if an index with the given name exists in the given table
 	indexExists(table string, name string) bool
+	// indexes returns a list of all indexes of the given table matching the given SQL pattern
+	indexes(table string, pattern string) []string
+	// jsonPathSQL returns the SQL expression of the value at the given path inside
+	// the given JSON expression, cast to the type of the given argument, and
+	// the SQL argument of the path
+	jsonPathSQL(expr string, path []string, arg interface{}) (string, interface{})
+	// indexComment returns the comment of the index with the given name
+	indexComment(name string) string
+	// commentIndexSQL returns the SQL statement that sets the comment of the given index
+	commentIndexSQL(name, comment string) string
 	// constraintExists returns true if a constraint with the given name exists
 	constraintExists(name string) bool
 	// constraints returns a list of all constraints matching the given SQL pattern
@@ -94,6 +110,75 @@ type dbAdapter interface {
 	// isSerializationError returns true if the given error is a serialization error
 	// and that the failed transaction should be retried.
 	isSerializationError(err error) bool
+	// createExtension creates the DB extension with the given name if it does not exist
+	createExtension(name string)
+	// unaccentSQL returns the SQL expression of the given expression without accents
+	unaccentSQL(expr string) string
+	// unaccentFunctionSQL returns the SQL statement that creates the function used
+	// by unaccentSQL
+	unaccentFunctionSQL() string
+	// unaccentIndexSQL returns the SQL statement that creates the index with the
+	// given name for case-insensitive searches without accents on the given column
+	// of the given table.
+	unaccentIndexSQL(name, table, column string) string
+	// collateSQL returns the SQL expression of the given expression with the given collation
+	collateSQL(expr, collation string) string
+	// textSearchVectorSQL returns the SQL expression of the text search vector
+	// of the given expression with the given text search configuration
+	textSearchVectorSQL(expr, config string) string
+	// textSearchMatchSQL returns the SQL expression that matches the given text search
+	// vector with the plain text query given as placeholder
+	textSearchMatchSQL(vector, config string) string
+	// textSearchRankSQL returns the SQL expression of the rank of the given text search
+	// vector for the plain text query given as placeholder
+	textSearchRankSQL(vector, config string) string
+	// textSearchTriggerSQL returns the SQL statements that create or replace the trigger
+	// setting each given column of the given table to its vector expression, in which
+	// the inserted or updated row is referred to as NEW.
+	textSearchTriggerSQL(table string, vectors map[string]string) []string
+	// dropTextSearchTriggerSQL returns the SQL statements that drop the text search
+	// trigger of the given table if it exists.
+	dropTextSearchTriggerSQL(table string) []string
+	// similaritySQL returns the SQL expression of the trigram similarity of
+	// the given expression with the text given as placeholder.
+	similaritySQL(expr string) string
+	// trigramIndexSQL returns the SQL statement that creates the trigram index
+	// with the given name on the given column of the given table.
+	trigramIndexSQL(name, table, column string) string
+	// columnComment returns the comment of the given column of the given table
+	columnComment(table, column string) string
+	// commentColumnSQL returns the SQL statement that sets the comment of the given column
+	commentColumnSQL(table, column, comment string) string
+	// tryAdvisoryLockSQL returns the SQL query that tries to take the session advisory
+	// lock whose key is given as placeholder. The query returns true if the lock is taken.
+	tryAdvisoryLockSQL() string
+	// advisoryUnlockSQL returns the SQL query that releases the session advisory
+	// lock whose key is given as placeholder.
+	advisoryUnlockSQL() string
+	// advisoryLockHeldSQL returns the SQL query that returns true if the session
+	// advisory lock whose key is given as placeholder is held by any session.
+	advisoryLockHeldSQL() string
+	// createSequenceSQL returns the SQL query that creates a DB sequence with the
+	// given name, starting at start and incremented by increment.
+	createSequenceSQL(name string, start, increment int64) string
+	// dropSequenceSQL returns the SQL query that drops the DB sequence with the
+	// given name if it exists.
+	dropSequenceSQL(name string) string
+	// nextSequenceValueSQL returns the SQL query that returns the next value
+	// of the DB sequence whose name is given as placeholder.
+	nextSequenceValueSQL() string
+	// notifySQL returns the SQL query that sends a notification on the
+	// database channel given as first placeholder with the payload given
+	// as second placeholder.
+	notifySQL() string
+	// listen calls handler with the payload of each notification sent on
+	// the given database channel, using its own connection to the database
+	// described by connData.
+	listen(connData, channel string, handler func(string)) error
+	// peekSequenceValueSQL returns the SQL query that returns the value that the next
+	// call to nextval will return for the DB sequence whose name is given as placeholder,
+	// without consuming it. The query returns no row if the sequence does not exist.
+	peekSequenceValueSQL() string
 }
 
 // registerDBAdapter adds a adapter to the adapters registry
@@ -102,36 +187,132 @@ func registerDBAdapter(name string, adapter dbAdapter) {
 	adapters[name] = adapter
 }
 
+// QueryBudget is the default maximum number of SQL queries that can be
+// executed within a single transaction. When the budget is exceeded, a
+// warning is logged or, if Testing is true, the transaction panics so that
+// N+1 query regressions make tests fail. 0 means no limit.
+var QueryBudget int
+
+// SlowQueryThreshold is the duration above which an SQL query is reported
+// as slow, with the model method that executed it. 0 means no report.
+var SlowQueryThreshold time.Duration
+
+// QueryStats are the statistics of the SQL queries executed in a transaction
+type QueryStats struct {
+	// Count is the number of queries
+	Count int
+	// Duration is the cumulative execution time of the queries
+	Duration time.Duration
+	// SlowQueries is the number of queries that took longer
+	// than SlowQueryThreshold
+	SlowQueries int
+}
+
 // Cursor is a wrapper around a database transaction
 type Cursor struct {
-	tx *sqlx.Tx
+	tx          *sqlx.Tx
+	queryCount  int
+	queryBudget int
+	queryTime   time.Duration
+	slowQueries int
 }
 
 // Execute a query without returning any rows. It panics in case of error.
 // The args are for any placeholder parameters in the query.
 func (c *Cursor) Execute(query string, args ...interface{}) sql.Result {
+	c.countQuery(query)
+	defer c.timeQuery(query, time.Now())
 	return dbExecute(c.tx, query, args...)
 }
 
 // Get queries a row into the database and maps the result into dest.
 // The query must return only one row. Get panics on errors
 func (c *Cursor) Get(dest interface{}, query string, args ...interface{}) {
+	c.countQuery(query)
+	defer c.timeQuery(query, time.Now())
 	dbGet(c.tx, dest, query, args...)
 }
 
 // Select queries multiple rows and map the result into dest which must be a slice.
 // Select panics on errors.
 func (c *Cursor) Select(dest interface{}, query string, args ...interface{}) {
+	c.countQuery(query)
+	defer c.timeQuery(query, time.Now())
 	dbSelect(c.tx, dest, query, args...)
 }
 
+// query executes the given query and returns the resulting rows.
+// It panics in case of error.
+func (c *Cursor) query(query string, args ...interface{}) *sqlx.Rows {
+	c.countQuery(query)
+	defer c.timeQuery(query, time.Now())
+	return dbQuery(c.tx, query, args...)
+}
+
+// QueryCount returns the number of SQL queries executed so far in this
+// Cursor's transaction.
+func (c *Cursor) QueryCount() int {
+	return c.queryCount
+}
+
+// QueryStats returns the statistics of the SQL queries
+// executed so far in this Cursor's transaction.
+func (c *Cursor) QueryStats() QueryStats {
+	return QueryStats{
+		Count:       c.queryCount,
+		Duration:    c.queryTime,
+		SlowQueries: c.slowQueries,
+	}
+}
+
+// SetQueryBudget sets the maximum number of SQL queries of this Cursor's
+// transaction, overriding QueryBudget. 0 means no limit.
+func (c *Cursor) SetQueryBudget(budget int) {
+	c.queryBudget = budget
+}
+
+// countQuery increments the query counter of this Cursor and
+// reports the given query if it exceeds the query budget.
+func (c *Cursor) countQuery(query string) {
+	c.queryCount++
+	if c.queryBudget == 0 || c.queryCount != c.queryBudget+1 {
+		return
+	}
+	if Testing {
+		log.Panic("SQL query budget exceeded", "budget", c.queryBudget, "query", query)
+	}
+	log.Warn("SQL query budget exceeded", "budget", c.queryBudget, "query", query)
+}
+
+// timeQuery adds the execution time of the given query started at start
+// to the statistics of this Cursor, to the metrics and to the current trace,
+// and reports it if it is slow.
+func (c *Cursor) timeQuery(query string, start time.Time) {
+	duration := time.Now().Sub(start)
+	c.queryTime += duration
+	method := currentMethod()
+	model := method
+	if i := strings.Index(method, "."); i >= 0 {
+		model = method[:i]
+	}
+	metrics.SQLQueries.WithLabelValues(model).Inc()
+	metrics.SQLQueryDuration.WithLabelValues(model).Observe(duration.Seconds())
+	traceQuery(query, start, duration, method)
+	if SlowQueryThreshold == 0 || duration < SlowQueryThreshold {
+		return
+	}
+	c.slowQueries++
+	log.Warn("Slow SQL query", "query", query, "duration", duration, "method", method)
+}
+
 // newCursor returns a new db cursor on the given database
 func newCursor(db *sqlx.DB) *Cursor {
 	adapter := adapters[db.DriverName()]
 	tx := db.MustBegin()
 	dbExecute(tx, adapter.setTransactionIsolation())
 	return &Cursor{
-		tx: tx,
+		tx:          tx,
+		queryBudget: QueryBudget,
 	}
 }
 
@@ -140,6 +321,7 @@ func DBConnect(driver string, params ConnectionParams) {
 	adapter := adapters[driver]
 	connData := adapter.connectionString(params)
 	db = sqlx.MustConnect(driver, connData)
+	dbConnData = connData
 	log.Info("Connected to database", "driver", driver, "connData", connData)
 }
 
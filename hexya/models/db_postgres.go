@@ -16,6 +16,10 @@ package models
 
 import (
 	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/hexya-erp/hexya/hexya/models/fieldtype"
 	"github.com/hexya-erp/hexya/hexya/models/operator"
@@ -40,34 +44,41 @@ var pgOperators = map[operator.Operator]string{
 	operator.LowerOrEqual:   "<= ?",
 	operator.Greater:        "> ?",
 	operator.GreaterOrEqual: ">= ?",
+	operator.Similar:        "% ?",
 }
 
 var pgTypes = map[fieldtype.Type]string{
-	fieldtype.Boolean:   "boolean",
-	fieldtype.Char:      "character varying",
-	fieldtype.Text:      "text",
-	fieldtype.Date:      "date",
-	fieldtype.DateTime:  "timestamp without time zone",
-	fieldtype.Integer:   "integer",
-	fieldtype.Float:     "numeric",
-	fieldtype.HTML:      "text",
-	fieldtype.Binary:    "bytea",
-	fieldtype.Selection: "character varying",
-	fieldtype.Many2One:  "integer",
-	fieldtype.One2One:   "integer",
+	fieldtype.Boolean:      "boolean",
+	fieldtype.Char:         "character varying",
+	fieldtype.Text:         "text",
+	fieldtype.Date:         "date",
+	fieldtype.DateTime:     "timestamp without time zone",
+	fieldtype.EmbeddedList: "jsonb",
+	fieldtype.Integer:      "integer",
+	fieldtype.JSON:         "jsonb",
+	fieldtype.Float:        "numeric",
+	fieldtype.Monetary:     "numeric",
+	fieldtype.HTML:         "text",
+	fieldtype.Binary:       "bytea",
+	fieldtype.Selection:    "character varying",
+	fieldtype.Many2One:     "integer",
+	fieldtype.One2One:      "integer",
 }
 
 var pgDefaultValues = map[fieldtype.Type]string{
-	fieldtype.Boolean:   "FALSE",
-	fieldtype.Char:      "''",
-	fieldtype.Text:      "''",
-	fieldtype.Date:      "'0001-01-01'",
-	fieldtype.DateTime:  "'0001-01-01 00:00:00'",
-	fieldtype.Integer:   "0",
-	fieldtype.Float:     "0.0",
-	fieldtype.HTML:      "''",
-	fieldtype.Binary:    "''",
-	fieldtype.Selection: "''",
+	fieldtype.Boolean:      "FALSE",
+	fieldtype.Char:         "''",
+	fieldtype.Text:         "''",
+	fieldtype.Date:         "'0001-01-01'",
+	fieldtype.DateTime:     "'0001-01-01 00:00:00'",
+	fieldtype.EmbeddedList: "'[]'",
+	fieldtype.Integer:      "0",
+	fieldtype.JSON:         "'null'",
+	fieldtype.Float:        "0.0",
+	fieldtype.Monetary:     "0.0",
+	fieldtype.HTML:         "''",
+	fieldtype.Binary:       "''",
+	fieldtype.Selection:    "''",
 }
 
 // connectionString returns the connection string for the given parameters
@@ -113,6 +124,14 @@ func (d *postgresAdapter) operatorSQL(do operator.Operator, arg interface{}) (st
 
 // typeSQL returns the sql type string for the given Field
 func (d *postgresAdapter) typeSQL(fi *Field) string {
+	if fi.attachment {
+		// Attachment binary fields only store the checksum of their content
+		return pgTypes[fieldtype.Char]
+	}
+	if fi.bigInt || (fi.fieldType.IsFKRelationType() && fi.relatedModel != nil && fi.relatedModel.hasBigIDs()) {
+		// Relations to models with 64 bits ids
+		return "bigint"
+	}
 	typ, _ := pgTypes[fi.fieldType]
 	return typ
 }
@@ -125,12 +144,13 @@ func (d *postgresAdapter) columnSQLDefinition(fi *Field) string {
 	if !ok {
 		log.Panic("Unknown column type", "type", fi.fieldType, "model", fi.model.name, "field", fi.name)
 	}
+	res = d.typeSQL(fi)
 	switch fi.fieldType {
 	case fieldtype.Char:
 		if fi.size > 0 {
 			res = fmt.Sprintf("%s(%d)", res, fi.size)
 		}
-	case fieldtype.Float:
+	case fieldtype.Float, fieldtype.Monetary:
 		emptyD := nbutils.Digits{}
 		if fi.digits != emptyD {
 			res = fmt.Sprintf("numeric(%d, %d)", fi.digits.Precision, fi.digits.Scale)
@@ -190,7 +210,8 @@ func (d *postgresAdapter) quoteTableName(tableName string) string {
 // columns returns a list of ColumnData for the given tableName
 func (d *postgresAdapter) columns(tableName string) map[string]ColumnData {
 	query := fmt.Sprintf(`
-		SELECT column_name, data_type, is_nullable, column_default
+		SELECT column_name, data_type, is_nullable, column_default,
+			character_maximum_length, numeric_precision, numeric_scale
 		FROM information_schema.columns
 		WHERE table_schema NOT IN ('pg_catalog', 'information_schema') AND table_name = '%s'
 	`, tableName)
@@ -213,6 +234,54 @@ func (d *postgresAdapter) indexExists(table string, name string) bool {
 	return cnt > 0
 }
 
+// indexes returns a list of all indexes of the given table matching the given SQL pattern
+func (d *postgresAdapter) indexes(table string, pattern string) []string {
+	query := "SELECT indexname FROM pg_indexes WHERE tablename = ? AND indexname ILIKE ?"
+	var res []string
+	dbSelectNoTx(&res, query, table, pattern)
+	return res
+}
+
+// jsonPathSQL returns the SQL expression of the value at the given path inside
+// the given JSON expression, cast to the type of the given argument, and
+// the SQL argument of the path
+func (d *postgresAdapter) jsonPathSQL(expr string, path []string, arg interface{}) (string, interface{}) {
+	res := fmt.Sprintf("(%s #>> ?)", expr)
+	val := reflect.ValueOf(arg)
+	if val.Kind() == reflect.Slice && val.Len() > 0 {
+		// Multi operators such as IN
+		val = val.Index(0)
+	}
+	if val.Kind() == reflect.Interface {
+		val = val.Elem()
+	}
+	switch val.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		res += "::numeric"
+	case reflect.Bool:
+		res += "::boolean"
+	}
+	return res, pq.Array(path)
+}
+
+// indexComment returns the comment of the index with the given name
+func (d *postgresAdapter) indexComment(name string) string {
+	query := "SELECT COALESCE(obj_description(oid, 'pg_class'), '') FROM pg_class WHERE relkind = 'i' AND relname = ?"
+	var res []string
+	dbSelectNoTx(&res, query, name)
+	if len(res) == 0 {
+		return ""
+	}
+	return res[0]
+}
+
+// commentIndexSQL returns the SQL statement that sets the comment of the given index
+func (d *postgresAdapter) commentIndexSQL(name, comment string) string {
+	return fmt.Sprintf("COMMENT ON INDEX %s IS '%s'", name, strings.Replace(comment, "'", "''", -1))
+}
+
 // constraintExists returns true if a constraint with the given name exists in the given table
 func (d *postgresAdapter) constraintExists(name string) bool {
 	query := fmt.Sprintf("SELECT COUNT(*) FROM pg_constraint WHERE conname = '%s'", name)
@@ -303,4 +372,206 @@ func (d *postgresAdapter) isSerializationError(err error) bool {
 	return false
 }
 
+// createExtension creates the DB extension with the given name if it does not exist
+func (d *postgresAdapter) createExtension(name string) {
+	query := fmt.Sprintf("CREATE EXTENSION IF NOT EXISTS %s", pq.QuoteIdentifier(name))
+	dbExecuteNoTx(query)
+}
+
+// unaccentSQL returns the SQL expression of the given expression without accents.
+// It requires the function created by unaccentFunctionSQL.
+func (d *postgresAdapter) unaccentSQL(expr string) string {
+	return fmt.Sprintf("hexya_unaccent(%s)", expr)
+}
+
+// unaccentFunctionSQL returns the SQL statement that creates the immutable
+// function used by unaccentSQL, so that it can be used in index expressions.
+// It requires the unaccent extension.
+func (d *postgresAdapter) unaccentFunctionSQL() string {
+	return `CREATE OR REPLACE FUNCTION hexya_unaccent(text) RETURNS text AS $$
+		SELECT unaccent('unaccent'::regdictionary, $1)
+		$$ LANGUAGE sql IMMUTABLE STRICT`
+}
+
+// unaccentIndexSQL returns the SQL statement that creates the index with the
+// given name for case-insensitive searches without accents on the given column
+// of the given table. It requires the unaccent and pg_trgm extensions.
+func (d *postgresAdapter) unaccentIndexSQL(name, table, column string) string {
+	return fmt.Sprintf("CREATE INDEX %s ON %s USING gin (%s gin_trgm_ops)", name, d.quoteTableName(table), d.unaccentSQL(column))
+}
+
+// collateSQL returns the SQL expression of the given expression with the given collation
+func (d *postgresAdapter) collateSQL(expr, collation string) string {
+	return fmt.Sprintf("%s COLLATE %s", expr, pq.QuoteIdentifier(collation))
+}
+
+// textSearchVectorSQL returns the SQL expression of the text search vector
+// of the given expression with the given text search configuration
+func (d *postgresAdapter) textSearchVectorSQL(expr, config string) string {
+	return fmt.Sprintf("to_tsvector(%s::regconfig, COALESCE(%s, ''))", quoteLiteral(config), expr)
+}
+
+// textSearchMatchSQL returns the SQL expression that matches the given text search
+// vector with the plain text query given as placeholder
+func (d *postgresAdapter) textSearchMatchSQL(vector, config string) string {
+	return fmt.Sprintf("%s @@ plainto_tsquery(%s::regconfig, ?)", vector, quoteLiteral(config))
+}
+
+// textSearchRankSQL returns the SQL expression of the rank of the given text search
+// vector for the plain text query given as placeholder
+func (d *postgresAdapter) textSearchRankSQL(vector, config string) string {
+	return fmt.Sprintf("ts_rank(%s, plainto_tsquery(%s::regconfig, ?))", vector, quoteLiteral(config))
+}
+
+// textSearchTriggerSQL returns the SQL statements that create or replace the trigger
+// setting each given column of the given table to its vector expression, in which
+// the inserted or updated row is referred to as NEW.
+func (d *postgresAdapter) textSearchTriggerSQL(table string, vectors map[string]string) []string {
+	columns := make([]string, 0, len(vectors))
+	for col := range vectors {
+		columns = append(columns, col)
+	}
+	sort.Strings(columns)
+	var assignments string
+	for _, col := range columns {
+		assignments += fmt.Sprintf("NEW.%s := %s; ", col, vectors[col])
+	}
+	return []string{
+		fmt.Sprintf(`CREATE OR REPLACE FUNCTION %s_tsv_update() RETURNS trigger AS $$
+			BEGIN %sRETURN NEW; END
+			$$ LANGUAGE plpgsql`, table, assignments),
+		fmt.Sprintf(`DROP TRIGGER IF EXISTS %s_tsv_trigger ON %s`, table, d.quoteTableName(table)),
+		fmt.Sprintf(`CREATE TRIGGER %s_tsv_trigger BEFORE INSERT OR UPDATE ON %s FOR EACH ROW EXECUTE PROCEDURE %s_tsv_update()`,
+			table, d.quoteTableName(table), table),
+	}
+}
+
+// dropTextSearchTriggerSQL returns the SQL statements that drop the text search
+// trigger of the given table if it exists.
+func (d *postgresAdapter) dropTextSearchTriggerSQL(table string) []string {
+	return []string{
+		fmt.Sprintf(`DROP TRIGGER IF EXISTS %s_tsv_trigger ON %s`, table, d.quoteTableName(table)),
+		fmt.Sprintf(`DROP FUNCTION IF EXISTS %s_tsv_update()`, table),
+	}
+}
+
+// similaritySQL returns the SQL expression of the trigram similarity of
+// the given expression with the text given as placeholder.
+// It requires the pg_trgm extension.
+func (d *postgresAdapter) similaritySQL(expr string) string {
+	return fmt.Sprintf("similarity(%s, ?)", expr)
+}
+
+// trigramIndexSQL returns the SQL statement that creates the trigram index
+// with the given name on the given column of the given table.
+// It requires the pg_trgm extension.
+func (d *postgresAdapter) trigramIndexSQL(name, table, column string) string {
+	return fmt.Sprintf("CREATE INDEX %s ON %s USING gin (%s gin_trgm_ops)", name, d.quoteTableName(table), column)
+}
+
+// columnComment returns the comment of the given column of the given table
+func (d *postgresAdapter) columnComment(table, column string) string {
+	query := `SELECT COALESCE(col_description(c.oid, a.attnum), '') FROM pg_class c
+		JOIN pg_attribute a ON a.attrelid = c.oid WHERE c.relname = ? AND a.attname = ?`
+	var res []string
+	dbSelectNoTx(&res, query, table, column)
+	if len(res) == 0 {
+		return ""
+	}
+	return res[0]
+}
+
+// commentColumnSQL returns the SQL statement that sets the comment of the given column
+func (d *postgresAdapter) commentColumnSQL(table, column, comment string) string {
+	return fmt.Sprintf("COMMENT ON COLUMN %s.%s IS %s", d.quoteTableName(table), column, quoteLiteral(comment))
+}
+
+// quoteLiteral returns the given string as an SQL string literal
+func quoteLiteral(value string) string {
+	return fmt.Sprintf("'%s'", strings.Replace(value, "'", "''", -1))
+}
+
 var _ dbAdapter = new(postgresAdapter)
+
+// tryAdvisoryLockSQL returns the SQL query that tries to take the session advisory
+// lock whose key is given as placeholder.
+func (d *postgresAdapter) tryAdvisoryLockSQL() string {
+	return "SELECT pg_try_advisory_lock(?)"
+}
+
+// advisoryUnlockSQL returns the SQL query that releases the session advisory
+// lock whose key is given as placeholder.
+func (d *postgresAdapter) advisoryUnlockSQL() string {
+	return "SELECT pg_advisory_unlock(?)"
+}
+
+// advisoryLockHeldSQL returns the SQL query that returns true if the session
+// advisory lock whose key is given as placeholder is held by any session.
+//
+// The 64 bits key of an advisory lock is split between the classid (high bits)
+// and objid (low bits) columns of pg_locks.
+func (d *postgresAdapter) advisoryLockHeldSQL() string {
+	return `SELECT EXISTS (
+		SELECT 1 FROM pg_locks
+		WHERE locktype = 'advisory' AND granted AND objsubid = 1
+			AND (classid::bigint << 32 | objid::bigint) = ?)`
+}
+
+// createSequenceSQL returns the SQL query that creates a DB sequence with the
+// given name, starting at start and incremented by increment.
+func (d *postgresAdapter) createSequenceSQL(name string, start, increment int64) string {
+	return fmt.Sprintf("CREATE SEQUENCE %s INCREMENT BY %d START WITH %d", name, increment, start)
+}
+
+// dropSequenceSQL returns the SQL query that drops the DB sequence with the
+// given name if it exists.
+func (d *postgresAdapter) dropSequenceSQL(name string) string {
+	return fmt.Sprintf("DROP SEQUENCE IF EXISTS %s", name)
+}
+
+// nextSequenceValueSQL returns the SQL query that returns the next value
+// of the DB sequence whose name is given as placeholder.
+func (d *postgresAdapter) nextSequenceValueSQL() string {
+	return "SELECT nextval(?)"
+}
+
+// peekSequenceValueSQL returns the SQL query that returns the value that the next
+// call to nextval will return for the DB sequence whose name is given as placeholder,
+// without consuming it. The query returns no row if the sequence does not exist.
+func (d *postgresAdapter) peekSequenceValueSQL() string {
+	return "SELECT COALESCE(last_value + increment_by, start_value) FROM pg_sequences WHERE sequencename = ?"
+}
+
+// notifySQL returns the SQL query that sends a notification on the
+// database channel given as first placeholder with the payload given
+// as second placeholder.
+func (d *postgresAdapter) notifySQL() string {
+	return "SELECT pg_notify(?, ?)"
+}
+
+// listen calls handler with the payload of each notification sent on
+// the given database channel, using its own connection to the database
+// described by connData.
+func (d *postgresAdapter) listen(connData, channel string, handler func(string)) error {
+	listener := pq.NewListener(connData, time.Second, time.Minute, func(evt pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Warn("Database listener error", "channel", channel, "event", evt, "error", err)
+		}
+	})
+	if err := listener.Listen(channel); err != nil {
+		listener.Close()
+		return err
+	}
+	go func() {
+		for notification := range listener.Notify {
+			if notification == nil {
+				// The connection has been reestablished and
+				// notifications may have been lost meanwhile.
+				log.Warn("Database listener reconnected", "channel", channel)
+				continue
+			}
+			handler(notification.Extra)
+		}
+	}()
+	return nil
+}
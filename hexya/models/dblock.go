@@ -0,0 +1,101 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// migrationLockKey is the key of the advisory lock held by
+// the instance that is updating the database.
+const migrationLockKey int64 = 0x6865787961 // "hexya"
+
+// migrationLockPollInterval is the interval at which an instance waiting
+// for the migration lock checks whether it has been released.
+var migrationLockPollInterval = time.Second
+
+// ErrMigrationLockTimeout is returned by WaitMigrationLock
+// if the lock could not be taken in time.
+var ErrMigrationLockTimeout = errors.New("timeout while waiting for the migration lock")
+
+// migrationLockConn is the database connection holding the migration lock.
+// Advisory locks belong to the session that took them, so we need to keep
+// a dedicated connection instead of using the pool.
+var migrationLockConn *sql.Conn
+
+// TryMigrationLock tries to take the migration lock and returns true if it
+// succeeded. The migration lock is shared by all the instances connected to
+// the same database and ensures that only one of them updates the database
+// schema and loads data at a time.
+//
+// The lock must be released with ReleaseMigrationLock.
+func TryMigrationLock() bool {
+	if migrationLockConn != nil {
+		log.Panic("Migration lock is already held by this instance")
+	}
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		log.Panic("Unable to get a database connection for the migration lock", "error", err)
+	}
+	query, args := sanitizeQuery(adapters[db.DriverName()].tryAdvisoryLockSQL(), migrationLockKey)
+	var locked bool
+	t := time.Now()
+	err = conn.QueryRowContext(context.Background(), query, args...).Scan(&locked)
+	logSQLResult(err, t, query, args...)
+	if !locked {
+		conn.Close()
+		return false
+	}
+	migrationLockConn = conn
+	log.Info("Migration lock acquired")
+	return true
+}
+
+// WaitMigrationLock waits until the migration lock can be taken and takes it.
+// It returns ErrMigrationLockTimeout if the lock is still held by another
+// instance after the given timeout. A timeout of 0 means waiting forever.
+func WaitMigrationLock(timeout time.Duration) error {
+	start := time.Now()
+	for !TryMigrationLock() {
+		if timeout > 0 && time.Since(start) >= timeout {
+			return ErrMigrationLockTimeout
+		}
+		time.Sleep(migrationLockPollInterval)
+	}
+	return nil
+}
+
+// ReleaseMigrationLock releases the migration lock held by this instance.
+// It is a no-op if this instance does not hold the lock.
+func ReleaseMigrationLock() {
+	if migrationLockConn == nil {
+		return
+	}
+	defer func() {
+		migrationLockConn.Close()
+		migrationLockConn = nil
+	}()
+	query, args := sanitizeQuery(adapters[db.DriverName()].advisoryUnlockSQL(), migrationLockKey)
+	var unlocked bool
+	t := time.Now()
+	err := migrationLockConn.QueryRowContext(context.Background(), query, args...).Scan(&unlocked)
+	logSQLResult(err, t, query, args...)
+	log.Info("Migration lock released")
+}
+
+// MigrationLockHeld returns true if the migration lock is held by this
+// instance or by another instance connected to the same database, that is
+// if the database is being updated.
+func MigrationLockHeld(ctx context.Context) (bool, error) {
+	if migrationLockConn != nil {
+		return true, nil
+	}
+	query, args := sanitizeQuery(adapters[db.DriverName()].advisoryLockHeldSQL(), migrationLockKey)
+	var held bool
+	err := db.QueryRowContext(ctx, query, args...).Scan(&held)
+	return held, err
+}
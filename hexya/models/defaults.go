@@ -0,0 +1,140 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"encoding/json"
+	"sort"
+)
+
+// userDefaultModel is the handle of the UserDefault model, which
+// is searched each time default values are computed.
+var userDefaultModel = NewModelHandle("UserDefault")
+
+// declareUserDefaultModel creates the UserDefault system model which stores
+// the default values of fields set at runtime for all users, for a user
+// or for a company.
+//
+// These defaults are consulted by applyDefaults after the Default functions
+// of the fields, so that implementers can tune the default values of a
+// database without code.
+func declareUserDefaultModel() {
+	userDefault := createModel("UserDefault", SystemModel)
+	userDefault.InheritModel(Registry.MustGet("CommonMixin"))
+	userDefault.AddFields(map[string]FieldDefinition{
+		"Model": CharField{Required: true, Index: true},
+		"Field": CharField{Required: true},
+		"Value": TextField{Help: "JSON encoded default value"},
+		"UserID": IntegerField{String: "User ID", Index: true,
+			Help: "ID of the user to whom this default applies, or 0 for all users"},
+		"CompanyID": IntegerField{String: "Company ID", Index: true,
+			Help: "ID of the company to which this default applies, or 0 for all companies"},
+	})
+	userDefault.AddSQLConstraint("unique_default", "UNIQUE (model, field, user_id, company_id)",
+		"There can be only one default per field, user and company")
+}
+
+// SetFieldDefault sets the default value of the given field for the new records of
+// this RecordCollection's model. The default only applies to the user with the
+// given uid and in the company with the given id, where 0 means all users or all
+// companies. A nil value removes the default.
+//
+// When several defaults apply to a field, the default of the user has priority
+// over the default of the company, which has priority over the global default.
+// These defaults have priority over the Default functions of the fields.
+//
+// Users can set their own defaults for all companies. Other defaults can only
+// be set by users allowed to create UserDefault records.
+func (rc *RecordCollection) SetFieldDefault(field string, value interface{}, uid, companyID int64) {
+	defaults := userDefaultModel.Pool(rc.env)
+	if uid != rc.env.uid || companyID != 0 {
+		defaults.CheckExecutionPermission(defaults.model.methods.MustGet("Create"))
+	}
+	fi := rc.model.fields.MustGet(field)
+	if fi.isReadOnly() {
+		log.Panic("Cannot set the default value of a read only field", "model", rc.model.name, "field", field)
+	}
+	existing := defaults.Sudo().Search(defaults.model.Field("Model").Equals(rc.model.name).
+		And().Field("Field").Equals(fi.name).
+		And().Field("UserID").Equals(uid).
+		And().Field("CompanyID").Equals(companyID))
+	if value == nil {
+		existing.Call("Unlink")
+		return
+	}
+	if rs, ok := value.(RecordSet); ok {
+		ids := rs.Ids()
+		if fi.fieldType.IsFKRelationType() && len(ids) > 0 {
+			value = ids[0]
+		} else {
+			value = ids
+		}
+	}
+	fMap := FieldMap{fi.json: value}
+	rc.model.convertValuesToFieldType(&fMap)
+	data, err := json.Marshal(value)
+	if err != nil {
+		log.Panic("Unable to encode default value", "model", rc.model.name, "field", field, "value", value, "error", err)
+	}
+	if !existing.IsEmpty() {
+		existing.Call("Write", FieldMap{"Value": string(data)})
+		return
+	}
+	defaults.Sudo().Call("Create", FieldMap{
+		"Model":     rc.model.name,
+		"Field":     fi.name,
+		"Value":     string(data),
+		"UserID":    uid,
+		"CompanyID": companyID,
+	})
+}
+
+// userDefaults returns the default values set with SetFieldDefault for the fields of
+// this RecordCollection's model that apply to the current user and company, by
+// field JSON name.
+func (rc *RecordCollection) userDefaults() FieldMap {
+	if rc.model.isSystem() {
+		return nil
+	}
+	defaults := userDefaultModel.Pool(rc.env).Sudo()
+	dModel := defaults.model
+	recs := defaults.Search(dModel.Field("Model").Equals(rc.model.name).
+		AndCond(dModel.Field("UserID").Equals(int64(0)).Or().Field("UserID").Equals(rc.env.uid)).
+		AndCond(dModel.Field("CompanyID").Equals(int64(0)).Or().Field("CompanyID").Equals(rc.env.context.CompanyID()))).
+		Records()
+	if len(recs) == 0 {
+		return nil
+	}
+	// Sort defaults from the least specific to the most specific
+	// so that the latter overwrite the former.
+	priority := func(rec *RecordCollection) int {
+		var res int
+		if rec.Get("UserID").(int64) != 0 {
+			res += 2
+		}
+		if rec.Get("CompanyID").(int64) != 0 {
+			res++
+		}
+		return res
+	}
+	sort.SliceStable(recs, func(i, j int) bool {
+		return priority(recs[i]) < priority(recs[j])
+	})
+	res := make(FieldMap)
+	for _, rec := range recs {
+		fi, ok := rc.model.fields.Get(rec.Get("Field").(string))
+		if !ok {
+			log.Warn("Ignoring default of unknown field", "model", rc.model.name, "field", rec.Get("Field"))
+			continue
+		}
+		var value interface{}
+		if err := json.Unmarshal([]byte(rec.Get("Value").(string)), &value); err != nil {
+			log.Warn("Ignoring invalid default value", "model", rc.model.name, "field", fi.name, "error", err)
+			continue
+		}
+		res[fi.json] = jsonFieldValue(fi, value)
+	}
+	rc.model.convertValuesToFieldType(&res)
+	return res
+}
@@ -0,0 +1,107 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import "sort"
+
+// A deferredCompute holds the records and fields of a compute
+// method whose call has been deferred.
+type deferredCompute struct {
+	ids    map[int64]bool
+	fields map[string]bool
+}
+
+// deferredComputations holds the stored computed fields whose computation
+// has been deferred because the context has the import_mode key set.
+//
+// The ids are those of the records that have been modified, from which the
+// records to recompute are found by following the path of the computeData.
+type deferredComputations map[computeData]*deferredCompute
+
+// deferredOperations holds the operations that have been
+// deferred because the context has the import_mode key set.
+type deferredOperations struct {
+	computations deferredComputations
+	messages     []trackingMessage
+}
+
+// newDeferredOperations returns a new empty deferredOperations instance
+func newDeferredOperations() *deferredOperations {
+	return &deferredOperations{computations: make(deferredComputations)}
+}
+
+// clear removes all deferred operations
+func (do *deferredOperations) clear() {
+	do.computations.clear()
+	do.messages = nil
+}
+
+// add defers the computation of cData on the records
+// with the given ids for the given fields.
+func (dc deferredComputations) add(cData computeData, ids []int64, fields []FieldNamer) {
+	dComp, ok := dc[cData]
+	if !ok {
+		dComp = &deferredCompute{ids: make(map[int64]bool), fields: make(map[string]bool)}
+		dc[cData] = dComp
+	}
+	for _, id := range ids {
+		dComp.ids[id] = true
+	}
+	for _, f := range fields {
+		dComp.fields[string(f.FieldName())] = true
+	}
+}
+
+// clear removes all deferred computations
+func (dc deferredComputations) clear() {
+	for cData := range dc {
+		delete(dc, cData)
+	}
+}
+
+// importMode returns true if the computation of stored fields and
+// tracking messages must be deferred in this Environment.
+func (env Environment) importMode() bool {
+	return env.context.GetBool("import_mode")
+}
+
+// ProcessDeferredComputations computes the stored fields whose computation has
+// been deferred because the context has the import_mode key set, then posts the
+// deferred tracking messages. Each compute method is called once for all the
+// records modified since the last call.
+//
+// Computations that are still deferred when the transaction of the Environment
+// ends are processed before it is committed.
+func (env Environment) ProcessDeferredComputations() {
+	env = env.WithContext("import_mode", false)
+	computations := env.deferred.computations
+	for len(computations) > 0 {
+		for cData, dComp := range computations {
+			delete(computations, cData)
+			ids := make([]int64, 0, len(dComp.ids))
+			for id := range dComp.ids {
+				ids = append(ids, id)
+			}
+			sort.Slice(ids, func(i, j int) bool {
+				return ids[i] < ids[j]
+			})
+			fields := make([]FieldNamer, 0, len(dComp.fields))
+			for f := range dComp.fields {
+				fields = append(fields, FieldName(f))
+			}
+			path := cData.path
+			if path == "" {
+				// Searching skips the records deleted since they were modified
+				path = "ID"
+			}
+			recs := env.Pool(cData.model.name).Search(cData.model.Field(path).In(ids))
+			updateStoredFields(recs, cData.compute, fields)
+		}
+	}
+	messages := env.deferred.messages
+	env.deferred.messages = nil
+	for _, msg := range messages {
+		env.postTrackingMessage(msg)
+	}
+}
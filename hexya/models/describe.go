@@ -0,0 +1,145 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+)
+
+// A ModelDescription describes a model of the registry for API clients
+type ModelDescription struct {
+	Name    string              `json:"name"`
+	Fields  []FieldDescription  `json:"fields"`
+	Methods []MethodDescription `json:"methods"`
+}
+
+// A FieldDescription describes a field of a model for API clients
+type FieldDescription struct {
+	*FieldInfo
+	Name string `json:"name"`
+	JSON string `json:"json"`
+}
+
+// A MethodDescription describes a method of a model for API clients.
+//
+// Type is the type of the method's function, whose first
+// argument is always the RecordCollection.
+type MethodDescription struct {
+	Name string
+	Doc  string
+	Type reflect.Type
+}
+
+// MarshalJSON returns the JSON encoding of this MethodDescription, in which
+// the type of the method is given by the list of the types of its parameters,
+// not counting the RecordCollection, and the list of the types it returns.
+func (md MethodDescription) MarshalJSON() ([]byte, error) {
+	params := make([]string, 0, md.Type.NumIn())
+	for i := 1; i < md.Type.NumIn(); i++ {
+		param := md.Type.In(i).String()
+		if md.Type.IsVariadic() && i == md.Type.NumIn()-1 {
+			param = "..." + md.Type.In(i).Elem().String()
+		}
+		params = append(params, param)
+	}
+	returns := make([]string, md.Type.NumOut())
+	for i := range returns {
+		returns[i] = md.Type.Out(i).String()
+	}
+	return json.Marshal(struct {
+		Name    string   `json:"name"`
+		Doc     string   `json:"doc"`
+		Params  []string `json:"params"`
+		Returns []string `json:"returns"`
+	}{
+		Name:    md.Name,
+		Doc:     md.Doc,
+		Params:  params,
+		Returns: returns,
+	})
+}
+
+// Describe returns the descriptions of the models of the registry that can
+// be accessed by API clients, sorted by name. Fields and methods are sorted
+// by name too.
+//
+// Mixins, system models, many2many link models and private methods
+// are not included.
+// It must be called after BootStrap.
+func (mc *modelCollection) Describe() []ModelDescription {
+	if !mc.bootstrapped {
+		log.Panic("Describe must be called after bootstrap")
+	}
+	mc.RLock()
+	defer mc.RUnlock()
+	var res []ModelDescription
+	for _, model := range mc.registryByName {
+		if model.isMixin() || model.isSystem() || model.isM2MLink() {
+			continue
+		}
+		res = append(res, model.describe(model.FieldsGet()))
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Name < res[j].Name
+	})
+	return res
+}
+
+// DescribeModel returns the description of the model with the given name,
+// as Describe does, for the user of this Environment. The string, help and
+// selection of the fields are translated in the language of the context.
+//
+// It panics if the model does not exist or cannot be accessed by API
+// clients, or if the user is not allowed to get its fields.
+func (env Environment) DescribeModel(modelName string) ModelDescription {
+	model := Registry.MustGet(modelName)
+	if model.isMixin() || model.isSystem() || model.isM2MLink() {
+		log.Panic("Model cannot be described", "model", model.name)
+	}
+	fInfos := env.Pool(model.name).Call("FieldsGet", FieldsGetArgs{}).(map[string]*FieldInfo)
+	return model.describe(fInfos)
+}
+
+// FieldsGet returns the definition of the given fields of the model of this
+// RecordCollection, or of all its fields if none is given, by calling its
+// FieldsGet method. The result map is indexed by the fields JSON names.
+func (rc *RecordCollection) FieldsGet(fields ...FieldNamer) map[string]*FieldInfo {
+	args := FieldsGetArgs{Fields: make([]FieldName, len(fields))}
+	for i, f := range fields {
+		args.Fields[i] = f.FieldName()
+	}
+	return rc.Call("FieldsGet", args).(map[string]*FieldInfo)
+}
+
+// describe returns the description of this model
+// with the given information about its fields.
+func (m *Model) describe(fInfos map[string]*FieldInfo) ModelDescription {
+	desc := ModelDescription{Name: m.name}
+	for jsonName, fInfo := range fInfos {
+		desc.Fields = append(desc.Fields, FieldDescription{
+			FieldInfo: fInfo,
+			Name:      m.fields.MustGet(jsonName).name,
+			JSON:      jsonName,
+		})
+	}
+	sort.Slice(desc.Fields, func(i, j int) bool {
+		return desc.Fields[i].Name < desc.Fields[j].Name
+	})
+	for name, method := range m.methods.registry {
+		if method.private {
+			continue
+		}
+		desc.Methods = append(desc.Methods, MethodDescription{
+			Name: name,
+			Doc:  method.Doc(),
+			Type: method.methodType,
+		})
+	}
+	sort.Slice(desc.Methods, func(i, j int) bool {
+		return desc.Methods[i].Name < desc.Methods[j].Name
+	})
+	return desc
+}
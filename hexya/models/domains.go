@@ -0,0 +1,86 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"github.com/hexya-erp/hexya/hexya/models/fieldtype"
+	"github.com/hexya-erp/hexya/hexya/models/operator"
+)
+
+// Domain logical operators
+const (
+	domainAnd = "&"
+	domainOr  = "|"
+	domainNot = "!"
+)
+
+// ParseDomain returns the Condition on this model given by the
+// given Odoo domain, such as decoded from JSON or XML-RPC:
+//
+//	["|", ["Name", "ilike", "smith"], "!", ["Email", "=", false]]
+//
+// Domains are lists of [field path, operator, value] terms, where field paths
+// are made of field names or JSON names, combined in prefix notation with the
+// "&", "|" and "!" operators. Terms which are not combined are ANDed together.
+// As in Odoo, false values of non boolean fields are interpreted as null.
+//
+// It panics if the domain is invalid.
+func (m *Model) ParseDomain(domain []interface{}) *Condition {
+	res := newCondition()
+	for rest := domain; len(rest) > 0; {
+		var cond *Condition
+		cond, rest = m.parseDomainTerm(domain, rest)
+		res = res.AndCond(cond)
+	}
+	return res
+}
+
+// parseDomainTerm returns the Condition of the first term of the given
+// rest of the given domain, and the rest of the domain after this term.
+func (m *Model) parseDomainTerm(domain, rest []interface{}) (*Condition, []interface{}) {
+	if len(rest) == 0 {
+		log.Panic("Missing term in domain", "model", m.name, "domain", domain)
+	}
+	switch term := rest[0].(type) {
+	case string:
+		switch term {
+		case domainAnd, domainOr:
+			left, rest := m.parseDomainTerm(domain, rest[1:])
+			right, rest := m.parseDomainTerm(domain, rest)
+			if term == domainOr {
+				return newCondition().AndCond(left).OrCond(right), rest
+			}
+			return newCondition().AndCond(left).AndCond(right), rest
+		case domainNot:
+			cond, rest := m.parseDomainTerm(domain, rest[1:])
+			return newCondition().AndNotCond(cond), rest
+		}
+	case []interface{}:
+		if len(term) != 3 {
+			break
+		}
+		path, pathOK := term[0].(string)
+		op, opOK := term[1].(string)
+		if !pathOK || !opOK || !operator.Operator(op).IsValid() {
+			break
+		}
+		return m.domainPredicate(path, operator.Operator(op), term[2]), rest[1:]
+	}
+	log.Panic("Invalid term in domain", "model", m.name, "term", rest[0], "domain", domain)
+	return nil, nil
+}
+
+// domainPredicate returns the Condition of the given domain term
+func (m *Model) domainPredicate(path string, op operator.Operator, value interface{}) *Condition {
+	fi := m.getRelatedFieldInfo(path)
+	if b, ok := value.(bool); ok && !b && fi.fieldType != fieldtype.Boolean {
+		switch op {
+		case operator.Equals:
+			return m.Field(path).IsNull()
+		case operator.NotEquals:
+			return m.Field(path).IsNotNull()
+		}
+	}
+	return m.Field(path).AddOperator(op, value)
+}
@@ -0,0 +1,275 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/hexya-erp/hexya/hexya/models/security"
+	"github.com/hexya-erp/hexya/hexya/models/types"
+	"github.com/hexya-erp/hexya/hexya/models/types/dates"
+	"github.com/hexya-erp/hexya/hexya/tools/logging"
+)
+
+// Download states
+const (
+	downloadPending = "pending"
+	downloadDone    = "done"
+	downloadFailed  = "failed"
+)
+
+// DownloadExport is the name of the built-in download generator
+// that exports records with Export.
+const DownloadExport = "export"
+
+// A DownloadGenerator writes the content of a background download to w and
+// returns its file name. It is called with an Environment of the user who
+// requested the download and with the JSON encoded parameters of the download.
+type DownloadGenerator func(env Environment, params json.RawMessage, w io.Writer) string
+
+var (
+	downloadGenerators = map[string]DownloadGenerator{
+		DownloadExport: generateExport,
+	}
+	downloadGeneratorsMutex sync.RWMutex
+)
+
+// DownloadURL returns the URL at which the content of the attachment with the
+// given id can be downloaded. It is used in the messages notifying users that
+// their downloads are ready and can be overridden by the module serving files.
+var DownloadURL = func(attachmentID int64) string {
+	return fmt.Sprintf("/web/content/%d?download=true", attachmentID)
+}
+
+// RegisterDownloadGenerator registers the given generator under the given
+// name, so that downloads can be scheduled with it by ScheduleDownload.
+//
+// It panics if a generator with the same name already exists.
+func RegisterDownloadGenerator(name string, generator DownloadGenerator) {
+	downloadGeneratorsMutex.Lock()
+	defer downloadGeneratorsMutex.Unlock()
+	if _, exists := downloadGenerators[name]; exists {
+		log.Panic("Download generator already registered", "generator", name)
+	}
+	downloadGenerators[name] = generator
+}
+
+// getDownloadGenerator returns the download generator with
+// the given name. It panics if there is no such generator.
+func getDownloadGenerator(name string) DownloadGenerator {
+	downloadGeneratorsMutex.RLock()
+	defer downloadGeneratorsMutex.RUnlock()
+	generator, ok := downloadGenerators[name]
+	if !ok {
+		log.Panic("Unknown download generator", "generator", name)
+	}
+	return generator
+}
+
+// declareDownloadModel creates the Download model.
+//
+// A Download is a file generated in the background for a user, such as a
+// large export. Pending downloads are generated by ProcessDownloads, which
+// is called periodically by the job runner worker of the server. The file
+// is then stored as an attachment of the download and the user is notified
+// with a message holding the download link.
+//
+// Users can only read their own downloads.
+func declareDownloadModel() {
+	download := NewModel("Download")
+	download.AddFields(map[string]FieldDefinition{
+		"Name":      CharField{String: "File Name"},
+		"Generator": CharField{Required: true},
+		"Params":    TextField{String: "Parameters", Help: "JSON encoded parameters of the generator"},
+		"UserID": IntegerField{String: "User ID", Required: true, Index: true,
+			Help: "ID of the user who requested the download, as whom the file is generated"},
+		"State": SelectionField{Required: true, Index: true, Default: DefaultValue(downloadPending),
+			Selection: types.Selection{downloadPending: "Pending", downloadDone: "Done", downloadFailed: "Failed"}},
+		"Attachment": Many2OneField{RelationModel: Registry.MustGet("Attachment")},
+		"Error":      TextField{Help: "Error of the generation, if it failed"},
+		"DoneDate":   DateTimeField{String: "Completion Date"},
+	})
+	download.SetDefaultOrder("ID desc")
+
+	download.AddRecordRule(&RecordRule{
+		Name:   "own_downloads",
+		Global: true,
+		ConditionFunc: func(rs RecordSet) *Condition {
+			rc := rs.Collection()
+			if rc.env.uid == security.SuperUserID {
+				return nil
+			}
+			return rc.model.Field("UserID").Equals(rc.env.uid)
+		},
+		Perms: security.All,
+	})
+
+	for _, method := range []string{"Load", "Read"} {
+		download.methods.MustGet(method).AllowGroup(security.GroupEveryone)
+	}
+}
+
+// ScheduleDownload schedules the generation of a file in the background by
+// the given generator with the given parameters, which are encoded in JSON.
+// The file is generated as the user of this Environment, who is notified
+// when it is ready. It returns the new Download record.
+func (env Environment) ScheduleDownload(generator string, params interface{}) *RecordCollection {
+	getDownloadGenerator(generator)
+	data, err := json.Marshal(params)
+	if err != nil {
+		log.Panic("Unable to encode download parameters", "generator", generator, "error", err)
+	}
+	download := env.Pool("Download").Sudo().Call("Create", FieldMap{
+		"Generator": generator,
+		"Params":    string(data),
+		"UserID":    env.uid,
+	}).(RecordSet).Collection()
+	return env.Pool("Download").withIds(download.Ids())
+}
+
+// exportParams are the parameters of the export download generator
+type exportParams struct {
+	Model  string       `json:"model"`
+	IDs    []int64      `json:"ids"`
+	Fields []string     `json:"fields"`
+	Format ExportFormat `json:"format"`
+}
+
+// ExportInBackground schedules the export of the given fields of the records
+// of this RecordCollection in the given format, as Export would write them,
+// and returns the Download record. Use this method instead of Export for
+// large RecordSets so that clients do not wait for the file.
+func (rc *RecordCollection) ExportInBackground(fields []string, format ExportFormat) *RecordCollection {
+	return rc.env.ScheduleDownload(DownloadExport, exportParams{
+		Model:  rc.model.name,
+		IDs:    rc.Ids(),
+		Fields: fields,
+		Format: format,
+	})
+}
+
+// generateExport is the download generator of exports
+func generateExport(env Environment, params json.RawMessage, w io.Writer) string {
+	var p exportParams
+	if err := json.Unmarshal(params, &p); err != nil {
+		log.Panic("Invalid export parameters", "params", string(params), "error", err)
+	}
+	records := env.Pool(p.Model).Search(Registry.MustGet(p.Model).Field("ID").In(p.IDs))
+	if len(p.IDs) == 0 {
+		records = env.Pool(p.Model).withIds(nil)
+	}
+	records.Export(w, p.Fields, p.Format)
+	return fmt.Sprintf("%s.%s", p.Model, p.Format)
+}
+
+// ProcessDownloads generates all the pending downloads and returns
+// the number of downloads that have been processed.
+//
+// Each download is generated in its own transaction, in which it is first
+// claimed with a row lock that other transactions skip, so that this function
+// can be called concurrently by several server processes. It returns after
+// the current download once StopProcessing has been called.
+func ProcessDownloads() int {
+	var count int
+	for !ProcessingStopped() {
+		var claimed bool
+		err := ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+			download := env.claimDownload()
+			claimed = download != nil
+			if claimed {
+				download.generateDownload()
+			}
+		})
+		if err != nil {
+			log.Warn("Error while processing downloads", "error", err)
+			return count
+		}
+		if !claimed {
+			return count
+		}
+		count++
+	}
+	return count
+}
+
+// claimDownload locks and returns the oldest pending download that is
+// not locked by another transaction, or nil if there is none.
+func (env Environment) claimDownload() *RecordCollection {
+	downloadModel := Registry.MustGet("Download")
+	var ids []int64
+	env.cr.Select(&ids, fmt.Sprintf(`SELECT id FROM %s WHERE state = ? ORDER BY id LIMIT 1 FOR UPDATE SKIP LOCKED`,
+		adapters[db.DriverName()].quoteTableName(downloadModel.tableName)), downloadPending)
+	if len(ids) == 0 {
+		return nil
+	}
+	return env.Pool(downloadModel.name).withIds(ids)
+}
+
+// generateDownload generates the file of this claimed download, stores
+// it as an attachment and notifies the user who requested it.
+//
+// The file is generated inside a savepoint so that a failing generator
+// can be rolled back while the download is still marked as failed.
+func (rc *RecordCollection) generateDownload() {
+	rc.EnsureOne()
+	uid := rc.Get("UserID").(int64)
+	var (
+		buf       bytes.Buffer
+		fileName  string
+		lastError string
+	)
+	rc.env.cr.Execute("SAVEPOINT hexya_download")
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				if err, ok := r.(error); ok && adapters[db.DriverName()].isSerializationError(err) {
+					// Let ExecuteInNewEnvironment retry the whole transaction
+					panic(r)
+				}
+				rc.env.cr.Execute("ROLLBACK TO SAVEPOINT hexya_download")
+				rc.env.Cache().Clear()
+				log.Warn("Download generation failed", "id", rc.ids[0], "generator", rc.Get("Generator"))
+				lastError = logging.LogPanicData(r).Error()
+			}
+		}()
+		userEnv := *rc.env
+		userEnv.uid = uid
+		generator := getDownloadGenerator(rc.Get("Generator").(string))
+		fileName = generator(userEnv, json.RawMessage(rc.Get("Params").(string)), &buf)
+		rc.env.cr.Execute("RELEASE SAVEPOINT hexya_download")
+	}()
+
+	values := FieldMap{"DoneDate": dates.Now()}
+	var body string
+	if lastError != "" {
+		values["State"] = downloadFailed
+		values["Error"] = lastError
+		body = "Your download could not be generated."
+	} else {
+		attachment := rc.env.Pool("Attachment").Call("Create", FieldMap{
+			"Name":     fileName,
+			"ResModel": rc.model.name,
+			"ResID":    rc.ids[0],
+			"Datas":    base64.StdEncoding.EncodeToString(buf.Bytes()),
+		}).(RecordSet).Collection()
+		values["State"] = downloadDone
+		values["Name"] = fileName
+		values["Attachment"] = attachment
+		body = fmt.Sprintf("Your download %s is ready: %s", fileName, DownloadURL(attachment.ids[0]))
+	}
+	rc.Call("Write", values)
+	message := rc.env.postMessage(FieldMap{
+		"ResModel":    rc.model.name,
+		"ResID":       rc.ids[0],
+		"Body":        body,
+		"MessageType": MessageNotification,
+		"AuthorUID":   int64(0),
+	}, nil)
+	rc.env.notifyUsers(message, []int64{uid})
+}
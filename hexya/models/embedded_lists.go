@@ -0,0 +1,126 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"github.com/hexya-erp/hexya/hexya/models/fieldtype"
+	"github.com/hexya-erp/hexya/hexya/models/types"
+	"github.com/hexya-erp/hexya/hexya/models/types/dates"
+)
+
+// embeddedTypes are the types that can be used in
+// the schema of the sub-records of EmbeddedList fields.
+var embeddedTypes = map[fieldtype.Type]bool{
+	fieldtype.Boolean:   true,
+	fieldtype.Char:      true,
+	fieldtype.Text:      true,
+	fieldtype.HTML:      true,
+	fieldtype.Selection: true,
+	fieldtype.Integer:   true,
+	fieldtype.Float:     true,
+	fieldtype.Monetary:  true,
+	fieldtype.Date:      true,
+	fieldtype.DateTime:  true,
+}
+
+// checkEmbeddedLists validates the sub-records of the EmbeddedList fields
+// of fMap against the schema of their field, and converts their values to
+// the Go type of their schema type. fMap values must have been converted
+// to their field type first.
+//
+// It panics if a sub-record has an unknown key, a value of the wrong type
+// or misses a required key.
+func (rc *RecordCollection) checkEmbeddedLists(fMap FieldMap) {
+	for key, value := range fMap {
+		fi, ok := rc.model.fields.Get(key)
+		if !ok || fi.fieldType != fieldtype.EmbeddedList {
+			continue
+		}
+		list, _ := value.(types.EmbeddedList)
+		res := make(types.EmbeddedList, len(list))
+		for i, rec := range list {
+			res[i] = checkEmbeddedRecord(fi, i, rec)
+		}
+		fMap[key] = res
+	}
+}
+
+// checkEmbeddedRecord returns the given sub-record of the given EmbeddedList
+// field with its values converted to the types of the field schema.
+func checkEmbeddedRecord(fi *Field, index int, rec types.EmbeddedRecord) types.EmbeddedRecord {
+	res := make(types.EmbeddedRecord, len(rec))
+	for key, value := range rec {
+		typ, ok := fi.embeddedSchema[key]
+		if !ok {
+			log.Panic("Unknown key in embedded record", "model", fi.model.name, "field", fi.name,
+				"index", index, "key", key)
+		}
+		if value == nil {
+			res[key] = nil
+			continue
+		}
+		val, valid := convertEmbeddedValue(typ, value)
+		if !valid {
+			log.Panic("Invalid value in embedded record", "model", fi.model.name, "field", fi.name,
+				"index", index, "key", key, "type", typ, "value", value)
+		}
+		res[key] = val
+	}
+	for _, key := range fi.embeddedRequired {
+		if res[key] == nil {
+			log.Panic("Missing required key in embedded record", "model", fi.model.name, "field", fi.name,
+				"index", index, "key", key)
+		}
+	}
+	return res
+}
+
+// convertEmbeddedValue returns the given value of a sub-record converted to
+// the Go type of the given schema type, and false if it cannot be converted.
+func convertEmbeddedValue(typ fieldtype.Type, value interface{}) (interface{}, bool) {
+	switch typ {
+	case fieldtype.Boolean:
+		val, ok := value.(bool)
+		return val, ok
+	case fieldtype.Char, fieldtype.Text, fieldtype.HTML, fieldtype.Selection:
+		val, ok := value.(string)
+		return val, ok
+	case fieldtype.Integer:
+		switch val := value.(type) {
+		case int64:
+			return val, true
+		case int:
+			return int64(val), true
+		case float64:
+			// Numbers sent by clients in JSON are floats
+			return int64(val), val == float64(int64(val))
+		}
+	case fieldtype.Float, fieldtype.Monetary:
+		switch val := value.(type) {
+		case float64:
+			return val, true
+		case int64:
+			return float64(val), true
+		case int:
+			return float64(val), true
+		}
+	case fieldtype.Date:
+		switch val := value.(type) {
+		case dates.Date:
+			return val, true
+		case string:
+			res, err := dates.ParseDate(dates.DefaultServerDateFormat, val)
+			return res, err == nil
+		}
+	case fieldtype.DateTime:
+		switch val := value.(type) {
+		case dates.DateTime:
+			return val, true
+		case string:
+			res, err := dates.ParseDateTime(dates.DefaultServerDateTimeFormat, val)
+			return res, err == nil
+		}
+	}
+	return nil, false
+}
@@ -15,8 +15,15 @@
 package models
 
 import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/hexya-erp/hexya/hexya/metrics"
 	"github.com/hexya-erp/hexya/hexya/models/types"
 	"github.com/hexya-erp/hexya/hexya/tools/logging"
+	"github.com/hexya-erp/hexya/hexya/tracing"
 )
 
 // DBSerializationMaxRetries defines the number of time a
@@ -30,12 +37,16 @@ const DBSerializationMaxRetries uint8 = 5
 // - the current context (for storing arbitrary metadata).
 // The Environment also stores caches.
 type Environment struct {
-	cr      *Cursor
-	uid     int64
-	context *types.Context
-	cache   *cache
-	super   bool
-	retries uint8
+	cr             *Cursor
+	uid            int64
+	context        *types.Context
+	cache          *cache
+	deferred       *deferredOperations
+	invalidations  cacheInvalidations
+	computedValues *computedCacheValues
+	super          bool
+	retries        uint8
+	scopes         APIScopes
 }
 
 // Cr returns a pointer to the Cursor of the Environment
@@ -43,6 +54,17 @@ func (env Environment) Cr() *Cursor {
 	return env.cr
 }
 
+// QueryStats returns the statistics of the SQL queries executed so far in the
+// transaction of this Environment. Tests can check the number of queries of an
+// operation by comparing the statistics before and after it:
+//
+//	before := env.QueryStats()
+//	partners.Load()
+//	So(env.QueryStats().Count-before.Count, ShouldBeLessThanOrEqualTo, 2)
+func (env Environment) QueryStats() QueryStats {
+	return env.cr.QueryStats()
+}
+
 // Uid returns the user id of the Environment
 func (env Environment) Uid() int64 {
 	return env.uid
@@ -53,6 +75,22 @@ func (env Environment) Context() *types.Context {
 	return env.context
 }
 
+// WithContext returns a copy of this Environment with its
+// context extended by the given key and value.
+//
+// The context of this Environment is not modified.
+func (env Environment) WithContext(key string, value interface{}) Environment {
+	env.context = env.context.WithKey(key, value)
+	return env
+}
+
+// WithNewContext returns a copy of this Environment
+// with its context replaced by the given one.
+func (env Environment) WithNewContext(context *types.Context) Environment {
+	env.context = context
+	return env
+}
+
 // commit the transaction of this environment.
 //
 // WARNING: Do NOT call Commit on Environment instances that you
@@ -78,10 +116,13 @@ func (env Environment) rollback() {
 // the database connection.
 func newEnvironment(uid int64) Environment {
 	env := Environment{
-		cr:      newCursor(db),
-		uid:     uid,
-		context: types.NewContext(),
-		cache:   newCache(),
+		cr:             newCursor(db),
+		uid:            uid,
+		context:        types.NewContext(),
+		cache:          newCache(),
+		deferred:       newDeferredOperations(),
+		invalidations:  make(cacheInvalidations),
+		computedValues: newComputedCacheValues(),
 	}
 	return env
 }
@@ -94,15 +135,50 @@ func newEnvironment(uid int64) Environment {
 // errors are automatically retried several times before returning an
 // error if they still occur.
 func ExecuteInNewEnvironment(uid int64, fnct func(Environment)) (rError error) {
+	return ExecuteOrRollbackInNewEnvironment(uid, func(env Environment) bool {
+		fnct(env)
+		return true
+	})
+}
+
+// ExecuteOrRollbackInNewEnvironment executes the given fnct in a new
+// Environment within a new transaction, like ExecuteInNewEnvironment.
+//
+// The transaction is committed if fnct returns true. It is rolled back
+// without error if fnct returns false, and rolled back with an error
+// if fnct panics. Database serialization errors are retried by calling
+// fnct again.
+func ExecuteOrRollbackInNewEnvironment(uid int64, fnct func(Environment) bool) error {
+	return executeInNewEnvironment(uid, fnct, 0)
+}
+
+// executeInNewEnvironment executes the given fnct in a new Environment
+// as ExecuteOrRollbackInNewEnvironment. retries is the number of times
+// fnct has already been executed and failed with a serialization error.
+func executeInNewEnvironment(uid int64, fnct func(Environment) bool, retries uint8) (rError error) {
+	if currentTraceState() == nil {
+		WithTraceContext(context.Background(), func() {
+			rError = executeInNewEnvironment(uid, fnct, retries)
+		})
+		return
+	}
+	span, endSpan := startSpan("Environment", tracing.UIDKey.Int64(uid), tracing.RetriesKey.Int(int(retries)))
+	defer endSpan()
+	atomic.AddInt64(&runningTransactions, 1)
+	defer atomic.AddInt64(&runningTransactions, -1)
 	env := newEnvironment(uid)
+	env.retries = retries
+	start := time.Now()
 	defer func() {
 		if r := recover(); r != nil {
+			recordSpanError(span, r)
 			env.rollback()
+			metrics.TransactionDuration.WithLabelValues("error").Observe(time.Now().Sub(start).Seconds())
 			if err, ok := r.(error); ok && adapters[db.DriverName()].isSerializationError(err) {
 				// Transaction error
 				env.retries++
 				if env.retries < DBSerializationMaxRetries {
-					if ExecuteInNewEnvironment(uid, fnct) == nil {
+					if executeInNewEnvironment(uid, fnct, env.retries) == nil {
 						rError = nil
 						return
 					}
@@ -111,9 +187,18 @@ func ExecuteInNewEnvironment(uid int64, fnct func(Environment)) (rError error) {
 			rError = logging.LogPanicData(r)
 			return
 		}
-		env.commit()
 	}()
-	fnct(env)
+	if !fnct(env) {
+		span.AddEvent("rollback")
+		env.rollback()
+		metrics.TransactionDuration.WithLabelValues("rollback").Observe(time.Now().Sub(start).Seconds())
+		return
+	}
+	env.ProcessDeferredComputations()
+	env.commit()
+	metrics.TransactionDuration.WithLabelValues("commit").Observe(time.Now().Sub(start).Seconds())
+	env.sendCacheInvalidations()
+	env.publishComputedValues()
 	return
 }
 
@@ -123,10 +208,21 @@ func ExecuteInNewEnvironment(uid int64, fnct func(Environment)) (rError error) {
 // This function always rolls back the transaction but returns an error
 // only if fnct panicked during its execution.
 func SimulateInNewEnvironment(uid int64, fnct func(Environment)) (rError error) {
+	if currentTraceState() == nil {
+		WithTraceContext(context.Background(), func() {
+			rError = SimulateInNewEnvironment(uid, fnct)
+		})
+		return
+	}
+	span, endSpan := startSpan("Environment", tracing.UIDKey.Int64(uid))
+	defer endSpan()
+	atomic.AddInt64(&runningTransactions, 1)
+	defer atomic.AddInt64(&runningTransactions, -1)
 	env := newEnvironment(uid)
 	defer func() {
 		env.rollback()
 		if r := recover(); r != nil {
+			recordSpanError(span, r)
 			rError = logging.LogPanicData(r)
 			return
 		}
@@ -135,7 +231,30 @@ func SimulateInNewEnvironment(uid int64, fnct func(Environment)) (rError error)
 	return
 }
 
+// Ref returns the record with the given module qualified external ID,
+// such as "base.main_company". It panics if the external ID is unknown.
+func (env Environment) Ref(externalID string) *RecordCollection {
+	var data []struct {
+		Model string
+		ResID int64
+	}
+	// We use direct SQL query to bypass access control on ModelData
+	query := fmt.Sprintf(`SELECT model, res_id FROM %s WHERE name = ?`,
+		adapters[db.DriverName()].quoteTableName(Registry.MustGet("ModelData").tableName))
+	env.cr.Select(&data, query, externalID)
+	if len(data) == 0 {
+		log.Panic("Unknown external ID", "externalID", externalID)
+	}
+	return env.Pool(data[0].Model).withIds([]int64{data[0].ResID})
+}
+
+// User returns an EnvUser to access the data of the
+// user of this Environment, such as its preferences.
+func (env Environment) User() EnvUser {
+	return EnvUser{env: env}
+}
+
 // Pool returns an empty RecordCollection for the given modelName
 func (env Environment) Pool(modelName string) *RecordCollection {
-	return newRecordCollection(env, modelName)
+	return newRecordCollection(env, Registry.MustGet(modelName))
 }
@@ -0,0 +1,94 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"sort"
+	"sync"
+)
+
+// A RecordHook is a function called after records have been created, written
+// or deleted. rc holds the affected records and fields the names of the fields
+// that have been set, sorted alphabetically. fields is nil for deleted records.
+//
+// Hooks are called in the transaction of the operation, as the user of the
+// operation. A hook that panics makes the whole operation fail.
+type RecordHook func(rc *RecordCollection, fields []string)
+
+// A recordEvent is a kind of operation on records for which hooks are called
+type recordEvent int
+
+const (
+	recordCreated recordEvent = iota
+	recordWritten
+	recordDeleted
+)
+
+var (
+	recordHooks      = make(map[recordEvent]map[string][]RecordHook)
+	recordHooksMutex sync.RWMutex
+)
+
+// registerRecordHook adds the given hook for the given event
+// on the given model, or on all models if modelName is empty.
+func registerRecordHook(event recordEvent, modelName string, hook RecordHook) {
+	recordHooksMutex.Lock()
+	defer recordHooksMutex.Unlock()
+	if recordHooks[event] == nil {
+		recordHooks[event] = make(map[string][]RecordHook)
+	}
+	recordHooks[event][modelName] = append(recordHooks[event][modelName], hook)
+}
+
+// OnRecordCreated registers a hook that is called each time a record of the
+// given model is created, after it has been inserted, its stored fields computed
+// and its constraints checked. If modelName is empty, the hook is called for
+// records of all models.
+func OnRecordCreated(modelName string, hook RecordHook) {
+	registerRecordHook(recordCreated, modelName, hook)
+}
+
+// OnRecordWritten registers a hook that is called each time records of the
+// given model are updated, after the update has been done, the stored fields
+// computed and the constraints checked. If modelName is empty, the hook is called
+// for records of all models.
+//
+// Fields of records updated by the computation of stored fields are not reported.
+func OnRecordWritten(modelName string, hook RecordHook) {
+	registerRecordHook(recordWritten, modelName, hook)
+}
+
+// OnRecordDeleted registers a hook that is called each time records of the given
+// model are deleted, after they have been removed from the database. Only the
+// ids of the given RecordCollection can be used, since the records do not exist
+// anymore. If modelName is empty, the hook is called for records of all models.
+func OnRecordDeleted(modelName string, hook RecordHook) {
+	registerRecordHook(recordDeleted, modelName, hook)
+}
+
+// fireRecordHooks calls the hooks registered for the given event on the
+// model of this RecordCollection, then the hooks registered for all models.
+// fMap holds the values that have been set.
+//
+// It also adds the records to the cache invalidations of the Environment.
+func (rc *RecordCollection) fireRecordHooks(event recordEvent, fMap FieldMap) {
+	var fields []string
+	if fMap != nil {
+		fields = make([]string, 0, len(fMap))
+		for key := range fMap {
+			if fi, ok := rc.model.fields.Get(key); ok {
+				key = fi.name
+			}
+			fields = append(fields, key)
+		}
+		sort.Strings(fields)
+	}
+	rc.env.invalidations.add(rc.model, rc.ids, fields)
+	recordHooksMutex.RLock()
+	hooks := append(append([]RecordHook{}, recordHooks[event][rc.model.name]...), recordHooks[event][""]...)
+	recordHooksMutex.RUnlock()
+	for _, hook := range hooks {
+		hook(rc, fields)
+	}
+}
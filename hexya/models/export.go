@@ -0,0 +1,259 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/hexya-erp/hexya/hexya/models/fieldtype"
+	"github.com/hexya-erp/hexya/hexya/models/types/dates"
+	"github.com/hexya-erp/hexya/hexya/tools/xlsx"
+)
+
+// An ExportFormat is a file format records can be exported to
+type ExportFormat string
+
+// Available export formats
+const (
+	ExportCSV  ExportFormat = "csv"
+	ExportXLSX ExportFormat = "xlsx"
+)
+
+// Export writes the given fields of the records of this RecordCollection to w
+// in the given format. The first row holds the field paths.
+//
+// Fields can be paths through relations such as "Partner.Country.Name".
+// Relation fields at the end of a path are exported with their display name.
+// Paths through one2many or many2many fields are flattened: the first line of
+// each record holds its first related record, and each other related record is
+// exported on an additional line where the other columns are empty.
+//
+// In XLSX, monetary fields are formatted with the symbol of their currency and
+// float fields with their number of decimals.
+//
+// Records are written as they are read, so that large RecordSets can be exported.
+func (rc *RecordCollection) Export(w io.Writer, fields []string, format ExportFormat) {
+	switch format {
+	case ExportCSV:
+		cw := csv.NewWriter(w)
+		defer cw.Flush()
+		rc.writeExport(fields, false, func(values []interface{}) error {
+			strValues := make([]string, len(values))
+			for i, v := range values {
+				strValues[i] = exportString(v)
+			}
+			return cw.Write(strValues)
+		})
+	case ExportXLSX:
+		ExportSheets(w, ExportSheet{Records: rc, Fields: fields})
+	default:
+		log.Panic("Unknown export format", "format", format)
+	}
+}
+
+// An ExportSheet is a sheet of a spreadsheet export, with the given
+// fields of the given records. Sheets without name are named after
+// their position, such as "Sheet1".
+type ExportSheet struct {
+	Name    string
+	Records *RecordCollection
+	Fields  []string
+}
+
+// ExportSheets writes the given sheets to w as an XLSX spreadsheet.
+// Each sheet is exported as by Export in the XLSX format.
+//
+// Rows are written as they are read, so that large RecordSets can be exported.
+func ExportSheets(w io.Writer, sheets ...ExportSheet) {
+	xw := xlsx.NewWriter(w)
+	for i, sheet := range sheets {
+		name := sheet.Name
+		if name == "" {
+			name = fmt.Sprintf("Sheet%d", i+1)
+		}
+		if err := xw.AddSheet(name); err != nil {
+			log.Panic("Unable to add sheet to XLSX export", "sheet", name, "error", err)
+		}
+		sheet.Records.writeExport(sheet.Fields, true, xw.WriteRow)
+	}
+	if err := xw.Close(); err != nil {
+		log.Panic("Unable to close XLSX export", "error", err)
+	}
+}
+
+// writeExport writes the header row and the export rows of the given fields
+// with the given writeRow function. Values are typed for spreadsheets if
+// typed is true.
+func (rc *RecordCollection) writeExport(fields []string, typed bool, writeRow func([]interface{}) error) {
+	headers := make([]interface{}, len(fields))
+	for i, f := range fields {
+		headers[i] = f
+	}
+	if err := writeRow(headers); err != nil {
+		log.Panic("Error while exporting records", "model", rc.model.name, "error", err)
+	}
+	for _, rec := range rc.Fetch().Records() {
+		for _, row := range rec.exportRows(fields, typed) {
+			if err := writeRow(row); err != nil {
+				log.Panic("Error while exporting records", "model", rc.model.name, "error", err)
+			}
+		}
+	}
+}
+
+// ExportRows returns the rows that Export would write for the
+// given fields, without the header row.
+func (rc *RecordCollection) ExportRows(fields []string) [][]interface{} {
+	var res [][]interface{}
+	for _, rec := range rc.Fetch().Records() {
+		res = append(res, rec.exportRows(fields, false)...)
+	}
+	return res
+}
+
+// exportRows returns the export rows of the given field paths for this record.
+// Paths through 2many relations are expanded on as many rows as needed.
+// Values are typed for spreadsheets if typed is true.
+func (rc *RecordCollection) exportRows(paths []string, typed bool) [][]interface{} {
+	rows := [][]interface{}{make([]interface{}, len(paths))}
+	subPaths := make(map[string][]string)
+	subColumns := make(map[string][]int)
+	var prefixes []string
+	for i, path := range paths {
+		prefix, subPath := rc.model.split2ManyPath(path)
+		if prefix == "" {
+			rows[0][i] = rc.exportValue(path, typed)
+			continue
+		}
+		if _, exists := subPaths[prefix]; !exists {
+			prefixes = append(prefixes, prefix)
+		}
+		subPaths[prefix] = append(subPaths[prefix], subPath)
+		subColumns[prefix] = append(subColumns[prefix], i)
+	}
+	for _, prefix := range prefixes {
+		related := rc.Get(prefix).(RecordSet).Collection()
+		var subRows [][]interface{}
+		for _, relRec := range related.Records() {
+			subRows = append(subRows, relRec.exportRows(subPaths[prefix], typed)...)
+		}
+		for len(rows) < len(subRows) {
+			rows = append(rows, make([]interface{}, len(paths)))
+		}
+		for j, subRow := range subRows {
+			for k, col := range subColumns[prefix] {
+				rows[j][col] = subRow[k]
+			}
+		}
+	}
+	return rows
+}
+
+// split2ManyPath splits the given path after its first 2many field if it
+// has one with a sub path. Otherwise, prefix is the empty string.
+func (m *Model) split2ManyPath(path string) (prefix, subPath string) {
+	exprs := strings.Split(path, ExprSep)
+	model := m
+	for i, expr := range exprs[:len(exprs)-1] {
+		fi := model.fields.MustGet(expr)
+		if fi.fieldType.Is2ManyRelationType() {
+			return strings.Join(exprs[:i+1], ExprSep), strings.Join(exprs[i+1:], ExprSep)
+		}
+		if !fi.isRelationField() {
+			log.Panic("Field is not a relation in model", "field", expr, "model", model.name)
+		}
+		model = fi.relatedModel
+	}
+	return "", path
+}
+
+// exportValue returns the value to export for the given path
+// which must not go through a 2many relation. If typed is true,
+// monetary and float values are given as xlsx.Cell with their format.
+func (rc *RecordCollection) exportValue(path string, typed bool) interface{} {
+	exprs := strings.SplitN(path, ExprSep, 2)
+	fi := rc.model.fields.MustGet(exprs[0])
+	val := rc.Get(exprs[0])
+	if len(exprs) > 1 {
+		relRC := val.(RecordSet).Collection()
+		if relRC.IsEmpty() {
+			return nil
+		}
+		return relRC.exportValue(exprs[1], typed)
+	}
+	if typed {
+		if format := rc.exportNumberFormat(fi); format != "" {
+			return xlsx.Cell{Value: val, Format: format}
+		}
+	}
+	switch v := val.(type) {
+	case RecordSet:
+		var names []string
+		for _, relRec := range v.Collection().Records() {
+			names = append(names, relRec.Call("NameGet").(string))
+		}
+		if fi.fieldType.Is2OneRelationType() && len(names) == 0 {
+			return nil
+		}
+		return strings.Join(names, ",")
+	case dates.Date:
+		if v.IsZero() {
+			return nil
+		}
+		return v.Time
+	case dates.DateTime:
+		if v.IsZero() {
+			return nil
+		}
+		return v.Time
+	}
+	return val
+}
+
+// exportNumberFormat returns the spreadsheet number format of the given field
+// of this record: monetary fields have the format of their currency, and float
+// fields with digits have their number of decimals. It returns the empty string
+// for other fields.
+func (rc *RecordCollection) exportNumberFormat(fi *Field) string {
+	switch fi.fieldType {
+	case fieldtype.Monetary:
+		currency := rc.Get(fi.currencyField).(RecordSet).Collection()
+		if currency.IsEmpty() {
+			return xlsx.NumberFormat(int(fi.digits.Scale))
+		}
+		return xlsx.CurrencyFormat(currency.Get("Symbol").(string), int(currency.Get("DecimalPlaces").(int64)),
+			currency.Get("Position").(string) == "before")
+	case fieldtype.Float:
+		if fi.digits.Scale > 0 {
+			return xlsx.NumberFormat(int(fi.digits.Scale))
+		}
+	}
+	return ""
+}
+
+// exportString returns the string representation of the given
+// exported value for text formats.
+func exportString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case bool:
+		if v {
+			return "True"
+		}
+		return "False"
+	case time.Time:
+		if v.Hour() == 0 && v.Minute() == 0 && v.Second() == 0 {
+			return v.Format(dates.DefaultServerDateFormat)
+		}
+		return v.Format(dates.DefaultServerDateTimeFormat)
+	}
+	return fmt.Sprintf("%v", value)
+}
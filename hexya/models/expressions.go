@@ -0,0 +1,244 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/hexya-erp/hexya/hexya/models/types/dates"
+	"github.com/hexya-erp/hexya/hexya/tools/expr"
+)
+
+// exprRecord gives access to the fields of a RecordCollection in expressions
+type exprRecord struct {
+	rc *RecordCollection
+}
+
+// Attribute returns the value of the given field of the first record.
+// Relation fields return an exprRecord too, so that paths can be followed.
+func (r exprRecord) Attribute(name string) (interface{}, error) {
+	fi, ok := r.rc.model.fields.Get(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown field %s in model %s", name, r.rc.model.name)
+	}
+	return toExprValue(r.rc.Get(fi.name)), nil
+}
+
+// Len returns the number of records
+func (r exprRecord) Len() int {
+	return r.rc.Len()
+}
+
+// String returns the string representation of the records
+func (r exprRecord) String() string {
+	return r.rc.String()
+}
+
+// toExprValue returns the given field value as used in expressions
+func toExprValue(value interface{}) interface{} {
+	if rs, ok := value.(RecordSet); ok {
+		return exprRecord{rc: rs.Collection()}
+	}
+	return value
+}
+
+// fromExprValue returns the given expression value as used in FieldMaps.
+//
+// Records are returned as RecordCollection and lists of records
+// of the same model are merged into a single RecordCollection.
+func fromExprValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case exprRecord:
+		return v.rc
+	case []interface{}:
+		var res *RecordCollection
+		for _, item := range v {
+			rec, ok := item.(exprRecord)
+			if !ok || (res != nil && rec.rc.model != res.model) {
+				return value
+			}
+			if res == nil {
+				res = rec.rc
+				continue
+			}
+			res = res.Union(rec.rc)
+		}
+		if res == nil {
+			return value
+		}
+		return res
+	}
+	return value
+}
+
+// expressionVars returns the variables available to
+// expressions evaluated on this RecordCollection:
+//
+//	record     this RecordCollection
+//	user       the current user, if the User model exists
+//	uid        the id of the current user
+//	context    the context of the environment, as a map
+//	today()    the current date in the time zone of the context
+//	ref(id)    the record with the given external ID
+//	url()      the web client deep link to the record
+func (rc *RecordCollection) expressionVars() expr.Vars {
+	vars := expr.Vars{
+		"record":  exprRecord{rc: rc},
+		"uid":     rc.env.uid,
+		"context": rc.env.context.ToMap(),
+		"today": expr.Func(func(args ...interface{}) (interface{}, error) {
+			if len(args) != 0 {
+				return nil, fmt.Errorf("today: expected 0 arguments, got %d", len(args))
+			}
+			return dates.TodayIn(rc.env.context.TZ()), nil
+		}),
+		"ref": refFunc(rc.env, ""),
+		"url": expr.Func(func(args ...interface{}) (interface{}, error) {
+			if len(args) != 0 {
+				return nil, fmt.Errorf("url: expected 0 arguments, got %d", len(args))
+			}
+			if rc.Len() != 1 {
+				return nil, fmt.Errorf("url: expected a single record, got %d", rc.Len())
+			}
+			return rc.URL(), nil
+		}),
+	}
+	if userModel, ok := Registry.Get("User"); ok {
+		vars["user"] = exprRecord{rc: rc.env.Pool(userModel.name).Search(userModel.Field("ID").Equals(rc.env.uid))}
+	}
+	return vars
+}
+
+// refFunc returns the ref() function of expressions, which returns the record
+// with the given external ID. If moduleName is not empty, external IDs are
+// qualified with it if needed.
+func refFunc(env Environment, moduleName string) expr.Func {
+	return func(args ...interface{}) (interface{}, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("ref: expected 1 argument, got %d", len(args))
+		}
+		externalID, ok := args[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("ref: expected an external ID, got %T", args[0])
+		}
+		if moduleName != "" {
+			externalID = qualifyExternalID(moduleName, externalID)
+		}
+		rec := env.recordByExternalID(externalID)
+		if rec == nil {
+			return nil, fmt.Errorf("ref: unknown external ID %s", externalID)
+		}
+		return exprRecord{rc: rec}, nil
+	}
+}
+
+// Evaluate returns the value of the given expression evaluated on this
+// RecordCollection. See the expr package for the syntax of expressions.
+//
+// The expression is given the current record as 'record', the current user
+// as 'user', the context as 'context' and the ref(externalID) function.
+// Records are returned as RecordCollection.
+//
+// This function panics if the expression cannot be parsed or evaluated.
+func (rc *RecordCollection) Evaluate(expression string) interface{} {
+	return rc.evaluate(expression, nil)
+}
+
+// evaluate returns the value of the given expression evaluated on this
+// RecordCollection, with the given variables added to or overriding
+// the variables of expressionVars.
+func (rc *RecordCollection) evaluate(expression string, vars expr.Vars) interface{} {
+	allVars := rc.expressionVars()
+	for name, value := range vars {
+		allVars[name] = value
+	}
+	res, err := expr.Eval(expression, allVars)
+	if err != nil {
+		log.Panic("Unable to evaluate expression", "model", rc.model.name, "expression", expression, "error", err)
+	}
+	return fromExprValue(res)
+}
+
+// EvaluateCondition returns true if the given expression evaluated on this
+// RecordCollection is true. See expr.IsTrue for the truth value of values.
+func (rc *RecordCollection) EvaluateCondition(expression string) bool {
+	return expr.IsTrue(rc.Evaluate(expression))
+}
+
+// recordByExternalID returns the record with the given module qualified
+// external ID, or nil if there is none.
+func (env Environment) recordByExternalID(externalID string) *RecordCollection {
+	modelData := env.Pool("ModelData").Sudo()
+	data := modelData.Search(modelData.Model().Field("Name").Equals(externalID)).Limit(1)
+	if data.IsEmpty() {
+		return nil
+	}
+	model, ok := Registry.Get(data.Get("Model").(string))
+	if !ok {
+		return nil
+	}
+	return env.Pool(model.name).Search(model.Field("ID").Equals(data.Get("ResID").(int64)))
+}
+
+// placeholderRE matches the {{ expression }} placeholders of texts
+var placeholderRE = regexp.MustCompile(`\{\{(.*?)\}\}`)
+
+// RenderPlaceholders returns the given text in which each {{ expression }}
+// placeholder is replaced by the value of the expression evaluated on this
+// RecordCollection, as with Evaluate. For instance:
+//
+//	Dear {{ record.Partner.Name }}, your order {{ record.Name }} is confirmed.
+//
+// Records are replaced by their display names, separated by commas.
+func (rc *RecordCollection) RenderPlaceholders(text string) string {
+	return rc.renderPlaceholders(text, nil)
+}
+
+// renderPlaceholders returns the given text with its placeholders replaced
+// as in RenderPlaceholders. If escape is not nil, it is applied to the values
+// of the expressions, e.g. to escape them for HTML.
+func (rc *RecordCollection) renderPlaceholders(text string, escape func(string) string) string {
+	return placeholderRE.ReplaceAllStringFunc(text, func(placeholder string) string {
+		expression := strings.TrimSpace(placeholderRE.FindStringSubmatch(placeholder)[1])
+		res := displayString(rc.Evaluate(expression))
+		if escape != nil {
+			res = escape(res)
+		}
+		return res
+	})
+}
+
+// displayString returns the given value as it is displayed to users
+// in texts: display names for records and empty strings for empty
+// values.
+func displayString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case RecordSet:
+		var names []string
+		for _, rec := range v.Collection().Records() {
+			names = append(names, rec.Get("DisplayName").(string))
+		}
+		return strings.Join(names, ", ")
+	case dates.Date:
+		if v.IsZero() {
+			return ""
+		}
+		return v.String()
+	case dates.DateTime:
+		if v.IsZero() {
+			return ""
+		}
+		return v.String()
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 32)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return fmt.Sprint(value)
+}
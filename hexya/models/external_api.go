@@ -0,0 +1,424 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/hexya-erp/hexya/hexya/models/operator"
+	"github.com/hexya-erp/hexya/hexya/models/types"
+	"github.com/hexya-erp/hexya/hexya/tools/strutils"
+)
+
+// OdooModelNames maps the names of Odoo models used by external API clients
+// to the names of Hexya models, when they cannot be deduced from each other.
+//
+// Other Odoo model names are resolved by replacing dots with underscores and
+// looking up the resulting table name, with or without its "res_" or "ir_"
+// prefix, e.g. "sale.order" is resolved as "SaleOrder" and "res.partner" as
+// "Partner". Modules may add their own names to this map.
+var OdooModelNames = map[string]string{
+	"res.users":  "User",
+	"res.groups": "Group",
+}
+
+// An externalMethod is a method of the Odoo external API
+// which has no direct equivalent among Hexya methods.
+type externalMethod struct {
+	// params are the names of the parameters of the method,
+	// not counting the ids of the records on which it is called.
+	params []string
+	// onRecords is true if the first argument of the method
+	// is the list of ids of the records on which it is called.
+	onRecords bool
+	fnct      func(rc *RecordCollection, args []interface{}) interface{}
+}
+
+// externalMethods are the methods of the Odoo external API
+// which are translated into calls to Hexya methods.
+var externalMethods = map[string]externalMethod{
+	"search": {
+		params: []string{"domain", "offset", "limit", "order", "count"},
+		fnct: func(rc *RecordCollection, args []interface{}) interface{} {
+			rs := rc.externalSearch(args[0])
+			if count, _ := args[4].(bool); count {
+				return rs.SearchCount()
+			}
+			if order, _ := args[3].(string); order != "" {
+				rs = rs.OrderBy(strings.Split(order, ",")...)
+			}
+			return rs.Offset(externalInt(args[1])).Limit(externalInt(args[2])).Ids()
+		},
+	},
+	"search_count": {
+		params: []string{"domain"},
+		fnct: func(rc *RecordCollection, args []interface{}) interface{} {
+			return rc.externalSearch(args[0]).SearchCount()
+		},
+	},
+	"search_read": {
+		params: []string{"domain", "fields", "offset", "limit", "order"},
+		fnct: func(rc *RecordCollection, args []interface{}) interface{} {
+			domain, _ := args[0].([]interface{})
+			order, _ := args[4].(string)
+			return rc.Call("SearchRead", rc.model.ParseDomain(domain), externalStrings(args[1]),
+				externalInt(args[2]), externalInt(args[3]), order).(SearchReadResult).Records
+		},
+	},
+	"read": {
+		params:    []string{"fields"},
+		onRecords: true,
+		fnct: func(rc *RecordCollection, args []interface{}) interface{} {
+			return rc.Call("Read", externalStrings(args[0]))
+		},
+	},
+	"create": {
+		params: []string{"vals_list"},
+		fnct: func(rc *RecordCollection, args []interface{}) interface{} {
+			list, ok := args[0].([]interface{})
+			if !ok {
+				return rc.Call("Create", rc.externalValues(args[0])).(RecordSet).Ids()[0]
+			}
+			ids := make([]int64, len(list))
+			for i, vals := range list {
+				ids[i] = rc.Call("Create", rc.externalValues(vals)).(RecordSet).Ids()[0]
+			}
+			return ids
+		},
+	},
+	"write": {
+		params:    []string{"vals"},
+		onRecords: true,
+		fnct: func(rc *RecordCollection, args []interface{}) interface{} {
+			for _, rec := range rc.Records() {
+				rec.Call("Write", rec.externalValues(args[0]))
+			}
+			return true
+		},
+	},
+	"unlink": {
+		onRecords: true,
+		fnct: func(rc *RecordCollection, args []interface{}) interface{} {
+			rc.Call("Unlink")
+			return true
+		},
+	},
+	"copy": {
+		params:    []string{"default"},
+		onRecords: true,
+		fnct: func(rc *RecordCollection, args []interface{}) interface{} {
+			overrides := make(FieldMap)
+			if args[0] != nil {
+				overrides = rc.externalValues(args[0])
+			}
+			return rc.Call("Copy", overrides).(RecordSet).Ids()[0]
+		},
+	},
+	"exists": {
+		onRecords: true,
+		fnct: func(rc *RecordCollection, args []interface{}) interface{} {
+			return rc.env.Pool(rc.model.name).Search(rc.model.Field("ID").In(rc.ids)).Ids()
+		},
+	},
+	"name_get": {
+		onRecords: true,
+		fnct: func(rc *RecordCollection, args []interface{}) interface{} {
+			return rc.externalNames()
+		},
+	},
+	"name_search": {
+		params: []string{"name", "args", "operator", "limit"},
+		fnct: func(rc *RecordCollection, args []interface{}) interface{} {
+			name, _ := args[0].(string)
+			domain, _ := args[1].([]interface{})
+			op := operator.IContains
+			if o, _ := args[2].(string); o != "" {
+				op = operator.Operator(o)
+			}
+			limit := 100
+			if args[3] != nil {
+				limit = externalInt(args[3])
+			}
+			return rc.Call("SearchByName", name, op, rc.model.ParseDomain(domain), limit).(RecordSet).Collection().externalNames()
+		},
+	},
+	"fields_get": {
+		params: []string{"allfields", "attributes"},
+		fnct: func(rc *RecordCollection, args []interface{}) interface{} {
+			var fields []FieldName
+			for _, f := range externalStrings(args[0]) {
+				fields = append(fields, FieldName(f))
+			}
+			infos := rc.Call("FieldsGet", FieldsGetArgs{Fields: fields}).(map[string]*FieldInfo)
+			attributes := externalStrings(args[1])
+			if len(attributes) == 0 {
+				return infos
+			}
+			res := make(map[string]map[string]interface{}, len(infos))
+			for name, info := range infos {
+				data, _ := json.Marshal(info)
+				var all map[string]interface{}
+				json.Unmarshal(data, &all)
+				res[name] = make(map[string]interface{}, len(attributes))
+				for _, attr := range attributes {
+					if val, ok := all[attr]; ok {
+						res[name][attr] = val
+					}
+				}
+			}
+			return res
+		},
+	},
+}
+
+// ExecuteKW calls the given method of the Odoo external API with the given
+// positional and keyword arguments on the model with the given Odoo or Hexya
+// name, and returns its result in a form that can be encoded in JSON.
+//
+// The search, search_count, search_read, read, create, write, unlink, copy,
+// exists, name_get, name_search and fields_get methods are translated into
+// calls of the equivalent Hexya methods. Their domains are parsed with
+// ParseDomain and their values can hold the 3, 4, 5 and 6 x2many commands.
+//
+// Other methods are called by the Hexya method whose snake case name is the
+// method name, e.g. action_confirm calls ActionConfirm, with the method's first argument being the list of ids of
+// the records and the others being decoded as with CallRPC. The only
+// keyword argument they accept is context, which all methods accept.
+//
+// It panics if the model or the method does not exist, if the method is not
+// allowed by the APIScopes of the Environment, or if the arguments are invalid.
+func (env Environment) ExecuteKW(modelName, method string, args []interface{}, kwargs map[string]interface{}) interface{} {
+	model := externalModel(modelName)
+	env.checkAPIScopes(model.name, method)
+	if ctx, ok := kwargs["context"].(map[string]interface{}); ok {
+		env = env.WithNewContext(types.NewContext(ctx))
+	}
+	rc := env.Pool(model.name)
+	if extMeth, ok := externalMethods[method]; ok {
+		if extMeth.onRecords {
+			if len(args) == 0 {
+				log.Panic("Missing ids in external API call", "model", model.name, "method", method)
+			}
+			rc = rc.withIds(externalIDs(args[0]))
+			args = args[1:]
+		}
+		return extMeth.fnct(rc, externalArguments(method, extMeth.params, args, kwargs))
+	}
+	for key := range kwargs {
+		if key != "context" {
+			log.Panic("Unknown keyword argument in external API call", "model", model.name, "method", method, "argument", key)
+		}
+	}
+	if len(args) > 0 {
+		rc = rc.withIds(externalIDs(args[0]))
+		args = args[1:]
+	}
+	rawArgs := make([]json.RawMessage, len(args))
+	for i, arg := range args {
+		data, err := json.Marshal(arg)
+		if err != nil {
+			log.Panic("Unable to encode external API argument", "model", model.name, "method", method, "error", err)
+		}
+		rawArgs[i] = data
+	}
+	return rc.CallRPC(externalMethodName(model, method), rawArgs...)
+}
+
+// externalMethodName returns the name of the method of the given model
+// whose snake case name is the given name, or name itself if there is none.
+func externalMethodName(model *Model, name string) string {
+	if _, ok := model.methods.get(name); ok {
+		return name
+	}
+	for methName := range model.methods.registry {
+		if strutils.SnakeCaseString(methName) == name {
+			return methName
+		}
+	}
+	return name
+}
+
+// externalModel returns the model with the given Odoo or Hexya name.
+// It panics if there is no such model.
+func externalModel(name string) *Model {
+	if model, ok := Registry.Get(name); ok {
+		return model
+	}
+	if hexyaName, ok := OdooModelNames[name]; ok {
+		return Registry.MustGet(hexyaName)
+	}
+	tableName := strings.Replace(name, ".", "_", -1)
+	for _, prefix := range []string{"", "res_", "ir_"} {
+		if model, ok := Registry.Get(strings.TrimPrefix(tableName, prefix)); ok {
+			return model
+		}
+	}
+	log.Panic("Unknown model", "model", name)
+	return nil
+}
+
+// externalArguments returns the values of the given parameters of the given
+// method, taken from the given positional arguments and then from the keyword
+// arguments. Missing arguments are nil.
+func externalArguments(method string, params []string, args []interface{}, kwargs map[string]interface{}) []interface{} {
+	if len(args) > len(params) {
+		log.Panic("Too many arguments in external API call", "method", method, "expected", len(params))
+	}
+	res := make([]interface{}, len(params))
+	copy(res, args)
+kwargsLoop:
+	for key, value := range kwargs {
+		if key == "context" {
+			continue
+		}
+		for i, param := range params {
+			if key == param {
+				res[i] = value
+				continue kwargsLoop
+			}
+		}
+		log.Panic("Unknown keyword argument in external API call", "method", method, "argument", key)
+	}
+	return res
+}
+
+// externalSearch returns the records of this RecordCollection's model
+// matching the given domain, excluding archived records.
+func (rc *RecordCollection) externalSearch(domain interface{}) *RecordCollection {
+	list, _ := domain.([]interface{})
+	return rc.SearchAll().Call("Search", rc.model.ParseDomain(list)).(RecordSet).Collection()
+}
+
+// externalNames returns the [id, display name] pairs of this RecordCollection
+func (rc *RecordCollection) externalNames() [][]interface{} {
+	res := make([][]interface{}, 0, rc.Len())
+	for _, rec := range rc.Records() {
+		res = append(res, []interface{}{rec.ids[0], rec.Call("NameGet")})
+	}
+	return res
+}
+
+// externalValues returns the given field values of an external API call
+// as a FieldMap to write on this RecordCollection, which must be empty or
+// a singleton. Values of x2many fields can be lists of ids or lists of
+// commands, in which case they are applied to the current value of the
+// field.
+func (rc *RecordCollection) externalValues(values interface{}) FieldMap {
+	vals, ok := values.(map[string]interface{})
+	if !ok {
+		log.Panic("External API values must be a struct", "model", rc.model.name, "values", values)
+	}
+	res := make(FieldMap, len(vals))
+	for field, value := range vals {
+		fi := rc.model.fields.MustGet(field)
+		switch {
+		case fi.fieldType.Is2ManyRelationType():
+			res[fi.json] = rc.externalX2ManyValue(fi, value)
+		case fi.fieldType.Is2OneRelationType() && value != nil && value != false:
+			res[fi.json] = externalInt64(value)
+		default:
+			res[fi.json] = value
+		}
+	}
+	return res
+}
+
+// externalX2ManyValue returns the ids of the given x2many field of this
+// RecordCollection after applying the given list of ids or of commands.
+// Supported commands are:
+//
+//	[3, id]       removes the record with the given id
+//	[4, id]       adds the record with the given id
+//	[5]           removes all records
+//	[6, 0, ids]   replaces all records with the records of the given ids
+func (rc *RecordCollection) externalX2ManyValue(fi *Field, value interface{}) []int64 {
+	list, _ := value.([]interface{})
+	var ids []int64
+	if !rc.IsEmpty() {
+		ids = rc.Get(fi.name).(RecordSet).Ids()
+	}
+	for _, item := range list {
+		command, ok := item.([]interface{})
+		if !ok {
+			// Plain list of ids
+			return externalIDs(value)
+		}
+		if len(command) == 0 {
+			log.Panic("Empty x2many command", "model", rc.model.name, "field", fi.name)
+		}
+		switch externalInt(command[0]) {
+		case 3:
+			id := externalInt64(command[1])
+			var newIds []int64
+			for _, i := range ids {
+				if i != id {
+					newIds = append(newIds, i)
+				}
+			}
+			ids = newIds
+		case 4:
+			ids = append(ids, externalInt64(command[1]))
+		case 5:
+			ids = nil
+		case 6:
+			ids = externalIDs(command[2])
+		default:
+			log.Panic("Unsupported x2many command", "model", rc.model.name, "field", fi.name, "command", command)
+		}
+	}
+	return ids
+}
+
+// externalInt64 returns the given numeric value as an int64
+func externalInt64(value interface{}) int64 {
+	switch val := value.(type) {
+	case int64:
+		return val
+	case int:
+		return int64(val)
+	case float64:
+		return int64(val)
+	case nil, bool:
+		return 0
+	}
+	log.Panic("Expected an integer in external API call", "value", value)
+	return 0
+}
+
+// externalInt returns the given numeric value as an int
+func externalInt(value interface{}) int {
+	return int(externalInt64(value))
+}
+
+// externalIDs returns the given id or list of ids as a slice of ids
+func externalIDs(value interface{}) []int64 {
+	switch val := value.(type) {
+	case []int64:
+		return val
+	case []interface{}:
+		res := make([]int64, len(val))
+		for i, id := range val {
+			res[i] = externalInt64(id)
+		}
+		return res
+	case nil, bool:
+		return nil
+	}
+	return []int64{externalInt64(value)}
+}
+
+// externalStrings returns the given list of strings as a slice of strings
+func externalStrings(value interface{}) []string {
+	list, _ := value.([]interface{})
+	res := make([]string, 0, len(list))
+	for _, item := range list {
+		str, ok := item.(string)
+		if !ok {
+			log.Panic("Expected a list of strings in external API call", "value", value)
+		}
+		res = append(res, str)
+	}
+	return res
+}
@@ -0,0 +1,323 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/png"
+	"math/rand"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hexya-erp/hexya/hexya/models/fieldtype"
+	"github.com/hexya-erp/hexya/hexya/models/types/dates"
+	"github.com/hexya-erp/hexya/hexya/tools/nbutils"
+)
+
+// factoryMaxRelatedIDs is the maximum number of existing records
+// among which a Factory picks the values of relation fields.
+const factoryMaxRelatedIDs = 1000
+
+// factoryRelatedCount is the number of related records created by a Factory
+// for a required relation field when the related model has no records.
+const factoryRelatedCount = 10
+
+var (
+	fakeFirstNames = []string{"Alex", "Camille", "Charlie", "Dominique", "Jamie", "Jordan", "Kim", "Morgan",
+		"Noa", "Robin", "Sacha", "Sam", "Taylor"}
+	fakeLastNames = []string{"Bernard", "Dubois", "Garcia", "Jones", "Martin", "Miller", "Nguyen", "Petit",
+		"Rossi", "Schmidt", "Smith", "Suzuki", "Wilson"}
+	fakeCities  = []string{"Amsterdam", "Berlin", "Lisbon", "London", "Lyon", "Madrid", "Montreal", "Paris", "Rome", "Tokyo"}
+	fakeStreets = []string{"Chestnut Street", "High Street", "Main Street", "Market Street", "Oak Avenue",
+		"Park Road", "River Lane", "Station Road"}
+	fakeWords = strings.Fields(`lorem ipsum dolor sit amet consectetur adipiscing elit sed do eiusmod tempor
+		incididunt ut labore et dolore magna aliqua enim ad minim veniam quis nostrud exercitation ullamco laboris
+		nisi aliquip ex ea commodo consequat`)
+)
+
+// A FactoryGenerator returns the value of a field for the record of the
+// given sequence number created by a Factory.
+type FactoryGenerator func(env Environment, seq int) interface{}
+
+// A Factory creates records of a model with random but plausible values,
+// for instance to fill a database for load testing.
+//
+// Values are generated for all fields that can be set by the user, except
+// fields with a default value. Required, unique and size constraints are
+// respected, as well as selection values. Relation fields are given existing
+// records of their model, and related records are created if there are none
+// for a required relation.
+//
+// Values of fields that are checked by constraint methods should be given
+// with Set.
+type Factory struct {
+	model      *Model
+	rand       *rand.Rand
+	generators map[string]FactoryGenerator
+	relatedIDs map[string][]int64
+	parents    map[*Model]bool
+	seq        int
+	// FillRate is the probability that an optional field is given a value.
+	// It defaults to 0.8.
+	FillRate float64
+}
+
+// NewFactory returns a new Factory for the given model. Generated values only
+// depend on the given seed and on the records already in the database.
+func NewFactory(model *Model, seed int64) *Factory {
+	return &Factory{
+		model:      model,
+		rand:       rand.New(rand.NewSource(seed)),
+		generators: make(map[string]FactoryGenerator),
+		relatedIDs: make(map[string][]int64),
+		parents:    map[*Model]bool{model: true},
+		seq:        -1,
+		FillRate:   0.8,
+	}
+}
+
+// Set makes this Factory use the given generator for the given field
+// instead of random values. It returns the Factory so that calls can be
+// chained.
+func (f *Factory) Set(field string, generator FactoryGenerator) *Factory {
+	fi := f.model.fields.MustGet(field)
+	f.generators[fi.json] = generator
+	return f
+}
+
+// Create creates count records with generated values and returns them.
+func (f *Factory) Create(env Environment, count int) *RecordCollection {
+	res := env.Pool(f.model.name)
+	for i := 0; i < count; i++ {
+		rec := env.Pool(f.model.name).Call("Create", f.Data(env)).(RecordSet).Collection()
+		res = res.Union(rec)
+	}
+	return res
+}
+
+// Data returns the values of a new record with generated values.
+func (f *Factory) Data(env Environment) FieldMap {
+	if f.seq < 0 {
+		f.seq = env.Pool(f.model.name).SearchCount()
+	}
+	f.seq++
+	res := make(FieldMap)
+	var fields []*Field
+	for _, fi := range f.model.fields.registryByJSON {
+		fields = append(fields, fi)
+	}
+	// Sort fields so that values only depend on the seed
+	sort.Slice(fields, func(i, j int) bool {
+		return fields[i].json < fields[j].json
+	})
+	for _, fi := range fields {
+		if gen, ok := f.generators[fi.json]; ok {
+			res[fi.json] = gen(env, f.seq)
+			continue
+		}
+		if !f.isGenerated(fi) || (!fi.required && f.rand.Float64() >= f.FillRate) {
+			continue
+		}
+		if val := f.fieldValue(env, fi); val != nil {
+			res[fi.json] = val
+		}
+	}
+	return res
+}
+
+// isGenerated returns true if this Factory must generate a value for the given field
+func (f *Factory) isGenerated(fi *Field) bool {
+	switch {
+	case fi.name == "ID", !fi.isStored(), fi.isReadOnly(), fi.isRelatedField(),
+		fi.embed, fi.counterOf != "", fi.defaultFunc != nil:
+		return false
+	}
+	if _, exists := Registry.MustGet("BaseMixin").fields.Get(fi.name); exists {
+		return false
+	}
+	return true
+}
+
+// fieldValue returns a random value for the given field
+func (f *Factory) fieldValue(env Environment, fi *Field) interface{} {
+	switch fi.fieldType {
+	case fieldtype.Boolean:
+		return f.rand.Intn(2) == 1
+	case fieldtype.Char:
+		return f.charValue(fi)
+	case fieldtype.Text:
+		return f.sentences(1 + f.rand.Intn(3))
+	case fieldtype.HTML:
+		return fmt.Sprintf("<p>%s</p>", f.sentences(1+f.rand.Intn(3)))
+	case fieldtype.Integer:
+		if fi.unique {
+			return int64(f.seq)
+		}
+		return int64(f.rand.Intn(1000))
+	case fieldtype.Float, fieldtype.Monetary:
+		val := f.rand.Float64() * 10000
+		if fi.digits != (nbutils.Digits{}) {
+			val = nbutils.Round(val, fi.digits.ToPrecision())
+		}
+		return val
+	case fieldtype.Date:
+		return dates.Today().AddDate(0, 0, -f.rand.Intn(730))
+	case fieldtype.DateTime:
+		return dates.Now().Add(-time.Duration(f.rand.Int63n(int64(730 * 24 * time.Hour))))
+	case fieldtype.Selection:
+		return f.selectionValue(fi)
+	case fieldtype.Binary:
+		if !fi.required {
+			return nil
+		}
+		return f.binaryValue(fi)
+	case fieldtype.Many2One:
+		ids := f.existingIDs(env, fi)
+		if len(ids) == 0 {
+			return nil
+		}
+		return ids[f.rand.Intn(len(ids))]
+	case fieldtype.One2One:
+		if !fi.required {
+			return nil
+		}
+		// One2one targets cannot be shared, so we create a new one
+		return f.relatedFactory(fi).Create(env, 1).ids[0]
+	case fieldtype.Many2Many:
+		ids := f.existingIDs(env, fi)
+		if len(ids) == 0 {
+			return nil
+		}
+		selected := make(map[int64]bool)
+		for i := 0; i < 1+f.rand.Intn(3); i++ {
+			selected[ids[f.rand.Intn(len(ids))]] = true
+		}
+		var res []int64
+		for id := range selected {
+			res = append(res, id)
+		}
+		sort.Slice(res, func(i, j int) bool { return res[i] < res[j] })
+		return res
+	}
+	return nil
+}
+
+// charValue returns a random value for the given char field,
+// with a content guessed from the field's name.
+func (f *Factory) charValue(fi *Field) string {
+	first, last := f.pick(fakeFirstNames), f.pick(fakeLastNames)
+	name := strings.ToLower(fi.json)
+	var res string
+	switch {
+	case strings.Contains(name, "email"):
+		res = strings.ToLower(fmt.Sprintf("%s.%s@example.com", first, last))
+	case strings.Contains(name, "phone"), strings.Contains(name, "mobile"), strings.Contains(name, "fax"):
+		res = fmt.Sprintf("+1 555 %03d %04d", f.rand.Intn(1000), f.rand.Intn(10000))
+	case strings.Contains(name, "street"):
+		res = fmt.Sprintf("%d %s", 1+f.rand.Intn(200), f.pick(fakeStreets))
+	case strings.Contains(name, "city"):
+		res = f.pick(fakeCities)
+	case strings.Contains(name, "zip"):
+		res = fmt.Sprintf("%05d", f.rand.Intn(100000))
+	case strings.Contains(name, "url"), strings.Contains(name, "website"):
+		res = fmt.Sprintf("https://www.%s.example.com", strings.ToLower(last))
+	case strings.Contains(name, "code"), strings.Contains(name, "ref"):
+		res = fmt.Sprintf("%s%05d", strings.ToUpper(f.pick(fakeWords)[:2]), f.rand.Intn(100000))
+	case name == "name" || strings.HasSuffix(name, "_name"):
+		res = fmt.Sprintf("%s %s", first, last)
+	default:
+		words := make([]string, 1+f.rand.Intn(3))
+		for i := range words {
+			words[i] = f.pick(fakeWords)
+		}
+		res = strings.Title(strings.Join(words, " "))
+	}
+	if fi.unique {
+		res = fmt.Sprintf("%s %d", res, f.seq)
+	}
+	if fi.size > 0 && len(res) > fi.size {
+		res = res[len(res)-fi.size:]
+	}
+	return res
+}
+
+// sentences returns the given number of random sentences
+func (f *Factory) sentences(count int) string {
+	res := make([]string, count)
+	for i := range res {
+		words := make([]string, 5+f.rand.Intn(10))
+		for j := range words {
+			words[j] = f.pick(fakeWords)
+		}
+		sentence := strings.Join(words, " ")
+		res[i] = strings.ToUpper(sentence[:1]) + sentence[1:] + "."
+	}
+	return strings.Join(res, " ")
+}
+
+// selectionValue returns a random key of the given selection field
+func (f *Factory) selectionValue(fi *Field) interface{} {
+	if len(fi.selection) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(fi.selection))
+	for key := range fi.selection {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return f.pick(keys)
+}
+
+// binaryValue returns a base64 encoded value for the given binary field.
+// Image fields are given a small PNG image.
+func (f *Factory) binaryValue(fi *Field) string {
+	if fi.imageSizes == nil {
+		content := make([]byte, 64)
+		f.rand.Read(content)
+		return base64.StdEncoding.EncodeToString(content)
+	}
+	var buf bytes.Buffer
+	png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 16, 16)))
+	return base64.StdEncoding.EncodeToString(buf.Bytes())
+}
+
+// existingIDs returns ids of records of the model of the given relation
+// field. If there are none and the field is required, records are created.
+func (f *Factory) existingIDs(env Environment, fi *Field) []int64 {
+	ids, ok := f.relatedIDs[fi.relatedModelName]
+	if ok && len(ids) > 0 {
+		return ids
+	}
+	ids = env.Pool(fi.relatedModelName).SearchAll().Limit(factoryMaxRelatedIDs).Ids()
+	if len(ids) == 0 && fi.required {
+		ids = f.relatedFactory(fi).Create(env, factoryRelatedCount).Ids()
+	}
+	f.relatedIDs[fi.relatedModelName] = ids
+	return ids
+}
+
+// relatedFactory returns a new Factory for the model of the given relation
+// field. It panics if the relation is a cycle of required fields, since
+// records of such models cannot be created.
+func (f *Factory) relatedFactory(fi *Field) *Factory {
+	if f.parents[fi.relatedModel] {
+		log.Panic("Unable to generate records for a cycle of required relations", "model", f.model.name,
+			"field", fi.name, "relatedModel", fi.relatedModelName)
+	}
+	res := NewFactory(fi.relatedModel, f.rand.Int63())
+	res.FillRate = f.FillRate
+	for m := range f.parents {
+		res.parents[m] = true
+	}
+	return res
+}
+
+// pick returns a random element of the given slice
+func (f *Factory) pick(values []string) string {
+	return values[f.rand.Intn(len(values))]
+}
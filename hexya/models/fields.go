@@ -58,22 +58,27 @@ type computeData struct {
 // FieldsCollection is a collection of Field instances in a model.
 type FieldsCollection struct {
 	sync.RWMutex
-	model                *Model
-	registryByName       map[string]*Field
-	registryByJSON       map[string]*Field
-	computedFields       []*Field
-	computedStoredFields []*Field
-	relatedFields        []*Field
-	bootstrapped         bool
+	model                  *Model
+	registryByName         map[string]*Field
+	registryByJSON         map[string]*Field
+	registryByPreviousName map[string]*Field
+	computedFields         []*Field
+	computedStoredFields   []*Field
+	relatedFields          []*Field
+	bootstrapped           bool
 }
 
 // Get returns the Field of the field with the given name.
-// name can be either the name of the field or its JSON name.
+// name can be either the name of the field or its JSON name,
+// or a previous name or JSON name of a renamed field.
 func (fc *FieldsCollection) Get(name string) (fi *Field, ok bool) {
 	fi, ok = fc.registryByName[name]
 	if !ok {
 		fi, ok = fc.registryByJSON[name]
 	}
+	if !ok {
+		fi, ok = fc.registryByPreviousName[name]
+	}
 	return
 }
 
@@ -134,8 +139,9 @@ func (fc *FieldsCollection) getComputedFields(fields ...string) (fil []*Field) {
 // all maps initialized.
 func newFieldsCollection() *FieldsCollection {
 	return &FieldsCollection{
-		registryByName: make(map[string]*Field),
-		registryByJSON: make(map[string]*Field),
+		registryByName:         make(map[string]*Field),
+		registryByJSON:         make(map[string]*Field),
+		registryByPreviousName: make(map[string]*Field),
 	}
 }
 
@@ -157,6 +163,10 @@ func (fc *FieldsCollection) register(fInfo *Field) {
 	jsonName := fInfo.json
 	fc.registryByName[name] = fInfo
 	fc.registryByJSON[jsonName] = fInfo
+	for _, oldName := range fInfo.previousNames {
+		fc.registryByPreviousName[oldName] = fInfo
+		fc.registryByPreviousName[snakeCaseFieldName(oldName, fInfo.fieldType)] = fInfo
+	}
 	if fInfo.isComputedField() {
 		if fInfo.stored {
 			fc.computedStoredFields = append(fc.computedStoredFields, fInfo)
@@ -195,12 +205,14 @@ type Field struct {
 	fieldType        fieldtype.Type
 	groupOperator    string
 	size             int
+	bigInt           bool
 	digits           nbutils.Digits
 	structField      reflect.StructField
 	relatedPath      string
 	dependencies     []computeData
 	embed            bool
 	noCopy           bool
+	tracking         bool
 	defaultFunc      func(Environment) interface{}
 	onDelete         OnDeleteAction
 	onChange         string
@@ -208,6 +220,20 @@ type Field struct {
 	inverse          string
 	filter           *Condition
 	translate        bool
+	collation        string
+	unaccent         bool
+	unaccentSearch   bool
+	fullText         string
+	companyDependent bool
+	cachePolicy      *CachePolicy
+	counterOf        string
+	counters         []*Field
+	attachment       bool
+	imageSizes       []int
+	currencyField    string
+	embeddedSchema   map[string]fieldtype.Type
+	embeddedRequired []string
+	previousNames    []string
 	updates          []map[string]interface{}
 }
 
@@ -238,6 +264,10 @@ func (f *Field) isStored() bool {
 		// Computed and related non stored fields are not stored
 		return false
 	}
+	if f.companyDependent {
+		// Company dependent fields are stored in the FieldProperty model
+		return false
+	}
 	return true
 }
 
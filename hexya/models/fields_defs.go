@@ -26,8 +26,9 @@ type FieldDefinition interface {
 //
 // Clients are expected to handle binary fields as file uploads.
 //
-// Binary fields are stored in the database. Consider other disk based
-// alternatives if you have a large amount of data to store.
+// Binary fields are stored in the database, unless Attachment is set. In
+// this case, the content is stored in the Filestore and only its checksum is
+// kept in the database and in the cache. Use Attachment for large contents.
 type BinaryField struct {
 	JSON       string
 	String     string
@@ -47,6 +48,7 @@ type BinaryField struct {
 	Constraint Methoder
 	Inverse    Methoder
 	Default    func(Environment) interface{}
+	Attachment bool
 }
 
 // DeclareField creates a binary field for the given FieldsCollection with the given name.
@@ -86,6 +88,7 @@ func (bf BinaryField) DeclareField(fc *FieldsCollection, name string) *Field {
 		translate:     bf.Translate,
 		onChange:      onchange,
 		constraint:    constraint,
+		attachment:    bf.Attachment,
 	}
 	return fInfo
 }
@@ -94,25 +97,27 @@ func (bf BinaryField) DeclareField(fc *FieldsCollection, name string) *Field {
 //
 // Clients are expected to handle boolean fields as checkboxes.
 type BooleanField struct {
-	JSON          string
-	String        string
-	Help          string
-	Stored        bool
-	Required      bool
-	ReadOnly      bool
-	Unique        bool
-	Index         bool
-	Compute       Methoder
-	Depends       []string
-	Related       string
-	GroupOperator string
-	NoCopy        bool
-	GoType        interface{}
-	Translate     bool
-	OnChange      Methoder
-	Constraint    Methoder
-	Inverse       Methoder
-	Default       func(Environment) interface{}
+	JSON             string
+	String           string
+	Help             string
+	Stored           bool
+	Required         bool
+	ReadOnly         bool
+	Unique           bool
+	Index            bool
+	Compute          Methoder
+	Depends          []string
+	Related          string
+	GroupOperator    string
+	NoCopy           bool
+	Tracking         bool
+	GoType           interface{}
+	Translate        bool
+	CompanyDependent bool
+	OnChange         Methoder
+	Constraint       Methoder
+	Inverse          Methoder
+	Default          func(Environment) interface{}
 }
 
 // DeclareField creates a boolean field for the given FieldsCollection with the given name.
@@ -137,29 +142,31 @@ func (bf BooleanField) DeclareField(fc *FieldsCollection, name string) *Field {
 		required = false
 	}
 	fInfo := &Field{
-		model:         fc.model,
-		acl:           security.NewAccessControlList(),
-		name:          name,
-		json:          json,
-		description:   str,
-		help:          bf.Help,
-		stored:        bf.Stored,
-		required:      required,
-		readOnly:      bf.ReadOnly,
-		unique:        bf.Unique,
-		index:         bf.Index,
-		compute:       compute,
-		inverse:       inverse,
-		depends:       bf.Depends,
-		relatedPath:   bf.Related,
-		groupOperator: strutils.GetDefaultString(bf.GroupOperator, "sum"),
-		noCopy:        bf.NoCopy,
-		structField:   structField,
-		fieldType:     fieldType,
-		defaultFunc:   defaultFunc,
-		translate:     bf.Translate,
-		onChange:      onchange,
-		constraint:    constraint,
+		model:            fc.model,
+		acl:              security.NewAccessControlList(),
+		name:             name,
+		json:             json,
+		description:      str,
+		help:             bf.Help,
+		stored:           bf.Stored,
+		required:         required,
+		readOnly:         bf.ReadOnly,
+		unique:           bf.Unique,
+		index:            bf.Index,
+		compute:          compute,
+		inverse:          inverse,
+		depends:          bf.Depends,
+		relatedPath:      bf.Related,
+		groupOperator:    strutils.GetDefaultString(bf.GroupOperator, "sum"),
+		noCopy:           bf.NoCopy,
+		tracking:         bf.Tracking,
+		structField:      structField,
+		fieldType:        fieldType,
+		defaultFunc:      defaultFunc,
+		translate:        bf.Translate,
+		companyDependent: bf.CompanyDependent,
+		onChange:         onchange,
+		constraint:       constraint,
 	}
 	return fInfo
 }
@@ -168,27 +175,41 @@ func (bf BooleanField) DeclareField(fc *FieldsCollection, name string) *Field {
 // default max size, but it can be forced by setting the Size value.
 //
 // Clients are expected to handle Char fields as single line inputs.
+//
+// Records are ordered by the byte order of the values, unless Collation is
+// set to the name of a database collation (e.g. an ICU collation such as
+// "fr-x-icu"). If Unaccent is set, accents are ignored when ordering. If
+// UnaccentSearch is set, accents are ignored by case-insensitive searches.
+//
+// If FullText is set to the name of a text search configuration (e.g.
+// "english" or "simple"), the field can be searched with the Match operator.
 type CharField struct {
-	JSON          string
-	String        string
-	Help          string
-	Stored        bool
-	Required      bool
-	ReadOnly      bool
-	Unique        bool
-	Index         bool
-	Compute       Methoder
-	Depends       []string
-	Related       string
-	GroupOperator string
-	NoCopy        bool
-	Size          int
-	GoType        interface{}
-	Translate     bool
-	OnChange      Methoder
-	Constraint    Methoder
-	Inverse       Methoder
-	Default       func(Environment) interface{}
+	JSON             string
+	String           string
+	Help             string
+	Stored           bool
+	Required         bool
+	ReadOnly         bool
+	Unique           bool
+	Index            bool
+	Compute          Methoder
+	Depends          []string
+	Related          string
+	GroupOperator    string
+	NoCopy           bool
+	Tracking         bool
+	Size             int
+	GoType           interface{}
+	Translate        bool
+	Collation        string
+	Unaccent         bool
+	UnaccentSearch   bool
+	FullText         string
+	CompanyDependent bool
+	OnChange         Methoder
+	Constraint       Methoder
+	Inverse          Methoder
+	Default          func(Environment) interface{}
 }
 
 // DeclareField creates a char field for the given FieldsCollection with the given name.
@@ -204,6 +225,64 @@ func (cf CharField) DeclareField(fc *FieldsCollection, name string) *Field {
 	fieldType := fieldtype.Char
 	json, str := getJSONAndString(name, fieldType, cf.JSON, cf.String)
 	compute, inverse, onchange, constraint := getFuncNames(cf.Compute, cf.Inverse, cf.OnChange, cf.Constraint)
+	fInfo := &Field{
+		model:            fc.model,
+		acl:              security.NewAccessControlList(),
+		name:             name,
+		json:             json,
+		description:      str,
+		help:             cf.Help,
+		stored:           cf.Stored,
+		required:         cf.Required,
+		readOnly:         cf.ReadOnly,
+		unique:           cf.Unique,
+		index:            cf.Index,
+		compute:          compute,
+		inverse:          inverse,
+		depends:          cf.Depends,
+		relatedPath:      cf.Related,
+		groupOperator:    strutils.GetDefaultString(cf.GroupOperator, "sum"),
+		noCopy:           cf.NoCopy,
+		tracking:         cf.Tracking,
+		structField:      structField,
+		size:             cf.Size,
+		fieldType:        fieldType,
+		defaultFunc:      cf.Default,
+		translate:        cf.Translate,
+		collation:        cf.Collation,
+		unaccent:         cf.Unaccent,
+		unaccentSearch:   cf.UnaccentSearch,
+		fullText:         cf.FullText,
+		companyDependent: cf.CompanyDependent,
+		onChange:         onchange,
+		constraint:       constraint,
+	}
+	return fInfo
+}
+
+// A CountField is a stored integer field that holds the number of records
+// of the One2Many field of the same model given by its name in One2Many.
+//
+// The counter is updated incrementally when related records are created,
+// deleted or change of parent, so that it is not computed at each read.
+// One2Many fields with a Filter cannot be counted.
+type CountField struct {
+	JSON          string
+	String        string
+	Help          string
+	Index         bool
+	GroupOperator string
+	One2Many      string
+}
+
+// DeclareField creates a count field for the given FieldsCollection with the given name.
+func (cf CountField) DeclareField(fc *FieldsCollection, name string) *Field {
+	structField := reflect.StructField{
+		Name: name,
+		Type: reflect.TypeOf(*new(int64)),
+	}
+	fieldType := fieldtype.Integer
+	json, str := getJSONAndString(name, fieldType, cf.JSON, cf.String)
 	fInfo := &Field{
 		model:         fc.model,
 		acl:           security.NewAccessControlList(),
@@ -211,24 +290,14 @@ func (cf CharField) DeclareField(fc *FieldsCollection, name string) *Field {
 		json:          json,
 		description:   str,
 		help:          cf.Help,
-		stored:        cf.Stored,
-		required:      cf.Required,
-		readOnly:      cf.ReadOnly,
-		unique:        cf.Unique,
+		stored:        true,
+		readOnly:      true,
 		index:         cf.Index,
-		compute:       compute,
-		inverse:       inverse,
-		depends:       cf.Depends,
-		relatedPath:   cf.Related,
 		groupOperator: strutils.GetDefaultString(cf.GroupOperator, "sum"),
-		noCopy:        cf.NoCopy,
+		noCopy:        true,
 		structField:   structField,
-		size:          cf.Size,
 		fieldType:     fieldType,
-		defaultFunc:   cf.Default,
-		translate:     cf.Translate,
-		onChange:      onchange,
-		constraint:    constraint,
+		counterOf:     cf.One2Many,
 	}
 	return fInfo
 }
@@ -237,25 +306,27 @@ func (cf CharField) DeclareField(fc *FieldsCollection, name string) *Field {
 //
 // Clients are expected to handle Date fields with a date picker.
 type DateField struct {
-	JSON          string
-	String        string
-	Help          string
-	Stored        bool
-	Required      bool
-	ReadOnly      bool
-	Unique        bool
-	Index         bool
-	Compute       Methoder
-	Depends       []string
-	Related       string
-	GroupOperator string
-	NoCopy        bool
-	GoType        interface{}
-	Translate     bool
-	OnChange      Methoder
-	Constraint    Methoder
-	Inverse       Methoder
-	Default       func(Environment) interface{}
+	JSON             string
+	String           string
+	Help             string
+	Stored           bool
+	Required         bool
+	ReadOnly         bool
+	Unique           bool
+	Index            bool
+	Compute          Methoder
+	Depends          []string
+	Related          string
+	GroupOperator    string
+	NoCopy           bool
+	Tracking         bool
+	GoType           interface{}
+	Translate        bool
+	CompanyDependent bool
+	OnChange         Methoder
+	Constraint       Methoder
+	Inverse          Methoder
+	Default          func(Environment) interface{}
 }
 
 // DeclareField creates a date field for the given FieldsCollection with the given name.
@@ -272,29 +343,31 @@ func (df DateField) DeclareField(fc *FieldsCollection, name string) *Field {
 	json, str := getJSONAndString(name, fieldType, df.JSON, df.String)
 	compute, inverse, onchange, constraint := getFuncNames(df.Compute, df.Inverse, df.OnChange, df.Constraint)
 	fInfo := &Field{
-		model:         fc.model,
-		acl:           security.NewAccessControlList(),
-		name:          name,
-		json:          json,
-		description:   str,
-		help:          df.Help,
-		stored:        df.Stored,
-		required:      df.Required,
-		readOnly:      df.ReadOnly,
-		unique:        df.Unique,
-		index:         df.Index,
-		compute:       compute,
-		inverse:       inverse,
-		depends:       df.Depends,
-		relatedPath:   df.Related,
-		groupOperator: strutils.GetDefaultString(df.GroupOperator, "sum"),
-		noCopy:        df.NoCopy,
-		structField:   structField,
-		fieldType:     fieldType,
-		defaultFunc:   df.Default,
-		translate:     df.Translate,
-		onChange:      onchange,
-		constraint:    constraint,
+		model:            fc.model,
+		acl:              security.NewAccessControlList(),
+		name:             name,
+		json:             json,
+		description:      str,
+		help:             df.Help,
+		stored:           df.Stored,
+		required:         df.Required,
+		readOnly:         df.ReadOnly,
+		unique:           df.Unique,
+		index:            df.Index,
+		compute:          compute,
+		inverse:          inverse,
+		depends:          df.Depends,
+		relatedPath:      df.Related,
+		groupOperator:    strutils.GetDefaultString(df.GroupOperator, "sum"),
+		noCopy:           df.NoCopy,
+		tracking:         df.Tracking,
+		structField:      structField,
+		fieldType:        fieldType,
+		defaultFunc:      df.Default,
+		translate:        df.Translate,
+		companyDependent: df.CompanyDependent,
+		onChange:         onchange,
+		constraint:       constraint,
 	}
 	return fInfo
 }
@@ -303,25 +376,27 @@ func (df DateField) DeclareField(fc *FieldsCollection, name string) *Field {
 //
 // Clients are expected to handle DateTime fields with a date and time picker.
 type DateTimeField struct {
-	JSON          string
-	String        string
-	Help          string
-	Stored        bool
-	Required      bool
-	ReadOnly      bool
-	Unique        bool
-	Index         bool
-	Compute       Methoder
-	Depends       []string
-	Related       string
-	GroupOperator string
-	NoCopy        bool
-	GoType        interface{}
-	Translate     bool
-	OnChange      Methoder
-	Constraint    Methoder
-	Inverse       Methoder
-	Default       func(Environment) interface{}
+	JSON             string
+	String           string
+	Help             string
+	Stored           bool
+	Required         bool
+	ReadOnly         bool
+	Unique           bool
+	Index            bool
+	Compute          Methoder
+	Depends          []string
+	Related          string
+	GroupOperator    string
+	NoCopy           bool
+	Tracking         bool
+	GoType           interface{}
+	Translate        bool
+	CompanyDependent bool
+	OnChange         Methoder
+	Constraint       Methoder
+	Inverse          Methoder
+	Default          func(Environment) interface{}
 }
 
 // DeclareField creates a datetime field for the given FieldsCollection with the given name.
@@ -338,55 +413,135 @@ func (df DateTimeField) DeclareField(fc *FieldsCollection, name string) *Field {
 	json, str := getJSONAndString(name, fieldType, df.JSON, df.String)
 	compute, inverse, onchange, constraint := getFuncNames(df.Compute, df.Inverse, df.OnChange, df.Constraint)
 	fInfo := &Field{
-		model:         fc.model,
-		acl:           security.NewAccessControlList(),
-		name:          name,
-		json:          json,
-		description:   str,
-		help:          df.Help,
-		stored:        df.Stored,
-		required:      df.Required,
-		readOnly:      df.ReadOnly,
-		unique:        df.Unique,
-		index:         df.Index,
-		compute:       compute,
-		inverse:       inverse,
-		depends:       df.Depends,
-		relatedPath:   df.Related,
-		groupOperator: strutils.GetDefaultString(df.GroupOperator, "sum"),
-		noCopy:        df.NoCopy,
-		structField:   structField,
-		fieldType:     fieldType,
-		defaultFunc:   df.Default,
-		translate:     df.Translate,
-		onChange:      onchange,
-		constraint:    constraint,
+		model:            fc.model,
+		acl:              security.NewAccessControlList(),
+		name:             name,
+		json:             json,
+		description:      str,
+		help:             df.Help,
+		stored:           df.Stored,
+		required:         df.Required,
+		readOnly:         df.ReadOnly,
+		unique:           df.Unique,
+		index:            df.Index,
+		compute:          compute,
+		inverse:          inverse,
+		depends:          df.Depends,
+		relatedPath:      df.Related,
+		groupOperator:    strutils.GetDefaultString(df.GroupOperator, "sum"),
+		noCopy:           df.NoCopy,
+		tracking:         df.Tracking,
+		structField:      structField,
+		fieldType:        fieldType,
+		defaultFunc:      df.Default,
+		translate:        df.Translate,
+		companyDependent: df.CompanyDependent,
+		onChange:         onchange,
+		constraint:       constraint,
+	}
+	return fInfo
+}
+
+// An EmbeddedListField is a field for storing an ordered list of small
+// sub-records, such as the answers to a dynamic survey, for cases where
+// declaring a model with a one2many relation would be overkill.
+//
+// The sub-records are stored in a jsonb column and handled as a
+// types.EmbeddedList. Their keys and the types of their values are given
+// by Schema. Supported types are Boolean, Char, Text, HTML, Selection,
+// Integer, Float, Monetary, Date and DateTime. Sub-records are validated
+// against the schema when they are written.
+//
+// Clients are expected to handle embedded list fields as editable tables.
+type EmbeddedListField struct {
+	JSON         string
+	String       string
+	Help         string
+	Stored       bool
+	Required     bool
+	ReadOnly     bool
+	Compute      Methoder
+	Depends      []string
+	Related      string
+	NoCopy       bool
+	Schema       map[string]fieldtype.Type
+	RequiredKeys []string
+	OnChange     Methoder
+	Constraint   Methoder
+	Inverse      Methoder
+	Default      func(Environment) interface{}
+}
+
+// DeclareField creates an embedded list field for the given FieldsCollection with the given name.
+func (ef EmbeddedListField) DeclareField(fc *FieldsCollection, name string) *Field {
+	structField := reflect.StructField{
+		Name: name,
+		Type: reflect.TypeOf(*new(types.EmbeddedList)),
+	}
+	for key, typ := range ef.Schema {
+		if !embeddedTypes[typ] {
+			log.Panic("Unsupported type in embedded list schema", "model", fc.model.name, "field", name,
+				"key", key, "type", typ)
+		}
+	}
+	for _, key := range ef.RequiredKeys {
+		if _, ok := ef.Schema[key]; !ok {
+			log.Panic("Required key is not in embedded list schema", "model", fc.model.name, "field", name, "key", key)
+		}
+	}
+	fieldType := fieldtype.EmbeddedList
+	json, str := getJSONAndString(name, fieldType, ef.JSON, ef.String)
+	compute, inverse, onchange, constraint := getFuncNames(ef.Compute, ef.Inverse, ef.OnChange, ef.Constraint)
+	fInfo := &Field{
+		model:            fc.model,
+		acl:              security.NewAccessControlList(),
+		name:             name,
+		json:             json,
+		description:      str,
+		help:             ef.Help,
+		stored:           ef.Stored,
+		required:         ef.Required,
+		readOnly:         ef.ReadOnly,
+		compute:          compute,
+		inverse:          inverse,
+		depends:          ef.Depends,
+		relatedPath:      ef.Related,
+		noCopy:           ef.NoCopy,
+		structField:      structField,
+		fieldType:        fieldType,
+		defaultFunc:      ef.Default,
+		embeddedSchema:   ef.Schema,
+		embeddedRequired: ef.RequiredKeys,
+		onChange:         onchange,
+		constraint:       constraint,
 	}
 	return fInfo
 }
 
 // A FloatField is a field for storing decimal numbers.
 type FloatField struct {
-	JSON          string
-	String        string
-	Help          string
-	Stored        bool
-	Required      bool
-	ReadOnly      bool
-	Unique        bool
-	Index         bool
-	Compute       Methoder
-	Depends       []string
-	Related       string
-	GroupOperator string
-	NoCopy        bool
-	Digits        nbutils.Digits
-	GoType        interface{}
-	Translate     bool
-	OnChange      Methoder
-	Constraint    Methoder
-	Inverse       Methoder
-	Default       func(Environment) interface{}
+	JSON             string
+	String           string
+	Help             string
+	Stored           bool
+	Required         bool
+	ReadOnly         bool
+	Unique           bool
+	Index            bool
+	Compute          Methoder
+	Depends          []string
+	Related          string
+	GroupOperator    string
+	NoCopy           bool
+	Tracking         bool
+	Digits           nbutils.Digits
+	GoType           interface{}
+	Translate        bool
+	CompanyDependent bool
+	OnChange         Methoder
+	Constraint       Methoder
+	Inverse          Methoder
+	Default          func(Environment) interface{}
 }
 
 // DeclareField adds this datetime field for the given FieldsCollection with the given name.
@@ -402,30 +557,32 @@ func (ff FloatField) DeclareField(fc *FieldsCollection, name string) *Field {
 	json, str := getJSONAndString(name, fieldtype.Float, ff.JSON, ff.String)
 	compute, inverse, onchange, constraint := getFuncNames(ff.Compute, ff.Inverse, ff.OnChange, ff.Constraint)
 	fInfo := &Field{
-		model:         fc.model,
-		acl:           security.NewAccessControlList(),
-		name:          name,
-		json:          json,
-		description:   str,
-		help:          ff.Help,
-		stored:        ff.Stored,
-		required:      ff.Required,
-		readOnly:      ff.ReadOnly,
-		unique:        ff.Unique,
-		index:         ff.Index,
-		compute:       compute,
-		inverse:       inverse,
-		depends:       ff.Depends,
-		relatedPath:   ff.Related,
-		groupOperator: strutils.GetDefaultString(ff.GroupOperator, "sum"),
-		noCopy:        ff.NoCopy,
-		structField:   structField,
-		digits:        ff.Digits,
-		fieldType:     fieldtype.Float,
-		defaultFunc:   ff.Default,
-		translate:     ff.Translate,
-		onChange:      onchange,
-		constraint:    constraint,
+		model:            fc.model,
+		acl:              security.NewAccessControlList(),
+		name:             name,
+		json:             json,
+		description:      str,
+		help:             ff.Help,
+		stored:           ff.Stored,
+		required:         ff.Required,
+		readOnly:         ff.ReadOnly,
+		unique:           ff.Unique,
+		index:            ff.Index,
+		compute:          compute,
+		inverse:          inverse,
+		depends:          ff.Depends,
+		relatedPath:      ff.Related,
+		groupOperator:    strutils.GetDefaultString(ff.GroupOperator, "sum"),
+		noCopy:           ff.NoCopy,
+		tracking:         ff.Tracking,
+		structField:      structField,
+		digits:           ff.Digits,
+		fieldType:        fieldtype.Float,
+		defaultFunc:      ff.Default,
+		translate:        ff.Translate,
+		companyDependent: ff.CompanyDependent,
+		onChange:         onchange,
+		constraint:       constraint,
 	}
 	return fInfo
 }
@@ -498,27 +655,106 @@ func (tf HTMLField) DeclareField(fc *FieldsCollection, name string) *Field {
 	return fInfo
 }
 
+// DefaultImageSizes are the sizes of the variants of ImageField
+// contents when the field's Sizes are not set.
+var DefaultImageSizes = []int{1920, 512, 128}
+
+// An ImageField is a binary field for storing JPEG, PNG or GIF images.
+//
+// Images are always stored in the Filestore, like attachment binary fields.
+// When an image is written, its format is checked and a resized variant is
+// generated for each of the given Sizes. A variant is scaled down so that
+// neither its width nor its height exceeds its size. Variants are retrieved
+// with RecordCollection.GetImage or RecordCollection.OpenImage.
+//
+// Clients are expected to handle image fields as image uploads.
+type ImageField struct {
+	JSON       string
+	String     string
+	Help       string
+	Stored     bool
+	Required   bool
+	ReadOnly   bool
+	Compute    Methoder
+	Depends    []string
+	Related    string
+	NoCopy     bool
+	OnChange   Methoder
+	Constraint Methoder
+	Inverse    Methoder
+	Default    func(Environment) interface{}
+	Sizes      []int
+}
+
+// DeclareField creates an image field for the given FieldsCollection with the given name.
+func (imf ImageField) DeclareField(fc *FieldsCollection, name string) *Field {
+	structField := reflect.StructField{
+		Name: name,
+		Type: reflect.TypeOf(*new(string)),
+	}
+	sizes := DefaultImageSizes
+	if imf.Sizes != nil {
+		sizes = imf.Sizes
+	}
+	sizes = append([]int(nil), sizes...)
+	sort.Ints(sizes)
+	fieldType := fieldtype.Binary
+	json, str := getJSONAndString(name, fieldType, imf.JSON, imf.String)
+	compute, inverse, onchange, constraint := getFuncNames(imf.Compute, imf.Inverse, imf.OnChange, imf.Constraint)
+	fInfo := &Field{
+		model:       fc.model,
+		acl:         security.NewAccessControlList(),
+		name:        name,
+		json:        json,
+		description: str,
+		help:        imf.Help,
+		stored:      imf.Stored,
+		required:    imf.Required,
+		readOnly:    imf.ReadOnly,
+		compute:     compute,
+		inverse:     inverse,
+		depends:     imf.Depends,
+		relatedPath: imf.Related,
+		noCopy:      imf.NoCopy,
+		structField: structField,
+		fieldType:   fieldType,
+		defaultFunc: imf.Default,
+		onChange:    onchange,
+		constraint:  constraint,
+		attachment:  true,
+		imageSizes:  sizes,
+	}
+	return fInfo
+}
+
 // An IntegerField is a field for storing non decimal numbers.
+//
+// If BigInt is set, the field is stored in a 64 bits column. This is needed
+// for fields holding the ids of records of any model, since models with an
+// ID generator have 64 bits ids.
 type IntegerField struct {
-	JSON          string
-	String        string
-	Help          string
-	Stored        bool
-	Required      bool
-	ReadOnly      bool
-	Unique        bool
-	Index         bool
-	Compute       Methoder
-	Depends       []string
-	Related       string
-	GroupOperator string
-	NoCopy        bool
-	GoType        interface{}
-	Translate     bool
-	OnChange      Methoder
-	Constraint    Methoder
-	Inverse       Methoder
-	Default       func(Environment) interface{}
+	JSON             string
+	String           string
+	Help             string
+	Stored           bool
+	Required         bool
+	ReadOnly         bool
+	Unique           bool
+	Index            bool
+	Compute          Methoder
+	Depends          []string
+	Related          string
+	GroupOperator    string
+	NoCopy           bool
+	Tracking         bool
+	GoType           interface{}
+	Translate        bool
+	CompanyDependent bool
+	BigInt           bool
+	OnChange         Methoder
+	Constraint       Methoder
+	Inverse          Methoder
+	Default          func(Environment) interface{}
 }
 
 // DeclareField creates a datetime field for the given FieldsCollection with the given name.
@@ -531,33 +767,108 @@ func (i IntegerField) DeclareField(fc *FieldsCollection, name string) *Field {
 		Name: name,
 		Type: typ,
 	}
-	fieldType := fieldtype.Integer
-	json, str := getJSONAndString(name, fieldType, i.JSON, i.String)
-	compute, inverse, onchange, constraint := getFuncNames(i.Compute, i.Inverse, i.OnChange, i.Constraint)
+	fieldType := fieldtype.Integer
+	json, str := getJSONAndString(name, fieldType, i.JSON, i.String)
+	compute, inverse, onchange, constraint := getFuncNames(i.Compute, i.Inverse, i.OnChange, i.Constraint)
+	fInfo := &Field{
+		model:            fc.model,
+		acl:              security.NewAccessControlList(),
+		name:             name,
+		json:             json,
+		description:      str,
+		help:             i.Help,
+		stored:           i.Stored,
+		required:         i.Required,
+		readOnly:         i.ReadOnly,
+		unique:           i.Unique,
+		index:            i.Index,
+		compute:          compute,
+		inverse:          inverse,
+		depends:          i.Depends,
+		relatedPath:      i.Related,
+		groupOperator:    strutils.GetDefaultString(i.GroupOperator, "sum"),
+		noCopy:           i.NoCopy,
+		tracking:         i.Tracking,
+		structField:      structField,
+		fieldType:        fieldType,
+		defaultFunc:      i.Default,
+		translate:        i.Translate,
+		companyDependent: i.CompanyDependent,
+		bigInt:           i.BigInt,
+		onChange:         onchange,
+		constraint:       constraint,
+	}
+	return fInfo
+}
+
+// A JSONField is a field for storing arbitrary JSON data, such as
+// the metadata of a record or a payload received from another system.
+//
+// Values are stored in a jsonb column. Their Go type is given by GoType,
+// which must be a pointer to a map, a struct or a slice type, and defaults
+// to map[string]interface{}. Values are encoded to and decoded from JSON
+// with the encoding/json package, so that struct tags apply.
+//
+// The values at a path inside JSON fields can be searched with the JSONPath
+// method of conditions, or with the '#' separator in field names, such as
+// "Meta#color".
+type JSONField struct {
+	JSON       string
+	String     string
+	Help       string
+	Stored     bool
+	Required   bool
+	ReadOnly   bool
+	Compute    Methoder
+	Depends    []string
+	Related    string
+	NoCopy     bool
+	GoType     interface{}
+	OnChange   Methoder
+	Constraint Methoder
+	Inverse    Methoder
+	Default    func(Environment) interface{}
+}
+
+// DeclareField creates a JSON field for the given FieldsCollection with the given name.
+func (jf JSONField) DeclareField(fc *FieldsCollection, name string) *Field {
+	typ := fieldtype.JSON.DefaultGoType()
+	if jf.GoType != nil {
+		typ = reflect.TypeOf(jf.GoType).Elem()
+	}
+	switch typ.Kind() {
+	case reflect.Map, reflect.Struct, reflect.Slice:
+	default:
+		log.Panic("GoType of JSON fields must be a pointer to a map, a struct or a slice type",
+			"model", fc.model.name, "field", name, "type", typ)
+	}
+	structField := reflect.StructField{
+		Name: name,
+		Type: typ,
+	}
+	fieldType := fieldtype.JSON
+	json, str := getJSONAndString(name, fieldType, jf.JSON, jf.String)
+	compute, inverse, onchange, constraint := getFuncNames(jf.Compute, jf.Inverse, jf.OnChange, jf.Constraint)
 	fInfo := &Field{
-		model:         fc.model,
-		acl:           security.NewAccessControlList(),
-		name:          name,
-		json:          json,
-		description:   str,
-		help:          i.Help,
-		stored:        i.Stored,
-		required:      i.Required,
-		readOnly:      i.ReadOnly,
-		unique:        i.Unique,
-		index:         i.Index,
-		compute:       compute,
-		inverse:       inverse,
-		depends:       i.Depends,
-		relatedPath:   i.Related,
-		groupOperator: strutils.GetDefaultString(i.GroupOperator, "sum"),
-		noCopy:        i.NoCopy,
-		structField:   structField,
-		fieldType:     fieldType,
-		defaultFunc:   i.Default,
-		translate:     i.Translate,
-		onChange:      onchange,
-		constraint:    constraint,
+		model:       fc.model,
+		acl:         security.NewAccessControlList(),
+		name:        name,
+		json:        json,
+		description: str,
+		help:        jf.Help,
+		stored:      jf.Stored,
+		required:    jf.Required,
+		readOnly:    jf.ReadOnly,
+		compute:     compute,
+		inverse:     inverse,
+		depends:     jf.Depends,
+		relatedPath: jf.Related,
+		noCopy:      jf.NoCopy,
+		structField: structField,
+		fieldType:   fieldType,
+		defaultFunc: jf.Default,
+		onChange:    onchange,
+		constraint:  constraint,
 	}
 	return fInfo
 }
@@ -658,26 +969,28 @@ func (mf Many2ManyField) DeclareField(fc *FieldsCollection, name string) *Field
 //
 // Clients are expected to handle many2one fields with a combo-box.
 type Many2OneField struct {
-	JSON          string
-	String        string
-	Help          string
-	Stored        bool
-	Required      bool
-	ReadOnly      bool
-	Index         bool
-	Compute       Methoder
-	Depends       []string
-	Related       string
-	NoCopy        bool
-	RelationModel Modeler
-	Embed         bool
-	Translate     bool
-	OnDelete      OnDeleteAction
-	OnChange      Methoder
-	Constraint    Methoder
-	Filter        Conditioner
-	Inverse       Methoder
-	Default       func(Environment) interface{}
+	JSON             string
+	String           string
+	Help             string
+	Stored           bool
+	Required         bool
+	ReadOnly         bool
+	Index            bool
+	Compute          Methoder
+	Depends          []string
+	Related          string
+	NoCopy           bool
+	Tracking         bool
+	RelationModel    Modeler
+	Embed            bool
+	Translate        bool
+	OnDelete         OnDeleteAction
+	CompanyDependent bool
+	OnChange         Methoder
+	Constraint       Methoder
+	Filter           Conditioner
+	Inverse          Methoder
+	Default          func(Environment) interface{}
 }
 
 // DeclareField creates a many2one field for the given FieldsCollection with the given name.
@@ -720,6 +1033,7 @@ func (mf Many2OneField) DeclareField(fc *FieldsCollection, name string) *Field {
 		depends:          mf.Depends,
 		relatedPath:      mf.Related,
 		noCopy:           noCopy,
+		tracking:         mf.Tracking,
 		structField:      structField,
 		embed:            mf.Embed,
 		relatedModelName: mf.RelationModel.Underlying().name,
@@ -727,6 +1041,7 @@ func (mf Many2OneField) DeclareField(fc *FieldsCollection, name string) *Field {
 		onDelete:         onDelete,
 		defaultFunc:      mf.Default,
 		translate:        mf.Translate,
+		companyDependent: mf.CompanyDependent,
 		onChange:         onchange,
 		filter:           filter,
 		constraint:       constraint,
@@ -734,6 +1049,73 @@ func (mf Many2OneField) DeclareField(fc *FieldsCollection, name string) *Field {
 	return fInfo
 }
 
+// A MonetaryField is a field for storing amounts of money in the currency
+// given by the many2one field to the Currency model named CurrencyField on
+// the same model. CurrencyField defaults to "Currency".
+//
+// Values are rounded to the rounding of their currency when they are written.
+// Aggregated values of grouped queries are rounded to Digits if it is set.
+type MonetaryField struct {
+	JSON             string
+	String           string
+	Help             string
+	Stored           bool
+	Required         bool
+	ReadOnly         bool
+	Index            bool
+	Compute          Methoder
+	Depends          []string
+	Related          string
+	GroupOperator    string
+	NoCopy           bool
+	Tracking         bool
+	Digits           nbutils.Digits
+	CurrencyField    string
+	CompanyDependent bool
+	OnChange         Methoder
+	Constraint       Methoder
+	Inverse          Methoder
+	Default          func(Environment) interface{}
+}
+
+// DeclareField creates a monetary field for the given FieldsCollection with the given name.
+func (mf MonetaryField) DeclareField(fc *FieldsCollection, name string) *Field {
+	structField := reflect.StructField{
+		Name: name,
+		Type: reflect.TypeOf(*new(float64)),
+	}
+	json, str := getJSONAndString(name, fieldtype.Monetary, mf.JSON, mf.String)
+	compute, inverse, onchange, constraint := getFuncNames(mf.Compute, mf.Inverse, mf.OnChange, mf.Constraint)
+	fInfo := &Field{
+		model:            fc.model,
+		acl:              security.NewAccessControlList(),
+		name:             name,
+		json:             json,
+		description:      str,
+		help:             mf.Help,
+		stored:           mf.Stored,
+		required:         mf.Required,
+		readOnly:         mf.ReadOnly,
+		index:            mf.Index,
+		compute:          compute,
+		inverse:          inverse,
+		depends:          mf.Depends,
+		relatedPath:      mf.Related,
+		groupOperator:    strutils.GetDefaultString(mf.GroupOperator, "sum"),
+		noCopy:           mf.NoCopy,
+		tracking:         mf.Tracking,
+		structField:      structField,
+		digits:           mf.Digits,
+		currencyField:    strutils.GetDefaultString(mf.CurrencyField, "Currency"),
+		fieldType:        fieldtype.Monetary,
+		defaultFunc:      mf.Default,
+		companyDependent: mf.CompanyDependent,
+		onChange:         onchange,
+		constraint:       constraint,
+	}
+	return fInfo
+}
+
 // A One2ManyField is a field for storing one-to-many relations.
 //
 // Clients are expected to handle one2many fields with a table.
@@ -954,24 +1336,26 @@ func (rf Rev2OneField) DeclareField(fc *FieldsCollection, name string) *Field {
 //
 // Clients are expected to handle selection fields with a combo-box or radio buttons.
 type SelectionField struct {
-	JSON       string
-	String     string
-	Help       string
-	Stored     bool
-	Required   bool
-	ReadOnly   bool
-	Unique     bool
-	Index      bool
-	Compute    Methoder
-	Depends    []string
-	Related    string
-	NoCopy     bool
-	Selection  types.Selection
-	Translate  bool
-	OnChange   Methoder
-	Constraint Methoder
-	Inverse    Methoder
-	Default    func(Environment) interface{}
+	JSON             string
+	String           string
+	Help             string
+	Stored           bool
+	Required         bool
+	ReadOnly         bool
+	Unique           bool
+	Index            bool
+	Compute          Methoder
+	Depends          []string
+	Related          string
+	NoCopy           bool
+	Tracking         bool
+	Selection        types.Selection
+	Translate        bool
+	CompanyDependent bool
+	OnChange         Methoder
+	Constraint       Methoder
+	Inverse          Methoder
+	Default          func(Environment) interface{}
 }
 
 // DeclareField creates a selection field for the given FieldsCollection with the given name.
@@ -983,29 +1367,31 @@ func (sf SelectionField) DeclareField(fc *FieldsCollection, name string) *Field
 	json, str := getJSONAndString(name, fieldtype.Selection, sf.JSON, sf.String)
 	compute, inverse, onchange, constraint := getFuncNames(sf.Compute, sf.Inverse, sf.OnChange, sf.Constraint)
 	fInfo := &Field{
-		model:       fc.model,
-		acl:         security.NewAccessControlList(),
-		name:        name,
-		json:        json,
-		description: str,
-		help:        sf.Help,
-		stored:      sf.Stored,
-		required:    sf.Required,
-		readOnly:    sf.ReadOnly,
-		unique:      sf.Unique,
-		index:       sf.Index,
-		compute:     compute,
-		inverse:     inverse,
-		depends:     sf.Depends,
-		relatedPath: sf.Related,
-		noCopy:      sf.NoCopy,
-		structField: structField,
-		selection:   sf.Selection,
-		fieldType:   fieldtype.Selection,
-		defaultFunc: sf.Default,
-		translate:   sf.Translate,
-		onChange:    onchange,
-		constraint:  constraint,
+		model:            fc.model,
+		acl:              security.NewAccessControlList(),
+		name:             name,
+		json:             json,
+		description:      str,
+		help:             sf.Help,
+		stored:           sf.Stored,
+		required:         sf.Required,
+		readOnly:         sf.ReadOnly,
+		unique:           sf.Unique,
+		index:            sf.Index,
+		compute:          compute,
+		inverse:          inverse,
+		depends:          sf.Depends,
+		relatedPath:      sf.Related,
+		noCopy:           sf.NoCopy,
+		tracking:         sf.Tracking,
+		structField:      structField,
+		selection:        sf.Selection,
+		fieldType:        fieldtype.Selection,
+		defaultFunc:      sf.Default,
+		translate:        sf.Translate,
+		companyDependent: sf.CompanyDependent,
+		onChange:         onchange,
+		constraint:       constraint,
 	}
 	return fInfo
 }
@@ -1014,27 +1400,41 @@ func (sf SelectionField) DeclareField(fc *FieldsCollection, name string) *Field
 // default max size, but it can be forced by setting the Size value.
 //
 // Clients are expected to handle text fields as multi-line inputs.
+//
+// Records are ordered by the byte order of the values, unless Collation is
+// set to the name of a database collation (e.g. an ICU collation such as
+// "fr-x-icu"). If Unaccent is set, accents are ignored when ordering. If
+// UnaccentSearch is set, accents are ignored by case-insensitive searches.
+//
+// If FullText is set to the name of a text search configuration (e.g.
+// "english" or "simple"), the field can be searched with the Match operator.
 type TextField struct {
-	JSON          string
-	String        string
-	Help          string
-	Stored        bool
-	Required      bool
-	ReadOnly      bool
-	Unique        bool
-	Index         bool
-	Compute       Methoder
-	Depends       []string
-	Related       string
-	GroupOperator string
-	NoCopy        bool
-	Size          int
-	GoType        interface{}
-	Translate     bool
-	OnChange      Methoder
-	Constraint    Methoder
-	Inverse       Methoder
-	Default       func(Environment) interface{}
+	JSON             string
+	String           string
+	Help             string
+	Stored           bool
+	Required         bool
+	ReadOnly         bool
+	Unique           bool
+	Index            bool
+	Compute          Methoder
+	Depends          []string
+	Related          string
+	GroupOperator    string
+	NoCopy           bool
+	Tracking         bool
+	Size             int
+	GoType           interface{}
+	Translate        bool
+	Collation        string
+	Unaccent         bool
+	UnaccentSearch   bool
+	FullText         string
+	CompanyDependent bool
+	OnChange         Methoder
+	Constraint       Methoder
+	Inverse          Methoder
+	Default          func(Environment) interface{}
 }
 
 // DeclareField creates a text field for the given FieldsCollection with the given name.
@@ -1051,30 +1451,36 @@ func (tf TextField) DeclareField(fc *FieldsCollection, name string) *Field {
 	json, str := getJSONAndString(name, fieldType, tf.JSON, tf.String)
 	compute, inverse, onchange, constraint := getFuncNames(tf.Compute, tf.Inverse, tf.OnChange, tf.Constraint)
 	fInfo := &Field{
-		model:         fc.model,
-		acl:           security.NewAccessControlList(),
-		name:          name,
-		json:          json,
-		description:   str,
-		help:          tf.Help,
-		stored:        tf.Stored,
-		required:      tf.Required,
-		readOnly:      tf.ReadOnly,
-		unique:        tf.Unique,
-		index:         tf.Index,
-		compute:       compute,
-		inverse:       inverse,
-		depends:       tf.Depends,
-		relatedPath:   tf.Related,
-		groupOperator: strutils.GetDefaultString(tf.GroupOperator, "sum"),
-		noCopy:        tf.NoCopy,
-		structField:   structField,
-		size:          tf.Size,
-		fieldType:     fieldType,
-		defaultFunc:   tf.Default,
-		translate:     tf.Translate,
-		onChange:      onchange,
-		constraint:    constraint,
+		model:            fc.model,
+		acl:              security.NewAccessControlList(),
+		name:             name,
+		json:             json,
+		description:      str,
+		help:             tf.Help,
+		stored:           tf.Stored,
+		required:         tf.Required,
+		readOnly:         tf.ReadOnly,
+		unique:           tf.Unique,
+		index:            tf.Index,
+		compute:          compute,
+		inverse:          inverse,
+		depends:          tf.Depends,
+		relatedPath:      tf.Related,
+		groupOperator:    strutils.GetDefaultString(tf.GroupOperator, "sum"),
+		noCopy:           tf.NoCopy,
+		tracking:         tf.Tracking,
+		structField:      structField,
+		size:             tf.Size,
+		fieldType:        fieldType,
+		defaultFunc:      tf.Default,
+		translate:        tf.Translate,
+		collation:        tf.Collation,
+		unaccent:         tf.Unaccent,
+		unaccentSearch:   tf.UnaccentSearch,
+		fullText:         tf.FullText,
+		companyDependent: tf.CompanyDependent,
+		onChange:         onchange,
+		constraint:       constraint,
 	}
 	return fInfo
 }
@@ -1187,6 +1593,8 @@ func (f *Field) setProperty(property string, value interface{}) {
 		f.embed = value.(bool)
 	case "noCopy":
 		f.noCopy = value.(bool)
+	case "tracking":
+		f.tracking = value.(bool)
 	case "defaultFunc":
 		f.defaultFunc = value.(func(Environment) interface{})
 	case "onDelete":
@@ -1201,6 +1609,18 @@ func (f *Field) setProperty(property string, value interface{}) {
 		f.filter = value.(*Condition)
 	case "translate":
 		f.translate = value.(bool)
+	case "companyDependent":
+		f.companyDependent = value.(bool)
+	case "collation":
+		f.collation = value.(string)
+	case "unaccent":
+		f.unaccent = value.(bool)
+	case "unaccentSearch":
+		f.unaccentSearch = value.(bool)
+	case "fullText":
+		f.fullText = value.(string)
+	case "cachePolicy":
+		f.cachePolicy = value.(*CachePolicy)
 	}
 }
 
@@ -1304,12 +1724,48 @@ func (f *Field) SetNoCopy(value bool) *Field {
 	return f
 }
 
+// SetTracking overrides the value of the Tracking parameter of this Field
+func (f *Field) SetTracking(value bool) *Field {
+	f.addUpdate("tracking", value)
+	return f
+}
+
 // SetTranslate overrides the value of the Translate parameter of this Field
 func (f *Field) SetTranslate(value bool) *Field {
 	f.addUpdate("translate", value)
 	return f
 }
 
+// SetCompanyDependent overrides the value of the CompanyDependent parameter of this Field
+func (f *Field) SetCompanyDependent(value bool) *Field {
+	f.addUpdate("companyDependent", value)
+	return f
+}
+
+// SetCollation overrides the value of the Collation parameter of this Field
+func (f *Field) SetCollation(value string) *Field {
+	f.addUpdate("collation", value)
+	return f
+}
+
+// SetUnaccent overrides the value of the Unaccent parameter of this Field
+func (f *Field) SetUnaccent(value bool) *Field {
+	f.addUpdate("unaccent", value)
+	return f
+}
+
+// SetUnaccentSearch overrides the value of the UnaccentSearch parameter of this Field
+func (f *Field) SetUnaccentSearch(value bool) *Field {
+	f.addUpdate("unaccentSearch", value)
+	return f
+}
+
+// SetFullText overrides the value of the FullText parameter of this Field
+func (f *Field) SetFullText(value string) *Field {
+	f.addUpdate("fullText", value)
+	return f
+}
+
 // SetDefault overrides the value of the Default parameter of this Field
 func (f *Field) SetDefault(value func(Environment) interface{}) *Field {
 	f.addUpdate("defaultFunc", value)
@@ -1364,3 +1820,10 @@ func (f *Field) SetFilter(value Conditioner) *Field {
 	f.addUpdate("filter", value.Underlying())
 	return f
 }
+
+// SetCachePolicy sets the CachePolicy of this computed Field, so
+// that its values are reused across environments until invalidated.
+func (f *Field) SetCachePolicy(value CachePolicy) *Field {
+	f.addUpdate("cachePolicy", &value)
+	return f
+}
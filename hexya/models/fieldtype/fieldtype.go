@@ -6,6 +6,7 @@ package fieldtype
 import (
 	"reflect"
 
+	"github.com/hexya-erp/hexya/hexya/models/types"
 	"github.com/hexya-erp/hexya/hexya/models/types/dates"
 )
 
@@ -14,23 +15,26 @@ type Type string
 
 // Types for model fields
 const (
-	NoType    Type = ""
-	Binary    Type = "binary"
-	Boolean   Type = "boolean"
-	Char      Type = "char"
-	Date      Type = "date"
-	DateTime  Type = "datetime"
-	Float     Type = "float"
-	HTML      Type = "html"
-	Integer   Type = "integer"
-	Many2Many Type = "many2many"
-	Many2One  Type = "many2one"
-	One2Many  Type = "one2many"
-	One2One   Type = "one2one"
-	Rev2One   Type = "rev2one"
-	Reference Type = "reference"
-	Selection Type = "selection"
-	Text      Type = "text"
+	NoType       Type = ""
+	Binary       Type = "binary"
+	Boolean      Type = "boolean"
+	Char         Type = "char"
+	Date         Type = "date"
+	DateTime     Type = "datetime"
+	EmbeddedList Type = "embeddedlist"
+	Float        Type = "float"
+	HTML         Type = "html"
+	Integer      Type = "integer"
+	JSON         Type = "json"
+	Many2Many    Type = "many2many"
+	Many2One     Type = "many2one"
+	Monetary     Type = "monetary"
+	One2Many     Type = "one2many"
+	One2One      Type = "one2one"
+	Rev2One      Type = "rev2one"
+	Reference    Type = "reference"
+	Selection    Type = "selection"
+	Text         Type = "text"
 )
 
 // IsRelationType returns true if this type is a relation.
@@ -81,12 +85,16 @@ func (t Type) DefaultGoType() reflect.Type {
 		return reflect.TypeOf(*new(dates.Date))
 	case DateTime:
 		return reflect.TypeOf(*new(dates.DateTime))
-	case Float:
+	case Float, Monetary:
 		return reflect.TypeOf(*new(float64))
 	case Integer, Many2One, One2One, Rev2One:
 		return reflect.TypeOf(*new(int64))
 	case One2Many, Many2Many:
 		return reflect.TypeOf(*new([]int64))
+	case EmbeddedList:
+		return reflect.TypeOf(*new(types.EmbeddedList))
+	case JSON:
+		return reflect.TypeOf(*new(map[string]interface{}))
 	}
 	return reflect.TypeOf(nil)
 }
@@ -0,0 +1,434 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/hexya-erp/hexya/hexya/models/security"
+	"github.com/hexya-erp/hexya/hexya/tools/b64image"
+)
+
+// A Filestore stores the content of attachment binary fields outside
+// of the tables of the models.
+//
+// Contents are addressed by their SHA1 checksum, so that a content
+// shared by several records is only stored once.
+type Filestore interface {
+	// Exists returns true if a content with the given checksum is stored
+	Exists(checksum string) bool
+	// Write stores the content read from r under the given checksum
+	Write(checksum string, r io.Reader) error
+	// Open returns a reader on the content with the given checksum.
+	// The returned reader must be closed by the caller.
+	Open(checksum string) (io.ReadCloser, error)
+}
+
+// A CollectableFilestore is a Filestore from which unused contents
+// can be removed by GarbageCollectFilestore.
+type CollectableFilestore interface {
+	Filestore
+	// Checksums returns the checksums of the contents that
+	// have been stored before the given time.
+	Checksums(before time.Time) ([]string, error)
+	// Remove deletes the content with the given checksum.
+	// Removing a non existent content is not an error.
+	Remove(checksum string) error
+}
+
+// A SizedFilestore is a Filestore that can report the size of its contents.
+// The size of removed contents is then subtracted from the usage of the
+// QuotaStorage quota by GarbageCollectFilestore.
+type SizedFilestore interface {
+	CollectableFilestore
+	// Size returns the size in bytes of the content with the given checksum.
+	Size(checksum string) (int64, error)
+}
+
+// DefaultFilestore is the Filestore of attachment binary fields.
+// If nil, contents are stored in the BinaryContent model.
+var DefaultFilestore Filestore
+
+// A binaryChecksum is the checksum of a content already stored in the
+// Filestore, as given in a FieldMap for an attachment binary field.
+type binaryChecksum string
+
+// declareBinaryContentModel creates the BinaryContent system model
+// which stores the contents of attachment binary fields when no
+// Filestore is configured.
+func declareBinaryContentModel() {
+	binaryContent := createModel("BinaryContent", SystemModel)
+	binaryContent.InheritModel(Registry.MustGet("CommonMixin"))
+	binaryContent.AddFields(map[string]FieldDefinition{
+		"Checksum":  CharField{Required: true, Unique: true},
+		"Content":   BinaryField{},
+		"StoreDate": DateTimeField{},
+	})
+}
+
+// filestore returns the Filestore to use for attachment binary fields
+func filestore() Filestore {
+	if DefaultFilestore != nil {
+		return DefaultFilestore
+	}
+	return dbFilestore{}
+}
+
+// dbFilestore is the Filestore that stores contents in the BinaryContent
+// model. It is used when no other Filestore is configured.
+//
+// Contents are written outside of the current transaction, like in any
+// other Filestore.
+type dbFilestore struct{}
+
+// Exists returns true if a content with the given checksum is stored
+func (dbFilestore) Exists(checksum string) bool {
+	var count int
+	query := fmt.Sprintf(`SELECT COUNT(*) FROM %s WHERE checksum = ?`,
+		adapters[db.DriverName()].quoteTableName(Registry.MustGet("BinaryContent").tableName))
+	dbGetNoTx(&count, query, checksum)
+	return count > 0
+}
+
+// Write stores the content read from r under the given checksum
+func (dbFilestore) Write(checksum string, r io.Reader) error {
+	content, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	query := fmt.Sprintf(`INSERT INTO %s (checksum, content, store_date) VALUES (?, ?, ?) ON CONFLICT (checksum) DO NOTHING`,
+		adapters[db.DriverName()].quoteTableName(Registry.MustGet("BinaryContent").tableName))
+	dbExecuteNoTx(query, checksum, content, time.Now().UTC())
+	return nil
+}
+
+// Open returns a reader on the content with the given checksum
+func (dbFilestore) Open(checksum string) (io.ReadCloser, error) {
+	var content []byte
+	query := fmt.Sprintf(`SELECT content FROM %s WHERE checksum = ?`,
+		adapters[db.DriverName()].quoteTableName(Registry.MustGet("BinaryContent").tableName))
+	dbGetNoTx(&content, query, checksum)
+	return ioutil.NopCloser(bytes.NewReader(content)), nil
+}
+
+// Checksums returns the checksums of the contents that
+// have been stored before the given time.
+func (dbFilestore) Checksums(before time.Time) ([]string, error) {
+	var checksums []string
+	query := fmt.Sprintf(`SELECT checksum FROM %s WHERE store_date IS NULL OR store_date < ?`,
+		adapters[db.DriverName()].quoteTableName(Registry.MustGet("BinaryContent").tableName))
+	dbSelectNoTx(&checksums, query, before.UTC())
+	return checksums, nil
+}
+
+// Size returns the size in bytes of the content with the given checksum.
+func (dbFilestore) Size(checksum string) (int64, error) {
+	var size int64
+	query := fmt.Sprintf(`SELECT COALESCE(octet_length(content), 0) FROM %s WHERE checksum = ?`,
+		adapters[db.DriverName()].quoteTableName(Registry.MustGet("BinaryContent").tableName))
+	dbGetNoTx(&size, query, checksum)
+	return size, nil
+}
+
+// Remove deletes the content with the given checksum.
+func (dbFilestore) Remove(checksum string) error {
+	query := fmt.Sprintf(`DELETE FROM %s WHERE checksum = ?`,
+		adapters[db.DriverName()].quoteTableName(Registry.MustGet("BinaryContent").tableName))
+	dbExecuteNoTx(query, checksum)
+	return nil
+}
+
+// GarbageCollectFilestore removes from the Filestore the contents that are
+// not referenced by any attachment binary field and returns the number of
+// removed contents.
+//
+// Only contents stored for more than minAge are removed, so that contents
+// written by transactions that are not committed yet are kept. minAge must
+// therefore be longer than the longest transaction.
+//
+// This function does nothing if the Filestore is not a CollectableFilestore.
+func GarbageCollectFilestore(minAge time.Duration) int {
+	store, ok := filestore().(CollectableFilestore)
+	if !ok {
+		log.Warn("Filestore does not support garbage collection", "filestore", fmt.Sprintf("%T", filestore()))
+		return 0
+	}
+	candidates, err := store.Checksums(time.Now().Add(-minAge))
+	if err != nil {
+		log.Panic("Unable to list filestore contents", "error", err)
+	}
+	used := make(map[string]bool)
+	for _, mi := range Registry.registryByName {
+		if mi.isMixin() {
+			continue
+		}
+		for _, fi := range mi.fields.registryByName {
+			if !fi.attachment || !fi.isStored() {
+				continue
+			}
+			var checksums []string
+			query := fmt.Sprintf(`SELECT DISTINCT %s FROM %s WHERE %s IS NOT NULL`,
+				fi.json, adapters[db.DriverName()].quoteTableName(mi.tableName), fi.json)
+			dbSelectNoTx(&checksums, query)
+			for _, cs := range checksums {
+				used[cs] = true
+				for _, size := range fi.imageSizes {
+					used[imageVariantChecksum(cs, size)] = true
+				}
+			}
+		}
+	}
+	sizedStore, sized := store.(SizedFilestore)
+	var count int
+	var removedSize int64
+	for _, cs := range candidates {
+		if used[cs] {
+			continue
+		}
+		if sized {
+			size, err := sizedStore.Size(cs)
+			if err != nil {
+				log.Panic("Unable to get the size of filestore content", "checksum", cs, "error", err)
+			}
+			removedSize += size
+		}
+		if err := store.Remove(cs); err != nil {
+			log.Panic("Unable to remove content from filestore", "checksum", cs, "error", err)
+		}
+		count++
+	}
+	if removedSize > 0 {
+		AddQuotaUsage(QuotaStorage, -removedSize)
+	}
+	return count
+}
+
+// storeBinaryContent writes the content read from r into the Filestore
+// if it is not already there and returns its checksum.
+//
+// The content is buffered in a temporary file so that it is never fully
+// loaded in memory.
+func storeBinaryContent(r io.Reader) string {
+	tmpFile, err := ioutil.TempFile("", "hexya-binary")
+	if err != nil {
+		log.Panic("Unable to create temporary file for binary content", "error", err)
+	}
+	defer os.Remove(tmpFile.Name())
+	defer tmpFile.Close()
+	hash := sha1.New()
+	size, err := io.Copy(tmpFile, io.TeeReader(r, hash))
+	if err != nil {
+		log.Panic("Unable to read binary content", "error", err)
+	}
+	checksum := hex.EncodeToString(hash.Sum(nil))
+	store := filestore()
+	if store.Exists(checksum) {
+		return checksum
+	}
+	if err = CheckCounterQuota(QuotaStorage, size); err != nil {
+		log.Panic("Unable to store binary content", "error", err)
+	}
+	if _, err = tmpFile.Seek(0, io.SeekStart); err != nil {
+		log.Panic("Unable to read binary content", "error", err)
+	}
+	if err = store.Write(checksum, tmpFile); err != nil {
+		log.Panic("Unable to write binary content to filestore", "checksum", checksum, "error", err)
+	}
+	AddQuotaUsage(QuotaStorage, size)
+	return checksum
+}
+
+// storeBinaries writes the contents of the attachment binary fields of fMap
+// into the Filestore and replaces them in fMap by their checksum.
+//
+// Contents are given base64 encoded, as for other binary fields.
+func (rc *RecordCollection) storeBinaries(fMap FieldMap) {
+	for field, value := range fMap {
+		fi, ok := rc.model.fields.Get(field)
+		if !ok || !fi.attachment {
+			continue
+		}
+		var checksum string
+		switch v := value.(type) {
+		case binaryChecksum:
+			checksum = string(v)
+		case string:
+			if v == "" {
+				continue
+			}
+			decoder := base64.NewDecoder(base64.StdEncoding, bytes.NewBufferString(v))
+			checksum = storeBinaryContent(decoder)
+		default:
+			continue
+		}
+		fMap[field] = checksum
+		if fi.imageSizes != nil {
+			rc.storeImageVariants(fi, checksum)
+		}
+	}
+}
+
+// imageVariantChecksum returns the key under which the variant of the
+// given size of the image with the given checksum is stored in the Filestore.
+func imageVariantChecksum(checksum string, size int) string {
+	hash := sha1.Sum([]byte(checksum + "/" + strconv.Itoa(size)))
+	return hex.EncodeToString(hash[:])
+}
+
+// storeImageVariants checks that the content with the given checksum of the
+// image field fi is a valid image and writes its resized variants into the
+// Filestore. Variants are only generated for sizes smaller than the image.
+func (rc *RecordCollection) storeImageVariants(fi *Field, checksum string) {
+	reader := rc.openChecksum(fi, checksum)
+	defer reader.Close()
+	img, format, err := image.Decode(reader)
+	if err != nil {
+		log.Panic("Invalid image content, only JPEG, PNG and GIF images are supported", "model", rc.model.name, "field", fi.name, "error", err)
+	}
+	store := filestore()
+	for _, size := range fi.imageSizes {
+		if img.Bounds().Dx() <= size && img.Bounds().Dy() <= size {
+			break
+		}
+		key := imageVariantChecksum(checksum, size)
+		if store.Exists(key) {
+			continue
+		}
+		var buf bytes.Buffer
+		if err = b64image.Encode(&buf, b64image.Resize(img, size), format); err != nil {
+			log.Panic("Unable to encode image variant", "model", rc.model.name, "field", fi.name, "size", size, "error", err)
+		}
+		variantSize := int64(buf.Len())
+		if err = store.Write(key, &buf); err != nil {
+			log.Panic("Unable to write image variant to filestore", "model", rc.model.name, "field", fi.name, "size", size, "error", err)
+		}
+		AddQuotaUsage(QuotaStorage, variantSize)
+	}
+}
+
+// binaryContent returns the base64 encoded content of the
+// attachment binary field fi for the first record of this
+// RecordCollection, given its checksum.
+func (rc *RecordCollection) binaryContent(fi *Field, checksum interface{}) string {
+	cs, _ := checksum.(string)
+	if cs == "" {
+		return ""
+	}
+	reader := rc.openChecksum(fi, cs)
+	defer reader.Close()
+	var buf bytes.Buffer
+	encoder := base64.NewEncoder(base64.StdEncoding, &buf)
+	if _, err := io.Copy(encoder, reader); err != nil {
+		log.Panic("Unable to read binary content", "model", rc.model.name, "field", fi.name, "error", err)
+	}
+	encoder.Close()
+	return buf.String()
+}
+
+// openChecksum returns a reader on the content with the given checksum
+// of the given attachment binary field.
+func (rc *RecordCollection) openChecksum(fi *Field, checksum string) io.ReadCloser {
+	reader, err := filestore().Open(checksum)
+	if err != nil {
+		log.Panic("Unable to open binary content", "model", rc.model.name, "field", fi.name, "checksum", checksum, "error", err)
+	}
+	return reader
+}
+
+// OpenBinary returns a reader on the raw content of the given attachment
+// binary field of the first record of this RecordCollection. The content is
+// read directly from the Filestore and is never loaded in the cache.
+//
+// The returned reader must be closed by the caller. It is empty if the field
+// has no content.
+func (rc *RecordCollection) OpenBinary(field string) io.ReadCloser {
+	fi, checksum := rc.attachmentChecksum(field, "OpenBinary")
+	if checksum == "" {
+		return ioutil.NopCloser(bytes.NewReader(nil))
+	}
+	return rc.openChecksum(fi, checksum)
+}
+
+// OpenImage returns a reader on the raw content of the given image field of
+// the first record of this RecordCollection, in the smallest variant that is
+// at least size large. The original image is returned if size is 0 or if there
+// is no such variant, for instance because the image is smaller than size.
+//
+// The returned reader must be closed by the caller. It is empty if the field
+// has no content.
+func (rc *RecordCollection) OpenImage(field string, size int) io.ReadCloser {
+	fi, checksum := rc.attachmentChecksum(field, "OpenImage")
+	if fi.imageSizes == nil {
+		log.Panic("OpenImage can only be called on image fields", "model", rc.model.name, "field", field)
+	}
+	if checksum == "" {
+		return ioutil.NopCloser(bytes.NewReader(nil))
+	}
+	if size > 0 {
+		store := filestore()
+		for _, s := range fi.imageSizes {
+			if s < size {
+				continue
+			}
+			if key := imageVariantChecksum(checksum, s); store.Exists(key) {
+				return rc.openChecksum(fi, key)
+			}
+		}
+	}
+	return rc.openChecksum(fi, checksum)
+}
+
+// GetImage returns the base64 encoded content of the given image field of
+// the first record of this RecordCollection, in the smallest variant that is
+// at least size large. See OpenImage for details.
+func (rc *RecordCollection) GetImage(field string, size int) string {
+	reader := rc.OpenImage(field, size)
+	defer reader.Close()
+	var buf bytes.Buffer
+	encoder := base64.NewEncoder(base64.StdEncoding, &buf)
+	if _, err := io.Copy(encoder, reader); err != nil {
+		log.Panic("Unable to read image content", "model", rc.model.name, "field", field, "error", err)
+	}
+	encoder.Close()
+	return buf.String()
+}
+
+// attachmentChecksum returns the given attachment binary field and the
+// checksum of its content for the first record of this RecordCollection,
+// after checking read access. caller is the name of the calling function.
+func (rc *RecordCollection) attachmentChecksum(field, caller string) (*Field, string) {
+	fi := rc.model.fields.MustGet(field)
+	if !fi.attachment {
+		log.Panic(fmt.Sprintf("%s can only be called on attachment binary fields", caller), "model", rc.model.name, "field", field)
+	}
+	rc.EnsureOne()
+	if !checkFieldPermission(fi, *rc.env, security.Read) {
+		log.Panic("You are not allowed to read this field", "model", rc.model.name, "field", field)
+	}
+	rc.Fetch()
+	checksum, _ := rc.get(fi.json, false)
+	cs, _ := checksum.(string)
+	return fi, cs
+}
+
+// WriteBinary writes the raw content read from r to the given attachment
+// binary field of all the records of this RecordCollection. The content
+// is streamed to the Filestore and is never fully loaded in memory.
+func (rc *RecordCollection) WriteBinary(field string, r io.Reader) {
+	fi := rc.model.fields.MustGet(field)
+	if !fi.attachment {
+		log.Panic("WriteBinary can only be called on attachment binary fields", "model", rc.model.name, "field", field)
+	}
+	rc.Call("Write", FieldMap{fi.json: binaryChecksum(storeBinaryContent(r))})
+}
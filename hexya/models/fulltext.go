@@ -0,0 +1,162 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"fmt"
+	"strings"
+)
+
+// fullTextSuffix is the suffix of the columns that hold the text
+// search vectors of the fields with a FullText configuration.
+const fullTextSuffix = "_tsv"
+
+// A queryRank is a rank of the records of a Query for a text, by which they are
+// ordered. It is either a full-text search rank or, if similarity is true, the
+// trigram similarity of the field with the text.
+type queryRank struct {
+	field      string
+	text       string
+	similarity bool
+}
+
+// OrderByRank returns a new RecordSet ordered by decreasing full-text search
+// rank of the given field for the given text, before any other order. The
+// field must have a FullText configuration.
+func (rc *RecordCollection) OrderByRank(field FieldNamer, text string) *RecordCollection {
+	rSet := *rc
+	rSet.query = rSet.query.clone()
+	rSet.query.ranks = append(rSet.query.ranks, queryRank{field: string(field.FieldName()), text: text})
+	return &rSet
+}
+
+// fullTextColumn returns the column holding the text search vector of the given field
+func fullTextColumn(fi *Field) string {
+	return fi.json + fullTextSuffix
+}
+
+// isFullTextColumn returns true if the given column of the given
+// model holds the text search vector of one of its fields.
+func isFullTextColumn(m *Model, colName string) bool {
+	if !strings.HasSuffix(colName, fullTextSuffix) {
+		return false
+	}
+	fi, ok := m.fields.registryByJSON[strings.TrimSuffix(colName, fullTextSuffix)]
+	return ok && fi.fullText != "" && fi.isStored()
+}
+
+// mustGetFullTextField returns the field of the given model with the given path,
+// and panics if it has no FullText configuration.
+func mustGetFullTextField(m *Model, path string) *Field {
+	fi := m.getRelatedFieldInfo(path)
+	if fi.fullText == "" || !fi.isStored() {
+		log.Panic("Full-text search is only available on stored fields with a FullText configuration",
+			"model", fi.model.name, "field", fi.name)
+	}
+	return fi
+}
+
+// updateDBFullText creates the text search vector columns and their indexes
+// for the fields of the given model with a FullText configuration, and the
+// trigger that maintains them.
+//
+// The text search configuration of a field is stored as the comment of its
+// vector column, so that the vectors are recomputed when it changes.
+func updateDBFullText(m *Model) {
+	adapter := adapters[db.DriverName()]
+	tableName := adapter.quoteTableName(m.tableName)
+	dbColumns := adapter.columns(m.tableName)
+	vectors := make(map[string]string)
+	var fields []*Field
+	for _, fi := range m.fields.registryByJSON {
+		if fi.fullText == "" || !fi.isStored() {
+			continue
+		}
+		fields = append(fields, fi)
+		vectors[fullTextColumn(fi)] = adapter.textSearchVectorSQL("NEW."+fi.json, fi.fullText)
+	}
+	if len(fields) == 0 {
+		for colName := range dbColumns {
+			if strings.HasSuffix(colName, fullTextSuffix) {
+				for _, query := range adapter.dropTextSearchTriggerSQL(m.tableName) {
+					dbExecuteNoTx(query)
+				}
+				break
+			}
+		}
+		return
+	}
+	for _, fi := range fields {
+		colName := fullTextColumn(fi)
+		if _, exists := dbColumns[colName]; exists {
+			continue
+		}
+		dbExecuteNoTx(fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s tsvector`, tableName, colName))
+		dbExecuteNoTx(fmt.Sprintf(`CREATE INDEX %s_%s_gin ON %s USING gin (%s)`, m.tableName, colName, tableName, colName))
+	}
+	for _, query := range adapter.textSearchTriggerSQL(m.tableName, vectors) {
+		dbExecuteNoTx(query)
+	}
+	for _, fi := range fields {
+		colName := fullTextColumn(fi)
+		if adapter.columnComment(m.tableName, colName) == fi.fullText {
+			continue
+		}
+		log.Info("Computing full-text search vectors", "model", m.name, "field", fi.name, "config", fi.fullText)
+		dbExecuteNoTx(fmt.Sprintf(`UPDATE %s SET %s = %s`, tableName, colName,
+			adapter.textSearchVectorSQL(fi.json, fi.fullText)))
+		dbExecuteNoTx(adapter.commentColumnSQL(m.tableName, colName, fi.fullText))
+	}
+}
+
+// matchPredicateSQLClause returns the SQL string and parameters of
+// a predicate with the Match operator on the given field expression.
+func (q *Query) matchPredicateSQLClause(exprs []string, field string, arg interface{}) (string, SQLParams) {
+	fi := mustGetFullTextField(q.recordSet.model, strings.Join(exprs, ExprSep))
+	adapter := adapters[db.DriverName()]
+	return adapter.textSearchMatchSQL(field+fullTextSuffix, fi.fullText), SQLParams{arg}
+}
+
+// getRankExpressions returns all expressions used in the ranks of this query.
+func (q *Query) getRankExpressions() [][]string {
+	exprs := make([][]string, len(q.ranks))
+	for i, rank := range q.ranks {
+		exprs[i] = jsonizeExpr(q.recordSet.model, strings.Split(rank.field, ExprSep))
+	}
+	return exprs
+}
+
+// ranksSQL returns the SQL string to add to the selected fields for the
+// ranks of this Query, and its parameters.
+func (q *Query) ranksSQL() (string, SQLParams) {
+	adapter := adapters[db.DriverName()]
+	var (
+		res  string
+		args SQLParams
+	)
+	for i, exprs := range q.getRankExpressions() {
+		field := q.joinedFieldExpression(exprs)
+		var rankSQL string
+		if q.ranks[i].similarity {
+			mustGetSimilarityField(q.recordSet.model, strings.Join(exprs, ExprSep))
+			rankSQL = adapter.similaritySQL(field)
+		} else {
+			fi := mustGetFullTextField(q.recordSet.model, strings.Join(exprs, ExprSep))
+			rankSQL = adapter.textSearchRankSQL(field+fullTextSuffix, fi.fullText)
+		}
+		res += fmt.Sprintf(", %s AS %srank%d", rankSQL, sortKeyPrefix, i)
+		args = append(args, q.ranks[i].text)
+	}
+	return res, args
+}
+
+// rankOrders returns the ORDER BY expressions of the ranks of this Query,
+// which refer to the ranks added to the selected fields by ranksSQL.
+func (q *Query) rankOrders() []string {
+	res := make([]string, len(q.ranks))
+	for i := range q.ranks {
+		res[i] = fmt.Sprintf("%srank%d DESC", sortKeyPrefix, i)
+	}
+	return res
+}
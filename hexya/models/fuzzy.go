@@ -0,0 +1,98 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"fmt"
+
+	"github.com/hexya-erp/hexya/hexya/models/fieldtype"
+	"github.com/hexya-erp/hexya/hexya/models/operator"
+)
+
+// EnableFuzzySearch allows searching the records of this model by similarity
+// of their record name with NameSearchFuzzy, so that they are found despite
+// typos.
+//
+// The pg_trgm extension is created and a trigram GIN index is created on each
+// stored record name field of this model when the database is synchronized.
+// Record name fields must be char or text fields.
+func (m *Model) EnableFuzzySearch() {
+	if m.isMixin() || m.isManual() {
+		log.Panic("Fuzzy search cannot be enabled on this model", "model", m.name)
+	}
+	m.options |= FuzzySearchModel
+}
+
+// OrderBySimilarity returns a new RecordSet ordered by decreasing trigram
+// similarity of the given char or text field with the given text, before
+// any other order. It requires the pg_trgm extension.
+func (rc *RecordCollection) OrderBySimilarity(field FieldNamer, text string) *RecordCollection {
+	rSet := *rc
+	rSet.query = rSet.query.clone()
+	rSet.query.ranks = append(rSet.query.ranks, queryRank{field: string(field.FieldName()), text: text, similarity: true})
+	return &rSet
+}
+
+// nameSearchFuzzy returns the records of this RecordCollection's model whose
+// record name fields are similar to the given name, ordered by decreasing
+// similarity. At most limit records are returned, 0 meaning no limit.
+func (rc *RecordCollection) nameSearchFuzzy(name string, limit int) *RecordCollection {
+	if !rc.model.isFuzzySearchable() {
+		log.Panic("Fuzzy search is not enabled on this model", "model", rc.model.name)
+	}
+	nameFields := rc.model.RecordNameFields()
+	if len(nameFields) == 0 {
+		return newRecordCollection(rc.Env(), rc.model)
+	}
+	cond := rc.Model().Field(nameFields[0]).AddOperator(operator.Similar, name)
+	for _, fName := range nameFields[1:] {
+		cond = cond.Or().Field(fName).AddOperator(operator.Similar, name)
+	}
+	res := rc.Model().Search(rc.Env(), cond).Limit(limit)
+	for _, fName := range nameFields {
+		res = res.OrderBySimilarity(FieldName(fName), name)
+	}
+	return res
+}
+
+// mustGetSimilarityField returns the field of the given model with the given
+// path, and panics if it is not a char or text field.
+func mustGetSimilarityField(m *Model, path string) *Field {
+	fi := m.getRelatedFieldInfo(path)
+	if fi.fieldType != fieldtype.Char && fi.fieldType != fieldtype.Text {
+		log.Panic("Similarity is only available on char and text fields", "model", fi.model.name, "field", fi.name)
+	}
+	return fi
+}
+
+// trigramIndexName returns the name of the trigram index of the given field
+func trigramIndexName(fi *Field) string {
+	return fmt.Sprintf("%s_%s_trgm", fi.model.tableName, fi.json)
+}
+
+// updateDBTrigramIndexes creates the trigram indexes of the record name fields
+// of the given model if it has fuzzy search enabled, and drops the trigram
+// indexes that are not needed anymore.
+func updateDBTrigramIndexes(m *Model) {
+	adapter := adapters[db.DriverName()]
+	indexes := make(map[string]bool)
+	if m.isFuzzySearchable() {
+		for _, fName := range m.RecordNameFields() {
+			fi := mustGetSimilarityField(m, fName)
+			if !fi.isStored() || fi.model != m {
+				continue
+			}
+			indexName := trigramIndexName(fi)
+			indexes[indexName] = true
+			if !adapter.indexExists(m.tableName, indexName) {
+				dbExecuteNoTx(adapter.trigramIndexSQL(indexName, m.tableName, fi.json))
+			}
+		}
+	}
+	for _, dbIndexName := range adapter.indexes(m.tableName, fmt.Sprintf("%s_%%_trgm", m.tableName)) {
+		if !indexes[dbIndexName] {
+			dropIndex(dbIndexName)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// A ModelHandle is a reference to a model by name which is resolved once at
+// bootstrap, so that hot paths do not look up the model in the registry by
+// name at each call. Handles are meant to be declared as package variables:
+//
+//	var partnerModel = models.NewModelHandle("Partner")
+//
+//	func doSomething(env models.Environment) {
+//		partners := partnerModel.Pool(env)
+//		...
+//	}
+//
+// Handles are safe for concurrent use. BootStrap panics if the model of a
+// handle does not exist.
+type ModelHandle struct {
+	name string
+	// index is the index of the model in the registry plus one,
+	// or 0 if the handle has not been resolved yet.
+	index int32
+}
+
+var (
+	modelHandles      []*ModelHandle
+	modelHandlesMutex sync.Mutex
+)
+
+// NewModelHandle returns a handle to the model with the given name.
+// The model does not need to be declared yet. If the models have
+// already been bootstrapped, it panics if the model does not exist.
+func NewModelHandle(name string) *ModelHandle {
+	modelHandlesMutex.Lock()
+	defer modelHandlesMutex.Unlock()
+	h := &ModelHandle{name: name}
+	if Registry != nil && Registry.bootstrapped {
+		h.resolve()
+	}
+	modelHandles = append(modelHandles, h)
+	return h
+}
+
+// resolve sets the index of the model of this handle.
+// It panics if the model does not exist.
+func (h *ModelHandle) resolve() {
+	atomic.StoreInt32(&h.index, int32(Registry.MustGet(h.name).index+1))
+}
+
+// Name returns the name of the model of this handle
+func (h *ModelHandle) Name() string {
+	return h.name
+}
+
+// Model returns the model of this handle. Before bootstrap, the
+// model is looked up in the registry by name at each call.
+func (h *ModelHandle) Model() *Model {
+	if index := atomic.LoadInt32(&h.index); index > 0 {
+		return Registry.getByIndex(int(index - 1))
+	}
+	return Registry.MustGet(h.name)
+}
+
+// Pool returns an empty RecordCollection of the model
+// of this handle in the given Environment.
+func (h *ModelHandle) Pool(env Environment) *RecordCollection {
+	return newRecordCollection(env, h.Model())
+}
+
+// resolveModelHandles resolves all the model handles declared
+// so far. It panics if the model of a handle does not exist.
+func resolveModelHandles() {
+	modelHandlesMutex.Lock()
+	defer modelHandlesMutex.Unlock()
+	for _, h := range modelHandles {
+		h.resolve()
+	}
+}
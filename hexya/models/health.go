@@ -0,0 +1,55 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+)
+
+// filestoreProbe is the content written and read back by CheckFilestore
+var filestoreProbe = []byte("hexya filestore health check")
+
+// CheckDatabase returns an error if the database cannot be reached
+// before ctx is done.
+func CheckDatabase(ctx context.Context) error {
+	if db == nil {
+		return errors.New("database is not connected")
+	}
+	return db.PingContext(ctx)
+}
+
+// CheckFilestore returns an error if the contents of the DefaultFilestore
+// cannot be written and read back. It does nothing if no DefaultFilestore
+// is set, since contents are then stored in the database.
+//
+// The probe content is always the same, so that checks do not fill the
+// Filestore. It is removed by GarbageCollectFilestore like any unused content.
+func CheckFilestore() error {
+	if DefaultFilestore == nil {
+		return nil
+	}
+	sum := sha1.Sum(filestoreProbe)
+	checksum := hex.EncodeToString(sum[:])
+	if err := DefaultFilestore.Write(checksum, bytes.NewReader(filestoreProbe)); err != nil {
+		return err
+	}
+	r, err := DefaultFilestore.Open(checksum)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	content, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(content, filestoreProbe) {
+		return errors.New("filestore returned a corrupted content")
+	}
+	return nil
+}
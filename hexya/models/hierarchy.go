@@ -0,0 +1,259 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// addParentPathFields adds a ParentPath field to the models
+// which have a Parent field pointing to themselves.
+//
+// The ParentPath of a record holds the ids of its ancestors and its own
+// id, from the root, each followed by a slash (e.g. "1/5/12/"), so that
+// ancestors and descendants can be found without recursive queries.
+func addParentPathFields() {
+	for _, model := range Registry.registryByName {
+		if model.isMixin() || model.isManual() || !model.hasParentField() {
+			continue
+		}
+		if model.fields.MustGet("Parent").relatedModelName != model.name {
+			continue
+		}
+		if _, exists := model.fields.Get("ParentPath"); exists {
+			continue
+		}
+		model.AddFields(map[string]FieldDefinition{
+			"ParentPath": CharField{Index: true, ReadOnly: true, NoCopy: true,
+				Help: "IDs of the ancestors of the record and of the record itself, from the root"},
+		})
+	}
+}
+
+// hasParentPath returns true if this model has a ParentPath field
+// maintained from its Parent field.
+func (m *Model) hasParentPath() bool {
+	_, exists := m.fields.Get("ParentPath")
+	return exists && m.hasParentField()
+}
+
+// recomputeParentPaths sets the parent path of all the records
+// of the given model in the database from their parent.
+func recomputeParentPaths(m *Model) {
+	table := adapters[db.DriverName()].quoteTableName(m.tableName)
+	query := fmt.Sprintf(`
+WITH RECURSIVE "paths" AS
+(
+	SELECT  id, id || '/' AS path
+	FROM    %[1]s
+	WHERE   parent_id IS NULL
+UNION ALL
+	SELECT  "c".id, "paths".path || "c".id || '/'
+	FROM    %[1]s "c"
+	JOIN    "paths"
+	ON      "c".parent_id = "paths".id
+)
+UPDATE  %[1]s SET parent_path = "paths".path
+FROM    "paths"
+WHERE   %[1]s.id = "paths".id AND %[1]s.parent_path IS DISTINCT FROM "paths".path`, table)
+	dbExecuteNoTx(query)
+}
+
+// removeParentPath removes the ParentPath field from the given FieldMap, since it
+// cannot be set directly, and returns true if fMap modifies the Parent field of
+// a model with a parent path.
+func (rc *RecordCollection) removeParentPath(fMap FieldMap) bool {
+	if !rc.model.hasParentPath() {
+		return false
+	}
+	fMap.Delete("ParentPath", rc.model)
+	_, ok := fMap.Get("Parent", rc.model)
+	return ok
+}
+
+// checkParentLoop panics if setting the Parent of the records of this
+// RecordCollection to the value of the given FieldMap would make one
+// of them its own ancestor.
+func (rc *RecordCollection) checkParentLoop(fMap FieldMap) {
+	parent, ok := fMap.Get("Parent", rc.model)
+	parentID, _ := parent.(int64)
+	if !ok || parentID == 0 {
+		return
+	}
+	var paths []string
+	rc.env.cr.Select(&paths, fmt.Sprintf(`SELECT COALESCE(parent_path, '') FROM %s WHERE id = ?`,
+		adapters[db.DriverName()].quoteTableName(rc.model.tableName)), parentID)
+	if len(paths) == 0 {
+		return
+	}
+	ancestors := make(map[int64]bool)
+	for _, id := range parentPathIds(paths[0]) {
+		ancestors[id] = true
+	}
+	for _, id := range rc.Ids() {
+		if ancestors[id] {
+			log.Panic("Recursion detected in hierarchy", "model", rc.model.name, "id", id, "parent", parentID)
+		}
+	}
+}
+
+// childIds returns the ids of the children of the records of this
+// RecordCollection which are not themselves in this RecordCollection.
+func (rc *RecordCollection) childIds() []int64 {
+	if !rc.model.hasParentPath() || rc.IsEmpty() {
+		return nil
+	}
+	var ids []int64
+	rc.env.cr.Select(&ids, fmt.Sprintf(`SELECT id FROM %s WHERE parent_id IN (?) AND id NOT IN (?)`,
+		adapters[db.DriverName()].quoteTableName(rc.model.tableName)), rc.ids, rc.ids)
+	return ids
+}
+
+// updateParentPaths sets the parent path of the records of this RecordCollection
+// and of their descendants from their parent. It must be called after records
+// have been created, their Parent has been modified or their parent deleted.
+//
+// It panics if a record has become its own ancestor.
+func (rc *RecordCollection) updateParentPaths() {
+	if !rc.model.hasParentPath() {
+		return
+	}
+	table := adapters[db.DriverName()].quoteTableName(rc.model.tableName)
+	for _, id := range rc.ids {
+		var paths []struct {
+			Path       string `db:"path"`
+			ParentPath string `db:"parent_path"`
+		}
+		rc.env.cr.Select(&paths, fmt.Sprintf(`SELECT COALESCE(r.parent_path, '') AS path, COALESCE(p.parent_path, '') AS parent_path
+			FROM %[1]s r LEFT JOIN %[1]s p ON p.id = r.parent_id WHERE r.id = ?`, table), id)
+		if len(paths) == 0 {
+			continue
+		}
+		oldPath, parentPath := paths[0].Path, paths[0].ParentPath
+		strID := strconv.FormatInt(id, 10)
+		if strings.Contains("/"+parentPath, "/"+strID+"/") {
+			log.Panic("Recursion detected in hierarchy", "model", rc.model.name, "id", id)
+		}
+		newPath := parentPath + strID + "/"
+		if newPath == oldPath {
+			continue
+		}
+		var updated []struct {
+			ID         int64  `db:"id"`
+			ParentPath string `db:"parent_path"`
+		}
+		if oldPath == "" {
+			rc.env.cr.Select(&updated, fmt.Sprintf(`UPDATE %s SET parent_path = ? WHERE id = ? RETURNING id, parent_path`,
+				table), newPath, id)
+		} else {
+			rc.env.cr.Select(&updated, fmt.Sprintf(`UPDATE %s SET parent_path = ? || substr(parent_path, ?)
+				WHERE parent_path LIKE ? RETURNING id, parent_path`, table), newPath, len(oldPath)+1, oldPath+"%")
+		}
+		for _, rec := range updated {
+			rc.env.cache.updateEntry(rc.model, rec.ID, "parent_path", rec.ParentPath)
+		}
+	}
+}
+
+// parentPathIds returns the ids of the given parent path
+func parentPathIds(path string) []int64 {
+	var res []int64
+	for _, strID := range strings.Split(strings.TrimSuffix(path, "/"), "/") {
+		id, err := strconv.ParseInt(strID, 10, 64)
+		if err != nil {
+			continue
+		}
+		res = append(res, id)
+	}
+	return res
+}
+
+// ensureParentPath panics if this RecordCollection's
+// model has no parent path.
+func (rc *RecordCollection) ensureParentPath() {
+	if !rc.model.hasParentPath() {
+		log.Panic("Model has no Parent field", "model", rc.model.name)
+	}
+}
+
+// Ancestors returns the ancestors of the records of this RecordCollection,
+// i.e. their parent, the parent of their parent and so on up to their root.
+// Records of this RecordCollection are not included unless they are the
+// ancestors of other records of it.
+//
+// It panics if the model has no Parent field pointing to itself.
+func (rc *RecordCollection) Ancestors() *RecordCollection {
+	rc.ensureParentPath()
+	idsMap := make(map[int64]bool)
+	for _, rec := range rc.Records() {
+		pathIds := parentPathIds(rec.Get("ParentPath").(string))
+		for _, id := range pathIds {
+			if id != rec.ids[0] {
+				idsMap[id] = true
+			}
+		}
+	}
+	return rc.hierarchyRecords(idsMap)
+}
+
+// Descendants returns the descendants of the records of this RecordCollection,
+// i.e. their children, the children of their children and so on. Records of
+// this RecordCollection are not included unless they are the descendants of
+// other records of it.
+//
+// It panics if the model has no Parent field pointing to itself.
+func (rc *RecordCollection) Descendants() *RecordCollection {
+	rc.ensureParentPath()
+	if rc.IsEmpty() {
+		return rc.env.Pool(rc.model.name)
+	}
+	var (
+		clauses []string
+		args    []interface{}
+	)
+	for _, rec := range rc.Records() {
+		clauses = append(clauses, "(parent_path LIKE ? AND id != ?)")
+		args = append(args, rec.Get("ParentPath").(string)+"%", rec.ids[0])
+	}
+	var ids []int64
+	rc.env.cr.Select(&ids, fmt.Sprintf(`SELECT id FROM %s WHERE %s`,
+		adapters[db.DriverName()].quoteTableName(rc.model.tableName), strings.Join(clauses, " OR ")), args...)
+	idsMap := make(map[int64]bool)
+	for _, id := range ids {
+		idsMap[id] = true
+	}
+	return rc.hierarchyRecords(idsMap)
+}
+
+// RootNodes returns the roots of the trees of the records of this
+// RecordCollection, i.e. their top-level ancestors. A record without
+// parent is its own root.
+//
+// It panics if the model has no Parent field pointing to itself.
+func (rc *RecordCollection) RootNodes() *RecordCollection {
+	rc.ensureParentPath()
+	idsMap := make(map[int64]bool)
+	for _, rec := range rc.Records() {
+		if pathIds := parentPathIds(rec.Get("ParentPath").(string)); len(pathIds) > 0 {
+			idsMap[pathIds[0]] = true
+		}
+	}
+	return rc.hierarchyRecords(idsMap)
+}
+
+// hierarchyRecords returns the records with the given ids of this
+// RecordCollection's model that the current user can read, in the
+// default order of the model.
+func (rc *RecordCollection) hierarchyRecords(idsMap map[int64]bool) *RecordCollection {
+	if len(idsMap) == 0 {
+		return rc.env.Pool(rc.model.name)
+	}
+	ids := make([]int64, 0, len(idsMap))
+	for id := range idsMap {
+		ids = append(ids, id)
+	}
+	return rc.env.Pool(rc.model.name).Search(rc.model.Field("ID").In(ids)).Fetch()
+}
@@ -0,0 +1,203 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Built-in ID generators
+const (
+	// IDSequence is the default ID generator. Ids are given
+	// by the database sequence of the table of the model.
+	IDSequence = "sequence"
+	// IDBigSerial gives ids from a 64 bits database sequence,
+	// for tables that may hold more than 2^31 records.
+	IDBigSerial = "bigserial"
+	// IDSnowflake generates time ordered ids that are unique across all
+	// the servers of a deployment as long as each server has its own node
+	// number set with SetSnowflakeNode. Each id is made of the number of
+	// milliseconds since 2017-01-01 (41 bits), the node number (10 bits)
+	// and a counter within the millisecond (12 bits).
+	IDSnowflake = "snowflake"
+	// IDRandom generates random positive 63 bits ids that cannot be guessed.
+	IDRandom = "random"
+)
+
+// An IDGenerator returns a new id for a record of the given model.
+// Generated ids must be strictly positive and unique in the model.
+type IDGenerator func(model *Model) int64
+
+var (
+	idGenerators = map[string]IDGenerator{
+		IDSnowflake: snowflakeID,
+		IDRandom:    randomID,
+	}
+	idGeneratorsMutex sync.RWMutex
+)
+
+// RegisterIDGenerator registers the given ID generator under the given name,
+// so that models can use it with SetIDGenerator.
+//
+// It panics if a generator with the same name already exists.
+func RegisterIDGenerator(name string, generator IDGenerator) {
+	idGeneratorsMutex.Lock()
+	defer idGeneratorsMutex.Unlock()
+	if _, exists := idGenerators[name]; exists || name == IDSequence || name == IDBigSerial {
+		log.Panic("ID generator already registered", "generator", name)
+	}
+	idGenerators[name] = generator
+}
+
+// SetIDGenerator sets the generator of the ids of the records of this
+// model, among IDSequence, IDBigSerial, IDSnowflake, IDRandom and the
+// generators registered with RegisterIDGenerator. It panics if the generator
+// is unknown.
+//
+// The id column of models with another generator than IDSequence is a bigint
+// column, and so are the columns of the relation fields pointing to them.
+// Existing integer columns are widened when the database is synchronized.
+//
+// Ids of all models remain 64 bits integers, so that they can be used
+// in relation fields, RecordSets and the cache as usual. UUID ids are
+// therefore not available: use IDRandom for ids that cannot be guessed.
+// Records of models with random ids are not ordered by creation date by default.
+func (m *Model) SetIDGenerator(name string) {
+	if name != IDSequence && name != IDBigSerial {
+		getIDGenerator(name)
+	}
+	if name == IDRandom && m.isHashChained() {
+		log.Panic("Records of hash chained models must have increasing ids", "model", m.name)
+	}
+	m.idGenerator = name
+}
+
+// IDGenerator returns the name of the ID generator of this model.
+func (m *Model) IDGenerator() string {
+	if m.idGenerator == "" {
+		return IDSequence
+	}
+	return m.idGenerator
+}
+
+// hasBigIDs returns true if the id column of this model is a bigint
+// column, that is if its ids are not given by a 32 bits sequence.
+func (m *Model) hasBigIDs() bool {
+	return m.IDGenerator() != IDSequence
+}
+
+// getIDGenerator returns the ID generator with the given name.
+// It panics if there is no such generator.
+func getIDGenerator(name string) IDGenerator {
+	idGeneratorsMutex.RLock()
+	defer idGeneratorsMutex.RUnlock()
+	generator, ok := idGenerators[name]
+	if !ok {
+		log.Panic("Unknown ID generator", "generator", name)
+	}
+	return generator
+}
+
+// newID returns a new id for a record of this model, or
+// 0 if the id must be given by the database sequence.
+func (m *Model) newID() int64 {
+	if !m.hasBigIDs() || m.idGenerator == IDBigSerial {
+		return 0
+	}
+	id := getIDGenerator(m.idGenerator)(m)
+	if id <= 0 {
+		log.Panic("ID generator returned an invalid id", "model", m.name, "generator", m.idGenerator, "id", id)
+	}
+	return id
+}
+
+// snowflakeEpoch is the origin of the timestamps of snowflake ids
+var snowflakeEpoch = time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+
+const (
+	snowflakeNodeBits    = 10
+	snowflakeCounterBits = 12
+)
+
+var snowflake struct {
+	sync.Mutex
+	node    int64
+	lastMs  int64
+	counter int64
+}
+
+// SetSnowflakeNode sets the node number of this server, which must be
+// unique among the servers of the deployment for snowflake ids to be unique.
+// It panics if node is not between 0 and 1023.
+func SetSnowflakeNode(node int64) {
+	if node < 0 || node >= 1<<snowflakeNodeBits {
+		log.Panic("Snowflake node number must be between 0 and 1023", "node", node)
+	}
+	snowflake.Lock()
+	defer snowflake.Unlock()
+	snowflake.node = node
+}
+
+// snowflakeID returns a new snowflake id.
+func snowflakeID(_ *Model) int64 {
+	snowflake.Lock()
+	defer snowflake.Unlock()
+	ms := int64(time.Since(snowflakeEpoch) / time.Millisecond)
+	if ms < snowflake.lastMs {
+		// The clock went backwards: keep using the last timestamp
+		ms = snowflake.lastMs
+	}
+	if ms == snowflake.lastMs {
+		snowflake.counter++
+		if snowflake.counter == 1<<snowflakeCounterBits {
+			// Counter exhausted for this millisecond: borrow the next one
+			ms++
+			snowflake.counter = 0
+		}
+	} else {
+		snowflake.counter = 0
+	}
+	snowflake.lastMs = ms
+	return ms<<(snowflakeNodeBits+snowflakeCounterBits) | snowflake.node<<snowflakeCounterBits | snowflake.counter
+}
+
+// randomID returns a new random id.
+func randomID(_ *Model) int64 {
+	var buf [8]byte
+	for {
+		if _, err := rand.Read(buf[:]); err != nil {
+			log.Panic("Unable to generate random id", "error", err)
+		}
+		if id := int64(binary.BigEndian.Uint64(buf[:]) >> 1); id > 0 {
+			return id
+		}
+	}
+}
+
+// updateDBIDColumn widens the id column of the given model and its
+// sequence to bigint if the model has 64 bits ids and its table has
+// been created with an integer id column.
+func updateDBIDColumn(m *Model) {
+	if !m.hasBigIDs() {
+		return
+	}
+	adapter := adapters[db.DriverName()]
+	idCol, ok := adapter.columns(m.tableName)["id"]
+	if !ok || idCol.DataType == "bigint" {
+		return
+	}
+	log.Info("Widening id column to bigint", "model", m.name)
+	tableName := adapter.quoteTableName(m.tableName)
+	dbExecuteNoTx(fmt.Sprintf(`ALTER TABLE %s ALTER COLUMN id SET DATA TYPE bigint`, tableName))
+	var sequence sql.NullString
+	dbGetNoTx(&sequence, `SELECT pg_get_serial_sequence(?, 'id')`, tableName)
+	if sequence.Valid {
+		dbExecuteNoTx(fmt.Sprintf(`ALTER SEQUENCE %s AS bigint`, sequence.String))
+	}
+}
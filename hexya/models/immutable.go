@@ -0,0 +1,175 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// EnableHashChain makes the records of this immutable model hash chained.
+//
+// Each record stores in its Hash field the SHA-256 hash of its stored values
+// and of the hash of the previous record, which is kept in its PreviousHash
+// field. Modifying, inserting or deleting records directly in the database
+// breaks the chain, which can be checked with VerifyHashChain.
+//
+// This method panics if the model is not immutable.
+func (m *Model) EnableHashChain() {
+	if !m.isImmutable() {
+		log.Panic("Only immutable models can be hash chained", "model", m.name)
+	}
+	if m.isHashChained() {
+		return
+	}
+	m.options |= HashChainedModel
+	m.AddFields(map[string]FieldDefinition{
+		"PreviousHash": CharField{ReadOnly: true, NoCopy: true, Size: 64},
+		"Hash":         CharField{ReadOnly: true, NoCopy: true, Size: 64, Index: true},
+	})
+}
+
+// checkMutable panics if the records of this RecordCollection
+// cannot be modified with the given operation.
+func (rc *RecordCollection) checkMutable(operation string) {
+	if !rc.model.isImmutable() {
+		return
+	}
+	log.Panic("Records of immutable models cannot be modified", "model", rc.model.name, "operation", operation)
+}
+
+// setupImmutableModels makes sure that the records of immutable models are
+// not modified by the database when their related records are deleted, by
+// restricting the deletion of records referenced by their many2one fields.
+func setupImmutableModels() {
+	for _, model := range Registry.registryByName {
+		if !model.isImmutable() {
+			continue
+		}
+		for _, fi := range model.fields.registryByName {
+			if fi.isRelationField() && !fi.fieldType.IsReverseRelationType() && fi.isStored() {
+				fi.onDelete = Restrict
+			}
+		}
+	}
+}
+
+// hashedColumns returns the sorted list of the columns of this model
+// that are included in the hash of a record.
+func (m *Model) hashedColumns() []string {
+	var res []string
+	for _, fi := range m.fields.registryByJSON {
+		if !fi.isStored() || fi.json == "hash" || fi.json == "previous_hash" {
+			continue
+		}
+		res = append(res, fi.json)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// recordHash returns the hash of the given row, chained to the given
+// previous hash. Null values are left out so that adding a column to
+// the model does not change the hash of existing records.
+func recordHash(previousHash string, columns []string, row map[string]interface{}) string {
+	var payload bytes.Buffer
+	payload.WriteString(previousHash)
+	for _, col := range columns {
+		val := row[col]
+		if val == nil {
+			continue
+		}
+		var str string
+		switch v := val.(type) {
+		case []byte:
+			str = string(v)
+		case time.Time:
+			str = v.UTC().Format(time.RFC3339Nano)
+		default:
+			str = fmt.Sprint(v)
+		}
+		fmt.Fprintf(&payload, "\n%s=%s", col, str)
+	}
+	sum := sha256.Sum256(payload.Bytes())
+	return hex.EncodeToString(sum[:])
+}
+
+// chainRecordHash sets the PreviousHash and Hash fields of the
+// newly created record of this RecordCollection.
+//
+// Concurrent creations are ordered by the serializable isolation level
+// of transactions: one of two transactions that would chain a record to
+// the same previous record fails with a serialization error and is retried.
+func (rc *RecordCollection) chainRecordHash() {
+	rc.EnsureOne()
+	id := rc.ids[0]
+	adapter := adapters[db.DriverName()]
+	tableName := adapter.quoteTableName(rc.model.tableName)
+	var previous []string
+	rc.env.cr.Select(&previous, fmt.Sprintf(`SELECT COALESCE(hash, '') FROM %s WHERE id < ? ORDER BY id DESC LIMIT 1`, tableName), id)
+	var previousHash string
+	if len(previous) > 0 {
+		previousHash = previous[0]
+	}
+	columns := rc.model.hashedColumns()
+	rows := rc.env.cr.query(fmt.Sprintf(`SELECT %s FROM %s WHERE id = ?`, strings.Join(columns, ", "), tableName), id)
+	row := make(map[string]interface{})
+	for rows.Next() {
+		if err := rows.MapScan(row); err != nil {
+			rows.Close()
+			log.Panic("Unable to read record to hash", "model", rc.model.name, "id", id, "error", err)
+		}
+	}
+	rows.Close()
+	hash := recordHash(previousHash, columns, row)
+	rc.env.cr.Execute(fmt.Sprintf(`UPDATE %s SET previous_hash = ?, hash = ? WHERE id = ?`, tableName), previousHash, hash, id)
+	rc.env.cache.updateEntry(rc.model, id, "previous_hash", previousHash)
+	rc.env.cache.updateEntry(rc.model, id, "hash", hash)
+}
+
+// VerifyHashChain checks the hash chain of all the records of this model
+// and returns the first record whose values or previous hash do not match
+// its hash. It returns an empty RecordCollection if the chain is valid.
+//
+// This method panics if the model is not hash chained.
+func (rc *RecordCollection) VerifyHashChain() *RecordCollection {
+	if !rc.model.isHashChained() {
+		log.Panic("Model is not hash chained", "model", rc.model.name)
+	}
+	columns := rc.model.hashedColumns()
+	query := fmt.Sprintf(`SELECT %s, previous_hash, hash FROM %s ORDER BY id`,
+		strings.Join(columns, ", "), adapters[db.DriverName()].quoteTableName(rc.model.tableName))
+	rows := rc.env.cr.query(query)
+	defer rows.Close()
+	var previousHash string
+	for rows.Next() {
+		row := make(map[string]interface{})
+		if err := rows.MapScan(row); err != nil {
+			log.Panic("Unable to read record to verify", "model", rc.model.name, "error", err)
+		}
+		storedPrevious, storedHash := nullableString(row["previous_hash"]), nullableString(row["hash"])
+		if storedPrevious != previousHash || storedHash != recordHash(previousHash, columns, row) {
+			return rc.env.Pool(rc.model.name).withIds([]int64{row["id"].(int64)})
+		}
+		previousHash = storedHash
+	}
+	return rc.env.Pool(rc.model.name)
+}
+
+// nullableString returns the given scanned string column value,
+// or the empty string if it is null.
+func nullableString(value interface{}) string {
+	switch v := value.(type) {
+	case []byte:
+		return string(v)
+	case string:
+		return v
+	}
+	return ""
+}
@@ -0,0 +1,364 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/hexya-erp/hexya/hexya/models/fieldtype"
+	"github.com/hexya-erp/hexya/hexya/models/operator"
+	"github.com/hexya-erp/hexya/hexya/models/types/dates"
+)
+
+// ImportBatchSize is the number of records that are inserted
+// together in the database by Import.
+var ImportBatchSize = 100
+
+// An ImportError describes why a line of imported data could not be loaded.
+type ImportError struct {
+	Line    int
+	Field   string
+	Message string
+}
+
+// Error returns the error message of this ImportError
+func (ie ImportError) Error() string {
+	if ie.Field == "" {
+		return fmt.Sprintf("line %d: %s", ie.Line, ie.Message)
+	}
+	return fmt.Sprintf("line %d: field %s: %s", ie.Line, ie.Field, ie.Message)
+}
+
+// An ImportResult holds the ids of the records created or updated
+// by an import and the errors of the lines that could not be imported.
+type ImportResult struct {
+	IDs    []int64
+	Errors []ImportError
+}
+
+// An importColumn maps a column of imported data to a field.
+// subField is set for columns of one2many child rows.
+type importColumn struct {
+	header   string
+	field    *Field
+	subField *Field
+}
+
+// An importLine is a record to import with its one2many children
+type importLine struct {
+	line     int
+	values   FieldMap
+	children map[*Field][]FieldMap
+	errors   []ImportError
+}
+
+// ImportCSV imports the records of the CSV data read from r into
+// this RecordCollection's model. The first line must hold the headers.
+//
+// See Import for the format of the data.
+func (rc *RecordCollection) ImportCSV(r io.Reader) ImportResult {
+	reader := csv.NewReader(r)
+	// Lines with a wrong number of values are reported by Import
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		line := 0
+		if pErr, ok := err.(*csv.ParseError); ok {
+			line = pErr.Line
+		}
+		return ImportResult{Errors: []ImportError{{Line: line, Message: err.Error()}}}
+	}
+	if len(records) == 0 {
+		return ImportResult{}
+	}
+	return rc.Import(records[0], records[1:])
+}
+
+// Import creates or updates records of this RecordCollection's model
+// from the given rows of string values.
+//
+//   - headers are field names, JSON names or field descriptions. The "id"
+//     column holds the external ID of the record, which is updated if it exists.
+//   - Many2one and Many2many values are resolved by external ID or by name.
+//     Many2many values are separated by '|'.
+//   - One2many values are given in "Field/SubField" columns. Following rows
+//     with all other columns empty are additional child rows of the record.
+//
+// Records are inserted by batches of ImportBatchSize, with the import_mode
+// context key set so that stored computed fields are computed once for each
+// batch after all its records have been inserted. Lines that cannot be
+// imported are reported in the result's Errors and do not prevent the other
+// lines from being imported. Line numbers start at 2 for the first row, as
+// the first line is that of the headers.
+func (rc *RecordCollection) Import(headers []string, rows [][]string) ImportResult {
+	columns, errs := rc.model.importColumns(headers)
+	if len(errs) > 0 {
+		return ImportResult{Errors: errs}
+	}
+	var (
+		res   ImportResult
+		batch []*importLine
+	)
+	for _, line := range rc.parseImportRows(columns, rows) {
+		if len(line.errors) > 0 {
+			res.Errors = append(res.Errors, line.errors...)
+			continue
+		}
+		batch = append(batch, line)
+		if len(batch) >= ImportBatchSize {
+			rc.importBatch(batch, &res)
+			batch = nil
+		}
+	}
+	rc.importBatch(batch, &res)
+	return res
+}
+
+// importColumns returns the importColumns matching the given headers.
+func (m *Model) importColumns(headers []string) ([]importColumn, []ImportError) {
+	var (
+		columns []importColumn
+		errs    []ImportError
+	)
+	for _, header := range headers {
+		col := importColumn{header: header}
+		if strings.ToLower(header) == "id" {
+			col.field = m.fields.MustGet("HexyaExternalID")
+			columns = append(columns, col)
+			continue
+		}
+		names := strings.SplitN(header, "/", 2)
+		fi, ok := m.importField(names[0])
+		if !ok {
+			errs = append(errs, ImportError{Line: 1, Field: header, Message: "unknown field"})
+			continue
+		}
+		col.field = fi
+		switch {
+		case fi.fieldType == fieldtype.One2Many && len(names) == 2:
+			subFi, ok := fi.relatedModel.importField(names[1])
+			if !ok {
+				errs = append(errs, ImportError{Line: 1, Field: header, Message: "unknown field"})
+				continue
+			}
+			col.subField = subFi
+		case fi.fieldType == fieldtype.One2Many:
+			errs = append(errs, ImportError{Line: 1, Field: header,
+				Message: "one2many fields must be imported with Field/SubField columns"})
+			continue
+		case len(names) == 2:
+			errs = append(errs, ImportError{Line: 1, Field: header, Message: "sub fields are only allowed on one2many fields"})
+			continue
+		}
+		columns = append(columns, col)
+	}
+	return columns, errs
+}
+
+// importField returns the field of this model with the given
+// name, JSON name or description (case insensitive).
+func (m *Model) importField(name string) (*Field, bool) {
+	if fi, ok := m.fields.Get(name); ok {
+		return fi, true
+	}
+	for _, fi := range m.fields.registryByName {
+		if strings.EqualFold(fi.description, name) {
+			return fi, true
+		}
+	}
+	return nil, false
+}
+
+// parseImportRows converts the given rows into importLines, attaching child rows
+// to their parent line. Conversion errors are set on the lines.
+func (rc *RecordCollection) parseImportRows(columns []importColumn, rows [][]string) []*importLine {
+	var lines []*importLine
+	for i, row := range rows {
+		lineNum := i + 2
+		if len(row) != len(columns) {
+			lines = append(lines, &importLine{
+				line:     lineNum,
+				values:   make(FieldMap),
+				children: make(map[*Field][]FieldMap),
+				errors: []ImportError{{Line: lineNum,
+					Message: fmt.Sprintf("expected %d values, got %d", len(columns), len(row))}},
+			})
+			continue
+		}
+		isChildRow := len(lines) > 0
+		for j, col := range columns {
+			if col.subField == nil && row[j] != "" {
+				isChildRow = false
+				break
+			}
+		}
+		var line *importLine
+		if isChildRow {
+			line = lines[len(lines)-1]
+		} else {
+			line = &importLine{
+				line:     lineNum,
+				values:   make(FieldMap),
+				children: make(map[*Field][]FieldMap),
+			}
+			lines = append(lines, line)
+		}
+		childValues := make(map[*Field]FieldMap)
+		for j, col := range columns {
+			if row[j] == "" {
+				continue
+			}
+			fi := col.field
+			if col.subField != nil {
+				fi = col.subField
+			}
+			val, err := rc.env.convertImportValue(fi, row[j])
+			if err != nil {
+				line.errors = append(line.errors, ImportError{Line: lineNum, Field: col.header, Message: err.Error()})
+				continue
+			}
+			if col.subField == nil {
+				line.values[fi.json] = val
+				continue
+			}
+			if childValues[col.field] == nil {
+				childValues[col.field] = make(FieldMap)
+			}
+			childValues[col.field][fi.json] = val
+		}
+		for fi, vals := range childValues {
+			line.children[fi] = append(line.children[fi], vals)
+		}
+	}
+	return lines
+}
+
+// convertImportValue returns the value to store in the given field
+// for the given imported string value.
+func (env Environment) convertImportValue(fi *Field, value string) (interface{}, error) {
+	switch {
+	case fi.fieldType == fieldtype.Integer:
+		return strconv.ParseInt(value, 0, 64)
+	case fi.fieldType == fieldtype.Float, fi.fieldType == fieldtype.Monetary:
+		return strconv.ParseFloat(value, 64)
+	case fi.fieldType == fieldtype.Boolean:
+		return strconv.ParseBool(value)
+	case fi.fieldType == fieldtype.Date:
+		return dates.ParseDate(dates.DefaultServerDateFormat, value)
+	case fi.fieldType == fieldtype.DateTime:
+		return dates.ParseDateTime(dates.DefaultServerDateTimeFormat, value)
+	case fi.fieldType == fieldtype.Selection:
+		if _, ok := fi.selection[value]; !ok {
+			return nil, fmt.Errorf("invalid selection value '%s'", value)
+		}
+		return value, nil
+	case fi.fieldType.IsFKRelationType():
+		return env.resolveImportRelation(fi.relatedModel, value)
+	case fi.fieldType == fieldtype.Many2Many:
+		var ids []int64
+		for _, ref := range strings.Split(value, "|") {
+			id, err := env.resolveImportRelation(fi.relatedModel, ref)
+			if err != nil {
+				return nil, err
+			}
+			ids = append(ids, id)
+		}
+		return ids, nil
+	default:
+		return value, nil
+	}
+}
+
+// resolveImportRelation returns the id of the record of the given model that has
+// the given value as external ID or, failing that, as record name.
+func (env Environment) resolveImportRelation(model *Model, value string) (int64, error) {
+	rc := env.Pool(model.name)
+	byExtID := rc.Search(model.Field("HexyaExternalID").Equals(value)).Limit(1)
+	if !byExtID.IsEmpty() {
+		return byExtID.ids[0], nil
+	}
+	modelData := env.Pool("ModelData").Sudo()
+	data := modelData.Search(modelData.Model().Field("Name").Equals(value).And().Field("Model").Equals(model.name))
+	if !data.IsEmpty() {
+		return data.Get("ResID").(int64), nil
+	}
+	byName := rc.Call("SearchByName", value, operator.Equals, newCondition(), 2).(RecordSet).Collection()
+	switch byName.Len() {
+	case 0:
+		return 0, fmt.Errorf("no %s record found for '%s'", model.name, value)
+	case 1:
+		return byName.ids[0], nil
+	default:
+		return 0, fmt.Errorf("several %s records match '%s'", model.name, value)
+	}
+}
+
+// importBatch imports the given lines in the database within a savepoint and adds
+// the results to res. If the batch fails, lines are imported one by one so that
+// only the failing lines are reported as errors.
+func (rc *RecordCollection) importBatch(batch []*importLine, res *ImportResult) {
+	if len(batch) == 0 {
+		return
+	}
+	// Computations deferred by the caller must not be lost if the batch fails
+	rc.env.ProcessDeferredComputations()
+	rc.env.cr.Execute("SAVEPOINT hexya_import")
+	ids, err := rc.WithContext("import_mode", true).importLines(batch)
+	if err == nil {
+		rc.env.cr.Execute("RELEASE SAVEPOINT hexya_import")
+		res.IDs = append(res.IDs, ids...)
+		return
+	}
+	rc.env.cr.Execute("ROLLBACK TO SAVEPOINT hexya_import")
+	rc.env.cr.Execute("RELEASE SAVEPOINT hexya_import")
+	// Records created in the rolled back savepoint may be in cache
+	rc.env.Cache().Clear()
+	rc.env.deferred.clear()
+	if len(batch) == 1 {
+		res.Errors = append(res.Errors, ImportError{Line: batch[0].line, Message: err.Error()})
+		return
+	}
+	for _, line := range batch {
+		rc.importBatch([]*importLine{line}, res)
+	}
+}
+
+// importLines creates or updates the records of the given lines
+// and returns their ids. Panics are returned as errors.
+func (rc *RecordCollection) importLines(lines []*importLine) (ids []int64, rError error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if err, ok := r.(error); ok {
+				rError = err
+				return
+			}
+			rError = errors.New(fmt.Sprint(r))
+		}
+	}()
+	for _, line := range lines {
+		var rec *RecordCollection
+		if extID, ok := line.values["hexya_external_id"]; ok {
+			rec = rc.Search(rc.model.Field("HexyaExternalID").Equals(extID)).Limit(1)
+		}
+		if rec != nil && !rec.IsEmpty() {
+			rec.Call("Write", line.values)
+		} else {
+			rec = rc.Call("Create", line.values).(RecordSet).Collection()
+		}
+		for fi, children := range line.children {
+			for _, child := range children {
+				child[fi.jsonReverseFK] = rec.ids[0]
+				rc.env.Pool(fi.relatedModelName).Call("Create", child)
+			}
+		}
+		ids = append(ids, rec.ids[0])
+	}
+	rc.env.ProcessDeferredComputations()
+	return
+}
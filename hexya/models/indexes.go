@@ -0,0 +1,106 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A modelIndex holds the data needed to create a
+// composite or partial index in the database
+type modelIndex struct {
+	name   string
+	fields []FieldNamer
+	unique bool
+	where  string
+}
+
+// AddIndex adds an index on the given fields of this model, which is created
+// in the database when it is synchronized.
+//   - name is an arbitrary name to reference this index. It will be appended by
+//     the table name in the database, so there is only need to ensure that it is unique
+//     in this model.
+//   - fields are the stored fields of the index, in the order of the index columns.
+//   - unique makes the index unique. To get a user friendly error message when the
+//     index is violated, use AddUniqueConstraint instead.
+//   - where is the SQL condition selecting the records to index, such as "active = TRUE".
+//     If empty, all records are indexed.
+//
+// Indexes that are not declared anymore are dropped and indexes whose declaration
+// has changed are recreated. Use the Index parameter of fields for single column
+// indexes.
+func (m *Model) AddIndex(name string, fields []FieldNamer, unique bool, where string) {
+	if len(fields) == 0 {
+		log.Panic("Indexes must have at least one field", "model", m.name, "index", name)
+	}
+	indexName := fmt.Sprintf("%s_%s_idx", name, m.tableName)
+	m.indexes[indexName] = modelIndex{
+		name:   indexName,
+		fields: fields,
+		unique: unique,
+		where:  where,
+	}
+}
+
+// RemoveIndex removes the index with the given name from this model,
+// so that it is dropped from the database.
+func (m *Model) RemoveIndex(name string) {
+	delete(m.indexes, fmt.Sprintf("%s_%s_idx", name, m.tableName))
+}
+
+// indexSQL returns the SQL statement that creates the given index
+func indexSQL(m *Model, index modelIndex) string {
+	adapter := adapters[db.DriverName()]
+	cols := make([]string, len(index.fields))
+	for i, f := range index.fields {
+		fi := m.fields.MustGet(f.String())
+		if !fi.isStored() {
+			log.Panic("Indexes can only be set on stored fields", "model", m.name,
+				"index", index.name, "field", fi.name)
+		}
+		cols[i] = fi.json
+	}
+	var unique string
+	if index.unique {
+		unique = "UNIQUE "
+	}
+	query := fmt.Sprintf(`CREATE %sINDEX %s ON %s (%s)`,
+		unique, index.name, adapter.quoteTableName(m.tableName), strings.Join(cols, ", "))
+	if index.where != "" {
+		query += fmt.Sprintf(" WHERE %s", index.where)
+	}
+	return query
+}
+
+// updateDBModelIndexes creates the indexes of the given model that do not
+// exist in the database, recreates those whose definition has changed and
+// drops those that are not declared anymore.
+//
+// The definition of an index is stored as the comment of the index in the
+// database, so that changes can be detected.
+func updateDBModelIndexes(m *Model) {
+	adapter := adapters[db.DriverName()]
+	for indexName, index := range m.indexes {
+		query := indexSQL(m, index)
+		if adapter.indexExists(m.tableName, indexName) {
+			if adapter.indexComment(indexName) == query {
+				continue
+			}
+			log.Info("Recreating index with new definition", "model", m.name, "index", indexName)
+			dropIndex(indexName)
+		}
+		dbExecuteNoTx(query)
+		dbExecuteNoTx(adapter.commentIndexSQL(indexName, query))
+	}
+dbIdxLoop:
+	for _, dbIndexName := range adapter.indexes(m.tableName, fmt.Sprintf("%%_%s_idx", m.tableName)) {
+		for indexName := range m.indexes {
+			if indexName == dbIndexName {
+				continue dbIdxLoop
+			}
+		}
+		dropIndex(dbIndexName)
+	}
+}
@@ -15,6 +15,7 @@
 package models
 
 import (
+	"github.com/hexya-erp/hexya/hexya/models/security"
 	"github.com/hexya-erp/hexya/hexya/tools/logging"
 	"github.com/hexya-erp/hexya/hexya/tools/strutils"
 	"github.com/jmoiron/sqlx"
@@ -34,4 +35,35 @@ func init() {
 	declareCommonMixin()
 	declareBaseMixin()
 	declareModelMixin()
+	declareModelDataModel()
+	declareFieldTranslationModel()
+	declareFieldPropertyModel()
+	declareBinaryContentModel()
+	declareQuotaCounterModel()
+	declareStageModel()
+	declareCurrencyModel()
+	declareCompanyModel()
+	declareSequenceModels()
+	declareCronJobModel()
+	declareAuditLogModel()
+	declareApprovalModels()
+	declareMessageModels()
+	declareMessagingModels()
+	declareAttachmentModel()
+	declareDownloadModel()
+	declareMailModels()
+	declareUserPreferenceModel()
+	declareUserDefaultModel()
+	declareKeyValueModel()
+	declareMigrationModel()
+	declareSessionModel()
+	declareWebhookModels()
+	declareShareModel()
+	declareUserCredentialModel()
+	declareLDAPModels()
+	declareAPIKeyModel()
+	security.AuthenticationRegistry.RegisterBackend(ldapBackend{})
+	security.AuthenticationRegistry.RegisterBackend(passwordBackend{})
+	declareServerActionModel()
+	declareAutomationRuleModel()
 }
@@ -0,0 +1,77 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"reflect"
+	"strings"
+)
+
+// JSONPath returns a ConditionField on the value at the given path of keys
+// inside this JSON field. Values are compared as text, or as numbers or
+// booleans if the argument of the operator is a number or a boolean.
+//
+//	cond := pool.Product().Meta().JSONPath("dimensions", "width").Greater(10)
+func (c ConditionField) JSONPath(keys ...string) *ConditionField {
+	res := c
+	res.jsonPath = append(append([]string{}, c.jsonPath...), keys...)
+	return &res
+}
+
+// splitFieldPath splits the given condition field name into the path of
+// the field and the path of keys inside the field, if the field name has
+// a JSON path such as "Meta#color".
+func splitFieldPath(name string) ([]string, []string) {
+	parts := strings.Split(name, JSONPathSep)
+	return strings.Split(parts[0], ExprSep), parts[1:]
+}
+
+// fieldPath returns the field name of this predicate,
+// including its JSON path if any.
+func (p predicate) fieldPath() string {
+	res := strings.Join(p.exprs, ExprSep)
+	for _, key := range p.jsonPath {
+		res += JSONPathSep + key
+	}
+	return res
+}
+
+// getJSONValue returns the given value of a JSON field as a reflect.Value of
+// the given type. The value can be JSON data, such as read from the database,
+// or any value that can be encoded to JSON, such as a map sent by a client.
+func getJSONValue(value interface{}, targetType reflect.Type) (reflect.Value, error) {
+	var data []byte
+	switch val := value.(type) {
+	case []byte:
+		data = val
+	case string:
+		data = []byte(val)
+	default:
+		var err error
+		data, err = json.Marshal(value)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+	}
+	res := reflect.New(targetType)
+	if err := json.Unmarshal(data, res.Interface()); err != nil {
+		return reflect.Value{}, err
+	}
+	return res.Elem(), nil
+}
+
+// jsonSQLValue returns the given value of a JSON field encoded
+// in JSON, so that it can be written in the database.
+func jsonSQLValue(fi *Field, value interface{}) interface{} {
+	if _, ok := value.(driver.Valuer); ok {
+		return value
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		log.Panic("Unable to encode JSON value", "model", fi.model.name, "field", fi.name, "error", err)
+	}
+	return string(data)
+}
@@ -0,0 +1,198 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	"github.com/hexya-erp/hexya/hexya/models/types/dates"
+)
+
+// declareKeyValueModel creates the KeyValue system model which
+// stores the values of the key/value stores of the modules.
+func declareKeyValueModel() {
+	keyValue := createModel("KeyValue", SystemModel)
+	keyValue.InheritModel(Registry.MustGet("CommonMixin"))
+	keyValue.AddFields(map[string]FieldDefinition{
+		"Namespace": CharField{Required: true, Index: true},
+		"Key":       CharField{Required: true},
+		"Value":     TextField{Help: "JSON encoded value"},
+		"Version": IntegerField{Required: true,
+			Help: "Incremented at each write of the value for optimistic locking"},
+	})
+	keyValue.AddSQLConstraint("unique_key", "UNIQUE (namespace, key)",
+		"Keys must be unique in a namespace")
+}
+
+// A KVStore is a namespaced key/value store in which modules can persist
+// their operational state, such as the cursor of a synchronization job or
+// the last processed id, without declaring a model.
+//
+// Values are encoded in JSON. Each key has a version which is incremented
+// at each write, so that concurrent writers can use optimistic locking with
+// CompareAndSet.
+//
+// A KVStore bypasses access control: it is intended to be used by the
+// modules' code and must not be exposed to clients.
+type KVStore struct {
+	env       Environment
+	namespace string
+}
+
+// KVStore returns the key/value store of the given namespace, such
+// as the name of a module, in the transaction of this Environment.
+func (env Environment) KVStore(namespace string) KVStore {
+	if namespace == "" {
+		log.Panic("Key/value store namespace cannot be empty")
+	}
+	return KVStore{env: env, namespace: namespace}
+}
+
+// tableName returns the quoted name of the table of the KeyValue model
+func (s KVStore) tableName() string {
+	return adapters[db.DriverName()].quoteTableName(Registry.MustGet("KeyValue").tableName)
+}
+
+// encode returns the JSON encoding of the given value for the given key
+func (s KVStore) encode(key string, value interface{}) string {
+	data, err := json.Marshal(value)
+	if err != nil {
+		log.Panic("Unable to encode key/value store value", "namespace", s.namespace, "key", key, "error", err)
+	}
+	return string(data)
+}
+
+// Get decodes the value of the given key into dst, which must be a pointer,
+// and returns the version of the value. If the key does not exist, dst is
+// left unchanged and Get returns 0.
+func (s KVStore) Get(key string, dst interface{}) int64 {
+	var rows []struct {
+		Value   string `db:"value"`
+		Version int64  `db:"version"`
+	}
+	s.env.cr.Select(&rows, fmt.Sprintf(`SELECT value, version FROM %s WHERE namespace = ? AND key = ?`, s.tableName()),
+		s.namespace, key)
+	if len(rows) == 0 {
+		return 0
+	}
+	if err := json.Unmarshal([]byte(rows[0].Value), dst); err != nil {
+		log.Panic("Unable to decode key/value store value", "namespace", s.namespace, "key", key, "error", err)
+	}
+	return rows[0].Version
+}
+
+// Version returns the version of the value of the given key,
+// or 0 if the key does not exist.
+func (s KVStore) Version(key string) int64 {
+	var versions []int64
+	s.env.cr.Select(&versions, fmt.Sprintf(`SELECT version FROM %s WHERE namespace = ? AND key = ?`, s.tableName()),
+		s.namespace, key)
+	if len(versions) == 0 {
+		return 0
+	}
+	return versions[0]
+}
+
+// GetString returns the string value of the given key,
+// or def if the key does not exist.
+func (s KVStore) GetString(key string, def string) string {
+	res := def
+	s.Get(key, &res)
+	return res
+}
+
+// GetInt returns the integer value of the given key,
+// or def if the key does not exist.
+func (s KVStore) GetInt(key string, def int64) int64 {
+	res := def
+	s.Get(key, &res)
+	return res
+}
+
+// GetFloat returns the float value of the given key,
+// or def if the key does not exist.
+func (s KVStore) GetFloat(key string, def float64) float64 {
+	res := def
+	s.Get(key, &res)
+	return res
+}
+
+// GetBool returns the boolean value of the given key,
+// or def if the key does not exist.
+func (s KVStore) GetBool(key string, def bool) bool {
+	res := def
+	s.Get(key, &res)
+	return res
+}
+
+// GetDateTime returns the DateTime value of the given key,
+// or def if the key does not exist.
+func (s KVStore) GetDateTime(key string, def dates.DateTime) dates.DateTime {
+	var value interface{}
+	if s.Get(key, &value) == 0 {
+		return def
+	}
+	str, ok := value.(string)
+	if !ok {
+		// Zero DateTimes are encoded as false
+		return dates.DateTime{}
+	}
+	res, err := dates.ParseDateTime(dates.DefaultServerDateTimeFormat, str)
+	if err != nil {
+		log.Panic("Invalid DateTime in key/value store", "namespace", s.namespace, "key", key, "error", err)
+	}
+	return res
+}
+
+// Set sets the value of the given key, whatever its current
+// version, and returns the new version of the value.
+func (s KVStore) Set(key string, value interface{}) int64 {
+	var version int64
+	s.env.cr.Get(&version, fmt.Sprintf(`INSERT INTO %[1]s (namespace, key, value, version) VALUES (?, ?, ?, 1)
+		ON CONFLICT (namespace, key) DO UPDATE SET value = EXCLUDED.value, version = %[1]s.version + 1
+		RETURNING version`, s.tableName()),
+		s.namespace, key, s.encode(key, value))
+	return version
+}
+
+// CompareAndSet sets the value of the given key only if its current version is
+// the given version, i.e. if it has not been written since it was read. A version
+// of 0 means that the key must not exist. It returns true if the value has been
+// set, and false if the version did not match.
+//
+// The new version of the value is the given version plus one.
+func (s KVStore) CompareAndSet(key string, value interface{}, version int64) bool {
+	var res sql.Result
+	if version == 0 {
+		res = s.env.cr.Execute(fmt.Sprintf(`INSERT INTO %s (namespace, key, value, version) VALUES (?, ?, ?, 1)
+			ON CONFLICT (namespace, key) DO NOTHING`, s.tableName()),
+			s.namespace, key, s.encode(key, value))
+	} else {
+		res = s.env.cr.Execute(fmt.Sprintf(`UPDATE %s SET value = ?, version = version + 1
+			WHERE namespace = ? AND key = ? AND version = ?`, s.tableName()),
+			s.encode(key, value), s.namespace, key, version)
+	}
+	count, err := res.RowsAffected()
+	if err != nil {
+		log.Panic("Unable to get the number of updated keys", "namespace", s.namespace, "key", key, "error", err)
+	}
+	return count == 1
+}
+
+// Delete removes the given key from this store.
+// It is a no-op if the key does not exist.
+func (s KVStore) Delete(key string) {
+	s.env.cr.Execute(fmt.Sprintf(`DELETE FROM %s WHERE namespace = ? AND key = ?`, s.tableName()),
+		s.namespace, key)
+}
+
+// Keys returns the keys of this store, sorted alphabetically.
+func (s KVStore) Keys() []string {
+	var res []string
+	s.env.cr.Select(&res, fmt.Sprintf(`SELECT key FROM %s WHERE namespace = ? ORDER BY key`, s.tableName()),
+		s.namespace)
+	return res
+}
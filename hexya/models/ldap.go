@@ -0,0 +1,393 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hexya-erp/hexya/hexya/models/security"
+	"github.com/hexya-erp/hexya/hexya/models/types"
+	"gopkg.in/ldap.v2"
+)
+
+// LDAP server encryptions
+const (
+	ldapEncryptionNone     = "none"
+	ldapEncryptionSTARTTLS = "starttls"
+	ldapEncryptionTLS      = "tls"
+)
+
+// LDAPTimeout is the timeout of the requests to LDAP servers
+var LDAPTimeout = 10 * time.Second
+
+var (
+	// errLDAPUserNotFound is returned by ldapLookup when no
+	// entry of the LDAP server matches the login.
+	errLDAPUserNotFound = errors.New("user not found in LDAP directory")
+	// errLDAPInvalidCredentials is returned by ldapLookup when the
+	// LDAP server refuses the password of the user.
+	errLDAPInvalidCredentials = errors.New("invalid LDAP credentials")
+)
+
+// An ldapServerConfig holds the connection and search parameters of an LDAP server
+type ldapServerConfig struct {
+	host           string
+	port           int64
+	encryption     string
+	skipVerify     bool
+	bindDN         string
+	bindPassword   string
+	baseDN         string
+	userFilter     string
+	nameAttribute  string
+	emailAttribute string
+	groupAttribute string
+}
+
+// An ldapEntry is the entry of a user in an LDAP directory
+type ldapEntry struct {
+	dn     string
+	name   string
+	email  string
+	groups []string
+}
+
+// ldapLookup returns the entry of the user with the given login in the
+// given LDAP server, after checking the given password by binding as this
+// user. It is a variable so that tests do not need an LDAP server.
+var ldapLookup = lookupLDAPUser
+
+// declareLDAPModels creates the LDAPServer, LDAPGroupMapping and
+// LDAPMembership models.
+//
+// Users are authenticated against the active LDAP servers, by order of
+// sequence, by binding to the server as the entry found with the user filter.
+// Group mappings give the members of an LDAP group the membership of a
+// security group when they log in. The memberships granted this way are
+// recorded as LDAPMembership records, so that they can be granted again
+// when the server starts.
+//
+// LDAP servers can only be accessed by the admin.
+func declareLDAPModels() {
+	ldapServer := NewModel("LDAPServer")
+	groupMapping := NewModel("LDAPGroupMapping")
+	ldapMembership := NewModel("LDAPMembership")
+
+	ldapServer.AddFields(map[string]FieldDefinition{
+		"Name": CharField{Required: true},
+		"Host": CharField{Required: true},
+		"Port": IntegerField{Required: true, Default: DefaultValue(int64(389))},
+		"Encryption": SelectionField{Required: true, Default: DefaultValue(ldapEncryptionSTARTTLS),
+			Selection: types.Selection{
+				ldapEncryptionNone:     "None",
+				ldapEncryptionSTARTTLS: "STARTTLS",
+				ldapEncryptionTLS:      "LDAPS",
+			}},
+		"SkipVerify": BooleanField{String: "Skip Certificate Verification",
+			Help: "Do not verify the certificate of the server. Only use this for testing."},
+		"BindDN": CharField{String: "Bind DN",
+			Help: "DN of the account used to search users. Anonymous bind is used if empty."},
+		"BindPassword": CharField{NoCopy: true},
+		"BaseDN":       CharField{String: "Base DN", Required: true, Help: "DN of the subtree in which users are searched"},
+		"UserFilter": CharField{Required: true, Default: DefaultValue("(uid=%s)"),
+			Help: "LDAP filter of the users, in which %s is replaced by the login, e.g. (sAMAccountName=%s) for Active Directory"},
+		"NameAttribute":  CharField{Default: DefaultValue("cn"), Help: "Attribute holding the name of created users"},
+		"EmailAttribute": CharField{Default: DefaultValue("mail"), Help: "Attribute holding the email of created users"},
+		"GroupAttribute": CharField{Default: DefaultValue("memberOf"),
+			Help: "Attribute of the user entry holding the DNs of its groups"},
+		"CreateUsers": BooleanField{
+			Help: "Create a user at the first login of LDAP users that do not exist in the database"},
+		"GroupMappings": One2ManyField{RelationModel: groupMapping, ReverseFK: "Server"},
+		"Sequence":      IntegerField{Default: DefaultValue(int64(10))},
+		"Active":        BooleanField{Default: DefaultValue(true)},
+	})
+	ldapServer.SetDefaultOrder("Sequence", "ID")
+
+	groupMapping.AddFields(map[string]FieldDefinition{
+		"Server": Many2OneField{RelationModel: ldapServer, Required: true, OnDelete: Cascade, Index: true},
+		"LDAPGroup": CharField{String: "LDAP Group", Required: true,
+			Help: "DN of the LDAP group, as given by the group attribute of users"},
+		"GroupID": CharField{String: "Group", Required: true, Help: "ID of the security group of the members"},
+	})
+
+	ldapMembership.AddFields(map[string]FieldDefinition{
+		"Server":  Many2OneField{RelationModel: ldapServer, Required: true, OnDelete: Cascade, Index: true},
+		"UserID":  IntegerField{Required: true, Index: true},
+		"GroupID": CharField{String: "Group", Required: true},
+	})
+
+	ldapServer.AddMethod("TestConnection",
+		`TestConnection connects to this LDAP server and binds with its bind DN.
+		It panics with the error of the server if it fails.`,
+		func(rc *RecordCollection) {
+			rc.EnsureOne()
+			conf := rc.ldapServerConfig()
+			conn, err := conf.connect()
+			if err != nil {
+				log.Panic("Unable to connect to LDAP server", "server", rc.Get("Name"), "error", err)
+			}
+			defer conn.Close()
+			if err = conf.bind(conn); err != nil {
+				log.Panic("Unable to bind to LDAP server", "server", rc.Get("Name"), "error", err)
+			}
+		})
+}
+
+// ldapServerConfig returns the configuration of this LDAPServer record
+func (rc *RecordCollection) ldapServerConfig() ldapServerConfig {
+	return ldapServerConfig{
+		host:           rc.Get("Host").(string),
+		port:           rc.Get("Port").(int64),
+		encryption:     rc.Get("Encryption").(string),
+		skipVerify:     rc.Get("SkipVerify").(bool),
+		bindDN:         rc.Get("BindDN").(string),
+		bindPassword:   rc.Get("BindPassword").(string),
+		baseDN:         rc.Get("BaseDN").(string),
+		userFilter:     rc.Get("UserFilter").(string),
+		nameAttribute:  rc.Get("NameAttribute").(string),
+		emailAttribute: rc.Get("EmailAttribute").(string),
+		groupAttribute: rc.Get("GroupAttribute").(string),
+	}
+}
+
+// connect opens a connection to the LDAP server of this config,
+// encrypted with TLS unless its encryption is none.
+func (c ldapServerConfig) connect() (*ldap.Conn, error) {
+	addr := fmt.Sprintf("%s:%d", c.host, c.port)
+	tlsConfig := &tls.Config{ServerName: c.host, InsecureSkipVerify: c.skipVerify}
+	var (
+		conn *ldap.Conn
+		err  error
+	)
+	if c.encryption == ldapEncryptionTLS {
+		conn, err = ldap.DialTLS("tcp", addr, tlsConfig)
+	} else {
+		conn, err = ldap.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	conn.SetTimeout(LDAPTimeout)
+	if c.encryption == ldapEncryptionSTARTTLS {
+		if err = conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// bind binds the given connection with the bind DN of this
+// config, or anonymously if it has no bind DN.
+func (c ldapServerConfig) bind(conn *ldap.Conn) error {
+	if c.bindDN == "" {
+		return conn.UnauthenticatedBind("")
+	}
+	return conn.Bind(c.bindDN, c.bindPassword)
+}
+
+// lookupLDAPUser returns the entry of the user with the given login in the
+// LDAP server of the given config. The password is checked by binding as
+// the entry of the user.
+func lookupLDAPUser(conf ldapServerConfig, login, password string) (ldapEntry, error) {
+	conn, err := conf.connect()
+	if err != nil {
+		return ldapEntry{}, err
+	}
+	defer conn.Close()
+	if err = conf.bind(conn); err != nil {
+		return ldapEntry{}, err
+	}
+	attributes := []string{"dn"}
+	for _, attr := range []string{conf.nameAttribute, conf.emailAttribute, conf.groupAttribute} {
+		if attr != "" {
+			attributes = append(attributes, attr)
+		}
+	}
+	request := ldap.NewSearchRequest(conf.baseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, 0, false,
+		strings.Replace(conf.userFilter, "%s", ldap.EscapeFilter(login), -1), attributes, nil)
+	res, err := conn.Search(request)
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		return ldapEntry{}, err
+	}
+	if res == nil || len(res.Entries) != 1 {
+		// No entry or an ambiguous filter
+		return ldapEntry{}, errLDAPUserNotFound
+	}
+	entry := res.Entries[0]
+	if err = conn.Bind(entry.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return ldapEntry{}, errLDAPInvalidCredentials
+		}
+		return ldapEntry{}, err
+	}
+	return ldapEntry{
+		dn:     entry.DN,
+		name:   entry.GetAttributeValue(conf.nameAttribute),
+		email:  entry.GetAttributeValue(conf.emailAttribute),
+		groups: entry.GetAttributeValues(conf.groupAttribute),
+	}, nil
+}
+
+// LDAPAuthenticate returns the id of the user with the given login if the
+// given password is accepted by one of the active LDAP servers.
+//
+// Servers are tried by order of sequence, skipping the servers that cannot be
+// reached or that do not know the login. If the user does not exist in the
+// database, they are created if the server allows it. The security groups
+// mapped to LDAP groups are then granted to or removed from the user,
+// depending on their membership of the LDAP groups.
+//
+// The returned error is a security.UserNotFoundError if no server knows this
+// login and a security.InvalidCredentialsError if the password is refused.
+func (env Environment) LDAPAuthenticate(login, password string) (int64, error) {
+	if login == "" || password == "" {
+		// LDAP servers accept binds with an empty password as anonymous binds
+		return 0, security.UserNotFoundError(login)
+	}
+	servers := env.Pool("LDAPServer").Sudo()
+	servers = servers.Search(servers.Model().Field("Active").Equals(true))
+	for _, server := range servers.Records() {
+		entry, err := ldapLookup(server.ldapServerConfig(), login, password)
+		switch {
+		case err == errLDAPUserNotFound:
+			continue
+		case err == errLDAPInvalidCredentials:
+			return 0, security.InvalidCredentialsError(login)
+		case err != nil:
+			log.Warn("Unable to query LDAP server", "server", server.Get("Name"), "error", err)
+			continue
+		}
+		user := env.userByLogin(login)
+		if user.IsEmpty() {
+			if !server.Get("CreateUsers").(bool) {
+				return 0, security.UserNotFoundError(login)
+			}
+			user = env.createLDAPUser(login, entry)
+		}
+		server.syncLDAPGroups(user.ids[0], entry.groups)
+		return user.ids[0], nil
+	}
+	return 0, security.UserNotFoundError(login)
+}
+
+// createLDAPUser creates a User with the given login
+// and the name and email of the given LDAP entry.
+func (env Environment) createLDAPUser(login string, entry ldapEntry) *RecordCollection {
+	users := env.Pool("User").Sudo()
+	name := entry.name
+	if name == "" {
+		name = login
+	}
+	values := FieldMap{UserLoginField: login}
+	if _, ok := users.model.fields.Get("Name"); ok && UserLoginField != "Name" {
+		values["Name"] = name
+	}
+	if _, ok := users.model.fields.Get(UserEmailField); ok && entry.email != "" {
+		values[UserEmailField] = entry.email
+	}
+	log.Info("Creating user from LDAP directory", "login", login, "dn", entry.dn)
+	return users.Call("Create", values).(RecordSet).Collection()
+}
+
+// syncLDAPGroups grants to the user with the given uid the security groups
+// mapped by this LDAPServer to the given LDAP groups, and removes the
+// groups mapped to other LDAP groups.
+//
+// The granted groups are recorded as LDAPMembership records. Groups that
+// were granted by this server at a previous login but that are not mapped
+// anymore are removed from the user.
+func (rc *RecordCollection) syncLDAPGroups(uid int64, ldapGroups []string) {
+	granted := make(map[string]bool)
+	for _, mapping := range rc.Get("GroupMappings").(RecordSet).Collection().Records() {
+		group := security.Registry.GetGroup(mapping.Get("GroupID").(string))
+		if group == nil {
+			log.Warn("Unknown group in LDAP group mapping", "group", mapping.Get("GroupID"), "server", rc.Get("Name"))
+			continue
+		}
+		var member bool
+		for _, ldapGroup := range ldapGroups {
+			if strings.EqualFold(ldapGroup, mapping.Get("LDAPGroup").(string)) {
+				member = true
+				break
+			}
+		}
+		if member {
+			granted[group.ID] = true
+			security.Registry.AddMembership(uid, group)
+			continue
+		}
+		security.Registry.RemoveMembership(uid, group)
+	}
+	memberships := rc.env.Pool("LDAPMembership").Sudo()
+	memberships = memberships.Search(memberships.model.Field("Server").Equals(rc).
+		And().Field("UserID").Equals(uid))
+	for _, membership := range memberships.Records() {
+		groupID := membership.Get("GroupID").(string)
+		if granted[groupID] {
+			delete(granted, groupID)
+			continue
+		}
+		if group := security.Registry.GetGroup(groupID); group != nil {
+			security.Registry.RemoveMembership(uid, group)
+		}
+		membership.Call("Unlink")
+	}
+	for groupID := range granted {
+		memberships.Call("Create", FieldMap{"Server": rc, "UserID": uid, "GroupID": groupID})
+	}
+}
+
+// LoadLDAPMemberships grants to the users the security groups recorded
+// as LDAPMembership records at their last LDAP login.
+//
+// Memberships are only kept in memory by security.Registry, so this
+// function must be called at each start of the server, after the modules
+// have loaded their own memberships.
+func LoadLDAPMemberships() {
+	err := ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+		env.loadLDAPMemberships()
+	})
+	if err != nil {
+		log.Panic("Unable to load LDAP memberships", "error", err)
+	}
+}
+
+// loadLDAPMemberships grants to the users the security groups
+// recorded as LDAPMembership records in this Environment.
+func (env Environment) loadLDAPMemberships() {
+	for _, membership := range env.Pool("LDAPMembership").Sudo().SearchAll().Records() {
+		group := security.Registry.GetGroup(membership.Get("GroupID").(string))
+		if group == nil {
+			log.Warn("Unknown group in LDAP membership", "group", membership.Get("GroupID"))
+			continue
+		}
+		security.Registry.AddMembership(membership.Get("UserID").(int64), group)
+	}
+}
+
+// ldapBackend is the security.AuthBackend which authenticates
+// users against the LDAP servers of the LDAPServer model.
+type ldapBackend struct{}
+
+// Authenticate the user defined by the given login and password
+func (lb ldapBackend) Authenticate(login, secret string, context *types.Context) (int64, error) {
+	var (
+		uid     int64
+		authErr error
+	)
+	err := ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+		uid, authErr = env.LDAPAuthenticate(login, secret)
+	})
+	if err != nil {
+		return 0, security.UserNotFoundError(login)
+	}
+	return uid, authErr
+}
+
+var _ security.AuthBackend = ldapBackend{}
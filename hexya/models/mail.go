@@ -0,0 +1,407 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"html"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hexya-erp/hexya/hexya/models/security"
+	"github.com/hexya-erp/hexya/hexya/models/types"
+	"github.com/hexya-erp/hexya/hexya/models/types/dates"
+	"github.com/hexya-erp/hexya/hexya/tools/logging"
+)
+
+// Mail states
+const (
+	mailOutgoing  = "outgoing"
+	mailSent      = "sent"
+	mailException = "exception"
+	mailCancel    = "cancel"
+)
+
+// Mail server encryptions
+const (
+	mailEncryptionNone     = "none"
+	mailEncryptionSTARTTLS = "starttls"
+	mailEncryptionSSL      = "ssl"
+)
+
+// A mailServerConfig holds the connection parameters of a mail server
+type mailServerConfig struct {
+	host       string
+	port       int64
+	username   string
+	password   string
+	encryption string
+}
+
+// sendMail sends the given message from the given address to the given
+// recipients through the given mail server. It is a variable so that tests
+// do not need an SMTP server.
+var sendMail = sendSMTPMail
+
+// declareMailModels creates the MailServer, Mail and MailTemplate models.
+//
+// Mails are not sent when they are created: they are queued as outgoing and
+// sent by ProcessMailQueue, which is called periodically by the cron worker
+// of the server, once their scheduled date is reached.
+//
+// Mail servers and mails can only be accessed by the admin. Users can send
+// mails from templates that they can read.
+func declareMailModels() {
+	mailServer := NewModel("MailServer")
+	mailServer.AddFields(map[string]FieldDefinition{
+		"Name":     CharField{Required: true},
+		"Host":     CharField{Required: true},
+		"Port":     IntegerField{Required: true, Default: DefaultValue(int64(25))},
+		"Username": CharField{},
+		"Password": CharField{},
+		"Encryption": SelectionField{Required: true, Default: DefaultValue(mailEncryptionNone),
+			Selection: types.Selection{
+				mailEncryptionNone:     "None",
+				mailEncryptionSTARTTLS: "STARTTLS",
+				mailEncryptionSSL:      "SSL/TLS",
+			}},
+		"Sequence": IntegerField{Default: DefaultValue(int64(10)),
+			Help: "The server with the lowest sequence is used for mails without a server"},
+		"Active": BooleanField{Default: DefaultValue(true)},
+	})
+	mailServer.SetDefaultOrder("Sequence", "ID")
+
+	mailModel := NewModel("Mail")
+	mailModel.AddFields(map[string]FieldDefinition{
+		"EmailFrom": CharField{String: "From", Required: true},
+		"EmailTo":   CharField{String: "To", Required: true, Help: "Comma separated list of recipients"},
+		"EmailCC":   CharField{String: "Cc", Help: "Comma separated list of carbon copy recipients"},
+		"Subject":   CharField{},
+		"Body":      TextField{Help: "HTML content of the mail"},
+		"State": SelectionField{Required: true, Index: true, Default: DefaultValue(mailOutgoing),
+			Selection: types.Selection{
+				mailOutgoing:  "Outgoing",
+				mailSent:      "Sent",
+				mailException: "Delivery Failed",
+				mailCancel:    "Cancelled",
+			}},
+		"ScheduledDate": DateTimeField{Index: true, Help: "The mail is not sent before this date if it is set"},
+		"SentDate":      DateTimeField{NoCopy: true},
+		"FailureReason": TextField{NoCopy: true},
+		"MailServer": Many2OneField{RelationModel: mailServer,
+			Help: "The server with the lowest sequence is used if not set"},
+		"ResModel": CharField{String: "Resource Model", Index: true,
+			Help: "Name of the model of the record this mail is about"},
+		"ResID": IntegerField{String: "Resource ID", BigInt: true, Index: true,
+			Help: "ID of the record this mail is about"},
+	})
+	mailModel.SetDefaultOrder("ID desc")
+
+	mailModel.AddMethod("Send",
+		`Send sends immediately the outgoing mails of this RecordSet, whatever
+		their scheduled date. Mails that cannot be sent are marked as failed with
+		the reason of the failure.`,
+		func(rc *RecordCollection) {
+			for _, m := range rc.Records() {
+				if m.Get("State").(string) != mailOutgoing {
+					continue
+				}
+				m.sendMail()
+			}
+		})
+
+	mailModel.AddMethod("Cancel",
+		`Cancel cancels the outgoing and failed mails of this RecordSet.`,
+		func(rc *RecordCollection) {
+			for _, m := range rc.Records() {
+				if state := m.Get("State").(string); state == mailOutgoing || state == mailException {
+					m.Call("Write", FieldMap{"State": mailCancel})
+				}
+			}
+		})
+
+	mailModel.AddMethod("Retry",
+		`Retry queues again the failed mails of this RecordSet.`,
+		func(rc *RecordCollection) {
+			for _, m := range rc.Records() {
+				if m.Get("State").(string) == mailException {
+					m.Call("Write", FieldMap{"State": mailOutgoing, "FailureReason": ""})
+				}
+			}
+		})
+
+	mailModel.AddMethod("ProcessQueue",
+		`ProcessQueue sends the due outgoing mails in the current transaction.
+		It can be called by a CronJob to send mails at a given frequency.`,
+		func(rc *RecordCollection) int64 {
+			var count int64
+			for {
+				mails := rc.env.claimMails(1)
+				if mails == nil {
+					return count
+				}
+				mails.sendMail()
+				count++
+			}
+		})
+
+	mailTemplate := NewModel("MailTemplate")
+
+	mailTemplate.AddMethod("CheckModel",
+		`CheckModel checks that the model of this template exists.`,
+		func(rc *RecordCollection) {
+			if _, ok := Registry.Get(rc.Get("Model").(string)); !ok {
+				log.Panic("Unknown model in mail template", "template", rc.Get("Name"), "model", rc.Get("Model"))
+			}
+		})
+
+	mailTemplate.AddFields(map[string]FieldDefinition{
+		"Name":      CharField{Required: true},
+		"Model":     CharField{Required: true, Constraint: mailTemplate.methods.MustGet("CheckModel")},
+		"EmailFrom": CharField{String: "From"},
+		"EmailTo":   CharField{String: "To"},
+		"EmailCC":   CharField{String: "Cc"},
+		"Subject":   CharField{},
+		"Body":      TextField{Help: "HTML content of the mail. Placeholder values are escaped."},
+	})
+
+	mailTemplate.AddMethod("SendMail",
+		`SendMail renders this template for each record of the given RecordSet
+		and queues the resulting mails, which are returned. All the fields of
+		the template can hold {{ expression }} placeholders, which are evaluated
+		on the record as with Evaluate.`,
+		func(rc *RecordCollection, records RecordSet) *RecordCollection {
+			rc.EnsureOne()
+			recs := records.Collection()
+			if recs.ModelName() != rc.Get("Model").(string) {
+				log.Panic("Mail template used with records of another model", "template", rc.Get("Name"),
+					"model", rc.Get("Model"), "records", recs.ModelName())
+			}
+			mails := rc.env.Pool(mailModel.name).Sudo()
+			var ids []int64
+			for _, rec := range recs.Records() {
+				values := FieldMap{
+					"ResModel": recs.ModelName(),
+					"ResID":    rec.ids[0],
+					"Body":     rec.renderPlaceholders(rc.Get("Body").(string), html.EscapeString),
+				}
+				for _, f := range []string{"EmailFrom", "EmailTo", "EmailCC", "Subject"} {
+					values[f] = rec.RenderPlaceholders(rc.Get(f).(string))
+				}
+				ids = append(ids, mails.Call("Create", values).(RecordSet).Ids()...)
+			}
+			return rc.env.Pool(mailModel.name).withIds(ids)
+		}).AllowGroup(security.GroupEveryone)
+
+	for _, method := range []string{"Load", "Read"} {
+		mailTemplate.methods.MustGet(method).AllowGroup(security.GroupEveryone)
+	}
+}
+
+// SendMail queues a mail with the given values, which are the
+// values of the fields of the Mail model, and returns it.
+//
+// The mail is sent by ProcessMailQueue. Users with access to the Mail
+// model can also send it immediately with its Send method.
+func (env Environment) SendMail(values FieldMap) *RecordCollection {
+	res := env.Pool("Mail").Sudo().Call("Create", values).(RecordSet).Collection()
+	return env.Pool("Mail").withIds(res.Ids())
+}
+
+// ProcessMailQueue sends all the outgoing mails whose scheduled date is
+// reached and returns the number of mails that have been processed.
+//
+// Each mail is sent in its own transaction, in which it is first claimed
+// with a row lock that other transactions skip, so that this function can
+// be called concurrently by several server processes. It returns after the
+// current mail once StopProcessing has been called, so that the state of
+// the mail that is being sent is committed.
+func ProcessMailQueue() int {
+	var count int
+	for !ProcessingStopped() {
+		var claimed bool
+		err := ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+			mails := env.claimMails(1)
+			claimed = mails != nil
+			if claimed {
+				mails.sendMail()
+			}
+		})
+		if err != nil {
+			log.Warn("Error while processing mail queue", "error", err)
+			return count
+		}
+		if !claimed {
+			return count
+		}
+		count++
+	}
+	return count
+}
+
+// claimMails locks and returns at most limit due outgoing mails that are
+// not locked by another transaction, or nil if there is none.
+func (env Environment) claimMails(limit int) *RecordCollection {
+	mailModel := Registry.MustGet("Mail")
+	var ids []int64
+	env.cr.Select(&ids, fmt.Sprintf(`SELECT id FROM %s WHERE state = ? AND (scheduled_date IS NULL OR scheduled_date <= ?)
+		ORDER BY id LIMIT ? FOR UPDATE SKIP LOCKED`,
+		adapters[db.DriverName()].quoteTableName(mailModel.tableName)), mailOutgoing, dates.Now(), limit)
+	if len(ids) == 0 {
+		return nil
+	}
+	return env.Pool(mailModel.name).Sudo().withIds(ids)
+}
+
+// sendMail sends this outgoing mail and updates its state.
+func (rc *RecordCollection) sendMail() {
+	rc.EnsureOne()
+	var failure string
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				failure = logging.LogPanicData(r).Error()
+			}
+		}()
+		server := rc.mailServer()
+		from, recipients, msg := rc.buildMailMessage()
+		if err := sendMail(server, from, recipients, msg); err != nil {
+			log.Panic("Unable to send mail", "id", rc.ids[0], "host", server.host, "error", err)
+		}
+	}()
+	if failure != "" {
+		log.Warn("Mail delivery failed", "id", rc.ids[0], "error", failure)
+		rc.Call("Write", FieldMap{"State": mailException, "FailureReason": failure})
+		return
+	}
+	rc.Call("Write", FieldMap{"State": mailSent, "SentDate": dates.Now(), "FailureReason": ""})
+}
+
+// mailServer returns the configuration of the server through which this
+// mail must be sent. It panics if no active mail server is configured.
+func (rc *RecordCollection) mailServer() mailServerConfig {
+	server := rc.Get("MailServer").(RecordSet).Collection()
+	if server.IsEmpty() {
+		servers := rc.env.Pool("MailServer").Sudo()
+		server = servers.Search(servers.Model().Field("Active").Equals(true)).Limit(1)
+	}
+	if server.IsEmpty() {
+		log.Panic("No mail server configured")
+	}
+	return mailServerConfig{
+		host:       server.Get("Host").(string),
+		port:       server.Get("Port").(int64),
+		username:   server.Get("Username").(string),
+		password:   server.Get("Password").(string),
+		encryption: server.Get("Encryption").(string),
+	}
+}
+
+// buildMailMessage returns the sender address, the recipients addresses
+// and the RFC 5322 message of this mail. It panics if an address is invalid.
+func (rc *RecordCollection) buildMailMessage() (string, []string, []byte) {
+	from, err := mail.ParseAddress(rc.Get("EmailFrom").(string))
+	if err != nil {
+		log.Panic("Invalid sender address", "address", rc.Get("EmailFrom"), "error", err)
+	}
+	var recipients []string
+	headers := []string{
+		"From: " + from.String(),
+	}
+	for _, f := range []struct{ field, header string }{{"EmailTo", "To"}, {"EmailCC", "Cc"}} {
+		value := strings.TrimSpace(rc.Get(f.field).(string))
+		if value == "" {
+			continue
+		}
+		addresses, err := mail.ParseAddressList(value)
+		if err != nil {
+			log.Panic("Invalid recipient address", "address", value, "error", err)
+		}
+		var strAddresses []string
+		for _, addr := range addresses {
+			recipients = append(recipients, addr.Address)
+			strAddresses = append(strAddresses, addr.String())
+		}
+		headers = append(headers, f.header+": "+strings.Join(strAddresses, ", "))
+	}
+	if len(recipients) == 0 {
+		log.Panic("Mail has no recipient", "id", rc.ids[0])
+	}
+	headers = append(headers,
+		"Subject: "+mime.QEncoding.Encode("utf-8", rc.Get("Subject").(string)),
+		"Date: "+time.Now().Format(time.RFC1123Z),
+		fmt.Sprintf("Message-Id: <%d.%d@%s>", rc.ids[0], time.Now().UnixNano(), from.Address[strings.LastIndex(from.Address, "@")+1:]),
+		"MIME-Version: 1.0",
+		"Content-Type: text/html; charset=utf-8",
+		"Content-Transfer-Encoding: quoted-printable",
+	)
+	var buf bytes.Buffer
+	buf.WriteString(strings.Join(headers, "\r\n"))
+	buf.WriteString("\r\n\r\n")
+	qpWriter := quotedprintable.NewWriter(&buf)
+	qpWriter.Write([]byte(rc.Get("Body").(string)))
+	qpWriter.Close()
+	return from.Address, recipients, buf.Bytes()
+}
+
+// sendSMTPMail sends the given message through the given SMTP server
+func sendSMTPMail(server mailServerConfig, from string, recipients []string, msg []byte) error {
+	addr := net.JoinHostPort(server.host, strconv.FormatInt(server.port, 10))
+	tlsConfig := &tls.Config{ServerName: server.host}
+	var (
+		client *smtp.Client
+		err    error
+	)
+	if server.encryption == mailEncryptionSSL {
+		var conn *tls.Conn
+		conn, err = tls.Dial("tcp", addr, tlsConfig)
+		if err != nil {
+			return err
+		}
+		client, err = smtp.NewClient(conn, server.host)
+	} else {
+		client, err = smtp.Dial(addr)
+	}
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	if server.encryption == mailEncryptionSTARTTLS {
+		if err = client.StartTLS(tlsConfig); err != nil {
+			return err
+		}
+	}
+	if server.username != "" {
+		if err = client.Auth(smtp.PlainAuth("", server.username, server.password, server.host)); err != nil {
+			return err
+		}
+	}
+	if err = client.Mail(from); err != nil {
+		return err
+	}
+	for _, rcpt := range recipients {
+		if err = client.Rcpt(rcpt); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(msg); err != nil {
+		return err
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
@@ -0,0 +1,209 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hexya-erp/hexya/hexya/models/fieldtype"
+	"github.com/hexya-erp/hexya/hexya/models/security"
+	"github.com/hexya-erp/hexya/hexya/models/types"
+	"github.com/hexya-erp/hexya/hexya/models/types/dates"
+)
+
+// Message types
+const (
+	// MessageComment is a message written by a user
+	MessageComment = "comment"
+	// MessageNotification is a message generated automatically,
+	// such as the tracking messages of changed fields.
+	MessageNotification = "notification"
+)
+
+// declareMessageModels creates the Message model, which holds the
+// messages linked to any record, and the TrackingValue model, which
+// holds the changes of tracked fields notified by a message.
+//
+// Access to a message is granted by the linked record: reading a message
+// requires read access to the linked record.
+func declareMessageModels() {
+	message := NewModel("Message")
+	trackingValue := NewModel("TrackingValue")
+
+	message.AddFields(map[string]FieldDefinition{
+		"ResModel": CharField{String: "Resource Model", Required: true, Index: true},
+		"ResID":    IntegerField{String: "Resource ID", BigInt: true, Required: true, Index: true},
+		"Body":     TextField{},
+		"MessageType": SelectionField{Required: true, Default: DefaultValue(MessageComment),
+			Selection: types.Selection{MessageComment: "Comment", MessageNotification: "Notification"}},
+		"AuthorUID": IntegerField{String: "Author ID", Help: "ID of the user who wrote or triggered this message"},
+		"Date": DateTimeField{Required: true, Index: true,
+			Default: func(env Environment) interface{} { return dates.Now() }},
+		"TrackingValues": One2ManyField{RelationModel: trackingValue, ReverseFK: "Message"},
+	})
+	message.SetDefaultOrder("Date desc", "ID desc")
+
+	trackingValue.AddFields(map[string]FieldDefinition{
+		"Message":          Many2OneField{RelationModel: message, Required: true, Index: true, OnDelete: Cascade},
+		"Field":            CharField{Required: true},
+		"FieldDescription": CharField{},
+		"OldValue":         CharField{Help: "Display value of the field before the change"},
+		"NewValue":         CharField{Help: "Display value of the field after the change"},
+	})
+
+	message.AddMethod("Read",
+		`Read checks that the user can read the linked records before reading the messages.`,
+		func(rc *RecordCollection, fields []string) []FieldMap {
+			checkMessageAccess(rc)
+			return rc.Super().Call("Read", fields).([]FieldMap)
+		})
+
+	for _, model := range []*Model{message, trackingValue} {
+		for _, method := range []string{"Load", "Read"} {
+			model.methods.MustGet(method).AllowGroup(security.GroupEveryone)
+		}
+	}
+}
+
+// checkMessageAccess panics if the current user cannot read
+// the records linked to the messages of rc.
+func checkMessageAccess(rc *RecordCollection) {
+	if rc.env.uid == security.SuperUserID || rc.IsEmpty() {
+		return
+	}
+	linked := make(map[string][]int64)
+	for _, msg := range rc.Sudo().Records() {
+		resModel := msg.Get("ResModel").(string)
+		linked[resModel] = append(linked[resModel], msg.Get("ResID").(int64))
+	}
+	checkLinkedRecordsAccess(rc.env, linked, "messages")
+}
+
+// checkLinkedRecordsAccess panics if the user of env cannot read the given
+// records, given as ids by model name, to which the given kind of objects
+// such as messages are linked.
+func checkLinkedRecordsAccess(env Environment, linked map[string][]int64, kind string) {
+	if env.uid == security.SuperUserID {
+		return
+	}
+	var checks []PermissionCheck
+	for resModel, ids := range linked {
+		checks = append(checks, PermissionCheck{Model: resModel, Method: "Load", IDs: ids})
+	}
+	for _, res := range env.CheckPermissions(checks) {
+		if !res.Allowed {
+			log.Panic("You are not allowed to access the records linked to these "+kind,
+				"model", res.Model, "ids", res.DeniedIDs, "uid", env.uid)
+		}
+	}
+}
+
+// A trackingMessage is a tracking message to post
+type trackingMessage struct {
+	values         FieldMap
+	trackingValues []FieldMap
+}
+
+// trackedFields returns the fields of fMap that have the
+// Tracking attribute, sorted by name.
+func (rc *RecordCollection) trackedFields(fMap FieldMap) []*Field {
+	var res []*Field
+	for key := range fMap {
+		fi, ok := rc.model.fields.Get(key)
+		if !ok || !fi.tracking {
+			continue
+		}
+		res = append(res, fi)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].name < res[j].name
+	})
+	return res
+}
+
+// trackingValues returns the display values of the given fields
+// for all the records of this RecordCollection, by record id and
+// field name.
+func (rc *RecordCollection) trackingValues(fields []*Field) map[int64]map[string]string {
+	if len(fields) == 0 {
+		return nil
+	}
+	res := make(map[int64]map[string]string)
+	for _, rec := range rc.Records() {
+		values := make(map[string]string)
+		for _, fi := range fields {
+			values[fi.name] = trackingDisplayValue(fi, rec.Get(fi.name))
+		}
+		res[rec.ids[0]] = values
+	}
+	return res
+}
+
+// trackingDisplayValue returns the given value of the given field as it
+// is shown in tracking messages: display names for relation fields and
+// labels for selection fields.
+func trackingDisplayValue(fi *Field, value interface{}) string {
+	str := displayString(value)
+	if fi.fieldType == fieldtype.Selection {
+		if label, ok := fi.selection[str]; ok {
+			return label
+		}
+	}
+	return str
+}
+
+// trackChanges posts a tracking message on each record of this RecordCollection
+// for which the display value of one of the given fields differs from its
+// value in old. Messages are deferred in import mode.
+func (rc *RecordCollection) trackChanges(fields []*Field, old map[int64]map[string]string) {
+	if len(fields) == 0 {
+		return
+	}
+	newValues := rc.trackingValues(fields)
+	for _, id := range rc.Ids() {
+		var (
+			lines   []string
+			tValues []FieldMap
+		)
+		for _, fi := range fields {
+			oldVal, newVal := old[id][fi.name], newValues[id][fi.name]
+			if oldVal == newVal {
+				continue
+			}
+			lines = append(lines, fmt.Sprintf("%s: %s → %s", fi.description, oldVal, newVal))
+			tValues = append(tValues, FieldMap{
+				"Field":            fi.name,
+				"FieldDescription": fi.description,
+				"OldValue":         oldVal,
+				"NewValue":         newVal,
+			})
+		}
+		if len(tValues) == 0 {
+			continue
+		}
+		msg := trackingMessage{
+			values: FieldMap{
+				"ResModel":    rc.model.name,
+				"ResID":       id,
+				"Body":        strings.Join(lines, "\n"),
+				"MessageType": MessageNotification,
+				"AuthorUID":   rc.env.uid,
+			},
+			trackingValues: tValues,
+		}
+		if rc.env.importMode() {
+			rc.env.deferred.messages = append(rc.env.deferred.messages, msg)
+			continue
+		}
+		rc.env.postTrackingMessage(msg)
+	}
+}
+
+// postTrackingMessage creates the given tracking message
+// and notifies the followers of the linked record.
+func (env Environment) postTrackingMessage(msg trackingMessage) {
+	env.postMessage(msg.values, msg.trackingValues)
+}
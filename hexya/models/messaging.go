@@ -0,0 +1,213 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"github.com/hexya-erp/hexya/hexya/models/security"
+)
+
+// declareMessagingModels creates the Follower and Notification models
+// and the MessagingMixin.
+//
+// Models that inherit MessagingMixin get a discussion thread per record:
+// messages can be posted on their records and the followers of a record
+// are notified of each new message.
+func declareMessagingModels() {
+	message := Registry.MustGet("Message")
+
+	follower := NewModel("Follower")
+	follower.AddFields(map[string]FieldDefinition{
+		"ResModel": CharField{String: "Resource Model", Required: true, Index: true},
+		"ResID":    IntegerField{String: "Resource ID", BigInt: true, Required: true, Index: true},
+		"UserID":   IntegerField{String: "User ID", Required: true, Index: true},
+	})
+	follower.AddSQLConstraint("unique_follower", "UNIQUE (res_model, res_id, user_id)",
+		"A user can only follow a record once")
+
+	notification := NewModel("Notification")
+	notification.AddFields(map[string]FieldDefinition{
+		"Message": Many2OneField{RelationModel: message, Required: true, Index: true, OnDelete: Cascade},
+		"UserID":  IntegerField{String: "User ID", Required: true, Index: true},
+		"IsRead":  BooleanField{String: "Read", Index: true},
+	})
+
+	message.AddMethod("Inbox",
+		`Inbox returns the messages of which the current user has
+		an unread notification, the most recent first.`,
+		func(rc *RecordCollection) *RecordCollection {
+			var ids []int64
+			for _, notif := range rc.env.Pool(notification.name).Sudo().Search(notification.Field("UserID").Equals(rc.env.uid).
+				And().Field("IsRead").Equals(false)).Records() {
+				ids = append(ids, notif.Get("Message").(RecordSet).Ids()...)
+			}
+			if len(ids) == 0 {
+				return rc.env.Pool(message.name).withIds(nil)
+			}
+			return rc.env.Pool(message.name).Search(message.Field("ID").In(ids))
+		}).AllowGroup(security.GroupEveryone)
+
+	message.AddMethod("MarkAsRead",
+		`MarkAsRead marks the notifications of these messages
+		to the current user as read.`,
+		func(rc *RecordCollection) {
+			if rc.IsEmpty() {
+				return
+			}
+			rc.env.Pool(notification.name).Sudo().Search(notification.Field("Message").In(rc.Ids()).
+				And().Field("UserID").Equals(rc.env.uid)).Call("Write", FieldMap{"IsRead": true})
+		}).AllowGroup(security.GroupEveryone)
+
+	messagingMixin := NewMixinModel("MessagingMixin")
+
+	messagingMixin.AddMethod("Messages",
+		`Messages returns the messages posted on the records
+		of this RecordSet, the most recent first.`,
+		func(rc *RecordCollection) *RecordCollection {
+			messages := rc.env.Pool(message.name)
+			if rc.IsEmpty() {
+				return messages.withIds(nil)
+			}
+			return messages.Search(message.Field("ResModel").Equals(rc.model.name).
+				And().Field("ResID").In(rc.Ids()))
+		}).AllowGroup(security.GroupEveryone)
+
+	messagingMixin.AddMethod("PostMessage",
+		`PostMessage posts a message with the given body on each record of this
+		RecordSet, notifies their followers and returns the new messages.`,
+		func(rc *RecordCollection, body string) *RecordCollection {
+			checkLinkedRecordsAccess(rc.env, map[string][]int64{rc.model.name: rc.Ids()}, "messages")
+			var ids []int64
+			for _, id := range rc.Ids() {
+				msg := rc.env.postMessage(FieldMap{
+					"ResModel":    rc.model.name,
+					"ResID":       id,
+					"Body":        body,
+					"MessageType": MessageComment,
+					"AuthorUID":   rc.env.uid,
+				}, nil)
+				ids = append(ids, msg.ids[0])
+			}
+			return rc.env.Pool(message.name).withIds(ids)
+		}).AllowGroup(security.GroupEveryone)
+
+	messagingMixin.AddMethod("Followers",
+		`Followers returns the ids of the users following this record.`,
+		func(rc *RecordCollection) []int64 {
+			rc.EnsureOne()
+			var res []int64
+			for _, f := range rc.followers().Records() {
+				res = append(res, f.Get("UserID").(int64))
+			}
+			return res
+		}).AllowGroup(security.GroupEveryone)
+
+	messagingMixin.AddMethod("Subscribe",
+		`Subscribe adds the users with the given ids to the followers of the
+		records of this RecordSet. Users already following a record are ignored.`,
+		func(rc *RecordCollection, uids []int64) {
+			checkLinkedRecordsAccess(rc.env, map[string][]int64{rc.model.name: rc.Ids()}, "followers")
+			followers := rc.env.Pool(follower.name).Sudo()
+			for _, rec := range rc.Records() {
+				existing := make(map[int64]bool)
+				for _, uid := range rec.Call("Followers").([]int64) {
+					existing[uid] = true
+				}
+				for _, uid := range uids {
+					if existing[uid] {
+						continue
+					}
+					existing[uid] = true
+					followers.Call("Create", FieldMap{
+						"ResModel": rc.model.name,
+						"ResID":    rec.ids[0],
+						"UserID":   uid,
+					})
+				}
+			}
+		}).AllowGroup(security.GroupEveryone)
+
+	messagingMixin.AddMethod("Unsubscribe",
+		`Unsubscribe removes the users with the given ids from the
+		followers of the records of this RecordSet.`,
+		func(rc *RecordCollection, uids []int64) {
+			if rc.IsEmpty() || len(uids) == 0 {
+				return
+			}
+			checkLinkedRecordsAccess(rc.env, map[string][]int64{rc.model.name: rc.Ids()}, "followers")
+			rc.followers().Search(follower.Field("UserID").In(uids)).Call("Unlink")
+		}).AllowGroup(security.GroupEveryone)
+
+	messagingMixin.AddMethod("Create",
+		`Create subscribes the current user to the new record.`,
+		func(rc *RecordCollection, data FieldMapper) *RecordCollection {
+			res := rc.Super().Call("Create", data).(RecordSet).Collection()
+			res.Call("Subscribe", []int64{rc.env.uid})
+			return res
+		})
+
+	messagingMixin.AddMethod("Unlink",
+		`Unlink deletes the messages and the followers of the deleted records.`,
+		func(rc *RecordCollection) int64 {
+			ids := rc.Ids()
+			res := rc.Super().Call("Unlink").(int64)
+			remaining := make(map[int64]bool)
+			for _, id := range rc.env.Pool(rc.model.name).Sudo().Search(rc.model.Field("ID").In(ids)).Ids() {
+				remaining[id] = true
+			}
+			var deleted []int64
+			for _, id := range ids {
+				if !remaining[id] {
+					deleted = append(deleted, id)
+				}
+			}
+			if len(deleted) == 0 {
+				return res
+			}
+			for _, model := range []*Model{message, follower} {
+				rc.env.Pool(model.name).Sudo().Search(model.Field("ResModel").Equals(rc.model.name).
+					And().Field("ResID").In(deleted)).Call("Unlink")
+			}
+			return res
+		})
+}
+
+// followers returns the Follower records of the records of this RecordCollection.
+func (rc *RecordCollection) followers() *RecordCollection {
+	followers := rc.env.Pool("Follower").Sudo()
+	return followers.Search(followers.Model().Field("ResModel").Equals(rc.model.name).
+		And().Field("ResID").In(rc.Ids()))
+}
+
+// postMessage creates a message with the given values and tracking values,
+// and a notification for each follower of the linked record except its author.
+func (env Environment) postMessage(values FieldMap, trackingValues []FieldMap) *RecordCollection {
+	message := env.Pool("Message").Sudo().Call("Create", values).(RecordSet).Collection()
+	tValues := env.Pool("TrackingValue").Sudo()
+	for _, tValue := range trackingValues {
+		tValue["Message"] = message.ids[0]
+		tValues.Call("Create", tValue)
+	}
+	followers := env.Pool("Follower").Sudo()
+	followers = followers.Search(followers.Model().Field("ResModel").Equals(values["ResModel"]).
+		And().Field("ResID").Equals(values["ResID"]).
+		And().Field("UserID").NotEquals(values["AuthorUID"]))
+	var uids []int64
+	for _, f := range followers.Records() {
+		uids = append(uids, f.Get("UserID").(int64))
+	}
+	env.notifyUsers(message, uids)
+	return message
+}
+
+// notifyUsers creates a notification of the given message
+// for each user with the given ids.
+func (env Environment) notifyUsers(message *RecordCollection, uids []int64) {
+	notifications := env.Pool("Notification").Sudo()
+	for _, uid := range uids {
+		notifications.Call("Create", FieldMap{
+			"Message": message.ids[0],
+			"UserID":  uid,
+		})
+	}
+}
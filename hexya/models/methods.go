@@ -16,6 +16,7 @@ package models
 
 import (
 	"reflect"
+	"strings"
 	"sync"
 
 	"github.com/hexya-erp/hexya/hexya/models/security"
@@ -46,6 +47,12 @@ func (mc *MethodsCollection) get(methodName string) (*Method, bool) {
 	return mi, true
 }
 
+// Get returns the Method with the given name. The second
+// returned value is false if the method does not exist.
+func (mc *MethodsCollection) Get(methodName string) (*Method, bool) {
+	return mc.get(methodName)
+}
+
 // MustGet returns the Method of the given method. It panics if the
 // method is not found.
 func (mc *MethodsCollection) MustGet(methodName string) *Method {
@@ -102,6 +109,7 @@ type Method struct {
 	nextLayer     map[*methodLayer]*methodLayer
 	groups        map[*security.Group]bool
 	groupsCallers map[callerGroup]bool
+	private       bool
 }
 
 // addMethodLayer adds the given layer to this Method.
@@ -164,6 +172,50 @@ func (m *Method) RevokeGroup(group *security.Group) *Method {
 	return m
 }
 
+// SetPrivate sets whether this method is private. Private methods
+// cannot be called by API clients, whatever their permissions.
+func (m *Method) SetPrivate(value bool) *Method {
+	m.Lock()
+	defer m.Unlock()
+	m.private = value
+	return m
+}
+
+// IsPrivate returns true if this method cannot be called by API clients
+func (m *Method) IsPrivate() bool {
+	return m.private
+}
+
+// Doc returns the documentation of this method, followed by the
+// documentation of its extensions, if any. The indentation of the
+// lines of the doc strings is removed.
+func (m *Method) Doc() string {
+	m.RLock()
+	defer m.RUnlock()
+	var docs []string
+	for _, layer := range m.invertedLayers() {
+		doc := cleanDocString(layer.doc)
+		if doc == "" || (len(docs) > 0 && docs[len(docs)-1] == doc) {
+			continue
+		}
+		docs = append(docs, doc)
+	}
+	if len(docs) == 0 {
+		return cleanDocString(m.doc)
+	}
+	return strings.Join(docs, "\n\n")
+}
+
+// cleanDocString returns the given doc string with the leading and
+// trailing spaces of each line removed.
+func cleanDocString(doc string) string {
+	lines := strings.Split(strings.TrimSpace(doc), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(line)
+	}
+	return strings.Join(lines, "\n")
+}
+
 // Underlying returns the underlysing method data object
 func (m *Method) Underlying() *Method {
 	return m
@@ -197,10 +249,12 @@ func copyMethod(m *Model, method *Method) *Method {
 	return &Method{
 		model:         m,
 		name:          method.name,
+		doc:           method.doc,
 		methodType:    method.methodType,
 		nextLayer:     make(map[*methodLayer]*methodLayer),
 		groups:        make(map[*security.Group]bool),
 		groupsCallers: make(map[callerGroup]bool),
+		private:       method.private,
 	}
 }
 
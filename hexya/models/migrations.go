@@ -0,0 +1,241 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hexya-erp/hexya/hexya/models/security"
+	"github.com/hexya-erp/hexya/hexya/models/types"
+	"github.com/hexya-erp/hexya/hexya/models/types/dates"
+)
+
+// A MigrationStage defines when a migration function is run
+// relatively to the synchronization of the database schema.
+type MigrationStage string
+
+const (
+	// MigrationPre functions are run before the database schema is
+	// synchronized with the models. The database has still the schema of
+	// the previous version, so they should only use raw SQL queries.
+	MigrationPre MigrationStage = "pre"
+	// MigrationPost functions are run after the database schema has been
+	// synchronized and the data records have been loaded.
+	MigrationPost MigrationStage = "post"
+	// migrationInstalled is the stage of the history entry which records
+	// that a module is installed and its current version.
+	migrationInstalled MigrationStage = "installed"
+)
+
+// A Migration holds the functions that upgrade the database
+// to a version of a module. Both functions are optional.
+type Migration struct {
+	Pre  func(env Environment)
+	Post func(env Environment)
+}
+
+// function returns the function of this Migration for the given stage
+func (m Migration) function(stage MigrationStage) func(env Environment) {
+	switch stage {
+	case MigrationPre:
+		return m.Pre
+	case MigrationPost:
+		return m.Post
+	}
+	return nil
+}
+
+// declareMigrationModel creates the Migration system model which
+// keeps the history of the migrations applied to the database.
+func declareMigrationModel() {
+	migration := createModel("Migration", SystemModel)
+	migration.InheritModel(Registry.MustGet("CommonMixin"))
+	migration.AddFields(map[string]FieldDefinition{
+		"Module":  CharField{Required: true, Index: true},
+		"Version": CharField{Required: true},
+		"Stage": SelectionField{Required: true, Selection: types.Selection{
+			string(MigrationPre): "Pre-migration", string(MigrationPost): "Post-migration",
+			string(migrationInstalled): "Installed"}},
+		"AppliedAt": DateTimeField{Required: true},
+	})
+	migration.AddSQLConstraint("unique_migration", "UNIQUE (module, version, stage)",
+		"A migration can only be applied once")
+}
+
+// migrationTableName returns the quoted name of the table of the Migration model
+func migrationTableName() string {
+	return adapters[db.DriverName()].quoteTableName(Registry.MustGet("Migration").tableName)
+}
+
+// RunMigrations runs the functions of the given stage of the given migrations
+// of a module that have not been applied to the database yet. Migrations are
+// keyed by the module version they upgrade to and are run in ascending version
+// order, each one in its own transaction together with its history entry.
+//
+// Migrations are only run for modules that are already installed. When a
+// module is installed, including when the database is created, all its
+// migrations are recorded as applied without being run. The installed version
+// of the module is updated to the given version after the post-migrations.
+//
+// RunMigrations should be called for each module in dependency order, for the
+// MigrationPre stage before SyncDatabase and for the MigrationPost stage after
+// it. It panics if a migration fails.
+func RunMigrations(module, version string, stage MigrationStage, migrations map[string]Migration) {
+	if !adapters[db.DriverName()].tables()[Registry.MustGet("Migration").tableName] {
+		log.Debug("No migration history in database, skipping migrations", "module", module, "stage", stage)
+		return
+	}
+	var applied map[string]bool
+	err := SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+		applied = appliedMigrations(env, module)
+	})
+	if err != nil {
+		log.Panic("Unable to read migration history", "module", module, "error", err)
+	}
+	if !applied[migrationKey("", migrationInstalled)] {
+		if stage == MigrationPost {
+			installModuleMigrations(module, version, migrations)
+		}
+		return
+	}
+	for _, v := range migrationVersions(migrations) {
+		fnct := migrations[v].function(stage)
+		if fnct == nil || applied[migrationKey(v, stage)] {
+			continue
+		}
+		log.Info("Running migration", "module", module, "version", v, "stage", stage)
+		err = ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+			fnct(env)
+			recordMigration(env, module, v, stage)
+		})
+		if err != nil {
+			log.Panic("Migration failed", "module", module, "version", v, "stage", stage, "error", err)
+		}
+	}
+	if stage == MigrationPost {
+		err = ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+			env.cr.Execute(fmt.Sprintf(`UPDATE %s SET version = ?, applied_at = ? WHERE module = ? AND stage = ?`,
+				migrationTableName()), version, dates.Now(), module, migrationInstalled)
+		})
+		if err != nil {
+			log.Panic("Unable to update module version", "module", module, "version", version, "error", err)
+		}
+	}
+}
+
+// installModuleMigrations records the given module as installed
+// in the given version and all its migrations as applied.
+func installModuleMigrations(module, version string, migrations map[string]Migration) {
+	log.Info("Recording module migrations as applied", "module", module, "version", version)
+	err := ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+		for _, v := range migrationVersions(migrations) {
+			for _, stage := range []MigrationStage{MigrationPre, MigrationPost} {
+				if migrations[v].function(stage) != nil {
+					recordMigration(env, module, v, stage)
+				}
+			}
+		}
+		env.cr.Execute(fmt.Sprintf(`INSERT INTO %s (module, version, stage, applied_at) VALUES (?, ?, ?, ?)`,
+			migrationTableName()), module, version, migrationInstalled, dates.Now())
+	})
+	if err != nil {
+		log.Panic("Unable to record module migrations", "module", module, "error", err)
+	}
+}
+
+// recordMigration adds the given migration to the migration history
+func recordMigration(env Environment, module, version string, stage MigrationStage) {
+	env.cr.Execute(fmt.Sprintf(`INSERT INTO %s (module, version, stage, applied_at) VALUES (?, ?, ?, ?)`,
+		migrationTableName()), module, version, stage, dates.Now())
+}
+
+// appliedMigrations returns the migrations of the given module in the migration
+// history as a set of migration keys. The installed entry has an empty version.
+func appliedMigrations(env Environment, module string) map[string]bool {
+	var rows []struct {
+		Version string `db:"version"`
+		Stage   string `db:"stage"`
+	}
+	env.cr.Select(&rows, fmt.Sprintf(`SELECT version, stage FROM %s WHERE module = ?`, migrationTableName()), module)
+	res := make(map[string]bool)
+	for _, row := range rows {
+		if MigrationStage(row.Stage) == migrationInstalled {
+			row.Version = ""
+		}
+		res[migrationKey(row.Version, MigrationStage(row.Stage))] = true
+	}
+	return res
+}
+
+// migrationKey returns the key of the migration of
+// the given version and stage in a migration set.
+func migrationKey(version string, stage MigrationStage) string {
+	return fmt.Sprintf("%s/%s", version, stage)
+}
+
+// InstalledModuleVersion returns the version of the given module recorded
+// in the migration history, and false if the module is not installed.
+func InstalledModuleVersion(module string) (string, bool) {
+	if !adapters[db.DriverName()].tables()[Registry.MustGet("Migration").tableName] {
+		return "", false
+	}
+	var versions []string
+	err := SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+		env.cr.Select(&versions, fmt.Sprintf(`SELECT version FROM %s WHERE module = ? AND stage = ?`,
+			migrationTableName()), module, migrationInstalled)
+	})
+	if err != nil {
+		log.Panic("Unable to read migration history", "module", module, "error", err)
+	}
+	if len(versions) == 0 {
+		return "", false
+	}
+	return versions[0], true
+}
+
+// migrationVersions returns the versions of the given migrations in ascending order
+func migrationVersions(migrations map[string]Migration) []string {
+	res := make([]string, 0, len(migrations))
+	for v := range migrations {
+		res = append(res, v)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return CompareVersions(res[i], res[j]) < 0
+	})
+	return res
+}
+
+// CompareVersions compares two dotted version strings such as "1.2.10" and
+// returns -1, 0 or 1 if a is lower than, equal to or greater than b. Numeric
+// parts are compared as numbers, other parts alphabetically, and a missing
+// part is lower than any other.
+func CompareVersions(a, b string) int {
+	aParts, bParts := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(aParts) || i < len(bParts); i++ {
+		switch {
+		case i >= len(aParts):
+			return -1
+		case i >= len(bParts):
+			return 1
+		}
+		aNum, aErr := strconv.Atoi(aParts[i])
+		bNum, bErr := strconv.Atoi(bParts[i])
+		switch {
+		case aErr == nil && bErr == nil && aNum != bNum:
+			if aNum < bNum {
+				return -1
+			}
+			return 1
+		case (aErr != nil || bErr != nil) && aParts[i] != bParts[i]:
+			if aParts[i] < bParts[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
@@ -0,0 +1,48 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"errors"
+	"time"
+)
+
+// MaxNotificationPayload is the maximum size in bytes of
+// the payload of a notification sent with Notify.
+const MaxNotificationPayload = 7999
+
+// ErrNotificationPayloadTooLarge is returned by Notify if the
+// payload is larger than MaxNotificationPayload.
+var ErrNotificationPayloadTooLarge = errors.New("notification payload is too large")
+
+// Notify sends a notification with the given payload on the given database
+// channel. The notification is received by all the handlers registered
+// with Listen on this channel by any instance connected to the same database.
+//
+// Notify does not take part in any transaction: the notification is sent
+// immediately, even if the current transaction is rolled back later.
+func Notify(channel, payload string) error {
+	if len(payload) > MaxNotificationPayload {
+		return ErrNotificationPayloadTooLarge
+	}
+	query, args := sanitizeQuery(adapters[db.DriverName()].notifySQL(), channel, payload)
+	t := time.Now()
+	_, err := db.Exec(query, args...)
+	if err != nil {
+		log.Warn("Unable to send notification", "channel", channel, "error", err)
+		return err
+	}
+	log.Debug("Notification sent", "channel", channel, "duration", time.Now().Sub(t))
+	return nil
+}
+
+// Listen calls handler in its own goroutine with the payload of each
+// notification sent with Notify on the given database channel.
+//
+// Listen uses a dedicated connection to the database which is reestablished
+// automatically if it is lost. Notifications sent while the connection is
+// down are lost.
+func Listen(channel string, handler func(payload string)) error {
+	return adapters[db.DriverName()].listen(dbConnData, channel, handler)
+}
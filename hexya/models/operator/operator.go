@@ -23,6 +23,8 @@ const (
 	In             Operator = "in"
 	NotIn          Operator = "not in"
 	ChildOf        Operator = "child_of"
+	Match          Operator = "match"
+	Similar        Operator = "similar"
 )
 
 var allowedOperators = map[Operator]bool{
@@ -41,6 +43,8 @@ var allowedOperators = map[Operator]bool{
 	In:             true,
 	NotIn:          true,
 	ChildOf:        true,
+	Match:          true,
+	Similar:        true,
 }
 
 var negativeOperators = map[Operator]bool{
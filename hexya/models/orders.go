@@ -0,0 +1,82 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"strings"
+)
+
+// An orderExpr is a parsed ORDER BY expression of a Query,
+// such as "Partner.Name desc nulls last".
+type orderExpr struct {
+	path      string
+	direction string
+	nulls     string
+}
+
+// parseOrder parses the given ORDER BY expression, made of a field path
+// optionally followed by "asc" or "desc" and by "nulls first" or "nulls last".
+// Keywords are case insensitive. It panics if the expression is not valid.
+func parseOrder(order string) orderExpr {
+	tokens := strings.Fields(order)
+	if len(tokens) == 0 {
+		log.Panic("Empty order expression")
+	}
+	res := orderExpr{path: tokens[0]}
+	tokens = tokens[1:]
+	if len(tokens) > 0 {
+		switch dir := strings.ToUpper(tokens[0]); dir {
+		case "ASC", "DESC":
+			res.direction = dir
+			tokens = tokens[1:]
+		}
+	}
+	if len(tokens) == 2 && strings.ToUpper(tokens[0]) == "NULLS" {
+		switch nulls := strings.ToUpper(tokens[1]); nulls {
+		case "FIRST", "LAST":
+			res.nulls = nulls
+			tokens = nil
+		}
+	}
+	if len(tokens) > 0 {
+		log.Panic("Invalid order expression", "order", order)
+	}
+	return res
+}
+
+// sqlSuffix returns the SQL string to write after the
+// ordered expression in the ORDER BY clause.
+func (o orderExpr) sqlSuffix() string {
+	if o.nulls == "" {
+		return o.direction
+	}
+	return strings.TrimSpace(o.direction + " NULLS " + o.nulls)
+}
+
+// orderFieldExprs returns the field expressions by which records are ordered
+// for the given order path.
+//
+// Records ordered by a many2one or one2one field are ordered by the stored
+// record name fields of the related model, so that they appear in the order
+// of their display names. This does not apply to grouped queries, which can
+// only be ordered by their groups.
+func (q *Query) orderFieldExprs(path string) [][]string {
+	exprs := jsonizeExpr(q.recordSet.model, strings.Split(path, ExprSep))
+	fi := q.recordSet.model.getRelatedFieldInfo(strings.Join(exprs, ExprSep))
+	if len(q.groups) > 0 || !fi.fieldType.IsFKRelationType() {
+		return [][]string{exprs}
+	}
+	var res [][]string
+	for _, fName := range fi.relatedModel.RecordNameFields() {
+		nameField := fi.relatedModel.fields.MustGet(fName)
+		if !nameField.isStored() || nameField.fieldType.IsRelationType() {
+			continue
+		}
+		res = append(res, append(append([]string{}, exprs...), nameField.json))
+	}
+	if len(res) == 0 {
+		return [][]string{exprs}
+	}
+	return res
+}
@@ -0,0 +1,110 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import "github.com/hexya-erp/hexya/hexya/models/security"
+
+// A PermissionCheck is a permission to check with CheckPermissions.
+//
+// Method is the name of the method to execute on the model, for instance
+// "Create", "Write", "Unlink" or any other method such as a button action.
+// If IDs is set, the permission is also checked on these records.
+type PermissionCheck struct {
+	Model  string  `json:"model"`
+	Method string  `json:"method"`
+	IDs    []int64 `json:"ids,omitempty"`
+}
+
+// A PermissionCheckResult is the result of a PermissionCheck.
+//
+// DeniedIDs are the ids of the records of the check on which the method
+// cannot be executed, either because they do not exist, because record
+// rules prevent it, or because the method itself is not allowed.
+type PermissionCheckResult struct {
+	PermissionCheck
+	Allowed   bool    `json:"allowed"`
+	DeniedIDs []int64 `json:"denied_ids,omitempty"`
+}
+
+// A permissionGroupKey groups the permission checks that
+// are evaluated with a single query.
+type permissionGroupKey struct {
+	model *Model
+	perm  security.Permission
+}
+
+// CheckPermissions returns whether the user of this Environment is allowed to
+// execute the methods of the given checks, in the same order.
+//
+// This is meant for clients that need to know which actions to display
+// without calling the server for each of them. Method permissions are checked
+// without accessing the database, and record rules are evaluated with a single
+// query for all the records of a model and permission.
+//
+// Record rules are evaluated with the Write permission for the "Write"
+// method, with the Unlink permission for "Unlink", and with the Read permission
+// for all other methods except "Create" which does not apply to records.
+func (env Environment) CheckPermissions(checks []PermissionCheck) []PermissionCheckResult {
+	res := make([]PermissionCheckResult, len(checks))
+	idsToCheck := make(map[permissionGroupKey][]int64)
+	for i, check := range checks {
+		res[i].PermissionCheck = check
+		model, ok := Registry.Get(check.Model)
+		if !ok || model.isMixin() {
+			log.Warn("Checking permission on unknown model", "model", check.Model)
+			res[i].DeniedIDs = check.IDs
+			continue
+		}
+		method, ok := model.methods.get(check.Method)
+		if !ok || !env.Pool(model.name).CheckExecutionPermission(method, true) {
+			res[i].DeniedIDs = check.IDs
+			continue
+		}
+		res[i].Allowed = true
+		if perm := methodRulePermission(check.Method); perm != 0 && len(check.IDs) > 0 {
+			key := permissionGroupKey{model: model, perm: perm}
+			idsToCheck[key] = append(idsToCheck[key], check.IDs...)
+		}
+	}
+	allowedIDs := make(map[permissionGroupKey]map[int64]bool)
+	for key, ids := range idsToCheck {
+		allowedIDs[key] = make(map[int64]bool)
+		// We search as superuser so that only the record rules of the checked permission apply
+		rSet := env.Pool(key.model.name).Sudo().Search(key.model.Field("ID").In(ids))
+		for _, id := range rSet.addRecordRuleConditions(env.uid, key.perm).Ids() {
+			allowedIDs[key][id] = true
+		}
+	}
+	for i := range res {
+		if !res[i].Allowed || len(res[i].IDs) == 0 {
+			continue
+		}
+		perm := methodRulePermission(res[i].Method)
+		if perm == 0 {
+			continue
+		}
+		key := permissionGroupKey{model: Registry.MustGet(res[i].Model), perm: perm}
+		for _, id := range res[i].IDs {
+			if !allowedIDs[key][id] {
+				res[i].DeniedIDs = append(res[i].DeniedIDs, id)
+			}
+		}
+		res[i].Allowed = len(res[i].DeniedIDs) == 0
+	}
+	return res
+}
+
+// methodRulePermission returns the permission with which record rules
+// are evaluated when executing the method with the given name.
+func methodRulePermission(method string) security.Permission {
+	switch method {
+	case "Create":
+		return 0
+	case "Write":
+		return security.Write
+	case "Unlink":
+		return security.Unlink
+	}
+	return security.Read
+}
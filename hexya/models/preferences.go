@@ -0,0 +1,219 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+
+	"github.com/hexya-erp/hexya/hexya/models/fieldtype"
+	"github.com/hexya-erp/hexya/hexya/models/security"
+)
+
+// A Preference is a typed per-user setting, such as the homepage
+// or the notification policy of the user.
+type Preference struct {
+	// Name is the key of this preference. It must be unique.
+	Name string
+	// Type of the values of this preference. Supported types are
+	// Boolean, Integer, Float, Char, Text and Selection.
+	Type fieldtype.Type
+	// Default is the value of this preference for users who did not set it.
+	Default interface{}
+	// Groups restricts this preference to the members of these groups.
+	// If empty, the preference is available to everyone.
+	Groups []*security.Group
+	// Help is a description of this preference.
+	Help string
+}
+
+// availableFor returns true if this preference is available for the given user.
+func (p *Preference) availableFor(user EnvUser) bool {
+	if len(p.Groups) == 0 || user.ID() == security.SuperUserID {
+		return true
+	}
+	for _, group := range p.Groups {
+		if user.HasGroup(group) {
+			return true
+		}
+	}
+	return false
+}
+
+// convertValue returns the given JSON decoded value as a value of this preference type.
+func (p *Preference) convertValue(value interface{}) interface{} {
+	switch p.Type {
+	case fieldtype.Integer:
+		if v, ok := value.(float64); ok {
+			return int64(v)
+		}
+	case fieldtype.Float:
+		if v, ok := value.(float64); ok {
+			return v
+		}
+	case fieldtype.Boolean:
+		if v, ok := value.(bool); ok {
+			return v
+		}
+	default:
+		if v, ok := value.(string); ok {
+			return v
+		}
+	}
+	log.Panic("Invalid value for preference", "preference", p.Name, "type", p.Type, "value", value)
+	return nil
+}
+
+// A PreferenceCollection is the registry of all user preferences
+type PreferenceCollection struct {
+	sync.RWMutex
+	prefs map[string]*Preference
+}
+
+// Preferences is the registry of all user preferences.
+//
+// Modules should register their preferences here instead of adding
+// columns to the users model.
+var Preferences = &PreferenceCollection{
+	prefs: make(map[string]*Preference),
+}
+
+// Register adds the given preference to this registry.
+// It panics if a preference with the same name already exists.
+func (pc *PreferenceCollection) Register(pref *Preference) {
+	pc.Lock()
+	defer pc.Unlock()
+	if _, exists := pc.prefs[pref.Name]; exists {
+		log.Panic("Preference already registered", "preference", pref.Name)
+	}
+	pc.prefs[pref.Name] = pref
+}
+
+// Get returns the preference with the given name and true if it exists.
+func (pc *PreferenceCollection) Get(name string) (*Preference, bool) {
+	pc.RLock()
+	defer pc.RUnlock()
+	pref, ok := pc.prefs[name]
+	return pref, ok
+}
+
+// MustGet returns the preference with the given name.
+// It panics if the preference does not exist.
+func (pc *PreferenceCollection) MustGet(name string) *Preference {
+	pref, ok := pc.Get(name)
+	if !ok {
+		log.Panic("Unknown preference", "preference", name)
+	}
+	return pref
+}
+
+// All returns all the registered preferences sorted by name
+func (pc *PreferenceCollection) All() []*Preference {
+	pc.RLock()
+	defer pc.RUnlock()
+	res := make([]*Preference, 0, len(pc.prefs))
+	for _, pref := range pc.prefs {
+		res = append(res, pref)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Name < res[j].Name
+	})
+	return res
+}
+
+// declareUserPreferenceModel creates the UserPreference system model
+// which stores the preference values set by the users.
+func declareUserPreferenceModel() {
+	userPref := createModel("UserPreference", SystemModel)
+	userPref.InheritModel(Registry.MustGet("CommonMixin"))
+	userPref.AddFields(map[string]FieldDefinition{
+		"UserID": IntegerField{String: "User ID", Required: true, Index: true},
+		"Name":   CharField{Required: true, Index: true},
+		"Value":  TextField{Help: "JSON encoded value of the preference"},
+	})
+}
+
+// An EnvUser gives access to the data of the user of an Environment
+type EnvUser struct {
+	env Environment
+}
+
+// ID returns the id of this user
+func (u EnvUser) ID() int64 {
+	return u.env.uid
+}
+
+// prefRecord returns the UserPreference record of this user for the given preference
+func (u EnvUser) prefRecord(pref *Preference) *RecordCollection {
+	rc := u.env.Pool("UserPreference").Sudo()
+	cond := rc.Model().Field("UserID").Equals(u.env.uid).And().Field("Name").Equals(pref.Name)
+	return rc.Search(cond).Limit(1)
+}
+
+// availablePref returns the preference with the given name.
+// It panics if it does not exist or if this user is not allowed to use it.
+func (u EnvUser) availablePref(name string) *Preference {
+	pref := Preferences.MustGet(name)
+	if !pref.availableFor(u) {
+		log.Panic("Preference is not available for user", "preference", name, "uid", u.env.uid)
+	}
+	return pref
+}
+
+// Pref returns the value of the preference with the given name for this user,
+// or the preference default if the user did not set it.
+//
+// It panics if the preference does not exist or if it is not available for this user.
+func (u EnvUser) Pref(name string) interface{} {
+	pref := u.availablePref(name)
+	rec := u.prefRecord(pref)
+	if rec.IsEmpty() {
+		return pref.Default
+	}
+	var value interface{}
+	if err := json.Unmarshal([]byte(rec.Get("Value").(string)), &value); err != nil {
+		log.Panic("Unable to decode preference value", "preference", name, "uid", u.env.uid, "error", err)
+	}
+	return pref.convertValue(value)
+}
+
+// SetPref sets the value of the preference with the given name for this user.
+//
+// It panics if the preference does not exist or if it is not available for this user.
+func (u EnvUser) SetPref(name string, value interface{}) {
+	pref := u.availablePref(name)
+	data, err := json.Marshal(value)
+	if err != nil {
+		log.Panic("Unable to encode preference value", "preference", name, "value", value, "error", err)
+	}
+	var decoded interface{}
+	json.Unmarshal(data, &decoded)
+	pref.convertValue(decoded)
+	rec := u.prefRecord(pref)
+	if rec.IsEmpty() {
+		u.env.Pool("UserPreference").Sudo().Call("Create", FieldMap{
+			"UserID": u.env.uid,
+			"Name":   pref.Name,
+			"Value":  string(data),
+		})
+		return
+	}
+	rec.Call("Write", FieldMap{"Value": string(data)})
+}
+
+// Prefs returns the values of all the preferences available for this user.
+//
+// This method is intended to be used by the client metadata endpoints
+// so that the client always has the preferences of the user.
+func (u EnvUser) Prefs() map[string]interface{} {
+	res := make(map[string]interface{})
+	for _, pref := range Preferences.All() {
+		if !pref.availableFor(u) {
+			continue
+		}
+		res[pref.Name] = u.Pref(pref.Name)
+	}
+	return res
+}
@@ -0,0 +1,226 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+
+	"github.com/hexya-erp/hexya/hexya/models/fieldtype"
+	"github.com/hexya-erp/hexya/hexya/models/types/dates"
+)
+
+// A propertyRef is the key of the value of a company
+// dependent field in the cache
+type propertyRef struct {
+	model   *Model
+	id      int64
+	field   string
+	company int64
+}
+
+// declareFieldPropertyModel creates the FieldProperty system model
+// which stores the values of company dependent fields.
+//
+// A FieldProperty with a ResID of 0 holds the default value of the field for
+// all the records of the model. A FieldProperty with a CompanyID of 0 holds
+// the value when there is no current company.
+func declareFieldPropertyModel() {
+	fieldProperty := createModel("FieldProperty", SystemModel)
+	fieldProperty.InheritModel(Registry.MustGet("CommonMixin"))
+	fieldProperty.AddFields(map[string]FieldDefinition{
+		"Model":     CharField{Required: true, Index: true},
+		"Field":     CharField{Required: true},
+		"ResID":     IntegerField{String: "Record ID", BigInt: true, Index: true},
+		"CompanyID": IntegerField{String: "Company ID"},
+		"Value":     TextField{},
+	})
+	fieldProperty.AddSQLConstraint("unique_property", "UNIQUE (model, field, res_id, company_id)",
+		"A field can only have one value per record and company")
+}
+
+// getCompanyValue returns the value of the given company dependent field
+// for the first record of this RecordCollection in the current company.
+//
+// Values are loaded for all the records of the prefetch RecordCollection.
+func (rc *RecordCollection) getCompanyValue(fi *Field) interface{} {
+	company := rc.env.context.CompanyID()
+	ref := propertyRef{model: rc.model, id: rc.ids[0], field: fi.json, company: company}
+	val, ok := rc.env.cache.properties[ref]
+	if !ok {
+		ids := rc.ids
+		if rc.prefetchRC != nil && len(rc.prefetchRC.ids) > 0 {
+			ids = rc.prefetchRC.ids
+		}
+		rc.loadCompanyValues(fi, company, ids)
+		val = rc.env.cache.properties[ref]
+	}
+	return val
+}
+
+// loadCompanyValues loads the values of the given company dependent field
+// for the given ids in the given company into the cache.
+//
+// The value of a record is its own value in the company if any, or else
+// the default value of the company, or else the default value for all
+// companies.
+func (rc *RecordCollection) loadCompanyValues(fi *Field, company int64, ids []int64) {
+	var rows []struct {
+		ResID     int64
+		CompanyID int64
+		Value     sql.NullString
+	}
+	query := fmt.Sprintf(`SELECT res_id, company_id, value FROM %s WHERE model = ? AND field = ? AND company_id IN (?) AND res_id IN (?)`,
+		adapters[db.DriverName()].quoteTableName(Registry.MustGet("FieldProperty").tableName))
+	rc.env.cr.Select(&rows, query, rc.model.name, fi.json, []int64{company, 0}, append([]int64{0}, ids...))
+	values := make(map[[2]int64]sql.NullString)
+	for _, row := range rows {
+		values[[2]int64{row.ResID, row.CompanyID}] = row.Value
+	}
+	for _, id := range ids {
+		var value sql.NullString
+		for _, key := range [][2]int64{{id, company}, {0, company}, {0, 0}} {
+			if v, ok := values[key]; ok {
+				value = v
+				break
+			}
+		}
+		ref := propertyRef{model: rc.model, id: id, field: fi.json, company: company}
+		rc.env.cache.properties[ref] = rc.model.parsePropertyValue(fi, value)
+	}
+}
+
+// writeCompanyValues writes the values of the company dependent fields of fMap
+// for all the records of this RecordCollection in the current company.
+func (rc *RecordCollection) writeCompanyValues(fMap FieldMap) {
+	company := rc.env.context.CompanyID()
+	var rSet *RecordCollection
+	for field, value := range fMap {
+		fi, ok := rc.model.fields.Get(field)
+		if !ok || !fi.companyDependent {
+			continue
+		}
+		if rSet == nil {
+			// Only fetch ids if we have company dependent values to write
+			rSet = rc.Fetch()
+		}
+		strValue := formatPropertyValue(fi, value)
+		for _, id := range rSet.ids {
+			rc.upsertProperty(fi, id, company, strValue)
+			ref := propertyRef{model: rc.model, id: id, field: fi.json, company: company}
+			rc.env.cache.properties[ref] = rc.model.parsePropertyValue(fi, strValue)
+		}
+	}
+}
+
+// SetCompanyDefault sets the default value of the given company dependent field
+// for all the records of this RecordCollection's model in the current company.
+// If there is no current company, value is the default for all companies.
+//
+// The default value is used for records that have no value of their own in
+// the current company.
+func (rc *RecordCollection) SetCompanyDefault(field string, value interface{}) {
+	rc.CheckExecutionPermission(rc.model.methods.MustGet("Write"))
+	fi := rc.model.fields.MustGet(field)
+	if !fi.companyDependent {
+		log.Panic("Field is not company dependent", "model", rc.model.name, "field", field)
+	}
+	fMap := FieldMap{fi.json: value}
+	rc.model.convertValuesToFieldType(&fMap)
+	rc.upsertProperty(fi, 0, rc.env.context.CompanyID(), formatPropertyValue(fi, fMap[fi.json]))
+	for ref := range rc.env.cache.properties {
+		if ref.model == rc.model && ref.field == fi.json {
+			delete(rc.env.cache.properties, ref)
+		}
+	}
+}
+
+// upsertProperty writes the given value of the given field for
+// the record with the given id in the given company.
+func (rc *RecordCollection) upsertProperty(fi *Field, id, company int64, value sql.NullString) {
+	query := fmt.Sprintf(`INSERT INTO %s (model, field, res_id, company_id, value) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (model, field, res_id, company_id) DO UPDATE SET value = EXCLUDED.value`,
+		adapters[db.DriverName()].quoteTableName(Registry.MustGet("FieldProperty").tableName))
+	rc.env.cr.Execute(query, rc.model.name, fi.json, id, company, value)
+}
+
+// deleteCompanyValues deletes the values of the company dependent fields of the
+// records with the given ids of this RecordCollection's model, if any.
+func (rc *RecordCollection) deleteCompanyValues(ids []int64) {
+	var companyDependent bool
+	for _, fi := range rc.model.fields.registryByJSON {
+		if fi.companyDependent {
+			companyDependent = true
+			break
+		}
+	}
+	if !companyDependent || len(ids) == 0 {
+		return
+	}
+	query := fmt.Sprintf(`DELETE FROM %s WHERE model = ? AND res_id IN (?)`,
+		adapters[db.DriverName()].quoteTableName(Registry.MustGet("FieldProperty").tableName))
+	rc.env.cr.Execute(query, rc.model.name, ids)
+	for ref := range rc.env.cache.properties {
+		if ref.model == rc.model {
+			delete(rc.env.cache.properties, ref)
+		}
+	}
+}
+
+// formatPropertyValue returns the given value of the given company
+// dependent field as stored in the FieldProperty model.
+// value must already be converted to the type of the field.
+func formatPropertyValue(fi *Field, value interface{}) sql.NullString {
+	var res string
+	switch v := value.(type) {
+	case nil, *interface{}:
+		return sql.NullString{}
+	case dates.Date:
+		if v.IsZero() {
+			return sql.NullString{}
+		}
+		res = v.String()
+	case dates.DateTime:
+		if v.IsZero() {
+			return sql.NullString{}
+		}
+		res = v.String()
+	case float64:
+		res = strconv.FormatFloat(v, 'f', -1, 64)
+	case int64:
+		if v == 0 && fi.fieldType.IsFKRelationType() {
+			return sql.NullString{}
+		}
+		res = strconv.FormatInt(v, 10)
+	default:
+		res = fmt.Sprint(v)
+	}
+	return sql.NullString{String: res, Valid: true}
+}
+
+// parsePropertyValue returns the value of the given company dependent
+// field, with the type of the field, from its value in the FieldProperty model.
+func (m *Model) parsePropertyValue(fi *Field, value sql.NullString) interface{} {
+	var raw interface{}
+	if value.Valid {
+		var err error
+		switch fi.fieldType {
+		case fieldtype.Boolean:
+			raw, err = strconv.ParseBool(value.String)
+		case fieldtype.Integer, fieldtype.Many2One:
+			raw, err = strconv.ParseInt(value.String, 10, 64)
+		case fieldtype.Float, fieldtype.Monetary:
+			raw, err = strconv.ParseFloat(value.String, 64)
+		default:
+			raw = value.String
+		}
+		if err != nil {
+			log.Panic("Invalid company dependent field value", "model", m.name, "field", fi.name, "value", value.String, "error", err)
+		}
+	}
+	fMap := FieldMap{fi.json: raw}
+	m.convertValuesToFieldType(&fMap)
+	return fMap[fi.json]
+}
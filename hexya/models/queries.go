@@ -17,9 +17,11 @@ package models
 import (
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/hexya-erp/hexya/hexya/models/fieldtype"
 	"github.com/hexya-erp/hexya/hexya/models/operator"
+	"github.com/hexya-erp/hexya/hexya/models/types/dates"
 	"github.com/hexya-erp/hexya/hexya/tools/nbutils"
 	"github.com/hexya-erp/hexya/hexya/tools/strutils"
 )
@@ -49,8 +51,16 @@ type Query struct {
 	noDistinct bool
 	groups     []string
 	orders     []string
+	ranks      []queryRank
+	collation  string
+	cacheTTL   time.Duration
 }
 
+// sortKeyPrefix is the prefix of the aliases of the sort keys that are added
+// to the selected fields when records are not ordered by a column itself, since
+// ORDER BY expressions of SELECT DISTINCT queries must appear in the select list.
+const sortKeyPrefix = "__sort_"
+
 // clone returns a pointer to a deep copy of this Query
 func (q Query) clone() *Query {
 	newCond := *q.cond
@@ -128,7 +138,20 @@ func (q *Query) predicateSQLClause(p predicate) (string, SQLParams) {
 		sql  string
 		args SQLParams
 	)
+	adapter := adapters[db.DriverName()]
 	field := q.joinedFieldExpression(exprs)
+	if len(p.jsonPath) > 0 {
+		if fi.fieldType != fieldtype.JSON {
+			log.Panic("JSON paths can only be used on JSON fields", "model", q.recordSet.model.name,
+				"field", strings.Join(p.exprs, ExprSep), "path", p.jsonPath)
+		}
+		var pathArg interface{}
+		field, pathArg = adapter.jsonPathSQL(field, p.jsonPath, p.arg)
+		args = append(args, pathArg)
+	}
+	if p.operator == operator.Match {
+		return q.matchPredicateSQLClause(exprs, field, p.arg)
+	}
 	if p.arg == nil {
 		switch p.operator {
 		case operator.Equals:
@@ -140,13 +163,61 @@ func (q *Query) predicateSQLClause(p predicate) (string, SQLParams) {
 		}
 		return sql, args
 	}
-	adapter := adapters[db.DriverName()]
+	switch fi.fieldType {
+	case fieldtype.Date, fieldtype.DateTime:
+		if dSQL, dArgs, ok := q.datePredicateSQLClause(fi, field, &p); ok {
+			return dSQL, dArgs
+		}
+	}
 	opSql, arg := adapter.operatorSQL(p.operator, p.arg)
+	if isUnaccentedSearch(fi, p.operator) {
+		field = adapter.unaccentSQL(field)
+		opSql = strings.Replace(opSql, "?", adapter.unaccentSQL("?"), 1)
+	}
 	sql = fmt.Sprintf(`%s %s`, field, opSql)
 	args = append(args, arg)
 	return sql, args
 }
 
+// datePredicateSQLClause handles predicates comparing a Date field with
+// a DateTime argument or a DateTime field with a Date argument, where the
+// day is the one of the time zone of the context.
+//
+// DateTime arguments of Date fields are replaced by their date. For DateTime
+// fields, Date arguments stand for the whole day, so that the predicate is
+// turned into comparisons with the beginning of the day or of the next day.
+// It returns false if the predicate must be handled the standard way.
+func (q *Query) datePredicateSQLClause(fi *Field, field string, p *predicate) (string, SQLParams, bool) {
+	tz := q.recordSet.env.context.TZ()
+	switch arg := p.arg.(type) {
+	case dates.DateTime:
+		if fi.fieldType == fieldtype.Date {
+			p.arg = arg.In(tz).ToDate()
+		}
+	case dates.Date:
+		if fi.fieldType != fieldtype.DateTime {
+			break
+		}
+		start := arg.ToDateTime(tz)
+		end := start.AddPeriod(dates.PeriodDay, 1)
+		switch p.operator {
+		case operator.Equals:
+			return fmt.Sprintf(`(%s >= ? AND %s < ?)`, field, field), SQLParams{start, end}, true
+		case operator.NotEquals:
+			return fmt.Sprintf(`(%s < ? OR %s >= ?)`, field, field), SQLParams{start, end}, true
+		case operator.Greater:
+			return fmt.Sprintf(`%s >= ?`, field), SQLParams{end}, true
+		case operator.GreaterOrEqual:
+			return fmt.Sprintf(`%s >= ?`, field), SQLParams{start}, true
+		case operator.Lower:
+			return fmt.Sprintf(`%s < ?`, field), SQLParams{start}, true
+		case operator.LowerOrEqual:
+			return fmt.Sprintf(`%s < ?`, field), SQLParams{end}, true
+		}
+	}
+	return "", nil, false
+}
+
 // sqlLimitClause returns the sql string for the LIMIT and OFFSET clauses
 // of this Query
 func (q *Query) sqlLimitOffsetClause() string {
@@ -163,21 +234,14 @@ func (q *Query) sqlLimitOffsetClause() string {
 // sqlOrderByClause returns the sql string for the ORDER BY clause
 // of this Query
 func (q *Query) sqlOrderByClause() string {
-	var fExprs [][]string
-	directions := make([]string, len(q.orders))
-	for i, order := range q.orders {
-		fieldOrder := strings.Split(strings.TrimSpace(order), " ")
-		oExprs := jsonizeExpr(q.recordSet.model, strings.Split(fieldOrder[0], ExprSep))
-		fExprs = append(fExprs, oExprs)
-		if len(fieldOrder) > 1 {
-			directions[i] = fieldOrder[1]
+	resSlice := q.rankOrders()
+	for _, order := range q.orders {
+		oExpr := parseOrder(order)
+		for _, exprs := range q.orderFieldExprs(oExpr.path) {
+			field, _ := q.orderByExpression(exprs)
+			resSlice = append(resSlice, fmt.Sprintf("%s %s", field, oExpr.sqlSuffix()))
 		}
 	}
-	resSlice := make([]string, len(q.orders))
-	for i, field := range fExprs {
-		resSlice[i] = q.joinedFieldExpression(field)
-		resSlice[i] += fmt.Sprintf(" %s", directions[i])
-	}
 	if len(resSlice) == 0 {
 		return ""
 	}
@@ -229,6 +293,9 @@ func (q *Query) insertQuery(data FieldMap) (string, SQLParams) {
 				continue
 			}
 		}
+		if fi.fieldType == fieldtype.JSON {
+			v = jsonSQLValue(fi, v)
+		}
 		cols = append(cols, fi.json)
 		vals = append(vals, v)
 		i++
@@ -264,11 +331,14 @@ func (q *Query) selectQuery(fields []string) (string, SQLParams) {
 	fieldExprs, allExprs := q.selectData(fields)
 	// Build up the query
 	// Fields
-	fieldsSQL := q.fieldsSQL(fieldExprs)
+	fieldsSQL := q.fieldsSQL(fieldExprs) + q.sortKeysSQL()
+	ranksSQL, args := q.ranksSQL()
+	fieldsSQL += ranksSQL
 	// Tables
 	tablesSQL, joinsMap := q.tablesSQL(allExprs)
 	// Where clause and args
-	whereSQL, args := q.sqlWhereClause()
+	whereSQL, whereArgs := q.sqlWhereClause()
+	args = append(args, whereArgs...)
 	orderSQL := q.sqlOrderByClause()
 	limitSQL := q.sqlLimitOffsetClause()
 	var distinct string
@@ -293,6 +363,9 @@ func (q *Query) selectGroupQuery(fields map[string]string) (string, SQLParams) {
 	if len(q.groups) == 0 {
 		log.Panic("Calling selectGroupQuery on a query without Group By clause")
 	}
+	if len(q.ranks) > 0 {
+		log.Panic("Group By queries cannot be ordered by rank")
+	}
 	fieldsList := make([]string, len(fields))
 	i := 0
 	for f := range fields {
@@ -302,7 +375,7 @@ func (q *Query) selectGroupQuery(fields map[string]string) (string, SQLParams) {
 	fieldExprs, allExprs := q.selectData(fieldsList)
 	// Build up the query
 	// Fields
-	fieldsSQL := q.fieldsGroupSQL(fieldExprs, fields)
+	fieldsSQL := q.fieldsGroupSQL(fieldExprs, fields) + q.sortKeysSQL()
 	// Tables
 	tablesSQL, joinsMap := q.tablesSQL(allExprs)
 	// Where clause and args
@@ -330,6 +403,8 @@ func (q *Query) selectData(fields []string) ([][]string, [][]string) {
 	fieldExprs = append(fieldExprs, q.getOrderByExpressions()...)
 	// Then given by condition
 	allExprs := append(fieldExprs, q.cond.getAllExpressions(q.recordSet.model)...)
+	// And by ranks
+	allExprs = append(allExprs, q.getRankExpressions()...)
 	return fieldExprs, allExprs
 }
 
@@ -354,6 +429,9 @@ func (q *Query) updateQuery(data FieldMap) (string, SQLParams) {
 	)
 	for k, v := range data {
 		fi := q.recordSet.model.fields.MustGet(k)
+		if fi.fieldType == fieldtype.JSON {
+			v = jsonSQLValue(fi, v)
+		}
 		cols[i] = fmt.Sprintf("%s = ?", fi.json)
 		vals[i] = v
 		i++
@@ -562,7 +640,7 @@ func (q *Query) sideDataIsEmpty() bool {
 func (q *Query) substituteConditionExprs(substMap map[string][]string) {
 	q.cond.substituteExprs(q.recordSet.model, substMap)
 	for i, order := range q.orders {
-		orderPath := strings.Split(strings.TrimSpace(order), " ")[0]
+		orderPath := parseOrder(order).path
 		jsonPath := jsonizePath(q.recordSet.model, orderPath)
 		for k, v := range substMap {
 			if jsonPath == k {
@@ -589,13 +667,48 @@ func (q *Query) getAllExpressions() [][]string {
 func (q *Query) getOrderByExpressions() [][]string {
 	var exprs [][]string
 	for _, order := range q.orders {
-		orderField := strings.Split(strings.TrimSpace(order), " ")[0]
-		oExprs := jsonizeExpr(q.recordSet.model, strings.Split(orderField, ExprSep))
-		exprs = append(exprs, oExprs)
+		exprs = append(exprs, q.orderFieldExprs(parseOrder(order).path)...)
 	}
 	return exprs
 }
 
+// orderByExpression returns the SQL expression by which records are ordered
+// for the given field expression, taking into account the collation and the
+// accents settings of the field and of this Query. The second returned value
+// is false if records are ordered by the column itself.
+func (q *Query) orderByExpression(exprs []string) (string, bool) {
+	field := q.joinedFieldExpression(exprs)
+	fi := q.recordSet.model.getRelatedFieldInfo(strings.Join(exprs, ExprSep))
+	if fi.fieldType != fieldtype.Char && fi.fieldType != fieldtype.Text {
+		return field, false
+	}
+	adapter := adapters[db.DriverName()]
+	res := field
+	if fi.unaccent {
+		res = adapter.unaccentSQL(res)
+	}
+	collation := fi.collation
+	if q.collation != "" {
+		collation = q.collation
+	}
+	if collation != "" {
+		res = adapter.collateSQL(res, collation)
+	}
+	return res, res != field
+}
+
+// sortKeysSQL returns the SQL string to add to the selected fields for the
+// ORDER BY expressions of this Query that are not columns themselves.
+func (q *Query) sortKeysSQL() string {
+	var res string
+	for i, exprs := range q.getOrderByExpressions() {
+		if sortKey, ok := q.orderByExpression(exprs); ok {
+			res += fmt.Sprintf(", %s AS %s%d", sortKey, sortKeyPrefix, i)
+		}
+	}
+	return res
+}
+
 // newQuery returns a new empty query
 // If rs is given, bind this query to the given RecordSet.
 func newQuery(rs ...*RecordCollection) *Query {
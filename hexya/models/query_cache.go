@@ -0,0 +1,163 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hexya-erp/hexya/hexya/metrics"
+	"github.com/hexya-erp/hexya/hexya/models/security"
+)
+
+var (
+	queryCacheHits   = metrics.CacheLookups.WithLabelValues("query", "hit")
+	queryCacheMisses = metrics.CacheLookups.WithLabelValues("query", "miss")
+)
+
+// queryCacheVacuumInterval is the minimum interval between
+// two removals of the expired entries of the query cache.
+const queryCacheVacuumInterval = time.Minute
+
+// A queryCacheKey is the key of the ids of a search in the queryCache.
+// Since the query includes the record rules of the user, searches of users
+// with different rules have different keys.
+type queryCacheKey struct {
+	model *Model
+	query string
+	args  string
+}
+
+// A queryCacheEntry is the result of a search in the queryCache
+type queryCacheEntry struct {
+	ids    []int64
+	models map[*Model]bool
+	expiry time.Time
+}
+
+// A queryCache holds the ids returned by searches made with Cached.
+// It is shared by all environments and is safe for concurrent access.
+type queryCache struct {
+	sync.RWMutex
+	data       map[queryCacheKey]queryCacheEntry
+	lastVacuum time.Time
+}
+
+// sharedQueryCache is the queryCache of the application
+var sharedQueryCache = &queryCache{
+	data: make(map[queryCacheKey]queryCacheEntry),
+}
+
+// get returns the ids for the given key and true if
+// they are in the cache and have not expired.
+func (qc *queryCache) get(key queryCacheKey) ([]int64, bool) {
+	qc.RLock()
+	defer qc.RUnlock()
+	entry, ok := qc.data[key]
+	if !ok || time.Now().After(entry.expiry) {
+		queryCacheMisses.Inc()
+		return nil, false
+	}
+	queryCacheHits.Inc()
+	return entry.ids, true
+}
+
+// set stores the given ids for key in the cache for the given ttl. models
+// are the models whose modification invalidates the entry.
+func (qc *queryCache) set(key queryCacheKey, ids []int64, models map[*Model]bool, ttl time.Duration) {
+	qc.Lock()
+	defer qc.Unlock()
+	now := time.Now()
+	if now.Sub(qc.lastVacuum) > queryCacheVacuumInterval {
+		for k, entry := range qc.data {
+			if now.After(entry.expiry) {
+				delete(qc.data, k)
+			}
+		}
+		qc.lastVacuum = now
+	}
+	qc.data[key] = queryCacheEntry{
+		ids:    ids,
+		models: models,
+		expiry: now.Add(ttl),
+	}
+}
+
+// invalidateModel removes from the cache the results of the
+// searches whose result depends on records of the given model.
+func (qc *queryCache) invalidateModel(mi *Model) {
+	qc.Lock()
+	defer qc.Unlock()
+	for key, entry := range qc.data {
+		if entry.models[mi] {
+			delete(qc.data, key)
+		}
+	}
+}
+
+// Cached returns a new RecordSet whose search results are kept in a cache
+// shared by all environments during ttl, so that identical searches of users
+// with the same record rules do not query the database again.
+//
+// Cached results are discarded when records of the model, or of the models
+// the condition or the order go through, are created, updated or deleted by
+// a committed transaction. Searches of an Environment which has modified
+// these models are never cached, so that their uncommitted records are not
+// exposed to other environments.
+//
+// Only the ids are cached: field values are still read from the database.
+func (rc *RecordCollection) Cached(ttl time.Duration) *RecordCollection {
+	rSet := *rc
+	rSet.query = rSet.query.clone()
+	rSet.query.cacheTTL = ttl
+	return &rSet
+}
+
+// fetchCached fetches the ids of this RecordCollection from the query cache,
+// or from the database if they are not in the cache, in which case they are
+// stored in the cache for the TTL given with Cached.
+func (rc *RecordCollection) fetchCached() *RecordCollection {
+	rSetVal := *rc.addRecordRuleConditions(rc.env.uid, security.Read)
+	rSetVal.query = rSetVal.query.clone()
+	rSet := &rSetVal
+	if len(rSet.query.orders) == 0 {
+		rSet.query.orders = make([]string, len(rSet.model.defaultOrder))
+		copy(rSet.query.orders, rSet.model.defaultOrder)
+	}
+	addNameSearchesToCondition(rSet.model, rSet.query.cond)
+	models := rSet.queryModels()
+	for mi := range models {
+		if _, modified := rc.env.invalidations[mi]; modified {
+			return rc.Load("id")
+		}
+	}
+	_, subSet := rSet.substituteRelatedFields([]string{"id"})
+	query, args := subSet.query.selectQuery([]string{"id"})
+	key := queryCacheKey{model: rc.model, query: query, args: fmt.Sprintf("%v", args)}
+	if ids, ok := sharedQueryCache.get(key); ok {
+		res := *rc
+		res.query = rc.query.clone()
+		return res.withIds(ids)
+	}
+	res := rc.Load("id")
+	sharedQueryCache.set(key, append([]int64(nil), res.ids...), models, rc.query.cacheTTL)
+	return res
+}
+
+// queryModels returns the models whose records are read by the query of this
+// RecordCollection, that is its model and the models its condition and its
+// order go through.
+func (rc *RecordCollection) queryModels() map[*Model]bool {
+	res := map[*Model]bool{rc.model: true}
+	paths := rc.query.cond.getAllExpressions(rc.model)
+	paths = append(paths, rc.query.getOrderByExpressions()...)
+	for _, exprs := range paths {
+		for i := 1; i < len(exprs); i++ {
+			res[rc.model.getRelatedModelInfo(strings.Join(exprs[:i], ExprSep))] = true
+		}
+	}
+	return res
+}
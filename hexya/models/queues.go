@@ -0,0 +1,53 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/hexya-erp/hexya/hexya/models/security"
+	"github.com/hexya-erp/hexya/hexya/models/types/dates"
+)
+
+// Names of the queues of background work returned by QueueDepths
+const (
+	QueueCronJobs  = "cron_jobs"
+	QueueDownloads = "downloads"
+	QueueWebhooks  = "webhooks"
+	QueueMails     = "mails"
+)
+
+// QueueDepths returns the number of items waiting to be processed in each
+// queue of background work, that is the due cron jobs, the pending downloads,
+// the due webhook deliveries and the outgoing mails.
+func QueueDepths() (map[string]int, error) {
+	res := make(map[string]int)
+	err := SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+		now := dates.Now()
+		for queue, q := range map[string]struct {
+			model string
+			where string
+			args  []interface{}
+		}{
+			QueueCronJobs:  {model: "CronJob", where: "active = TRUE AND next_call <= ?", args: []interface{}{now}},
+			QueueDownloads: {model: "Download", where: "state = ?", args: []interface{}{downloadPending}},
+			QueueWebhooks: {model: "WebhookDelivery", where: "state = ? AND (next_attempt IS NULL OR next_attempt <= ?)",
+				args: []interface{}{webhookPending, now}},
+			QueueMails: {model: "Mail", where: "state = ? AND (scheduled_date IS NULL OR scheduled_date <= ?)",
+				args: []interface{}{mailOutgoing, now}},
+		} {
+			var count int
+			env.cr.Get(&count, fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE %s",
+				adapters[db.DriverName()].quoteTableName(Registry.MustGet(q.model).tableName), q.where), q.args...)
+			res[queue] = count
+		}
+	})
+	return res, err
+}
+
+// DBStats returns the statistics of the pool of connections to the database
+func DBStats() sql.DBStats {
+	return db.Stats()
+}
@@ -0,0 +1,347 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Built-in quotas
+const (
+	// QuotaUsers is the number of active records of the User model.
+	// It is checked when users are created.
+	QuotaUsers = "users"
+	// QuotaStorage is the size in bytes of the contents stored in the
+	// Filestore. It is checked when new contents are written.
+	QuotaStorage = "storage"
+	// QuotaAPICalls is the number of RPC calls of the current day (UTC).
+	// It is checked by the server for each RPC call.
+	QuotaAPICalls = "api_calls"
+)
+
+// A Quota limits the usage of a resource by the database.
+type Quota struct {
+	// Warning is the usage from which quota hooks are called with a warning.
+	// 0 means no warning.
+	Warning int64
+	// Limit is the maximum usage. Operations that would exceed
+	// it panic. 0 means no limit.
+	Limit int64
+}
+
+// A QuotaEvent is given to quota hooks when the usage
+// of a quota reaches its warning threshold or its limit.
+type QuotaEvent struct {
+	// Name of the quota
+	Name string
+	// Usage of the quota including the checked operation
+	Usage int64
+	// Quota is the warning threshold and limit of the quota
+	Quota Quota
+	// Exceeded is true if the operation would exceed the limit
+	// of the quota. The operation is refused after the hooks are
+	// called.
+	Exceeded bool
+}
+
+// A QuotaHook is a function called with the QuotaEvent of a checked
+// operation. Hooks may refuse operations that are within the limit of
+// the quota by panicking, e.g. to enforce a grace period policy.
+type QuotaHook func(event QuotaEvent)
+
+// A quotaDefinition is a registered quota
+type quotaDefinition struct {
+	quota Quota
+	// usage returns the current usage of a computed quota
+	usage func(env Environment) int64
+	// model is the name of the model whose records are
+	// counted by a record quota
+	model string
+	// counter is true for quotas tracked with a counter
+	counter bool
+	// daily is true for counters that are reset every day
+	daily bool
+}
+
+var (
+	quotas = map[string]*quotaDefinition{
+		QuotaUsers:    {model: "User"},
+		QuotaStorage:  {counter: true},
+		QuotaAPICalls: {counter: true, daily: true},
+	}
+	quotaHooks  []QuotaHook
+	quotasMutex sync.RWMutex
+)
+
+// registerQuota adds the given quota definition to the registry.
+// It panics if a quota with the same name exists.
+func registerQuota(name string, def *quotaDefinition) {
+	quotasMutex.Lock()
+	defer quotasMutex.Unlock()
+	if _, exists := quotas[name]; exists {
+		log.Panic("Quota already registered", "quota", name)
+	}
+	quotas[name] = def
+}
+
+// RegisterQuota registers a quota whose current usage is given by the
+// usage function. Such quotas are only checked when CheckQuota is called.
+func RegisterQuota(name string, usage func(env Environment) int64) {
+	registerQuota(name, &quotaDefinition{usage: usage})
+}
+
+// RegisterRecordQuota registers a quota on the number of records of the given
+// model, counting only active records if the model has an Active field. The
+// quota is checked each time a record of the model is created.
+//
+// The model does not need to exist. The usage of the quota is always 0 if it does not.
+func RegisterRecordQuota(name string, modelName string) {
+	registerQuota(name, &quotaDefinition{model: modelName})
+}
+
+// RegisterCounterQuota registers a quota whose usage is tracked by a counter
+// in the database, which is updated with AddQuotaUsage. If daily is true, the
+// counter is reset every day at midnight UTC.
+func RegisterCounterQuota(name string, daily bool) {
+	registerQuota(name, &quotaDefinition{counter: true, daily: daily})
+}
+
+// RegisterQuotaHook adds the given hook to the functions called when
+// the usage of a quota reaches its warning threshold or its limit.
+func RegisterQuotaHook(hook QuotaHook) {
+	quotasMutex.Lock()
+	defer quotasMutex.Unlock()
+	quotaHooks = append(quotaHooks, hook)
+}
+
+// SetQuota sets the warning threshold and the limit of the given quota.
+// It can be called at any time, e.g. when a customer changes plan.
+func SetQuota(name string, quota Quota) {
+	quotasMutex.Lock()
+	defer quotasMutex.Unlock()
+	mustGetQuotaDefinition(name).quota = quota
+}
+
+// GetQuota returns the warning threshold and the limit of the given quota.
+func GetQuota(name string) Quota {
+	quotasMutex.RLock()
+	defer quotasMutex.RUnlock()
+	return mustGetQuotaDefinition(name).quota
+}
+
+// QuotaNames returns the sorted names of all registered quotas.
+func QuotaNames() []string {
+	quotasMutex.RLock()
+	defer quotasMutex.RUnlock()
+	res := make([]string, 0, len(quotas))
+	for name := range quotas {
+		res = append(res, name)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// getQuotaDefinition returns the definition of the given quota.
+// It panics if the quota is not registered.
+func getQuotaDefinition(name string) *quotaDefinition {
+	quotasMutex.RLock()
+	defer quotasMutex.RUnlock()
+	return mustGetQuotaDefinition(name)
+}
+
+// mustGetQuotaDefinition returns the definition of the given quota.
+// It panics if the quota is not registered.
+//
+// The caller must hold quotasMutex.
+func mustGetQuotaDefinition(name string) *quotaDefinition {
+	def, ok := quotas[name]
+	if !ok {
+		log.Panic("Unknown quota", "quota", name)
+	}
+	return def
+}
+
+// declareQuotaCounterModel creates the QuotaCounter system model
+// which stores the values of the counters of counter quotas.
+func declareQuotaCounterModel() {
+	quotaCounter := createModel("QuotaCounter", SystemModel)
+	quotaCounter.InheritModel(Registry.MustGet("CommonMixin"))
+	quotaCounter.AddFields(map[string]FieldDefinition{
+		"Name": CharField{Required: true},
+		"Period": CharField{Required: true,
+			Help: "Day of the counter for daily counters, empty string otherwise"},
+		"Value": IntegerField{Required: true},
+	})
+	quotaCounter.AddSQLConstraint("unique_counter", "UNIQUE (name, period)",
+		"There can be only one counter per quota and period")
+}
+
+// quotaPeriod returns the period of the current counter of the given quota
+func (def *quotaDefinition) quotaPeriod() string {
+	if !def.daily {
+		return ""
+	}
+	return time.Now().UTC().Format("2006-01-02")
+}
+
+// counterValue returns the current value of the counter of the given quota
+func (def *quotaDefinition) counterValue(name string) int64 {
+	var values []int64
+	dbSelectNoTx(&values, fmt.Sprintf(`SELECT value FROM %s WHERE name = ? AND period = ?`,
+		adapters[db.DriverName()].quoteTableName(Registry.MustGet("QuotaCounter").tableName)), name, def.quotaPeriod())
+	if len(values) == 0 {
+		return 0
+	}
+	return values[0]
+}
+
+// AddQuotaUsage adds delta to the counter of the given counter quota.
+// delta may be negative.
+//
+// Counters are updated outside of any transaction, so that the usage is
+// tracked even if the transaction of the operation is rolled back. It
+// panics if the quota is not a counter quota.
+func AddQuotaUsage(name string, delta int64) {
+	def := getQuotaDefinition(name)
+	if !def.counter {
+		log.Panic("Quota is not tracked by a counter", "quota", name)
+	}
+	query := fmt.Sprintf(`INSERT INTO %s AS qc (name, period, value) VALUES (?, ?, ?)
+		ON CONFLICT (name, period) DO UPDATE SET value = qc.value + EXCLUDED.value`,
+		adapters[db.DriverName()].quoteTableName(Registry.MustGet("QuotaCounter").tableName))
+	dbExecuteNoTx(query, name, def.quotaPeriod(), delta)
+}
+
+// setQuotaUsage sets the counter of the given counter quota to value.
+func setQuotaUsage(name string, value int64) {
+	def := getQuotaDefinition(name)
+	query := fmt.Sprintf(`INSERT INTO %s (name, period, value) VALUES (?, ?, ?)
+		ON CONFLICT (name, period) DO UPDATE SET value = EXCLUDED.value`,
+		adapters[db.DriverName()].quoteTableName(Registry.MustGet("QuotaCounter").tableName))
+	dbExecuteNoTx(query, name, def.quotaPeriod(), value)
+}
+
+// QuotaUsage returns the current usage of the given quota.
+func (env Environment) QuotaUsage(name string) int64 {
+	def := getQuotaDefinition(name)
+	switch {
+	case def.counter:
+		return def.counterValue(name)
+	case def.model != "":
+		model, ok := Registry.Get(def.model)
+		if !ok {
+			return 0
+		}
+		query := fmt.Sprintf(`SELECT COUNT(*) FROM %s`, adapters[db.DriverName()].quoteTableName(model.tableName))
+		if _, ok := model.fields.Get("Active"); ok {
+			query += ` WHERE active = TRUE`
+		}
+		var count int64
+		env.cr.Get(&count, query)
+		return count
+	default:
+		return def.usage(env)
+	}
+}
+
+// CheckQuota checks that the given quota allows an operation that
+// increases its usage by increment.
+//
+// Quota hooks are called if the usage after the operation reaches the
+// warning threshold of the quota or exceeds its limit. This function
+// panics if the limit would be exceeded.
+func (env Environment) CheckQuota(name string, increment int64) {
+	quota := GetQuota(name)
+	if quota.Warning == 0 && quota.Limit == 0 {
+		return
+	}
+	checkQuota(name, quota, env.QuotaUsage(name)+increment)
+}
+
+// CheckCounterQuota is the same as CheckQuota for counter quotas,
+// which do not need an Environment, but returns an error instead of
+// panicking if the limit would be exceeded.
+func CheckCounterQuota(name string, increment int64) (rError error) {
+	def := getQuotaDefinition(name)
+	if !def.counter {
+		log.Panic("Quota is not tracked by a counter", "quota", name)
+	}
+	quota := GetQuota(name)
+	if quota.Warning == 0 && quota.Limit == 0 {
+		return nil
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			rError = fmt.Errorf("%v", r)
+		}
+	}()
+	checkQuota(name, quota, def.counterValue(name)+increment)
+	return nil
+}
+
+// checkQuota calls the quota hooks if the given usage reaches the
+// warning threshold of the given quota or exceeds its limit, and
+// panics in the latter case.
+func checkQuota(name string, quota Quota, usage int64) {
+	exceeded := quota.Limit > 0 && usage > quota.Limit
+	if !exceeded && (quota.Warning == 0 || usage < quota.Warning) {
+		return
+	}
+	event := QuotaEvent{Name: name, Usage: usage, Quota: quota, Exceeded: exceeded}
+	quotasMutex.RLock()
+	hooks := quotaHooks
+	quotasMutex.RUnlock()
+	for _, hook := range hooks {
+		hook(event)
+	}
+	if exceeded {
+		log.Panic("Quota exceeded", "quota", name, "usage", usage, "limit", quota.Limit)
+	}
+	log.Warn("Quota warning threshold reached", "quota", name, "usage", usage, "warning", quota.Warning, "limit", quota.Limit)
+}
+
+// checkRecordQuotas checks the record quotas of the model
+// of this RecordCollection for the creation of a record.
+func (rc *RecordCollection) checkRecordQuotas() {
+	quotasMutex.RLock()
+	var names []string
+	for name, def := range quotas {
+		if def.model == rc.model.name {
+			names = append(names, name)
+		}
+	}
+	quotasMutex.RUnlock()
+	for _, name := range names {
+		rc.env.CheckQuota(name, 1)
+	}
+}
+
+// RecomputeStorageUsage sets the usage of the QuotaStorage quota to the
+// size of all the contents of the Filestore, which must be a SizedFilestore.
+//
+// The usage is otherwise only updated when contents are written and
+// garbage collected, so this function should be called once when quotas
+// are enabled on an existing database.
+func RecomputeStorageUsage() {
+	store, ok := filestore().(SizedFilestore)
+	if !ok {
+		log.Panic("Filestore cannot report the size of its contents", "filestore", fmt.Sprintf("%T", filestore()))
+	}
+	checksums, err := store.Checksums(time.Now().Add(time.Minute))
+	if err != nil {
+		log.Panic("Unable to list filestore contents", "error", err)
+	}
+	var total int64
+	for _, cs := range checksums {
+		size, err := store.Size(cs)
+		if err != nil {
+			log.Panic("Unable to get the size of filestore content", "checksum", cs, "error", err)
+		}
+		total += size
+	}
+	setQuotaUsage(QuotaStorage, total)
+}
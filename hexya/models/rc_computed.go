@@ -15,6 +15,8 @@
 package models
 
 import (
+	"strings"
+
 	"github.com/hexya-erp/hexya/hexya/models/security"
 	"github.com/hexya-erp/hexya/hexya/tools/typesutils"
 )
@@ -28,7 +30,7 @@ import (
 func (rc *RecordCollection) computeFieldValues(params *FieldMap, fields ...string) {
 	rc.EnsureOne()
 	for _, fInfo := range rc.model.fields.getComputedFields(fields...) {
-		if !checkFieldPermission(fInfo, rc.env.uid, security.Read) {
+		if !checkFieldPermission(fInfo, *rc.env, security.Read) {
 			// We do not have the access rights on this field, so we skip it.
 			continue
 		}
@@ -41,15 +43,46 @@ func (rc *RecordCollection) computeFieldValues(params *FieldMap, fields ...strin
 			(*params)[fInfo.json] = rc.env.cache.get(rc.model, rc.Ids()[0], fInfo.name)
 			continue
 		}
+		useSharedCache := fInfo.cachePolicy != nil && !fInfo.isRelationField() && !rc.dependsOnModifiedModels(fInfo)
+		ref := computedCacheRef{model: rc.model, field: fInfo.name, id: rc.Ids()[0], uid: rc.env.uid}
+		if useSharedCache {
+			if val, ok := sharedComputedCache.get(ref); ok {
+				(*params)[fInfo.json] = val
+				rc.env.cache.updateEntry(rc.model, rc.Ids()[0], fInfo.name, val)
+				continue
+			}
+		}
 		newParams := rc.Call(fInfo.compute).(FieldMapper).FieldMap()
 		for k, v := range newParams {
 			key, _ := rc.model.fields.Get(k)
 			(*params)[key.json] = v
 			rc.env.cache.updateEntry(rc.model, rc.Ids()[0], fInfo.name, v)
 		}
+		if useSharedCache {
+			rc.env.computedValues.add(ref, (*params)[fInfo.json], fInfo.cachePolicy)
+		}
 	}
 }
 
+// dependsOnModifiedModels returns true if records of the model of this
+// RecordCollection, or of the models the Depends paths of the given field go
+// through, have been modified in the transaction of its Environment.
+func (rc *RecordCollection) dependsOnModifiedModels(fInfo *Field) bool {
+	if _, modified := rc.env.invalidations[rc.model]; modified {
+		return true
+	}
+	for _, path := range fInfo.depends {
+		exprs := strings.Split(path, ExprSep)
+		for i := 1; i < len(exprs); i++ {
+			mi := rc.model.getRelatedModelInfo(strings.Join(exprs[:i], ExprSep))
+			if _, modified := rc.env.invalidations[mi]; modified {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 // processTriggers execute computed fields recomputation (for stored fields) or
 // invalidation (for non stored fields) based on the data of each fields 'Depends'
 // attribute.
@@ -78,12 +111,17 @@ func (rc *RecordCollection) processTriggers(fMap FieldMap) {
 	// Compute all that must be computed and store the values
 	rc.Fetch()
 	for cData, fNames := range toUpdate {
+		if cData.stored && rc.env.importMode() {
+			rc.env.deferred.computations.add(cData, rc.Ids(), fNames)
+			continue
+		}
 		recs := rc
 		if cData.path != "" {
 			recs = rc.Env().Pool(cData.model.name).Search(rc.Model().Field(cData.path).In(rc.Ids()))
 		}
 		if !cData.stored {
-			// Field is not stored, just invalidating cache
+			// Field is not stored, just invalidating cache. The shared
+			// computed cache is invalidated when the transaction is committed.
 			for _, id := range recs.Ids() {
 				rc.env.cache.removeEntry(recs.model, id, cData.fieldName)
 			}
@@ -0,0 +1,157 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"reflect"
+	"sort"
+
+	"github.com/hexya-erp/hexya/hexya/models/types/dates"
+	"github.com/hexya-erp/hexya/hexya/tools/nbutils"
+)
+
+// diffIgnoredFields are the fields that are not compared by Diff
+// when no fields are given, because they always differ between records.
+var diffIgnoredFields = map[string]bool{
+	"ID":          true,
+	"CreateDate":  true,
+	"CreateUID":   true,
+	"WriteDate":   true,
+	"WriteUID":    true,
+	"LastUpdate":  true,
+	"DisplayName": true,
+}
+
+// A FieldDiff is the difference of the value of a field
+// between a record and another record or a FieldMap.
+type FieldDiff struct {
+	// Field is the name of the field
+	Field string
+	// Old is the value of the field in this record
+	Old interface{}
+	// New is the value of the field in the other record or FieldMap.
+	// Relation fields values are always given as RecordCollection.
+	New interface{}
+}
+
+// Diff returns the fields whose values differ between the record of this
+// RecordCollection and other, which must be either a record of the same
+// model, or a FieldMapper with new values for this record, as given to Write.
+//
+// Only the given fields are compared, in the given order. If no fields are
+// given, all fields of the model are compared when other is a record, and
+// all fields of the FieldMap otherwise, in the alphabetical order of their
+// names. Relation fields are equal if they hold the same records.
+//
+// This method panics if this RecordCollection or other is not a singleton.
+func (rc *RecordCollection) Diff(other interface{}, fields ...FieldNamer) []FieldDiff {
+	rc.EnsureOne()
+	var (
+		otherRec *RecordCollection
+		fMap     FieldMap
+	)
+	switch o := other.(type) {
+	case RecordSet:
+		otherRec = o.Collection()
+		otherRec.EnsureOne()
+		if otherRec.model != rc.model {
+			log.Panic("Diff can only compare records of the same model", "model", rc.model.name, "other", otherRec.model.name)
+		}
+	case FieldMapper:
+		fMap = o.FieldMap()
+	default:
+		log.Panic("Diff compares with a RecordSet or a FieldMapper", "model", rc.model.name, "other", other)
+	}
+	fis := rc.diffFields(fMap, otherRec != nil, fields)
+	var res []FieldDiff
+	for _, fi := range fis {
+		old := rc.Get(fi.name)
+		var newVal interface{}
+		if otherRec != nil {
+			newVal = otherRec.Get(fi.name)
+		} else {
+			newVal = rc.diffValue(fi, fMap.MustGet(fi.name, rc.model))
+		}
+		if diffValuesEqual(old, newVal) {
+			continue
+		}
+		res = append(res, FieldDiff{Field: fi.name, Old: old, New: newVal})
+	}
+	return res
+}
+
+// diffFields returns the fields to compare in Diff. If fields is empty,
+// these are all the fields of the model if allFields is true, or all the
+// fields of fMap otherwise, sorted by name.
+func (rc *RecordCollection) diffFields(fMap FieldMap, allFields bool, fields []FieldNamer) []*Field {
+	var res []*Field
+	if len(fields) > 0 {
+		for _, f := range fields {
+			fi := rc.model.fields.MustGet(string(f.FieldName()))
+			if _, ok := fMap.Get(fi.name, rc.model); !allFields && !ok {
+				continue
+			}
+			res = append(res, fi)
+		}
+		return res
+	}
+	if allFields {
+		for name, fi := range rc.model.fields.registryByName {
+			if !diffIgnoredFields[name] {
+				res = append(res, fi)
+			}
+		}
+	} else {
+		for key := range fMap {
+			res = append(res, rc.model.fields.MustGet(key))
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].name < res[j].name
+	})
+	return res
+}
+
+// diffValue returns the given FieldMap value of the field fi
+// converted to the type returned by Get for this field.
+func (rc *RecordCollection) diffValue(fi *Field, value interface{}) interface{} {
+	if fi.isRelationField() {
+		var ids []int64
+		switch v := value.(type) {
+		case nil, bool:
+		case RecordSet:
+			ids = v.Ids()
+		case []int64:
+			ids = v
+		default:
+			id, err := nbutils.CastToInteger(v)
+			if err != nil {
+				log.Panic("Invalid value for relation field", "model", rc.model.name, "field", fi.name, "value", value)
+			}
+			if id != 0 {
+				ids = []int64{id}
+			}
+		}
+		return rc.env.Pool(fi.relatedModelName).withIds(ids)
+	}
+	fMap := FieldMap{fi.json: value}
+	rc.model.convertValuesToFieldType(&fMap)
+	return fMap[fi.json]
+}
+
+// diffValuesEqual returns true if the given field values are equal
+func diffValuesEqual(old, newVal interface{}) bool {
+	switch o := old.(type) {
+	case RecordSet:
+		n, ok := newVal.(RecordSet)
+		return ok && o.Collection().Equals(n)
+	case dates.DateTime:
+		n, ok := newVal.(dates.DateTime)
+		return ok && o.Equal(n)
+	case dates.Date:
+		n, ok := newVal.(dates.Date)
+		return ok && o.Equal(n)
+	}
+	return reflect.DeepEqual(old, newVal)
+}
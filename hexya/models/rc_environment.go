@@ -29,18 +29,19 @@ func (rc *RecordCollection) WithEnv(env Environment) *RecordCollection {
 // WithContext returns a copy of the current RecordCollection with
 // its context extended by the given key and value.
 func (rc *RecordCollection) WithContext(key string, value interface{}) *RecordCollection {
-	newCtx := rc.env.context.Copy().WithKey(key, value)
-	newEnv := *rc.env
-	newEnv.context = newCtx
-	return rc.WithEnv(newEnv)
+	return rc.WithEnv(rc.env.WithContext(key, value))
 }
 
 // WithNewContext returns a copy of the current RecordCollection with its context
 // replaced by the given one.
 func (rc *RecordCollection) WithNewContext(context *types.Context) *RecordCollection {
-	newEnv := *rc.env
-	newEnv.context = context
-	return rc.WithEnv(newEnv)
+	return rc.WithEnv(rc.env.WithNewContext(context))
+}
+
+// WithCompany returns a copy of the current RecordCollection with
+// the given company as current company.
+func (rc *RecordCollection) WithCompany(company RecordSet) *RecordCollection {
+	return rc.WithEnv(rc.env.WithCompany(company))
 }
 
 // Sudo returns a new RecordCollection with the given userId
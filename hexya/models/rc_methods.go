@@ -15,9 +15,10 @@
 package models
 
 import (
+	"fmt"
 	"reflect"
 
-	"github.com/hexya-erp/hexya/hexya/models/security"
+	"github.com/hexya-erp/hexya/hexya/tracing"
 	"github.com/jtolds/gls"
 )
 
@@ -60,6 +61,12 @@ func (rc *RecordCollection) CallMulti(methName string, args ...interface{}) []in
 
 	var res []interface{}
 	ctxManager.SetValues(gls.Values{"layers": [2]*methodLayer{methLayer, previousLayer}}, func() {
+		_, endSpan := startSpan(rc.model.name+"."+methName,
+			tracing.ModelKey.String(rc.model.name),
+			tracing.MethodKey.String(methName),
+			tracing.SuperKey.Bool(rc.env.super),
+			tracing.RecordsKey.Int(len(rc.ids)))
+		defer endSpan()
 		res = rSet.callMulti(methLayer, args...)
 	})
 	return res
@@ -130,7 +137,7 @@ func (rc *RecordCollection) CheckExecutionPermission(method *Method, dontPanic .
 		// We are calling Super on the same method, so it's ok
 		return true
 	}
-	userGroups := security.Registry.UserGroups(rc.env.uid)
+	userGroups := rc.env.userGroups(rc.env.uid)
 	for group := range userGroups {
 		if method.groups[group] {
 			return true
@@ -149,3 +156,17 @@ func (rc *RecordCollection) CheckExecutionPermission(method *Method, dontPanic .
 	// Unreachable
 	return false
 }
+
+// currentMethod returns the qualified name of the model method being
+// executed, such as "User.Write", or an empty string if there is none.
+func currentMethod() string {
+	layers, ok := ctxManager.GetValue("layers")
+	if !ok {
+		return ""
+	}
+	methLayer := layers.([2]*methodLayer)[0]
+	if methLayer == nil {
+		return ""
+	}
+	return fmt.Sprintf("%s.%s", methLayer.method.model.name, methLayer.method.name)
+}
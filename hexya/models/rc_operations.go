@@ -5,7 +5,6 @@ package models
 
 import (
 	"sort"
-	"strings"
 
 	"github.com/hexya-erp/hexya/hexya/tools/typesutils"
 )
@@ -34,7 +33,7 @@ func (rc *RecordCollection) Union(other RecordSet) *RecordCollection {
 		delete(idMap, id)
 		i++
 	}
-	return newRecordCollection(rc.Env(), rc.ModelName()).withIds(ids)
+	return newRecordCollection(rc.Env(), rc.model).withIds(ids)
 }
 
 // Subtract returns a RecordSet with the Records that are in this
@@ -62,7 +61,7 @@ func (rc *RecordCollection) Subtract(other RecordSet) *RecordCollection {
 		ids[i] = id
 		i++
 	}
-	return newRecordCollection(rc.Env(), rc.ModelName()).withIds(ids)
+	return newRecordCollection(rc.Env(), rc.model).withIds(ids)
 }
 
 // Intersect returns a new RecordCollection with only the records that are both
@@ -91,7 +90,7 @@ func (rc *RecordCollection) Intersect(other RecordSet) *RecordCollection {
 		ids[i] = id
 		i++
 	}
-	return newRecordCollection(rc.Env(), rc.ModelName()).withIds(ids)
+	return newRecordCollection(rc.Env(), rc.model).withIds(ids)
 }
 
 // CartesianProduct returns the cartesian product of this RecordCollection with others.
@@ -143,7 +142,7 @@ func (rc *RecordCollection) Sorted(less func(rs1 RecordSet, rs2 RecordSet) bool)
 	for _, rec := range records {
 		ids = append(ids, rec.ids[0])
 	}
-	return newRecordCollection(rc.Env(), rc.ModelName()).withIds(ids)
+	return newRecordCollection(rc.Env(), rc.model).withIds(ids)
 }
 
 // SortedDefault returns a new record set with the same records as rc but sorted according
@@ -151,9 +150,9 @@ func (rc *RecordCollection) Sorted(less func(rs1 RecordSet, rs2 RecordSet) bool)
 func (rc *RecordCollection) SortedDefault() *RecordCollection {
 	return rc.Sorted(func(rs1 RecordSet, rs2 RecordSet) bool {
 		for _, order := range Registry.MustGet(rs1.ModelName()).defaultOrder {
-			tokens := strings.Split(order+" asc", " ")
-			order = tokens[0]
-			reverse := strings.ToLower(tokens[1]) == "desc"
+			oExpr := parseOrder(order)
+			order = oExpr.path
+			reverse := oExpr.direction == "DESC"
 			if eq, _ := typesutils.AreEqual(rs1.Collection().Get(order), rs2.Collection().Get(order)); eq {
 				continue
 			}
@@ -26,20 +26,28 @@ func (rc *RecordCollection) addRecordRuleConditions(uid int64, perm security.Per
 	// Add global rules
 	for _, rule := range rSet.model.rulesRegistry.globalRules {
 		if perm&rule.Perms > 0 {
-			rSet = rSet.Search(rule.Condition)
+			if cond := rule.conditionFor(rc); cond != nil {
+				rSet = rSet.Search(cond)
+			}
 		}
 	}
 	// Add groups rules
-	userGroups := security.Registry.UserGroups(uid)
+	userGroups := rc.env.userGroups(uid)
 	groupCondition := newCondition()
+	unrestricted := false
 	for group := range userGroups {
 		for _, rule := range rSet.model.rulesRegistry.rulesByGroup[group.Name] {
 			if perm&rule.Perms > 0 {
-				groupCondition = groupCondition.OrCond(rule.Condition)
+				cond := rule.conditionFor(rc)
+				if cond == nil {
+					unrestricted = true
+					continue
+				}
+				groupCondition = groupCondition.OrCond(cond)
 			}
 		}
 	}
-	if !groupCondition.IsEmpty() {
+	if !unrestricted && !groupCondition.IsEmpty() {
 		rSet = rSet.Search(groupCondition)
 	}
 	rSet.filtered = true
@@ -24,6 +24,7 @@ import (
 	"github.com/hexya-erp/hexya/hexya/models/fieldtype"
 	"github.com/hexya-erp/hexya/hexya/models/security"
 	"github.com/hexya-erp/hexya/hexya/models/types/dates"
+	"github.com/hexya-erp/hexya/hexya/tracing"
 	"github.com/jmoiron/sqlx"
 )
 
@@ -72,34 +73,56 @@ func (rc *RecordCollection) Ids() []int64 {
 // This function is private and low level. It should not be called directly.
 // Instead use rs.Call("Create")
 func (rc *RecordCollection) create(data FieldMapper) *RecordCollection {
+	span, endSpan := rc.startOperationSpan("create")
+	defer endSpan()
 	defer func() {
 		if r := recover(); r != nil {
 			panic(rc.substituteSQLErrorMessage(r))
 		}
 	}()
 	rc.CheckExecutionPermission(rc.model.methods.MustGet("Create"))
+	rc.checkRecordQuotas()
 	fMap := data.FieldMap()
-	fMap = filterMapOnAuthorizedFields(rc.model, fMap, rc.env.uid, security.Write)
+	fMap = filterMapOnAuthorizedFields(rc.model, fMap, *rc.env, security.Write)
 	rc.applyDefaults(&fMap, true)
 	rc.addAccessFieldsCreateData(&fMap)
+	rc.storeBinaries(fMap)
 	rc.model.convertValuesToFieldType(&fMap)
+	rc.checkEmbeddedLists(fMap)
+	rc.removeParentPath(fMap)
 	fMap = rc.createEmbeddedRecords(fMap)
 	// clean our fMap from ID and non stored fields
 	fMap.RemovePKIfZero()
+	if _, ok := fMap.Get("ID", rc.model); !ok {
+		if id := rc.model.newID(); id != 0 {
+			fMap["id"] = id
+		}
+	}
 	storedFieldMap := filterMapOnStoredFields(rc.model, fMap)
 	// insert in DB
 	var createdId int64
 	sql, args := rc.query.insertQuery(storedFieldMap)
 	rc.env.cr.Get(&createdId, sql, args...)
+	span.SetAttributes(tracing.RowsKey.Int(1))
 
 	rc.env.cache.addRecord(rc.model, createdId, storedFieldMap)
+	rc.env.cache.invalidateFilteredO2Ms(rc.model)
 	rSet := rc.withIds([]int64{createdId})
+	rSet.writeCompanyValues(fMap)
+	rSet.updateCountersOnCreate(storedFieldMap)
+	rSet.roundMonetaryFields(fMap)
 	// update reverse relation fields
 	rSet.updateRelationFields(fMap)
+	rSet.updateParentPaths()
 	// compute stored fields
 	rSet.processInverseMethods(fMap)
 	rSet.processTriggers(fMap)
+	if rSet.model.isHashChained() {
+		rSet.chainRecordHash()
+	}
 	rSet.checkConstraints()
+	rSet.logAudit(auditCreate, rSet.auditFields(fMap), nil)
+	rSet.fireRecordHooks(recordCreated, fMap)
 	return rSet
 }
 
@@ -155,14 +178,23 @@ func (rc *RecordCollection) createEmbeddedRecords(fMap FieldMap) FieldMap {
 // applyDefaults adds the default value to the given fMap values which
 // are equal to their Go type zero value. If requiredOnly is true, default
 // value is set only if the field is required (and equal to zero value).
+//
+// Defaults set with SetFieldDefault for the current user or company
+// take precedence over the Default functions of the fields.
 func (rc *RecordCollection) applyDefaults(fMap *FieldMap, requiredOnly bool) {
+	userDefaults := rc.userDefaults()
 	for fName, fi := range Registry.MustGet(rc.ModelName()).fields.registryByJSON {
-		if fi.defaultFunc == nil {
+		userDefault, hasUserDefault := userDefaults[fName]
+		if fi.defaultFunc == nil && !hasUserDefault {
 			continue
 		}
 		val := reflect.ValueOf((*fMap)[fName])
 		if !fi.isReadOnly() && (!val.IsValid() || val == reflect.Zero(val.Type())) {
 			if fi.required || !requiredOnly {
+				if hasUserDefault {
+					(*fMap)[fName] = userDefault
+					continue
+				}
 				(*fMap)[fName] = fi.defaultFunc(rc.Env())
 			}
 		}
@@ -204,18 +236,43 @@ func (rc *RecordCollection) addAccessFieldsCreateData(fMap *FieldMap) {
 // This function is private and low level. It should not be called directly.
 // Instead use rs.Call("Write")
 func (rc *RecordCollection) update(data FieldMapper, fieldsToUnset ...FieldNamer) bool {
+	span, endSpan := rc.startOperationSpan("update")
+	defer endSpan()
+	rc.checkMutable("Write")
 	rSet := rc.addRecordRuleConditions(rc.env.uid, security.Write)
 	fMap := data.FieldMap(fieldsToUnset...)
 	rSet.addAccessFieldsUpdateData(&fMap)
 	// We process inverse method before we convert RecordSets to ids
 	rSet.processInverseMethods(fMap)
+	rSet.storeBinaries(fMap)
 	rSet.model.convertValuesToFieldType(&fMap)
+	rSet.checkEmbeddedLists(fMap)
+	parentChanged := rSet.removeParentPath(fMap)
+	if parentChanged {
+		rSet.checkParentLoop(fMap)
+	}
+	if lang := rSet.translationLang(); lang != "" {
+		// Translatable fields are only written in the context language
+		rSet.writeTranslations(fMap, lang)
+	}
 	// clean our fMap from ID and non stored fields
 	fMap.RemovePK()
+	rSet.writeCompanyValues(fMap)
 	storedFieldMap := filterMapOnStoredFields(rSet.model, fMap)
+	counterRefs := rSet.counterRefs(storedFieldMap)
+	auditFields := rSet.auditFields(fMap)
+	auditValues := rSet.auditValues(auditFields)
+	trackedFields := rSet.trackedFields(fMap)
+	trackingValues := rSet.trackingValues(trackedFields)
 	rSet.doUpdate(storedFieldMap)
+	rSet.updateCountersOnWrite(counterRefs)
 	// Let's fetch once for all
 	rSet.Fetch()
+	span.SetAttributes(tracing.RowsKey.Int(len(rSet.ids)))
+	if parentChanged {
+		rSet.updateParentPaths()
+	}
+	rSet.roundMonetaryFields(fMap)
 	// write reverse relation fields
 	rSet.updateRelationFields(fMap)
 	// write related fields
@@ -223,6 +280,9 @@ func (rc *RecordCollection) update(data FieldMapper, fieldsToUnset ...FieldNamer
 	// compute stored fields
 	rSet.processTriggers(fMap)
 	rSet.checkConstraints()
+	rSet.logAudit(auditWrite, auditFields, auditValues)
+	rSet.trackChanges(trackedFields, trackingValues)
+	rSet.fireRecordHooks(recordWritten, fMap)
 	return true
 }
 
@@ -245,7 +305,7 @@ func (rc *RecordCollection) doUpdate(fMap FieldMap) {
 			panic(rc.substituteSQLErrorMessage(r))
 		}
 	}()
-	fMap = filterMapOnAuthorizedFields(rc.model, fMap, rc.env.uid, security.Write)
+	fMap = filterMapOnAuthorizedFields(rc.model, fMap, *rc.env, security.Write)
 	// update DB
 	if len(fMap) > 0 {
 		sql, args := rc.query.updateQuery(fMap)
@@ -259,6 +319,7 @@ func (rc *RecordCollection) doUpdate(fMap FieldMap) {
 			rc.env.cache.updateEntry(rc.model, rec.Ids()[0], k, v)
 		}
 	}
+	rc.env.cache.invalidateFilteredO2Ms(rc.model)
 }
 
 // updateRelationFields updates reverse relations fields of the
@@ -267,7 +328,7 @@ func (rc *RecordCollection) updateRelationFields(fMap FieldMap) {
 	rc.Fetch()
 	for field, value := range fMap {
 		fi := rc.model.getRelatedFieldInfo(field)
-		if !checkFieldPermission(fi, rc.env.uid, security.Write) {
+		if !checkFieldPermission(fi, *rc.env, security.Write) {
 			continue
 		}
 		switch fi.fieldType {
@@ -318,7 +379,7 @@ func (rc *RecordCollection) updateRelatedFields(fMap FieldMap) {
 		if !fi.isRelatedField() {
 			continue
 		}
-		if !checkFieldPermission(fi, rc.env.uid, security.Write) {
+		if !checkFieldPermission(fi, *rc.env, security.Write) {
 			continue
 		}
 
@@ -363,6 +424,11 @@ func (rc *RecordCollection) substituteSQLErrorMessage(r interface{}) interface{}
 			return res
 		}
 	}
+	for constraintName, constraint := range rc.model.uniqueConstraints {
+		if strings.Contains(err.Error(), constraintName) {
+			return adapters[db.DriverName()].substituteErrorMessage(err, constraint.errorString)
+		}
+	}
 	return r
 }
 
@@ -370,18 +436,37 @@ func (rc *RecordCollection) substituteSQLErrorMessage(r interface{}) interface{}
 // This function is private and low level. It should not be called directly.
 // Instead use rs.Unlink() or rs.Call("Unlink")
 func (rc *RecordCollection) unlink() int64 {
+	span, endSpan := rc.startOperationSpan("unlink")
+	defer endSpan()
+	rc.checkMutable("Unlink")
 	rc.CheckExecutionPermission(rc.model.methods.MustGet("Unlink"))
 	rSet := rc.addRecordRuleConditions(rc.env.uid, security.Unlink)
 	ids := rSet.Ids()
 	if rSet.IsEmpty() {
 		return 0
 	}
+	counterRefs := rSet.counterRefs(nil)
+	auditFields := rSet.auditFields(nil)
+	auditValues := rSet.auditValues(auditFields)
+	childIds := rSet.childIds()
 	sql, args := rSet.query.deleteQuery()
 	res := rSet.env.cr.Execute(sql, args...)
 	num, _ := res.RowsAffected()
+	span.SetAttributes(tracing.RowsKey.Int64(num))
+	for fi, refs := range counterRefs {
+		rc.incrementCounters(fi, refs, -1)
+	}
+	rc.deleteTranslations(ids)
+	rc.deleteCompanyValues(ids)
+	// Former children of deleted records are now roots
+	rc.env.Pool(rc.model.name).withIds(childIds).updateParentPaths()
+	deleted := rc.env.Pool(rc.model.name).withIds(ids)
+	deleted.logAudit(auditUnlink, auditFields, auditValues)
+	deleted.fireRecordHooks(recordDeleted, nil)
 	for _, id := range ids {
 		rc.env.cache.invalidateRecord(rc.model, id)
 	}
+	rc.env.cache.invalidateFilteredO2Ms(rc.model)
 	return num
 }
 
@@ -394,6 +479,43 @@ func (rc *RecordCollection) Search(cond *Condition) *RecordCollection {
 	return &rSetVal
 }
 
+// withActiveTest returns the given condition with an additional "Active = true"
+// predicate if the model of this RecordCollection has an Active boolean field.
+//
+// The condition is returned unchanged if it already filters on the Active
+// field or if the context of this RecordCollection has active_test set to false.
+func (rc *RecordCollection) withActiveTest(cond *Condition) *Condition {
+	fi, ok := rc.model.fields.Get("Active")
+	if !ok || fi.fieldType != fieldtype.Boolean {
+		return cond
+	}
+	if !rc.env.context.ActiveTest() {
+		return cond
+	}
+	for _, exprs := range cond.getAllExpressions(rc.model) {
+		if len(exprs) == 1 && exprs[0] == fi.json {
+			return cond
+		}
+	}
+	activeCond := rc.model.Field("Active").Equals(true)
+	if cond.IsEmpty() {
+		return activeCond
+	}
+	return activeCond.AndCond(cond)
+}
+
+// ToggleActive inverts the value of the Active field of each record of this
+// RecordCollection, archiving active records and restoring archived ones.
+// It panics if the model has no Active field.
+func (rc *RecordCollection) ToggleActive() {
+	if _, ok := rc.model.fields.Get("Active"); !ok {
+		log.Panic("ToggleActive called on a model without Active field", "model", rc.model.name)
+	}
+	for _, rec := range rc.Records() {
+		rec.Set("Active", !rec.Get("Active").(bool))
+	}
+}
+
 // NoDistinct removes the DISTINCT keyword from this RecordSet query.
 // By default, all queries are distinct.
 func (rc *RecordCollection) NoDistinct() *RecordCollection {
@@ -419,7 +541,12 @@ func (rc *RecordCollection) Offset(offset int) *RecordCollection {
 	return &rSet
 }
 
-// OrderBy returns a new RecordSet ordered by the given ORDER BY expressions
+// OrderBy returns a new RecordSet ordered by the given ORDER BY expressions.
+//
+// Each expression is a field path optionally followed by "asc" or "desc" and by
+// "nulls first" or "nulls last", such as "Partner.Name desc nulls last". Records
+// ordered by a many2one or one2one field are ordered by the record name fields
+// of the related model.
 func (rc *RecordCollection) OrderBy(exprs ...string) *RecordCollection {
 	rSet := *rc
 	rSet.query = rSet.query.clone()
@@ -427,6 +554,15 @@ func (rc *RecordCollection) OrderBy(exprs ...string) *RecordCollection {
 	return &rSet
 }
 
+// Collate returns a new RecordSet whose char and text fields are ordered
+// with the given database collation, instead of the collation of the fields.
+func (rc *RecordCollection) Collate(collation string) *RecordCollection {
+	rSet := *rc
+	rSet.query = rSet.query.clone()
+	rSet.query.collation = collation
+	return &rSet
+}
+
 // GroupBy returns a new RecordSet grouped with the given GROUP BY expressions
 func (rc *RecordCollection) GroupBy(fields ...FieldNamer) *RecordCollection {
 	rSet := *rc
@@ -453,6 +589,9 @@ func (rc *RecordCollection) Fetch() *RecordCollection {
 		// Call SearchAll instead to load all the records of the table
 		return rc
 	}
+	if rc.query.cacheTTL > 0 {
+		return rc.fetchCached()
+	}
 	return rc.Load("id")
 }
 
@@ -476,6 +615,33 @@ func (rc *RecordCollection) SearchCount() int {
 	return res
 }
 
+// SearchRead searches the records matching the given condition in this RecordCollection
+// and reads the given fields in a single call. It is meant to be used by paginated
+// list views.
+//
+// Only the records from offset and up to limit are read (0 meaning no limit),
+// but the returned SearchReadResult also holds the total number of records
+// matching cond. order is a comma separated list of ORDER BY expressions,
+// such as "Name desc, ID". The model's default order is used if it is empty.
+func (rc *RecordCollection) SearchRead(cond *Condition, fields []string, offset, limit int, order string) SearchReadResult {
+	rSet := rc
+	switch {
+	case !cond.IsEmpty():
+		rSet = rSet.Search(cond)
+	case rSet.query.isEmpty():
+		rSet = rSet.SearchAll()
+	}
+	length := rSet.SearchCount()
+	if order != "" {
+		rSet = rSet.OrderBy(strings.Split(order, ",")...)
+	}
+	records := rSet.Offset(offset).Limit(limit).Read(fields...)
+	return SearchReadResult{
+		Records: records,
+		Length:  length,
+	}
+}
+
 // Load query all data of the RecordCollection and store in cache.
 // fields are the fields to retrieve in the path format,
 // i.e. "User.Profile.Age" or "user_id.profile_id.age".
@@ -492,6 +658,8 @@ func (rc *RecordCollection) Load(fields ...string) *RecordCollection {
 	if len(rc.query.groups) > 0 {
 		log.Panic("Trying to load a grouped query", "model", rc.model, "groups", rc.query.groups)
 	}
+	span, endSpan := rc.startOperationSpan("load")
+	defer endSpan()
 	rSet := rc
 	var prefetch bool
 	if !rc.prefetchRC.IsEmpty() && len(rc.ids) > 0 {
@@ -504,29 +672,32 @@ func (rc *RecordCollection) Load(fields ...string) *RecordCollection {
 		rSet.query.orders = make([]string, len(rSet.model.defaultOrder))
 		copy(rSet.query.orders, rSet.model.defaultOrder)
 	}
-	var results []FieldMap
 	if len(fields) == 0 {
 		fields = rSet.model.fields.storedFieldNames()
 	}
-	fields = filterOnAuthorizedFields(rSet.model, rSet.env.uid, fields, security.Read)
+	fields = filterOnAuthorizedFields(rSet.model, *rSet.env, fields, security.Read)
 	addNameSearchesToCondition(rSet.model, rSet.query.cond)
 	subFields, rSet := rSet.substituteRelatedFields(fields)
 	dbFields := filterOnDBFields(rSet.model, subFields)
 	sql, args := rSet.query.selectQuery(dbFields)
-	rows := dbQuery(rSet.env.cr.tx, sql, args...)
+	rows := rSet.env.cr.query(sql, args...)
 	defer rows.Close()
-	var ids []int64
+	scanner, err := rSet.model.newRowScanner(rows)
+	if err != nil {
+		log.Panic(err.Error(), "model", rSet.ModelName(), "fields", fields)
+	}
+	ids := make([]int64, 0, len(rSet.ids))
 	for rows.Next() {
-		line := make(FieldMap)
-		err := rSet.model.scanToFieldMap(rows, &line)
+		line, err := scanner.scan(rows)
 		if err != nil {
 			log.Panic(err.Error(), "model", rSet.ModelName(), "fields", fields)
 		}
-		results = append(results, line)
-		rSet.env.cache.addRecord(rSet.model, line["id"].(int64), line)
-		ids = append(ids, line["id"].(int64))
+		id := line["id"].(int64)
+		rSet.env.cache.addRecord(rSet.model, id, line)
+		ids = append(ids, id)
 	}
 
+	span.SetAttributes(tracing.RowsKey.Int(len(ids)))
 	rSet = rSet.withIds(ids)
 	rSet.loadRelationFields(fields)
 	if prefetch {
@@ -544,7 +715,11 @@ func (rc *RecordCollection) loadRelationFields(fields []string) {
 			fi := rc.model.getRelatedFieldInfo(fieldName)
 			switch fi.fieldType {
 			case fieldtype.One2Many:
-				relRC := rc.env.Pool(fi.relatedModelName).Search(rc.Model().Field(fi.reverseFK).Equals(id)).Fetch()
+				cond := fi.relatedModel.Field(fi.reverseFK).Equals(id)
+				if fi.filter != nil {
+					cond = cond.AndCond(fi.filter)
+				}
+				relRC := rc.env.Pool(fi.relatedModelName).Search(cond).Fetch()
 				rc.env.cache.updateEntry(rc.model, id, fieldName, relRC.ids)
 			case fieldtype.Many2Many:
 				query := fmt.Sprintf(`SELECT %s FROM %s WHERE %s = ?`, fi.m2mTheirField.json,
@@ -575,7 +750,7 @@ func (rc *RecordCollection) Get(fieldName string) interface{} {
 	var res interface{}
 
 	switch {
-	case !checkFieldPermission(fi, rc.env.uid, security.Read):
+	case !checkFieldPermission(fi, *rc.env, security.Read):
 		res = nil
 	case rc.IsEmpty():
 		res = reflect.Zero(fi.structField.Type).Interface()
@@ -585,11 +760,22 @@ func (rc *RecordCollection) Get(fieldName string) interface{} {
 		res = fMap[fi.json]
 	case fi.isRelatedField() && !fi.isStored():
 		res, _ = rc.get(fi.relatedPath, false)
+	case fi.companyDependent:
+		res = rc.getCompanyValue(fi)
 	default:
 		// If value is not in cache we fetch the whole model to speed up later calls to Get,
 		// except for the case of non stored relation fields, where we only load the requested field.
 		all := !fi.fieldType.IsNonStoredRelationType()
 		res, _ = rc.get(fieldName, all)
+		if lang := rc.translationLang(); lang != "" && fi.isTranslatable() {
+			if tr, ok := rc.getTranslation(fi, lang); ok {
+				res = tr
+			}
+		}
+		if fi.attachment {
+			// Only the checksum of attachment contents is cached
+			res = rc.binaryContent(fi, res)
+		}
 	}
 
 	if res == nil {
@@ -602,19 +788,76 @@ func (rc *RecordCollection) Get(fieldName string) interface{} {
 		switch r := res.(type) {
 		case *interface{}:
 			// *interface{} is returned when the field is null
-			res = newRecordCollection(rc.Env(), fi.relatedModel.name)
+			res = newRecordCollection(rc.Env(), fi.relatedModel)
 		case int64:
-			res = newRecordCollection(rc.Env(), fi.relatedModel.name)
+			res = newRecordCollection(rc.Env(), fi.relatedModel)
 			if r != 0 {
 				res = res.(RecordSet).Collection().withIds([]int64{r})
 			}
 		case []int64:
-			res = newRecordCollection(rc.Env(), fi.relatedModel.name).withIds(r).SortedDefault()
+			res = newRecordCollection(rc.Env(), fi.relatedModel).withIds(r).SortedDefault()
 		}
 	}
+	if dt, ok := res.(dates.DateTime); ok {
+		// DateTime values are stored in UTC and given in the time zone of the context
+		res = dt.In(rc.env.context.TZ())
+	}
 	return res
 }
 
+// Read loads the given fields of this RecordCollection and returns their values
+// as a slice of serializable FieldMaps, one for each record. The "id" field is
+// always included.
+//
+// fields can be given in the path format, i.e. "Profile.Age", in which case the
+// value of the related field is returned under the path key. If no fields are
+// given, all DB columns of the RecordCollection's model are read.
+//
+// Relation fields values are rendered as follows:
+// - Many2one, One2one and Rev2one: an [id, display name] pair or false if empty
+// - One2many and Many2many: a slice of ids
+func (rc *RecordCollection) Read(fields ...string) []FieldMap {
+	if len(fields) == 0 {
+		fields = rc.model.fields.storedFieldNames()
+	}
+	fields = addIDIfNotPresent(fields)
+	rSet := rc.Load(fields...)
+	res := make([]FieldMap, rSet.Len())
+	for i, rec := range rSet.Records() {
+		fMap := make(FieldMap)
+		for _, fName := range fields {
+			fMap[fName] = rec.readValue(fName)
+		}
+		res[i] = fMap
+	}
+	return res
+}
+
+// readValue returns the serializable value of the given field path
+// for the first record of this RecordCollection.
+func (rc *RecordCollection) readValue(path string) interface{} {
+	exprs := strings.SplitN(path, ExprSep, 2)
+	fi := rc.model.fields.MustGet(exprs[0])
+	val := rc.Get(exprs[0])
+	if len(exprs) > 1 {
+		if !fi.isRelationField() {
+			log.Panic("Field is not a relation in model", "field", exprs[0], "model", rc.model.name)
+		}
+		return val.(RecordSet).Collection().readValue(exprs[1])
+	}
+	switch {
+	case fi.fieldType.Is2OneRelationType():
+		relRC := val.(RecordSet).Collection()
+		if relRC.IsEmpty() {
+			return false
+		}
+		return []interface{}{relRC.ids[0], relRC.Call("NameGet")}
+	case fi.fieldType.Is2ManyRelationType():
+		return val.(RecordSet).Collection().Ids()
+	}
+	return val
+}
+
 // get returns the value of field for this RecordSet.
 // It loads the cache if necessary before reading.
 // If all is true, all fields of the model are loaded, otherwise only field.
@@ -668,7 +911,7 @@ func (rc *RecordCollection) First(structPtr interface{}) {
 	}
 	rc.Load(fields...)
 	fMap := rc.env.cache.getRecord(rc.Model(), rc.ids[0])
-	fMap = filterMapOnAuthorizedFields(rc.model, fMap, rc.env.uid, security.Read)
+	fMap = filterMapOnAuthorizedFields(rc.model, fMap, *rc.env, security.Read)
 	MapToStruct(rc, structPtr, fMap)
 }
 
@@ -688,7 +931,7 @@ func (rc *RecordCollection) All(structSlicePtr interface{}) {
 	rc.Load()
 	for i := 0; i < rc.Len(); i++ {
 		fMap := rc.env.cache.getRecord(rc.Model(), recs[i].ids[0])
-		fMap = filterMapOnAuthorizedFields(rc.model, fMap, rc.env.uid, security.Read)
+		fMap = filterMapOnAuthorizedFields(rc.model, fMap, *rc.env, security.Read)
 		newStructPtr := reflect.New(structType).Interface()
 		MapToStruct(rc, newStructPtr, fMap)
 		val.Elem().Index(i).Set(reflect.ValueOf(newStructPtr))
@@ -701,7 +944,7 @@ func (rc *RecordCollection) Aggregates(fieldNames ...FieldNamer) []GroupAggregat
 		log.Panic("Trying to get aggregates of a non-grouped query", "model", rc.model)
 	}
 	rSet := rc.addRecordRuleConditions(rc.env.uid, security.Read)
-	fields := filterOnAuthorizedFields(rSet.model, rSet.env.uid, convertToStringSlice(fieldNames), security.Read)
+	fields := filterOnAuthorizedFields(rSet.model, *rSet.env, convertToStringSlice(fieldNames), security.Read)
 	subFields, rSet := rSet.substituteRelatedFields(fields)
 	dbFields := filterOnDBFields(rSet.model, subFields, true)
 
@@ -711,7 +954,7 @@ func (rc *RecordCollection) Aggregates(fieldNames ...FieldNamer) []GroupAggregat
 	fieldsOperatorMap := rSet.fieldsGroupOperators(dbFields)
 	sql, args := rSet.query.selectGroupQuery(fieldsOperatorMap)
 	var res []GroupAggregateRow
-	rows := dbQuery(rSet.env.cr.tx, sql, args...)
+	rows := rSet.env.cr.query(sql, args...)
 	defer rows.Close()
 
 	for rows.Next() {
@@ -722,6 +965,12 @@ func (rc *RecordCollection) Aggregates(fieldNames ...FieldNamer) []GroupAggregat
 		}
 		cnt := vals["__count"].(int64)
 		delete(vals, "__count")
+		for key := range vals {
+			if strings.HasPrefix(key, sortKeyPrefix) {
+				delete(vals, key)
+			}
+		}
+		rSet.roundAggregates(vals)
 		line := GroupAggregateRow{
 			Values:    vals,
 			Count:     int(cnt),
@@ -747,7 +996,7 @@ func (rc *RecordCollection) fieldsGroupOperators(fields []string) map[string]str
 			continue
 		}
 		fi := rc.model.getRelatedFieldInfo(dbf)
-		if fi.fieldType != fieldtype.Float && fi.fieldType != fieldtype.Integer {
+		if fi.fieldType != fieldtype.Float && fi.fieldType != fieldtype.Monetary && fi.fieldType != fieldtype.Integer {
 			continue
 		}
 		res[dbf] = fi.groupOperator
@@ -765,7 +1014,7 @@ func (rc *RecordCollection) Condition() *Condition {
 func (rc *RecordCollection) Records() []*RecordCollection {
 	res := make([]*RecordCollection, rc.Len())
 	for i, id := range rc.Ids() {
-		newRC := newRecordCollection(rc.Env(), rc.ModelName())
+		newRC := newRecordCollection(rc.Env(), rc.model)
 		res[i] = newRC.withIds([]int64{id})
 		res[i].prefetchRC = rc
 	}
@@ -847,7 +1096,7 @@ func (rc *RecordCollection) withIds(ids []int64) *RecordCollection {
 // The given src will be passed to fmt.Sprintf with the optional args
 // before being returned.
 func (rc *RecordCollection) T(src string, args ...interface{}) string {
-	lang := rc.Env().Context().GetString("lang")
+	lang := rc.Env().Context().Lang()
 	transCode := i18n.TranslateCode(lang, "", src)
 	return fmt.Sprintf(transCode, args...)
 }
@@ -861,9 +1110,8 @@ func (rc *RecordCollection) Collection() *RecordCollection {
 var _ RecordSet = new(RecordCollection)
 
 // newRecordCollection returns a new empty RecordCollection in the
-// given environment for the given modelName
-func newRecordCollection(env Environment, modelName string) *RecordCollection {
-	mi := Registry.MustGet(modelName)
+// given environment for the given model
+func newRecordCollection(env Environment, mi *Model) *RecordCollection {
 	rc := RecordCollection{
 		model: mi,
 		query: newQuery(),
@@ -38,18 +38,24 @@ type Option int
 
 type modelCollection struct {
 	sync.RWMutex
-	bootstrapped        bool
-	registryByName      map[string]*Model
-	registryByTableName map[string]*Model
-	sequences           map[string]*Sequence
+	bootstrapped           bool
+	registryByName         map[string]*Model
+	registryByTableName    map[string]*Model
+	registryByPreviousName map[string]*Model
+	registryByIndex        []*Model
+	sequences              map[string]*Sequence
 }
 
-// Get the given Model by name or by table name
+// Get the given Model by name or by table name,
+// or by a previous name of a renamed model
 func (mc *modelCollection) Get(nameOrJSON string) (mi *Model, ok bool) {
 	mi, ok = mc.registryByName[nameOrJSON]
 	if !ok {
 		mi, ok = mc.registryByTableName[nameOrJSON]
 	}
+	if !ok {
+		mi, ok = mc.registryByPreviousName[nameOrJSON]
+	}
 	return
 }
 
@@ -63,6 +69,12 @@ func (mc *modelCollection) MustGet(nameOrJSON string) *Model {
 	return mi
 }
 
+// getByIndex returns the Model with the given index in the registry.
+// Indexes are assigned in the order in which models are declared.
+func (mc *modelCollection) getByIndex(index int) *Model {
+	return mc.registryByIndex[index]
+}
+
 // GetSequence the given Sequence by name or by db name
 func (mc *modelCollection) GetSequence(nameOrJSON string) (s *Sequence, ok bool) {
 	s, ok = mc.sequences[nameOrJSON]
@@ -87,8 +99,10 @@ func (mc *modelCollection) add(mi *Model) {
 	if _, exists := mc.Get(mi.name); exists {
 		log.Panic("Trying to add already existing model", "model", mi.name)
 	}
+	mi.index = len(mc.registryByIndex)
 	mc.registryByName[mi.name] = mi
 	mc.registryByTableName[mi.tableName] = mi
+	mc.registryByIndex = append(mc.registryByIndex, mi)
 	mi.methods.model = mi
 	mi.fields.model = mi
 }
@@ -96,26 +110,35 @@ func (mc *modelCollection) add(mi *Model) {
 // newModelCollection returns a pointer to a new modelCollection
 func newModelCollection() *modelCollection {
 	return &modelCollection{
-		registryByName:      make(map[string]*Model),
-		registryByTableName: make(map[string]*Model),
-		sequences:           make(map[string]*Sequence),
+		registryByName:         make(map[string]*Model),
+		registryByTableName:    make(map[string]*Model),
+		registryByPreviousName: make(map[string]*Model),
+		sequences:              make(map[string]*Sequence),
 	}
 }
 
 // A Model is the definition of a business object (e.g. a partner, a sale order, etc.)
 // including fields and methods.
 type Model struct {
-	name           string
-	options        Option
-	acl            *security.AccessControlList
-	rulesRegistry  *recordRuleRegistry
-	tableName      string
-	fields         *FieldsCollection
-	methods        *MethodsCollection
-	mixins         []*Model
-	sqlConstraints map[string]sqlConstraint
-	sqlErrors      map[string]string
-	defaultOrder   []string
+	name              string
+	index             int
+	options           Option
+	acl               *security.AccessControlList
+	rulesRegistry     *recordRuleRegistry
+	tableName         string
+	fields            *FieldsCollection
+	methods           *MethodsCollection
+	mixins            []*Model
+	sqlConstraints    map[string]sqlConstraint
+	uniqueConstraints map[string]uniqueConstraint
+	indexes           map[string]modelIndex
+	sqlErrors         map[string]string
+	defaultOrder      []string
+	recNameFields     []string
+	idGenerator       string
+	approvalRules     []ApprovalRule
+	filteredO2Ms      []*Field
+	previousNames     []string
 }
 
 // An sqlConstraint holds the data needed to create a table constraint in the database
@@ -125,6 +148,15 @@ type sqlConstraint struct {
 	errorString string
 }
 
+// A uniqueConstraint holds the data needed to create a partial
+// unique index in the database
+type uniqueConstraint struct {
+	name        string
+	fields      []FieldNamer
+	where       string
+	errorString string
+}
+
 // getRelatedModelInfo returns the Model of the related model when
 // following path.
 // - If skipLast is true, getRelatedModelInfo does not follow the last part of the path
@@ -170,88 +202,128 @@ func (m *Model) getRelatedFieldInfo(path string) *Field {
 	return fi
 }
 
-// scanToFieldMap scans the db query result r into the given FieldMap.
+// scannerType is the reflect.Type of the sql.Scanner interface
+var scannerType = reflect.TypeOf((*sql.Scanner)(nil)).Elem()
+
+// A rowScanner scans the rows of a db query result into FieldMaps.
 // Unlike slqx.MapScan, the returned interface{} values are of the type
 // of the Model fields instead of the database types.
-func (m *Model) scanToFieldMap(r sqlx.ColScanner, dest *FieldMap) error {
+//
+// The keys and fields of the columns are computed once for all the rows
+// and the scan buffer is reused, so that scanning a row only allocates
+// the resulting FieldMap and its values.
+type rowScanner struct {
+	model    *Model
+	keys     []string
+	fields   []*Field
+	values   []interface{}
+	dbValues []interface{}
+}
+
+// newRowScanner returns a rowScanner for the columns of r.
+func (m *Model) newRowScanner(r sqlx.ColScanner) (*rowScanner, error) {
 	columns, err := r.Columns()
 	if err != nil {
-		return err
+		return nil, err
 	}
-
-	// Step 1: We create a []interface{} which is in fact a []*interface{}
-	// and we scan our DB row into it. This enables us to Get null values
-	// without panic, since null values will map to nil.
-	dbValues := make([]interface{}, len(columns))
-	for i := range dbValues {
-		dbValues[i] = new(interface{})
+	rs := rowScanner{
+		model:    m,
+		keys:     make([]string, len(columns)),
+		fields:   make([]*Field, len(columns)),
+		values:   make([]interface{}, len(columns)),
+		dbValues: make([]interface{}, len(columns)),
 	}
-
-	err = r.Scan(dbValues...)
-	if err != nil {
-		return err
+	for i, col := range columns {
+		// We scan into *interface{} so that null values map to nil without panic
+		rs.dbValues[i] = &rs.values[i]
+		if strings.HasPrefix(col, sortKeyPrefix) {
+			continue
+		}
+		key := strings.Replace(col, sqlSep, ExprSep, -1)
+		rs.fields[i] = m.getRelatedFieldInfo(key)
+		if key == rs.fields[i].json {
+			// Share the field's string so that all loaded FieldMaps use the same keys
+			key = rs.fields[i].json
+		}
+		rs.keys[i] = key
 	}
+	return &rs, nil
+}
 
-	// Step 2: We populate our FieldMap with these values
-	for i, dbValue := range dbValues {
-		colName := strings.Replace(columns[i], sqlSep, ExprSep, -1)
-		dbVal := reflect.ValueOf(dbValue).Elem().Interface()
-		(*dest)[colName] = dbVal
+// scan scans the current row of r into a new FieldMap, with values
+// converted to the type of the corresponding Field.
+func (rs *rowScanner) scan(r sqlx.ColScanner) (FieldMap, error) {
+	for i := range rs.values {
+		rs.values[i] = nil
 	}
-
-	// Step 3: We convert values with the type of the corresponding Field
-	// if the value is not nil.
-	m.convertValuesToFieldType(dest)
-	return r.Err()
+	if err := r.Scan(rs.dbValues...); err != nil {
+		return nil, err
+	}
+	res := make(FieldMap, len(rs.keys))
+	for i, key := range rs.keys {
+		if rs.fields[i] == nil {
+			continue
+		}
+		res[key] = rs.model.convertValueToFieldType(rs.fields[i], key, rs.values[i])
+	}
+	return res, r.Err()
 }
 
 // convertValuesToFieldType converts all values of the given FieldMap to
 // their type in the Model.
 func (m *Model) convertValuesToFieldType(fMap *FieldMap) {
-	destVals := reflect.ValueOf(fMap).Elem()
 	for colName, fMapValue := range *fMap {
-		if val, ok := fMapValue.(bool); ok && !val {
-			// Hack to manage client returning false instead of nil
-			fMapValue = nil
+		(*fMap)[colName] = m.convertValueToFieldType(m.getRelatedFieldInfo(colName), colName, fMapValue)
+	}
+}
+
+// convertValueToFieldType returns the given value of the given field converted
+// to the type of the field. colName is the key of the field in its FieldMap.
+func (m *Model) convertValueToFieldType(fi *Field, colName string, fMapValue interface{}) interface{} {
+	if val, ok := fMapValue.(bool); ok && !val {
+		// Hack to manage client returning false instead of nil
+		fMapValue = nil
+	}
+	fType := fi.structField.Type
+	if fType == reflect.TypeOf(fMapValue) {
+		// If we already have the good type, don't do anything
+		return fMapValue
+	}
+	switch {
+	case fMapValue == nil:
+		// dbValue is null, we put the type zero value instead
+		// except if we have a nullable FK relation field
+		if fi.fieldType.IsFKRelationType() && !fi.required {
+			return (*interface{})(nil)
 		}
-		fi := m.getRelatedFieldInfo(colName)
-		fType := fi.structField.Type
-		if fType == reflect.TypeOf(fMapValue) {
-			// If we already have the good type, don't do anything
-			continue
+		return reflect.Zero(fType).Interface()
+	case fi.fieldType == fieldtype.JSON:
+		val, err := getJSONValue(fMapValue, fType)
+		if err != nil {
+			log.Panic("Unable to convert JSON value", "model", m.name, "field", colName, "type", fType, "error", err)
 		}
-		var val reflect.Value
-		switch {
-		case fMapValue == nil:
-			// dbValue is null, we put the type zero value instead
-			// except if we have a nullable FK relation field
-			if fi.fieldType.IsFKRelationType() && !fi.required {
-				val = reflect.ValueOf((*interface{})(nil))
-			} else {
-				val = reflect.Zero(fType)
-			}
-		case reflect.PtrTo(fType).Implements(reflect.TypeOf((*sql.Scanner)(nil)).Elem()):
-			// the type implements sql.Scanner, so we call Scan
-			valPtr := reflect.New(fType)
-			scanFunc := valPtr.MethodByName("Scan")
-			inArgs := []reflect.Value{reflect.ValueOf(fMapValue)}
-			res := scanFunc.Call(inArgs)
-			if res[0].Interface() != nil {
-				log.Panic("Unable to scan into target Type", "error", res[0].Interface())
-			}
-			val = valPtr.Elem()
-		default:
-			var err error
-			if fi.isRelationField() {
-				val, err = getRelationFieldValue(fMapValue, fType)
-			} else {
-				val, err = getSimpleTypeValue(fMapValue, fType)
-			}
-			if err != nil {
-				log.Panic(err.Error(), "model", m.name, "field", colName, "type", fType, "value", fMapValue)
-			}
+		return val.Interface()
+	case reflect.PtrTo(fType).Implements(scannerType):
+		// the type implements sql.Scanner, so we call Scan
+		valPtr := reflect.New(fType)
+		if err := valPtr.Interface().(sql.Scanner).Scan(fMapValue); err != nil {
+			log.Panic("Unable to scan into target Type", "error", err)
 		}
-		destVals.SetMapIndex(reflect.ValueOf(colName), val)
+		return valPtr.Elem().Interface()
+	default:
+		var (
+			val reflect.Value
+			err error
+		)
+		if fi.isRelationField() {
+			val, err = getRelationFieldValue(fMapValue, fType)
+		} else {
+			val, err = getSimpleTypeValue(fMapValue, fType)
+		}
+		if err != nil {
+			log.Panic(err.Error(), "model", m.name, "field", colName, "type", fType, "value", fMapValue)
+		}
+		return val.Interface()
 	}
 }
 
@@ -347,6 +419,41 @@ func (m *Model) isSystem() bool {
 	return false
 }
 
+// isImmutable returns true if the records of this model
+// cannot be modified nor deleted once created.
+func (m *Model) isImmutable() bool {
+	if m.options&ImmutableModel > 0 {
+		return true
+	}
+	return false
+}
+
+// isHashChained returns true if the records of this model are hash chained.
+func (m *Model) isHashChained() bool {
+	if m.options&HashChainedModel > 0 {
+		return true
+	}
+	return false
+}
+
+// isAudited returns true if the changes of the records
+// of this model are logged in the audit log.
+func (m *Model) isAudited() bool {
+	if m.options&AuditedModel > 0 {
+		return true
+	}
+	return false
+}
+
+// isFuzzySearchable returns true if the records of
+// this model can be searched with NameSearchFuzzy.
+func (m *Model) isFuzzySearchable() bool {
+	if m.options&FuzzySearchModel > 0 {
+		return true
+	}
+	return false
+}
+
 // isSystem returns true if this is a n M2M Link model.
 func (m *Model) isM2MLink() bool {
 	if m.options&Many2ManyLinkModel > 0 {
@@ -381,6 +488,30 @@ func (m *Model) SetDefaultOrder(orders ...string) {
 	m.defaultOrder = orders
 }
 
+// SetRecordNameFields sets the fields that hold the human readable name of
+// the records of this model. They are used by NameGet to compute the display
+// name and by SearchByName and NameSearch to find records by name.
+//
+// When unspecified, the 'Name' field is used if it exists.
+func (m *Model) SetRecordNameFields(fields ...FieldNamer) {
+	m.recNameFields = make([]string, len(fields))
+	for i, f := range fields {
+		m.recNameFields[i] = string(f.FieldName())
+	}
+}
+
+// RecordNameFields returns the names of the fields that hold the human
+// readable name of the records of this model.
+func (m *Model) RecordNameFields() []string {
+	if len(m.recNameFields) > 0 {
+		return m.recNameFields
+	}
+	if _, exists := m.fields.Get("Name"); exists {
+		return []string{"Name"}
+	}
+	return nil
+}
+
 // JSONizeFieldName returns the json name of the given fieldName
 // If fieldName is already the json name, returns it without modifying it.
 // fieldName may be a dot separated path from this model.
@@ -391,8 +522,8 @@ func (m *Model) JSONizeFieldName(fieldName string) string {
 
 // Field starts a condition on this model
 func (m *Model) Field(name string) *ConditionField {
-	newExprs := strings.Split(name, ExprSep)
-	cp := ConditionField{}
+	newExprs, jsonPath := splitFieldPath(name)
+	cp := ConditionField{jsonPath: jsonPath}
 	cp.exprs = append(cp.exprs, newExprs...)
 	return &cp
 }
@@ -421,20 +552,22 @@ func (m *Model) FieldsGet(fields ...FieldNamer) map[string]*FieldInfo {
 			filter = fInfo.filter.Serialize()
 		}
 		res[fInfo.json] = &FieldInfo{
-			Help:       fInfo.help,
-			Searchable: true,
-			Depends:    fInfo.depends,
-			Sortable:   true,
-			Type:       fInfo.fieldType,
-			Store:      fInfo.isStored(),
-			String:     fInfo.description,
-			Relation:   relation,
-			Required:   fInfo.required,
-			Selection:  fInfo.selection,
-			Domain:     filter,
-			ReadOnly:   fInfo.isReadOnly(),
-			ReverseFK:  fInfo.jsonReverseFK,
-			OnChange:   fInfo.onChange != "",
+			Help:             fInfo.help,
+			Searchable:       !fInfo.companyDependent && fInfo.fieldType != fieldtype.EmbeddedList && fInfo.fieldType != fieldtype.JSON,
+			Depends:          fInfo.depends,
+			Sortable:         !fInfo.companyDependent && fInfo.fieldType != fieldtype.EmbeddedList && fInfo.fieldType != fieldtype.JSON,
+			Type:             fInfo.fieldType,
+			Store:            fInfo.isStored(),
+			String:           fInfo.description,
+			Relation:         relation,
+			Required:         fInfo.required,
+			Selection:        fInfo.selection,
+			Domain:           filter,
+			ReadOnly:         fInfo.isReadOnly(),
+			ReverseFK:        fInfo.jsonReverseFK,
+			OnChange:         fInfo.onChange != "",
+			CompanyDependent: fInfo.companyDependent,
+			EmbeddedSchema:   fInfo.embeddedSchema,
 		}
 	}
 	return res
@@ -455,18 +588,18 @@ func (m *Model) FilteredOn(field string, condition *Condition) *Condition {
 
 // Create creates a new record in this model with the given data.
 func (m *Model) Create(env Environment, data interface{}) *RecordCollection {
-	return env.Pool(m.name).Call("Create", data).(RecordSet).Collection()
+	return newRecordCollection(env, m).Call("Create", data).(RecordSet).Collection()
 }
 
 // Search searches the database and returns records matching the given condition.
 func (m *Model) Search(env Environment, cond Conditioner) *RecordCollection {
-	return env.Pool(m.name).Call("Search", cond).(RecordSet).Collection()
+	return newRecordCollection(env, m).Call("Search", cond).(RecordSet).Collection()
 }
 
 // Browse returns a new RecordSet with the records with the given ids.
 // Note that this function is just a shorcut for Search on a list of ids.
 func (m *Model) Browse(env Environment, ids []int64) *RecordCollection {
-	return env.Pool(m.name).Call("Browse", ids).(RecordSet).Collection()
+	return newRecordCollection(env, m).Call("Browse", ids).(RecordSet).Collection()
 }
 
 // AddSQLConstraint adds a table constraint in the database.
@@ -484,6 +617,34 @@ func (m *Model) AddSQLConstraint(name, sql, errorString string) {
 	}
 }
 
+// AddUniqueConstraint adds a unique constraint on the given fields which only
+// applies to the records matching the given where clause. It is created as a
+// partial unique index in the database.
+//    - name is an arbitrary name to reference this constraint. It will be appended by
+//      the table name in the database, so there is only need to ensure that it is unique
+//      in this model.
+//    - fields are the fields whose values must be unique together.
+//    - where is the SQL condition selecting the records on which the constraint applies,
+//      such as "active = TRUE". If empty, the constraint applies to all records.
+//    - errorString is the text to display to the user when the constraint is violated
+func (m *Model) AddUniqueConstraint(name string, fields []FieldNamer, where, errorString string) {
+	if len(fields) == 0 {
+		log.Panic("Unique constraints must have at least one field", "model", m.name, "constraint", name)
+	}
+	constraintName := fmt.Sprintf("%s_%s_manidx", name, m.tableName)
+	m.uniqueConstraints[constraintName] = uniqueConstraint{
+		name:        constraintName,
+		fields:      fields,
+		where:       where,
+		errorString: errorString,
+	}
+}
+
+// RemoveUniqueConstraint removes the unique constraint with the given name from the database.
+func (m *Model) RemoveUniqueConstraint(name string) {
+	delete(m.uniqueConstraints, fmt.Sprintf("%s_%s_manidx", name, m.tableName))
+}
+
 // RemoveSQLConstraint removes the sql constraint with the given name from the database.
 func (m *Model) RemoveSQLConstraint(name string) {
 	delete(m.sqlConstraints, fmt.Sprintf("%s_mancon", name))
@@ -494,6 +655,11 @@ func (m *Model) Underlying() *Model {
 	return m
 }
 
+// ModelName returns the name of this model
+func (m *Model) ModelName() string {
+	return m.name
+}
+
 var _ Modeler = new(Model)
 
 // NewModel creates a new model with the given name and
@@ -527,6 +693,15 @@ func NewManualModel(name string) *Model {
 	return model
 }
 
+// NewImmutableModel creates a model whose records cannot be modified nor
+// deleted once created. Call EnableHashChain on the returned model to also
+// detect modifications made directly in the database.
+func NewImmutableModel(name string) *Model {
+	model := createModel(name, ImmutableModel)
+	model.InheritModel(Registry.MustGet("ModelMixin"))
+	return model
+}
+
 // InheritModel extends this Model by importing all fields and methods of mixInModel.
 // MixIn methods and fields have a lower priority than those of the model and are
 // overridden by the them when applicable.
@@ -538,16 +713,18 @@ func (m *Model) InheritModel(mixInModel Modeler) {
 // by parsing the given struct pointer.
 func createModel(name string, options Option) *Model {
 	mi := &Model{
-		name:           name,
-		options:        options,
-		acl:            security.NewAccessControlList(),
-		rulesRegistry:  newRecordRuleRegistry(),
-		tableName:      strutils.SnakeCaseString(name),
-		fields:         newFieldsCollection(),
-		methods:        newMethodsCollection(),
-		sqlConstraints: make(map[string]sqlConstraint),
-		sqlErrors:      make(map[string]string),
-		defaultOrder:   []string{"id"},
+		name:              name,
+		options:           options,
+		acl:               security.NewAccessControlList(),
+		rulesRegistry:     newRecordRuleRegistry(),
+		tableName:         strutils.SnakeCaseString(name),
+		fields:            newFieldsCollection(),
+		methods:           newMethodsCollection(),
+		sqlConstraints:    make(map[string]sqlConstraint),
+		uniqueConstraints: make(map[string]uniqueConstraint),
+		indexes:           make(map[string]modelIndex),
+		sqlErrors:         make(map[string]string),
+		defaultOrder:      []string{"id"},
 	}
 	pk := &Field{
 		name:      "ID",
@@ -0,0 +1,275 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hexya-erp/hexya/hexya/models/fieldtype"
+)
+
+// A RemapCheck is the verification result of the remapping
+// of the ids in a database column.
+//
+// Expected is the number of rows that referenced an old id before remapping,
+// Updated the number of rows actually rewritten and Remaining the number of
+// rows that still reference an old id which is not also a new id.
+type RemapCheck struct {
+	Table     string
+	Column    string
+	Expected  int64
+	Updated   int64
+	Remaining int64
+}
+
+// OK returns true if all the rows of this check have been remapped
+func (rc RemapCheck) OK() bool {
+	return rc.Updated == rc.Expected && rc.Remaining == 0
+}
+
+// A RemapReport is the result of RemapIDs.
+//
+// DuplicatesRemoved is the number of many2many link rows that were deleted
+// because remapping made them identical to another link.
+type RemapReport struct {
+	Model             string
+	Checks            []RemapCheck
+	DuplicatesRemoved int64
+	Applied           bool
+}
+
+// OK returns true if all the checks of this report are OK
+func (rr RemapReport) OK() bool {
+	for _, check := range rr.Checks {
+		if !check.OK() {
+			return false
+		}
+	}
+	return true
+}
+
+// String returns a human readable version of this report
+func (rr RemapReport) String() string {
+	var lines []string
+	for _, check := range rr.Checks {
+		status := "OK"
+		if !check.OK() {
+			status = "FAILED"
+		}
+		lines = append(lines, fmt.Sprintf("%s.%s: expected %d, updated %d, remaining %d - %s",
+			check.Table, check.Column, check.Expected, check.Updated, check.Remaining, status))
+	}
+	lines = append(lines, fmt.Sprintf("%d duplicate many2many links removed", rr.DuplicatesRemoved))
+	return strings.Join(lines, "\n")
+}
+
+// RemapIDs rewrites all references to the records of the given model according
+// to the given mapping of old ids to new ids. This is meant to be used when
+// records have been renumbered, for instance after merging two databases.
+//
+// The following references are rewritten:
+//   - many2one and one2one columns of all models pointing to this model,
+//   - many2many link rows (duplicate links are removed),
+//   - reference columns holding "ModelName,ID" values,
+//   - external ids of ModelData.
+//
+// The records themselves are not renumbered and all the new ids must
+// already exist in the database. RemapIDs panics otherwise.
+//
+// All rewrites are performed within a savepoint and are verified afterwards.
+// If a check fails, all changes are rolled back and the returned report has
+// its Applied field set to false.
+func (env Environment) RemapIDs(modelName string, mapping map[int64]int64) RemapReport {
+	model := Registry.MustGet(modelName)
+	res := RemapReport{Model: model.name}
+	if len(mapping) == 0 {
+		res.Applied = true
+		return res
+	}
+	adapter := adapters[db.DriverName()]
+	oldIDs, newIDs, staleIDs := remapIDSets(mapping)
+
+	var count int64
+	env.cr.Get(&count, fmt.Sprintf(`SELECT COUNT(*) FROM %s WHERE id IN (?)`, adapter.quoteTableName(model.tableName)), newIDs)
+	if count != int64(len(newIDs)) {
+		log.Panic("Remapping to non existent records", "model", model.name, "expected", len(newIDs), "found", count)
+	}
+
+	env.cr.Execute("SAVEPOINT hexya_remap")
+	for _, mi := range Registry.registryByName {
+		if mi.isMixin() {
+			continue
+		}
+		var remapped bool
+		for _, fi := range mi.fields.registryByJSON {
+			if !fi.isStored() {
+				continue
+			}
+			switch {
+			case fi.fieldType.IsFKRelationType() && fi.relatedModel == model:
+				check := env.remapColumn(mi, fi.json, remapIntValues(mapping), oldIDs, staleIDs)
+				remapped = remapped || check.Updated > 0
+				res.Checks = append(res.Checks, check)
+			case fi.fieldType == fieldtype.Reference:
+				res.Checks = append(res.Checks, env.remapColumn(mi, fi.json, remapRefValues(model, mapping),
+					remapRefList(model, oldIDs), remapRefList(model, staleIDs)))
+			}
+		}
+		if mi.isM2MLink() && remapped {
+			res.DuplicatesRemoved += env.removeDuplicateLinks(mi)
+		}
+	}
+	res.Checks = append(res.Checks, env.remapModelData(model, mapping, oldIDs, staleIDs))
+
+	// Cached values may reference old ids
+	env.Cache().Clear()
+	if !res.OK() {
+		env.cr.Execute("ROLLBACK TO SAVEPOINT hexya_remap")
+		env.cr.Execute("RELEASE SAVEPOINT hexya_remap")
+		log.Warn("Remapping ids failed verification, changes rolled back", "model", model.name, "report", res.String())
+		return res
+	}
+	env.cr.Execute("RELEASE SAVEPOINT hexya_remap")
+	res.Applied = true
+	return res
+}
+
+// remapIDSets returns the old ids and new ids of the given mapping, as well
+// as the stale ids, i.e. the old ids that are not also new ids and that must
+// not be referenced anymore after the remapping.
+func remapIDSets(mapping map[int64]int64) (oldIDs, newIDs, staleIDs []int64) {
+	newSet := make(map[int64]bool)
+	for oldID, newID := range mapping {
+		oldIDs = append(oldIDs, oldID)
+		if !newSet[newID] {
+			newIDs = append(newIDs, newID)
+		}
+		newSet[newID] = true
+	}
+	for _, oldID := range oldIDs {
+		if !newSet[oldID] {
+			staleIDs = append(staleIDs, oldID)
+		}
+	}
+	return
+}
+
+// remapIntValues returns the given mapping as a map of column values
+func remapIntValues(mapping map[int64]int64) map[interface{}]interface{} {
+	res := make(map[interface{}]interface{})
+	for oldID, newID := range mapping {
+		res[oldID] = newID
+	}
+	return res
+}
+
+// remapRefValues returns the given mapping as a map of
+// reference column values for the given model.
+func remapRefValues(model *Model, mapping map[int64]int64) map[interface{}]interface{} {
+	res := make(map[interface{}]interface{})
+	for oldID, newID := range mapping {
+		res[fmt.Sprintf("%s,%d", model.name, oldID)] = fmt.Sprintf("%s,%d", model.name, newID)
+	}
+	return res
+}
+
+// remapRefList returns the reference column values of the given ids of model
+func remapRefList(model *Model, ids []int64) []string {
+	res := make([]string, len(ids))
+	for i, id := range ids {
+		res[i] = fmt.Sprintf("%s,%d", model.name, id)
+	}
+	return res
+}
+
+// remapColumn rewrites the values of the given column of the table of model mi
+// according to the given values map in a single statement, so that chained or
+// swapped ids are remapped correctly.
+//
+// oldValues are the keys of values and staleValues those of the keys that must
+// not be found in the column after the remapping.
+func (env Environment) remapColumn(mi *Model, column string, values map[interface{}]interface{}, oldValues, staleValues interface{}) RemapCheck {
+	adapter := adapters[db.DriverName()]
+	table := adapter.quoteTableName(mi.tableName)
+	check := RemapCheck{Table: mi.tableName, Column: column}
+	env.cr.Get(&check.Expected, fmt.Sprintf(`SELECT COUNT(*) FROM %s WHERE %s IN (?)`, table, column), oldValues)
+	if check.Expected == 0 {
+		return check
+	}
+	var (
+		cases []string
+		args  []interface{}
+	)
+	for oldVal, newVal := range values {
+		cases = append(cases, "WHEN ? THEN ?")
+		args = append(args, oldVal, newVal)
+	}
+	args = append(args, oldValues)
+	query := fmt.Sprintf(`UPDATE %s SET %s = CASE %s %s END WHERE %s IN (?)`,
+		table, column, column, strings.Join(cases, " "), column)
+	check.Updated, _ = env.cr.Execute(query, args...).RowsAffected()
+	if remapListLen(staleValues) > 0 {
+		env.cr.Get(&check.Remaining, fmt.Sprintf(`SELECT COUNT(*) FROM %s WHERE %s IN (?)`, table, column), staleValues)
+	}
+	return check
+}
+
+// remapModelData rewrites the external ids of the records of the given model
+func (env Environment) remapModelData(model *Model, mapping map[int64]int64, oldIDs, staleIDs []int64) RemapCheck {
+	adapter := adapters[db.DriverName()]
+	mdModel := Registry.MustGet("ModelData")
+	table := adapter.quoteTableName(mdModel.tableName)
+	check := RemapCheck{Table: mdModel.tableName, Column: "res_id"}
+	env.cr.Get(&check.Expected, fmt.Sprintf(`SELECT COUNT(*) FROM %s WHERE model = ? AND res_id IN (?)`, table), model.name, oldIDs)
+	if check.Expected == 0 {
+		return check
+	}
+	var (
+		cases []string
+		args  []interface{}
+	)
+	for oldID, newID := range mapping {
+		cases = append(cases, "WHEN ? THEN ?")
+		args = append(args, oldID, newID)
+	}
+	args = append(args, model.name, oldIDs)
+	query := fmt.Sprintf(`UPDATE %s SET res_id = CASE res_id %s END WHERE model = ? AND res_id IN (?)`,
+		table, strings.Join(cases, " "))
+	check.Updated, _ = env.cr.Execute(query, args...).RowsAffected()
+	if len(staleIDs) > 0 {
+		env.cr.Get(&check.Remaining, fmt.Sprintf(`SELECT COUNT(*) FROM %s WHERE model = ? AND res_id IN (?)`, table), model.name, staleIDs)
+	}
+	return check
+}
+
+// removeDuplicateLinks deletes the rows of the given many2many link model
+// that link the same records as a row with a lower id. It returns the number
+// of deleted rows.
+func (env Environment) removeDuplicateLinks(mi *Model) int64 {
+	var cols []string
+	for _, fi := range mi.fields.registryByJSON {
+		if fi.fieldType.IsFKRelationType() {
+			cols = append(cols, fmt.Sprintf("a.%s = b.%s", fi.json, fi.json))
+		}
+	}
+	if len(cols) == 0 {
+		return 0
+	}
+	table := adapters[db.DriverName()].quoteTableName(mi.tableName)
+	query := fmt.Sprintf(`DELETE FROM %s a USING %s b WHERE a.id > b.id AND %s`, table, table, strings.Join(cols, " AND "))
+	num, _ := env.cr.Execute(query).RowsAffected()
+	return num
+}
+
+// remapListLen returns the length of the given []int64 or []string
+func remapListLen(list interface{}) int {
+	switch l := list.(type) {
+	case []int64:
+		return len(l)
+	case []string:
+		return len(l)
+	}
+	return 0
+}
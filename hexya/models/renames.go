@@ -0,0 +1,160 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"fmt"
+
+	"github.com/hexya-erp/hexya/hexya/models/fieldtype"
+	"github.com/hexya-erp/hexya/hexya/tools/strutils"
+)
+
+// AddPreviousName declares that this model was previously named oldName.
+//
+// The model can still be found in the registry by its previous name, so that
+// views, data files and clients referring to it keep working. When the
+// database is synchronized, the table of the previous model is renamed instead
+// of being dropped, and the model names stored in the system tables, such as
+// the external IDs, are updated.
+func (m *Model) AddPreviousName(oldName string) {
+	if Registry.bootstrapped {
+		log.Panic("Models must not be modified after bootstrap", "model", m.name, "previousName", oldName)
+	}
+	if other, exists := Registry.Get(oldName); exists {
+		log.Panic("Previous model name is already used", "model", m.name, "previousName", oldName, "other", other.name)
+	}
+	m.previousNames = append(m.previousNames, oldName)
+	Registry.registryByPreviousName[oldName] = m
+}
+
+// AddPreviousName declares that this field was previously named oldName.
+//
+// The field can still be found by its previous name or JSON name, so that
+// views, data files and clients referring to it keep working. When the
+// database is synchronized, the column of the previous field is renamed
+// instead of a new empty column being created, and the field names stored
+// in the system tables, such as translations, are updated.
+func (f *Field) AddPreviousName(oldName string) *Field {
+	if Registry.bootstrapped {
+		log.Panic("Fields must not be modified after bootstrap", "model", f.model.name, "field", f.name, "previousName", oldName)
+	}
+	if other, exists := f.model.fields.Get(oldName); exists {
+		log.Panic("Previous field name is already used", "model", f.model.name, "field", f.name,
+			"previousName", oldName, "other", other.name)
+	}
+	f.previousNames = append(f.previousNames, oldName)
+	f.model.fields.registerPreviousName(f, oldName)
+	return f
+}
+
+// registerPreviousName adds the given previous name of fInfo in this collection.
+func (fc *FieldsCollection) registerPreviousName(fInfo *Field, oldName string) {
+	fc.Lock()
+	defer fc.Unlock()
+	fc.registryByPreviousName[oldName] = fInfo
+	fc.registryByPreviousName[snakeCaseFieldName(oldName, fInfo.fieldType)] = fInfo
+}
+
+// updateDBRenames renames the tables of the models and the columns of the
+// fields that have been declared with a previous name, if the new table or
+// column does not exist yet, so that their data is kept.
+func updateDBRenames() {
+	adapter := adapters[db.DriverName()]
+	dbTables := adapter.tables()
+	for _, model := range Registry.registryByTableName {
+		if model.isMixin() || model.isManual() || dbTables[model.tableName] {
+			continue
+		}
+		for _, oldName := range model.previousNames {
+			oldTable := strutils.SnakeCaseString(oldName)
+			if !dbTables[oldTable] {
+				continue
+			}
+			log.Info("Renaming table of renamed model", "model", model.name, "previousName", oldName)
+			dbExecuteNoTx(fmt.Sprintf(`ALTER TABLE %s RENAME TO %s`,
+				adapter.quoteTableName(oldTable), adapter.quoteTableName(model.tableName)))
+			renameModelReferences(oldName, model.name)
+			delete(dbTables, oldTable)
+			dbTables[model.tableName] = true
+			break
+		}
+	}
+	for _, model := range Registry.registryByTableName {
+		if model.isMixin() || model.isManual() || !dbTables[model.tableName] {
+			continue
+		}
+		var columns map[string]ColumnData
+		for _, fi := range model.fields.registryByName {
+			if len(fi.previousNames) == 0 || !fi.isStored() || fi.fieldType.Is2ManyRelationType() {
+				continue
+			}
+			if columns == nil {
+				columns = adapter.columns(model.tableName)
+			}
+			if _, exists := columns[fi.json]; exists {
+				continue
+			}
+			for _, oldName := range fi.previousNames {
+				oldColumn := snakeCaseFieldName(oldName, fi.fieldType)
+				if _, exists := columns[oldColumn]; !exists {
+					continue
+				}
+				log.Info("Renaming column of renamed field", "model", model.name, "field", fi.name, "previousName", oldName)
+				dbExecuteNoTx(fmt.Sprintf(`ALTER TABLE %s RENAME COLUMN %s TO %s`,
+					adapter.quoteTableName(model.tableName), oldColumn, fi.json))
+				renameFieldReferences(model, oldName, fi)
+				delete(columns, oldColumn)
+				columns[fi.json] = ColumnData{}
+				break
+			}
+		}
+	}
+}
+
+// referenceTables returns the tables of the system models which store
+// model names in a 'model' column and, if withField is true, field names
+// in a 'field' column.
+func referenceTables(withField bool) []string {
+	var res []string
+	for _, model := range Registry.registryByTableName {
+		if !model.isSystem() || model.isMixin() || model.isManual() {
+			continue
+		}
+		modelField, ok := model.fields.registryByName["Model"]
+		if !ok || modelField.fieldType != fieldtype.Char || !modelField.isStored() {
+			continue
+		}
+		if withField {
+			fieldField, ok := model.fields.registryByName["Field"]
+			if !ok || fieldField.fieldType != fieldtype.Char || !fieldField.isStored() {
+				continue
+			}
+		}
+		res = append(res, model.tableName)
+	}
+	return res
+}
+
+// renameModelReferences replaces the previous name of a model by its new
+// name in the system tables that reference models by name.
+func renameModelReferences(oldName, newName string) {
+	adapter := adapters[db.DriverName()]
+	for _, table := range referenceTables(false) {
+		dbExecuteNoTx(fmt.Sprintf(`UPDATE %s SET model = ? WHERE model = ?`, adapter.quoteTableName(table)),
+			newName, oldName)
+	}
+}
+
+// renameFieldReferences replaces the previous name of the given field by its
+// new name in the system tables that reference fields by name. Both the name
+// and the JSON name of the field are replaced.
+func renameFieldReferences(model *Model, oldName string, fi *Field) {
+	adapter := adapters[db.DriverName()]
+	oldJSON := snakeCaseFieldName(oldName, fi.fieldType)
+	for _, table := range referenceTables(true) {
+		dbExecuteNoTx(fmt.Sprintf(`UPDATE %s SET field = CASE WHEN field = ? THEN ? ELSE ? END
+			WHERE model = ? AND field IN (?, ?)`, adapter.quoteTableName(table)),
+			oldName, fi.name, fi.json, model.name, oldName, oldJSON)
+	}
+}
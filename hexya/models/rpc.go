@@ -0,0 +1,145 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"encoding/json"
+	"reflect"
+)
+
+var (
+	recordSetType   = reflect.TypeOf((*RecordSet)(nil)).Elem()
+	fieldMapperType = reflect.TypeOf((*FieldMapper)(nil)).Elem()
+	fieldNamerType  = reflect.TypeOf((*FieldNamer)(nil)).Elem()
+)
+
+// An rpcRecordSet is the JSON form of a RecordSet argument
+// of a model other than the called model.
+type rpcRecordSet struct {
+	Model string  `json:"model"`
+	IDs   []int64 `json:"ids"`
+}
+
+// CallRPC calls the given method on this RecordCollection with the given JSON
+// encoded arguments, as requested by an API client, and returns its result in
+// a form that can be encoded in JSON.
+//
+// Arguments are decoded with the types of the method's parameters, except for:
+//   - RecordSet parameters, given as a list of ids of this RecordCollection's
+//     model or as a {"model": "Partner", "ids": [1, 2]} object,
+//   - FieldMapper parameters, given as an object whose keys are field names
+//     or JSON field names of this RecordCollection's model,
+//   - FieldNamer parameters, given as field names.
+//
+// RecordSets in the result are returned as lists of ids.
+//
+// It panics if the method does not exist, is private, is not allowed by the
+// APIScopes of the Environment or if the arguments cannot be decoded.
+func (rc *RecordCollection) CallRPC(methName string, args ...json.RawMessage) interface{} {
+	methInfo, ok := rc.model.methods.get(methName)
+	if !ok || methInfo.private {
+		log.Panic("Unknown or private method in model", "method", methName, "model", rc.model.name)
+	}
+	rc.env.checkAPIScopes(rc.model.name, methName)
+	values := make([]interface{}, len(args))
+	for i, arg := range args {
+		argType, ok := methodArgType(methInfo.methodType, i)
+		if !ok {
+			log.Panic("Too many arguments for method", "model", rc.model.name, "method", methName,
+				"expected", methInfo.methodType.NumIn()-1)
+		}
+		values[i] = rc.rpcArgument(argType, arg)
+	}
+	return rpcResult(rc.Call(methName, values...))
+}
+
+// methodArgType returns the type of the i-th argument of the given method type,
+// not counting the RecordCollection. It returns false if the method does not
+// accept that many arguments.
+func methodArgType(methType reflect.Type, i int) (reflect.Type, bool) {
+	switch {
+	case methType.IsVariadic() && i+1 >= methType.NumIn()-1:
+		return methType.In(methType.NumIn() - 1).Elem(), true
+	case i+1 < methType.NumIn():
+		return methType.In(i + 1), true
+	}
+	return nil, false
+}
+
+// rpcArgument returns the given JSON encoded argument decoded for
+// a parameter of the given type of a method of this RecordCollection.
+func (rc *RecordCollection) rpcArgument(argType reflect.Type, arg json.RawMessage) interface{} {
+	switch {
+	case argType.Implements(recordSetType) || argType == reflect.TypeOf(new(RecordCollection)):
+		var ids []int64
+		if err := json.Unmarshal(arg, &ids); err == nil {
+			return rc.env.Pool(rc.model.name).withIds(ids)
+		}
+		var id int64
+		if err := json.Unmarshal(arg, &id); err == nil {
+			return rc.env.Pool(rc.model.name).withIds([]int64{id})
+		}
+		var rs rpcRecordSet
+		if err := json.Unmarshal(arg, &rs); err != nil || rs.Model == "" {
+			log.Panic("Unable to decode RecordSet argument", "argument", string(arg), "error", err)
+		}
+		return rc.env.Pool(rs.Model).withIds(rs.IDs)
+	case argType.Implements(fieldMapperType):
+		var raw map[string]interface{}
+		if err := json.Unmarshal(arg, &raw); err != nil {
+			log.Panic("Unable to decode FieldMap argument", "argument", string(arg), "error", err)
+		}
+		res := make(FieldMap, len(raw))
+		for field, value := range raw {
+			if fi, ok := rc.model.fields.Get(field); ok {
+				value = jsonFieldValue(fi, value)
+			}
+			res[field] = value
+		}
+		return res
+	case argType == fieldNamerType:
+		var name string
+		if err := json.Unmarshal(arg, &name); err != nil {
+			log.Panic("Unable to decode field name argument", "argument", string(arg), "error", err)
+		}
+		return FieldName(name)
+	case argType.Kind() == reflect.Slice && argType.Elem() == fieldNamerType:
+		var names []string
+		if err := json.Unmarshal(arg, &names); err != nil {
+			log.Panic("Unable to decode field names argument", "argument", string(arg), "error", err)
+		}
+		res := make([]FieldNamer, len(names))
+		for i, name := range names {
+			res[i] = FieldName(name)
+		}
+		return res
+	}
+	val := reflect.New(argType)
+	if err := json.Unmarshal(arg, val.Interface()); err != nil {
+		log.Panic("Unable to decode argument", "argument", string(arg), "type", argType, "error", err)
+	}
+	return val.Elem().Interface()
+}
+
+// rpcResult returns the given method result with
+// RecordSets replaced by their ids.
+func rpcResult(res interface{}) interface{} {
+	switch r := res.(type) {
+	case RecordSet:
+		return r.Ids()
+	case FieldMap:
+		out := make(FieldMap, len(r))
+		for k, v := range r {
+			out[k] = rpcResult(v)
+		}
+		return out
+	case []FieldMap:
+		out := make([]FieldMap, len(r))
+		for i, fMap := range r {
+			out[i] = rpcResult(fMap).(FieldMap)
+		}
+		return out
+	}
+	return res
+}
@@ -0,0 +1,146 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"fmt"
+
+	"github.com/hexya-erp/hexya/hexya/models/fieldtype"
+	"github.com/hexya-erp/hexya/hexya/tools/nbutils"
+)
+
+// AllowDestructiveSchemaChanges defines whether SyncDatabase applies the
+// schema changes that may lose data: dropping tables, columns and sequences
+// that are not declared anymore, and changing the type of a column to a
+// type that does not hold all the values of the current type.
+//
+// If false, which is the default, these changes are logged and returned by
+// SkippedSchemaChanges instead of being applied, so that they can be
+// reviewed and applied manually.
+var AllowDestructiveSchemaChanges bool
+
+// skippedSchemaChanges are the SQL statements of the schema changes that
+// have not been applied by the last call to SyncDatabase.
+var skippedSchemaChanges []string
+
+// SkippedSchemaChanges returns the SQL statements of the schema changes that
+// the last call to SyncDatabase did not apply, either because they are
+// destructive, or because they are not possible with the current data, such
+// as setting NOT NULL on a column of a required relation field that has
+// NULL values.
+func SkippedSchemaChanges() []string {
+	res := make([]string, len(skippedSchemaChanges))
+	copy(res, skippedSchemaChanges)
+	return res
+}
+
+// applyDestructiveChange executes the given SQL statement of a destructive
+// schema change if AllowDestructiveSchemaChanges is true. Otherwise, the
+// statement is logged with the given context and skipped.
+func applyDestructiveChange(query string, ctx ...interface{}) {
+	if AllowDestructiveSchemaChanges {
+		log.Warn("Applying destructive schema change", append(ctx, "query", query)...)
+		dbExecuteNoTx(query)
+		return
+	}
+	skipSchemaChange(query, "destructive change", ctx...)
+}
+
+// skipSchemaChange logs that the schema change with the given SQL statement
+// is not applied for the given reason and adds it to the skipped changes.
+func skipSchemaChange(query, reason string, ctx ...interface{}) {
+	log.Warn("Schema change not applied", append(ctx, "reason", reason, "query", query)...)
+	skippedSchemaChanges = append(skippedSchemaChanges, query)
+}
+
+// A columnType is the type of a column, as described in the
+// catalog of the database.
+type columnType struct {
+	// dataType is the name of the type, as in pgTypes
+	dataType string
+	// length is the maximum length of a character varying
+	// column, or 0 if it is unlimited
+	length int64
+	// precision and scale are the precision and scale of a numeric
+	// column. precision is 0 if the column is unconstrained.
+	precision int64
+	scale     int64
+}
+
+// dbColumnType returns the columnType of the given column of the database
+func dbColumnType(col ColumnData) columnType {
+	res := columnType{dataType: col.DataType}
+	switch col.DataType {
+	case "character varying":
+		res.length = col.CharacterMaximumLength.Int64
+	case "numeric":
+		res.precision = col.NumericPrecision.Int64
+		res.scale = col.NumericScale.Int64
+	}
+	return res
+}
+
+// fieldColumnType returns the columnType of the column of the given Field
+func fieldColumnType(fi *Field) columnType {
+	adapter := adapters[db.DriverName()]
+	res := columnType{dataType: adapter.typeSQL(fi)}
+	if fi.attachment {
+		return res
+	}
+	switch fi.fieldType {
+	case fieldtype.Char:
+		res.length = int64(fi.size)
+	case fieldtype.Float, fieldtype.Monetary:
+		if fi.digits != (nbutils.Digits{}) {
+			res.precision = int64(fi.digits.Precision)
+			res.scale = int64(fi.digits.Scale)
+		}
+	}
+	return res
+}
+
+// String returns the SQL definition of this columnType
+func (ct columnType) String() string {
+	switch {
+	case ct.dataType == "character varying" && ct.length > 0:
+		return fmt.Sprintf("%s(%d)", ct.dataType, ct.length)
+	case ct.dataType == "numeric" && ct.precision > 0:
+		return fmt.Sprintf("numeric(%d, %d)", ct.precision, ct.scale)
+	}
+	return ct.dataType
+}
+
+// integerDigits are the numbers of decimal digits of the
+// largest values of the integer types.
+var integerDigits = map[string]int64{
+	"smallint": 5,
+	"integer":  10,
+	"bigint":   19,
+}
+
+// widensTo returns true if all the values of this columnType
+// can be converted to the given target type without loss.
+func (ct columnType) widensTo(target columnType) bool {
+	switch {
+	case ct == target:
+		return true
+	case ct.dataType == target.dataType && ct.dataType == "character varying":
+		return target.length == 0 || (ct.length > 0 && target.length >= ct.length)
+	case ct.dataType == target.dataType && ct.dataType == "numeric":
+		return target.precision == 0 ||
+			(ct.precision > 0 && target.scale >= ct.scale && target.precision-target.scale >= ct.precision-ct.scale)
+	case ct.dataType == "character varying" || ct.dataType == "text":
+		return (target.dataType == "character varying" && target.length == 0) || target.dataType == "text"
+	case ct.dataType == "date":
+		return target.dataType == "timestamp without time zone"
+	}
+	digits, isInteger := integerDigits[ct.dataType]
+	if !isInteger {
+		return false
+	}
+	if targetDigits, ok := integerDigits[target.dataType]; ok {
+		return targetDigits >= digits
+	}
+	return target.dataType == "numeric" && (target.precision == 0 || target.precision-target.scale >= digits)
+}
@@ -48,8 +48,9 @@ var (
 type InheritanceInfo int8
 
 // A Group defines a role which can be granted or denied permissions.
-// - Groups can inherit from other groups and get access to these groups
-// permissions.
+// - Groups can imply other groups (their Inherits) and get access to these
+// groups permissions. Implication is transitive: if A implies B and B
+// implies C, members of A are also members of B and C.
 // - A user can belong to one or several groups, and thus inherit from the
 // permissions of the groups.
 type Group struct {
@@ -63,11 +64,13 @@ func (g Group) String() string {
 	return fmt.Sprintf("Group(%s)", g.ID)
 }
 
-// A GroupCollection keeps a list of groups
+// A GroupCollection keeps a list of groups and the native memberships of
+// users. Memberships through implied groups are resolved when queried.
 type GroupCollection struct {
 	sync.RWMutex
 	groups      map[string]*Group
-	memberships map[int64]map[*Group]InheritanceInfo
+	memberships map[int64]map[*Group]bool
+	version     uint64
 }
 
 // NewGroup creates a new Group with the given id, name and inherited groups
@@ -83,6 +86,21 @@ func (gc *GroupCollection) NewGroup(ID, name string, inherits ...*Group) *Group
 	return grp
 }
 
+// DeclareGroup returns the group with the given ID, after adding the given
+// implied groups to it. The group is created with the given name if it does
+// not exist yet, so that several modules can declare the same group, each
+// adding the groups it implies in this module.
+func (gc *GroupCollection) DeclareGroup(ID, name string, implied ...*Group) *Group {
+	gc.RLock()
+	grp, exists := gc.groups[ID]
+	gc.RUnlock()
+	if !exists {
+		return gc.NewGroup(ID, name, implied...)
+	}
+	gc.AddImpliedGroups(grp, implied...)
+	return grp
+}
+
 // RegisterGroup adds the given group to this GroupCollection
 // If group with the same ID exists, this methods panics.
 func (gc *GroupCollection) RegisterGroup(group *Group) {
@@ -91,98 +109,141 @@ func (gc *GroupCollection) RegisterGroup(group *Group) {
 	if _, exists := gc.groups[group.ID]; exists {
 		log.Panic("Trying register a new group with an existing ID", "ID", group.ID)
 	}
+	for _, implied := range group.Inherits {
+		if implied == group || impliesGroup(implied, group) {
+			log.Panic("Group cannot imply itself", "ID", group.ID)
+		}
+	}
 	gc.groups[group.ID] = group
+	gc.version++
+}
+
+// AddImpliedGroups makes the given group imply the given implied groups, so
+// that all the members of group become members of the implied groups. It
+// panics if it would make a group imply itself.
+func (gc *GroupCollection) AddImpliedGroups(group *Group, implied ...*Group) {
+	gc.Lock()
+	defer gc.Unlock()
+	for _, imp := range implied {
+		if imp == group || impliesGroup(imp, group) {
+			log.Panic("Group cannot imply itself", "group", group.ID, "implied", imp.ID)
+		}
+		if impliesGroup(group, imp) {
+			continue
+		}
+		group.Inherits = append(group.Inherits, imp)
+	}
+	gc.version++
 }
 
-// inheritedBy recursively populates the result slice for the
-// with the group's parents
-func (gc *GroupCollection) inheritedBy(group *Group, result *[]*Group) {
-	for _, parent := range group.Inherits {
-		*result = append(*result, parent)
-		gc.inheritedBy(parent, result)
+// RemoveImpliedGroups removes the given groups from the
+// groups directly implied by the given group.
+func (gc *GroupCollection) RemoveImpliedGroups(group *Group, implied ...*Group) {
+	gc.Lock()
+	defer gc.Unlock()
+	for _, imp := range implied {
+		removeInherits(group, imp)
+	}
+	gc.version++
+}
+
+// ImpliedGroups returns all the groups implied by the given
+// group, directly or transitively, without the group itself.
+func (gc *GroupCollection) ImpliedGroups(group *Group) []*Group {
+	gc.RLock()
+	defer gc.RUnlock()
+	return impliedGroups(group)
+}
+
+// impliedGroups returns all the groups implied by the given group, directly
+// or transitively, without the group itself. It is safe on cyclic graphs.
+func impliedGroups(group *Group) []*Group {
+	var res []*Group
+	visited := map[*Group]bool{group: true}
+	stack := append([]*Group{}, group.Inherits...)
+	for len(stack) > 0 {
+		grp := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if visited[grp] {
+			continue
+		}
+		visited[grp] = true
+		res = append(res, grp)
+		stack = append(stack, grp.Inherits...)
+	}
+	return res
+}
+
+// impliesGroup returns true if group implies other, directly or transitively
+func impliesGroup(group, other *Group) bool {
+	for _, grp := range impliedGroups(group) {
+		if grp == other {
+			return true
+		}
+	}
+	return false
+}
+
+// removeInherits removes other from the groups directly implied by group
+func removeInherits(group, other *Group) {
+	for i := 0; i < len(group.Inherits); i++ {
+		if group.Inherits[i] != other {
+			continue
+		}
+		// memory safe delete
+		copy(group.Inherits[i:], group.Inherits[i+1:])
+		length := len(group.Inherits)
+		group.Inherits[length-1] = nil
+		group.Inherits = group.Inherits[:length-1]
+		i--
 	}
 }
 
 // UnregisterGroup removes the group with the given ID from this GroupCollection
 func (gc *GroupCollection) UnregisterGroup(group *Group) {
+	gc.Lock()
+	defer gc.Unlock()
 	// remove links from inheriting groups
-	for id, grp := range gc.groups {
-		for i, iGrp := range grp.Inherits {
-			if iGrp.ID == group.ID {
-				// memory safe delete
-				copy(gc.groups[id].Inherits[i:], gc.groups[id].Inherits[i+1:])
-				length := len(gc.groups[id].Inherits)
-				gc.groups[id].Inherits[length-1] = nil
-				gc.groups[id].Inherits = gc.groups[id].Inherits[:length-1]
-			}
-		}
+	for _, grp := range gc.groups {
+		removeInherits(grp, group)
 	}
 	// remove memberships
 	for uid := range gc.memberships {
-		gc.RemoveMembership(uid, group)
+		delete(gc.memberships[uid], group)
 	}
 	// Remove the group itself
-	gc.Lock()
-	defer gc.Unlock()
 	delete(gc.groups, group.ID)
+	gc.version++
 }
 
 // GetGroup returns the group with the given groupID or nil if not found
 func (gc *GroupCollection) GetGroup(groupID string) *Group {
+	gc.RLock()
+	defer gc.RUnlock()
 	return gc.groups[groupID]
 }
 
-// AddMembership adds the user defined by its uid to the
-// given group and also to all groups that inherit this group.
-// inherit is set to true when this method is called on an
-// inherited group recursively. You should normally leave it
-// unset.
-func (gc *GroupCollection) AddMembership(uid int64, group *Group, inherit ...bool) {
-	var inheritingGroups []*Group
-	gc.inheritedBy(group, &inheritingGroups)
-	for _, grp := range inheritingGroups {
-		gc.AddMembership(uid, grp, true)
-	}
+// AddMembership adds the user defined by its uid to the given group.
+// The user also becomes a member of all the groups implied by this group.
+func (gc *GroupCollection) AddMembership(uid int64, group *Group) {
 	gc.Lock()
 	defer gc.Unlock()
-	mode := NativeGroup
-	if len(inherit) > 0 && inherit[0] {
-		mode = InheritedGroup
-	}
 	if _, exists := gc.memberships[uid]; !exists {
-		gc.memberships[uid] = make(map[*Group]InheritanceInfo)
+		gc.memberships[uid] = make(map[*Group]bool)
 	}
-	gc.memberships[uid][group] = mode
+	gc.memberships[uid][group] = true
+	gc.version++
 }
 
-// RemoveMembership removes the user with the given uid from the given group
-// and all groups that inherit from this group.
+// RemoveMembership removes the user with the given uid from the given group.
+// The user is still a member of the groups implied by this group if they are
+// implied by another group of the user. Removing a membership that the user
+// only has through implied groups does nothing.
 func (gc *GroupCollection) RemoveMembership(uid int64, group *Group) {
-	if _, exists := gc.memberships[uid][group]; !exists {
-		return
-	}
-	gc.doRemoveMembership(uid, group)
-	// Re-Add membership for all existing groups to compute inheritance
-	for grp, ii := range gc.memberships[uid] {
-		if ii == NativeGroup {
-			gc.AddMembership(uid, grp)
-		}
-	}
-}
-
-// doRemoveMembership actually removes the user with the given uid from the
-// given Group and all groups that inherit from this Group.
-func (gc *GroupCollection) doRemoveMembership(uid int64, group *Group) {
 	gc.Lock()
 	defer gc.Unlock()
-	// Remove our group
 	delete(gc.memberships[uid], group)
-	// Remove all inherited groups
-	for _, grp := range group.Inherits {
-		if gc.memberships[uid][grp] == InheritedGroup {
-			delete(gc.memberships[uid], grp)
-		}
-	}
+	gc.version++
 }
 
 // RemoveAllMembershipsForUser removes the given uid from all groups
@@ -198,30 +259,57 @@ func (gc *GroupCollection) doRemoveAllMembershipsForUser(uid int64) {
 	gc.Lock()
 	defer gc.Unlock()
 	delete(gc.memberships, uid)
+	gc.version++
 }
 
-// HasMembership returns true id the given uid is a member of the given group
+// HasMembership returns true id the given uid is a member of the given group,
+// natively or through implied groups.
 func (gc *GroupCollection) HasMembership(uid int64, group *Group) bool {
 	if group == GroupEveryone {
 		return true
 	}
-	_, ok := gc.memberships[uid][group]
+	_, ok := gc.UserGroups(uid)[group]
 	return ok
 }
 
-// UserGroups returns the slice of groups the user with the given
-// uid belongs to, including inherited groups.
+// UserGroups returns the groups the user with the given uid belongs to,
+// including the groups implied transitively by the user's native groups.
 func (gc *GroupCollection) UserGroups(uid int64) map[*Group]InheritanceInfo {
+	gc.RLock()
+	defer gc.RUnlock()
 	res := make(map[*Group]InheritanceInfo, len(gc.memberships[uid])+1)
-	for k, v := range gc.memberships[uid] {
-		res[k] = v
+	natives := []*Group{GroupEveryone}
+	for grp := range gc.memberships[uid] {
+		natives = append(natives, grp)
+	}
+	for _, grp := range natives {
+		res[grp] = NativeGroup
+	}
+	for _, grp := range natives {
+		for _, implied := range impliedGroups(grp) {
+			if _, exists := res[implied]; !exists {
+				res[implied] = InheritedGroup
+			}
+		}
 	}
-	res[GroupEveryone] = NativeGroup
 	return res
 }
 
+// Version returns a number that changes each time a group or a membership
+// of this collection is modified, so that resolved memberships can be cached.
+//
+// Modifying the Inherits of a group directly does not change the version:
+// use AddImpliedGroups and RemoveImpliedGroups instead.
+func (gc *GroupCollection) Version() uint64 {
+	gc.RLock()
+	defer gc.RUnlock()
+	return gc.version
+}
+
 // AllGroups returns a slice with all the groups of the collection
 func (gc *GroupCollection) AllGroups() []*Group {
+	gc.RLock()
+	defer gc.RUnlock()
 	res := make([]*Group, len(gc.groups))
 	i := 0
 	for _, group := range gc.groups {
@@ -235,7 +323,7 @@ func (gc *GroupCollection) AllGroups() []*Group {
 func NewGroupCollection() *GroupCollection {
 	gc := GroupCollection{
 		groups:      make(map[string]*Group),
-		memberships: make(map[int64]map[*Group]InheritanceInfo),
+		memberships: make(map[int64]map[*Group]bool),
 	}
 	return &gc
 }
@@ -0,0 +1,103 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package security
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Password hashing algorithms
+const (
+	// Argon2id is the argon2id algorithm, the winner of the Password
+	// Hashing Competition, whose hashes are given in the PHC string
+	// format: $argon2id$v=19$m=<memory>,t=<time>,p=<threads>$<salt>$<key>
+	Argon2id = "argon2id"
+	// BCrypt is the bcrypt algorithm
+	BCrypt = "bcrypt"
+)
+
+var (
+	// PasswordHashAlgorithm is the algorithm of the
+	// new password hashes, Argon2id or BCrypt.
+	PasswordHashAlgorithm = Argon2id
+	// Argon2Params are the parameters of new argon2id hashes
+	Argon2Params = struct {
+		Memory  uint32
+		Time    uint32
+		Threads uint8
+		SaltLen int
+		KeyLen  uint32
+	}{Memory: 64 * 1024, Time: 3, Threads: 2, SaltLen: 16, KeyLen: 32}
+	// BCryptCost is the cost of new bcrypt hashes
+	BCryptCost = bcrypt.DefaultCost
+)
+
+// HashPassword returns the hash of the given password with
+// PasswordHashAlgorithm, with a random salt.
+func HashPassword(password string) (string, error) {
+	if PasswordHashAlgorithm == BCrypt {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), BCryptCost)
+		return string(hash), err
+	}
+	p := Argon2Params
+	salt := make([]byte, p.SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, p.Time, p.Memory, p.Threads, p.KeyLen)
+	return fmt.Sprintf("$%s$v=%d$m=%d,t=%d,p=%d$%s$%s", Argon2id, argon2.Version, p.Memory, p.Time, p.Threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// CheckPassword returns true if the given password matches the given hash,
+// which can be an argon2id or a bcrypt hash.
+func CheckPassword(password, hash string) bool {
+	if !strings.HasPrefix(hash, "$"+Argon2id+"$") {
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+	}
+	var (
+		version, memory, time uint32
+		threads               uint8
+	)
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return false
+	}
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &time, &threads); err != nil {
+		return false
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return false
+	}
+	other := argon2.IDKey([]byte(password), salt, time, memory, threads, uint32(len(key)))
+	return subtle.ConstantTimeCompare(key, other) == 1
+}
+
+// PasswordNeedsRehash returns true if the given hash has not been computed
+// with the current algorithm and parameters, so that the password should be
+// hashed again the next time it is checked successfully.
+func PasswordNeedsRehash(hash string) bool {
+	if PasswordHashAlgorithm == BCrypt {
+		cost, err := bcrypt.Cost([]byte(hash))
+		return err != nil || cost != BCryptCost
+	}
+	p := Argon2Params
+	prefix := fmt.Sprintf("$%s$v=%d$m=%d,t=%d,p=%d$", Argon2id, argon2.Version, p.Memory, p.Time, p.Threads)
+	return !strings.HasPrefix(hash, prefix)
+}
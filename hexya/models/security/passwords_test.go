@@ -0,0 +1,50 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package security
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPasswords(t *testing.T) {
+	Convey("Testing password hashing", t, func() {
+		hash, err := HashPassword("secret")
+		So(err, ShouldBeNil)
+		So(strings.HasPrefix(hash, "$argon2id$v=19$m=65536,t=3,p=2$"), ShouldBeTrue)
+		So(CheckPassword("secret", hash), ShouldBeTrue)
+		So(CheckPassword("Secret", hash), ShouldBeFalse)
+		So(PasswordNeedsRehash(hash), ShouldBeFalse)
+		other, _ := HashPassword("secret")
+		So(other, ShouldNotEqual, hash)
+		Convey("Hashes with other parameters should be checked and rehashed", func() {
+			Argon2Params.Time = 4
+			defer func() { Argon2Params.Time = 3 }()
+			So(CheckPassword("secret", hash), ShouldBeTrue)
+			So(PasswordNeedsRehash(hash), ShouldBeTrue)
+		})
+		Convey("bcrypt hashes should be supported", func() {
+			PasswordHashAlgorithm = BCrypt
+			BCryptCost = 4
+			defer func() {
+				PasswordHashAlgorithm = Argon2id
+				BCryptCost = 10
+			}()
+			bHash, err := HashPassword("secret")
+			So(err, ShouldBeNil)
+			So(strings.HasPrefix(bHash, "$2a$04$"), ShouldBeTrue)
+			So(CheckPassword("secret", bHash), ShouldBeTrue)
+			So(CheckPassword("other", bHash), ShouldBeFalse)
+			So(PasswordNeedsRehash(bHash), ShouldBeFalse)
+			So(PasswordNeedsRehash(hash), ShouldBeTrue)
+		})
+		Convey("Invalid hashes should never match", func() {
+			So(CheckPassword("secret", ""), ShouldBeFalse)
+			So(CheckPassword("secret", "$argon2id$v=19$invalid"), ShouldBeFalse)
+			So(CheckPassword("secret", "secret"), ShouldBeFalse)
+		})
+	})
+}
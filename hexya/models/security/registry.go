@@ -48,16 +48,20 @@ func (acl *AccessControlList) ReplacePermission(group *Group, perm Permission) {
 }
 
 // CheckPermission returns true if the given group has the given permission,
-// either directly granted to it or granted to one of its inherited groups.
+// either directly granted to it or granted to one of the groups it implies.
 func (acl *AccessControlList) CheckPermission(group *Group, perm Permission) bool {
 	if perm == 0 {
 		log.Panic("Trying to check nil permission for group", "group", group.Name)
 	}
+	acl.RLock()
+	defer acl.RUnlock()
 	if acl.perms[group]&perm == perm {
 		return true
 	}
-	for _, inhGroup := range group.Inherits {
-		return acl.CheckPermission(inhGroup, perm)
+	for _, implied := range impliedGroups(group) {
+		if acl.perms[implied]&perm == perm {
+			return true
+		}
 	}
 	return false
 }
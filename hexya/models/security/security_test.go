@@ -61,6 +61,14 @@ func TestAccessControlList(t *testing.T) {
 			So(acl.CheckPermission(group1, Read|Write), ShouldBeFalse)
 			So(acl.CheckPermission(group1Inherit, Read|Unlink), ShouldBeFalse)
 		})
+
+		Convey("Checking permissions through several implied groups", func() {
+			group3 := gr.NewGroup("group3_test", "Group 3", group1, group2)
+			group4 := gr.NewGroup("group4_test", "Group 4", group3)
+			So(acl.CheckPermission(group3, Write), ShouldBeTrue)
+			So(acl.CheckPermission(group4, Read), ShouldBeTrue)
+			So(acl.CheckPermission(group4, Unlink), ShouldBeTrue)
+		})
 	})
 }
 
@@ -177,3 +185,58 @@ func TestGroupRegistry(t *testing.T) {
 		})
 	})
 }
+
+func TestImpliedGroups(t *testing.T) {
+	Convey("Testing implied groups", t, func() {
+		gr := NewGroupCollection()
+		user := gr.NewGroup("user_test", "User")
+		manager := gr.NewGroup("manager_test", "Manager", user)
+		director := gr.NewGroup("director_test", "Director", manager)
+		auditor := gr.NewGroup("auditor_test", "Auditor")
+		Convey("Implied groups should be resolved transitively", func() {
+			So(gr.ImpliedGroups(director), ShouldHaveLength, 2)
+			So(gr.ImpliedGroups(director), ShouldContain, manager)
+			So(gr.ImpliedGroups(director), ShouldContain, user)
+			gr.AddMembership(10, director)
+			So(gr.UserGroups(10), ShouldHaveLength, 4)
+			So(gr.UserGroups(10)[director], ShouldEqual, NativeGroup)
+			So(gr.UserGroups(10)[manager], ShouldEqual, InheritedGroup)
+			So(gr.UserGroups(10)[user], ShouldEqual, InheritedGroup)
+			So(gr.HasMembership(10, user), ShouldBeTrue)
+			So(gr.HasMembership(10, auditor), ShouldBeFalse)
+		})
+		Convey("Implications added later should apply to existing members", func() {
+			gr.AddMembership(10, director)
+			version := gr.Version()
+			gr.AddImpliedGroups(manager, auditor)
+			So(gr.Version(), ShouldBeGreaterThan, version)
+			So(gr.HasMembership(10, auditor), ShouldBeTrue)
+			gr.RemoveImpliedGroups(manager, auditor)
+			So(gr.HasMembership(10, auditor), ShouldBeFalse)
+		})
+		Convey("Removing a native membership should keep groups implied by others", func() {
+			gr.AddMembership(11, director)
+			gr.AddMembership(11, manager)
+			gr.RemoveMembership(11, director)
+			So(gr.HasMembership(11, director), ShouldBeFalse)
+			So(gr.HasMembership(11, manager), ShouldBeTrue)
+			So(gr.HasMembership(11, user), ShouldBeTrue)
+			gr.RemoveMembership(11, manager)
+			So(gr.HasMembership(11, user), ShouldBeFalse)
+		})
+		Convey("Declaring an existing group should extend it", func() {
+			grp := gr.DeclareGroup("manager_test", "Other Name", auditor)
+			So(grp, ShouldEqual, manager)
+			So(grp.Name, ShouldEqual, "Manager")
+			So(gr.ImpliedGroups(director), ShouldContain, auditor)
+			newGrp := gr.DeclareGroup("new_test", "New Group", user)
+			So(gr.GetGroup("new_test"), ShouldEqual, newGrp)
+			So(newGrp.Inherits, ShouldResemble, []*Group{user})
+		})
+		Convey("Groups should not imply themselves", func() {
+			So(func() { gr.AddImpliedGroups(user, director) }, ShouldPanic)
+			So(func() { gr.AddImpliedGroups(user, user) }, ShouldPanic)
+			So(user.Inherits, ShouldBeEmpty)
+		})
+	})
+}
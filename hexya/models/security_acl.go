@@ -34,9 +34,9 @@ func (f *Field) RevokeAccess(group *security.Group, perm security.Permission) *F
 	return f
 }
 
-// checkFieldPermission checks if the given uid has the given perm on the given field info.
-func checkFieldPermission(f *Field, uid int64, perm security.Permission) bool {
-	userGroups := security.Registry.UserGroups(uid)
+// checkFieldPermission checks if the user of the given Environment has the given perm on the given field info.
+func checkFieldPermission(f *Field, env Environment, perm security.Permission) bool {
+	userGroups := env.userGroups(env.uid)
 	for group := range userGroups {
 		if f.acl.CheckPermission(group, perm) {
 			return true
@@ -47,7 +47,7 @@ func checkFieldPermission(f *Field, uid int64, perm security.Permission) bool {
 
 // filterOnAuthorizedFields returns the fields slice with only the fields on
 // which the current user has the given permission.
-func filterOnAuthorizedFields(m *Model, uid int64, fields []string, perm security.Permission) []string {
+func filterOnAuthorizedFields(m *Model, env Environment, fields []string, perm security.Permission) []string {
 	perm = perm & (security.Read | security.Write)
 	if perm == 0 {
 		// We are trying to check perms that are not read or write which
@@ -58,7 +58,7 @@ func filterOnAuthorizedFields(m *Model, uid int64, fields []string, perm securit
 
 	for _, field := range fields {
 		f := m.getRelatedFieldInfo(field)
-		if checkFieldPermission(f, uid, perm) {
+		if checkFieldPermission(f, env, perm) {
 			res = append(res, field)
 		}
 	}
@@ -66,9 +66,9 @@ func filterOnAuthorizedFields(m *Model, uid int64, fields []string, perm securit
 }
 
 // filterMapOnAuthorizedFields returns a new FieldMap from fMap
-// with only the fields on which the given uid user has access.
+// with only the fields on which the user of the given Environment has access.
 // All field names are JSONized.
-func filterMapOnAuthorizedFields(m *Model, fMap FieldMap, uid int64, perm security.Permission) FieldMap {
+func filterMapOnAuthorizedFields(m *Model, fMap FieldMap, env Environment, perm security.Permission) FieldMap {
 	perm = perm & (security.Read | security.Write)
 	if perm == 0 {
 		// We are trying to check perms that are not read or write which
@@ -78,7 +78,7 @@ func filterMapOnAuthorizedFields(m *Model, fMap FieldMap, uid int64, perm securi
 	newFMap := make(FieldMap)
 	for field, value := range fMap {
 		f := m.getRelatedFieldInfo(field)
-		if checkFieldPermission(f, uid, perm) {
+		if checkFieldPermission(f, env, perm) {
 			newFMap[f.json] = value
 		}
 	}
@@ -0,0 +1,46 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import "github.com/hexya-erp/hexya/hexya/models/security"
+
+// cachedUserGroups are the groups of a user, resolved with the
+// given version of the security.Registry.
+type cachedUserGroups struct {
+	version uint64
+	groups  map[*security.Group]security.InheritanceInfo
+}
+
+// userGroups returns the groups the user with the given uid belongs to,
+// including implied groups. Groups are resolved once per Environment cache
+// and resolved again only if the security.Registry has changed since.
+func (env Environment) userGroups(uid int64) map[*security.Group]security.InheritanceInfo {
+	version := security.Registry.Version()
+	if cached, ok := env.cache.userGroups[uid]; ok && cached.version == version {
+		return cached.groups
+	}
+	groups := security.Registry.UserGroups(uid)
+	env.cache.userGroups[uid] = cachedUserGroups{version: version, groups: groups}
+	return groups
+}
+
+// Groups returns the groups this user belongs to, natively
+// or through the groups implied by their groups.
+func (u EnvUser) Groups() map[*security.Group]security.InheritanceInfo {
+	res := make(map[*security.Group]security.InheritanceInfo)
+	for group, ii := range u.env.userGroups(u.env.uid) {
+		res[group] = ii
+	}
+	return res
+}
+
+// HasGroup returns true if this user is a member of
+// the given group, natively or through implied groups.
+func (u EnvUser) HasGroup(group *security.Group) bool {
+	if group == security.GroupEveryone {
+		return true
+	}
+	_, ok := u.env.userGroups(u.env.uid)[group]
+	return ok
+}
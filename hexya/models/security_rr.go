@@ -25,12 +25,26 @@ import (
 // - If Global is true, then the RecordRule applies to all groups
 // - Condition is the filter to apply on the model to retrieve
 // the records on which to allow the Perms permission.
+// - ConditionFunc, if set, is used instead of Condition for rules
+// that depend on the environment. It is called with the RecordSet
+// to filter and returns the filter to apply, or nil if all records
+// are allowed.
 type RecordRule struct {
-	Name      string
-	Global    bool
-	Group     *security.Group
-	Condition *Condition
-	Perms     security.Permission
+	Name          string
+	Global        bool
+	Group         *security.Group
+	Condition     *Condition
+	ConditionFunc func(RecordSet) *Condition
+	Perms         security.Permission
+}
+
+// conditionFor returns the condition of this RecordRule to apply
+// on the given RecordCollection, or nil if all records are allowed.
+func (rr *RecordRule) conditionFor(rc *RecordCollection) *Condition {
+	if rr.ConditionFunc != nil {
+		return rr.ConditionFunc(rc)
+	}
+	return rr.Condition
 }
 
 // A RecordRuleRegistry keeps a list of RecordRule. It is meant
@@ -0,0 +1,330 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hexya-erp/hexya/hexya/models/security"
+	"github.com/hexya-erp/hexya/hexya/models/types"
+	"github.com/hexya-erp/hexya/hexya/models/types/dates"
+)
+
+// declareSequenceModels creates the Sequence and SequenceDateRange models.
+//
+// A Sequence produces formatted numbers such as "SO/2017/0042" for order or
+// invoice numbering. Numbers are taken either from a DB sequence ("standard"
+// implementation, fast but gaps appear when transactions are rolled back) or
+// from the NumberNext column of the sequence locked with SELECT FOR UPDATE
+// ("no_gap" implementation, which serializes concurrent transactions).
+//
+// Sequences using date ranges restart numbering in each SequenceDateRange.
+// A yearly date range is created when no range includes the sequence date.
+func declareSequenceModels() {
+	sequence := NewModel("Sequence")
+	dateRange := NewModel("SequenceDateRange")
+
+	sequence.AddFields(map[string]FieldDefinition{
+		"Name": CharField{Required: true},
+		"Code": CharField{String: "Sequence Code", Index: true,
+			Help: "Code used to get the next number with Environment.NextSequence"},
+		"Implementation": SelectionField{Selection: types.Selection{"standard": "Standard", "no_gap": "No Gap"},
+			Required: true, Default: DefaultValue("standard"),
+			Help: "Standard sequences are faster but may have gaps. No gap sequences lock the sequence until the end of the transaction."},
+		"Active": BooleanField{Default: DefaultValue(true)},
+		"Prefix": CharField{Help: "Prefix of the numbers. It may contain placeholders such as %(year)s"},
+		"Suffix": CharField{Help: "Suffix of the numbers. It may contain placeholders such as %(year)s"},
+		"NumberNext": IntegerField{String: "Next Number", Required: true, Default: DefaultValue(int64(1)),
+			Help: "Next number of this sequence. Setting it restarts the sequence."},
+		"NumberIncrement": IntegerField{String: "Step", Required: true, Default: DefaultValue(int64(1))},
+		"Padding": IntegerField{String: "Sequence Size", Required: true, Default: DefaultValue(int64(0)),
+			Help: "Numbers are left padded with zeros to this size"},
+		"UseDateRange": BooleanField{String: "Use Subsequences per Date Range"},
+		"DateRanges":   One2ManyField{String: "Subsequences", RelationModel: dateRange, ReverseFK: "Sequence"},
+		"Company":      Many2OneField{RelationModel: Registry.MustGet("Company")},
+	})
+	sequence.SetDefaultOrder("Name")
+
+	dateRange.AddFields(map[string]FieldDefinition{
+		"DateFrom":   DateField{String: "From", Required: true},
+		"DateTo":     DateField{String: "To", Required: true},
+		"NumberNext": IntegerField{String: "Next Number", Required: true, Default: DefaultValue(int64(1))},
+		"Sequence":   Many2OneField{RelationModel: sequence, Required: true, OnDelete: Cascade, Index: true},
+	})
+	dateRange.SetDefaultOrder("DateFrom")
+
+	sequence.AddMethod("Create",
+		`Create creates the DB sequences of standard sequences.`,
+		func(rc *RecordCollection, data FieldMapper) *RecordCollection {
+			res := rc.Super().Call("Create", data).(RecordSet).Collection()
+			res.resetDBSequence()
+			return res
+		})
+
+	sequence.AddMethod("Write",
+		`Write restarts the DB sequences of standard sequences if their
+		implementation, next number or increment is modified.`,
+		func(rc *RecordCollection, data FieldMapper, fieldsToUnset ...FieldNamer) bool {
+			fMap := data.FieldMap(fieldsToUnset...)
+			args := []interface{}{fMap}
+			for _, f := range fieldsToUnset {
+				args = append(args, f)
+			}
+			_, implChanged := fMap.Get("Implementation", rc.model)
+			_, incrChanged := fMap.Get("NumberIncrement", rc.model)
+			if _, restart := fMap.Get("NumberNext", rc.model); (implChanged || incrChanged) && !restart {
+				// Keep the numbering where the DB sequences left it
+				for _, rec := range rc.Records() {
+					rec.syncNumberNext()
+					for _, dr := range rec.Get("DateRanges").(RecordSet).Collection().Records() {
+						dr.syncNumberNext()
+					}
+				}
+			}
+			res := rc.Super().Call("Write", args...).(bool)
+			if implChanged || incrChanged {
+				for _, rec := range rc.Records() {
+					rec.resetDBSequence()
+					for _, dr := range rec.Get("DateRanges").(RecordSet).Collection().Records() {
+						dr.resetDBSequence()
+					}
+				}
+				return res
+			}
+			if _, ok := fMap.Get("NumberNext", rc.model); ok {
+				for _, rec := range rc.Records() {
+					rec.resetDBSequence()
+				}
+			}
+			return res
+		})
+
+	sequence.AddMethod("Unlink",
+		`Unlink drops the DB sequences of the sequences and of their date ranges.`,
+		func(rc *RecordCollection) int64 {
+			for _, rec := range rc.Records() {
+				rec.dropDBSequence()
+				for _, dr := range rec.Get("DateRanges").(RecordSet).Collection().Records() {
+					dr.dropDBSequence()
+				}
+			}
+			return rc.Super().Call("Unlink").(int64)
+		})
+
+	sequence.AddMethod("Next",
+		`Next returns the next formatted number of this sequence.
+
+		The date of the number, used to select the date range and to replace the
+		placeholders of the prefix and suffix, is given by the 'sequence_date' key
+		of the context, or today in the time zone of the context.`,
+		func(rc *RecordCollection) string {
+			rc.EnsureOne()
+			date := rc.env.context.GetDate("sequence_date")
+			if date.IsZero() {
+				date = dates.TodayIn(rc.env.context.TZ())
+			}
+			if !rc.Get("UseDateRange").(bool) {
+				return rc.formatSequenceNumber(rc.nextSequenceNumber(), date, date)
+			}
+			dr := rc.sequenceDateRange(date)
+			return rc.formatSequenceNumber(dr.nextSequenceNumber(), date, dr.Get("DateFrom").(dates.Date))
+		}).AllowGroup(security.GroupEveryone)
+
+	dateRange.AddMethod("Create",
+		`Create creates the DB sequences of the date ranges of standard sequences.`,
+		func(rc *RecordCollection, data FieldMapper) *RecordCollection {
+			res := rc.Super().Call("Create", data).(RecordSet).Collection()
+			res.resetDBSequence()
+			return res
+		})
+
+	dateRange.AddMethod("Write",
+		`Write restarts the DB sequences of the date ranges if their next number is modified.`,
+		func(rc *RecordCollection, data FieldMapper, fieldsToUnset ...FieldNamer) bool {
+			fMap := data.FieldMap(fieldsToUnset...)
+			args := []interface{}{fMap}
+			for _, f := range fieldsToUnset {
+				args = append(args, f)
+			}
+			res := rc.Super().Call("Write", args...).(bool)
+			if _, ok := fMap.Get("NumberNext", rc.model); ok {
+				for _, rec := range rc.Records() {
+					rec.resetDBSequence()
+				}
+			}
+			return res
+		})
+
+	dateRange.AddMethod("Unlink",
+		`Unlink drops the DB sequences of the date ranges.`,
+		func(rc *RecordCollection) int64 {
+			for _, rec := range rc.Records() {
+				rec.dropDBSequence()
+			}
+			return rc.Super().Call("Unlink").(int64)
+		})
+}
+
+// dbSequenceName returns the name of the DB sequence of this
+// Sequence or SequenceDateRange record.
+func (rc *RecordCollection) dbSequenceName() string {
+	if rc.model.name == "SequenceDateRange" {
+		return fmt.Sprintf("sequence_%03d_%03d", rc.Get("Sequence").(RecordSet).Ids()[0], rc.ids[0])
+	}
+	return fmt.Sprintf("sequence_%03d", rc.ids[0])
+}
+
+// sequenceRecord returns the Sequence of this Sequence or SequenceDateRange record.
+func (rc *RecordCollection) sequenceRecord() *RecordCollection {
+	if rc.model.name == "SequenceDateRange" {
+		return rc.Get("Sequence").(RecordSet).Collection()
+	}
+	return rc
+}
+
+// resetDBSequence (re)creates the DB sequence of this Sequence or
+// SequenceDateRange record so that it starts at its next number.
+// The DB sequence is dropped if the sequence is not standard.
+func (rc *RecordCollection) resetDBSequence() {
+	rc.EnsureOne()
+	rc.dropDBSequence()
+	seq := rc.sequenceRecord()
+	if seq.Get("Implementation").(string) != "standard" {
+		return
+	}
+	adapter := adapters[db.DriverName()]
+	rc.env.cr.Execute(adapter.createSequenceSQL(rc.dbSequenceName(), rc.Get("NumberNext").(int64), seq.Get("NumberIncrement").(int64)))
+}
+
+// syncNumberNext sets the NumberNext field of this standard Sequence or
+// SequenceDateRange record to the next value of its DB sequence.
+func (rc *RecordCollection) syncNumberNext() {
+	rc.EnsureOne()
+	if rc.sequenceRecord().Get("Implementation").(string) != "standard" {
+		return
+	}
+	adapter := adapters[db.DriverName()]
+	var next []int64
+	rc.env.cr.Select(&next, adapter.peekSequenceValueSQL(), rc.dbSequenceName())
+	if len(next) == 0 {
+		return
+	}
+	rc.env.cr.Execute(fmt.Sprintf(`UPDATE %s SET number_next = ? WHERE id = ?`, adapter.quoteTableName(rc.model.tableName)), next[0], rc.ids[0])
+	rc.env.cache.updateEntry(rc.model, rc.ids[0], "number_next", next[0])
+}
+
+// dropDBSequence drops the DB sequence of this Sequence or SequenceDateRange record.
+func (rc *RecordCollection) dropDBSequence() {
+	rc.EnsureOne()
+	rc.env.cr.Execute(adapters[db.DriverName()].dropSequenceSQL(rc.dbSequenceName()))
+}
+
+// nextSequenceNumber returns the next number of this
+// Sequence or SequenceDateRange record.
+func (rc *RecordCollection) nextSequenceNumber() int64 {
+	rc.EnsureOne()
+	seq := rc.sequenceRecord()
+	adapter := adapters[db.DriverName()]
+	var number int64
+	if seq.Get("Implementation").(string) == "standard" {
+		rc.env.cr.Get(&number, adapter.nextSequenceValueSQL(), rc.dbSequenceName())
+		return number
+	}
+	tableName := adapter.quoteTableName(rc.model.tableName)
+	rc.env.cr.Get(&number, fmt.Sprintf(`SELECT number_next FROM %s WHERE id = ? FOR UPDATE`, tableName), rc.ids[0])
+	next := number + seq.Get("NumberIncrement").(int64)
+	rc.env.cr.Execute(fmt.Sprintf(`UPDATE %s SET number_next = ? WHERE id = ?`, tableName), next, rc.ids[0])
+	rc.env.cache.updateEntry(rc.model, rc.ids[0], "number_next", next)
+	return number
+}
+
+// sequenceDateRange returns the SequenceDateRange of this Sequence that
+// includes the given date. A date range for the year of the date is
+// created if there is none.
+func (rc *RecordCollection) sequenceDateRange(date dates.Date) *RecordCollection {
+	drModel := Registry.MustGet("SequenceDateRange")
+	dateRanges := rc.env.Pool(drModel.name).Sudo()
+	dr := dateRanges.Search(drModel.Field("Sequence").Equals(rc.ids[0]).
+		And().Field("DateFrom").LowerOrEqual(date).
+		And().Field("DateTo").GreaterOrEqual(date)).Limit(1)
+	if !dr.IsEmpty() {
+		return dr
+	}
+	dateFrom := dates.Date{Time: time.Date(date.Year(), time.January, 1, 0, 0, 0, 0, time.UTC)}
+	return dateRanges.Call("Create", FieldMap{
+		"Sequence": rc.ids[0],
+		"DateFrom": dateFrom,
+		"DateTo":   dateFrom.AddDate(1, 0, -1),
+	}).(RecordSet).Collection()
+}
+
+// formatSequenceNumber returns the given number formatted with the prefix,
+// suffix and padding of this Sequence. Placeholders are replaced with the
+// values of the given date, and 'range_' placeholders with the values of
+// the given start date of the date range.
+func (rc *RecordCollection) formatSequenceNumber(number int64, date, rangeDate dates.Date) string {
+	now := time.Now().In(rc.env.context.TZ())
+	placeholders := sequencePlaceholders("", date.Time)
+	placeholders = append(placeholders, sequencePlaceholders("range_", rangeDate.Time)...)
+	placeholders = append(placeholders, "%(h24)s", now.Format("15"), "%(h12)s", now.Format("03"),
+		"%(min)s", now.Format("04"), "%(sec)s", now.Format("05"))
+	replacer := strings.NewReplacer(placeholders...)
+	interpolate := func(field string) string {
+		value, _ := rc.Get(field).(string)
+		res := replacer.Replace(value)
+		if strings.Contains(res, "%(") {
+			log.Panic("Invalid placeholder in sequence", "sequence", rc.Get("Name"), "field", field, "value", value)
+		}
+		return res
+	}
+	return fmt.Sprintf("%s%0*d%s", interpolate("Prefix"), int(rc.Get("Padding").(int64)), number, interpolate("Suffix"))
+}
+
+// sequencePlaceholders returns the date placeholders of sequence prefixes
+// and suffixes with the given prefix and their values for the given time,
+// as pairs of old and new strings for a strings.Replacer.
+func sequencePlaceholders(prefix string, t time.Time) []string {
+	_, week := t.ISOWeek()
+	values := map[string]string{
+		"year":    t.Format("2006"),
+		"y":       t.Format("06"),
+		"month":   t.Format("01"),
+		"day":     t.Format("02"),
+		"doy":     fmt.Sprintf("%03d", t.YearDay()),
+		"woy":     fmt.Sprintf("%02d", week),
+		"weekday": fmt.Sprintf("%d", t.Weekday()),
+	}
+	var res []string
+	for key, value := range values {
+		res = append(res, fmt.Sprintf("%%(%s%s)s", prefix, key), value)
+	}
+	return res
+}
+
+// NextSequence returns the next number of the sequence with the given code.
+//
+// If several active sequences have this code, the sequence of the current
+// company is used first, then a sequence without company. Sequences of other
+// companies are never used. This function panics if there is no such sequence.
+func (env Environment) NextSequence(code string) string {
+	seqModel := Registry.MustGet("Sequence")
+	sequences := env.Pool(seqModel.name).Sudo().Search(seqModel.Field("Code").Equals(code))
+	var res *RecordCollection
+	company := env.context.CompanyID()
+	for _, seq := range sequences.Records() {
+		seqCompany := seq.Get("Company").(RecordSet)
+		switch {
+		case company != 0 && !seqCompany.IsEmpty() && seqCompany.Ids()[0] == company:
+			return seq.Call("Next").(string)
+		case res == nil && seqCompany.IsEmpty():
+			res = seq
+		}
+	}
+	if res == nil {
+		log.Panic("Unknown sequence code", "code", code, "company", company)
+	}
+	return res.Call("Next").(string)
+}
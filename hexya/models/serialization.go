@@ -0,0 +1,71 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"fmt"
+
+	"github.com/hexya-erp/hexya/hexya/models/types"
+)
+
+// A RecordRef is a serializable reference to the records of a RecordCollection.
+//
+// It holds the model name, the ids and the context of the RecordCollection so
+// that it can be passed to another process, for instance as a job argument, and
+// be turned back into a RecordCollection with Rehydrate. The user is deliberately
+// not part of the reference: records are always rehydrated with the user of the
+// target Environment.
+//
+// RecordRef marshals to JSON with stable key ordering.
+type RecordRef struct {
+	Model   string         `json:"model"`
+	IDs     []int64        `json:"ids"`
+	Context *types.Context `json:"context,omitempty"`
+}
+
+// Ref returns a serializable reference to the records of this RecordCollection
+func (rc *RecordCollection) Ref() RecordRef {
+	return RecordRef{
+		Model:   rc.model.name,
+		IDs:     rc.Ids(),
+		Context: rc.env.context.Copy(),
+	}
+}
+
+// Rehydrate returns a RecordCollection of the referenced records in the given
+// Environment with the context of this RecordRef.
+//
+// Records that do not exist anymore in the database are silently dropped, so
+// that a RecordRef can safely be rehydrated long after it has been created.
+// Rehydrate panics if the model of this RecordRef does not exist.
+func (rr RecordRef) Rehydrate(env Environment) *RecordCollection {
+	model := Registry.MustGet(rr.Model)
+	ctx := rr.Context
+	if ctx == nil {
+		ctx = types.NewContext()
+	}
+	rc := env.Pool(model.name).WithNewContext(ctx)
+	if len(rr.IDs) == 0 {
+		return rc
+	}
+	var existingIds []int64
+	query := fmt.Sprintf(`SELECT id FROM %s WHERE id IN (?)`, adapters[db.DriverName()].quoteTableName(model.tableName))
+	env.cr.Select(&existingIds, query, rr.IDs)
+	existing := make(map[int64]bool, len(existingIds))
+	for _, id := range existingIds {
+		existing[id] = true
+	}
+	var ids []int64
+	for _, id := range rr.IDs {
+		if existing[id] {
+			ids = append(ids, id)
+		}
+	}
+	return rc.withIds(ids)
+}
+
+// String returns a human readable version of this RecordRef
+func (rr RecordRef) String() string {
+	return fmt.Sprintf("%s%v", rr.Model, rr.IDs)
+}
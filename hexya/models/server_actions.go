@@ -0,0 +1,213 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+
+	"github.com/hexya-erp/hexya/hexya/models/types"
+	"github.com/hexya-erp/hexya/hexya/tools/expr"
+)
+
+// Server action types
+const (
+	serverActionMethod     = "method"
+	serverActionWrite      = "write"
+	serverActionExpression = "expression"
+)
+
+// assignmentRegexp matches the 'Field = expression' lines of the
+// scripts of expression server actions.
+var assignmentRegexp = regexp.MustCompile(`^\s*(\w+)\s*=([^=].*)$`)
+
+// A fieldAssignment is a line of the script of an expression
+// server action, which sets a field to the value of an expression.
+type fieldAssignment struct {
+	field      string
+	expression string
+}
+
+// declareServerActionModel creates the ServerAction model.
+//
+// A ServerAction is a configurable operation on records of a model: calling a
+// method, writing fixed values or writing values computed by expressions. It
+// lets admins automate operations without recompiling modules. Actions can be
+// run manually, by automated rules or by cron jobs.
+//
+// Server actions can only be accessed by the admin.
+func declareServerActionModel() {
+	serverAction := NewModel("ServerAction")
+
+	serverAction.AddMethod("CheckAction",
+		`CheckAction checks that the model of this action exists and
+		that the parameters of its type are valid.`,
+		func(rc *RecordCollection) {
+			model, ok := Registry.Get(rc.Get("Model").(string))
+			if !ok {
+				log.Panic("Unknown model in server action", "action", rc.Get("Name"), "model", rc.Get("Model"))
+			}
+			switch rc.Get("ActionType").(string) {
+			case serverActionMethod:
+				if _, ok := model.methods.get(rc.Get("Method").(string)); !ok {
+					log.Panic("Unknown method in server action", "action", rc.Get("Name"), "model", model.name, "method", rc.Get("Method"))
+				}
+			case serverActionWrite:
+				rc.serverActionValues(model)
+			case serverActionExpression:
+				for _, assignment := range rc.serverActionScript() {
+					model.fields.MustGet(assignment.field)
+					if _, err := expr.Parse(assignment.expression); err != nil {
+						log.Panic("Invalid expression in server action", "action", rc.Get("Name"), "field", assignment.field, "error", err)
+					}
+				}
+			}
+			if filter := strings.TrimSpace(rc.Get("Filter").(string)); filter != "" {
+				if _, err := expr.Parse(filter); err != nil {
+					log.Panic("Invalid filter in server action", "action", rc.Get("Name"), "error", err)
+				}
+			}
+		})
+
+	serverAction.AddFields(map[string]FieldDefinition{
+		"Name":  CharField{Required: true},
+		"Model": CharField{Required: true, Index: true, Constraint: serverAction.methods.MustGet("CheckAction")},
+		"ActionType": SelectionField{String: "Action To Do", Required: true, Default: DefaultValue(serverActionMethod),
+			Constraint: serverAction.methods.MustGet("CheckAction"),
+			Selection: types.Selection{
+				serverActionMethod:     "Call a Method",
+				serverActionWrite:      "Update Fields",
+				serverActionExpression: "Compute Fields",
+			}},
+		"Method": CharField{Constraint: serverAction.methods.MustGet("CheckAction"),
+			Help: "Name of the method called on the records"},
+		"Arguments": TextField{
+			Help: "JSON array of the arguments of the method, e.g. [\"draft\", 30]"},
+		"Values": TextField{Constraint: serverAction.methods.MustGet("CheckAction"),
+			Help: "JSON object of the values written on the records, e.g. {\"State\": \"done\"}"},
+		"Script": TextField{Constraint: serverAction.methods.MustGet("CheckAction"),
+			Help: "One 'Field = expression' line per field to write, the expression being evaluated on each record"},
+		"Filter": TextField{Constraint: serverAction.methods.MustGet("CheckAction"),
+			Help: "Expression selecting the records on which the action is run when it is not given records, e.g. by a cron job"},
+		"Sequence": IntegerField{Default: DefaultValue(int64(10))},
+	})
+	serverAction.SetDefaultOrder("Sequence", "ID")
+
+	serverAction.AddMethod("Run",
+		`Run executes this action on the given records, which must belong
+		to the model of the action, as the current user.`,
+		func(rc *RecordCollection, records RecordSet) {
+			rc.EnsureOne()
+			recs := records.Collection()
+			if recs.ModelName() != rc.Get("Model").(string) {
+				log.Panic("Server action run on records of another model", "action", rc.Get("Name"),
+					"model", rc.Get("Model"), "records", recs.ModelName())
+			}
+			rc.runServerAction(recs.WithEnv(rc.env))
+		})
+
+	serverAction.AddMethod("RunFiltered",
+		`RunFiltered executes this action on the records of its model for
+		which its filter is true, or on all of them if it has no filter.`,
+		func(rc *RecordCollection) {
+			rc.EnsureOne()
+			records := rc.env.Pool(rc.Get("Model").(string)).SearchAll()
+			if filter := strings.TrimSpace(rc.Get("Filter").(string)); filter != "" {
+				var ids []int64
+				for _, rec := range records.Records() {
+					if rec.EvaluateCondition(filter) {
+						ids = append(ids, rec.ids[0])
+					}
+				}
+				records = rc.env.Pool(records.ModelName()).withIds(ids)
+			}
+			if records.IsEmpty() {
+				return
+			}
+			rc.runServerAction(records)
+		})
+
+	serverAction.AddMethod("RunByID",
+		`RunByID executes the action with the given id on the records selected
+		by its filter. It is intended to be called by cron jobs, with the Model
+		'ServerAction', the Method 'RunByID' and the id as Arguments.`,
+		func(rc *RecordCollection, id int64) {
+			action := rc.env.Pool(serverAction.name).Search(serverAction.Field("ID").Equals(id))
+			if action.IsEmpty() {
+				log.Panic("Unknown server action", "id", id)
+			}
+			action.Call("RunFiltered")
+		})
+}
+
+// runServerAction executes this server action on the given records
+func (rc *RecordCollection) runServerAction(records *RecordCollection) {
+	switch rc.Get("ActionType").(string) {
+	case serverActionMethod:
+		method := rc.Get("Method").(string)
+		args := cronJobArguments(records.MethodType(method), rc.Get("Arguments").(string))
+		records.Call(method, args...)
+	case serverActionWrite:
+		records.Call("Write", rc.serverActionValues(records.model))
+	case serverActionExpression:
+		script := rc.serverActionScript()
+		for _, rec := range records.Records() {
+			values := make(FieldMap)
+			for _, assignment := range script {
+				values[assignment.field] = rec.Evaluate(assignment.expression)
+			}
+			rec.Call("Write", values)
+		}
+	}
+}
+
+// serverActionValues returns the values written by this
+// write server action on the records of the given model.
+func (rc *RecordCollection) serverActionValues(model *Model) FieldMap {
+	var raw map[string]interface{}
+	if err := json.Unmarshal([]byte(rc.Get("Values").(string)), &raw); err != nil {
+		log.Panic("Server action values must be a JSON object", "action", rc.Get("Name"), "error", err)
+	}
+	res := make(FieldMap)
+	for field, value := range raw {
+		fi := model.fields.MustGet(field)
+		res[fi.json] = jsonFieldValue(fi, value)
+	}
+	model.convertValuesToFieldType(&res)
+	return res
+}
+
+// serverActionScript returns the field assignments of the
+// script of this expression server action.
+func (rc *RecordCollection) serverActionScript() []fieldAssignment {
+	var res []fieldAssignment
+	for _, line := range strings.Split(rc.Get("Script").(string), "\n") {
+		if line = strings.TrimSpace(line); line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		match := assignmentRegexp.FindStringSubmatch(line)
+		if match == nil {
+			log.Panic("Server action script lines must be 'Field = expression'", "action", rc.Get("Name"), "line", line)
+		}
+		res = append(res, fieldAssignment{field: match[1], expression: strings.TrimSpace(match[2])})
+	}
+	return res
+}
+
+// jsonFieldValue returns the given JSON decoded value of the given field so
+// that it can be converted to the field type. JSON numbers are decoded as
+// floats, so that lists of ids of relation fields must be converted.
+func jsonFieldValue(fi *Field, value interface{}) interface{} {
+	list, ok := value.([]interface{})
+	if !ok || !fi.isRelationField() {
+		return value
+	}
+	ids := make([]int64, len(list))
+	for i, id := range list {
+		fID, _ := id.(float64)
+		ids[i] = int64(fID)
+	}
+	return ids
+}
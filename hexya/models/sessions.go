@@ -0,0 +1,72 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"encoding/base64"
+	"fmt"
+	"time"
+
+	"github.com/hexya-erp/hexya/hexya/models/types/dates"
+)
+
+// declareSessionModel creates the Session system model which stores
+// the data of the HTTP sessions kept in the database.
+func declareSessionModel() {
+	session := createModel("Session", SystemModel)
+	session.InheritModel(Registry.MustGet("CommonMixin"))
+	session.AddFields(map[string]FieldDefinition{
+		"SID":            CharField{String: "Session ID", Required: true},
+		"Data":           TextField{Help: "Base64 encoded data of the session"},
+		"ExpirationDate": DateTimeField{Required: true, Index: true},
+	})
+	session.AddSQLConstraint("unique_sid", "UNIQUE (sid)",
+		"Session IDs must be unique")
+}
+
+// sessionTableName returns the quoted name of the table of the Session model
+func sessionTableName() string {
+	return adapters[db.DriverName()].quoteTableName(Registry.MustGet("Session").tableName)
+}
+
+// LoadSession returns the data of the HTTP session with the given id,
+// or nil if there is no such session or if it has expired.
+func (env Environment) LoadSession(sid string) []byte {
+	var values []string
+	env.cr.Select(&values, fmt.Sprintf(`SELECT data FROM %s WHERE sid = ? AND expiration_date > ?`, sessionTableName()),
+		sid, dates.Now())
+	if len(values) == 0 {
+		return nil
+	}
+	data, err := base64.StdEncoding.DecodeString(values[0])
+	if err != nil {
+		log.Panic("Unable to decode session data", "error", err)
+	}
+	return data
+}
+
+// SaveSession stores the given data of the HTTP session with
+// the given id, which expires after the given duration.
+func (env Environment) SaveSession(sid string, data []byte, maxAge time.Duration) {
+	env.cr.Execute(fmt.Sprintf(`INSERT INTO %s (sid, data, expiration_date) VALUES (?, ?, ?)
+		ON CONFLICT (sid) DO UPDATE SET data = EXCLUDED.data, expiration_date = EXCLUDED.expiration_date`, sessionTableName()),
+		sid, base64.StdEncoding.EncodeToString(data), dates.Now().Add(maxAge))
+}
+
+// DeleteSession deletes the HTTP session with the given id.
+// It is a no-op if there is no such session.
+func (env Environment) DeleteSession(sid string) {
+	env.cr.Execute(fmt.Sprintf(`DELETE FROM %s WHERE sid = ?`, sessionTableName()), sid)
+}
+
+// DeleteExpiredSessions deletes the expired HTTP sessions
+// and returns the number of deleted sessions.
+func (env Environment) DeleteExpiredSessions() int64 {
+	res := env.cr.Execute(fmt.Sprintf(`DELETE FROM %s WHERE expiration_date <= ?`, sessionTableName()), dates.Now())
+	count, err := res.RowsAffected()
+	if err != nil {
+		log.Panic("Unable to get the number of deleted sessions", "error", err)
+	}
+	return count
+}
@@ -0,0 +1,257 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hexya-erp/hexya/hexya/models/types"
+	"github.com/hexya-erp/hexya/hexya/models/types/dates"
+)
+
+// Share access levels
+const (
+	// ShareRead grants read-only access to the shared record
+	ShareRead = "read"
+	// ShareComment grants read access to the shared record
+	// and to its comments, and allows to post comments.
+	ShareComment = "comment"
+)
+
+// ShareDuration is the default validity of share tokens
+var ShareDuration = 30 * 24 * time.Hour
+
+// ShareURL returns the URL at which the record shared by the given token can
+// be accessed without user account. It can be overridden by the module
+// serving shared records.
+var ShareURL = func(token string) string {
+	return BaseURL() + "/share/" + token
+}
+
+// Key of the secret signing the share tokens in the key/value store
+const (
+	shareSecretNamespace = "hexya"
+	shareSecretKey       = "share_secret"
+)
+
+// declareShareModel creates the Share model.
+//
+// A Share grants access to a single record to anyone holding its token,
+// without user account, such as a customer reading a quote. Tokens are
+// signed with a secret of the database and expire at the expiration date
+// of the share. A share can be revoked before it expires by archiving it.
+//
+// Shared records are read as the user who shared them, so that a share never
+// grants more than the access rights of this user. Shares can only be
+// accessed by the admin: users create them with RecordCollection.Share.
+func declareShareModel() {
+	share := NewModel("Share")
+	share.AddFields(map[string]FieldDefinition{
+		"ResModel": CharField{String: "Resource Model", Required: true, Index: true},
+		"ResID":    IntegerField{String: "Resource ID", BigInt: true, Required: true, Index: true},
+		"Access": SelectionField{Required: true, Default: DefaultValue(ShareRead),
+			Selection: types.Selection{ShareRead: "Read", ShareComment: "Comment"}},
+		"SharedFields": CharField{
+			Help: "Comma separated list of the fields of the record that are shared. All stored fields if empty."},
+		"UserID": IntegerField{String: "User ID", Required: true, Index: true,
+			Help: "ID of the user who shared the record, as whom it is read"},
+		"ExpirationDate": DateTimeField{Required: true, Index: true},
+		"Active":         BooleanField{Default: DefaultValue(true)},
+		"AccessCount":    IntegerField{NoCopy: true, Help: "Number of times the shared record has been accessed"},
+		"LastAccess":     DateTimeField{NoCopy: true},
+	})
+	share.SetDefaultOrder("ID desc")
+
+	share.AddMethod("Token",
+		`Token returns the signed token granting access to the record of this share.`,
+		func(rc *RecordCollection) string {
+			rc.EnsureOne()
+			expiration := rc.Get("ExpirationDate").(dates.DateTime).Unix()
+			payload := fmt.Sprintf("%d.%d", rc.ids[0], expiration)
+			return payload + "." + rc.env.signShare(payload, rc.Get("Access").(string))
+		})
+
+	share.AddMethod("URL",
+		`URL returns the URL at which the record of this share can be accessed.`,
+		func(rc *RecordCollection) string {
+			return ShareURL(rc.Call("Token").(string))
+		})
+
+	share.AddMethod("Revoke",
+		`Revoke invalidates the tokens of this RecordSet's shares.`,
+		func(rc *RecordCollection) {
+			rc.Call("Write", FieldMap{"Active": false})
+		})
+}
+
+// Share creates a share of the record of this RecordCollection with the given
+// access, ShareRead or ShareComment, restricted to the given fields if any,
+// and returns the Share record. The share expires after ShareDuration.
+//
+// The current user must be allowed to read the record.
+func (rc *RecordCollection) Share(access string, fields ...string) *RecordCollection {
+	rc.EnsureOne()
+	if access != ShareRead && access != ShareComment {
+		log.Panic("Unknown share access", "access", access)
+	}
+	checkLinkedRecordsAccess(rc.env, map[string][]int64{rc.model.name: rc.Ids()}, "shares")
+	for _, field := range fields {
+		rc.model.fields.MustGet(field)
+	}
+	share := rc.env.Pool("Share").Sudo().Call("Create", FieldMap{
+		"ResModel":       rc.model.name,
+		"ResID":          rc.ids[0],
+		"Access":         access,
+		"SharedFields":   strings.Join(fields, ","),
+		"UserID":         rc.env.uid,
+		"ExpirationDate": dates.Now().Add(ShareDuration),
+	}).(RecordSet).Collection()
+	return rc.env.Pool("Share").Sudo().withIds(share.Ids())
+}
+
+// signShare returns the signature of the given payload of
+// a share token with the given access.
+func (env Environment) signShare(payload, access string) string {
+	mac := hmac.New(sha256.New, env.shareSecret())
+	mac.Write([]byte(payload + "." + access))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// shareSecret returns the key with which share tokens are signed.
+// It is generated at its first use and stored in the key/value store,
+// so that it is shared by all the servers of the database.
+func (env Environment) shareSecret() []byte {
+	store := env.KVStore(shareSecretNamespace)
+	if secret := store.GetString(shareSecretKey, ""); secret != "" {
+		return []byte(secret)
+	}
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		log.Panic("Unable to generate the share secret", "error", err)
+	}
+	if !store.CompareAndSet(shareSecretKey, hex.EncodeToString(buf), 0) {
+		// Another transaction has just created the secret
+		return []byte(store.GetString(shareSecretKey, ""))
+	}
+	return []byte(hex.EncodeToString(buf))
+}
+
+// shareFromToken returns the active Share record granted by the
+// given token, as superuser, or nil if the token is invalid or
+// expired or if the share has been revoked.
+func (env Environment) shareFromToken(token string) *RecordCollection {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil
+	}
+	id, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return nil
+	}
+	expiration, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || time.Now().Unix() > expiration {
+		return nil
+	}
+	shareModel := Registry.MustGet("Share")
+	share := env.Pool(shareModel.name).Sudo().Search(shareModel.Field("ID").Equals(id).
+		And().Field("Active").Equals(true))
+	if share.IsEmpty() || share.Get("ExpirationDate").(dates.DateTime).Unix() != expiration {
+		return nil
+	}
+	expected := env.signShare(parts[0]+"."+parts[1], share.Get("Access").(string))
+	if !hmac.Equal([]byte(parts[2]), []byte(expected)) {
+		return nil
+	}
+	return share
+}
+
+// sharedRecord returns the record of the given share, in an
+// Environment of the user who shared it.
+func (rc *RecordCollection) sharedRecord() *RecordCollection {
+	records := rc.env.Pool(rc.Get("ResModel").(string)).Sudo(rc.Get("UserID").(int64))
+	return records.withIds([]int64{rc.Get("ResID").(int64)})
+}
+
+// A SharedRecord is the data of a record accessed with a share token
+type SharedRecord struct {
+	Model          string         `json:"model"`
+	ID             int64          `json:"id"`
+	Access         string         `json:"access"`
+	ExpirationDate dates.DateTime `json:"expiration_date"`
+	Record         FieldMap       `json:"record"`
+	Messages       []FieldMap     `json:"messages,omitempty"`
+}
+
+// ReadSharedRecord returns the data of the record shared by the given token.
+// The comments posted on the record are included if the share grants the
+// ShareComment access. The second returned value is false if the token is
+// invalid or expired or if the share has been revoked.
+//
+// It panics if the user who shared the record cannot read it anymore.
+func (env Environment) ReadSharedRecord(token string) (SharedRecord, bool) {
+	share := env.shareFromToken(token)
+	if share == nil {
+		return SharedRecord{}, false
+	}
+	share.Call("Write", FieldMap{
+		"AccessCount": share.Get("AccessCount").(int64) + 1,
+		"LastAccess":  dates.Now(),
+	})
+	record := share.sharedRecord()
+	var fields []string
+	if sf := share.Get("SharedFields").(string); sf != "" {
+		fields = strings.Split(sf, ",")
+	}
+	data := record.Call("Read", fields).([]FieldMap)
+	if len(data) == 0 {
+		log.Panic("Shared record does not exist anymore", "model", record.model.name, "id", record.ids[0])
+	}
+	res := SharedRecord{
+		Model:          record.model.name,
+		ID:             record.ids[0],
+		Access:         share.Get("Access").(string),
+		ExpirationDate: share.Get("ExpirationDate").(dates.DateTime),
+		Record:         data[0],
+	}
+	if res.Access == ShareComment {
+		messageModel := Registry.MustGet("Message")
+		messages := record.env.Pool(messageModel.name).Search(messageModel.Field("ResModel").Equals(res.Model).
+			And().Field("ResID").Equals(res.ID).
+			And().Field("MessageType").Equals(MessageComment))
+		res.Messages = messages.Call("Read", []string{"Body", "Date"}).([]FieldMap)
+	}
+	return res, true
+}
+
+// CommentSharedRecord posts a comment with the given body on the record shared
+// by the given token, on behalf of the given author name, and returns the new
+// message. The second returned value is false if the token is invalid or
+// expired, if the share has been revoked or if it does not grant the
+// ShareComment access.
+func (env Environment) CommentSharedRecord(token, author, body string) (*RecordCollection, bool) {
+	share := env.shareFromToken(token)
+	if share == nil || share.Get("Access").(string) != ShareComment {
+		return nil, false
+	}
+	record := share.sharedRecord()
+	checkLinkedRecordsAccess(record.env, map[string][]int64{record.model.name: record.ids}, "shares")
+	if author != "" {
+		body = fmt.Sprintf("%s: %s", author, body)
+	}
+	message := env.postMessage(FieldMap{
+		"ResModel":    record.model.name,
+		"ResID":       record.ids[0],
+		"Body":        body,
+		"MessageType": MessageComment,
+		"AuthorUID":   int64(0),
+	}, nil)
+	return message, true
+}
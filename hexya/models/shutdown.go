@@ -0,0 +1,57 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// transactionsPollInterval is the interval at which WaitForTransactions
+// checks whether the running transactions have finished.
+var transactionsPollInterval = 10 * time.Millisecond
+
+var (
+	// runningTransactions is the number of environments being executed
+	runningTransactions int64
+	// processingStopped is 1 once StopProcessing has been called
+	processingStopped int32
+)
+
+// StopProcessing makes the functions that process queues of background work,
+// such as ProcessCronJobs or ProcessMailQueue, return after the item they are
+// processing instead of claiming the next one. It is called when the server
+// shuts down.
+func StopProcessing() {
+	atomic.StoreInt32(&processingStopped, 1)
+}
+
+// ProcessingStopped returns true if StopProcessing has been called.
+// Workers that process queues should stop claiming items once it is true.
+func ProcessingStopped() bool {
+	return atomic.LoadInt32(&processingStopped) == 1
+}
+
+// RunningTransactions returns the number of environments of this
+// process that are being executed.
+func RunningTransactions() int {
+	return int(atomic.LoadInt64(&runningTransactions))
+}
+
+// WaitForTransactions waits until all the environments of this process have
+// been committed or rolled back. It returns the error of ctx if it is done
+// before.
+func WaitForTransactions(ctx context.Context) error {
+	ticker := time.NewTicker(transactionsPollInterval)
+	defer ticker.Stop()
+	for RunningTransactions() > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// SchemaSnapshot returns the SQL statements that create the table of this
+// model from scratch, with its columns, constraints and indexes.
+//
+// Statements are written one per line in a deterministic order so that the
+// result can be compared with a golden file in tests: any change of the
+// schema generated for the model then shows in the diff of the golden file.
+//
+// It panics if the model has no table, i.e. if it is a mixin or manual model.
+func (m *Model) SchemaSnapshot() string {
+	if m.isMixin() || m.isManual() {
+		log.Panic("Only models with a table have a schema", "model", m.name)
+	}
+	var (
+		columns, fks, indexes []string
+		buf                   bytes.Buffer
+	)
+	for colName, fi := range m.fields.registryByJSON {
+		if colName == "id" || !fi.isStored() {
+			continue
+		}
+		columns = append(columns, addColumnSQL(fi))
+		if fi.fieldType.IsFKRelationType() {
+			fks = append(fks, addConstraintSQL(m.tableName, fmt.Sprintf("%s_%s_fkey", m.tableName, colName),
+				fkConstraintSQL(colName, fi.relatedModel.tableName, string(fi.onDelete))))
+		}
+		if fi.index {
+			indexes = append(indexes, columnIndexSQL(m.tableName, colName))
+		}
+	}
+	var constraints []string
+	for constraintName, constraint := range m.sqlConstraints {
+		constraints = append(constraints, addConstraintSQL(m.tableName, constraintName, constraint.sql))
+	}
+	for _, constraint := range m.uniqueConstraints {
+		constraints = append(constraints, uniqueIndexSQL(m, constraint))
+	}
+	for _, index := range m.indexes {
+		indexes = append(indexes, indexSQL(m, index))
+	}
+	fmt.Fprintf(&buf, "%s;\n", createTableSQL(m))
+	for _, stmts := range [][]string{columns, fks, constraints, indexes} {
+		sort.Strings(stmts)
+		for _, stmt := range stmts {
+			fmt.Fprintf(&buf, "%s;\n", stmt)
+		}
+	}
+	return buf.String()
+}
+
+// SQLSnapshot returns the SQL query that loads the given fields of the records
+// of this RecordCollection, followed by one comment line per query argument.
+// If no fields are given, only the ID is loaded.
+//
+// Whitespace is normalized in the query so that the result can be compared
+// with a golden file in tests and changes of the query builder are reviewed
+// explicitly.
+func (rc *RecordCollection) SQLSnapshot(fields ...string) string {
+	if len(fields) == 0 {
+		fields = []string{"ID"}
+	}
+	sql, args := rc.query.selectQuery(fields)
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s\n", strings.Join(strings.Fields(sql), " "))
+	for i, arg := range args {
+		fmt.Fprintf(&buf, "-- $%d: %s\n", i+1, snapshotArg(arg))
+	}
+	return buf.String()
+}
+
+// snapshotArg returns the given query argument as it
+// is written in SQL snapshots: strings are quoted.
+func snapshotArg(arg interface{}) string {
+	if str, ok := arg.(string); ok {
+		return fmt.Sprintf("%q", str)
+	}
+	return fmt.Sprint(arg)
+}
+
+// DataSnapshot returns the given fields of the records of this RecordCollection
+// in CSV, as Export writes them, so that the result of representative queries
+// can be compared with a golden file in tests.
+//
+// The records should be ordered explicitly for the result to be deterministic.
+func (rc *RecordCollection) DataSnapshot(fields ...string) string {
+	var buf bytes.Buffer
+	rc.Export(&buf, fields, ExportCSV)
+	return buf.String()
+}
@@ -0,0 +1,90 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"github.com/hexya-erp/hexya/hexya/models/security"
+)
+
+// A StageGroup holds the data of a stage column of a kanban view
+type StageGroup struct {
+	ID    int64  `json:"id"`
+	Name  string `json:"name"`
+	Fold  bool   `json:"fold"`
+	Count int    `json:"count"`
+}
+
+// declareStageModel creates the Stage model and the StageMixin.
+//
+// Models that inherit StageMixin get a Stage field which is set
+// by default to the first applicable stage by sequence.
+func declareStageModel() {
+	stage := NewModel("Stage")
+	stage.AddFields(map[string]FieldDefinition{
+		"Name":     CharField{Required: true, Translate: true},
+		"Sequence": IntegerField{Default: DefaultValue(10)},
+		"Fold": BooleanField{String: "Folded in Kanban",
+			Help: "This stage is folded in the kanban view when there are no records in that stage to display."},
+		"ResModel": CharField{String: "Model", Index: true,
+			Help: "Name of the model this stage applies to. If empty, this stage applies to all models."},
+	})
+	stage.SetDefaultOrder("Sequence", "ID")
+
+	stageMixin := NewMixinModel("StageMixin")
+	stageMixin.AddFields(map[string]FieldDefinition{
+		"Stage": Many2OneField{RelationModel: stage, Index: true, OnDelete: Restrict},
+	})
+
+	stageMixin.AddMethod("StagesCondition",
+		`StagesCondition returns the condition on the Stage model to get the stages
+		applicable to this RecordSet.
+
+		Override this method to restrict the available stages, for instance to
+		provide per team stages.`,
+		func(rc *RecordCollection) *Condition {
+			return stage.Field("ResModel").Equals(rc.ModelName()).
+				Or().Field("ResModel").IsNull().
+				Or().Field("ResModel").Equals("")
+		}).AllowGroup(security.GroupEveryone)
+
+	stageMixin.AddMethod("DefaultStage",
+		`DefaultStage returns the stage to set on new records, which is
+		the first applicable stage by sequence.`,
+		func(rc *RecordCollection) *RecordCollection {
+			cond := rc.Call("StagesCondition").(*Condition)
+			return rc.Env().Pool(stage.name).Call("Search", cond).(RecordSet).Collection().Limit(1).Fetch()
+		}).AllowGroup(security.GroupEveryone)
+
+	stageMixin.AddMethod("Create",
+		`Create sets the default stage on the new record if it is not given in data.`,
+		func(rc *RecordCollection, data FieldMapper) *RecordCollection {
+			fMap := data.FieldMap()
+			if _, exists := fMap.Get("Stage", rc.model); !exists {
+				if defStage := rc.Call("DefaultStage").(RecordSet).Collection(); !defStage.IsEmpty() {
+					fMap.Set("Stage", defStage.Ids()[0], rc.model)
+				}
+			}
+			return rc.Super().Call("Create", fMap).(RecordSet).Collection()
+		})
+
+	stageMixin.AddMethod("ReadStageGroups",
+		`ReadStageGroups returns the kanban columns of this RecordSet, that is all
+		applicable stages, including those without records, with the number of records
+		of this RecordSet in each of them.`,
+		func(rc *RecordCollection) []StageGroup {
+			cond := rc.Call("StagesCondition").(*Condition)
+			stages := rc.Env().Pool(stage.name).Call("Search", cond).(RecordSet).Collection()
+			var res []StageGroup
+			for _, stg := range stages.Records() {
+				count := rc.Search(rc.Model().Field("Stage").Equals(stg.Ids()[0])).SearchCount()
+				res = append(res, StageGroup{
+					ID:    stg.Ids()[0],
+					Name:  stg.Get("Name").(string),
+					Fold:  stg.Get("Fold").(bool),
+					Count: count,
+				})
+			}
+			return res
+		}).AllowGroup(security.GroupEveryone)
+}
@@ -18,6 +18,7 @@ import (
 	"fmt"
 	"testing"
 
+	"github.com/hexya-erp/hexya/hexya/models/fieldtype"
 	"github.com/hexya-erp/hexya/hexya/models/security"
 	"github.com/hexya-erp/hexya/hexya/models/types"
 	"github.com/hexya-erp/hexya/hexya/models/types/dates"
@@ -35,6 +36,8 @@ func TestModelDeclaration(t *testing.T) {
 		addressMI := NewMixinModel("AddressMixIn")
 		activeMI := NewMixinModel("ActiveMixIn")
 		viewModel := NewManualModel("UserView")
+		ledgerEntry := NewImmutableModel("LedgerEntry")
+		note := NewModel("Note")
 
 		user.AddMethod("PrefixedUser", "",
 			func(rc *RecordCollection, prefix string) []string {
@@ -222,6 +225,21 @@ func TestModelDeclaration(t *testing.T) {
 				}
 			})
 
+		tag.AddMethod("ComputeRatedChildren",
+			`ComputeRatedChildren returns the children of this tag which have a rate`,
+			func(rc *RecordCollection) FieldMap {
+				children := rc.Get("Children").(RecordSet).Collection()
+				return FieldMap{"RatedChildren": children.Filtered(func(rs RecordSet) bool {
+					return rs.Collection().Get("Rate").(float32) > 0
+				})}
+			})
+
+		tag.AddMethod("CreateRatedTag",
+			`CreateRatedTag creates a tag with the given name and rate`,
+			func(rc *RecordCollection, name string, rate float32) {
+				rc.Call("Create", FieldMap{"Name": name, "Rate": rate})
+			})
+
 		tag.methods.AllowAllToGroup(security.GroupEveryone)
 		tag.methods.RevokeAllFromGroup(security.GroupEveryone)
 		tag.methods.AllowAllToGroup(security.GroupEveryone)
@@ -241,17 +259,21 @@ func TestModelDeclaration(t *testing.T) {
 			"Age": IntegerField{Compute: user.Methods().MustGet("ComputeAge"),
 				Inverse: user.Methods().MustGet("InverseSetAge"),
 				Depends: []string{"Profile", "Profile.Age"}, Stored: true, GoType: new(int16)},
-			"Posts":     One2ManyField{RelationModel: Registry.MustGet("Post"), ReverseFK: "User"},
-			"PMoney":    FloatField{Related: "Profile.Money"},
-			"LastPost":  Many2OneField{RelationModel: Registry.MustGet("Post")},
-			"Resume":    Many2OneField{RelationModel: Registry.MustGet("Resume"), Embed: true},
-			"Email2":    CharField{},
-			"IsPremium": BooleanField{},
-			"Nums":      IntegerField{GoType: new(int)},
-			"Size":      FloatField{},
+			"Posts":      One2ManyField{RelationModel: Registry.MustGet("Post"), ReverseFK: "User"},
+			"PostsCount": CountField{One2Many: "Posts"},
+			"PMoney":     FloatField{Related: "Profile.Money"},
+			"LastPost":   Many2OneField{RelationModel: Registry.MustGet("Post")},
+			"Resume":     Many2OneField{RelationModel: Registry.MustGet("Resume"), Embed: true},
+			"Email2":     CharField{},
+			"IsPremium":  BooleanField{},
+			"Nums":       IntegerField{GoType: new(int)},
+			"Size":       FloatField{},
+			"VisiblePosts": One2ManyField{RelationModel: Registry.MustGet("Post"), ReverseFK: "User",
+				Filter: Registry.MustGet("Post").Field("Visibility").Equals("visible")},
 		})
 		user.AddSQLConstraint("nums_premium", "CHECK((is_premium = TRUE AND nums > 0) OR (IS_PREMIUM = false))",
 			"Premium users must have positive nums")
+		user.EnableFuzzySearch()
 
 		profile.AddFields(map[string]FieldDefinition{
 			"Age":      IntegerField{GoType: new(int16)},
@@ -261,15 +283,18 @@ func TestModelDeclaration(t *testing.T) {
 			"BestPost": One2OneField{RelationModel: Registry.MustGet("Post")},
 			"City":     CharField{},
 			"Country":  CharField{},
+			"Currency": Many2OneField{RelationModel: Registry.MustGet("Currency")},
+			"Balance":  MonetaryField{Digits: nbutils.Digits{Precision: 16, Scale: 2}},
+			"Discount": FloatField{CompanyDependent: true},
 		})
 
 		post.AddFields(map[string]FieldDefinition{
 			"User":            Many2OneField{RelationModel: Registry.MustGet("User")},
-			"Title":           CharField{Required: true},
+			"Title":           CharField{Required: true, Translate: true},
 			"Content":         HTMLField{Required: true},
 			"Tags":            Many2ManyField{RelationModel: Registry.MustGet("Tag")},
 			"BestPostProfile": Rev2OneField{RelationModel: Registry.MustGet("Profile"), ReverseFK: "BestPost"},
-			"Abstract":        TextField{},
+			"Abstract":        TextField{FullText: "english"},
 			"Attachment":      BinaryField{},
 			"Read":            BooleanField{Compute: Registry.MustGet("Post").Methods().MustGet("ComputeRead")},
 			"LastRead":        DateField{},
@@ -281,19 +306,30 @@ func TestModelDeclaration(t *testing.T) {
 		post.SetDefaultOrder("Title")
 
 		tag.AddFields(map[string]FieldDefinition{
-			"Name":        CharField{Constraint: tag.Methods().MustGet("CheckNameDescription")},
+			"Name":        CharField{Constraint: tag.Methods().MustGet("CheckNameDescription"), Unaccent: true},
 			"BestPost":    Many2OneField{RelationModel: Registry.MustGet("Post")},
 			"Posts":       Many2ManyField{RelationModel: Registry.MustGet("Post")},
 			"Parent":      Many2OneField{RelationModel: Registry.MustGet("Tag")},
-			"Description": CharField{Constraint: tag.Methods().MustGet("CheckNameDescription")},
+			"Children":    One2ManyField{RelationModel: Registry.MustGet("Tag"), ReverseFK: "Parent", NoCopy: true},
+			"Siblings":    One2ManyField{RelationModel: Registry.MustGet("Tag"), ReverseFK: "Parent", Related: "Parent.Children"},
+			"Description": CharField{Constraint: tag.Methods().MustGet("CheckNameDescription"), UnaccentSearch: true, Index: true},
 			"Rate":        FloatField{Constraint: tag.Methods().MustGet("CheckRate"), GoType: new(float32)},
+			"Company":     Many2OneField{RelationModel: Registry.MustGet("Company")},
+			"RatedChildren": One2ManyField{RelationModel: Registry.MustGet("Tag"), ReverseFK: "Parent",
+				Compute: tag.Methods().MustGet("ComputeRatedChildren")},
 		})
 		tag.SetDefaultOrder("Name DESC", "ID ASC")
+		tag.AddUniqueConstraint("active_name_description", []FieldNamer{FieldName("Name"), FieldName("Description")},
+			"active = TRUE", "Active tags must have different names or descriptions")
+		tag.AddIndex("company_rate", []FieldNamer{FieldName("Company"), FieldName("Rate")}, false, "active = TRUE")
+		tag.AddIndex("parent_name", []FieldNamer{FieldName("Parent"), FieldName("Name")}, false, "")
 
 		cv.AddFields(map[string]FieldDefinition{
 			"Education":  TextField{},
 			"Experience": TextField{},
 			"Leisure":    TextField{},
+			"Photo":      BinaryField{Attachment: true},
+			"Picture":    ImageField{Sizes: []int{32, 8}},
 		})
 
 		addressMI.AddFields(map[string]FieldDefinition{
@@ -314,6 +350,54 @@ func TestModelDeclaration(t *testing.T) {
 			"Name": CharField{},
 			"City": CharField{},
 		})
+
+		ledgerEntry.AddFields(map[string]FieldDefinition{
+			"Message": CharField{Required: true},
+			"User":    Many2OneField{RelationModel: Registry.MustGet("User")},
+			"Amount":  FloatField{},
+		})
+		ledgerEntry.EnableHashChain()
+		So(func() { viewModel.EnableHashChain() }, ShouldPanic)
+		So(func() { ledgerEntry.SetIDGenerator(IDRandom) }, ShouldPanic)
+
+		note.AddFields(map[string]FieldDefinition{
+			"Title": CharField{Tracking: true},
+			"User":  Many2OneField{RelationModel: Registry.MustGet("User"), Tracking: true},
+			"Stars": IntegerField{},
+			"State": SelectionField{Selection: types.Selection{"draft": "Draft", "confirmed": "Confirmed"},
+				Default: DefaultValue("draft"), Tracking: true},
+			"Amount": FloatField{},
+			"Checklist": EmbeddedListField{Schema: map[string]fieldtype.Type{
+				"Label":    fieldtype.Char,
+				"Done":     fieldtype.Boolean,
+				"Weight":   fieldtype.Integer,
+				"Deadline": fieldtype.Date,
+			}, RequiredKeys: []string{"Label"}},
+			"Meta":   JSONField{},
+			"Origin": Many2OneField{RelationModel: Registry.MustGet("Note")},
+		})
+		note.EnableAudit()
+		note.InheritModel(Registry.MustGet("ApprovalMixin"))
+		note.InheritModel(Registry.MustGet("MessagingMixin"))
+		note.InheritModel(Registry.MustGet("StageMixin"))
+		approvers := security.Registry.NewGroup("approvers", "Approvers")
+		confirmRule := ApprovalRule{
+			Operation:   "confirm",
+			Field:       FieldName("State"),
+			Value:       "confirmed",
+			Groups:      []*security.Group{approvers},
+			AmountField: FieldName("Amount"),
+			Threshold:   1000,
+		}
+		note.AddApprovalRule(confirmRule)
+		So(func() { note.AddApprovalRule(confirmRule) }, ShouldPanic)
+		So(func() { note.AddApprovalRule(ApprovalRule{Operation: "cancel"}) }, ShouldPanic)
+		So(func() { activeMI.EnableAudit() }, ShouldPanic)
+		So(func() { activeMI.EnableFuzzySearch() }, ShouldPanic)
+		So(note.IDGenerator(), ShouldEqual, IDSequence)
+		So(func() { note.SetIDGenerator("unknown") }, ShouldPanic)
+		note.SetIDGenerator(IDSnowflake)
+		So(note.IDGenerator(), ShouldEqual, IDSnowflake)
 	})
 }
 
@@ -375,6 +459,18 @@ func TestFieldModification(t *testing.T) {
 		nameField := Registry.MustGet("User").Fields().MustGet("Name")
 		nameField.SetSize(127)
 		checkUpdates(nameField, "size", 127)
+		nameField.SetCollation("C")
+		checkUpdates(nameField, "collation", "C")
+		nameField.SetCollation("")
+		checkUpdates(nameField, "collation", "")
+		nameField.SetUnaccent(true)
+		checkUpdates(nameField, "unaccent", true)
+		nameField.SetUnaccent(false)
+		checkUpdates(nameField, "unaccent", false)
+		nameField.SetUnaccentSearch(true)
+		checkUpdates(nameField, "unaccentSearch", true)
+		nameField.SetUnaccentSearch(false)
+		checkUpdates(nameField, "unaccentSearch", false)
 		nameField.SetOnchange(nil)
 		nameField.SetOnchange(Registry.MustGet("User").Methods().MustGet("OnChangeName"))
 		nameField.SetConstraint(Registry.MustGet("User").Methods().MustGet("UpdateCity"))
@@ -406,6 +502,9 @@ func TestFieldModification(t *testing.T) {
 		statusField := Registry.MustGet("User").Fields().MustGet("Status")
 		statusField.SetReadOnly(false)
 		checkUpdates(statusField, "readOnly", false)
+		discountField := Registry.MustGet("Profile").Fields().MustGet("Discount")
+		discountField.SetCompanyDependent(true)
+		checkUpdates(discountField, "companyDependent", true)
 	})
 }
 
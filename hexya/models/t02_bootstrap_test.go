@@ -4,10 +4,14 @@
 package models
 
 import (
+	"context"
 	"fmt"
 	"reflect"
+	"sort"
 	"testing"
+	"time"
 
+	"github.com/hexya-erp/hexya/hexya/models/security"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
@@ -64,6 +68,44 @@ func TestIllegalMethods(t *testing.T) {
 		So(checkTypesMatch(reflect.TypeOf(TestFieldMap{}), reflect.TypeOf(FieldMap{})), ShouldBeTrue)
 		So(checkTypesMatch(reflect.TypeOf(FieldMap{}), reflect.TypeOf(TestFieldMap{})), ShouldBeTrue)
 	})
+	Convey("Test registry validation", t, func() {
+		userModel := Registry.MustGet("User")
+		So(checkRelatedPath(userModel, "Profile.Age"), ShouldBeBlank)
+		So(checkRelatedPath(userModel, "Education"), ShouldBeBlank)
+		So(checkRelatedPath(userModel, "Profile.NonExistentField"), ShouldNotBeBlank)
+		So(checkRelatedPath(userModel, "Name.Age"), ShouldNotBeBlank)
+		So(validateModel(userModel), ShouldBeEmpty)
+		So(validateRegistry, ShouldNotPanic)
+		Convey("Invalid declarations should be reported", func() {
+			userModel.AddFields(map[string]FieldDefinition{
+				"BadRelated": CharField{Related: "Profile.NonExistentField"},
+				"WrongTarget": Many2OneField{RelationModel: Registry.MustGet("Post"),
+					Related: "Profile.Currency"},
+				"BadReverse": One2ManyField{RelationModel: Registry.MustGet("Post"),
+					ReverseFK: "NonExistentFK"},
+			})
+			Reset(func() {
+				removeTestFields(userModel, "BadRelated", "WrongTarget", "BadReverse")
+			})
+			errs := validateModel(userModel)
+			sort.Strings(errs)
+			So(errs, ShouldResemble, []string{
+				"User.BadRelated: related path 'Profile.NonExistentField' does not resolve: unknown field 'NonExistentField' in model Profile",
+				"User.BadReverse: unknown reverse field 'NonExistentFK' in model Post",
+				"User.WrongTarget: related path 'Profile.Currency' does not lead to a relation to model Post",
+			})
+			var msg string
+			func() {
+				defer func() {
+					msg = fmt.Sprintf("%v", recover())
+				}()
+				validateRegistry()
+			}()
+			So(msg, ShouldStartWith, "Models validation failed with 3 error(s):\n- User.BadRelated: related path")
+			So(msg, ShouldContainSubstring, "\n- User.BadReverse: unknown reverse field 'NonExistentFK' in model Post")
+			So(msg, ShouldContainSubstring, "\n- User.WrongTarget: related path 'Profile.Currency' does not lead to a relation to model Post")
+		})
+	})
 	Convey("Test methods signature check", t, func() {
 		userModel := Registry.MustGet("User")
 		nameField := userModel.Fields().MustGet("Name")
@@ -106,6 +148,15 @@ func TestBootStrap(t *testing.T) {
 			So(BootStrap, ShouldNotPanic)
 			So(SyncDatabase, ShouldNotPanic)
 		})
+		Convey("Destructive changes should only be applied if allowed", func() {
+			So(testAdapter.tables(), ShouldContainKey, "shouldbedeleted")
+			So(SkippedSchemaChanges(), ShouldContain, `DROP TABLE "shouldbedeleted"`)
+			AllowDestructiveSchemaChanges = true
+			defer func() { AllowDestructiveSchemaChanges = false }()
+			So(SyncDatabase, ShouldNotPanic)
+			So(SkippedSchemaChanges(), ShouldBeEmpty)
+			So(testAdapter.tables(), ShouldNotContainKey, "shouldbedeleted")
+		})
 		Convey("Boostrapping twice should panic", func() {
 			So(BootStrapped(), ShouldBeTrue)
 			So(BootStrap, ShouldPanic)
@@ -143,6 +194,72 @@ func TestBootStrap(t *testing.T) {
 			So(testAdapter.constraints("%_mancon"), ShouldHaveLength, 1)
 			So(testAdapter.constraints("%_mancon")[0], ShouldEqual, "nums_premium_user_mancon")
 		})
+		Convey("Unique constraints indexes should have been created", func() {
+			So(testAdapter.indexes("tag", "%_manidx"), ShouldHaveLength, 1)
+			So(testAdapter.indexes("tag", "%_manidx")[0], ShouldEqual, "active_name_description_tag_manidx")
+			So(testAdapter.indexComment("active_name_description_tag_manidx"), ShouldEqual,
+				`CREATE UNIQUE INDEX active_name_description_tag_manidx ON "tag" (name, description) WHERE active = TRUE`)
+		})
+		Convey("Unique constraints indexes should be updated with their declaration", func() {
+			tag := Registry.MustGet("Tag")
+			tag.AddUniqueConstraint("active_name_description", []FieldNamer{FieldName("Name"), FieldName("Description")},
+				"", "Active tags must have different names or descriptions")
+			So(SyncDatabase, ShouldNotPanic)
+			So(testAdapter.indexComment("active_name_description_tag_manidx"), ShouldEqual,
+				`CREATE UNIQUE INDEX active_name_description_tag_manidx ON "tag" (name, description)`)
+			tag.AddUniqueConstraint("active_name_description", []FieldNamer{FieldName("Name"), FieldName("Description")},
+				"active = TRUE", "Active tags must have different names or descriptions")
+			So(SyncDatabase, ShouldNotPanic)
+			So(testAdapter.indexComment("active_name_description_tag_manidx"), ShouldEqual,
+				`CREATE UNIQUE INDEX active_name_description_tag_manidx ON "tag" (name, description) WHERE active = TRUE`)
+		})
+		Convey("Model indexes should have been created", func() {
+			So(testAdapter.indexes("tag", "%_tag_idx"), ShouldHaveLength, 2)
+			So(testAdapter.indexExists("tag", "company_rate_tag_idx"), ShouldBeTrue)
+			So(testAdapter.indexComment("company_rate_tag_idx"), ShouldEqual,
+				`CREATE INDEX company_rate_tag_idx ON "tag" (company_id, rate) WHERE active = TRUE`)
+		})
+		Convey("Trigram indexes should have been created on record names of fuzzy searchable models", func() {
+			So(testAdapter.indexes("user", "user_%_trgm"), ShouldResemble, []string{"user_name_trgm"})
+			So(testAdapter.indexes("tag", "tag_%_trgm"), ShouldBeEmpty)
+		})
+		Convey("Unaccent indexes should have been created on indexed unaccent searchable fields", func() {
+			So(testAdapter.indexes("tag", "tag_%_unaccent"), ShouldResemble, []string{"tag_description_unaccent"})
+			So(testAdapter.indexes("user", "user_%_unaccent"), ShouldBeEmpty)
+		})
+		Convey("Model indexes should be updated with their declaration", func() {
+			tag := Registry.MustGet("Tag")
+			tag.AddIndex("company_rate", []FieldNamer{FieldName("Company"), FieldName("Rate")}, true, "")
+			tag.RemoveIndex("parent_name")
+			So(SyncDatabase, ShouldNotPanic)
+			So(testAdapter.indexes("tag", "%_tag_idx"), ShouldResemble, []string{"company_rate_tag_idx"})
+			So(testAdapter.indexComment("company_rate_tag_idx"), ShouldEqual,
+				`CREATE UNIQUE INDEX company_rate_tag_idx ON "tag" (company_id, rate)`)
+			tag.AddIndex("company_rate", []FieldNamer{FieldName("Company"), FieldName("Rate")}, false, "active = TRUE")
+			tag.AddIndex("parent_name", []FieldNamer{FieldName("Parent"), FieldName("Name")}, false, "")
+			So(SyncDatabase, ShouldNotPanic)
+			So(testAdapter.indexes("tag", "%_tag_idx"), ShouldHaveLength, 2)
+		})
+		Convey("Models with 64 bits ids should have bigint id and relation columns", func() {
+			So(testAdapter.columns("note")["id"].DataType, ShouldEqual, "bigint")
+			So(testAdapter.columns("note")["origin_id"].DataType, ShouldEqual, "bigint")
+			So(testAdapter.columns("note")["user_id"].DataType, ShouldEqual, "integer")
+			So(testAdapter.columns("tag")["id"].DataType, ShouldEqual, "integer")
+			So(testAdapter.columns("audit_log")["res_id"].DataType, ShouldEqual, "bigint")
+			So(createTableSQL(Registry.MustGet("Note")), ShouldEqual, `CREATE TABLE "note" (id bigserial NOT NULL PRIMARY KEY)`)
+			So(createTableSQL(Registry.MustGet("Tag")), ShouldEqual, `CREATE TABLE "tag" (id serial NOT NULL PRIMARY KEY)`)
+			Convey("Integer id and relation columns should be widened", func() {
+				dbExecuteNoTx(`ALTER TABLE "note" ALTER COLUMN origin_id SET DATA TYPE integer`)
+				dbExecuteNoTx(`ALTER TABLE "note" ALTER COLUMN id SET DATA TYPE integer`)
+				dbExecuteNoTx(`ALTER SEQUENCE note_id_seq AS integer`)
+				So(SyncDatabase, ShouldNotPanic)
+				So(testAdapter.columns("note")["id"].DataType, ShouldEqual, "bigint")
+				So(testAdapter.columns("note")["origin_id"].DataType, ShouldEqual, "bigint")
+				var seqType string
+				dbGetNoTx(&seqType, `SELECT data_type FROM information_schema.sequences WHERE sequence_name = 'note_id_seq'`)
+				So(seqType, ShouldEqual, "bigint")
+			})
+		})
 		Convey("Applying DB modifications", func() {
 			Registry.bootstrapped = false
 			contentField := Registry.MustGet("Post").Fields().MustGet("Content")
@@ -157,6 +274,32 @@ func TestBootStrap(t *testing.T) {
 			So(numsField.index, ShouldBeFalse)
 			So(SyncDatabase, ShouldNotPanic)
 		})
+		Convey("Renamed models and fields should keep their data", func() {
+			dbExecuteNoTx(`ALTER TABLE "resume" RENAME COLUMN leisure TO hobbies`)
+			dbExecuteNoTx(`ALTER TABLE "resume" RENAME TO "curriculum_vitae"`)
+			dbExecuteNoTx(`INSERT INTO "curriculum_vitae" (hobbies) VALUES ('Chess')`)
+			dbExecuteNoTx(`INSERT INTO "model_data" (name, model, res_id, no_update) VALUES ('test_cv', 'CurriculumVitae', 1, false)`)
+			Registry.bootstrapped = false
+			resume := Registry.MustGet("Resume")
+			resume.AddPreviousName("CurriculumVitae")
+			resume.Fields().MustGet("Leisure").AddPreviousName("Hobbies")
+			Registry.bootstrapped = true
+			So(Registry.MustGet("CurriculumVitae"), ShouldEqual, resume)
+			So(resume.Fields().MustGet("Hobbies").name, ShouldEqual, "Leisure")
+			So(resume.JSONizeFieldName("hobbies"), ShouldEqual, "leisure")
+			So(SyncDatabase, ShouldNotPanic)
+			So(testAdapter.tables(), ShouldContainKey, "resume")
+			So(testAdapter.tables(), ShouldNotContainKey, "curriculum_vitae")
+			So(testAdapter.columns("resume"), ShouldContainKey, "leisure")
+			So(testAdapter.columns("resume"), ShouldNotContainKey, "hobbies")
+			var leisures []string
+			dbSelectNoTx(&leisures, `SELECT leisure FROM "resume"`)
+			So(leisures, ShouldContain, "Chess")
+			var model string
+			dbGetNoTx(&model, `SELECT model FROM "model_data" WHERE name = 'test_cv'`)
+			So(model, ShouldEqual, "Resume")
+			dbExecuteNoTx(`DELETE FROM "model_data" WHERE name = 'test_cv'`)
+		})
 	})
 
 	Convey("Post testing models modifications", t, func() {
@@ -180,3 +323,182 @@ func TestBootStrap(t *testing.T) {
 		}
 	})
 }
+
+func TestMigrations(t *testing.T) {
+	Convey("Testing module migrations", t, func() {
+		var calls []string
+		migration := func(name string) func(env Environment) {
+			return func(env Environment) {
+				calls = append(calls, name)
+			}
+		}
+		migrations := map[string]Migration{
+			"1.0": {Pre: migration("1.0-pre"), Post: migration("1.0-post")},
+		}
+		Convey("Installing a module should not run its migrations", func() {
+			RunMigrations("migration_test", "1.0", MigrationPre, migrations)
+			RunMigrations("migration_test", "1.0", MigrationPost, migrations)
+			So(calls, ShouldBeEmpty)
+			version, installed := InstalledModuleVersion("migration_test")
+			So(installed, ShouldBeTrue)
+			So(version, ShouldEqual, "1.0")
+			Convey("Upgrading a module should run its new migrations in version order", func() {
+				migrations["1.10"] = Migration{Post: migration("1.10-post")}
+				migrations["1.2"] = Migration{Pre: migration("1.2-pre"), Post: migration("1.2-post")}
+				RunMigrations("migration_test", "1.10", MigrationPre, migrations)
+				So(calls, ShouldResemble, []string{"1.2-pre"})
+				RunMigrations("migration_test", "1.10", MigrationPost, migrations)
+				So(calls, ShouldResemble, []string{"1.2-pre", "1.2-post", "1.10-post"})
+				version, _ = InstalledModuleVersion("migration_test")
+				So(version, ShouldEqual, "1.10")
+				Convey("Migrations should only be run once", func() {
+					calls = nil
+					RunMigrations("migration_test", "1.10", MigrationPre, migrations)
+					RunMigrations("migration_test", "1.10", MigrationPost, migrations)
+					So(calls, ShouldBeEmpty)
+				})
+				Convey("A failing migration should panic and not be recorded", func() {
+					migrations["2.0"] = Migration{Post: func(env Environment) {
+						env.cr.Execute(fmt.Sprintf(`UPDATE %s SET version = 'broken' WHERE module = 'migration_test'`,
+							migrationTableName()))
+						panic("migration error")
+					}}
+					So(func() { RunMigrations("migration_test", "2.0", MigrationPost, migrations) }, ShouldPanic)
+					var applied map[string]bool
+					SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+						applied = appliedMigrations(env, "migration_test")
+					})
+					So(applied, ShouldNotContainKey, migrationKey("2.0", MigrationPost))
+					So(applied, ShouldContainKey, migrationKey("1.10", MigrationPost))
+					version, _ = InstalledModuleVersion("migration_test")
+					So(version, ShouldEqual, "1.10")
+				})
+			})
+		})
+		Reset(func() {
+			dbExecuteNoTx(fmt.Sprintf(`DELETE FROM %s WHERE module = 'migration_test'`, migrationTableName()))
+		})
+	})
+	Convey("Testing version comparison", t, func() {
+		So(CompareVersions("1.2", "1.10"), ShouldEqual, -1)
+		So(CompareVersions("1.10", "1.2"), ShouldEqual, 1)
+		So(CompareVersions("1.2.0", "1.2.0"), ShouldEqual, 0)
+		So(CompareVersions("1.2", "1.2.1"), ShouldEqual, -1)
+		So(CompareVersions("1.2-beta", "1.2-rc"), ShouldEqual, -1)
+	})
+}
+
+func TestColumnTypeWidening(t *testing.T) {
+	Convey("Testing column type widening", t, func() {
+		varchar := func(length int64) columnType { return columnType{dataType: "character varying", length: length} }
+		numeric := func(precision, scale int64) columnType {
+			return columnType{dataType: "numeric", precision: precision, scale: scale}
+		}
+		Convey("Enlarging or removing limits should widen", func() {
+			So(varchar(10).widensTo(varchar(20)), ShouldBeTrue)
+			So(varchar(10).widensTo(varchar(0)), ShouldBeTrue)
+			So(varchar(10).widensTo(columnType{dataType: "text"}), ShouldBeTrue)
+			So(numeric(10, 2).widensTo(numeric(12, 4)), ShouldBeTrue)
+			So(numeric(10, 2).widensTo(numeric(0, 0)), ShouldBeTrue)
+			So(columnType{dataType: "integer"}.widensTo(columnType{dataType: "bigint"}), ShouldBeTrue)
+			So(columnType{dataType: "integer"}.widensTo(numeric(0, 0)), ShouldBeTrue)
+			So(columnType{dataType: "date"}.widensTo(columnType{dataType: "timestamp without time zone"}), ShouldBeTrue)
+		})
+		Convey("Shrinking limits or converting types should not widen", func() {
+			So(varchar(20).widensTo(varchar(10)), ShouldBeFalse)
+			So(varchar(0).widensTo(varchar(10)), ShouldBeFalse)
+			So(columnType{dataType: "text"}.widensTo(varchar(10)), ShouldBeFalse)
+			So(numeric(10, 2).widensTo(numeric(10, 4)), ShouldBeFalse)
+			So(numeric(0, 0).widensTo(numeric(10, 2)), ShouldBeFalse)
+			So(columnType{dataType: "bigint"}.widensTo(columnType{dataType: "integer"}), ShouldBeFalse)
+			So(columnType{dataType: "integer"}.widensTo(numeric(8, 2)), ShouldBeFalse)
+			So(columnType{dataType: "text"}.widensTo(columnType{dataType: "integer"}), ShouldBeFalse)
+			So(columnType{dataType: "boolean"}.widensTo(columnType{dataType: "integer"}), ShouldBeFalse)
+		})
+		Convey("Column types should be rendered as SQL", func() {
+			So(varchar(10).String(), ShouldEqual, "character varying(10)")
+			So(varchar(0).String(), ShouldEqual, "character varying")
+			So(numeric(12, 4).String(), ShouldEqual, "numeric(12, 4)")
+		})
+	})
+}
+
+func TestMigrationLock(t *testing.T) {
+	Convey("Testing the migration lock", t, func() {
+		// Another instance is simulated by a connection holding the lock
+		conn, err := db.Conn(context.Background())
+		So(err, ShouldBeNil)
+		query, args := sanitizeQuery(adapters[db.DriverName()].tryAdvisoryLockSQL(), migrationLockKey)
+		var locked bool
+		So(conn.QueryRowContext(context.Background(), query, args...).Scan(&locked), ShouldBeNil)
+		So(locked, ShouldBeTrue)
+		pollInterval := migrationLockPollInterval
+		migrationLockPollInterval = 10 * time.Millisecond
+		unlockQuery, unlockArgs := sanitizeQuery(adapters[db.DriverName()].advisoryUnlockSQL(), migrationLockKey)
+		Reset(func() {
+			migrationLockPollInterval = pollInterval
+			ReleaseMigrationLock()
+			// Closing conn only returns it to the pool, so that we must unlock explicitly
+			conn.ExecContext(context.Background(), unlockQuery, unlockArgs...)
+			conn.Close()
+		})
+		held, err := MigrationLockHeld(context.Background())
+		So(err, ShouldBeNil)
+		So(held, ShouldBeTrue)
+		Convey("The lock should not be taken twice", func() {
+			So(TryMigrationLock(), ShouldBeFalse)
+			So(WaitMigrationLock(50*time.Millisecond), ShouldEqual, ErrMigrationLockTimeout)
+		})
+		Convey("Waiting instances should take the lock once it is released", func() {
+			go func() {
+				time.Sleep(50 * time.Millisecond)
+				conn.ExecContext(context.Background(), unlockQuery, unlockArgs...)
+			}()
+			So(WaitMigrationLock(5*time.Second), ShouldBeNil)
+			So(func() { TryMigrationLock() }, ShouldPanic)
+			ReleaseMigrationLock()
+			held, err := MigrationLockHeld(context.Background())
+			So(err, ShouldBeNil)
+			So(held, ShouldBeFalse)
+		})
+	})
+}
+
+func TestModelHandles(t *testing.T) {
+	Convey("Testing model handles", t, func() {
+		handle := NewModelHandle("User")
+		Convey("Handles should resolve to the model of the registry", func() {
+			So(handle.Name(), ShouldEqual, "User")
+			So(handle.Model(), ShouldEqual, Registry.MustGet("User"))
+			So(Registry.getByIndex(Registry.MustGet("User").index), ShouldEqual, Registry.MustGet("User"))
+		})
+		Convey("Pool should return an empty RecordCollection of the model", func() {
+			So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+				users := handle.Pool(env)
+				So(users.ModelName(), ShouldEqual, "User")
+				So(users.IsEmpty(), ShouldBeTrue)
+				So(users.SearchAll().Len(), ShouldEqual, env.Pool("User").SearchAll().Len())
+			}), ShouldBeNil)
+		})
+		Convey("Handles of unknown models should panic at resolution", func() {
+			So(func() { NewModelHandle("UnknownModel") }, ShouldPanic)
+		})
+	})
+}
+
+// removeTestFields removes from the given model the fields
+// with the given names, which have been added for a test.
+func removeTestFields(mi *Model, names ...string) {
+	for _, name := range names {
+		fi := mi.fields.MustGet(name)
+		delete(mi.fields.registryByName, fi.name)
+		delete(mi.fields.registryByJSON, fi.json)
+		var relatedFields []*Field
+		for _, rfi := range mi.fields.relatedFields {
+			if rfi != fi {
+				relatedFields = append(relatedFields, rfi)
+			}
+		}
+		mi.fields.relatedFields = relatedFields
+	}
+}
@@ -17,8 +17,11 @@ package models
 import (
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/hexya-erp/hexya/hexya/models/security"
+	"github.com/hexya-erp/hexya/hexya/models/types/dates"
+	"github.com/hexya-erp/hexya/hexya/tools/golden"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
@@ -89,6 +92,22 @@ func TestConditions(t *testing.T) {
 					sql, _ = rs.query.selectQuery(fields)
 					So(sql, ShouldEqual, `SELECT DISTINCT "user".name AS name, "T2".title AS profile_id__best_post_id__title FROM "user" "user" LEFT JOIN "profile" "T1" ON "user".profile_id="T1".id LEFT JOIN "post" "T2" ON "T1".best_post_id="T2".id INNER JOIN "resume" "T3" ON "user".resume_id="T3".id  WHERE (("T2".title = ?) AND ("T1".age >= ?)) AND ("user".name LIKE ? OR "T3".education LIKE ?)  `)
 				})
+				Convey("Checking query snapshots", func() {
+					rs = rs.Search(rs.Model().Field("Profile.Age").GreaterOrEqual(12))
+					rs = rs.Search(rs.Model().Field("name").Contains("jane").Or().Field("Profile.Money").Lower(1234.56))
+					So(rs.SQLSnapshot("Name", "Profile.BestPost.Title"), golden.ShouldMatchGolden, "testdata/golden/user_search.golden")
+				})
+				Convey("Checking schema snapshots", func() {
+					schema := env.Pool("Tag").Model().SchemaSnapshot()
+					So(schema, ShouldStartWith, `CREATE TABLE "tag" (id serial NOT NULL PRIMARY KEY);`)
+					So(schema, ShouldContainSubstring, `ALTER TABLE "tag" ADD COLUMN name character varying NOT NULL DEFAULT '';`)
+					So(schema, ShouldContainSubstring, `ALTER TABLE "tag" ADD CONSTRAINT tag_best_post_id_fkey FOREIGN KEY (best_post_id) REFERENCES "post" ON DELETE set null;`)
+					So(schema, ShouldContainSubstring, `CREATE UNIQUE INDEX active_name_description_tag_manidx ON "tag" (name, description) WHERE active = TRUE;`)
+					So(schema, ShouldContainSubstring, `CREATE INDEX company_rate_tag_idx ON "tag" (company_id, rate) WHERE active = TRUE;`)
+					So(schema, ShouldNotContainSubstring, "posts")
+					So(schema, ShouldEqual, env.Pool("Tag").Model().SchemaSnapshot())
+					So(func() { Registry.MustGet("CommonMixin").SchemaSnapshot() }, ShouldPanic)
+				})
 				Convey("Testing query without WHERE clause", func() {
 					rs = env.Pool("User").Load()
 					fields := []string{"name"}
@@ -113,6 +132,27 @@ func TestConditions(t *testing.T) {
 					sql, _ := rs.query.selectQuery(fields)
 					So(sql, ShouldEqual, `SELECT DISTINCT "user".name AS name, "user".email AS email, "user".id AS id FROM "user" "user"  WHERE "user".email ILIKE ? ORDER BY "user".email , "user".id  `)
 				})
+				Convey("Testing query with collated ORDER BY clauses", func() {
+					rs = env.Pool("User").Search(rs.Model().Field("email").IContains("jane.smith@example.com")).OrderBy("Email", "ID").Collate("C")
+					fields := []string{"name"}
+					sql, _ := rs.query.selectQuery(fields)
+					So(sql, ShouldEqual, `SELECT DISTINCT "user".name AS name, "user".email AS email, "user".id AS id, "user".email COLLATE "C" AS __sort_0 FROM "user" "user"  WHERE "user".email ILIKE ? ORDER BY "user".email COLLATE "C" , "user".id  `)
+				})
+				Convey("Testing query with unaccented ORDER BY clauses", func() {
+					tags := env.Pool("Tag").SearchAll().OrderBy("Name DESC")
+					sql, _ := tags.query.selectQuery([]string{"id"})
+					So(sql, ShouldEqual, `SELECT DISTINCT "tag".id AS id, "tag".name AS name, hexya_unaccent("tag".name) AS __sort_0 FROM "tag" "tag"   ORDER BY hexya_unaccent("tag".name) DESC `)
+				})
+				Convey("Testing query with ORDER BY on related fields and NULLS ordering", func() {
+					posts := env.Pool("Post").SearchAll().OrderBy("User desc", "LastRead asc nulls last")
+					sql, _ := posts.query.selectQuery([]string{"id"})
+					So(sql, ShouldEqual, `SELECT DISTINCT "post".id AS id, "T1".name AS user_id__name, "post".last_read AS last_read FROM "post" "post" LEFT JOIN "user" "T1" ON "post".user_id="T1".id   ORDER BY "T1".name DESC, "post".last_read ASC NULLS LAST `)
+					posts = env.Pool("Post").SearchAll().OrderBy("User.Profile.Age DESC NULLS FIRST")
+					sql, _ = posts.query.selectQuery([]string{"id"})
+					So(sql, ShouldEqual, `SELECT DISTINCT "post".id AS id, "T2".age AS user_id__profile_id__age FROM "post" "post" LEFT JOIN "user" "T1" ON "post".user_id="T1".id INNER JOIN "profile" "T2" ON "T1".profile_id="T2".id   ORDER BY "T2".age DESC NULLS FIRST `)
+					So(func() { env.Pool("Post").SearchAll().OrderBy("Title descending").Load() }, ShouldPanic)
+					So(func() { env.Pool("Post").SearchAll().OrderBy("Title nulls").Load() }, ShouldPanic)
+				})
 				Convey("Testing complex conditions", func() {
 					rs = env.Pool("User").Search(rs.Model().Field("Profile.Age").GreaterOrEqual(12).
 						AndNot().Field("Name").IContains("Jane").
@@ -231,6 +271,35 @@ func TestConditions(t *testing.T) {
 					So(sql, ShouldEqual, `SELECT DISTINCT "user".name AS name FROM "user" "user"  WHERE "user".id = ?  `)
 					So(args, ShouldContain, 101)
 				})
+				Convey("Date argument on DateTime field", func() {
+					tokyo, _ := time.LoadLocation("Asia/Tokyo")
+					day, _ := dates.ParseDate(dates.DefaultServerDateFormat, "2017-08-01")
+					rs = env.WithContext("tz", "Asia/Tokyo").Pool("User")
+					start := dates.DateTime{Time: time.Date(2017, 8, 1, 0, 0, 0, 0, tokyo)}
+					end := start.AddDate(0, 0, 1)
+					rsEq := rs.Search(rs.Model().Field("CreateDate").Equals(day))
+					sql, args := rsEq.query.sqlWhereClause()
+					So(sql, ShouldEqual, `WHERE ("user".create_date >= ? AND "user".create_date < ?)`)
+					So(args, ShouldHaveLength, 2)
+					So(args[0].(dates.DateTime).Equal(start), ShouldBeTrue)
+					So(args[1].(dates.DateTime).Equal(end), ShouldBeTrue)
+					rsGt := rs.Search(rs.Model().Field("CreateDate").Greater(day))
+					sql, args = rsGt.query.sqlWhereClause()
+					So(sql, ShouldEqual, `WHERE "user".create_date >= ?`)
+					So(args[0].(dates.DateTime).Equal(end), ShouldBeTrue)
+					rsLe := rs.Search(rs.Model().Field("CreateDate").LowerOrEqual(day))
+					sql, args = rsLe.query.sqlWhereClause()
+					So(sql, ShouldEqual, `WHERE "user".create_date < ?`)
+					So(args[0].(dates.DateTime).Equal(end), ShouldBeTrue)
+				})
+				Convey("DateTime argument on Date field", func() {
+					dt, _ := dates.ParseDateTime(dates.DefaultServerDateTimeFormat, "2017-08-01 20:00:00")
+					posts := env.WithContext("tz", "Asia/Tokyo").Pool("Post")
+					posts = posts.Search(posts.Model().Field("LastRead").Equals(dt))
+					sql, args := posts.query.sqlWhereClause()
+					So(sql, ShouldEqual, `WHERE "post".last_read = ?`)
+					So(args[0].(dates.Date).String(), ShouldEqual, "2017-08-02")
+				})
 			}), ShouldBeNil)
 		}
 	})
@@ -269,6 +338,11 @@ func TestConditionSerialization(t *testing.T) {
 			dom := cond.Serialize()
 			So(fmt.Sprint(dom), ShouldEqual, "[| [Age > 18] [Name ilike John]]")
 		})
+		Convey("Testing condition on JSON paths", func() {
+			cond := newCondition().And().Field("Meta#color").Equals("red").And().Field("Meta").JSONPath("size", "width").Greater(5)
+			dom := cond.Serialize()
+			So(fmt.Sprint(dom), ShouldEqual, "[& [Meta#color = red] [Meta#size#width > 5]]")
+		})
 		Convey("Testing A AND B OR C condition", func() {
 			cond := newCondition().And().Field("Name").IContains("John").And().Field("Age").Greater(18).Or().Field("IsStaff").Equals(true)
 			dom := cond.Serialize()
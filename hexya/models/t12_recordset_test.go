@@ -15,6 +15,7 @@
 package models
 
 import (
+	"bytes"
 	"testing"
 
 	"github.com/hexya-erp/hexya/hexya/models/security"
@@ -135,6 +136,21 @@ func TestCreateRecordSet(t *testing.T) {
 			env.Pool("User").Call("Create", userRobData)
 		}).Error(), ShouldStartWith, "pq: Premium users must have positive nums")
 	})
+	Convey("Checking unique constraint enforcement", t, func() {
+		tagData := FieldMap{
+			"Name":        "Unique",
+			"Description": "Only once",
+		}
+		So(ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+			env.Pool("Tag").Call("Create", tagData)
+			env.Pool("Tag").Call("Create", tagData)
+		}).Error(), ShouldStartWith, "pq: Active tags must have different names or descriptions")
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			tag := env.Pool("Tag").Call("Create", tagData).(RecordSet).Collection()
+			tag.Set("Active", false)
+			So(func() { env.Pool("Tag").Call("Create", tagData) }, ShouldNotPanic)
+		}), ShouldBeNil)
+	})
 	group1 := security.Registry.NewGroup("group1", "Group 1")
 	Convey("Testing access control list on creation (create only)", t, func() {
 		So(SimulateInNewEnvironment(2, func(env Environment) {
@@ -203,6 +219,10 @@ func TestCreateRecordSet(t *testing.T) {
 				userTom := env.Pool("User").Call("Create", userTomData).(RecordSet).Collection()
 				So(userTom.Get("Name"), ShouldEqual, "Tom Smith")
 				So(userTom.Get("Email").(string), ShouldBeBlank)
+				fInfos := env.Pool("User").FieldsGet(FieldName("Name"), FieldName("Email"))
+				So(fInfos["email"].Readable, ShouldBeTrue)
+				So(fInfos["email"].Writable, ShouldBeFalse)
+				So(fInfos["name"].Writable, ShouldBeTrue)
 
 				userModel.fields.MustGet("Email").GrantAccess(security.GroupEveryone, security.Write)
 			})
@@ -241,6 +261,35 @@ func TestSearchRecordSet(t *testing.T) {
 					So(recs[0].Get("Title"), ShouldEqual, "1st Post")
 					So(recs[1].Get("Title"), ShouldEqual, "2nd Post")
 				})
+				Convey("Reading Jane with Read", func() {
+					res := userJane.Read("Name", "Profile", "Profile.Age", "Posts", "LastPost")
+					So(res, ShouldHaveLength, 1)
+					So(res[0], ShouldHaveLength, 6)
+					So(res[0]["id"], ShouldEqual, userJane.Ids()[0])
+					So(res[0]["Name"], ShouldEqual, "Jane Smith")
+					profile := userJane.Get("Profile").(RecordSet).Collection()
+					So(res[0]["Profile"], ShouldResemble, []interface{}{profile.Ids()[0], profile.String()})
+					So(res[0]["Profile.Age"], ShouldEqual, 23)
+					So(res[0]["Posts"], ShouldHaveLength, 2)
+					So(res[0]["LastPost"], ShouldEqual, false)
+				})
+				Convey("Exporting Jane", func() {
+					fields := []string{"Name", "Profile.Age", "Posts.Title", "Email"}
+					rows := userJane.ExportRows(fields)
+					So(rows, ShouldHaveLength, 2)
+					So(rows[0], ShouldResemble, []interface{}{"Jane Smith", int16(23), "1st Post", "jane.smith@example.com"})
+					So(rows[1], ShouldResemble, []interface{}{nil, nil, "2nd Post", nil})
+					var buf bytes.Buffer
+					userJane.Export(&buf, fields, ExportCSV)
+					So(buf.String(), ShouldEqual, `Name,Profile.Age,Posts.Title,Email
+Jane Smith,23,1st Post,jane.smith@example.com
+,,2nd Post,
+`)
+					buf.Reset()
+					userJane.Export(&buf, fields, ExportXLSX)
+					So(buf.Len(), ShouldBeGreaterThan, 0)
+					So(func() { userJane.Export(&buf, fields, "pdf") }, ShouldPanic)
+				})
 				Convey("Reading Jane with ReadFirst", func() {
 					var userJaneStruct UserStruct
 					userJane.First(&userJaneStruct)
@@ -274,6 +323,17 @@ func TestSearchRecordSet(t *testing.T) {
 					So(userStructs[1].Email, ShouldEqual, "jsmith@example.com")
 					So(userStructs[2].Email, ShouldEqual, "will.smith@example.com")
 				})
+				Convey("Reading a page of users with SearchRead", func() {
+					users := env.Pool("User")
+					res := users.SearchRead(users.Model().Field("Name").Contains("Smith"), []string{"Name", "Email"}, 1, 1, "Name")
+					So(res.Length, ShouldEqual, 3)
+					So(res.Records, ShouldHaveLength, 1)
+					So(res.Records[0]["Name"], ShouldEqual, "John Smith")
+					res = users.SearchRead(newCondition(), []string{"Name"}, 0, 0, "Name desc")
+					So(res.Length, ShouldEqual, 3)
+					So(res.Records, ShouldHaveLength, 3)
+					So(res.Records[0]["Name"], ShouldEqual, "Will Smith")
+				})
 			})
 			Convey("Testing search on manual model", func() {
 				userViews := env.Pool("UserView").SearchAll()
@@ -365,6 +425,39 @@ func TestSearchRecordSet(t *testing.T) {
 	security.Registry.UnregisterGroup(group1)
 }
 
+func TestActiveRecordSet(t *testing.T) {
+	Convey("Testing archived records", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			users := env.Pool("User")
+			nameCond := users.Model().Field("Name").Equals("Jane Smith")
+			userJane := users.Call("Search", nameCond).(RecordSet).Collection()
+			So(userJane.Len(), ShouldEqual, 1)
+			userJane.ToggleActive()
+			So(userJane.Get("Active").(bool), ShouldBeFalse)
+			Convey("Archived records are not returned by Search", func() {
+				So(users.Call("Search", nameCond).(RecordSet).Collection().Len(), ShouldEqual, 0)
+				So(users.Call("SearchAll").(RecordSet).Collection().Ids(), ShouldNotContain, userJane.Ids()[0])
+			})
+			Convey("Archived records are returned with active_test set to false", func() {
+				res := users.WithContext("active_test", false).Call("Search", nameCond).(RecordSet).Collection()
+				So(res.Ids(), ShouldResemble, userJane.Ids())
+			})
+			Convey("Archived records are returned when filtering on Active", func() {
+				cond := nameCond.And().Field("Active").Equals(false)
+				So(users.Call("Search", cond).(RecordSet).Collection().Ids(), ShouldResemble, userJane.Ids())
+			})
+			Convey("Archived records are returned by Browse", func() {
+				So(users.Call("Browse", userJane.Ids()).(RecordSet).Collection().Len(), ShouldEqual, 1)
+			})
+			Convey("Restoring archived records", func() {
+				userJane.Call("ToggleActive")
+				So(userJane.Get("Active").(bool), ShouldBeTrue)
+				So(users.Call("Search", nameCond).(RecordSet).Collection().Len(), ShouldEqual, 1)
+			})
+		}), ShouldBeNil)
+	})
+}
+
 func TestAdvancedQueries(t *testing.T) {
 	Convey("Testing advanced queries on M2O relations", t, func() {
 		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
@@ -15,7 +15,9 @@
 package models
 
 import (
+	"fmt"
 	"testing"
+	"time"
 
 	"github.com/hexya-erp/hexya/hexya/models/security"
 	. "github.com/smartystreets/goconvey/convey"
@@ -85,6 +87,89 @@ func TestComputedNonStoredFields(t *testing.T) {
 	})
 }
 
+func TestComputedFieldsCachePolicy(t *testing.T) {
+	Convey("Testing non stored computed fields with a cache policy", t, func() {
+		users := Registry.MustGet("User")
+		fi := users.fields.MustGet("DecoratedName")
+		fi.cachePolicy = &CachePolicy{Keys: []string{"user_names"}}
+		defer func() { fi.cachePolicy = nil }()
+		sharedComputedCache.invalidateRecords(users, "", nil)
+		getJane := func(env Environment) *RecordCollection {
+			return env.Pool("User").Search(users.Field("Email").Equals("jane.smith@example.com"))
+		}
+		Convey("Values computed in a rolled back transaction should not be cached", func() {
+			var ref computedCacheRef
+			So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+				userJane := getJane(env)
+				So(userJane.Get("DecoratedName"), ShouldEqual, "User: Jane A. Smith [<jane.smith@example.com>]")
+				ref = computedCacheRef{model: users, field: "DecoratedName", id: userJane.Ids()[0], uid: security.SuperUserID}
+				So(env.computedValues.values, ShouldContainKey, ref)
+				_, ok := sharedComputedCache.get(ref)
+				So(ok, ShouldBeFalse)
+			}), ShouldBeNil)
+			_, ok := sharedComputedCache.get(ref)
+			So(ok, ShouldBeFalse)
+		})
+		Convey("Values computed in a committed transaction should be cached", func() {
+			So(ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+				So(getJane(env).Get("DecoratedName"), ShouldEqual, "User: Jane A. Smith [<jane.smith@example.com>]")
+			}), ShouldBeNil)
+			So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+				userJane := getJane(env)
+				query := fmt.Sprintf("UPDATE %s SET name = ? WHERE id = ?",
+					adapters[db.DriverName()].quoteTableName(users.tableName))
+				env.Cr().Execute(query, "Jane B. Smith", userJane.Ids()[0])
+				env.cache.invalidateRecord(users, userJane.Ids()[0])
+				Convey("Cached value should be reused", func() {
+					So(userJane.Get("Name"), ShouldEqual, "Jane B. Smith")
+					So(userJane.Get("DecoratedName"), ShouldEqual, "User: Jane A. Smith [<jane.smith@example.com>]")
+				})
+				Convey("Cached value should be recomputed after invalidation", func() {
+					InvalidateComputedCache("user_names")
+					So(userJane.Get("DecoratedName"), ShouldEqual, "User: Jane B. Smith [<jane.smith@example.com>]")
+				})
+				Convey("Cached value should be recomputed after a cache invalidation", func() {
+					applyCacheInvalidation(cacheInvalidation{Model: "User", IDs: userJane.Ids(), Fields: []string{"Name"}})
+					So(userJane.Get("DecoratedName"), ShouldEqual, "User: Jane B. Smith [<jane.smith@example.com>]")
+				})
+				Convey("Modified records should be added to the cache invalidations", func() {
+					userJane.Set("Nums", 13)
+					pending := env.invalidations[users]
+					So(pending, ShouldNotBeNil)
+					So(pending.ids, ShouldContainKey, userJane.Ids()[0])
+					So(pending.fields, ShouldContainKey, "Nums")
+					So(pending.allFields, ShouldBeFalse)
+				})
+				Convey("Cache should not be used by transactions that modified the model", func() {
+					userJane.Set("Nums", 13)
+					So(userJane.Get("DecoratedName"), ShouldEqual, "User: Jane B. Smith [<jane.smith@example.com>]")
+					So(env.computedValues.values, ShouldBeEmpty)
+				})
+			}), ShouldBeNil)
+		})
+		Convey("Values whose model is invalidated before they are stored should be discarded", func() {
+			ref := computedCacheRef{model: users, field: "DecoratedName", id: 1, uid: security.SuperUserID}
+			values := newComputedCacheValues()
+			values.add(ref, "value", fi.cachePolicy)
+			sharedComputedCache.invalidateRecords(users, "", []int64{2})
+			sharedComputedCache.set(ref, values.values[ref])
+			_, ok := sharedComputedCache.get(ref)
+			So(ok, ShouldBeFalse)
+		})
+		Convey("Expired values should be vacuumed", func() {
+			ref := computedCacheRef{model: users, field: "DecoratedName", id: 1, uid: security.SuperUserID}
+			sharedComputedCache.set(ref, sharedComputedCache.newEntry("value", &CachePolicy{TTL: time.Nanosecond}, sharedComputedCache.sequence))
+			So(sharedComputedCache.data[users], ShouldContainKey, int64(1))
+			time.Sleep(time.Millisecond)
+			sharedComputedCache.lastVacuum = time.Time{}
+			ref2 := computedCacheRef{model: users, field: "DecoratedName", id: 2, uid: security.SuperUserID}
+			sharedComputedCache.set(ref2, sharedComputedCache.newEntry("value", fi.cachePolicy, sharedComputedCache.sequence))
+			So(sharedComputedCache.data[users], ShouldNotContainKey, int64(1))
+			So(sharedComputedCache.data[users], ShouldContainKey, int64(2))
+		})
+	})
+}
+
 func TestComputedStoredFields(t *testing.T) {
 	Convey("Testing stored computed fields", t, func() {
 		So(ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
@@ -242,3 +327,36 @@ func TestMixedInModels(t *testing.T) {
 		}), ShouldBeNil)
 	})
 }
+
+func TestDeferredComputations(t *testing.T) {
+	Convey("Testing deferred computations in import mode", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			importEnv := env.WithContext("import_mode", true)
+			profile := importEnv.Pool("Profile").Call("Create", FieldMap{"Age": int16(31)}).(RecordSet).Collection()
+			user := importEnv.Pool("User").Call("Create", FieldMap{
+				"Name":    "Imported User",
+				"Email":   "imported.user@example.com",
+				"Profile": profile,
+			}).(RecordSet).Collection()
+			Convey("Stored fields should be computed when deferred computations are processed", func() {
+				So(env.deferred.computations, ShouldNotBeEmpty)
+				So(user.Get("Age"), ShouldEqual, 0)
+				importEnv.ProcessDeferredComputations()
+				So(env.deferred.computations, ShouldBeEmpty)
+				So(user.Get("Age"), ShouldEqual, 31)
+			})
+			Convey("Records found through dependency paths should be recomputed", func() {
+				importEnv.ProcessDeferredComputations()
+				profile.Call("Write", FieldMap{"Age": int16(32)})
+				So(user.Get("Age"), ShouldEqual, 31)
+				importEnv.ProcessDeferredComputations()
+				So(user.Get("Age"), ShouldEqual, 32)
+			})
+			Convey("Deleted records should be skipped", func() {
+				user.Call("Unlink")
+				So(func() { importEnv.ProcessDeferredComputations() }, ShouldNotPanic)
+				So(env.deferred.computations, ShouldBeEmpty)
+			})
+		}), ShouldBeNil)
+	})
+}
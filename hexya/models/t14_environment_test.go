@@ -15,11 +15,21 @@
 package models
 
 import (
+	"context"
 	"testing"
+	"time"
 
+	"github.com/hexya-erp/hexya/hexya/models/fieldtype"
 	"github.com/hexya-erp/hexya/hexya/models/security"
 	"github.com/hexya-erp/hexya/hexya/models/types"
+	"github.com/hexya-erp/hexya/hexya/tracing"
 	. "github.com/smartystreets/goconvey/convey"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
 )
 
 func TestEnvironment(t *testing.T) {
@@ -179,6 +189,47 @@ func TestEnvironment(t *testing.T) {
 				So(tags.Records()[1].Get("Posts").(RecordSet).Collection().Ids(), ShouldHaveLength, 2)
 				So(tags.Records()[1].Get("Posts").(RecordSet).Collection().Ids(), ShouldContain, post2.ids[0])
 			})
+			Convey("Cache statistics should count hits and misses", func() {
+				stats := env.Cache().Stats()
+				So(stats.Records, ShouldEqual, 0)
+				So(stats.MaxRecords, ShouldEqual, CacheMaxRecords)
+				userJane.Get("Name")
+				So(env.Cache().Stats().Misses, ShouldBeGreaterThan, stats.Misses)
+				So(env.Cache().Stats().Records, ShouldEqual, 1)
+				stats = env.Cache().Stats()
+				userJane.Get("Name")
+				So(env.Cache().Stats().Hits, ShouldBeGreaterThan, stats.Hits)
+				So(env.Cache().Stats().Misses, ShouldEqual, stats.Misses)
+			})
+			Convey("Least recently used records should be evicted when the cache is full", func() {
+				postModel := env.Pool("Post").Model()
+				post1 := env.Pool("Post").Search(postModel.Field("Title").Equals("1st Post"))
+				post3 := env.Pool("Post").Search(postModel.Field("Title").Equals("3rd Post"))
+				env.Cache().SetMaxRecords(2)
+				userJane.Load()
+				post1.Load()
+				userJane.Get("Name")
+				post3.Load()
+				So(env.cache.data, ShouldHaveLength, 2)
+				So(env.cache.data, ShouldContainKey, cacheRef{model: users.model, id: userJane.ids[0]})
+				So(env.cache.data, ShouldContainKey, cacheRef{model: postModel, id: post3.ids[0]})
+				So(env.cache.data, ShouldNotContainKey, cacheRef{model: postModel, id: post1.ids[0]})
+				So(env.Cache().Stats().Evictions, ShouldEqual, 1)
+				So(post1.Get("Title"), ShouldEqual, "1st Post")
+			})
+			Convey("Clearing a model should remove its records and the relations to them", func() {
+				userJane.Load("Posts")
+				janeCacheRef := cacheRef{model: users.model, id: userJane.ids[0]}
+				So(env.cache.data[janeCacheRef], ShouldContainKey, "posts_ids")
+				So(env.cache.data, ShouldHaveLength, 3)
+				env.Cache().Clear("Post")
+				So(env.cache.data, ShouldHaveLength, 1)
+				So(env.cache.data[janeCacheRef], ShouldContainKey, "name")
+				So(env.cache.data[janeCacheRef], ShouldNotContainKey, "posts_ids")
+				So(userJane.Get("Posts").(RecordSet).Collection().Len(), ShouldEqual, 2)
+				env.Cache().Clear()
+				So(env.cache.data, ShouldBeEmpty)
+			})
 			Convey("Check that computed fields are stored and read in cache", func() {
 				userJane.Load()
 				janeCacheRef := cacheRef{model: users.model, id: userJane.ids[0]}
@@ -228,3 +279,172 @@ func TestEnvironment(t *testing.T) {
 		}), ShouldBeNil)
 	})
 }
+
+func TestContext(t *testing.T) {
+	Convey("Testing Context", t, func() {
+		Convey("Context should be immutable", func() {
+			ctx := types.NewContext().WithKey("key", "value")
+			ctx2 := ctx.WithKey("key", "other value")
+			So(ctx.GetString("key"), ShouldEqual, "value")
+			So(ctx2.GetString("key"), ShouldEqual, "other value")
+		})
+		Convey("Checking well known keys", func() {
+			ctx := types.NewContext()
+			So(ctx.Lang(), ShouldEqual, "")
+			So(ctx.TZ(), ShouldEqual, time.UTC)
+			So(ctx.CompanyID(), ShouldEqual, 0)
+			So(ctx.ActiveTest(), ShouldBeTrue)
+			ctx = ctx.WithKey("lang", "fr_FR").WithKey("tz", "Europe/Paris").
+				WithKey("company_id", 3).WithKey("active_test", false)
+			So(ctx.Lang(), ShouldEqual, "fr_FR")
+			So(ctx.TZ().String(), ShouldEqual, "Europe/Paris")
+			So(ctx.TZ(), ShouldEqual, ctx.TZ())
+			So(ctx.CompanyID(), ShouldEqual, 3)
+			So(ctx.ActiveTest(), ShouldBeFalse)
+			So(ctx.WithKey("tz", "Unknown/Zone").TZ(), ShouldEqual, time.UTC)
+		})
+		Convey("Context should be propagated", func() {
+			So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+				env2 := env.WithContext("lang", "fr_FR")
+				So(env.Context().HasKey("lang"), ShouldBeFalse)
+				users := env2.Pool("User")
+				So(users.Env().Context().Lang(), ShouldEqual, "fr_FR")
+				for _, rec := range users.SearchAll().Records() {
+					So(rec.Env().Context().Lang(), ShouldEqual, "fr_FR")
+					posts := rec.Get("Posts").(RecordSet).Collection()
+					So(posts.Env().Context().Lang(), ShouldEqual, "fr_FR")
+				}
+			}), ShouldBeNil)
+		})
+	})
+}
+func TestUserPreferences(t *testing.T) {
+	Preferences.Register(&Preference{Name: "homepage", Type: fieldtype.Char, Default: "/web"})
+	Preferences.Register(&Preference{Name: "pageSize", Type: fieldtype.Integer, Default: int64(80)})
+	Preferences.Register(&Preference{Name: "debug", Type: fieldtype.Boolean, Default: false,
+		Groups: []*security.Group{security.GroupAdmin}})
+	Convey("Testing user preferences", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			Convey("Registering an existing preference should panic", func() {
+				So(func() { Preferences.Register(&Preference{Name: "homepage", Type: fieldtype.Char}) }, ShouldPanic)
+			})
+			Convey("Unset preferences should return default values", func() {
+				So(env.User().Pref("homepage"), ShouldEqual, "/web")
+				So(env.User().Pref("pageSize"), ShouldEqual, 80)
+				So(func() { env.User().Pref("unknown") }, ShouldPanic)
+			})
+			Convey("Setting preferences", func() {
+				env.User().SetPref("homepage", "/web#menu=3")
+				env.User().SetPref("pageSize", 40)
+				So(env.User().Pref("homepage"), ShouldEqual, "/web#menu=3")
+				So(env.User().Pref("pageSize"), ShouldEqual, 40)
+				env.User().SetPref("pageSize", 20)
+				So(env.User().Pref("pageSize"), ShouldEqual, 20)
+				So(func() { env.User().SetPref("pageSize", "many") }, ShouldPanic)
+				prefs := env.User().Prefs()
+				So(prefs, ShouldHaveLength, 3)
+				So(prefs["homepage"], ShouldEqual, "/web#menu=3")
+				So(prefs["debug"], ShouldEqual, false)
+			})
+			Convey("Preferences should be restricted to their groups", func() {
+				env2 := newEnvironment(2)
+				So(env2.User().Pref("homepage"), ShouldEqual, "/web")
+				So(func() { env2.User().Pref("debug") }, ShouldPanic)
+				So(env2.User().Prefs(), ShouldHaveLength, 2)
+				env2.rollback()
+			})
+		}), ShouldBeNil)
+	})
+}
+
+func TestQueryBudget(t *testing.T) {
+	Convey("Testing SQL query budget", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			users := env.Pool("User")
+			Convey("Queries should be counted", func() {
+				count := env.Cr().QueryCount()
+				users.SearchAll().Load()
+				So(env.Cr().QueryCount(), ShouldBeGreaterThan, count)
+			})
+			Convey("Query statistics should be recorded", func() {
+				before := env.QueryStats()
+				users.SearchAll().Load()
+				after := env.QueryStats()
+				So(after.Count, ShouldBeGreaterThan, before.Count)
+				So(after.Count, ShouldEqual, env.Cr().QueryCount())
+				So(after.Duration, ShouldBeGreaterThan, before.Duration)
+				So(after.SlowQueries, ShouldEqual, 0)
+			})
+			Convey("Slow queries should be counted", func() {
+				SlowQueryThreshold = time.Nanosecond
+				defer func() { SlowQueryThreshold = 0 }()
+				before := env.QueryStats()
+				users.SearchAll().Load()
+				So(env.QueryStats().SlowQueries, ShouldBeGreaterThan, before.SlowQueries)
+			})
+			Convey("Exceeding the budget should panic in test mode", func() {
+				testMode := Testing
+				Testing = true
+				defer func() { Testing = testMode }()
+				env.Cr().SetQueryBudget(env.Cr().QueryCount())
+				So(func() { users.SearchAll().Load() }, ShouldPanic)
+			})
+			Convey("Exceeding the budget should only log outside test mode", func() {
+				testMode := Testing
+				Testing = false
+				defer func() { Testing = testMode }()
+				env.Cr().SetQueryBudget(env.Cr().QueryCount())
+				So(func() { users.SearchAll().Load() }, ShouldNotPanic)
+			})
+		}), ShouldBeNil)
+	})
+}
+
+func TestTracing(t *testing.T) {
+	Convey("Testing tracing of the ORM", t, func() {
+		recorder := tracetest.NewSpanRecorder()
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+		defer otel.SetTracerProvider(noop.NewTracerProvider())
+		ctx, root := tracing.Tracer().Start(context.Background(), "root")
+		WithTraceContext(ctx, func() {
+			So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+				So(TraceContext(), ShouldNotEqual, ctx)
+				env.Pool("Tag").Call("Create", FieldMap{"Name": "Traced Tag"})
+			}), ShouldBeNil)
+			So(TraceContext(), ShouldEqual, ctx)
+		})
+		root.End()
+		// Spans end after their children, so that we keep the
+		// outermost span of each name.
+		spans := make(map[string]sdktrace.ReadOnlySpan)
+		byID := make(map[trace.SpanID]sdktrace.ReadOnlySpan)
+		for _, span := range recorder.Ended() {
+			spans[span.Name()] = span
+			byID[span.SpanContext().SpanID()] = span
+		}
+		So(spans, ShouldContainKey, "Environment")
+		So(spans, ShouldContainKey, "Tag.Create")
+		So(spans, ShouldContainKey, "Tag.create")
+		So(spans, ShouldContainKey, "SQL")
+		Convey("Spans should be nested along the call stack", func() {
+			So(spans["Environment"].Parent().SpanID(), ShouldEqual, root.SpanContext().SpanID())
+			So(spans["Tag.Create"].Parent().SpanID(), ShouldEqual, spans["Environment"].SpanContext().SpanID())
+			So(byID[spans["Tag.create"].Parent().SpanID()].Name(), ShouldEqual, "Tag.Create")
+		})
+		Convey("Spans should hold the model, method and row count", func() {
+			So(spans["Tag.Create"].Attributes(), ShouldContain, tracing.ModelKey.String("Tag"))
+			So(spans["Tag.Create"].Attributes(), ShouldContain, tracing.MethodKey.String("Create"))
+			So(spans["Tag.create"].Attributes(), ShouldContain, tracing.RowsKey.Int(1))
+		})
+		Convey("Failed environments should be marked as errors", func() {
+			WithTraceContext(ctx, func() {
+				So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+					panic("traced failure")
+				}), ShouldNotBeNil)
+			})
+			ended := recorder.Ended()
+			So(ended[len(ended)-1].Name(), ShouldEqual, "Environment")
+			So(ended[len(ended)-1].Status().Code, ShouldEqual, codes.Error)
+		})
+	})
+}
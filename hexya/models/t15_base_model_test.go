@@ -4,11 +4,13 @@
 package models
 
 import (
+	"encoding/json"
 	"fmt"
 	"testing"
 	"time"
 
 	"github.com/hexya-erp/hexya/hexya/models/fieldtype"
+	"github.com/hexya-erp/hexya/hexya/models/operator"
 	"github.com/hexya-erp/hexya/hexya/models/security"
 	"github.com/hexya-erp/hexya/hexya/models/types/dates"
 	. "github.com/smartystreets/goconvey/convey"
@@ -63,7 +65,40 @@ func TestBaseModelMethods(t *testing.T) {
 				So(userJaneCopy.Get("Password"), ShouldBeBlank)
 				So(userJaneCopy.Get("Age"), ShouldEqual, 24)
 				So(userJaneCopy.Get("Nums"), ShouldEqual, 2)
-				So(userJaneCopy.Get("Posts").(RecordSet).Collection().Len(), ShouldEqual, 0)
+				So(userJaneCopy.Get("Posts").(RecordSet).Collection().Len(), ShouldEqual, 2)
+				So(userJane.Get("Posts").(RecordSet).Collection().Len(), ShouldEqual, 2)
+				for _, post := range userJaneCopy.Get("Posts").(RecordSet).Collection().Records() {
+					So(post.Get("User").(RecordSet).Collection().Ids(), ShouldResemble, userJaneCopy.Ids())
+				}
+			})
+			Convey("Copy of unique char fields and children", func() {
+				companies := env.Pool("Company")
+				group := companies.Call("Create", FieldMap{"Name": "Copy Group"}).(RecordSet).Collection()
+				companies.Call("Create", FieldMap{"Name": "Copy Subsidiary", "Parent": group})
+				groupCopy := group.Call("Copy", FieldMap{}).(RecordSet).Collection()
+				So(groupCopy.Get("Name"), ShouldEqual, "Copy Group (copy)")
+				children := groupCopy.Get("Children").(RecordSet).Collection()
+				So(children.Len(), ShouldEqual, 1)
+				So(children.Get("Name"), ShouldEqual, "Copy Subsidiary (copy)")
+				So(children.Get("Parent").(RecordSet).Collection().Ids(), ShouldResemble, groupCopy.Ids())
+				So(group.Get("Children").(RecordSet).Collection().Get("Name"), ShouldEqual, "Copy Subsidiary")
+				otherCopy := group.Call("Copy", FieldMap{"Name": "Other Group"}).(RecordSet).Collection()
+				So(otherCopy.Get("Name"), ShouldEqual, "Other Group")
+			})
+			Convey("Copy should skip no copy, computed and related one2many fields", func() {
+				tags := env.Pool("Tag")
+				grandParent := tags.Call("Create", FieldMap{"Name": "Copy Grand Parent", "Description": "Grand parent"}).(RecordSet).Collection()
+				parent := tags.Call("Create", FieldMap{"Name": "Copy Parent", "Description": "Parent", "Parent": grandParent}).(RecordSet).Collection()
+				tags.Call("Create", FieldMap{"Name": "Copy Uncle", "Description": "Uncle", "Parent": grandParent})
+				tags.Call("Create", FieldMap{"Name": "Copy Child", "Description": "Child", "Parent": parent, "Rate": float32(5)})
+				So(parent.Get("Siblings").(RecordSet).Collection().Len(), ShouldEqual, 2)
+				So(parent.Get("Children").(RecordSet).Collection().Len(), ShouldEqual, 1)
+				So(parent.Get("RatedChildren").(RecordSet).Collection().Len(), ShouldEqual, 1)
+				count := tags.SearchAll().Len()
+				parentCopy := parent.Call("Copy", FieldMap{"Description": "Parent copy"}).(RecordSet).Collection()
+				So(tags.SearchAll().Len(), ShouldEqual, count+1)
+				So(parentCopy.Get("Children").(RecordSet).Collection().IsEmpty(), ShouldBeTrue)
+				So(parentCopy.Get("RatedChildren").(RecordSet).Collection().IsEmpty(), ShouldBeTrue)
 			})
 			Convey("FieldGet and FieldsGet", func() {
 				fInfo := userJane.Call("FieldGet", FieldName("Name")).(*FieldInfo)
@@ -72,12 +107,27 @@ func TestBaseModelMethods(t *testing.T) {
 				So(fInfo.Type, ShouldEqual, fieldtype.Char)
 				fInfos := userJane.Call("FieldsGet", FieldsGetArgs{}).(map[string]*FieldInfo)
 				So(fInfos, ShouldHaveLength, 30)
+				fInfos = userJane.FieldsGet(FieldName("Name"), FieldName("DisplayName"))
+				So(fInfos, ShouldHaveLength, 2)
+				So(fInfos["name"].Readable, ShouldBeTrue)
+				So(fInfos["name"].Writable, ShouldBeTrue)
+				So(fInfos["display_name"].ReadOnly, ShouldBeTrue)
+				So(fInfos["display_name"].Writable, ShouldBeFalse)
 			})
 			Convey("NameGet", func() {
 				So(userJane.Get("DisplayName"), ShouldEqual, "Jane A. Smith")
 				profile := userJane.Get("Profile").(RecordSet).Collection()
 				So(profile.Get("DisplayName"), ShouldEqual, fmt.Sprintf("Profile(%d)", profile.Get("ID")))
 			})
+			Convey("NameSearch", func() {
+				So(userModel.RecordNameFields(), ShouldResemble, []string{"Name"})
+				res := env.Pool(userModel.name).Call("NameSearch", "jane a.", operator.IContains, 0).(RecordSet).Collection()
+				So(res.Len(), ShouldEqual, 1)
+				So(res.Ids()[0], ShouldEqual, userJane.Ids()[0])
+				profile := userJane.Get("Profile").(RecordSet).Collection()
+				So(profile.Model().RecordNameFields(), ShouldBeEmpty)
+				So(profile.Call("NameSearch", "foo", operator.IContains, 0).(RecordSet).Collection().IsEmpty(), ShouldBeTrue)
+			})
 			Convey("DefaultGet", func() {
 				defaults := userJane.Call("DefaultGet").(FieldMap)
 				So(defaults, ShouldHaveLength, 6)
@@ -120,7 +170,9 @@ func TestBaseModelMethods(t *testing.T) {
 					"Parent": tag2,
 				}).(RecordSet).Collection()
 				So(tag3.Call("CheckRecursion").(bool), ShouldBeTrue)
-				tag1.Set("Parent", tag3)
+				So(func() { tag1.Set("Parent", tag3) }, ShouldPanic)
+				// Create the loop bypassing the ORM which prevents it
+				env.Cr().Execute(`UPDATE tag SET parent_id = ? WHERE id = ?`, tag3.Ids()[0], tag1.Ids()[0])
 				So(tag1.Call("CheckRecursion").(bool), ShouldBeFalse)
 				So(tag2.Call("CheckRecursion").(bool), ShouldBeFalse)
 				So(tag3.Call("CheckRecursion").(bool), ShouldBeFalse)
@@ -357,3 +409,55 @@ func TestBaseModelMethods(t *testing.T) {
 		}), ShouldBeNil)
 	})
 }
+
+func TestDescribe(t *testing.T) {
+	Convey("Testing models description", t, func() {
+		descs := Registry.Describe()
+		var user *ModelDescription
+		for i, desc := range descs {
+			So(Registry.MustGet(desc.Name).isMixin(), ShouldBeFalse)
+			So(Registry.MustGet(desc.Name).isSystem(), ShouldBeFalse)
+			if desc.Name == "User" {
+				user = &descs[i]
+			}
+		}
+		So(user, ShouldNotBeNil)
+		var nameField *FieldDescription
+		for i, field := range user.Fields {
+			if field.Name == "Name" {
+				nameField = &user.Fields[i]
+			}
+		}
+		So(nameField, ShouldNotBeNil)
+		So(nameField.JSON, ShouldEqual, "name")
+		So(nameField.Type, ShouldEqual, fieldtype.Char)
+		var create *MethodDescription
+		for i, method := range user.Methods {
+			if method.Name == "Create" {
+				create = &user.Methods[i]
+			}
+		}
+		So(create, ShouldNotBeNil)
+		So(create.Type.NumIn(), ShouldEqual, 2)
+		So(create.Doc, ShouldEqual, `Create inserts a record in the database from the given data.
+Returns the created RecordCollection.`)
+		Convey("Method descriptions should be marshalled with their parameters", func() {
+			data, err := json.Marshal(create)
+			So(err, ShouldBeNil)
+			So(string(data), ShouldEqual, `{"name":"Create","doc":"Create inserts a record in the database from the given data.\nReturns the created RecordCollection.","params":["models.FieldMapper"],"returns":["*models.RecordCollection"]}`)
+		})
+		Convey("Describing a model in an environment should give field help", func() {
+			So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+				desc := env.DescribeModel("User")
+				So(desc.Name, ShouldEqual, "User")
+				So(desc.Fields, ShouldHaveLength, len(user.Fields))
+				So(desc.Methods, ShouldHaveLength, len(user.Methods))
+				for _, field := range desc.Fields {
+					if field.Name == "Name" {
+						So(field.Help, ShouldEqual, "The user's username")
+					}
+				}
+			}), ShouldBeNil)
+		})
+	})
+}
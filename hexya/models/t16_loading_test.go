@@ -0,0 +1,178 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hexya-erp/hexya/hexya/models/fieldtype"
+	"github.com/hexya-erp/hexya/hexya/models/security"
+	"github.com/hexya-erp/hexya/hexya/models/types"
+	"github.com/hexya-erp/hexya/hexya/models/types/dates"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestConvertValueToFieldType(t *testing.T) {
+	Convey("Testing the conversion of database values to field types", t, func() {
+		day := time.Date(2020, 3, 15, 0, 0, 0, 0, time.UTC)
+		moment := time.Date(2020, 3, 15, 10, 30, 0, 0, time.UTC)
+		cases := []struct {
+			model    string
+			field    string
+			value    interface{}
+			expected interface{}
+		}{
+			{"Post", "Attachment", []byte("data"), "data"},
+			{"User", "IsStaff", true, true},
+			{"User", "IsStaff", false, false},
+			{"User", "Email", "jane@example.com", "jane@example.com"},
+			{"User", "Email", []byte("jane@example.com"), "jane@example.com"},
+			{"User", "Email", nil, ""},
+			{"Post", "LastRead", day, dates.Date{Time: day}},
+			{"User", "CreateDate", moment, dates.DateTime{Time: moment}},
+			{"User", "Size", 1.5, 1.5},
+			{"User", "Size", []byte("1.5"), 1.5},
+			{"Tag", "Rate", []byte("2.5"), float32(2.5)},
+			{"Post", "Content", "<p>Hello</p>", "<p>Hello</p>"},
+			{"User", "Nums", int64(3), 3},
+			{"User", "Status", int64(12), int16(12)},
+			{"Note", "Meta", []byte(`{"key":"value"}`), map[string]interface{}{"key": "value"}},
+			{"Post", "Tags", []int64{1, 2}, []int64{1, 2}},
+			{"User", "Profile", int64(4), int64(4)},
+			{"User", "LastPost", int64(4), int64(4)},
+			{"User", "LastPost", nil, (*interface{})(nil)},
+			{"Profile", "Balance", []byte("10.25"), 10.25},
+			{"User", "Posts", []int64{3, 4}, []int64{3, 4}},
+			{"Profile", "BestPost", int64(5), int64(5)},
+			{"Post", "BestPostProfile", int64(6), int64(6)},
+			{"Profile", "Gender", []byte("male"), "male"},
+			{"Post", "Abstract", "Summary", "Summary"},
+		}
+		covered := make(map[fieldtype.Type]bool)
+		for _, c := range cases {
+			mi := Registry.MustGet(c.model)
+			fi := mi.fields.MustGet(c.field)
+			covered[fi.fieldType] = true
+			res := mi.convertValueToFieldType(fi, fi.json, c.value)
+			So(reflect.TypeOf(res), ShouldEqual, reflect.TypeOf(c.expected))
+			switch exp := c.expected.(type) {
+			case dates.Date:
+				So(res.(dates.Date).Equal(exp.Time), ShouldBeTrue)
+			case dates.DateTime:
+				So(res.(dates.DateTime).Equal(exp.Time), ShouldBeTrue)
+			default:
+				So(res, ShouldResemble, c.expected)
+			}
+		}
+		note := Registry.MustGet("Note")
+		checklist := note.fields.MustGet("Checklist")
+		covered[checklist.fieldType] = true
+		list := note.convertValueToFieldType(checklist, checklist.json, []byte(`[{"Label":"Eggs","Done":true}]`))
+		So(list, ShouldHaveSameTypeAs, types.EmbeddedList{})
+		So(list.(types.EmbeddedList).Len(), ShouldEqual, 1)
+		So(list.(types.EmbeddedList)[0].GetString("Label"), ShouldEqual, "Eggs")
+		So(note.convertValueToFieldType(checklist, checklist.json, nil), ShouldHaveSameTypeAs, types.EmbeddedList{})
+		Convey("All field types should be tested", func() {
+			for _, typ := range []fieldtype.Type{fieldtype.Binary, fieldtype.Boolean, fieldtype.Char,
+				fieldtype.Date, fieldtype.DateTime, fieldtype.EmbeddedList, fieldtype.Float, fieldtype.HTML,
+				fieldtype.Integer, fieldtype.JSON, fieldtype.Many2Many, fieldtype.Many2One, fieldtype.Monetary,
+				fieldtype.One2Many, fieldtype.One2One, fieldtype.Rev2One, fieldtype.Selection, fieldtype.Text} {
+				So(covered, ShouldContainKey, typ)
+			}
+		})
+	})
+}
+
+// benchmarkTags creates count tags in the given Environment
+// and returns the SQL query that selects their stored fields.
+func benchmarkTags(env Environment, count int) (string, SQLParams) {
+	tags := env.Pool("Tag")
+	for i := 0; i < count; i++ {
+		tags.Call("Create", FieldMap{
+			"Name":        fmt.Sprintf("Benchmark Tag %d", i),
+			"Description": fmt.Sprintf("Benchmark tag number %d", i),
+			"Rate":        float32(i % 10),
+		})
+	}
+	fields := filterOnDBFields(tags.model, tags.model.fields.storedFieldNames())
+	return tags.query.selectQuery(fields)
+}
+
+// BenchmarkRowScanner compares scanning rows with a single rowScanner, as
+// done by Load, with creating a rowScanner for each row, which costs as much
+// as resolving the columns and fields of each row.
+func BenchmarkRowScanner(b *testing.B) {
+	err := SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+		sql, args := benchmarkTags(env, 1000)
+		tagModel := Registry.MustGet("Tag")
+		b.Run("Reused", func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				rows := env.cr.query(sql, args...)
+				scanner, err := tagModel.newRowScanner(rows)
+				if err != nil {
+					b.Fatal(err)
+				}
+				for rows.Next() {
+					if _, err := scanner.scan(rows); err != nil {
+						b.Fatal(err)
+					}
+				}
+				rows.Close()
+			}
+		})
+		b.Run("PerRow", func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				rows := env.cr.query(sql, args...)
+				for rows.Next() {
+					scanner, err := tagModel.newRowScanner(rows)
+					if err != nil {
+						b.Fatal(err)
+					}
+					if _, err := scanner.scan(rows); err != nil {
+						b.Fatal(err)
+					}
+				}
+				rows.Close()
+			}
+		})
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+}
+
+// BenchmarkCacheGet compares getting a field of a cached record, which
+// takes the fast path of getRelatedRef, with getting a related field.
+func BenchmarkCacheGet(b *testing.B) {
+	err := SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+		profile := env.Pool("Profile").Call("Create", FieldMap{"Age": int16(30)}).(RecordSet).Collection()
+		user := env.Pool("User").Call("Create", FieldMap{
+			"Name":    "Benchmark User",
+			"Email":   "benchmark@example.com",
+			"Profile": profile,
+		}).(RecordSet).Collection()
+		user.Load("Name", "Profile.Age")
+		userModel, id := user.model, user.ids[0]
+		b.Run("Field", func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				env.cache.get(userModel, id, "Name")
+			}
+		})
+		b.Run("RelatedField", func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				env.cache.get(userModel, id, "Profile.Age")
+			}
+		})
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+}
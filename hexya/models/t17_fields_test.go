@@ -0,0 +1,535 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hexya-erp/hexya/hexya/models/fieldtype"
+	"github.com/hexya-erp/hexya/hexya/models/security"
+	"github.com/hexya-erp/hexya/hexya/models/types"
+	"github.com/hexya-erp/hexya/hexya/models/types/dates"
+	"github.com/hexya-erp/hexya/hexya/tools/xlsx"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCountFields(t *testing.T) {
+	Convey("Testing count fields", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			users := env.Pool("User")
+			posts := env.Pool("Post")
+			userJane := users.Search(users.Model().Field("Email").Equals("jane.smith@example.com"))
+			userWill := users.Search(users.Model().Field("Name").Equals("Will Smith"))
+			janeCount := userJane.Get("PostsCount").(int64)
+			willCount := userWill.Get("PostsCount").(int64)
+			So(janeCount, ShouldEqual, userJane.Get("Posts").(RecordSet).Collection().Len())
+			Convey("Creating a post should increment the counter", func() {
+				posts.Call("Create", FieldMap{"Title": "Counted Post", "Content": "Content", "User": userJane})
+				So(userJane.Get("PostsCount"), ShouldEqual, janeCount+1)
+			})
+			Convey("Changing the post's user should move the count", func() {
+				post := posts.Call("Create", FieldMap{"Title": "Counted Post", "Content": "Content", "User": userJane}).(RecordSet).Collection()
+				post.Set("User", userWill)
+				So(userJane.Get("PostsCount"), ShouldEqual, janeCount)
+				So(userWill.Get("PostsCount"), ShouldEqual, willCount+1)
+				post.Set("User", nil)
+				So(userWill.Get("PostsCount"), ShouldEqual, willCount)
+			})
+			Convey("Deleting a post should decrement the counter", func() {
+				post := posts.Call("Create", FieldMap{"Title": "Counted Post", "Content": "Content", "User": userJane}).(RecordSet).Collection()
+				post.Call("Unlink")
+				So(userJane.Get("PostsCount"), ShouldEqual, janeCount)
+			})
+		}), ShouldBeNil)
+	})
+}
+
+func TestTranslatedFields(t *testing.T) {
+	Convey("Testing translatable fields", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			posts := env.Pool("Post")
+			post := posts.Search(posts.Model().Field("Title").Equals("1st Post"))
+			So(post.Len(), ShouldEqual, 1)
+			postFr := post.WithContext("lang", "fr_FR")
+			Convey("Untranslated values should fall back to the stored value", func() {
+				So(postFr.Get("Title"), ShouldEqual, "1st Post")
+			})
+			Convey("Writing in another language should only write the translation", func() {
+				postFr.Set("Title", "1er article")
+				So(postFr.Get("Title"), ShouldEqual, "1er article")
+				So(post.Get("Title"), ShouldEqual, "1st Post")
+				So(post.WithContext("lang", DefaultLang).Get("Title"), ShouldEqual, "1st Post")
+				So(post.WithContext("lang", "de_DE").Get("Title"), ShouldEqual, "1st Post")
+				So(postFr.Read("Title")[0]["Title"], ShouldEqual, "1er article")
+				Convey("Translations should be read in a new environment", func() {
+					env.Cache().Clear()
+					postFr = posts.Search(posts.Model().Field("Title").Equals("1st Post")).WithContext("lang", "fr_FR")
+					So(postFr.Get("Title"), ShouldEqual, "1er article")
+				})
+			})
+		}), ShouldBeNil)
+	})
+}
+
+func TestAttachmentBinaryFields(t *testing.T) {
+	Convey("Testing attachment binary fields", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			// "hello" base64 encoded and its SHA1 checksum
+			helloB64 := "aGVsbG8="
+			helloChecksum := "aaf4c61ddcc5e8a2dabede0f3b482cd9aea9434d"
+			cv1 := env.Pool("Resume").Call("Create", FieldMap{"Photo": helloB64}).(RecordSet).Collection()
+			cv2 := env.Pool("Resume").Call("Create", FieldMap{"Photo": helloB64}).(RecordSet).Collection()
+			Convey("Contents should be read back from the filestore", func() {
+				So(cv1.Get("Photo"), ShouldEqual, helloB64)
+				So(cv2.Get("Photo"), ShouldEqual, helloB64)
+			})
+			Convey("Only the checksum should be stored in the table and the cache", func() {
+				var checksum string
+				env.cr.Get(&checksum, "SELECT photo FROM resume WHERE id = ?", cv1.ids[0])
+				So(checksum, ShouldEqual, helloChecksum)
+				cached, _ := cv1.get("Photo", false)
+				So(cached, ShouldEqual, helloChecksum)
+			})
+			Convey("Identical contents should only be stored once", func() {
+				var count int
+				dbGetNoTx(&count, "SELECT COUNT(*) FROM binary_content WHERE checksum = ?", helloChecksum)
+				So(count, ShouldEqual, 1)
+			})
+			Convey("Contents should be streamed with OpenBinary and WriteBinary", func() {
+				reader := cv1.OpenBinary("Photo")
+				content, _ := ioutil.ReadAll(reader)
+				reader.Close()
+				So(string(content), ShouldEqual, "hello")
+				cv1.WriteBinary("Photo", strings.NewReader("world"))
+				So(cv1.Get("Photo"), ShouldEqual, "d29ybGQ=")
+				So(cv2.Get("Photo"), ShouldEqual, helloB64)
+			})
+			Convey("Streaming non attachment fields should panic", func() {
+				So(func() { cv1.OpenBinary("Education") }, ShouldPanic)
+			})
+		}), ShouldBeNil)
+	})
+}
+
+func TestImageFields(t *testing.T) {
+	Convey("Testing image fields", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			var buf bytes.Buffer
+			png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 64, 40)))
+			imgB64 := base64.StdEncoding.EncodeToString(buf.Bytes())
+			cv := env.Pool("Resume").Call("Create", FieldMap{"Picture": imgB64}).(RecordSet).Collection()
+			imageSize := func(b64 string) (int, int) {
+				cfg, _, err := image.DecodeConfig(base64.NewDecoder(base64.StdEncoding, strings.NewReader(b64)))
+				So(err, ShouldBeNil)
+				return cfg.Width, cfg.Height
+			}
+			Convey("Get should return the original image", func() {
+				So(cv.Get("Picture"), ShouldEqual, imgB64)
+				So(cv.GetImage("Picture", 0), ShouldEqual, imgB64)
+			})
+			Convey("GetImage should return the smallest variant at least as large as size", func() {
+				w, h := imageSize(cv.GetImage("Picture", 8))
+				So(w, ShouldEqual, 8)
+				So(h, ShouldEqual, 5)
+				w, h = imageSize(cv.GetImage("Picture", 10))
+				So(w, ShouldEqual, 32)
+				So(h, ShouldEqual, 20)
+				So(cv.GetImage("Picture", 50), ShouldEqual, imgB64)
+			})
+			Convey("Invalid images should be rejected", func() {
+				So(func() { cv.Call("Write", FieldMap{"Picture": "aGVsbG8="}) }, ShouldPanic)
+				So(func() { cv.GetImage("Photo", 8) }, ShouldPanic)
+			})
+		}), ShouldBeNil)
+	})
+}
+
+func TestMonetaryFields(t *testing.T) {
+	Convey("Testing monetary fields and currencies", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			eur := env.Pool("Currency").Call("Create", FieldMap{"Name": "EUR", "Symbol": "€"}).(RecordSet).Collection()
+			jpy := env.Pool("Currency").Call("Create", FieldMap{"Name": "JPY", "Symbol": "¥", "Rounding": 1.0}).(RecordSet).Collection()
+			date := dates.Today()
+			env.Pool("CurrencyRate").Call("Create", FieldMap{"Currency": jpy.ids[0], "Name": date.AddDate(0, 0, -10), "Rate": 100.0})
+			env.Pool("CurrencyRate").Call("Create", FieldMap{"Currency": jpy.ids[0], "Name": date, "Rate": 130.0})
+			Convey("Currency helpers should round and convert amounts", func() {
+				So(eur.Get("DecimalPlaces"), ShouldEqual, 2)
+				So(jpy.Get("DecimalPlaces"), ShouldEqual, 0)
+				So(eur.Call("Round", 12.345678), ShouldEqual, 12.35)
+				So(eur.Call("IsZero", 0.004), ShouldBeTrue)
+				So(eur.Call("CompareAmounts", 1.001, 1.004), ShouldEqual, 0)
+				So(jpy.Call("RateAt", date.AddDate(0, 0, -5)), ShouldEqual, 100)
+				So(jpy.Get("Rate"), ShouldEqual, 130)
+				So(eur.Call("Convert", 10.0, jpy, date, true), ShouldEqual, 1300)
+				So(jpy.Call("Convert", 1234.0, eur, date.AddDate(0, 0, -5), true), ShouldEqual, 12.34)
+			})
+			Convey("Monetary values should be rounded to their currency on write", func() {
+				profile := env.Pool("Profile").Call("Create", FieldMap{"Currency": eur.ids[0], "Balance": 10.126}).(RecordSet).Collection()
+				So(profile.Get("Balance"), ShouldEqual, 10.13)
+				profile.Call("Write", FieldMap{"Currency": jpy.ids[0]})
+				So(profile.Get("Balance"), ShouldEqual, 10)
+				profile.Call("Write", FieldMap{"Balance": 2.5})
+				So(profile.Get("Balance"), ShouldEqual, 3)
+			})
+			Convey("Aggregated monetary values should be rounded to the field's digits", func() {
+				env.Pool("Profile").Call("Create", FieldMap{"Country": "Monetaria", "Balance": 0.125})
+				env.Pool("Profile").Call("Create", FieldMap{"Country": "Monetaria", "Balance": 0.25})
+				profiles := env.Pool("Profile").Search(env.Pool("Profile").Model().Field("Country").Equals("Monetaria"))
+				groups := profiles.GroupBy(FieldName("Country")).Aggregates(FieldName("Country"), FieldName("Balance"))
+				So(groups, ShouldHaveLength, 1)
+				So(groups[0].Values["balance"], ShouldEqual, 0.38)
+			})
+			Convey("Monetary fields must have a currency field", func() {
+				So(validateModel(Registry.MustGet("Profile")), ShouldBeEmpty)
+			})
+			Convey("Monetary values should be exported to spreadsheets with their currency", func() {
+				profile := env.Pool("Profile").Call("Create", FieldMap{"Currency": eur.ids[0], "Balance": 10.5, "Money": 1.25}).(RecordSet).Collection()
+				So(profile.exportValue("Balance", true), ShouldResemble, xlsx.Cell{Value: 10.5, Format: `#,##0.00 "€"`})
+				So(profile.exportValue("Balance", false), ShouldEqual, 10.5)
+				So(profile.exportValue("Money", true), ShouldEqual, 1.25)
+				So(profile.ExportRows([]string{"Balance"}), ShouldResemble, [][]interface{}{{10.5}})
+				var buf bytes.Buffer
+				ExportSheets(&buf,
+					ExportSheet{Name: "Profiles", Records: profile, Fields: []string{"Country", "Balance"}},
+					ExportSheet{Records: profile, Fields: []string{"Currency"}})
+				So(buf.Len(), ShouldBeGreaterThan, 0)
+			})
+		}), ShouldBeNil)
+	})
+}
+
+func TestDateTimeFields(t *testing.T) {
+	Convey("Testing time zones of datetime fields", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			paris, _ := time.LoadLocation("Europe/Paris")
+			created := dates.DateTime{Time: time.Date(2017, 8, 2, 1, 30, 0, 0, paris)}
+			user := env.Pool("User").Call("Create", FieldMap{"Name": "Zoe Zone", "Email": "zoe@example.com"}).(RecordSet).Collection()
+			user.doUpdate(FieldMap{"CreateDate": created})
+			Convey("DateTime values should be stored in UTC", func() {
+				var stored time.Time
+				env.Cr().Get(&stored, `SELECT create_date FROM "user" WHERE id = ?`, user.ids[0])
+				So(stored.Hour(), ShouldEqual, 23)
+			})
+			Convey("DateTime values should be read in the time zone of the context", func() {
+				So(user.Get("CreateDate").(dates.DateTime).Location(), ShouldEqual, time.UTC)
+				local := user.WithContext("tz", "Europe/Paris").Get("CreateDate").(dates.DateTime)
+				So(local.Location().String(), ShouldEqual, "Europe/Paris")
+				So(local.Hour(), ShouldEqual, 1)
+				So(local.Equal(created), ShouldBeTrue)
+			})
+			Convey("Searching datetimes by date should use the time zone of the context", func() {
+				day := created.In(paris).ToDate()
+				users := env.Pool("User").Search(env.Pool("User").Model().Field("CreateDate").Equals(day))
+				So(users.Ids(), ShouldNotContain, user.ids[0])
+				parisUsers := env.WithContext("tz", "Europe/Paris").Pool("User")
+				parisUsers = parisUsers.Search(parisUsers.Model().Field("CreateDate").Equals(day))
+				So(parisUsers.Ids(), ShouldContain, user.ids[0])
+			})
+		}), ShouldBeNil)
+	})
+}
+
+func TestCompanyDependentFields(t *testing.T) {
+	Convey("Testing company dependent fields", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			profile := env.Pool("Profile").Call("Create", FieldMap{"Money": 12.0}).(RecordSet).Collection()
+			other := env.Pool("Profile").Call("Create", FieldMap{"Money": 15.0}).(RecordSet).Collection()
+			inCompany1 := profile.WithContext("company_id", int64(1))
+			inCompany2 := profile.WithContext("company_id", int64(2))
+			Convey("Company dependent fields should not be stored in the table", func() {
+				So(Registry.MustGet("Profile").Fields().MustGet("Discount").isStored(), ShouldBeFalse)
+				So(Registry.MustGet("Profile").FieldsGet(FieldName("Discount"))["discount"].CompanyDependent, ShouldBeTrue)
+			})
+			Convey("Unset values should be the zero value", func() {
+				So(inCompany1.Get("Discount"), ShouldEqual, 0)
+			})
+			Convey("Values should be stored per company", func() {
+				inCompany1.Set("Discount", 10.0)
+				inCompany2.Set("Discount", 20.0)
+				So(inCompany1.Get("Discount"), ShouldEqual, 10)
+				So(inCompany2.Get("Discount"), ShouldEqual, 20)
+				env.cache.properties = make(map[propertyRef]interface{})
+				So(inCompany1.Get("Discount"), ShouldEqual, 10)
+				So(inCompany2.Get("Discount"), ShouldEqual, 20)
+				So(other.WithContext("company_id", int64(1)).Get("Discount"), ShouldEqual, 0)
+			})
+			Convey("Company defaults should be used for records without value", func() {
+				inCompany1.Set("Discount", 10.0)
+				env.Pool("Profile").SetCompanyDefault("Discount", 5.0)
+				env.Pool("Profile").WithContext("company_id", int64(2)).SetCompanyDefault("Discount", 7.0)
+				So(inCompany1.Get("Discount"), ShouldEqual, 10)
+				So(inCompany2.Get("Discount"), ShouldEqual, 7)
+				So(other.WithContext("company_id", int64(1)).Get("Discount"), ShouldEqual, 5)
+				So(other.WithContext("company_id", int64(3)).Get("Discount"), ShouldEqual, 5)
+			})
+			Convey("Values given at creation should be stored in the current company", func() {
+				created := env.Pool("Profile").WithContext("company_id", int64(2)).
+					Call("Create", FieldMap{"Discount": 3.5}).(RecordSet).Collection()
+				So(created.Get("Discount"), ShouldEqual, 3.5)
+				So(created.WithContext("company_id", int64(1)).Get("Discount"), ShouldEqual, 0)
+				res := created.Read("Discount")
+				So(res[0]["Discount"], ShouldEqual, 3.5)
+			})
+			Convey("SetCompanyDefault should panic on other fields", func() {
+				So(func() { env.Pool("Profile").SetCompanyDefault("Money", 5.0) }, ShouldPanic)
+			})
+		}), ShouldBeNil)
+	})
+}
+
+func TestUserDefaults(t *testing.T) {
+	Convey("Testing user defaults", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			notes := env.Pool("Note")
+			company := env.Pool("Company").Call("Create", FieldMap{"Name": "Defaults Company"}).(RecordSet).Collection()
+			companyNotes := env.WithCompany(company).Pool("Note")
+			Convey("Field defaults should apply without user defaults", func() {
+				defaults := notes.Call("DefaultGet").(FieldMap)
+				So(defaults["state"], ShouldEqual, "draft")
+				So(defaults, ShouldNotContainKey, "stars")
+			})
+			Convey("Global defaults should override field defaults", func() {
+				notes.SetFieldDefault("State", "confirmed", 0, 0)
+				notes.SetFieldDefault("Stars", 3, 0, 0)
+				defaults := notes.Call("DefaultGet").(FieldMap)
+				So(defaults["state"], ShouldEqual, "confirmed")
+				So(defaults["stars"], ShouldEqual, 3)
+				So(notes.Sudo(2).Call("DefaultGet").(FieldMap)["stars"], ShouldEqual, 3)
+			})
+			Convey("User defaults should override company and global defaults", func() {
+				notes.SetFieldDefault("Stars", 3, 0, 0)
+				notes.SetFieldDefault("Stars", 4, 0, company.Ids()[0])
+				notes.SetFieldDefault("Stars", 5, security.SuperUserID, 0)
+				So(notes.Call("DefaultGet").(FieldMap)["stars"], ShouldEqual, 5)
+				So(companyNotes.Call("DefaultGet").(FieldMap)["stars"], ShouldEqual, 5)
+				So(companyNotes.Sudo(2).Call("DefaultGet").(FieldMap)["stars"], ShouldEqual, 4)
+				So(notes.Sudo(2).Call("DefaultGet").(FieldMap)["stars"], ShouldEqual, 3)
+				Convey("Setting a default again should update it", func() {
+					notes.SetFieldDefault("Stars", 6, security.SuperUserID, 0)
+					So(notes.Call("DefaultGet").(FieldMap)["stars"], ShouldEqual, 6)
+					So(env.Pool("UserDefault").Search(env.Pool("UserDefault").Model().Field("Model").Equals("Note")).Len(), ShouldEqual, 3)
+				})
+				Convey("Setting a nil default should remove it", func() {
+					notes.SetFieldDefault("Stars", nil, security.SuperUserID, 0)
+					So(notes.Call("DefaultGet").(FieldMap)["stars"], ShouldEqual, 3)
+					So(companyNotes.Call("DefaultGet").(FieldMap)["stars"], ShouldEqual, 4)
+				})
+			})
+			Convey("Relation defaults should be stored as ids", func() {
+				userJane := env.Pool("User").Search(env.Pool("User").Model().Field("Email").Equals("jane.smith@example.com"))
+				notes.SetFieldDefault("User", userJane, 0, 0)
+				So(notes.Call("DefaultGet").(FieldMap)["user_id"], ShouldEqual, userJane.Ids()[0])
+			})
+			Convey("Users should only set their own defaults", func() {
+				So(func() { notes.Sudo(2).SetFieldDefault("Stars", 7, 2, 0) }, ShouldNotPanic)
+				So(notes.Sudo(2).Call("DefaultGet").(FieldMap)["stars"], ShouldEqual, 7)
+				So(func() { notes.Sudo(2).SetFieldDefault("Stars", 7, 0, 0) }, ShouldPanic)
+				So(func() { notes.Sudo(2).SetFieldDefault("Stars", 7, 2, company.Ids()[0]) }, ShouldPanic)
+			})
+			Convey("Invalid defaults should panic", func() {
+				So(func() { notes.SetFieldDefault("Unknown", 1, 0, 0) }, ShouldPanic)
+				So(func() { notes.SetFieldDefault("Stars", "many", 0, 0) }, ShouldPanic)
+			})
+		}), ShouldBeNil)
+	})
+}
+
+func TestEmbeddedLists(t *testing.T) {
+	Convey("Testing embedded list fields", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			note := env.Pool("Note").Call("Create", FieldMap{
+				"Title": "Shopping",
+				"Checklist": []map[string]interface{}{
+					{"Label": "Bread", "Done": true, "Weight": 2},
+					{"Label": "Milk", "Deadline": "2017-05-12"},
+				},
+			}).(RecordSet).Collection()
+			Convey("Sub-records should be read with their schema types", func() {
+				note.InvalidateCache()
+				checklist := note.Get("Checklist").(types.EmbeddedList)
+				So(checklist.Len(), ShouldEqual, 2)
+				So(checklist[0].GetString("Label"), ShouldEqual, "Bread")
+				So(checklist[0].GetBool("Done"), ShouldBeTrue)
+				So(checklist[0].GetInt("Weight"), ShouldEqual, 2)
+				So(checklist[1].GetString("Label"), ShouldEqual, "Milk")
+				So(checklist[1].GetBool("Done"), ShouldBeFalse)
+				So(checklist[1].GetDate("Deadline").Format(dates.DefaultServerDateFormat), ShouldEqual, "2017-05-12")
+			})
+			Convey("Modified lists should be written back", func() {
+				checklist := note.Get("Checklist").(types.EmbeddedList)
+				checklist = checklist.Remove(0).Append(types.EmbeddedRecord{"Label": "Eggs", "Weight": 12.0})
+				note.Set("Checklist", checklist)
+				note.InvalidateCache()
+				checklist = note.Get("Checklist").(types.EmbeddedList)
+				So(checklist.Len(), ShouldEqual, 2)
+				So(checklist[0].GetString("Label"), ShouldEqual, "Milk")
+				So(checklist[1].GetString("Label"), ShouldEqual, "Eggs")
+				So(checklist[1].GetInt("Weight"), ShouldEqual, 12)
+				done := checklist.Filtered(func(rec types.EmbeddedRecord) bool { return rec.GetString("Label") == "Eggs" })
+				So(done.Len(), ShouldEqual, 1)
+			})
+			Convey("Lists sent as JSON should be accepted", func() {
+				note.Call("Write", FieldMap{"Checklist": []interface{}{
+					map[string]interface{}{"Label": "Tea", "Weight": float64(1)},
+				}})
+				So(note.Get("Checklist").(types.EmbeddedList)[0].GetInt("Weight"), ShouldEqual, 1)
+			})
+			Convey("Empty lists should be stored as such", func() {
+				note.Set("Checklist", nil)
+				note.InvalidateCache()
+				So(note.Get("Checklist").(types.EmbeddedList).Len(), ShouldEqual, 0)
+				other := env.Pool("Note").Call("Create", FieldMap{"Title": "Empty"}).(RecordSet).Collection()
+				other.InvalidateCache()
+				So(other.Get("Checklist").(types.EmbeddedList).Len(), ShouldEqual, 0)
+			})
+			Convey("Invalid sub-records should panic", func() {
+				So(func() {
+					note.Set("Checklist", types.EmbeddedList{{"Label": "Salt", "Color": "white"}})
+				}, ShouldPanic)
+				So(func() {
+					note.Set("Checklist", types.EmbeddedList{{"Label": "Salt", "Weight": "heavy"}})
+				}, ShouldPanic)
+				So(func() {
+					note.Set("Checklist", types.EmbeddedList{{"Label": "Salt", "Weight": 1.5}})
+				}, ShouldPanic)
+				So(func() {
+					note.Set("Checklist", types.EmbeddedList{{"Done": true}})
+				}, ShouldPanic)
+				So(func() {
+					note.Set("Checklist", types.EmbeddedList{{"Label": "Salt", "Deadline": "tomorrow"}})
+				}, ShouldPanic)
+			})
+			Convey("Embedded list fields should describe their schema", func() {
+				fInfo := env.Pool("Note").Call("FieldGet", FieldName("Checklist")).(*FieldInfo)
+				So(fInfo.Type, ShouldEqual, fieldtype.EmbeddedList)
+				So(fInfo.EmbeddedSchema, ShouldContainKey, "Label")
+				So(fInfo.Searchable, ShouldBeFalse)
+			})
+		}), ShouldBeNil)
+	})
+}
+
+func TestJSONFields(t *testing.T) {
+	Convey("Testing JSON fields", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			note := env.Pool("Note").Call("Create", FieldMap{
+				"Title": "Paint",
+				"Meta": map[string]interface{}{
+					"color": "red",
+					"size":  map[string]interface{}{"width": 12, "height": 4},
+					"dry":   true,
+				},
+			}).(RecordSet).Collection()
+			env.Pool("Note").Call("Create", FieldMap{
+				"Title": "Varnish",
+				"Meta":  map[string]interface{}{"color": "clear", "size": map[string]interface{}{"width": 3}},
+			})
+			Convey("Values should be read back as maps", func() {
+				note.InvalidateCache()
+				meta := note.Get("Meta").(map[string]interface{})
+				So(meta["color"], ShouldEqual, "red")
+				So(meta["dry"], ShouldBeTrue)
+				So(meta["size"].(map[string]interface{})["width"], ShouldEqual, 12)
+			})
+			Convey("Values should be updated", func() {
+				note.Set("Meta", map[string]interface{}{"color": "blue"})
+				note.InvalidateCache()
+				So(note.Get("Meta").(map[string]interface{}), ShouldResemble, map[string]interface{}{"color": "blue"})
+			})
+			Convey("Records without value should read nil", func() {
+				other := env.Pool("Note").Call("Create", FieldMap{"Title": "Empty"}).(RecordSet).Collection()
+				other.InvalidateCache()
+				So(other.Get("Meta"), ShouldBeNil)
+			})
+			Convey("Searching on JSON paths with the separator", func() {
+				notes := env.Pool("Note").Search(env.Pool("Note").Model().Field("Meta#color").Equals("red"))
+				So(notes.Len(), ShouldEqual, 1)
+				So(notes.Get("Title"), ShouldEqual, "Paint")
+				notes = env.Pool("Note").Search(env.Pool("Note").Model().Field("Meta#size#width").Greater(5))
+				So(notes.Len(), ShouldEqual, 1)
+				So(notes.Get("Title"), ShouldEqual, "Paint")
+				notes = env.Pool("Note").Search(env.Pool("Note").Model().Field("Meta#color").In([]string{"red", "clear"}))
+				So(notes.Len(), ShouldEqual, 2)
+			})
+			Convey("Searching on JSON paths with JSONPath", func() {
+				notes := env.Pool("Note").Search(env.Pool("Note").Model().Field("Meta").JSONPath("dry").Equals(true))
+				So(notes.Len(), ShouldEqual, 1)
+				notes = env.Pool("Note").Search(env.Pool("Note").Model().Field("Meta").JSONPath("size", "height").IsNull().
+					And().Field("Title").In([]string{"Paint", "Varnish"}))
+				So(notes.Len(), ShouldEqual, 1)
+				So(notes.Get("Title"), ShouldEqual, "Varnish")
+			})
+			Convey("JSON path values should be cast to the type of the argument", func() {
+				rs := env.Pool("Note").Search(env.Pool("Note").Model().Field("Meta#size#width").Greater(5).
+					And().Field("Meta#color").Equals("red"))
+				sql, args := rs.query.sqlWhereClause()
+				So(sql, ShouldContainSubstring, `("note".meta #>> ?)::numeric > ?`)
+				So(sql, ShouldContainSubstring, `("note".meta #>> ?) = ?`)
+				So(args, ShouldContain, 5)
+				So(args, ShouldContain, "red")
+			})
+			Convey("JSON paths on other fields should panic", func() {
+				So(func() {
+					env.Pool("Note").Search(env.Pool("Note").Model().Field("Title#color").Equals("red")).Len()
+				}, ShouldPanic)
+			})
+			Convey("JSON fields should not be searchable as a whole", func() {
+				fInfo := env.Pool("Note").Call("FieldGet", FieldName("Meta")).(*FieldInfo)
+				So(fInfo.Type, ShouldEqual, fieldtype.JSON)
+				So(fInfo.Searchable, ShouldBeFalse)
+			})
+		}), ShouldBeNil)
+	})
+}
+
+func TestFilteredOne2Many(t *testing.T) {
+	Convey("Testing filtered one2many fields", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			users := env.Pool("User")
+			jane := users.Search(users.Model().Field("Email").Equals("jane.smith@example.com"))
+			posts := jane.Get("Posts").(RecordSet).Collection()
+			So(posts.Len(), ShouldEqual, 2)
+			Convey("Only related records matching the filter should be selected", func() {
+				So(jane.Get("VisiblePosts").(RecordSet).Collection().IsEmpty(), ShouldBeTrue)
+				posts.Records()[0].Set("Visibility", "visible")
+				visible := jane.Get("VisiblePosts").(RecordSet).Collection()
+				So(visible.Ids(), ShouldResemble, posts.Records()[0].Ids())
+				So(jane.Get("Posts").(RecordSet).Collection().Len(), ShouldEqual, 2)
+			})
+			Convey("Filtered fields should follow creations and deletions", func() {
+				post := env.Pool("Post").Call("Create", FieldMap{
+					"Title":      "Visible Post",
+					"Content":    "Visible content",
+					"User":       jane,
+					"Visibility": "visible",
+				}).(RecordSet).Collection()
+				So(jane.Get("VisiblePosts").(RecordSet).Collection().Ids(), ShouldResemble, post.Ids())
+				post.Call("Unlink")
+				So(jane.Get("VisiblePosts").(RecordSet).Collection().IsEmpty(), ShouldBeTrue)
+			})
+			Convey("Cached filtered fields should be indexed for invalidation", func() {
+				fi := users.Model().Fields().MustGet("VisiblePosts")
+				jane.Get("VisiblePosts")
+				So(env.cache.filteredO2Ms[fi], ShouldContainKey, jane.Ids()[0])
+				So(env.cache.data[cacheRef{model: jane.model, id: jane.Ids()[0]}], ShouldContainKey, fi.json)
+				env.Pool("Post").Call("Create", FieldMap{"Title": "New Post", "Content": "New content", "User": jane})
+				So(env.cache.filteredO2Ms, ShouldNotContainKey, fi)
+				So(env.cache.data[cacheRef{model: jane.model, id: jane.Ids()[0]}], ShouldNotContainKey, fi.json)
+			})
+			Convey("Filtered fields should not copy children again", func() {
+				posts.Set("Visibility", "visible")
+				janeCopy := jane.Call("Copy", FieldMap{"Name": "Jane's Copy", "Email2": "js@example.com"}).(RecordSet).Collection()
+				So(janeCopy.Get("Posts").(RecordSet).Collection().Len(), ShouldEqual, 2)
+				So(janeCopy.Get("VisiblePosts").(RecordSet).Collection().Len(), ShouldEqual, 2)
+			})
+		}), ShouldBeNil)
+	})
+}
@@ -0,0 +1,185 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hexya-erp/hexya/hexya/models/security"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCollatedOrder(t *testing.T) {
+	Convey("Testing collations and unaccented ordering", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			zoe := env.Pool("Tag").Call("Create", FieldMap{"Name": "Zoe", "Description": "apple"}).(RecordSet).Collection()
+			emile := env.Pool("Tag").Call("Create", FieldMap{"Name": "Émile", "Description": "Banana"}).(RecordSet).Collection()
+			eric := env.Pool("Tag").Call("Create", FieldMap{"Name": "Eric", "Description": "cherry"}).(RecordSet).Collection()
+			tags := env.Pool("Tag").Search(env.Pool("Tag").Model().Field("ID").In(zoe.Union(emile).Union(eric).Ids()))
+			Convey("Unaccent fields should be ordered without accents", func() {
+				So(tags.OrderBy("Name").Ids(), ShouldResemble, []int64{emile.ids[0], eric.ids[0], zoe.ids[0]})
+			})
+			Convey("Collate should change the collation of the query", func() {
+				So(tags.OrderBy("Description").Collate("C").Ids(), ShouldResemble, []int64{emile.ids[0], zoe.ids[0], eric.ids[0]})
+			})
+			Convey("Sort keys should not be loaded", func() {
+				records := tags.OrderBy("Name").Collate("C").Load().Records()
+				So(records, ShouldHaveLength, 3)
+				So(records[0].Get("Name"), ShouldEqual, "Émile")
+				So(records[0].Get("Description"), ShouldEqual, "Banana")
+			})
+		}), ShouldBeNil)
+	})
+}
+
+func TestUnaccentSearch(t *testing.T) {
+	Convey("Testing unaccented searches", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			tags := env.Pool("Tag")
+			tags.Call("Create", FieldMap{"Name": "Crème", "Description": "Crème brûlée"})
+			tags.Call("Create", FieldMap{"Name": "Pâté", "Description": "Pâté en croûte"})
+			Convey("Unaccent searchable fields should be searched without accents", func() {
+				res := tags.Search(tags.Model().Field("Description").IContains("CREME BRULEE"))
+				So(res.Len(), ShouldEqual, 1)
+				So(res.Get("Name"), ShouldEqual, "Crème")
+				res = tags.Search(tags.Model().Field("Description").IContains("croûte").
+					And().Field("Description").NotIContains("crème"))
+				So(res.Len(), ShouldEqual, 1)
+				So(res.Get("Name"), ShouldEqual, "Pâté")
+				sql, _ := res.query.sqlWhereClause()
+				So(sql, ShouldContainSubstring, `hexya_unaccent("tag".description) ILIKE hexya_unaccent(?)`)
+			})
+			Convey("Other fields and operators should not ignore accents", func() {
+				So(tags.Search(tags.Model().Field("Name").IContains("creme")).IsEmpty(), ShouldBeTrue)
+				So(tags.Search(tags.Model().Field("Description").Contains("Creme")).IsEmpty(), ShouldBeTrue)
+			})
+			Convey("UnaccentSearch should apply to all char and text fields", func() {
+				UnaccentSearch = true
+				defer func() { UnaccentSearch = false }()
+				So(tags.Search(tags.Model().Field("Name").IContains("creme")).Len(), ShouldEqual, 1)
+			})
+		}), ShouldBeNil)
+	})
+}
+
+func TestQueryCache(t *testing.T) {
+	Convey("Testing the query cache", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			users := env.Pool("User")
+			userJane := users.Search(users.Model().Field("Email").Equals("jane.smith@example.com"))
+			query := fmt.Sprintf("UPDATE %s SET email = ? WHERE id = ?",
+				adapters[db.DriverName()].quoteTableName(users.model.tableName))
+			cached := users.Search(users.Model().Field("Email").Equals("jane.smith@example.com")).Cached(time.Minute)
+			So(cached.Ids(), ShouldResemble, userJane.Ids())
+			env.Cr().Execute(query, "jane.doe@example.com", userJane.Ids()[0])
+			Convey("Identical searches should be served from the cache", func() {
+				again := users.Search(users.Model().Field("Email").Equals("jane.smith@example.com")).Cached(time.Minute)
+				So(again.Ids(), ShouldResemble, userJane.Ids())
+				uncached := users.Search(users.Model().Field("Email").Equals("jane.smith@example.com"))
+				So(uncached.Ids(), ShouldBeEmpty)
+			})
+			Convey("Cached searches should be discarded when their model is invalidated", func() {
+				applyCacheInvalidation(cacheInvalidation{Model: "User", IDs: userJane.Ids()})
+				again := users.Search(users.Model().Field("Email").Equals("jane.smith@example.com")).Cached(time.Minute)
+				So(again.Ids(), ShouldBeEmpty)
+			})
+			Convey("Cached searches should expire", func() {
+				applyCacheInvalidation(cacheInvalidation{Model: "User"})
+				expiring := users.Search(users.Model().Field("Email").Equals("jane.smith@example.com")).Cached(time.Nanosecond)
+				So(expiring.Ids(), ShouldBeEmpty)
+				env.Cr().Execute(query, "jane.smith@example.com", userJane.Ids()[0])
+				time.Sleep(time.Millisecond)
+				again := users.Search(users.Model().Field("Email").Equals("jane.smith@example.com")).Cached(time.Minute)
+				So(again.Ids(), ShouldResemble, userJane.Ids())
+			})
+			Convey("Searches of environments modifying the model should not be cached", func() {
+				userJane.Set("Nums", 5)
+				again := users.Search(users.Model().Field("Email").Equals("jane.smith@example.com")).Cached(time.Minute)
+				So(again.Ids(), ShouldBeEmpty)
+			})
+			applyCacheInvalidation(cacheInvalidation{Model: "User"})
+		}), ShouldBeNil)
+	})
+}
+
+func TestFullTextSearch(t *testing.T) {
+	Convey("Testing full-text search", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			posts := env.Pool("Post")
+			posts.Call("Create", FieldMap{"Title": "Running", "Content": "Content",
+				"Abstract": "How to run a marathon and keep running"})
+			posts.Call("Create", FieldMap{"Title": "Cooking", "Content": "Content",
+				"Abstract": "Cooking pasta for runners"})
+			posts.Call("Create", FieldMap{"Title": "Training", "Content": "Content",
+				"Abstract": "Running twice a week is enough"})
+			Convey("Match should find the records with all the words, after stemming", func() {
+				res := posts.Search(posts.Model().Field("Abstract").Match("runs"))
+				So(res.Len(), ShouldEqual, 2)
+				res = posts.Search(posts.Model().Field("Abstract").Match("running marathons"))
+				So(res.Len(), ShouldEqual, 1)
+				So(res.Get("Title"), ShouldEqual, "Running")
+				So(posts.Search(posts.Model().Field("Abstract").Match("swimming")).IsEmpty(), ShouldBeTrue)
+			})
+			Convey("Vectors should be updated when records are written", func() {
+				cooking := posts.Search(posts.Model().Field("Title").Equals("Cooking"))
+				cooking.Set("Abstract", "Cooking for swimmers")
+				So(posts.Search(posts.Model().Field("Abstract").Match("swimmer")).Len(), ShouldEqual, 1)
+			})
+			Convey("Records should be ordered by rank", func() {
+				res := posts.Search(posts.Model().Field("Abstract").Match("run")).
+					OrderByRank(FieldName("Abstract"), "run")
+				So(res.Len(), ShouldEqual, 2)
+				So(res.Records()[0].Get("Title"), ShouldEqual, "Running")
+				So(res.Records()[1].Get("Title"), ShouldEqual, "Training")
+				sql, args := res.query.selectQuery([]string{"id"})
+				So(sql, ShouldContainSubstring, `ts_rank("post".abstract_tsv, plainto_tsquery('english'::regconfig, ?)) AS __sort_rank0`)
+				So(sql, ShouldContainSubstring, `"post".abstract_tsv @@ plainto_tsquery('english'::regconfig, ?)`)
+				So(sql, ShouldContainSubstring, `ORDER BY __sort_rank0 DESC`)
+				So(args[0], ShouldEqual, "run")
+			})
+			Convey("Full-text search on other fields should panic", func() {
+				So(func() { posts.Search(posts.Model().Field("Title").Match("run")).Len() }, ShouldPanic)
+				So(func() { posts.SearchAll().OrderByRank(FieldName("Title"), "run").Len() }, ShouldPanic)
+			})
+		}), ShouldBeNil)
+	})
+}
+
+func TestFuzzySearch(t *testing.T) {
+	Convey("Testing fuzzy search", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			users := env.Pool("User")
+			users.Call("Create", FieldMap{"Name": "Theodore Fuzzyman", "Email": "theodore@example.com"})
+			users.Call("Create", FieldMap{"Name": "Theodora Fuzzymann", "Email": "theodora@example.com"})
+			users.Call("Create", FieldMap{"Name": "Completely Different", "Email": "different@example.com"})
+			Convey("Similar should find records despite typos", func() {
+				res := users.Search(users.Model().Field("Name").Similar("Teodore Fuzyman"))
+				So(res.Len(), ShouldEqual, 2)
+				sql, _ := res.query.sqlWhereClause()
+				So(sql, ShouldContainSubstring, `"user".name % ?`)
+			})
+			Convey("NameSearchFuzzy should order records by similarity", func() {
+				res := users.Call("NameSearchFuzzy", "Theodore Fuzyman", 0).(RecordSet).Collection()
+				So(res.Len(), ShouldEqual, 2)
+				So(res.Records()[0].Get("Name"), ShouldEqual, "Theodore Fuzzyman")
+				So(res.Records()[1].Get("Name"), ShouldEqual, "Theodora Fuzzymann")
+				res = users.Call("NameSearchFuzzy", "Theodora", 1).(RecordSet).Collection()
+				So(res.Len(), ShouldEqual, 1)
+				So(res.Get("Name"), ShouldEqual, "Theodora Fuzzymann")
+			})
+			Convey("Records should be ordered by similarity", func() {
+				res := users.Search(users.Model().Field("Email").Equals("theodore@example.com").
+					Or().Field("Email").Equals("different@example.com")).
+					OrderBySimilarity(FieldName("Name"), "Completly Diferent")
+				So(res.Records()[0].Get("Name"), ShouldEqual, "Completely Different")
+			})
+			Convey("Fuzzy search on models without it should panic", func() {
+				So(func() { env.Pool("Tag").Call("NameSearchFuzzy", "Tag", 0) }, ShouldPanic)
+				So(func() { users.SearchAll().OrderBySimilarity(FieldName("Nums"), "12").Len() }, ShouldPanic)
+			})
+		}), ShouldBeNil)
+	})
+}
@@ -0,0 +1,110 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"testing"
+
+	"github.com/hexya-erp/hexya/hexya/models/security"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCheckPermissions(t *testing.T) {
+	group1 := security.Registry.NewGroup("group1", "Group 1")
+	security.Registry.AddMembership(2, group1)
+	Convey("Testing bulk permission checks", t, func() {
+		So(SimulateInNewEnvironment(2, func(env Environment) {
+			userModel := Registry.MustGet("User")
+			janeID := env.Pool("User").Sudo().Search(userModel.Field("Email").Equals("jane.smith@example.com")).Ids()[0]
+			johnID := env.Pool("User").Sudo().Search(userModel.Field("Name").Equals("John Smith")).Ids()[0]
+			Convey("Methods not granted should be denied", func() {
+				res := env.CheckPermissions([]PermissionCheck{
+					{Model: "User", Method: "Write", IDs: []int64{janeID}},
+					{Model: "User", Method: "Create"},
+					{Model: "User", Method: "Unknown"},
+					{Model: "Unknown", Method: "Write"},
+				})
+				So(res, ShouldHaveLength, 4)
+				So(res[0].Allowed, ShouldBeFalse)
+				So(res[0].DeniedIDs, ShouldResemble, []int64{janeID})
+				So(res[1].Allowed, ShouldBeFalse)
+				So(res[2].Allowed, ShouldBeFalse)
+				So(res[3].Allowed, ShouldBeFalse)
+			})
+			Convey("Record rules should be applied to the given ids", func() {
+				userModel.methods.MustGet("Write").AllowGroup(group1)
+				userModel.methods.MustGet("Create").AllowGroup(group1)
+				rule := RecordRule{
+					Name:      "janeOnly",
+					Group:     group1,
+					Condition: userModel.Field("Email").Equals("jane.smith@example.com"),
+					Perms:     security.Write,
+				}
+				userModel.AddRecordRule(&rule)
+				res := env.CheckPermissions([]PermissionCheck{
+					{Model: "User", Method: "Write", IDs: []int64{janeID, johnID}},
+					{Model: "User", Method: "Write", IDs: []int64{janeID}},
+					{Model: "User", Method: "Create"},
+					{Model: "User", Method: "Write", IDs: []int64{-1}},
+				})
+				So(res, ShouldHaveLength, 4)
+				So(res[0].Allowed, ShouldBeFalse)
+				So(res[0].DeniedIDs, ShouldResemble, []int64{johnID})
+				So(res[1].Allowed, ShouldBeTrue)
+				So(res[1].DeniedIDs, ShouldBeEmpty)
+				So(res[2].Allowed, ShouldBeTrue)
+				So(res[3].Allowed, ShouldBeFalse)
+				userModel.RemoveRecordRule("janeOnly")
+				userModel.methods.MustGet("Write").RevokeGroup(group1)
+				userModel.methods.MustGet("Create").RevokeGroup(group1)
+			})
+		}), ShouldBeNil)
+	})
+	security.Registry.UnregisterGroup(group1)
+}
+
+func TestImpliedGroupsAccess(t *testing.T) {
+	employee := security.Registry.NewGroup("employee", "Employee")
+	manager := security.Registry.DeclareGroup("manager", "Manager", employee)
+	security.Registry.AddMembership(2, manager)
+	Convey("Testing access rights through implied groups", t, func() {
+		So(SimulateInNewEnvironment(2, func(env Environment) {
+			userModel := Registry.MustGet("User")
+			writeMethod := userModel.methods.MustGet("Write")
+			Convey("Users should have the groups implied by their groups", func() {
+				So(env.User().HasGroup(manager), ShouldBeTrue)
+				So(env.User().HasGroup(employee), ShouldBeTrue)
+				So(env.User().Groups()[employee], ShouldEqual, security.InheritedGroup)
+				So(env.User().HasGroup(security.GroupAdmin), ShouldBeFalse)
+			})
+			Convey("Permissions granted to implied groups should apply", func() {
+				So(env.Pool("User").CheckExecutionPermission(writeMethod, true), ShouldBeFalse)
+				writeMethod.AllowGroup(employee)
+				So(env.Pool("User").CheckExecutionPermission(writeMethod, true), ShouldBeTrue)
+				writeMethod.RevokeGroup(employee)
+			})
+			Convey("Record rules of implied groups should apply", func() {
+				rule := RecordRule{
+					Name:      "employeeJaneOnly",
+					Group:     employee,
+					Condition: userModel.Field("Email").Equals("jane.smith@example.com"),
+					Perms:     security.Read,
+				}
+				userModel.AddRecordRule(&rule)
+				janeID := env.Pool("User").Sudo().Search(userModel.Field("Email").Equals("jane.smith@example.com")).Ids()[0]
+				So(env.Pool("User").SearchAll().Ids(), ShouldResemble, []int64{janeID})
+				userModel.RemoveRecordRule("employeeJaneOnly")
+			})
+			Convey("Cached groups should be updated when the registry changes", func() {
+				So(env.User().HasGroup(employee), ShouldBeTrue)
+				security.Registry.RemoveImpliedGroups(manager, employee)
+				So(env.User().HasGroup(employee), ShouldBeFalse)
+				security.Registry.AddImpliedGroups(manager, employee)
+				So(env.User().HasGroup(employee), ShouldBeTrue)
+			})
+		}), ShouldBeNil)
+	})
+	security.Registry.UnregisterGroup(manager)
+	security.Registry.UnregisterGroup(employee)
+}
@@ -4,6 +4,7 @@
 package models
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/hexya-erp/hexya/hexya/models/security"
@@ -27,6 +28,11 @@ func TestDataLoading(t *testing.T) {
 				So(userMary.Get("IsStaff").(bool), ShouldEqual, false)
 				So(userMary.Get("Size").(float64), ShouldEqual, 1.59)
 
+				So(userPeter.Get("HexyaExternalID"), ShouldEqual, "testdata.external_id_1")
+				So(env.Ref("testdata.external_id_1").Equals(userPeter), ShouldBeTrue)
+				So(env.Ref("testdata.external_id_2").Equals(userMary), ShouldBeTrue)
+				So(func() { env.Ref("testdata.unknown_id") }, ShouldPanic)
+
 				So(func() { LoadCSVDataFile("testdata/001User.csv") }, ShouldPanic)
 				So(func() { LoadCSVDataFile("testdata/011User.csv") }, ShouldPanic)
 				So(func() { LoadCSVDataFile("testdata/012User.csv") }, ShouldPanic)
@@ -121,6 +127,126 @@ func TestDataLoading(t *testing.T) {
 				So(func() { LoadCSVDataFile("testdata/001Post.csv") }, ShouldPanic)
 				So(func() { LoadCSVDataFile("testdata/002Post.csv") }, ShouldPanic)
 			})
+			Convey("Checking XML data files with noupdate", func() {
+				LoadXMLDataFile("testdata/UserData.xml")
+				userJane := userObj.Search(userObj.Model().Field("HexyaExternalID").Equals("testdata.user_xml_jane"))
+				So(userJane.Len(), ShouldEqual, 1)
+				So(userJane.Get("Name"), ShouldEqual, "Jane")
+				So(userJane.Get("Nums").(int), ShouldEqual, 4)
+				So(userJane.Get("IsStaff").(bool), ShouldBeTrue)
+				userJack := userObj.Search(userObj.Model().Field("HexyaExternalID").Equals("testdata.user_xml_jack"))
+				So(userJack.Get("Name"), ShouldEqual, "Jack")
+				jackPost := userJack.Get("Posts").(RecordSet).Collection()
+				So(jackPost.Len(), ShouldEqual, 1)
+				So(jackPost.Get("Title"), ShouldEqual, "Jack's Post")
+				So(jackPost.Get("Tags").(RecordSet).Collection().Len(), ShouldEqual, 2)
+				janePost := userJane.Get("Posts").(RecordSet).Collection()
+				So(janePost.Len(), ShouldEqual, 1)
+				So(janePost.Get("Title"), ShouldEqual, "Post #6")
+				So(janePost.Get("Tags").(RecordSet).Collection().Len(), ShouldEqual, 2)
+
+				LoadXMLDataFile("testdata/UserData2.xml")
+				userJane.InvalidateCache()
+				So(userJane.Get("Name"), ShouldEqual, "Jane modified")
+				So(userJane.Get("Nums").(int), ShouldEqual, 5)
+				userJack.InvalidateCache()
+				So(userJack.Get("Name"), ShouldEqual, "Jack")
+				So(userJack.Get("Nums").(int), ShouldEqual, 6)
+			})
+		}), ShouldBeNil)
+	})
+}
+
+func TestImport(t *testing.T) {
+	Convey("Testing CSV import", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			userObj := env.Pool("User")
+			Convey("Importing users with posts", func() {
+				data := `Name,Nums,IsStaff,Posts/Title,Posts/Content,Posts/Tags
+Alice,3,true,Alice's first post,Hello,testdata.tag_book|testdata.tag_film
+,,,Alice's second post,World,
+Bob,notanumber,false,,,
+Carl,5,maybe,,,
+Dave,7,false,Dave's post,Content,unknown_tag
+Peter,2,false,,,
+Eve,2,false,,,
+Frank,1`
+				res := userObj.ImportCSV(strings.NewReader(data))
+				So(res.IDs, ShouldHaveLength, 2)
+				So(res.Errors, ShouldHaveLength, 5)
+				var errLines []int
+				for _, e := range res.Errors {
+					errLines = append(errLines, e.Line)
+				}
+				So(errLines, ShouldContain, 4)
+				So(errLines, ShouldContain, 5)
+				So(errLines, ShouldContain, 6)
+				So(errLines, ShouldContain, 7)
+				So(errLines, ShouldContain, 9)
+				alice := userObj.Search(userObj.Model().Field("Name").Equals("Alice"))
+				So(alice.Get("Nums"), ShouldEqual, 3)
+				So(alice.Get("IsStaff"), ShouldBeTrue)
+				alicePosts := alice.Get("Posts").(RecordSet).Collection()
+				So(alicePosts.Len(), ShouldEqual, 2)
+				So(alicePosts.Records()[0].Get("Title"), ShouldEqual, "Alice's first post")
+				So(alicePosts.Records()[0].Get("Tags").(RecordSet).Collection().Len(), ShouldEqual, 2)
+				eve := userObj.Search(userObj.Model().Field("Name").Equals("Eve"))
+				So(eve.Len(), ShouldEqual, 1)
+				So(userObj.Search(userObj.Model().Field("Name").Equals("Bob")).Len(), ShouldEqual, 0)
+			})
+			Convey("Resolving many2one by external ID and by name", func() {
+				postObj := env.Pool("Post")
+				data := `Title,Content,User
+Post A,Content A,Peter
+Post B,Content B,testdata.external_id_2
+Post C,Content C,Nobody`
+				res := postObj.ImportCSV(strings.NewReader(data))
+				So(res.IDs, ShouldHaveLength, 2)
+				So(res.Errors, ShouldHaveLength, 1)
+				So(res.Errors[0].Line, ShouldEqual, 4)
+				So(res.Errors[0].Field, ShouldEqual, "User")
+				postA := postObj.Search(postObj.Model().Field("Title").Equals("Post A"))
+				So(postA.Get("User").(RecordSet).Collection().Get("Name"), ShouldEqual, "Peter")
+			})
+			Convey("Unknown headers should be reported", func() {
+				res := userObj.ImportCSV(strings.NewReader("Name,Unknown\nZoe,1"))
+				So(res.IDs, ShouldBeEmpty)
+				So(res.Errors, ShouldHaveLength, 1)
+				So(res.Errors[0].Field, ShouldEqual, "Unknown")
+			})
+		}), ShouldBeNil)
+	})
+}
+
+func TestRemapIDs(t *testing.T) {
+	Convey("Testing ids remapping", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			userObj := env.Pool("User")
+			postObj := env.Pool("Post")
+			tagObj := env.Pool("Tag")
+			oldUser := userObj.Call("Create", FieldMap{"Name": "Old User"}).(RecordSet).Collection()
+			newUser := userObj.Call("Create", FieldMap{"Name": "New User"}).(RecordSet).Collection()
+			post := postObj.Call("Create", FieldMap{"Title": "Remapped Post", "Content": "Content", "User": oldUser}).(RecordSet).Collection()
+			oldTag := tagObj.Call("Create", FieldMap{"Name": "Old Tag"}).(RecordSet).Collection()
+			newTag := tagObj.Call("Create", FieldMap{"Name": "New Tag"}).(RecordSet).Collection()
+			post.Set("Tags", oldTag.Union(newTag))
+			Convey("Remapping users should rewrite foreign keys", func() {
+				res := env.RemapIDs("User", map[int64]int64{oldUser.Ids()[0]: newUser.Ids()[0]})
+				So(res.Applied, ShouldBeTrue)
+				So(res.OK(), ShouldBeTrue)
+				post = postObj.Search(postObj.Model().Field("Title").Equals("Remapped Post"))
+				So(post.Get("User").(RecordSet).Collection().Ids(), ShouldResemble, newUser.Ids())
+			})
+			Convey("Remapping tags should rewrite and deduplicate many2many links", func() {
+				res := env.RemapIDs("Tag", map[int64]int64{oldTag.Ids()[0]: newTag.Ids()[0]})
+				So(res.Applied, ShouldBeTrue)
+				So(res.DuplicatesRemoved, ShouldEqual, 1)
+				post = postObj.Search(postObj.Model().Field("Title").Equals("Remapped Post"))
+				So(post.Get("Tags").(RecordSet).Collection().Ids(), ShouldResemble, newTag.Ids())
+			})
+			Convey("Remapping to non existent records should panic", func() {
+				So(func() { env.RemapIDs("User", map[int64]int64{oldUser.Ids()[0]: 999999}) }, ShouldPanic)
+			})
 		}), ShouldBeNil)
 	})
 }
@@ -0,0 +1,54 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hexya-erp/hexya/hexya/models/security"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAttachments(t *testing.T) {
+	Convey("Testing the Attachment model", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			janeID := env.Pool("User").Search(env.Pool("User").Model().Field("Email").Equals("jane.smith@example.com")).Ids()[0]
+			att := env.Pool("Attachment").Call("Create", FieldMap{
+				"Name":     "hello.txt",
+				"ResModel": "User",
+				"ResID":    janeID,
+				"Datas":    "aGVsbG8=",
+			}).(RecordSet).Collection()
+			Convey("Size and mime type should be set from the content", func() {
+				So(att.Get("FileSize"), ShouldEqual, 5)
+				So(att.Get("MimeType"), ShouldEqual, "text/plain; charset=utf-8")
+				att.Call("Write", FieldMap{"Name": "image", "Datas": "R0lGODlhAQABAAAAACw="})
+				So(att.Get("FileSize"), ShouldEqual, 14)
+				So(att.Get("MimeType"), ShouldEqual, "image/gif")
+			})
+			Convey("Access should be granted by the linked record", func() {
+				own := env.Pool("Attachment").Sudo(2).Call("Create", FieldMap{"Name": "own.txt", "Datas": "aGVsbG8="}).(RecordSet).Collection()
+				So(own.Call("Read", []string{"Name"}).([]FieldMap)[0]["Name"], ShouldEqual, "own.txt")
+				So(func() { att.Sudo(2).Call("Read", []string{"Name"}) }, ShouldPanic)
+				So(func() { own.Sudo(3).Call("Read", []string{"Name"}) }, ShouldPanic)
+				So(func() {
+					env.Pool("Attachment").Sudo(2).Call("Create", FieldMap{"Name": "jane.txt", "ResModel": "User", "ResID": janeID})
+				}, ShouldPanic)
+			})
+			Convey("Orphan attachments and contents should be garbage collected", func() {
+				orphan := env.Pool("Attachment").Call("Create", FieldMap{"Name": "orphan", "ResModel": "User", "ResID": -1})
+				So(env.Pool("Attachment").Call("GarbageCollect"), ShouldEqual, 1)
+				So(orphan.(RecordSet).Collection().SearchCount(), ShouldEqual, 0)
+				So(att.SearchCount(), ShouldEqual, 1)
+				checksum := storeBinaryContent(strings.NewReader("orphan content"))
+				GarbageCollectFilestore(time.Hour)
+				So(filestore().Exists(checksum), ShouldBeTrue)
+				So(GarbageCollectFilestore(0), ShouldBeGreaterThan, 0)
+				So(filestore().Exists(checksum), ShouldBeFalse)
+			})
+		}), ShouldBeNil)
+	})
+}
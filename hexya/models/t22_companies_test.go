@@ -0,0 +1,48 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"testing"
+
+	"github.com/hexya-erp/hexya/hexya/models/security"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMultiCompany(t *testing.T) {
+	Convey("Testing multi-company support", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			company1 := env.Pool("Company").Call("Create", FieldMap{"Name": "Company 1"}).(RecordSet).Collection()
+			company2 := env.Pool("Company").Call("Create", FieldMap{"Name": "Company 2"}).(RecordSet).Collection()
+			env1 := env.WithCompany(company1)
+			env2 := env.WithCompany(company2)
+			tagModel := Registry.MustGet("Tag")
+			shared := env.Pool("Tag").Call("Create", FieldMap{"Name": "Shared Tag"}).(RecordSet).Collection()
+			tag1 := env1.Pool("Tag").Call("Create", FieldMap{"Name": "Company 1 Tag"}).(RecordSet).Collection()
+			tag2 := env.Pool("Tag").WithCompany(company2).Call("Create", FieldMap{"Name": "Company 2 Tag"}).(RecordSet).Collection()
+			cond := tagModel.Field("ID").In([]int64{shared.Get("ID").(int64), tag1.Get("ID").(int64), tag2.Get("ID").(int64)})
+			Convey("Company and Companies should follow the context", func() {
+				So(env.Company().IsEmpty(), ShouldBeTrue)
+				So(env.Companies().IsEmpty(), ShouldBeTrue)
+				So(env1.Company().Equals(company1), ShouldBeTrue)
+				So(env1.Context().AllowedCompanyIDs(), ShouldHaveLength, 1)
+				both := env1.WithCompany(company2)
+				So(both.Company().Equals(company2), ShouldBeTrue)
+				So(both.Companies().Len(), ShouldEqual, 2)
+				So(env.Context().HasKey("company_id"), ShouldBeFalse)
+			})
+			Convey("New records should belong to the current company", func() {
+				So(shared.Get("Company").(RecordSet).IsEmpty(), ShouldBeTrue)
+				So(tag1.Get("Company").(RecordSet).Collection().Equals(company1), ShouldBeTrue)
+				So(tag2.Get("Company").(RecordSet).Collection().Equals(company2), ShouldBeTrue)
+			})
+			Convey("Records should be filtered on the allowed companies", func() {
+				So(env.Pool("Tag").Search(cond).Len(), ShouldEqual, 3)
+				So(env1.Pool("Tag").Search(cond).Len(), ShouldEqual, 2)
+				So(env2.Pool("Tag").Search(cond).Search(tagModel.Field("Name").Equals("Company 1 Tag")).IsEmpty(), ShouldBeTrue)
+				So(env1.WithCompany(company2).Pool("Tag").Search(cond).Len(), ShouldEqual, 3)
+			})
+		}), ShouldBeNil)
+	})
+}
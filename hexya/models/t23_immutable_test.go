@@ -0,0 +1,42 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"testing"
+
+	"github.com/hexya-erp/hexya/hexya/models/security"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestImmutableModels(t *testing.T) {
+	Convey("Testing immutable models", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			ledgerModel := Registry.MustGet("LedgerEntry")
+			entry1 := env.Pool("LedgerEntry").Call("Create", FieldMap{"Message": "First", "Amount": 12.5}).(RecordSet).Collection()
+			entry2 := env.Pool("LedgerEntry").Call("Create", FieldMap{"Message": "Second"}).(RecordSet).Collection()
+			Convey("Many2one fields should restrict deletion", func() {
+				So(ledgerModel.fields.MustGet("User").onDelete, ShouldEqual, Restrict)
+			})
+			Convey("Records should not be modified nor deleted", func() {
+				So(func() { entry1.Set("Message", "Modified") }, ShouldPanic)
+				So(func() { entry1.Call("Write", FieldMap{"Amount": 13.0}) }, ShouldPanic)
+				So(func() { entry2.Call("Unlink") }, ShouldPanic)
+				So(entry1.Get("Message"), ShouldEqual, "First")
+			})
+			Convey("Records should be hash chained", func() {
+				So(entry1.Get("Hash"), ShouldHaveLength, 64)
+				So(entry2.Get("PreviousHash"), ShouldEqual, entry1.Get("Hash"))
+				So(env.Pool("LedgerEntry").VerifyHashChain().IsEmpty(), ShouldBeTrue)
+			})
+			Convey("Tampering in the database should break the chain", func() {
+				env.Cr().Execute("UPDATE ledger_entry SET amount = ? WHERE id = ?", 1250.0, entry1.Ids()[0])
+				So(env.Pool("LedgerEntry").VerifyHashChain().Equals(entry1), ShouldBeTrue)
+			})
+			Convey("Verifying a model that is not hash chained should panic", func() {
+				So(func() { env.Pool("Tag").VerifyHashChain() }, ShouldPanic)
+			})
+		}), ShouldBeNil)
+	})
+}
@@ -0,0 +1,148 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hexya-erp/hexya/hexya/models/security"
+	"github.com/hexya-erp/hexya/hexya/models/types/dates"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSequenceModels(t *testing.T) {
+	Convey("Testing sequence models", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			env = env.WithContext("sequence_date", dates.Date{Time: time.Date(2017, 3, 15, 0, 0, 0, 0, time.UTC)})
+			sequences := env.Pool("Sequence")
+			orders := sequences.Call("Create", FieldMap{
+				"Name":    "Sale Orders",
+				"Code":    "sale.order",
+				"Prefix":  "SO/%(year)s/",
+				"Padding": int64(4),
+			}).(RecordSet).Collection()
+			invoices := sequences.Call("Create", FieldMap{
+				"Name":            "Invoices",
+				"Code":            "invoice",
+				"Implementation":  "no_gap",
+				"Prefix":          "INV%(y)s%(month)s-",
+				"NumberNext":      int64(10),
+				"NumberIncrement": int64(5),
+			}).(RecordSet).Collection()
+			Convey("Standard sequences should use DB sequences", func() {
+				So(env.NextSequence("sale.order"), ShouldEqual, "SO/2017/0001")
+				So(env.NextSequence("sale.order"), ShouldEqual, "SO/2017/0002")
+				So(orders.Get("NumberNext"), ShouldEqual, 1)
+				Convey("Setting the next number should restart the sequence", func() {
+					orders.Set("NumberNext", int64(100))
+					So(env.NextSequence("sale.order"), ShouldEqual, "SO/2017/0100")
+				})
+				Convey("Switching to no gap should keep the numbering", func() {
+					orders.Set("Implementation", "no_gap")
+					So(orders.Get("NumberNext"), ShouldEqual, 3)
+					So(env.NextSequence("sale.order"), ShouldEqual, "SO/2017/0003")
+				})
+			})
+			Convey("No gap sequences should use the next number of the sequence", func() {
+				So(env.NextSequence("invoice"), ShouldEqual, "INV1703-10")
+				So(env.NextSequence("invoice"), ShouldEqual, "INV1703-15")
+				So(invoices.Get("NumberNext"), ShouldEqual, 20)
+			})
+			Convey("Date ranges should restart numbering", func() {
+				for _, impl := range []string{"standard", "no_gap"} {
+					yearly := sequences.Call("Create", FieldMap{
+						"Name":           "Yearly",
+						"Implementation": impl,
+						"Prefix":         "%(range_year)s-",
+						"UseDateRange":   true,
+					}).(RecordSet).Collection()
+					So(yearly.Call("Next"), ShouldEqual, "2017-1")
+					So(yearly.Call("Next"), ShouldEqual, "2017-2")
+					nextYear := yearly.WithContext("sequence_date", dates.Date{Time: time.Date(2018, 1, 2, 0, 0, 0, 0, time.UTC)})
+					So(nextYear.Call("Next"), ShouldEqual, "2018-1")
+					So(yearly.Get("DateRanges").(RecordSet).Len(), ShouldEqual, 2)
+				}
+			})
+			Convey("Sequences of the current company should be used first", func() {
+				company := env.Pool("Company").Call("Create", FieldMap{"Name": "Sequence Company"}).(RecordSet).Collection()
+				env.WithCompany(company).Pool("Sequence").Call("Create", FieldMap{
+					"Name":   "Company Sale Orders",
+					"Code":   "sale.order",
+					"Prefix": "C-",
+				})
+				So(env.WithCompany(company).NextSequence("sale.order"), ShouldEqual, "C-1")
+				So(env.NextSequence("sale.order"), ShouldEqual, "SO/2017/0001")
+			})
+			Convey("Sequences of other companies should not be used", func() {
+				company := env.Pool("Company").Call("Create", FieldMap{"Name": "Sequence Company"}).(RecordSet).Collection()
+				other := env.Pool("Company").Call("Create", FieldMap{"Name": "Other Company"}).(RecordSet).Collection()
+				env.WithCompany(company).Pool("Sequence").Call("Create", FieldMap{
+					"Name":   "Company Quotations",
+					"Code":   "quotation",
+					"Prefix": "Q-",
+				})
+				So(env.WithCompany(company).NextSequence("quotation"), ShouldEqual, "Q-1")
+				So(func() { env.WithCompany(other).NextSequence("quotation") }, ShouldPanic)
+				So(func() { env.NextSequence("quotation") }, ShouldPanic)
+				So(env.WithCompany(other).NextSequence("sale.order"), ShouldEqual, "SO/2017/0001")
+			})
+			Convey("Unknown codes and placeholders should panic", func() {
+				So(func() { env.NextSequence("unknown") }, ShouldPanic)
+				orders.Set("Suffix", "%(unknown)s")
+				So(func() { env.NextSequence("sale.order") }, ShouldPanic)
+			})
+		}), ShouldBeNil)
+	})
+}
+
+func TestIDGenerators(t *testing.T) {
+	Convey("Testing ID generators", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			Convey("Snowflake ids should be increasing and carry the node number", func() {
+				SetSnowflakeNode(5)
+				defer SetSnowflakeNode(0)
+				note1 := env.Pool("Note").Call("Create", FieldMap{"Title": "First"}).(RecordSet).Collection()
+				note2 := env.Pool("Note").Call("Create", FieldMap{"Title": "Second"}).(RecordSet).Collection()
+				id1, id2 := note1.Ids()[0], note2.Ids()[0]
+				So(id1, ShouldBeGreaterThan, 1<<22)
+				So(id2, ShouldBeGreaterThan, id1)
+				So((id1>>12)&1023, ShouldEqual, 5)
+				So(env.Pool("Note").Search(env.Pool("Note").Model().Field("ID").Equals(id2)).Get("Title"), ShouldEqual, "Second")
+				So(func() { SetSnowflakeNode(1024) }, ShouldPanic)
+			})
+			Convey("Snowflake ids beyond 32 bits should be stored and referenced", func() {
+				note1 := env.Pool("Note").Call("Create", FieldMap{"Title": "Origin"}).(RecordSet).Collection()
+				So(note1.Ids()[0], ShouldBeGreaterThan, int64(1)<<31)
+				note2 := env.Pool("Note").Call("Create", FieldMap{"Title": "Copy", "Origin": note1}).(RecordSet).Collection()
+				env.cache.invalidateRecord(Registry.MustGet("Note"), note2.Ids()[0])
+				So(note2.Get("Origin").(RecordSet).Collection().Ids(), ShouldResemble, note1.Ids())
+				So(note1.AuditTrail().Get("ResID"), ShouldEqual, note1.Ids()[0])
+			})
+			Convey("Explicit ids should be kept", func() {
+				note := env.Pool("Note").Call("Create", FieldMap{"ID": int64(42), "Title": "Explicit"}).(RecordSet).Collection()
+				So(note.Ids(), ShouldResemble, []int64{42})
+			})
+			Convey("Random ids should be positive and distinct", func() {
+				seen := make(map[int64]bool)
+				for i := 0; i < 100; i++ {
+					id := randomID(nil)
+					So(id, ShouldBeGreaterThan, 0)
+					So(seen, ShouldNotContainKey, id)
+					seen[id] = true
+				}
+			})
+			Convey("Registered generators should be used", func() {
+				So(func() { RegisterIDGenerator(IDSnowflake, randomID) }, ShouldPanic)
+				So(func() { RegisterIDGenerator(IDSequence, randomID) }, ShouldPanic)
+				noteModel := Registry.MustGet("Note")
+				RegisterIDGenerator("test_fixed", func(model *Model) int64 { return 1000 + int64(len(model.name)) })
+				noteModel.SetIDGenerator("test_fixed")
+				defer noteModel.SetIDGenerator(IDSnowflake)
+				note := env.Pool("Note").Call("Create", FieldMap{"Title": "Fixed"}).(RecordSet).Collection()
+				So(note.Ids(), ShouldResemble, []int64{1004})
+			})
+		}), ShouldBeNil)
+	})
+}
@@ -0,0 +1,102 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hexya-erp/hexya/hexya/models/security"
+	"github.com/hexya-erp/hexya/hexya/models/types/dates"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCronJobs(t *testing.T) {
+	Convey("Testing cron jobs", t, func() {
+		var jobIDs []int64
+		So(ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+			cronJobs := env.Pool("CronJob")
+			for _, data := range []FieldMap{{
+				"Name":       "Create tag",
+				"Model":      "Tag",
+				"Method":     "CreateRatedTag",
+				"Arguments":  `["Cron Tag", 5]`,
+				"NextCall":   dates.Now().Add(-time.Hour),
+				"NumberCall": int64(2),
+			}, {
+				"Name":           "Create invalid tag",
+				"Model":          "Tag",
+				"Method":         "CreateRatedTag",
+				"Arguments":      `["Cron Invalid Tag", 20]`,
+				"CronExpression": "30 3 * * *",
+				"NextCall":       dates.Now().Add(-time.Minute),
+			}, {
+				"Name":      "Not due",
+				"Model":     "Tag",
+				"Method":    "CreateRatedTag",
+				"Arguments": `["Cron Future Tag", 1]`,
+				"NextCall":  dates.Now().Add(time.Hour),
+			}} {
+				jobIDs = append(jobIDs, cronJobs.Call("Create", data).(RecordSet).Ids()...)
+			}
+		}), ShouldBeNil)
+		Convey("Invalid jobs should not be created", func() {
+			So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+				env.Pool("CronJob").Call("Create", FieldMap{"Name": "Unknown", "Model": "Tag", "Method": "Unknown"})
+			}), ShouldNotBeNil)
+			So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+				env.Pool("CronJob").Call("Create", FieldMap{"Name": "Invalid", "Model": "Tag",
+					"Method": "CreateRatedTag", "CronExpression": "0 25 * * *"})
+			}), ShouldNotBeNil)
+		})
+		Convey("Due jobs should be run and rescheduled", func() {
+			So(ProcessCronJobs(), ShouldEqual, 2)
+			So(ProcessCronJobs(), ShouldEqual, 0)
+			So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+				tagModel := Registry.MustGet("Tag")
+				So(tagModel.Search(env, tagModel.Field("Name").Equals("Cron Tag")).Len(), ShouldEqual, 1)
+				So(tagModel.Search(env, tagModel.Field("Name").Equals("Cron Tag")).Get("Rate"), ShouldEqual, 5)
+				So(tagModel.Search(env, tagModel.Field("Name").Equals("Cron Invalid Tag")).Len(), ShouldEqual, 0)
+				So(tagModel.Search(env, tagModel.Field("Name").Equals("Cron Future Tag")).Len(), ShouldEqual, 0)
+				jobs := env.Pool("CronJob").withIds(jobIDs).Records()
+				So(jobs[0].Get("NextCall").(dates.DateTime).Greater(dates.Now()), ShouldBeTrue)
+				So(jobs[0].Get("LastCall").(dates.DateTime).IsZero(), ShouldBeFalse)
+				So(jobs[0].Get("NumberCall"), ShouldEqual, 1)
+				So(jobs[0].Get("LastError"), ShouldBeBlank)
+				nextCall := jobs[1].Get("NextCall").(dates.DateTime).In(time.Local)
+				So(nextCall.Greater(dates.Now()), ShouldBeTrue)
+				So(nextCall.Hour(), ShouldEqual, 3)
+				So(nextCall.Minute(), ShouldEqual, 30)
+				So(jobs[1].Get("LastError"), ShouldContainSubstring, "Tag rate must be between 0 and 10")
+				So(jobs[1].Get("Active"), ShouldBeTrue)
+				So(jobs[2].Get("LastCall").(dates.DateTime).IsZero(), ShouldBeTrue)
+			}), ShouldBeNil)
+			Convey("Jobs should be deactivated after their last call", func() {
+				So(ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+					env.Pool("CronJob").withIds(jobIDs[:1]).Set("NextCall", dates.Now().Add(-time.Minute))
+				}), ShouldBeNil)
+				So(ProcessCronJobs(), ShouldEqual, 1)
+				So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+					job := env.Pool("CronJob").withIds(jobIDs[:1])
+					So(job.Get("NumberCall"), ShouldEqual, 0)
+					So(job.Get("Active"), ShouldBeFalse)
+				}), ShouldBeNil)
+			})
+		})
+		Convey("Running a job should call its method immediately", func() {
+			So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+				job := env.Pool("CronJob").withIds(jobIDs[2:])
+				job.Call("Run")
+				tagModel := Registry.MustGet("Tag")
+				So(tagModel.Search(env, tagModel.Field("Name").Equals("Cron Future Tag")).Len(), ShouldEqual, 1)
+				So(job.Get("LastCall").(dates.DateTime).IsZero(), ShouldBeTrue)
+			}), ShouldBeNil)
+		})
+		So(ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+			env.Pool("CronJob").withIds(jobIDs).Call("Unlink")
+			tagModel := Registry.MustGet("Tag")
+			tagModel.Search(env, tagModel.Field("Name").In([]string{"Cron Tag", "Cron Future Tag"})).Call("Unlink")
+		}), ShouldBeNil)
+	})
+}
@@ -0,0 +1,101 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"encoding/base64"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hexya-erp/hexya/hexya/models/security"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestQuotas(t *testing.T) {
+	var events []QuotaEvent
+	RegisterQuotaHook(func(event QuotaEvent) {
+		events = append(events, event)
+	})
+	RegisterQuota("test_quota", func(env Environment) int64 {
+		return 10
+	})
+	Convey("Testing quotas", t, func() {
+		events = nil
+		Convey("Registering quotas twice or using unknown quotas should panic", func() {
+			So(func() { RegisterCounterQuota("test_quota", false) }, ShouldPanic)
+			So(func() { GetQuota("unknown") }, ShouldPanic)
+			So(func() { AddQuotaUsage(QuotaUsers, 1) }, ShouldPanic)
+			So(QuotaNames(), ShouldContain, "test_quota")
+		})
+		Convey("Computed quotas should be checked with CheckQuota", func() {
+			So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+				So(env.QuotaUsage("test_quota"), ShouldEqual, 10)
+				env.CheckQuota("test_quota", 5)
+				So(events, ShouldBeEmpty)
+				SetQuota("test_quota", Quota{Warning: 12, Limit: 15})
+				defer SetQuota("test_quota", Quota{})
+				env.CheckQuota("test_quota", 1)
+				So(events, ShouldBeEmpty)
+				env.CheckQuota("test_quota", 5)
+				So(events, ShouldHaveLength, 1)
+				So(events[0], ShouldResemble, QuotaEvent{Name: "test_quota", Usage: 15, Quota: Quota{Warning: 12, Limit: 15}})
+				So(func() { env.CheckQuota("test_quota", 6) }, ShouldPanic)
+				So(events, ShouldHaveLength, 2)
+				So(events[1].Exceeded, ShouldBeTrue)
+			}), ShouldBeNil)
+		})
+		Convey("Record quotas should be checked on creation", func() {
+			So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+				usage := env.QuotaUsage(QuotaUsers)
+				SetQuota(QuotaUsers, Quota{Warning: usage + 1, Limit: usage + 1})
+				defer SetQuota(QuotaUsers, Quota{})
+				env.Pool("User").Call("Create", FieldMap{"Name": "Quota User 1", "Email": "quota1@example.com"})
+				So(env.QuotaUsage(QuotaUsers), ShouldEqual, usage+1)
+				So(events, ShouldHaveLength, 1)
+				So(events[0].Exceeded, ShouldBeFalse)
+				So(func() {
+					env.Pool("User").Call("Create", FieldMap{"Name": "Quota User 2", "Email": "quota2@example.com"})
+				}, ShouldPanic)
+				So(events[1].Exceeded, ShouldBeTrue)
+			}), ShouldBeNil)
+		})
+		Convey("Counter quotas should be tracked outside transactions", func() {
+			So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+				usage := env.QuotaUsage(QuotaAPICalls)
+				AddQuotaUsage(QuotaAPICalls, 3)
+				defer AddQuotaUsage(QuotaAPICalls, -3)
+				So(env.QuotaUsage(QuotaAPICalls), ShouldEqual, usage+3)
+				SetQuota(QuotaAPICalls, Quota{Limit: usage + 4})
+				defer SetQuota(QuotaAPICalls, Quota{})
+				So(CheckCounterQuota(QuotaAPICalls, 1), ShouldBeNil)
+				So(CheckCounterQuota(QuotaAPICalls, 2), ShouldNotBeNil)
+				So(func() { CheckCounterQuota("test_quota", 1) }, ShouldPanic)
+			}), ShouldBeNil)
+		})
+		Convey("Storage quota should track new filestore contents", func() {
+			So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+				content := fmt.Sprintf("quota content %d", time.Now().UnixNano())
+				usage := env.QuotaUsage(QuotaStorage)
+				env.Pool("Attachment").Call("Create", FieldMap{
+					"Name":  "quota.txt",
+					"Datas": base64.StdEncoding.EncodeToString([]byte(content)),
+				})
+				So(env.QuotaUsage(QuotaStorage), ShouldEqual, usage+int64(len(content)))
+				SetQuota(QuotaStorage, Quota{Limit: usage + int64(len(content)) + 1})
+				defer SetQuota(QuotaStorage, Quota{})
+				So(func() {
+					env.Pool("Attachment").Call("Create", FieldMap{
+						"Name":  "quota2.txt",
+						"Datas": base64.StdEncoding.EncodeToString([]byte(content + " again")),
+					})
+				}, ShouldPanic)
+				env.Pool("Attachment").Call("Create", FieldMap{
+					"Name":  "quota3.txt",
+					"Datas": base64.StdEncoding.EncodeToString([]byte(content)),
+				})
+			}), ShouldBeNil)
+		})
+	})
+}
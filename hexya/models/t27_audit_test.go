@@ -0,0 +1,221 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/hexya-erp/hexya/hexya/models/security"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDiff(t *testing.T) {
+	Convey("Testing record diffs", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			parent := env.Pool("Tag").Call("Create", FieldMap{"Name": "Diff Parent"}).(RecordSet).Collection()
+			tag1 := env.Pool("Tag").Call("Create", FieldMap{
+				"Name":        "Diff1",
+				"Description": "First",
+				"Rate":        float32(5),
+				"Parent":      parent,
+			}).(RecordSet).Collection()
+			tag2 := env.Pool("Tag").Call("Create", FieldMap{
+				"Name":        "Diff2",
+				"Description": "First",
+				"Rate":        float32(7),
+			}).(RecordSet).Collection()
+			Convey("Comparing two records should return the differing fields", func() {
+				diff := tag1.Diff(tag2, FieldName("Description"), FieldName("Rate"), FieldName("Parent"))
+				So(diff, ShouldHaveLength, 2)
+				So(diff[0].Field, ShouldEqual, "Rate")
+				So(diff[0].Old, ShouldEqual, 5)
+				So(diff[0].New, ShouldEqual, 7)
+				So(diff[1].Field, ShouldEqual, "Parent")
+				So(diff[1].Old.(RecordSet).Collection().Equals(parent), ShouldBeTrue)
+				So(diff[1].New.(RecordSet).IsEmpty(), ShouldBeTrue)
+				So(tag1.Diff(tag1), ShouldBeEmpty)
+			})
+			Convey("Comparing all fields should ignore access fields", func() {
+				var fields []string
+				for _, fd := range tag1.Diff(tag2) {
+					fields = append(fields, fd.Field)
+				}
+				So(fields, ShouldContain, "Name")
+				So(fields, ShouldContain, "Parent")
+				So(fields, ShouldNotContain, "ID")
+				So(fields, ShouldNotContain, "CreateDate")
+				So(fields, ShouldNotContain, "Description")
+			})
+			Convey("Comparing a record with a FieldMap should return what will change", func() {
+				diff := tag1.Diff(FieldMap{
+					"Name":        "Diff1",
+					"description": "Second",
+					"Rate":        5,
+					"Parent":      nil,
+				})
+				So(diff, ShouldHaveLength, 2)
+				So(diff[0].Field, ShouldEqual, "Description")
+				So(diff[0].Old, ShouldEqual, "First")
+				So(diff[0].New, ShouldEqual, "Second")
+				So(diff[1].Field, ShouldEqual, "Parent")
+				So(diff[1].New.(RecordSet).IsEmpty(), ShouldBeTrue)
+				So(tag2.Diff(FieldMap{"Parent": parent.Ids()[0], "Rate": 7}, FieldName("Parent")), ShouldHaveLength, 1)
+				So(tag1.Diff(FieldMap{"Parent": parent}), ShouldBeEmpty)
+			})
+			Convey("Comparing with other models or several records should panic", func() {
+				So(func() { tag1.Diff(env.Pool("User").Search(env.Pool("User").Model().Field("ID").Greater(0)).Limit(1)) }, ShouldPanic)
+				So(func() { tag1.Union(tag2).Diff(tag2) }, ShouldPanic)
+				So(func() { tag1.Diff("Diff2") }, ShouldPanic)
+			})
+		}), ShouldBeNil)
+	})
+}
+
+func TestRecordHooks(t *testing.T) {
+	var events []string
+	var hookFields [][]string
+	var active bool
+	hook := func(event string) RecordHook {
+		return func(rc *RecordCollection, fields []string) {
+			if !active {
+				return
+			}
+			events = append(events, fmt.Sprintf("%s %s %v", event, rc.ModelName(), rc.Ids()))
+			hookFields = append(hookFields, fields)
+		}
+	}
+	OnRecordCreated("Tag", hook("created"))
+	OnRecordWritten("Tag", hook("written"))
+	OnRecordDeleted("Tag", hook("deleted"))
+	OnRecordDeleted("", hook("deleted any"))
+	Convey("Testing record hooks", t, func() {
+		events, hookFields, active = nil, nil, true
+		defer func() { active = false }()
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			tag := env.Pool("Tag").Call("Create", FieldMap{"Name": "Hooked"}).(RecordSet).Collection()
+			id := tag.Ids()[0]
+			So(events, ShouldResemble, []string{fmt.Sprintf("created Tag [%d]", id)})
+			So(hookFields[0], ShouldContain, "Name")
+			So(hookFields[0], ShouldNotContain, "name")
+			tag.Call("Write", FieldMap{"Description": "Hooked tag"})
+			So(events, ShouldHaveLength, 2)
+			So(events[1], ShouldEqual, fmt.Sprintf("written Tag [%d]", id))
+			So(hookFields[1], ShouldContain, "Description")
+			So(hookFields[1], ShouldNotContain, "Name")
+			tag.Call("Unlink")
+			So(events, ShouldHaveLength, 4)
+			So(events[2], ShouldEqual, fmt.Sprintf("deleted Tag [%d]", id))
+			So(events[3], ShouldEqual, fmt.Sprintf("deleted any Tag [%d]", id))
+			So(hookFields[2], ShouldBeNil)
+			Convey("Hooks should not be called for other models", func() {
+				events = nil
+				env.Pool("Resume").Call("Create", FieldMap{"Education": "Hooked"})
+				So(events, ShouldBeEmpty)
+			})
+		}), ShouldBeNil)
+	})
+}
+
+func TestAuditTrail(t *testing.T) {
+	Convey("Testing audit trail", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			userJane := env.Pool("User").Search(env.Pool("User").Model().Field("Email").Equals("jane.smith@example.com"))
+			note := env.Pool("Note").Call("Create", FieldMap{"Title": "Audited", "Stars": 3}).(RecordSet).Collection()
+			Convey("Creating a record should log its values", func() {
+				trail := note.AuditTrail()
+				So(trail.Len(), ShouldEqual, 1)
+				So(trail.Get("Operation"), ShouldEqual, "create")
+				So(trail.Get("UserID"), ShouldEqual, security.SuperUserID)
+				diffs := trail.Call("FieldDiffs").([]FieldDiff)
+				var fields []string
+				for _, d := range diffs {
+					fields = append(fields, d.Field)
+				}
+				So(fields, ShouldContain, "Title")
+				So(fields, ShouldContain, "Stars")
+				So(fields, ShouldNotContain, "WriteDate")
+			})
+			Convey("Writing a record should log changed fields only", func() {
+				note.Call("Write", FieldMap{"Title": "Audited", "Stars": 4, "User": userJane})
+				trail := note.AuditTrail()
+				So(trail.Len(), ShouldEqual, 2)
+				last := trail.Records()[0]
+				So(last.Get("Operation"), ShouldEqual, "write")
+				diffs := last.Call("FieldDiffs").([]FieldDiff)
+				So(diffs, ShouldHaveLength, 2)
+				So(diffs[0].Field, ShouldEqual, "Stars")
+				So(diffs[0].Old, ShouldEqual, 3)
+				So(diffs[0].New, ShouldEqual, 4)
+				So(diffs[1].Field, ShouldEqual, "User")
+				So(diffs[1].Old, ShouldBeEmpty)
+				So(diffs[1].New, ShouldHaveLength, 1)
+				note.Call("Write", FieldMap{"Stars": 4})
+				So(note.AuditTrail().Len(), ShouldEqual, 2)
+			})
+			Convey("Deleting a record should log its last values", func() {
+				id := note.Ids()[0]
+				note.Call("Unlink")
+				trail := env.Pool("Note").withIds([]int64{id}).AuditTrail()
+				So(trail.Len(), ShouldEqual, 2)
+				last := trail.Records()[0]
+				So(last.Get("Operation"), ShouldEqual, "unlink")
+				for _, d := range last.Call("FieldDiffs").([]FieldDiff) {
+					So(d.New, ShouldBeNil)
+					if d.Field == "Title" {
+						So(d.Old, ShouldEqual, "Audited")
+					}
+				}
+			})
+			Convey("Models that are not audited should not be logged", func() {
+				tag := env.Pool("Tag").Call("Create", FieldMap{"Name": "Not audited"}).(RecordSet).Collection()
+				So(tag.AuditTrail().IsEmpty(), ShouldBeTrue)
+			})
+		}), ShouldBeNil)
+	})
+}
+
+func TestTracking(t *testing.T) {
+	Convey("Testing field tracking", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			userJane := env.Pool("User").Search(env.Pool("User").Model().Field("Email").Equals("jane.smith@example.com"))
+			note := env.Pool("Note").Call("Create", FieldMap{"Title": "Tracked", "Stars": 3}).(RecordSet).Collection()
+			messagesOf := func(rc *RecordCollection) *RecordCollection {
+				messages := env.Pool("Message")
+				return messages.Search(messages.Model().Field("ResModel").Equals("Note").
+					And().Field("ResID").Equals(rc.Ids()[0]))
+			}
+			Convey("Creating a record should not post tracking messages", func() {
+				So(messagesOf(note).IsEmpty(), ShouldBeTrue)
+			})
+			Convey("Changing tracked fields should post a message with display values", func() {
+				note.Call("Write", FieldMap{"Title": "Tracked again", "State": "confirmed", "User": userJane})
+				messages := messagesOf(note)
+				So(messages.Len(), ShouldEqual, 1)
+				So(messages.Get("MessageType"), ShouldEqual, MessageNotification)
+				So(messages.Get("AuthorUID"), ShouldEqual, security.SuperUserID)
+				So(messages.Get("Body"), ShouldEqual, "State: Draft → Confirmed\nTitle: Tracked → Tracked again\nUser:  → "+
+					userJane.Get("DisplayName").(string))
+				tValues := messages.Get("TrackingValues").(RecordSet).Collection().Records()
+				So(tValues, ShouldHaveLength, 3)
+				So(tValues[0].Get("Field"), ShouldEqual, "State")
+				So(tValues[0].Get("OldValue"), ShouldEqual, "Draft")
+				So(tValues[0].Get("NewValue"), ShouldEqual, "Confirmed")
+			})
+			Convey("Unchanged and untracked fields should not post messages", func() {
+				note.Call("Write", FieldMap{"Title": "Tracked", "Stars": 5})
+				So(messagesOf(note).IsEmpty(), ShouldBeTrue)
+			})
+			Convey("Tracking messages should be deferred in import mode", func() {
+				importEnv := env.WithContext("import_mode", true)
+				importEnv.Pool("Note").withIds(note.Ids()).Call("Write", FieldMap{"Title": "Imported"})
+				So(messagesOf(note).IsEmpty(), ShouldBeTrue)
+				So(env.deferred.messages, ShouldHaveLength, 1)
+				importEnv.ProcessDeferredComputations()
+				So(env.deferred.messages, ShouldBeEmpty)
+				So(messagesOf(note).Get("Body"), ShouldEqual, "Title: Tracked → Imported")
+			})
+		}), ShouldBeNil)
+	})
+}
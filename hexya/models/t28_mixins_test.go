@@ -0,0 +1,161 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"testing"
+
+	"github.com/hexya-erp/hexya/hexya/models/security"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestApprovals(t *testing.T) {
+	Convey("Testing approvals", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			small := env.Pool("Note").Call("Create", FieldMap{"Title": "Small", "Amount": 50.0}).(RecordSet).Collection()
+			big := env.Pool("Note").Call("Create", FieldMap{"Title": "Big", "Amount": 5000.0}).(RecordSet).Collection()
+			Convey("Operations below the threshold should not require approval", func() {
+				So(func() { small.Call("Write", FieldMap{"State": "confirmed"}) }, ShouldNotPanic)
+				So(small.Call("RequestApproval", "confirm").(RecordSet).IsEmpty(), ShouldBeTrue)
+				So(func() {
+					env.Pool("Note").Call("Create", FieldMap{"Title": "Direct", "Amount": 10.0, "State": "confirmed"})
+				}, ShouldNotPanic)
+			})
+			Convey("Operations above the threshold should be blocked until approved", func() {
+				So(func() { big.Call("Write", FieldMap{"State": "confirmed"}) }, ShouldPanic)
+				So(func() {
+					env.Pool("Note").Call("Create", FieldMap{"Title": "Direct", "Amount": 2000.0, "State": "confirmed"})
+				}, ShouldPanic)
+				So(big.Call("ApprovalState", "confirm"), ShouldEqual, "")
+				approval := big.Call("RequestApproval", "confirm").(RecordSet).Collection()
+				So(approval.Len(), ShouldEqual, 1)
+				So(approval.Get("ApproverGroups"), ShouldEqual, "approvers")
+				So(big.Call("RequestApproval", "confirm").(RecordSet).Collection().Equals(approval), ShouldBeTrue)
+				So(big.Call("ApprovalState", "confirm"), ShouldEqual, "pending")
+				So(func() { big.Call("Write", FieldMap{"State": "confirmed"}) }, ShouldPanic)
+				approval.Call("Accept")
+				So(big.Call("ApprovalState", "confirm"), ShouldEqual, "accepted")
+				So(approval.Get("DecisionUID"), ShouldEqual, security.SuperUserID)
+				So(func() { approval.Call("Refuse", "Too late") }, ShouldPanic)
+				So(func() { big.Call("Write", FieldMap{"State": "confirmed"}) }, ShouldNotPanic)
+				So(big.Get("State"), ShouldEqual, "confirmed")
+				Convey("Approvals should not be valid for higher amounts", func() {
+					big.Call("Write", FieldMap{"State": "draft"})
+					So(func() { big.Call("Write", FieldMap{"State": "confirmed", "Amount": 6000.0}) }, ShouldPanic)
+					So(func() { big.Call("Write", FieldMap{"State": "confirmed", "Amount": 4000.0}) }, ShouldNotPanic)
+				})
+			})
+			Convey("Refused approvals should keep blocking the operation", func() {
+				approval := big.Call("RequestApproval", "confirm").(RecordSet).Collection()
+				approval.Call("Refuse", "Too expensive")
+				So(big.Call("ApprovalState", "confirm"), ShouldEqual, "refused")
+				So(approval.Get("Reason"), ShouldEqual, "Too expensive")
+				So(func() { big.Call("Write", FieldMap{"State": "confirmed"}) }, ShouldPanic)
+			})
+			Convey("Only approvers should decide approvals", func() {
+				approval := big.Call("RequestApproval", "confirm").(RecordSet).Collection()
+				approvers := security.Registry.GetGroup("approvers")
+				userEnv := env
+				userEnv.uid = 2
+				userApproval := userEnv.Pool("Approval").withIds(approval.Ids())
+				So(userApproval.Call("CanDecide"), ShouldBeFalse)
+				So(userEnv.Pool("Approval").Call("Inbox").(RecordSet).IsEmpty(), ShouldBeTrue)
+				So(func() { userApproval.Call("Accept") }, ShouldPanic)
+				security.Registry.AddMembership(2, approvers)
+				defer security.Registry.RemoveMembership(2, approvers)
+				So(userApproval.Call("CanDecide"), ShouldBeTrue)
+				So(userEnv.Pool("Approval").Call("Inbox").(RecordSet).Collection().Equals(approval), ShouldBeTrue)
+				userApproval.Call("Accept")
+				So(approval.Get("State"), ShouldEqual, "accepted")
+				So(approval.Get("DecisionUID"), ShouldEqual, 2)
+			})
+		}), ShouldBeNil)
+	})
+}
+
+func TestMessaging(t *testing.T) {
+	Convey("Testing messaging mixin", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			note := env.Pool("Note").Call("Create", FieldMap{"Title": "Discussed"}).(RecordSet).Collection()
+			userEnv := env
+			userEnv.uid = 2
+			Convey("The creator should follow the new record", func() {
+				So(note.Call("Followers"), ShouldResemble, []int64{security.SuperUserID})
+			})
+			Convey("Subscribing should add followers once", func() {
+				note.Call("Subscribe", []int64{2, 2, security.SuperUserID})
+				So(note.Call("Followers"), ShouldHaveLength, 2)
+				So(note.Call("Followers"), ShouldContain, int64(2))
+				note.Call("Unsubscribe", []int64{2})
+				So(note.Call("Followers"), ShouldResemble, []int64{security.SuperUserID})
+			})
+			Convey("Posting a message should notify the followers but the author", func() {
+				note.Call("Subscribe", []int64{2})
+				msg := note.Call("PostMessage", "Hello").(RecordSet).Collection()
+				So(msg.Len(), ShouldEqual, 1)
+				So(msg.Get("Body"), ShouldEqual, "Hello")
+				So(msg.Get("MessageType"), ShouldEqual, MessageComment)
+				So(msg.Get("AuthorUID"), ShouldEqual, security.SuperUserID)
+				So(note.Call("Messages").(RecordSet).Collection().Equals(msg), ShouldBeTrue)
+				So(env.Pool("Message").Call("Inbox").(RecordSet).IsEmpty(), ShouldBeTrue)
+				inbox := userEnv.Pool("Message").Call("Inbox").(RecordSet).Collection()
+				So(inbox.Equals(msg), ShouldBeTrue)
+				inbox.Call("MarkAsRead")
+				So(userEnv.Pool("Message").Call("Inbox").(RecordSet).IsEmpty(), ShouldBeTrue)
+			})
+			Convey("Tracking messages should notify the followers", func() {
+				note.Call("Subscribe", []int64{2})
+				note.Call("Write", FieldMap{"Title": "Discussed again"})
+				inbox := userEnv.Pool("Message").Call("Inbox").(RecordSet).Collection()
+				So(inbox.Len(), ShouldEqual, 1)
+				So(inbox.Get("MessageType"), ShouldEqual, MessageNotification)
+			})
+			Convey("Deleting a record should delete its messages and followers", func() {
+				note.Call("PostMessage", "Bye")
+				id := note.Ids()[0]
+				note.Call("Unlink")
+				deleted := env.Pool("Note").withIds([]int64{id})
+				So(deleted.Call("Messages").(RecordSet).IsEmpty(), ShouldBeTrue)
+				So(deleted.followers().IsEmpty(), ShouldBeTrue)
+			})
+		}), ShouldBeNil)
+	})
+}
+
+func TestStages(t *testing.T) {
+	Convey("Testing stages", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			stages := env.Pool("Stage")
+			draft := stages.Call("Create", FieldMap{"Name": "Draft", "Sequence": 1, "ResModel": "Note"}).(RecordSet).Collection()
+			progress := stages.Call("Create", FieldMap{"Name": "In Progress", "Sequence": 5}).(RecordSet).Collection()
+			done := stages.Call("Create", FieldMap{"Name": "Done", "Sequence": 20, "Fold": true, "ResModel": "Note"}).(RecordSet).Collection()
+			stages.Call("Create", FieldMap{"Name": "Tag Stage", "Sequence": 0, "ResModel": "Tag"})
+			notes := env.Pool("Note")
+			Convey("New records should be set to the first applicable stage", func() {
+				note := notes.Call("Create", FieldMap{"Title": "Staged Note"}).(RecordSet).Collection()
+				So(note.Get("Stage").(RecordSet).Collection().Ids(), ShouldResemble, draft.Ids())
+				note = notes.Call("Create", FieldMap{"Title": "Done Note", "Stage": done}).(RecordSet).Collection()
+				So(note.Get("Stage").(RecordSet).Collection().Ids(), ShouldResemble, done.Ids())
+			})
+			Convey("Records should move from stage to stage", func() {
+				note := notes.Call("Create", FieldMap{"Title": "Moving Note"}).(RecordSet).Collection()
+				note.Set("Stage", progress)
+				So(note.Get("Stage").(RecordSet).Collection().Ids(), ShouldResemble, progress.Ids())
+				note.Set("Stage", done)
+				So(note.Get("Stage").(RecordSet).Collection().Ids(), ShouldResemble, done.Ids())
+			})
+			Convey("Stage groups should list the applicable stages with their folding", func() {
+				notes.Call("Create", FieldMap{"Title": "Grouped Note 1"})
+				notes.Call("Create", FieldMap{"Title": "Grouped Note 2"})
+				notes.Call("Create", FieldMap{"Title": "Grouped Note 3", "Stage": done})
+				grouped := notes.Search(notes.Model().Field("Title").Contains("Grouped Note"))
+				So(grouped.Call("ReadStageGroups"), ShouldResemble, []StageGroup{
+					{ID: draft.Ids()[0], Name: "Draft", Fold: false, Count: 2},
+					{ID: progress.Ids()[0], Name: "In Progress", Fold: false, Count: 0},
+					{ID: done.Ids()[0], Name: "Done", Fold: true, Count: 1},
+				})
+			})
+		}), ShouldBeNil)
+	})
+}
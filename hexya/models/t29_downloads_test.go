@@ -0,0 +1,56 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"testing"
+
+	"github.com/hexya-erp/hexya/hexya/models/security"
+	"github.com/hexya-erp/hexya/hexya/models/types/dates"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDownloads(t *testing.T) {
+	Convey("Testing background downloads", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			tags := env.Pool("Tag").Search(env.Pool("Tag").Model().Field("Name").IsNotNull())
+			So(tags.IsEmpty(), ShouldBeFalse)
+			Convey("Pending downloads should be generated and notified", func() {
+				download := tags.ExportInBackground([]string{"Name"}, ExportCSV)
+				So(download.Get("State"), ShouldEqual, "pending")
+				So(download.Get("UserID"), ShouldEqual, security.SuperUserID)
+				claimed := env.claimDownload()
+				So(claimed.Equals(download), ShouldBeTrue)
+				claimed.generateDownload()
+				So(download.Get("State"), ShouldEqual, "done")
+				So(download.Get("Name"), ShouldEqual, "Tag.csv")
+				So(download.Get("DoneDate").(dates.DateTime).IsZero(), ShouldBeFalse)
+				attachment := download.Get("Attachment").(RecordSet).Collection()
+				So(attachment.Get("Name"), ShouldEqual, "Tag.csv")
+				So(attachment.Get("FileSize"), ShouldBeGreaterThan, 0)
+				inbox := env.Pool("Message").Call("Inbox").(RecordSet).Collection()
+				So(inbox.Len(), ShouldEqual, 1)
+				So(inbox.Get("Body"), ShouldContainSubstring, DownloadURL(attachment.Ids()[0]))
+				So(env.claimDownload(), ShouldBeNil)
+			})
+			Convey("Failing downloads should be marked as failed", func() {
+				download := env.ScheduleDownload(DownloadExport, exportParams{
+					Model:  "Tag",
+					IDs:    tags.Ids(),
+					Fields: []string{"UnknownField"},
+					Format: ExportCSV,
+				})
+				env.claimDownload().generateDownload()
+				So(download.Get("State"), ShouldEqual, "failed")
+				So(download.Get("Error"), ShouldNotBeBlank)
+				So(download.Get("Attachment").(RecordSet).IsEmpty(), ShouldBeTrue)
+				So(env.Pool("Message").Call("Inbox").(RecordSet).Collection().Get("Body"), ShouldEqual,
+					"Your download could not be generated.")
+			})
+			Convey("Unknown generators should not be scheduled", func() {
+				So(func() { env.ScheduleDownload("unknown", nil) }, ShouldPanic)
+			})
+		}), ShouldBeNil)
+	})
+}
@@ -0,0 +1,111 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hexya-erp/hexya/hexya/models/security"
+	"github.com/hexya-erp/hexya/hexya/models/types/dates"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMails(t *testing.T) {
+	Convey("Testing mails", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			type sentMail struct {
+				server     mailServerConfig
+				from       string
+				recipients []string
+				msg        string
+			}
+			var sent []sentMail
+			sendErr := error(nil)
+			defaultSendMail := sendMail
+			sendMail = func(server mailServerConfig, from string, recipients []string, msg []byte) error {
+				sent = append(sent, sentMail{server: server, from: from, recipients: recipients, msg: string(msg)})
+				return sendErr
+			}
+			defer func() { sendMail = defaultSendMail }()
+			userJane := env.Pool("User").Search(env.Pool("User").Model().Field("Email").Equals("jane.smith@example.com"))
+			note := env.Pool("Note").Call("Create", FieldMap{"Title": "Fish & Chips", "User": userJane}).(RecordSet).Collection()
+			template := env.Pool("MailTemplate").Call("Create", FieldMap{
+				"Name":      "Note Notification",
+				"Model":     "Note",
+				"EmailFrom": "noreply@example.com",
+				"EmailTo":   "{{ record.User.Name }} <{{ record.User.Email }}>",
+				"EmailCC":   "archive@example.com",
+				"Subject":   "Note {{ record.Title }}",
+				"Body":      "<p>{{ record.Title }} by {{ record.User }}</p>",
+			}).(RecordSet).Collection()
+			Convey("Placeholders should be rendered with the values of the record", func() {
+				So(note.RenderPlaceholders("{{ record.Title }}: {{ record.Stars + 1 }} {{record.User}}"), ShouldEqual,
+					"Fish & Chips: 1 "+userJane.Get("DisplayName").(string))
+			})
+			Convey("Templates should queue rendered mails", func() {
+				mail := template.Call("SendMail", note).(RecordSet).Collection()
+				So(mail.Len(), ShouldEqual, 1)
+				So(mail.Get("State"), ShouldEqual, "outgoing")
+				So(mail.Get("ResModel"), ShouldEqual, "Note")
+				So(mail.Get("ResID"), ShouldEqual, note.Ids()[0])
+				So(mail.Get("EmailTo"), ShouldEqual, userJane.Get("Name").(string)+" <jane.smith@example.com>")
+				So(mail.Get("Subject"), ShouldEqual, "Note Fish & Chips")
+				So(mail.Get("Body"), ShouldEqual, "<p>Fish &amp; Chips by "+userJane.Get("DisplayName").(string)+"</p>")
+				So(func() {
+					template.Call("SendMail", env.Pool("Tag").Search(env.Pool("Tag").Model().Field("Name").IsNotNull()))
+				}, ShouldPanic)
+				Convey("Mails should not be sent without a mail server", func() {
+					mail.Call("Send")
+					So(sent, ShouldBeEmpty)
+					So(mail.Get("State"), ShouldEqual, "exception")
+					So(mail.Get("FailureReason"), ShouldContainSubstring, "No mail server configured")
+				})
+				Convey("Mails should be sent through the first active mail server", func() {
+					env.Pool("MailServer").Call("Create", FieldMap{"Name": "Backup", "Host": "backup.example.com", "Sequence": int64(20)})
+					env.Pool("MailServer").Call("Create", FieldMap{"Name": "Main", "Host": "smtp.example.com", "Port": int64(587),
+						"Encryption": "starttls"})
+					So(env.Pool("Mail").Call("ProcessQueue"), ShouldEqual, 1)
+					So(sent, ShouldHaveLength, 1)
+					So(sent[0].server.host, ShouldEqual, "smtp.example.com")
+					So(sent[0].server.port, ShouldEqual, 587)
+					So(sent[0].from, ShouldEqual, "noreply@example.com")
+					So(sent[0].recipients, ShouldResemble, []string{"jane.smith@example.com", "archive@example.com"})
+					So(sent[0].msg, ShouldContainSubstring, "Subject: Note Fish & Chips")
+					So(sent[0].msg, ShouldContainSubstring, "Cc: <archive@example.com>")
+					So(mail.Get("State"), ShouldEqual, "sent")
+					So(mail.Get("SentDate").(dates.DateTime).IsZero(), ShouldBeFalse)
+					So(env.Pool("Mail").Call("ProcessQueue"), ShouldEqual, 0)
+				})
+				Convey("Failed mails should be retried", func() {
+					env.Pool("MailServer").Call("Create", FieldMap{"Name": "Main", "Host": "smtp.example.com"})
+					sendErr = errors.New("connection refused")
+					mail.Call("Send")
+					So(mail.Get("State"), ShouldEqual, "exception")
+					So(mail.Get("FailureReason"), ShouldContainSubstring, "connection refused")
+					sendErr = nil
+					mail.Call("Retry")
+					So(mail.Get("State"), ShouldEqual, "outgoing")
+					mail.Call("Send")
+					So(mail.Get("State"), ShouldEqual, "sent")
+					mail.Call("Cancel")
+					So(mail.Get("State"), ShouldEqual, "sent")
+				})
+			})
+			Convey("Scheduled mails should not be sent before their date", func() {
+				env.Pool("MailServer").Call("Create", FieldMap{"Name": "Main", "Host": "smtp.example.com"})
+				mail := env.SendMail(FieldMap{
+					"EmailFrom":     "noreply@example.com",
+					"EmailTo":       "jane.smith@example.com",
+					"Subject":       "Later",
+					"ScheduledDate": dates.Now().Add(time.Hour),
+				})
+				So(env.Pool("Mail").Call("ProcessQueue"), ShouldEqual, 0)
+				mail.Call("Cancel")
+				So(mail.Get("State"), ShouldEqual, "cancel")
+			})
+		}), ShouldBeNil)
+	})
+}
@@ -0,0 +1,78 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hexya-erp/hexya/hexya/models/security"
+	"github.com/hexya-erp/hexya/hexya/models/types/dates"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestKVStore(t *testing.T) {
+	Convey("Testing key/value stores", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			store := env.KVStore("sync")
+			Convey("Missing keys should return the default values", func() {
+				So(store.GetString("cursor", "start"), ShouldEqual, "start")
+				So(store.GetInt("last_id", 7), ShouldEqual, 7)
+				So(store.Version("cursor"), ShouldEqual, 0)
+				So(store.Keys(), ShouldBeEmpty)
+			})
+			Convey("Set values should be read with their type", func() {
+				now := dates.Now()
+				So(store.Set("cursor", "abc"), ShouldEqual, 1)
+				store.Set("last_id", int64(42))
+				store.Set("ratio", 0.5)
+				store.Set("enabled", true)
+				store.Set("last_sync", now)
+				store.Set("state", map[string]int{"done": 3})
+				So(store.GetString("cursor", ""), ShouldEqual, "abc")
+				So(store.GetInt("last_id", 0), ShouldEqual, 42)
+				So(store.GetFloat("ratio", 0), ShouldEqual, 0.5)
+				So(store.GetBool("enabled", false), ShouldBeTrue)
+				So(store.GetDateTime("last_sync", dates.DateTime{}).Equal(now.Truncate(time.Second)), ShouldBeTrue)
+				var state map[string]int
+				So(store.Get("state", &state), ShouldEqual, 1)
+				So(state, ShouldResemble, map[string]int{"done": 3})
+				So(store.Keys(), ShouldResemble, []string{"cursor", "enabled", "last_id", "last_sync", "ratio", "state"})
+				So(func() { store.GetInt("cursor", 0) }, ShouldPanic)
+			})
+			Convey("Namespaces should be isolated", func() {
+				store.Set("cursor", "abc")
+				other := env.KVStore("other")
+				So(other.GetString("cursor", ""), ShouldEqual, "")
+				other.Set("cursor", "xyz")
+				So(store.GetString("cursor", ""), ShouldEqual, "abc")
+				So(func() { env.KVStore("") }, ShouldPanic)
+			})
+			Convey("Writing should increment the version", func() {
+				So(store.Set("cursor", "a"), ShouldEqual, 1)
+				So(store.Set("cursor", "b"), ShouldEqual, 2)
+				So(store.Version("cursor"), ShouldEqual, 2)
+			})
+			Convey("CompareAndSet should only write the expected version", func() {
+				So(store.CompareAndSet("cursor", "a", 0), ShouldBeTrue)
+				So(store.CompareAndSet("cursor", "b", 0), ShouldBeFalse)
+				var cursor string
+				version := store.Get("cursor", &cursor)
+				So(version, ShouldEqual, 1)
+				So(cursor, ShouldEqual, "a")
+				So(store.CompareAndSet("cursor", "c", version), ShouldBeTrue)
+				So(store.CompareAndSet("cursor", "d", version), ShouldBeFalse)
+				So(store.GetString("cursor", ""), ShouldEqual, "c")
+				So(store.Version("cursor"), ShouldEqual, 2)
+			})
+			Convey("Deleted keys should be missing", func() {
+				store.Set("cursor", "a")
+				store.Delete("cursor")
+				store.Delete("unknown")
+				So(store.GetString("cursor", "none"), ShouldEqual, "none")
+				So(store.CompareAndSet("cursor", "b", 0), ShouldBeTrue)
+			})
+		}), ShouldBeNil)
+	})
+}
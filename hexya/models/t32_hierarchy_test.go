@@ -0,0 +1,79 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/hexya-erp/hexya/hexya/models/security"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestHierarchy(t *testing.T) {
+	Convey("Testing hierarchy helpers", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			newTag := func(name string, parent *RecordCollection) *RecordCollection {
+				return env.Pool("Tag").Call("Create", FieldMap{
+					"Name":        name,
+					"Description": name + " Tag",
+					"Parent":      parent,
+				}).(RecordSet).Collection()
+			}
+			food := newTag("Food", env.Pool("Tag"))
+			fruits := newTag("Fruits", food)
+			apples := newTag("Apples", fruits)
+			pears := newTag("Pears", fruits)
+			drinks := newTag("Drinks", env.Pool("Tag"))
+			path := func(rc *RecordCollection) string {
+				var res string
+				for _, id := range rc.Ids() {
+					res = fmt.Sprintf("%s%d/", res, id)
+				}
+				return res
+			}
+			Convey("Parent paths should be maintained on creation", func() {
+				So(food.Get("ParentPath"), ShouldEqual, path(food))
+				So(apples.Get("ParentPath"), ShouldEqual, path(food)+path(fruits)+path(apples))
+			})
+			Convey("Ancestors should return all the parents up to the root", func() {
+				So(apples.Ancestors().Ids(), ShouldHaveLength, 2)
+				So(apples.Ancestors().Ids(), ShouldContain, food.Ids()[0])
+				So(apples.Ancestors().Ids(), ShouldContain, fruits.Ids()[0])
+				So(food.Ancestors().IsEmpty(), ShouldBeTrue)
+			})
+			Convey("Descendants should return all the children down to the leaves", func() {
+				So(food.Descendants().Ids(), ShouldHaveLength, 3)
+				So(food.Descendants().Ids(), ShouldContain, pears.Ids()[0])
+				So(food.Union(drinks).Descendants().Ids(), ShouldHaveLength, 3)
+				So(apples.Descendants().IsEmpty(), ShouldBeTrue)
+			})
+			Convey("RootNodes should return the top-level ancestors", func() {
+				roots := apples.Union(drinks).RootNodes()
+				So(roots.Ids(), ShouldHaveLength, 2)
+				So(roots.Ids(), ShouldContain, food.Ids()[0])
+				So(roots.Ids(), ShouldContain, drinks.Ids()[0])
+			})
+			Convey("Moving a record should update the paths of its descendants", func() {
+				fruits.Set("Parent", drinks)
+				So(fruits.Get("ParentPath"), ShouldEqual, path(drinks)+path(fruits))
+				So(apples.Get("ParentPath"), ShouldEqual, path(drinks)+path(fruits)+path(apples))
+				So(food.Descendants().IsEmpty(), ShouldBeTrue)
+				So(pears.RootNodes().Ids(), ShouldResemble, drinks.Ids())
+			})
+			Convey("Deleting a record should make its children roots", func() {
+				fruits.Call("Unlink")
+				So(apples.Get("ParentPath"), ShouldEqual, path(apples))
+				So(food.Descendants().IsEmpty(), ShouldBeTrue)
+			})
+			Convey("Creating loops should panic", func() {
+				So(func() { food.Set("Parent", apples) }, ShouldPanic)
+				So(func() { fruits.Set("Parent", fruits) }, ShouldPanic)
+			})
+			Convey("Models without parent should panic", func() {
+				So(func() { env.Pool("User").SearchAll().Ancestors() }, ShouldPanic)
+			})
+		}), ShouldBeNil)
+	})
+}
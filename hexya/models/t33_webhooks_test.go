@@ -0,0 +1,118 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/hexya-erp/hexya/hexya/models/security"
+	"github.com/hexya-erp/hexya/hexya/models/types/dates"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestWebhooks(t *testing.T) {
+	Convey("Testing webhooks", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			type postedWebhook struct {
+				url     string
+				headers map[string]string
+				payload string
+			}
+			var posted []postedWebhook
+			status := http.StatusOK
+			defaultPostWebhook := postWebhook
+			postWebhook = func(url string, headers map[string]string, payload []byte) (int, error) {
+				posted = append(posted, postedWebhook{url: url, headers: headers, payload: string(payload)})
+				return status, nil
+			}
+			defer func() { postWebhook = defaultPostWebhook }()
+			webhook := env.Pool("Webhook").Call("Create", FieldMap{
+				"Name":          "Starred Notes",
+				"Model":         "Note",
+				"Event":         "write",
+				"Condition":     "record.Stars > 2",
+				"URL":           "https://example.com/hooks/notes",
+				"PayloadFields": "Title, Stars",
+				"Secret":        "s3cr3t",
+				"MaxAttempts":   int64(2),
+			}).(RecordSet).Collection()
+			note := env.Pool("Note").Call("Create", FieldMap{"Title": "Recipes"}).(RecordSet).Collection()
+			deliveries := func() *RecordCollection {
+				return webhook.Get("Deliveries").(RecordSet).Collection()
+			}
+			Convey("Webhooks should only be queued for their event and condition", func() {
+				So(deliveries().IsEmpty(), ShouldBeTrue)
+				note.Set("Stars", int64(1))
+				So(deliveries().IsEmpty(), ShouldBeTrue)
+				note.Set("Stars", int64(3))
+				So(deliveries().Len(), ShouldEqual, 1)
+				So(deliveries().Get("State"), ShouldEqual, "pending")
+				So(deliveries().Get("ResID"), ShouldEqual, note.Ids()[0])
+				So(posted, ShouldBeEmpty)
+			})
+			Convey("Webhooks should not be queued on other models or when inactive", func() {
+				env.Pool("Tag").Call("Create", FieldMap{"Name": "Webhook Tag"})
+				webhook.Set("Active", false)
+				note.Set("Stars", int64(4))
+				So(deliveries().IsEmpty(), ShouldBeTrue)
+			})
+			Convey("Deliveries should be sent signed with the selected fields", func() {
+				note.Set("Stars", int64(3))
+				delivery := deliveries()
+				delivery.Call("Send")
+				So(posted, ShouldHaveLength, 1)
+				So(posted[0].url, ShouldEqual, "https://example.com/hooks/notes")
+				So(posted[0].headers["X-Hexya-Event"], ShouldEqual, "write")
+				So(posted[0].headers["X-Hexya-Signature"], ShouldEqual, webhookSignature("s3cr3t", []byte(posted[0].payload)))
+				var payload map[string]interface{}
+				So(json.Unmarshal([]byte(posted[0].payload), &payload), ShouldBeNil)
+				So(payload["model"], ShouldEqual, "Note")
+				So(payload["fields"], ShouldResemble, []interface{}{"Stars"})
+				data := payload["data"].(map[string]interface{})
+				So(data["Title"], ShouldEqual, "Recipes")
+				So(data["Stars"], ShouldEqual, 3)
+				So(data, ShouldNotContainKey, "User")
+				So(delivery.Get("State"), ShouldEqual, "done")
+				So(delivery.Get("ResponseStatus"), ShouldEqual, 200)
+				So(delivery.Get("Attempts"), ShouldEqual, 1)
+			})
+			Convey("Failed deliveries should be retried until the maximum attempts", func() {
+				status = http.StatusInternalServerError
+				note.Set("Stars", int64(3))
+				delivery := deliveries()
+				delivery.Call("Send")
+				So(delivery.Get("State"), ShouldEqual, "pending")
+				So(delivery.Get("Attempts"), ShouldEqual, 1)
+				So(delivery.Get("ResponseStatus"), ShouldEqual, 500)
+				So(delivery.Get("Error"), ShouldNotBeBlank)
+				So(delivery.Get("NextAttempt").(dates.DateTime).Greater(dates.Now()), ShouldBeTrue)
+				delivery.Call("Send")
+				So(delivery.Get("State"), ShouldEqual, "failed")
+				So(delivery.Get("Attempts"), ShouldEqual, 2)
+				Convey("Failed deliveries can be retried manually", func() {
+					status = http.StatusOK
+					delivery.Call("Retry")
+					So(delivery.Get("State"), ShouldEqual, "pending")
+					delivery.Call("Send")
+					So(delivery.Get("State"), ShouldEqual, "done")
+				})
+			})
+			Convey("Deletion webhooks should only hold the id", func() {
+				webhook.Set("Event", "unlink")
+				noteID := note.Ids()[0]
+				note.Call("Unlink")
+				So(deliveries().Len(), ShouldEqual, 1)
+				var payload map[string]interface{}
+				So(json.Unmarshal([]byte(deliveries().Get("Payload").(string)), &payload), ShouldBeNil)
+				So(payload["id"], ShouldEqual, float64(noteID))
+				So(payload, ShouldNotContainKey, "data")
+			})
+			Convey("Webhooks on unknown models should panic", func() {
+				So(func() { webhook.Set("Model", "NoSuchModel") }, ShouldPanic)
+			})
+		}), ShouldBeNil)
+	})
+}
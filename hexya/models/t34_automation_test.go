@@ -0,0 +1,129 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hexya-erp/hexya/hexya/models/security"
+	"github.com/hexya-erp/hexya/hexya/models/types/dates"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestServerActions(t *testing.T) {
+	Convey("Testing server actions", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			notes := env.Pool("Note")
+			note1 := notes.Call("Create", FieldMap{"Title": "Draft", "Stars": int64(2)}).(RecordSet).Collection()
+			note2 := notes.Call("Create", FieldMap{"Title": "Ideas", "Stars": int64(4)}).(RecordSet).Collection()
+			newAction := func(values FieldMap) *RecordCollection {
+				values["Name"] = "Test Action"
+				values["Model"] = "Note"
+				return env.Pool("ServerAction").Call("Create", values).(RecordSet).Collection()
+			}
+			Convey("Method actions should call the method with the arguments", func() {
+				action := newAction(FieldMap{"ActionType": "method", "Method": "PostMessage", "Arguments": `["Reviewed"]`})
+				action.Call("Run", note1)
+				messages := note1.Call("Messages").(RecordSet).Collection()
+				So(messages.Len(), ShouldEqual, 1)
+				So(messages.Get("Body"), ShouldEqual, "Reviewed")
+				So(note2.Call("Messages").(RecordSet).IsEmpty(), ShouldBeTrue)
+			})
+			Convey("Write actions should write the values", func() {
+				action := newAction(FieldMap{"ActionType": "write", "Values": `{"State": "confirmed", "Stars": 5}`})
+				action.Call("Run", note1.Union(note2))
+				So(note1.Get("State"), ShouldEqual, "confirmed")
+				So(note2.Get("Stars"), ShouldEqual, 5)
+			})
+			Convey("Expression actions should write the computed values", func() {
+				action := newAction(FieldMap{"ActionType": "expression", "Script": `
+					# Promote the note
+					Stars = record.Stars * 2
+					Title = record.Title + " (" + str(record.Stars) + ")"`})
+				action.Call("Run", note1.Union(note2))
+				So(note1.Get("Stars"), ShouldEqual, 4)
+				So(note1.Get("Title"), ShouldEqual, "Draft (2)")
+				So(note2.Get("Stars"), ShouldEqual, 8)
+			})
+			Convey("Filtered actions should only run on matching records", func() {
+				action := newAction(FieldMap{"ActionType": "write", "Values": `{"Title": "Starred"}`,
+					"Filter": "record.Stars > 3"})
+				env.Pool("ServerAction").Call("RunByID", action.Ids()[0])
+				So(note1.Get("Title"), ShouldEqual, "Draft")
+				So(note2.Get("Title"), ShouldEqual, "Starred")
+			})
+			Convey("Invalid actions should panic", func() {
+				So(func() { newAction(FieldMap{"ActionType": "method", "Method": "NoSuchMethod"}) }, ShouldPanic)
+				So(func() { newAction(FieldMap{"ActionType": "write", "Values": `{"NoSuchField": 1}`}) }, ShouldPanic)
+				So(func() { newAction(FieldMap{"ActionType": "expression", "Script": "Stars == 2"}) }, ShouldPanic)
+				So(func() { newAction(FieldMap{"ActionType": "expression", "Script": "Stars = (2"}) }, ShouldPanic)
+				action := newAction(FieldMap{"ActionType": "method", "Method": "PostMessage", "Arguments": `["Hi"]`})
+				So(func() { action.Call("Run", env.Pool("Tag").SearchAll()) }, ShouldPanic)
+			})
+		}), ShouldBeNil)
+	})
+}
+
+func TestAutomationRules(t *testing.T) {
+	Convey("Testing automation rules", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			promote := env.Pool("ServerAction").Call("Create", FieldMap{
+				"Name":       "Promote",
+				"Model":      "Note",
+				"ActionType": "expression",
+				"Script":     "Stars = record.Stars + 1",
+			}).(RecordSet).Collection()
+			newRule := func(values FieldMap) *RecordCollection {
+				values["Name"] = "Test Rule"
+				values["Model"] = "Note"
+				values["Actions"] = promote
+				return env.Pool("AutomationRule").Call("Create", values).(RecordSet).Collection()
+			}
+			Convey("Creation rules should run on matching new records", func() {
+				newRule(FieldMap{"Trigger": "create", "Filter": "record.Title != 'Ignored'"})
+				note := env.Pool("Note").Call("Create", FieldMap{"Title": "Promoted"}).(RecordSet).Collection()
+				So(note.Get("Stars"), ShouldEqual, 1)
+				ignored := env.Pool("Note").Call("Create", FieldMap{"Title": "Ignored"}).(RecordSet).Collection()
+				So(ignored.Get("Stars"), ShouldEqual, 0)
+				note.Set("Title", "Updated")
+				So(note.Get("Stars"), ShouldEqual, 1)
+			})
+			Convey("Update rules should only run when watched fields are written", func() {
+				newRule(FieldMap{"Trigger": "write", "WatchedFields": "State"})
+				note := env.Pool("Note").Call("Create", FieldMap{"Title": "Watched"}).(RecordSet).Collection()
+				So(note.Get("Stars"), ShouldEqual, 0)
+				note.Set("Title", "Still Watched")
+				So(note.Get("Stars"), ShouldEqual, 0)
+				note.Set("State", "confirmed")
+				So(note.Get("Stars"), ShouldEqual, 1)
+			})
+			Convey("Rules should not be triggered again by their own actions", func() {
+				newRule(FieldMap{"Trigger": "create_write"})
+				note := env.Pool("Note").Call("Create", FieldMap{"Title": "Loop"}).(RecordSet).Collection()
+				So(note.Get("Stars"), ShouldEqual, 1)
+				note.Set("Title", "Loop Again")
+				So(note.Get("Stars"), ShouldEqual, 2)
+			})
+			Convey("Time based rules should run on records whose date is reached", func() {
+				note := env.Pool("Note").Call("Create", FieldMap{"Title": "Timed"}).(RecordSet).Collection()
+				rule := newRule(FieldMap{"Trigger": "time", "DateField": "CreateDate",
+					"LastRun": dates.Now().Add(-time.Hour)})
+				late := newRule(FieldMap{"Trigger": "time", "DateField": "CreateDate", "DelayCount": int64(1),
+					"DelayUnit": "days", "LastRun": dates.Now().Add(-time.Hour)})
+				late.Call("RunTimed")
+				So(note.Get("Stars"), ShouldEqual, 0)
+				rule.Call("RunTimed")
+				So(note.Get("Stars"), ShouldEqual, 1)
+				rule.Call("RunTimed")
+				So(note.Get("Stars"), ShouldEqual, 1)
+				So(rule.Get("LastRun").(dates.DateTime).Greater(dates.Now().Add(-time.Minute)), ShouldBeTrue)
+			})
+			Convey("Invalid rules should panic", func() {
+				So(func() { newRule(FieldMap{"Trigger": "time", "DateField": "Title"}) }, ShouldPanic)
+				So(func() { newRule(FieldMap{"Trigger": "write", "WatchedFields": "NoSuchField"}) }, ShouldPanic)
+			})
+		}), ShouldBeNil)
+	})
+}
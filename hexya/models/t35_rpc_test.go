@@ -0,0 +1,95 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hexya-erp/hexya/hexya/models/security"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCallRPC(t *testing.T) {
+	Convey("Testing method calls of API clients", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			Convey("Arguments should be decoded with the method parameters types", func() {
+				ids := env.Pool("Tag").CallRPC("Create", json.RawMessage(`{"Name": "RPC Tag", "Rate": 4.5}`))
+				So(ids, ShouldHaveLength, 1)
+				tag := env.Pool("Tag").withIds(ids.([]int64))
+				So(tag.Get("Name"), ShouldEqual, "RPC Tag")
+				So(tag.CallRPC("Write", json.RawMessage(`{"Description": "Updated"}`), json.RawMessage(`"Rate"`)), ShouldBeTrue)
+				So(tag.Get("Description"), ShouldEqual, "Updated")
+				So(tag.Get("Rate"), ShouldEqual, 0)
+				res := tag.CallRPC("Read", json.RawMessage(`["Name"]`)).([]FieldMap)
+				So(res, ShouldHaveLength, 1)
+				So(res[0]["Name"], ShouldEqual, "RPC Tag")
+				So(env.Pool("Tag").SearchAll().CallRPC("SearchCount"), ShouldEqual, env.Pool("Tag").SearchAll().SearchCount())
+			})
+			Convey("Private and unknown methods should not be callable", func() {
+				So(env.Pool("Tag").Model().Methods().MustGet("Sudo").IsPrivate(), ShouldBeTrue)
+				So(func() { env.Pool("Tag").CallRPC("Sudo") }, ShouldPanic)
+				So(func() { env.Pool("Tag").CallRPC("UnknownMethod") }, ShouldPanic)
+			})
+			Convey("Invalid arguments should panic", func() {
+				So(func() { env.Pool("Tag").CallRPC("Read", json.RawMessage(`"Name"`)) }, ShouldPanic)
+				So(func() { env.Pool("Tag").CallRPC("SearchCount", json.RawMessage(`1`)) }, ShouldPanic)
+			})
+		}), ShouldBeNil)
+	})
+}
+
+func TestExecuteKW(t *testing.T) {
+	Convey("Testing the Odoo external API", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			tags := env.Pool("Tag")
+			Convey("Domains should be parsed into conditions", func() {
+				cond := tags.Model().ParseDomain([]interface{}{"|", []interface{}{"name", "=", "Books"}, "!", []interface{}{"Description", "=", false}})
+				So(tags.Search(cond).SearchCount(), ShouldEqual,
+					tags.Search(tags.Model().Field("Name").Equals("Books").Or().Field("Description").IsNotNull()).SearchCount())
+				So(func() { tags.Model().ParseDomain([]interface{}{"|", []interface{}{"Name", "=", "Books"}}) }, ShouldPanic)
+				So(func() { tags.Model().ParseDomain([]interface{}{[]interface{}{"Name", "~", "Books"}}) }, ShouldPanic)
+				So(func() { tags.Model().ParseDomain([]interface{}{"Name"}) }, ShouldPanic)
+			})
+			Convey("CRUD methods should be translated into Hexya methods", func() {
+				domain := []interface{}{[]interface{}{"name", "=", "XML Tag"}}
+				id := env.ExecuteKW("tag", "create", []interface{}{map[string]interface{}{"name": "XML Tag", "rate": 2.5}}, nil).(int64)
+				So(env.ExecuteKW("tag", "search", []interface{}{domain}, nil), ShouldResemble, []int64{id})
+				So(env.ExecuteKW("tag", "search", []interface{}{domain}, map[string]interface{}{"count": true}), ShouldEqual, 1)
+				So(env.ExecuteKW("tag", "write", []interface{}{[]interface{}{id}, map[string]interface{}{"description": "From XML-RPC"}}, nil), ShouldBeTrue)
+				res := env.ExecuteKW("tag", "read", []interface{}{[]interface{}{id}}, map[string]interface{}{"fields": []interface{}{"name", "description"}}).([]FieldMap)
+				So(res, ShouldHaveLength, 1)
+				So(res[0]["description"], ShouldEqual, "From XML-RPC")
+				So(env.ExecuteKW("tag", "name_get", []interface{}{[]interface{}{id}}, nil), ShouldResemble, [][]interface{}{{id, "XML Tag"}})
+				So(env.ExecuteKW("tag", "unlink", []interface{}{[]interface{}{id}}, nil), ShouldBeTrue)
+				So(env.ExecuteKW("tag", "exists", []interface{}{[]interface{}{id}}, nil), ShouldBeEmpty)
+			})
+			Convey("Many2many commands should be applied to the current value", func() {
+				posts := env.Pool("Post")
+				post := posts.Search(posts.Model().Field("Title").Equals("1st Post"))
+				tag1 := tags.Call("Create", FieldMap{"Name": "Command Tag 1"}).(RecordSet).Ids()[0]
+				tag2 := tags.Call("Create", FieldMap{"Name": "Command Tag 2"}).(RecordSet).Ids()[0]
+				write := func(command ...interface{}) {
+					env.ExecuteKW("Post", "write", []interface{}{[]interface{}{post.Ids()[0]}, map[string]interface{}{"Tags": []interface{}{command}}}, nil)
+				}
+				write(int64(6), int64(0), []interface{}{tag1})
+				So(post.Get("Tags").(RecordSet).Ids(), ShouldResemble, []int64{tag1})
+				write(int64(4), tag2)
+				So(post.Get("Tags").(RecordSet).Ids(), ShouldHaveLength, 2)
+				write(int64(3), tag1)
+				So(post.Get("Tags").(RecordSet).Ids(), ShouldResemble, []int64{tag2})
+				write(int64(5))
+				So(post.Get("Tags").(RecordSet).IsEmpty(), ShouldBeTrue)
+				So(func() { write(int64(0), int64(0), map[string]interface{}{"name": "New"}) }, ShouldPanic)
+			})
+			Convey("Other methods should be called by their snake case name", func() {
+				env.ExecuteKW("tag", "create_rated_tag", []interface{}{[]interface{}{}, "Rated XML Tag", 3.5}, nil)
+				So(tags.Search(tags.Model().Field("Name").Equals("Rated XML Tag")).Get("Rate"), ShouldEqual, 3.5)
+				So(func() { env.ExecuteKW("tag", "sudo", []interface{}{[]interface{}{}}, nil) }, ShouldPanic)
+				So(func() { env.ExecuteKW("res.unknown", "search", nil, nil) }, ShouldPanic)
+				So(func() { env.ExecuteKW("tag", "search", nil, map[string]interface{}{"unknown": 1}) }, ShouldPanic)
+			})
+		}), ShouldBeNil)
+	})
+}
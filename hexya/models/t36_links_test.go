@@ -0,0 +1,130 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hexya-erp/hexya/hexya/models/security"
+	"github.com/hexya-erp/hexya/hexya/models/types/dates"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRecordRef(t *testing.T) {
+	Convey("Testing record references serialization", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			users := env.Pool("User").Search(env.Pool("User").Model().Field("Name").In([]string{"Jane A. Smith", "John Smith"}))
+			So(users.Len(), ShouldEqual, 2)
+			ref := users.WithContext("lang", "fr_FR").Ref()
+			So(ref.Model, ShouldEqual, "User")
+			So(ref.IDs, ShouldResemble, users.Ids())
+			data, err := json.Marshal(ref)
+			So(err, ShouldBeNil)
+			var newRef RecordRef
+			So(json.Unmarshal(data, &newRef), ShouldBeNil)
+			Convey("Rehydrating should give back the same records and context", func() {
+				rehydrated := newRef.Rehydrate(env)
+				So(rehydrated.ModelName(), ShouldEqual, "User")
+				So(rehydrated.Ids(), ShouldResemble, users.Ids())
+				So(rehydrated.Env().Context().Lang(), ShouldEqual, "fr_FR")
+				So(rehydrated.Env().Uid(), ShouldEqual, env.Uid())
+			})
+			Convey("Deleted records should be dropped when rehydrating", func() {
+				users.Records()[0].Call("Unlink")
+				rehydrated := newRef.Rehydrate(env)
+				So(rehydrated.Len(), ShouldEqual, 1)
+				So(rehydrated.Get("ID"), ShouldEqual, users.Ids()[1])
+			})
+		}), ShouldBeNil)
+	})
+}
+
+func TestRecordURL(t *testing.T) {
+	Convey("Testing deep links to records", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			users := env.Pool("User")
+			jane := users.Search(users.Model().Field("Email").Equals("jane.smith@example.com"))
+			Convey("Links should be relative to the server without base URL", func() {
+				So(jane.URL(), ShouldEqual, fmt.Sprintf("/web#id=%d&model=User&view_type=form", jane.Ids()[0]))
+			})
+			Convey("Links should use the base URL and the action of the model", func() {
+				SetBaseURL("https://erp.example.com/")
+				SetRecordActionResolver(func(modelName string) string {
+					if modelName == "User" {
+						return "action_users"
+					}
+					return ""
+				})
+				defer SetBaseURL("")
+				defer SetRecordActionResolver(nil)
+				So(jane.URL(), ShouldEqual, fmt.Sprintf("https://erp.example.com/web#action=action_users&id=%d&model=User&view_type=form", jane.Ids()[0]))
+				So(RecordURL("Tag", 5), ShouldEqual, "https://erp.example.com/web#id=5&model=Tag&view_type=form")
+				So(jane.Evaluate("url()"), ShouldEqual, jane.URL())
+			})
+			Convey("Links should only be given for single records of existing models", func() {
+				So(func() { users.SearchAll().URL() }, ShouldPanic)
+				So(func() { RecordURL("UnknownModel", 1) }, ShouldPanic)
+			})
+		}), ShouldBeNil)
+	})
+}
+
+func TestShares(t *testing.T) {
+	Convey("Testing record share links", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			note := env.Pool("Note").Call("Create", FieldMap{"Title": "Quote"}).(RecordSet).Collection()
+			Convey("Read-only shares should give the shared fields of the record", func() {
+				share := note.Share(ShareRead, "Title")
+				token := share.Call("Token").(string)
+				So(share.Call("URL"), ShouldEqual, "/share/"+token)
+				shared, ok := env.ReadSharedRecord(token)
+				So(ok, ShouldBeTrue)
+				So(shared.Model, ShouldEqual, "Note")
+				So(shared.ID, ShouldEqual, note.Ids()[0])
+				So(shared.Record, ShouldResemble, FieldMap{"id": note.Ids()[0], "Title": "Quote"})
+				So(shared.Messages, ShouldBeEmpty)
+				So(share.Get("AccessCount"), ShouldEqual, int64(1))
+				_, ok = env.CommentSharedRecord(token, "John", "Looks good")
+				So(ok, ShouldBeFalse)
+			})
+			Convey("Comment shares should allow to post and read comments", func() {
+				token := note.Share(ShareComment).Call("Token").(string)
+				message, ok := env.CommentSharedRecord(token, "John", "Looks good")
+				So(ok, ShouldBeTrue)
+				So(message.Get("Body"), ShouldEqual, "John: Looks good")
+				So(message.Get("AuthorUID"), ShouldEqual, int64(0))
+				shared, ok := env.ReadSharedRecord(token)
+				So(ok, ShouldBeTrue)
+				So(shared.Messages, ShouldHaveLength, 1)
+				So(shared.Messages[0]["Body"], ShouldEqual, "John: Looks good")
+			})
+			Convey("Tampered, expired and revoked tokens should be rejected", func() {
+				share := note.Share(ShareRead)
+				token := share.Call("Token").(string)
+				_, ok := env.ReadSharedRecord(token + "0")
+				So(ok, ShouldBeFalse)
+				_, ok = env.ReadSharedRecord(strings.Replace(token, ".", "0.", 1))
+				So(ok, ShouldBeFalse)
+				_, ok = env.ReadSharedRecord("invalid")
+				So(ok, ShouldBeFalse)
+				share.Call("Revoke")
+				_, ok = env.ReadSharedRecord(token)
+				So(ok, ShouldBeFalse)
+				expired := note.Share(ShareRead)
+				expired.Set("ExpirationDate", dates.Now().Add(-time.Hour))
+				_, ok = env.ReadSharedRecord(expired.Call("Token").(string))
+				So(ok, ShouldBeFalse)
+			})
+			Convey("Shares should check their parameters", func() {
+				So(func() { note.Share("write") }, ShouldPanic)
+				So(func() { note.Share(ShareRead, "UnknownField") }, ShouldPanic)
+				So(func() { env.Pool("Note").SearchAll().Share(ShareRead) }, ShouldPanic)
+			})
+		}), ShouldBeNil)
+	})
+}
@@ -0,0 +1,219 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hexya-erp/hexya/hexya/models/security"
+	"github.com/hexya-erp/hexya/hexya/models/types/dates"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSessions(t *testing.T) {
+	Convey("Testing HTTP sessions storage", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			So(env.LoadSession("unknown"), ShouldBeNil)
+			env.SaveSession("sid1", []byte("data"), time.Hour)
+			So(env.LoadSession("sid1"), ShouldResemble, []byte("data"))
+			env.SaveSession("sid1", []byte("new data"), time.Hour)
+			So(env.LoadSession("sid1"), ShouldResemble, []byte("new data"))
+			Convey("Expired sessions should not be loaded and should be deleted", func() {
+				env.SaveSession("sid2", []byte("old"), -time.Minute)
+				So(env.LoadSession("sid2"), ShouldBeNil)
+				So(env.DeleteExpiredSessions(), ShouldEqual, 1)
+				So(env.LoadSession("sid1"), ShouldResemble, []byte("new data"))
+			})
+			Convey("Deleted sessions should not be loaded", func() {
+				env.DeleteSession("sid1")
+				So(env.LoadSession("sid1"), ShouldBeNil)
+				env.DeleteSession("sid1")
+			})
+		}), ShouldBeNil)
+	})
+}
+
+func TestUserPasswords(t *testing.T) {
+	Convey("Testing user passwords", t, func() {
+		UserLoginField = "Name"
+		defer func() { UserLoginField = "Login" }()
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			users := env.Pool("User")
+			jane := users.Search(users.Model().Field("Email").Equals("jane.smith@example.com"))
+			login := jane.Get("Name").(string)
+			_, err := env.CheckUserPassword(login, "secret")
+			So(err, ShouldHaveSameTypeAs, security.UserNotFoundError(""))
+			env.SetPassword(jane.Ids()[0], "secret")
+			So(env.userCredential(jane.Ids()[0], false).Get("PasswordHash"), ShouldStartWith, "$argon2id$")
+			Convey("Users should be authenticated with their password", func() {
+				uid, err := env.CheckUserPassword(login, "secret")
+				So(err, ShouldBeNil)
+				So(uid, ShouldEqual, jane.Ids()[0])
+				_, err = env.CheckUserPassword(login, "wrong")
+				So(err, ShouldHaveSameTypeAs, security.InvalidCredentialsError(""))
+				_, err = env.CheckUserPassword("unknown", "secret")
+				So(err, ShouldHaveSameTypeAs, security.UserNotFoundError(""))
+			})
+			Convey("Users should be locked out after too many failed attempts", func() {
+				for i := int64(0); i < MaxLoginAttempts; i++ {
+					env.CheckUserPassword(login, "wrong")
+				}
+				_, err := env.CheckUserPassword(login, "secret")
+				So(err, ShouldNotBeNil)
+				cred := env.userCredential(jane.Ids()[0], false)
+				So(cred.Get("LockCount"), ShouldEqual, int64(1))
+				cred.Set("LockedUntil", dates.Now().Add(-time.Second))
+				uid, err := env.CheckUserPassword(login, "secret")
+				So(err, ShouldBeNil)
+				So(uid, ShouldEqual, jane.Ids()[0])
+				So(cred.Get("LockCount"), ShouldEqual, int64(0))
+			})
+			Convey("Lock durations should double up to the maximum duration", func() {
+				So(loginLockDuration(0), ShouldEqual, LoginLockDuration)
+				So(loginLockDuration(1), ShouldEqual, 2*LoginLockDuration)
+				So(loginLockDuration(3), ShouldEqual, 8*LoginLockDuration)
+				So(loginLockDuration(20), ShouldEqual, MaxLoginLockDuration)
+				So(loginLockDuration(100), ShouldEqual, MaxLoginLockDuration)
+			})
+			Convey("Passwords should be reset with the token sent by mail", func() {
+				token := env.RequestPasswordReset(login)
+				So(token, ShouldNotBeEmpty)
+				mails := env.Pool("Mail").Search(env.Pool("Mail").Model().Field("ResModel").Equals("User").
+					And().Field("ResID").Equals(jane.Ids()[0]))
+				So(mails.Len(), ShouldEqual, 1)
+				So(mails.Get("EmailTo"), ShouldEqual, "jane.smith@example.com")
+				So(mails.Get("Body"), ShouldContainSubstring, PasswordResetURL(token))
+				So(env.ResetPassword("invalid", "new secret"), ShouldBeFalse)
+				So(env.ResetPassword(token, "new secret"), ShouldBeTrue)
+				So(env.ResetPassword(token, "other secret"), ShouldBeFalse)
+				_, err := env.CheckUserPassword(login, "new secret")
+				So(err, ShouldBeNil)
+				So(env.RequestPasswordReset("unknown"), ShouldBeEmpty)
+			})
+		}), ShouldBeNil)
+	})
+}
+
+func TestAPIKeys(t *testing.T) {
+	Convey("Testing API keys", t, func() {
+		So(SimulateInNewEnvironment(2, func(env Environment) {
+			key := env.CreateAPIKey("Integration", dates.DateTime{}, "Tag", "User.Read")
+			So(key, ShouldStartWith, APIKeyPrefix)
+			So(env.APIKeys().Len(), ShouldEqual, 1)
+			So(env.APIKeys().Get("KeyHash"), ShouldNotContainSubstring, strings.TrimPrefix(key, APIKeyPrefix))
+			Convey("Keys should authenticate their user with their scopes", func() {
+				uid, scopes, ok := env.CheckAPIKey(key)
+				So(ok, ShouldBeTrue)
+				So(uid, ShouldEqual, 2)
+				So(scopes, ShouldResemble, APIScopes{"Tag", "User.Read"})
+				So(env.APIKeys().Get("LastUsed").(dates.DateTime).IsZero(), ShouldBeFalse)
+				_, _, ok = env.CheckAPIKey(key + "0")
+				So(ok, ShouldBeFalse)
+				_, _, ok = env.CheckAPIKey(strings.TrimPrefix(key, APIKeyPrefix))
+				So(ok, ShouldBeFalse)
+			})
+			Convey("Scopes should restrict the callable methods", func() {
+				So(APIScopes(nil).Allows("User", "Write"), ShouldBeTrue)
+				So(APIScopes{"*"}.Allows("User", "Write"), ShouldBeTrue)
+				scopes := APIScopes{"Tag", "User.SearchRead"}
+				So(scopes.Allows("Tag", "Unlink"), ShouldBeTrue)
+				So(scopes.Allows("User", "search_read"), ShouldBeTrue)
+				So(scopes.Allows("User", "Write"), ShouldBeFalse)
+				So(scopes.Allows("Profile", "Read"), ShouldBeFalse)
+				scopedEnv := env.Pool("User").Sudo().Env().WithAPIScopes(scopes)
+				So(func() { scopedEnv.ExecuteKW("tag", "search_count", []interface{}{[]interface{}{}}, nil) }, ShouldNotPanic)
+				So(func() { scopedEnv.ExecuteKW("user", "unlink", []interface{}{[]interface{}{int64(-1)}}, nil) }, ShouldPanic)
+				So(func() { scopedEnv.Pool("User").CallRPC("Write") }, ShouldPanic)
+			})
+			Convey("Revoked and expired keys should be refused", func() {
+				expired := env.CreateAPIKey("Expired", dates.Now().Add(-time.Hour))
+				_, _, ok := env.CheckAPIKey(expired)
+				So(ok, ShouldBeFalse)
+				env.RevokeAPIKey(env.APIKeys().Records()[1].Ids()[0])
+				_, _, ok = env.CheckAPIKey(key)
+				So(ok, ShouldBeFalse)
+				So(env.APIKeys().Len(), ShouldEqual, 1)
+				other := env.Pool("User").Sudo().Env()
+				otherKey := other.CreateAPIKey("Admin key", dates.DateTime{})
+				_, _, ok = env.CheckAPIKey(otherKey)
+				So(ok, ShouldBeTrue)
+				So(func() { env.RevokeAPIKey(other.APIKeys().Ids()[0]) }, ShouldPanic)
+			})
+		}), ShouldBeNil)
+	})
+}
+
+func TestLDAPAuthentication(t *testing.T) {
+	Convey("Testing LDAP authentication", t, func() {
+		UserLoginField = "Name"
+		defer func() { UserLoginField = "Login" }()
+		managers := security.Registry.NewGroup("ldap_managers", "LDAP Managers")
+		defer security.Registry.UnregisterGroup(managers)
+		var lookups []string
+		ldapLookup = func(conf ldapServerConfig, login, password string) (ldapEntry, error) {
+			lookups = append(lookups, conf.host)
+			switch {
+			case conf.host == "down.example.com":
+				return ldapEntry{}, errors.New("connection refused")
+			case login != "Jane A. Smith":
+				return ldapEntry{}, errLDAPUserNotFound
+			case password != "secret":
+				return ldapEntry{}, errLDAPInvalidCredentials
+			}
+			return ldapEntry{
+				dn:     "uid=jane,ou=people,dc=example,dc=com",
+				groups: []string{"CN=Managers,OU=Groups,DC=example,DC=com"},
+			}, nil
+		}
+		defer func() { ldapLookup = lookupLDAPUser }()
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			users := env.Pool("User")
+			janeID := users.Search(users.Model().Field("Email").Equals("jane.smith@example.com")).Ids()[0]
+			_, err := env.LDAPAuthenticate("Jane A. Smith", "secret")
+			So(err, ShouldHaveSameTypeAs, security.UserNotFoundError(""))
+			servers := env.Pool("LDAPServer")
+			servers.Call("Create", FieldMap{"Name": "Down", "Host": "down.example.com", "BaseDN": "dc=example,dc=com",
+				"Sequence": int64(1)})
+			server := servers.Call("Create", FieldMap{"Name": "Directory", "Host": "ldap.example.com",
+				"BaseDN": "dc=example,dc=com"}).(RecordSet).Collection()
+			env.Pool("LDAPGroupMapping").Call("Create", FieldMap{"Server": server,
+				"LDAPGroup": "cn=managers,ou=groups,dc=example,dc=com", "GroupID": "ldap_managers"})
+			Convey("Users should be authenticated by the LDAP servers", func() {
+				uid, err := env.LDAPAuthenticate("Jane A. Smith", "secret")
+				So(err, ShouldBeNil)
+				So(uid, ShouldEqual, janeID)
+				So(lookups, ShouldResemble, []string{"down.example.com", "ldap.example.com"})
+				_, err = env.LDAPAuthenticate("Jane A. Smith", "wrong")
+				So(err, ShouldHaveSameTypeAs, security.InvalidCredentialsError(""))
+				_, err = env.LDAPAuthenticate("Jane A. Smith", "")
+				So(err, ShouldHaveSameTypeAs, security.UserNotFoundError(""))
+				_, err = env.LDAPAuthenticate("Nobody", "secret")
+				So(err, ShouldHaveSameTypeAs, security.UserNotFoundError(""))
+			})
+			Convey("Mapped groups should be synchronized at login", func() {
+				So(security.Registry.HasMembership(janeID, managers), ShouldBeFalse)
+				env.LDAPAuthenticate("Jane A. Smith", "secret")
+				So(security.Registry.HasMembership(janeID, managers), ShouldBeTrue)
+				server.syncLDAPGroups(janeID, []string{"cn=others,dc=example,dc=com"})
+				So(security.Registry.HasMembership(janeID, managers), ShouldBeFalse)
+			})
+			Convey("Mapped groups should be recorded and loaded at startup", func() {
+				memberships := env.Pool("LDAPMembership")
+				env.LDAPAuthenticate("Jane A. Smith", "secret")
+				So(memberships.SearchAll().Len(), ShouldEqual, 1)
+				So(memberships.SearchAll().Get("GroupID"), ShouldEqual, "ldap_managers")
+				security.Registry.RemoveMembership(janeID, managers)
+				env.loadLDAPMemberships()
+				So(security.Registry.HasMembership(janeID, managers), ShouldBeTrue)
+				server.Get("GroupMappings").(RecordSet).Collection().Call("Unlink")
+				env.LDAPAuthenticate("Jane A. Smith", "secret")
+				So(security.Registry.HasMembership(janeID, managers), ShouldBeFalse)
+				So(memberships.SearchAll().IsEmpty(), ShouldBeTrue)
+			})
+		}), ShouldBeNil)
+	})
+}
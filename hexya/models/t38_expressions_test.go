@@ -0,0 +1,52 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"testing"
+
+	"github.com/hexya-erp/hexya/hexya/models/security"
+	"github.com/hexya-erp/hexya/hexya/models/types/dates"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestEvaluate(t *testing.T) {
+	Convey("Testing expressions evaluation on records", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			profile := env.Pool("Profile").Call("Create", FieldMap{"City": "Paris", "Money": 12.5}).(RecordSet).Collection()
+			user := env.Pool("User").Call("Create", FieldMap{
+				"Name":    "Eve Smith",
+				"Email":   "eve.smith@example.com",
+				"Nums":    2,
+				"Profile": profile,
+			}).(RecordSet).Collection()
+			Convey("Fields and relation paths should be available", func() {
+				So(user.Evaluate("record.Name"), ShouldEqual, "Eve Smith")
+				So(user.Evaluate("record.Profile.City + ' (' + str(record.Profile.Money * 2) + ')'"), ShouldEqual, "Paris (25)")
+				So(user.Evaluate("record.Profile").(*RecordCollection).Equals(profile), ShouldBeTrue)
+				So(user.EvaluateCondition("record.Nums > 1 and record.Profile.City in ['Paris', 'Lyon']"), ShouldBeTrue)
+				So(user.EvaluateCondition("record.Profile.BestPost"), ShouldBeFalse)
+			})
+			Convey("Environment variables should be available", func() {
+				So(user.Evaluate("uid"), ShouldEqual, security.SuperUserID)
+				So(user.WithContext("lang", "fr_FR").Evaluate("context.lang"), ShouldEqual, "fr_FR")
+				So(user.Evaluate("today()"), ShouldResemble, dates.Today())
+			})
+			Convey("ref should return records by external ID", func() {
+				tag1 := env.Pool("Tag").Call("Create", FieldMap{"Name": "Eval1"}).(RecordSet).Collection()
+				tag2 := env.Pool("Tag").Call("Create", FieldMap{"Name": "Eval2"}).(RecordSet).Collection()
+				registerExternalID(env, "testdata.tag_eval1", tag1, false)
+				registerExternalID(env, "testdata.tag_eval2", tag2, false)
+				tags := user.Evaluate("[ref('testdata.tag_eval1'), ref('testdata.tag_eval2')]").(*RecordCollection)
+				So(tags.ModelName(), ShouldEqual, "Tag")
+				So(tags.Len(), ShouldEqual, 2)
+				So(func() { user.Evaluate("ref('testdata.unknown')") }, ShouldPanic)
+			})
+			Convey("Invalid expressions should panic", func() {
+				So(func() { user.Evaluate("record.Unknown") }, ShouldPanic)
+				So(func() { user.Evaluate("record.Name +") }, ShouldPanic)
+			})
+		}), ShouldBeNil)
+	})
+}
@@ -0,0 +1,47 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/hexya-erp/hexya/hexya/models/security"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestFactory(t *testing.T) {
+	Convey("Testing record factories", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			Convey("Generated records should respect required fields and relations", func() {
+				userIDs := env.Pool("User").SearchAll().Ids()
+				posts := NewFactory(Registry.MustGet("Post"), 1).Create(env, 20)
+				So(posts.Len(), ShouldEqual, 20)
+				for _, post := range posts.Records() {
+					So(post.Get("Title"), ShouldNotBeBlank)
+					So(post.Get("Content"), ShouldStartWith, "<p>")
+					if user := post.Get("User").(RecordSet).Collection(); !user.IsEmpty() {
+						So(userIDs, ShouldContain, user.ids[0])
+					}
+				}
+			})
+			Convey("Same seeds should give the same values", func() {
+				data1 := NewFactory(Registry.MustGet("Post"), 42).Data(env)
+				data2 := NewFactory(Registry.MustGet("Post"), 42).Data(env)
+				So(data1, ShouldResemble, data2)
+			})
+			Convey("Related records should be created for required relations", func() {
+				users := NewFactory(Registry.MustGet("User"), 1).
+					Set("IsPremium", func(env Environment, seq int) interface{} { return false }).
+					Set("Name", func(env Environment, seq int) interface{} { return fmt.Sprintf("Load Test User %d", seq) }).
+					Create(env, 5)
+				So(users.Len(), ShouldEqual, 5)
+				for _, user := range users.Records() {
+					So(user.Get("Profile").(RecordSet).IsEmpty(), ShouldBeFalse)
+					So(user.Get("Name"), ShouldStartWith, "Load Test User")
+				}
+			})
+		}), ShouldBeNil)
+	})
+}
@@ -0,0 +1,106 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hexya-erp/hexya/hexya/tracing"
+	"github.com/jtolds/gls"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// A traceState holds the context of the current span of the ORM in a
+// goroutine. It is stored in the goroutine local storage so that spans
+// are nested along the method layer stack without threading a context
+// through the API.
+type traceState struct {
+	ctx context.Context
+}
+
+// WithTraceContext executes fnct with the span of ctx as the current span of
+// the ORM, so that the environments, method calls and queries of fnct are
+// traced as its children.
+//
+// This function is meant to be called by controllers with the context of the
+// request they serve.
+func WithTraceContext(ctx context.Context, fnct func()) {
+	ctxManager.SetValues(gls.Values{"trace": &traceState{ctx: ctx}}, fnct)
+}
+
+// TraceContext returns a context holding the current span of the ORM. It
+// returns context.Background() if there is none.
+func TraceContext() context.Context {
+	if state := currentTraceState(); state != nil {
+		return state.ctx
+	}
+	return context.Background()
+}
+
+// currentTraceState returns the traceState of the current goroutine,
+// or nil if WithTraceContext has not been called.
+func currentTraceState() *traceState {
+	state, ok := ctxManager.GetValue("trace")
+	if !ok {
+		return nil
+	}
+	return state.(*traceState)
+}
+
+// startSpan starts a span with the given name and attributes as a child
+// of the current span and makes it the current span. The returned function
+// must be called to end the span and restore the previous current span.
+func startSpan(name string, attrs ...attribute.KeyValue) (trace.Span, func()) {
+	state := currentTraceState()
+	if state == nil {
+		_, span := tracing.Tracer().Start(context.Background(), name, trace.WithAttributes(attrs...))
+		return span, func() { span.End() }
+	}
+	parent := state.ctx
+	ctx, span := tracing.Tracer().Start(parent, name, trace.WithAttributes(attrs...))
+	state.ctx = ctx
+	return span, func() {
+		state.ctx = parent
+		span.End()
+	}
+}
+
+// startOperationSpan starts a span for the given CRUD operation on this
+// RecordCollection, as startSpan.
+func (rc *RecordCollection) startOperationSpan(operation string) (trace.Span, func()) {
+	return startSpan(rc.model.name+"."+operation,
+		tracing.ModelKey.String(rc.model.name),
+		tracing.RecordsKey.Int(len(rc.ids)))
+}
+
+// traceQuery adds a span for the given SQL query started at start and that
+// lasted duration, as a child of the current span, if it is recorded.
+func traceQuery(query string, start time.Time, duration time.Duration, method string) {
+	parent := TraceContext()
+	if !trace.SpanFromContext(parent).IsRecording() {
+		return
+	}
+	_, span := tracing.Tracer().Start(parent, "SQL", trace.WithTimestamp(start),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			tracing.DBSystemKey.String(db.DriverName()),
+			tracing.Statement(query),
+			tracing.MethodKey.String(method)))
+	span.End(trace.WithTimestamp(start.Add(duration)))
+}
+
+// recordSpanError records the given recovered panic value on the span and
+// marks it as failed.
+func recordSpanError(span trace.Span, r interface{}) {
+	err, ok := r.(error)
+	if !ok {
+		err = fmt.Errorf("%v", r)
+	}
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
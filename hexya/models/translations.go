@@ -0,0 +1,152 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"fmt"
+
+	"github.com/hexya-erp/hexya/hexya/models/fieldtype"
+)
+
+// DefaultLang is the language of the values stored in the tables of the
+// models for translatable fields. The values in other languages are stored
+// in the FieldTranslation model.
+var DefaultLang = "en_US"
+
+// A translationRef is the key of a field translation in the cache
+type translationRef struct {
+	model *Model
+	id    int64
+	field string
+	lang  string
+}
+
+// A cachedTranslation is a field translation in the cache.
+// exists is false if the field has no translation.
+type cachedTranslation struct {
+	value  string
+	exists bool
+}
+
+// declareFieldTranslationModel creates the FieldTranslation system
+// model which stores the values of translatable fields in each language.
+func declareFieldTranslationModel() {
+	fieldTranslation := createModel("FieldTranslation", SystemModel)
+	fieldTranslation.InheritModel(Registry.MustGet("CommonMixin"))
+	fieldTranslation.AddFields(map[string]FieldDefinition{
+		"Model": CharField{Required: true, Index: true},
+		"Field": CharField{Required: true},
+		"ResID": IntegerField{String: "Record ID", BigInt: true, Required: true, Index: true},
+		"Lang":  CharField{String: "Language", Required: true},
+		"Value": TextField{},
+	})
+	fieldTranslation.AddSQLConstraint("unique_translation", "UNIQUE (model, field, res_id, lang)",
+		"A field can only have one translation per language")
+}
+
+// isTranslatable returns true if the values of this field are translated
+func (f *Field) isTranslatable() bool {
+	if !f.translate || !f.isStored() || f.isComputedField() || f.isRelatedField() {
+		return false
+	}
+	switch f.fieldType {
+	case fieldtype.Char, fieldtype.Text, fieldtype.HTML:
+		return true
+	}
+	return false
+}
+
+// translationLang returns the language in which the translatable fields of
+// this RecordCollection are read and written, or the empty string if the
+// values stored in the model's table must be used.
+func (rc *RecordCollection) translationLang() string {
+	lang := rc.env.context.Lang()
+	if lang == DefaultLang {
+		return ""
+	}
+	return lang
+}
+
+// getTranslation returns the translation of the given field for the first record
+// of this RecordCollection in the given language. The second returned value is
+// false if the field has no translation in this language.
+//
+// Translations are loaded for all the records of the prefetch RecordCollection.
+func (rc *RecordCollection) getTranslation(fi *Field, lang string) (string, bool) {
+	ref := translationRef{model: rc.model, id: rc.ids[0], field: fi.json, lang: lang}
+	tr, ok := rc.env.cache.translations[ref]
+	if !ok {
+		ids := rc.ids
+		if rc.prefetchRC != nil && len(rc.prefetchRC.ids) > 0 {
+			ids = rc.prefetchRC.ids
+		}
+		rc.loadTranslations(fi, lang, ids)
+		tr = rc.env.cache.translations[ref]
+	}
+	return tr.value, tr.exists
+}
+
+// loadTranslations loads the translations of the given field for the
+// given ids in the given language into the cache.
+func (rc *RecordCollection) loadTranslations(fi *Field, lang string, ids []int64) {
+	var rows []struct {
+		ResID int64
+		Value string
+	}
+	query := fmt.Sprintf(`SELECT res_id, COALESCE(value, '') AS value FROM %s WHERE model = ? AND field = ? AND lang = ? AND res_id IN (?)`,
+		adapters[db.DriverName()].quoteTableName(Registry.MustGet("FieldTranslation").tableName))
+	rc.env.cr.Select(&rows, query, rc.model.name, fi.json, lang, ids)
+	for _, id := range ids {
+		rc.env.cache.translations[translationRef{model: rc.model, id: id, field: fi.json, lang: lang}] = cachedTranslation{}
+	}
+	for _, row := range rows {
+		ref := translationRef{model: rc.model, id: row.ResID, field: fi.json, lang: lang}
+		rc.env.cache.translations[ref] = cachedTranslation{value: row.Value, exists: true}
+	}
+}
+
+// writeTranslations writes the values of the translatable fields of fMap as
+// translations in the given language for all the records of this RecordCollection.
+// These fields are removed from fMap so that the stored values are not modified.
+func (rc *RecordCollection) writeTranslations(fMap FieldMap, lang string) {
+	query := fmt.Sprintf(`INSERT INTO %s (model, field, res_id, lang, value) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (model, field, res_id, lang) DO UPDATE SET value = EXCLUDED.value`,
+		adapters[db.DriverName()].quoteTableName(Registry.MustGet("FieldTranslation").tableName))
+	for field, value := range fMap {
+		fi, ok := rc.model.fields.Get(field)
+		if !ok || !fi.isTranslatable() {
+			continue
+		}
+		strValue, _ := value.(string)
+		for _, id := range rc.Ids() {
+			rc.env.cr.Execute(query, rc.model.name, fi.json, id, lang, strValue)
+			ref := translationRef{model: rc.model, id: id, field: fi.json, lang: lang}
+			rc.env.cache.translations[ref] = cachedTranslation{value: strValue, exists: true}
+		}
+		delete(fMap, field)
+	}
+}
+
+// deleteTranslations deletes the translations of the records with the given
+// ids of this RecordCollection's model, if the model has translatable fields.
+func (rc *RecordCollection) deleteTranslations(ids []int64) {
+	var translatable bool
+	for _, fi := range rc.model.fields.registryByJSON {
+		if fi.isTranslatable() {
+			translatable = true
+			break
+		}
+	}
+	if !translatable || len(ids) == 0 {
+		return
+	}
+	query := fmt.Sprintf(`DELETE FROM %s WHERE model = ? AND res_id IN (?)`,
+		adapters[db.DriverName()].quoteTableName(Registry.MustGet("FieldTranslation").tableName))
+	rc.env.cr.Execute(query, rc.model.name, ids)
+	for ref := range rc.env.cache.translations {
+		if ref.model == rc.model {
+			delete(rc.env.cache.translations, ref)
+		}
+	}
+}
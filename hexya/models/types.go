@@ -67,7 +67,7 @@ func (fm *FieldMap) RemovePKIfZero() {
 // JSONized returns a new field map identical to this one but
 // with all its keys switched to the JSON name of the fields
 func (fm *FieldMap) JSONized(model *Model) FieldMap {
-	res := make(FieldMap)
+	res := make(FieldMap, len(*fm))
 	for f, v := range *fm {
 		jsonFieldName := model.JSONizeFieldName(f)
 		res[jsonFieldName] = v
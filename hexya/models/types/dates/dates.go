@@ -18,7 +18,52 @@ const (
 	DefaultServerDateTimeFormat = "2006-01-02 15:04:05"
 )
 
+// A Period is a calendar unit by which Date and DateTime
+// values can be shifted with AddPeriod.
+type Period string
+
+// Available periods
+const (
+	PeriodDay     Period = "day"
+	PeriodWeek    Period = "week"
+	PeriodMonth   Period = "month"
+	PeriodQuarter Period = "quarter"
+	PeriodYear    Period = "year"
+)
+
+// addTo returns t shifted by count times this Period.
+//
+// Contrary to time.Time.AddDate, months are not normalized when shifting
+// by months, quarters or years: the day is clamped to the last day of the
+// target month instead (e.g. January 31st + 1 month is February 28th or 29th).
+func (p Period) addTo(t time.Time, count int) time.Time {
+	var months int
+	switch p {
+	case PeriodDay:
+		return t.AddDate(0, 0, count)
+	case PeriodWeek:
+		return t.AddDate(0, 0, 7*count)
+	case PeriodMonth:
+		months = count
+	case PeriodQuarter:
+		months = 3 * count
+	case PeriodYear:
+		months = 12 * count
+	default:
+		panic(fmt.Errorf("unknown period %s", p))
+	}
+	year, month, day := t.Date()
+	target := time.Date(year, month+time.Month(months), 1, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+	if lastDay := target.AddDate(0, 1, -1).Day(); day > lastDay {
+		day = lastDay
+	}
+	return target.AddDate(0, 0, day-1)
+}
+
 // Date type that JSON marshal and unmarshals as "YYYY-MM-DD"
+//
+// Dates have no time zone: a Date is the day given by its year,
+// month and day, whatever the location of its time.Time.
 type Date struct {
 	time.Time
 }
@@ -52,7 +97,7 @@ func (d Date) Value() (driver.Value, error) {
 func (d *Date) Scan(src interface{}) error {
 	switch t := src.(type) {
 	case time.Time:
-		d.Time = t
+		d.Time = dayOf(t)
 		return nil
 	case string:
 		val, err := ParseDate(DefaultServerDateFormat, t)
@@ -75,22 +120,22 @@ func (d Date) Equal(other Date) bool {
 
 // Greater returns true if d is strictly greater than other
 func (d Date) Greater(other Date) bool {
-	return d.Sub(other.Time) > 0
+	return dayOf(d.Time).Sub(dayOf(other.Time)) > 0
 }
 
 // GreaterEqual returns true if d is greater than or equal to other
 func (d Date) GreaterEqual(other Date) bool {
-	return d.Sub(other.Time) >= 0
+	return dayOf(d.Time).Sub(dayOf(other.Time)) >= 0
 }
 
 // Lower returns true if d is strictly lower than other
 func (d Date) Lower(other Date) bool {
-	return d.Sub(other.Time) < 0
+	return dayOf(d.Time).Sub(dayOf(other.Time)) < 0
 }
 
 // LowerEqual returns true if d is lower than or equal to other
 func (d Date) LowerEqual(other Date) bool {
-	return d.Sub(other.Time) <= 0
+	return dayOf(d.Time).Sub(dayOf(other.Time)) <= 0
 }
 
 // AddDate adds the given year, month or days to the current date
@@ -100,9 +145,36 @@ func (d Date) AddDate(year, month, day int) Date {
 	}
 }
 
-// Today returns the current date
+// AddPeriod returns this Date shifted by count times the given period.
+// count may be negative. The day is clamped to the last day of the
+// month when shifting by months, quarters or years.
+func (d Date) AddPeriod(period Period, count int) Date {
+	return Date{
+		Time: period.addTo(d.Time, count),
+	}
+}
+
+// ToDateTime returns the DateTime of the beginning of this
+// Date in the given location.
+func (d Date) ToDateTime(loc *time.Location) DateTime {
+	year, month, day := d.Date()
+	return DateTime{time.Date(year, month, day, 0, 0, 0, 0, loc)}
+}
+
+// Today returns the current date in UTC
 func Today() Date {
-	return Date{time.Now()}
+	return TodayIn(time.UTC)
+}
+
+// TodayIn returns the current date in the given location
+func TodayIn(loc *time.Location) Date {
+	return Date{dayOf(time.Now().In(loc))}
+}
+
+// dayOf returns the midnight UTC time of the day of t in its location.
+func dayOf(t time.Time) time.Time {
+	year, month, day := t.Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
 }
 
 // ParseDate returns a date from the given string value
@@ -113,6 +185,10 @@ func ParseDate(layout, value string) (Date, error) {
 }
 
 // DateTime type that JSON marshals and unmarshals as "YYYY-MM-DD HH:MM:SS"
+//
+// DateTime values are stored in the database and marshaled in UTC,
+// whatever the location of their time.Time. Use In to get the same
+// instant in another time zone.
 type DateTime struct {
 	time.Time
 }
@@ -123,9 +199,19 @@ func (d DateTime) String() string {
 	return strings.Trim(string(bs), "\"")
 }
 
-// ToDate returns the Date of this DateTime
+// ToDate returns the Date of this DateTime in its location.
+// Use In first to get the Date in a given time zone.
 func (d DateTime) ToDate() Date {
-	return Date{d.Time}
+	return Date{dayOf(d.Time)}
+}
+
+// In returns this DateTime with its location set to loc.
+// Both DateTime represent the same time instant.
+func (d DateTime) In(loc *time.Location) DateTime {
+	if d.IsZero() {
+		return d
+	}
+	return DateTime{d.Time.In(loc)}
 }
 
 // MarshalJSON for DateTime type
@@ -133,7 +219,7 @@ func (d DateTime) MarshalJSON() ([]byte, error) {
 	if d.IsZero() {
 		return []byte("false"), nil
 	}
-	dateStr := d.Time.Format(DefaultServerDateTimeFormat)
+	dateStr := d.Time.UTC().Format(DefaultServerDateTimeFormat)
 	dateStr = fmt.Sprintf(`"%s"`, dateStr)
 	return []byte(dateStr), nil
 }
@@ -144,14 +230,15 @@ func (d DateTime) Value() (driver.Value, error) {
 	if d.IsZero() {
 		return driver.Value(time.Time{}), nil
 	}
-	return driver.Value(d.Time), nil
+	return driver.Value(d.Time.UTC()), nil
 }
 
-// Scan casts the database output to a DateTime
+// Scan casts the database output to a DateTime.
+// Database values are considered to be in UTC.
 func (d *DateTime) Scan(src interface{}) error {
 	switch t := src.(type) {
 	case time.Time:
-		d.Time = t
+		d.Time = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
 		return nil
 	case string:
 		val, err := ParseDateTime(DefaultServerDateTimeFormat, t)
@@ -161,9 +248,9 @@ func (d *DateTime) Scan(src interface{}) error {
 	return fmt.Errorf("DateTime data is not time.Time but %T", src)
 }
 
-// Now returns the current date/time
+// Now returns the current date/time in UTC
 func Now() DateTime {
-	return DateTime{time.Now()}
+	return DateTime{time.Now().UTC()}
 }
 
 // ParseDateTime returns a datetime from the given string value
@@ -214,3 +301,14 @@ func (d DateTime) AddDate(year, month, day int) DateTime {
 		Time: d.Time.AddDate(year, month, day),
 	}
 }
+
+// AddPeriod returns this DateTime shifted by count times the given period.
+// count may be negative. Periods are calendar periods in the location of
+// this DateTime, so that the time of the day is kept across DST changes.
+// The day is clamped to the last day of the month when shifting by months,
+// quarters or years.
+func (d DateTime) AddPeriod(period Period, count int) DateTime {
+	return DateTime{
+		Time: period.addTo(d.Time, count),
+	}
+}
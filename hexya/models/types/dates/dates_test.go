@@ -128,5 +128,60 @@ func TestDates(t *testing.T) {
 			So(ok, ShouldBeTrue)
 			So(t.IsZero(), ShouldBeTrue)
 		})
+		Convey("Datetimes are stored and marshaled in UTC", func() {
+			paris, _ := time.LoadLocation("Europe/Paris")
+			local := dateTime.In(paris)
+			So(local.Hour(), ShouldEqual, 12)
+			So(local.Equal(dateTime), ShouldBeTrue)
+			So(local.String(), ShouldEqual, "2017-08-01 10:02:57")
+			val, _ := local.Value()
+			So(val.(time.Time).Location(), ShouldEqual, time.UTC)
+			So(val.(time.Time).Hour(), ShouldEqual, 10)
+			dtScan := &DateTime{}
+			dtScan.Scan(time.Date(2017, 8, 1, 10, 2, 57, 0, time.FixedZone("", 7200)))
+			So(dtScan.Equal(dateTime), ShouldBeTrue)
+			So(DateTime{}.In(paris).IsZero(), ShouldBeTrue)
+		})
+		Convey("Converting between dates and datetimes", func() {
+			tokyo, _ := time.LoadLocation("Asia/Tokyo")
+			late := dateTime.Add(15 * time.Hour)
+			So(late.ToDate().String(), ShouldEqual, "2017-08-02")
+			So(late.In(tokyo).ToDate().String(), ShouldEqual, "2017-08-02")
+			So(dateTime.In(time.FixedZone("", -12*3600)).ToDate().String(), ShouldEqual, "2017-07-31")
+			start := date.ToDateTime(tokyo)
+			So(start.String(), ShouldEqual, "2017-07-31 15:00:00")
+			So(start.In(tokyo).Hour(), ShouldEqual, 0)
+		})
+		Convey("Comparing dates ignores times", func() {
+			morning, _ := ParseDate(DefaultServerDateTimeFormat, "2017-08-01 08:00:00")
+			So(date.Greater(morning), ShouldBeFalse)
+			So(date.GreaterEqual(morning), ShouldBeTrue)
+			So(date.LowerEqual(morning), ShouldBeTrue)
+			So(date.Lower(date.AddDate(0, 0, 1)), ShouldBeTrue)
+		})
+		Convey("Adding periods", func() {
+			jan31, _ := ParseDate(DefaultServerDateFormat, "2016-01-31")
+			So(jan31.AddPeriod(PeriodDay, 1).String(), ShouldEqual, "2016-02-01")
+			So(jan31.AddPeriod(PeriodWeek, -1).String(), ShouldEqual, "2016-01-24")
+			So(jan31.AddPeriod(PeriodMonth, 1).String(), ShouldEqual, "2016-02-29")
+			So(jan31.AddPeriod(PeriodMonth, -2).String(), ShouldEqual, "2015-11-30")
+			So(jan31.AddPeriod(PeriodQuarter, 1).String(), ShouldEqual, "2016-04-30")
+			So(jan31.AddPeriod(PeriodYear, 1).String(), ShouldEqual, "2017-01-31")
+			feb29, _ := ParseDate(DefaultServerDateFormat, "2016-02-29")
+			So(feb29.AddPeriod(PeriodYear, 1).String(), ShouldEqual, "2017-02-28")
+			So(dateTime.AddPeriod(PeriodMonth, 1).String(), ShouldEqual, "2017-09-01 10:02:57")
+			paris, _ := time.LoadLocation("Europe/Paris")
+			summer := dateTime.In(paris).AddPeriod(PeriodMonth, 3)
+			So(summer.In(paris).Hour(), ShouldEqual, 12)
+			So(summer.String(), ShouldEqual, "2017-11-01 11:02:57")
+			So(func() { date.AddPeriod(Period("century"), 1) }, ShouldPanic)
+		})
+		Convey("Today and Now", func() {
+			So(Now().Location(), ShouldEqual, time.UTC)
+			So(Today().Equal(Now().ToDate()), ShouldBeTrue)
+			So(Today().Hour(), ShouldEqual, 0)
+			tokyo, _ := time.LoadLocation("Asia/Tokyo")
+			So(TodayIn(tokyo).Equal(Now().In(tokyo).ToDate()), ShouldBeTrue)
+		})
 	})
 }
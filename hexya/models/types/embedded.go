@@ -0,0 +1,219 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package types
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+
+	"github.com/hexya-erp/hexya/hexya/models/types/dates"
+)
+
+// An EmbeddedRecord is a sub-record of an EmbeddedList,
+// which maps the keys of its schema to values.
+type EmbeddedRecord map[string]interface{}
+
+// Copy returns a shallow copy of this EmbeddedRecord
+func (r EmbeddedRecord) Copy() EmbeddedRecord {
+	res := make(EmbeddedRecord, len(r))
+	for k, v := range r {
+		res[k] = v
+	}
+	return res
+}
+
+// GetString returns the value of the given key as a string,
+// or an empty string if this record has no such key.
+func (r EmbeddedRecord) GetString(key string) string {
+	switch val := r[key].(type) {
+	case nil:
+		return ""
+	case string:
+		return val
+	default:
+		return fmt.Sprint(val)
+	}
+}
+
+// GetInt returns the value of the given key as an int64,
+// or 0 if this record has no such key.
+//
+// Numbers of EmbeddedLists read from the database are
+// float64, as all JSON numbers.
+func (r EmbeddedRecord) GetInt(key string) int64 {
+	switch val := r[key].(type) {
+	case int64:
+		return val
+	case int:
+		return int64(val)
+	case float64:
+		return int64(val)
+	}
+	return 0
+}
+
+// GetFloat returns the value of the given key as a float64,
+// or 0 if this record has no such key.
+func (r EmbeddedRecord) GetFloat(key string) float64 {
+	switch val := r[key].(type) {
+	case float64:
+		return val
+	case int64:
+		return float64(val)
+	case int:
+		return float64(val)
+	}
+	return 0
+}
+
+// GetBool returns the value of the given key as a bool,
+// or false if this record has no such key.
+func (r EmbeddedRecord) GetBool(key string) bool {
+	val, _ := r[key].(bool)
+	return val
+}
+
+// GetDate returns the value of the given key as a Date,
+// or the zero Date if this record has no such key.
+func (r EmbeddedRecord) GetDate(key string) dates.Date {
+	switch val := r[key].(type) {
+	case dates.Date:
+		return val
+	case string:
+		res, _ := dates.ParseDate(dates.DefaultServerDateFormat, val)
+		return res
+	}
+	return dates.Date{}
+}
+
+// GetDateTime returns the value of the given key as a DateTime,
+// or the zero DateTime if this record has no such key.
+func (r EmbeddedRecord) GetDateTime(key string) dates.DateTime {
+	switch val := r[key].(type) {
+	case dates.DateTime:
+		return val
+	case string:
+		res, _ := dates.ParseDateTime(dates.DefaultServerDateTimeFormat, val)
+		return res
+	}
+	return dates.DateTime{}
+}
+
+// An EmbeddedList is the value of an EmbeddedList field: an ordered
+// list of small sub-records, stored in JSON in the database.
+//
+// EmbeddedLists should be treated as immutable values: methods that
+// modify the list return a new EmbeddedList which must be written back
+// to the field.
+type EmbeddedList []EmbeddedRecord
+
+// Len returns the number of records of this EmbeddedList
+func (l EmbeddedList) Len() int {
+	return len(l)
+}
+
+// Copy returns a copy of this EmbeddedList and of its records
+func (l EmbeddedList) Copy() EmbeddedList {
+	res := make(EmbeddedList, len(l))
+	for i, rec := range l {
+		res[i] = rec.Copy()
+	}
+	return res
+}
+
+// Append returns a copy of this EmbeddedList with the
+// given records added at the end.
+func (l EmbeddedList) Append(records ...EmbeddedRecord) EmbeddedList {
+	res := l.Copy()
+	for _, rec := range records {
+		res = append(res, rec.Copy())
+	}
+	return res
+}
+
+// Remove returns a copy of this EmbeddedList without the record
+// at the given index. It panics if the index is out of range.
+func (l EmbeddedList) Remove(index int) EmbeddedList {
+	if index < 0 || index >= len(l) {
+		log.Panic("Index out of range in EmbeddedList", "index", index, "length", len(l))
+	}
+	res := l.Copy()
+	return append(res[:index], res[index+1:]...)
+}
+
+// Filtered returns a copy of this EmbeddedList with only
+// the records for which the given function returns true.
+func (l EmbeddedList) Filtered(keep func(EmbeddedRecord) bool) EmbeddedList {
+	res := EmbeddedList{}
+	for _, rec := range l {
+		if keep(rec) {
+			res = append(res, rec.Copy())
+		}
+	}
+	return res
+}
+
+// Value JSON encodes this EmbeddedList for storing in the database.
+// A nil EmbeddedList is stored as an empty list.
+func (l EmbeddedList) Value() (driver.Value, error) {
+	if l == nil {
+		return "[]", nil
+	}
+	data, err := json.Marshal(l)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+// Scan decodes the given value into this EmbeddedList. The value can be
+// JSON data, as returned by the database, or a list of maps, as sent by
+// clients.
+func (l *EmbeddedList) Scan(src interface{}) error {
+	switch val := src.(type) {
+	case nil:
+		*l = EmbeddedList{}
+	case []byte:
+		return l.scanJSON(val)
+	case string:
+		return l.scanJSON([]byte(val))
+	case EmbeddedList:
+		*l = val
+	case []EmbeddedRecord:
+		*l = EmbeddedList(val)
+	case []map[string]interface{}:
+		res := make(EmbeddedList, len(val))
+		for i, rec := range val {
+			res[i] = EmbeddedRecord(rec)
+		}
+		*l = res
+	case []interface{}:
+		res := make(EmbeddedList, len(val))
+		for i, rec := range val {
+			switch r := rec.(type) {
+			case map[string]interface{}:
+				res[i] = EmbeddedRecord(r)
+			case EmbeddedRecord:
+				res[i] = r
+			default:
+				return fmt.Errorf("embedded record %d is not a map: %v", i, rec)
+			}
+		}
+		*l = res
+	default:
+		return fmt.Errorf("unable to scan %T into an EmbeddedList", src)
+	}
+	return nil
+}
+
+// scanJSON decodes the given JSON data into this EmbeddedList
+func (l *EmbeddedList) scanJSON(data []byte) error {
+	res := EmbeddedList{}
+	if err := json.Unmarshal(data, &res); err != nil {
+		return err
+	}
+	*l = res
+	return nil
+}
@@ -21,6 +21,8 @@ import (
 	"encoding/xml"
 	"fmt"
 	"reflect"
+	"sync"
+	"time"
 
 	"github.com/hexya-erp/hexya/hexya/models/types/dates"
 	"github.com/hexya-erp/hexya/hexya/tools/logging"
@@ -209,10 +211,70 @@ func (c *Context) HasKey(key string) bool {
 }
 
 // WithKey returns a copy of this context with the given key/value.
-// If key already exists, it is overwritten.
+// If key already exists, it is overwritten. This Context is not modified.
 func (c Context) WithKey(key string, value interface{}) *Context {
-	c.values[key] = value
-	return &c
+	res := c.Copy()
+	res.values[key] = value
+	return res
+}
+
+// Lang returns the language code of this Context,
+// i.e. the value of its "lang" key.
+func (c *Context) Lang() string {
+	return c.GetString("lang")
+}
+
+// timeZones caches the *time.Location of the valid time zone
+// names of Context.TZ, so that they are loaded only once.
+var timeZones sync.Map
+
+// TZ returns the time zone of this Context given by its "tz" key.
+// It returns UTC if the key is not set or is not a valid time zone name.
+func (c *Context) TZ() *time.Location {
+	tz := c.GetString("tz")
+	if tz == "" {
+		return time.UTC
+	}
+	if loc, ok := timeZones.Load(tz); ok {
+		return loc.(*time.Location)
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		log.Warn("Invalid time zone in context", "tz", tz, "error", err)
+		return time.UTC
+	}
+	timeZones.Store(tz, loc)
+	return loc
+}
+
+// CompanyID returns the id of the current company of this Context,
+// i.e. the value of its "company_id" key. It returns 0 if it is not set.
+func (c *Context) CompanyID() int64 {
+	return c.GetInteger("company_id")
+}
+
+// AllowedCompanyIDs returns the ids of the companies whose records can be
+// accessed with this Context, i.e. the value of its "allowed_company_ids" key.
+// The current company is always allowed. It returns an empty slice if
+// neither key is set.
+func (c *Context) AllowedCompanyIDs() []int64 {
+	res := c.GetIntegerSlice("allowed_company_ids")
+	company := c.CompanyID()
+	if company == 0 {
+		return res
+	}
+	for _, id := range res {
+		if id == company {
+			return res
+		}
+	}
+	return append([]int64{company}, res...)
+}
+
+// ActiveTest returns true if archived records should be filtered out of
+// searches. This is the case unless the "active_test" key is set to false.
+func (c *Context) ActiveTest() bool {
+	return !c.HasKey("active_test") || c.GetBool("active_test")
 }
 
 // IsEmpty returns true if this Context has no entries.
@@ -0,0 +1,68 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"fmt"
+
+	"github.com/hexya-erp/hexya/hexya/models/fieldtype"
+	"github.com/hexya-erp/hexya/hexya/models/operator"
+)
+
+// UnaccentSearch makes the case-insensitive searches on all char and text
+// fields ignore accents, as if they all had the UnaccentSearch parameter set.
+// It must be set before the database is synchronized.
+var UnaccentSearch bool
+
+// unaccentedOperators are the operators that ignore accents
+// when they are applied to unaccent searchable fields.
+var unaccentedOperators = map[operator.Operator]bool{
+	operator.IContains:    true,
+	operator.NotIContains: true,
+	operator.ILike:        true,
+}
+
+// isUnaccentSearchable returns true if case-insensitive searches
+// on the given field ignore accents.
+func isUnaccentSearchable(fi *Field) bool {
+	if fi.fieldType != fieldtype.Char && fi.fieldType != fieldtype.Text {
+		return false
+	}
+	return fi.unaccentSearch || UnaccentSearch
+}
+
+// isUnaccentedSearch returns true if a predicate on the given
+// field with the given operator must ignore accents.
+func isUnaccentedSearch(fi *Field, op operator.Operator) bool {
+	return unaccentedOperators[op] && isUnaccentSearchable(fi)
+}
+
+// unaccentIndexName returns the name of the index for
+// unaccented searches on the given field
+func unaccentIndexName(fi *Field) string {
+	return fmt.Sprintf("%s_%s_unaccent", fi.model.tableName, fi.json)
+}
+
+// updateDBUnaccentIndexes creates the expression indexes used by the
+// unaccented searches on the indexed fields of the given model, and drops
+// the ones that are not needed anymore.
+func updateDBUnaccentIndexes(m *Model) {
+	adapter := adapters[db.DriverName()]
+	indexes := make(map[string]bool)
+	for _, fi := range m.fields.registryByJSON {
+		if !fi.index || !fi.isStored() || !isUnaccentSearchable(fi) {
+			continue
+		}
+		indexName := unaccentIndexName(fi)
+		indexes[indexName] = true
+		if !adapter.indexExists(m.tableName, indexName) {
+			dbExecuteNoTx(adapter.unaccentIndexSQL(indexName, m.tableName, fi.json))
+		}
+	}
+	for _, dbIndexName := range adapter.indexes(m.tableName, fmt.Sprintf("%s_%%_unaccent", m.tableName)) {
+		if !indexes[dbIndexName] {
+			dropIndex(dbIndexName)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// recordURLs holds the parameters of the deep links to records
+var recordURLs struct {
+	sync.RWMutex
+	baseURL        string
+	actionForModel func(modelName string) string
+}
+
+// SetBaseURL sets the URL at which users reach the web client of this
+// server, such as "https://erp.example.com". It is prepended to the links
+// returned by RecordURL, which are relative to the server if it is not set.
+func SetBaseURL(baseURL string) {
+	recordURLs.Lock()
+	defer recordURLs.Unlock()
+	recordURLs.baseURL = strings.TrimRight(baseURL, "/")
+}
+
+// BaseURL returns the URL set by SetBaseURL
+func BaseURL() string {
+	recordURLs.RLock()
+	defer recordURLs.RUnlock()
+	return recordURLs.baseURL
+}
+
+// SetRecordActionResolver sets the function which returns the id of the
+// window action with which the web client opens the records of the given
+// model, or an empty string if there is none. It is set by the actions
+// package when it is bootstrapped.
+func SetRecordActionResolver(fnct func(modelName string) string) {
+	recordURLs.Lock()
+	defer recordURLs.Unlock()
+	recordURLs.actionForModel = fnct
+}
+
+// RecordURL returns the web client deep link to the record of the
+// given model with the given id. The link opens the form view of the
+// record in the window action of its model, if any.
+//
+// It panics if the model does not exist.
+func RecordURL(modelName string, id int64) string {
+	model := Registry.MustGet(modelName)
+	recordURLs.RLock()
+	defer recordURLs.RUnlock()
+	params := url.Values{
+		"id":        {fmt.Sprintf("%d", id)},
+		"model":     {model.name},
+		"view_type": {"form"},
+	}
+	if recordURLs.actionForModel != nil {
+		if action := recordURLs.actionForModel(model.name); action != "" {
+			params.Set("action", action)
+		}
+	}
+	return fmt.Sprintf("%s/web#%s", recordURLs.baseURL, params.Encode())
+}
+
+// URL returns the web client deep link to this record, as given by
+// RecordURL. It is intended to be used in mails, webhooks or reports
+// to link back to the record.
+//
+// It panics if this RecordCollection is not a singleton.
+func (rc *RecordCollection) URL() string {
+	rc.EnsureOne()
+	return RecordURL(rc.model.name, rc.ids[0])
+}
@@ -164,11 +164,11 @@ func MapToStruct(rc *RecordCollection, structPtr interface{}, fMap FieldMap) {
 			var relRC *RecordCollection
 			switch r := mValue.(type) {
 			case nil, *interface{}:
-				relRC = newRecordCollection(rc.Env(), fi.relatedModel.name)
+				relRC = newRecordCollection(rc.Env(), fi.relatedModel)
 			case int64:
-				relRC = newRecordCollection(rc.Env(), fi.relatedModel.name).withIds([]int64{r})
+				relRC = newRecordCollection(rc.Env(), fi.relatedModel).withIds([]int64{r})
 			case []int64:
-				relRC = newRecordCollection(rc.Env(), fi.relatedModel.name).withIds(r)
+				relRC = newRecordCollection(rc.Env(), fi.relatedModel).withIds(r)
 			}
 			if sf.Type == reflect.TypeOf(new(RecordCollection)) {
 				convertedValue = reflect.ValueOf(relRC)
@@ -345,7 +345,7 @@ func appendPredicateToSerial(res []interface{}, predicate predicate) []interface
 	if predicate.isCond {
 		res = append(res, serializePredicates(predicate.cond.predicates)...)
 	} else {
-		res = append(res, []interface{}{strings.Join(predicate.exprs, ExprSep), predicate.operator, predicate.arg})
+		res = append(res, []interface{}{predicate.fieldPath(), predicate.operator, predicate.arg})
 	}
 	return res
 }
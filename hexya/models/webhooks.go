@@ -0,0 +1,348 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hexya-erp/hexya/hexya/models/security"
+	"github.com/hexya-erp/hexya/hexya/models/types"
+	"github.com/hexya-erp/hexya/hexya/models/types/dates"
+	"github.com/hexya-erp/hexya/hexya/tools/logging"
+)
+
+// Webhook events
+const (
+	webhookCreate = "create"
+	webhookWrite  = "write"
+	webhookUnlink = "unlink"
+)
+
+// Webhook delivery states
+const (
+	webhookPending = "pending"
+	webhookDone    = "done"
+	webhookFailed  = "failed"
+)
+
+var (
+	// WebhookTimeout is the maximum duration of a webhook request
+	WebhookTimeout = 10 * time.Second
+	// WebhookRetryDelay is the delay before the first retry of a failed
+	// webhook delivery. It is doubled at each subsequent attempt.
+	WebhookRetryDelay = 30 * time.Second
+)
+
+// postWebhook posts the given payload with the given headers to the given URL
+// and returns the HTTP status code of the response. It is a variable so that
+// tests do not need an HTTP server.
+var postWebhook = postHTTPWebhook
+
+// Handles of the webhook models, which are used
+// at each creation, update or deletion of records.
+var (
+	webhookModel         = NewModelHandle("Webhook")
+	webhookDeliveryModel = NewModelHandle("WebhookDelivery")
+)
+
+// declareWebhookModels creates the Webhook and WebhookDelivery models and
+// registers the record hooks that queue webhook deliveries.
+//
+// Deliveries are created in the transaction of the operation that triggered
+// them, so that no webhook is sent for a rolled back operation. They are sent
+// by ProcessWebhooks, which is called periodically by the job runner worker of
+// the server, and retried with an exponential backoff until they succeed or
+// reach the maximum number of attempts of their webhook.
+//
+// Webhooks and deliveries can only be accessed by the admin.
+func declareWebhookModels() {
+	webhook := NewModel("Webhook")
+	delivery := NewModel("WebhookDelivery")
+
+	webhook.AddMethod("CheckModel",
+		`CheckModel checks that the model of this webhook exists.`,
+		func(rc *RecordCollection) {
+			if _, ok := Registry.Get(rc.Get("Model").(string)); !ok {
+				log.Panic("Unknown model in webhook", "webhook", rc.Get("Name"), "model", rc.Get("Model"))
+			}
+		})
+
+	webhook.AddFields(map[string]FieldDefinition{
+		"Name":  CharField{Required: true},
+		"Model": CharField{Required: true, Index: true, Constraint: webhook.methods.MustGet("CheckModel")},
+		"Event": SelectionField{Required: true, Selection: types.Selection{
+			webhookCreate: "Creation",
+			webhookWrite:  "Update",
+			webhookUnlink: "Deletion",
+		}},
+		"Condition": TextField{
+			Help: "Expression evaluated on each record as with Evaluate. The webhook is only sent if it is true. Not evaluated on deletion."},
+		"URL": CharField{Required: true},
+		"PayloadFields": CharField{
+			Help: "Comma separated list of the fields of the record sent in the payload. Not used on deletion."},
+		"Secret": CharField{
+			Help: "Key of the HMAC-SHA256 signature of the payload sent in the X-Hexya-Signature header"},
+		"MaxAttempts": IntegerField{Required: true, Default: DefaultValue(int64(5))},
+		"Active":      BooleanField{Default: DefaultValue(true)},
+		"Deliveries":  One2ManyField{RelationModel: delivery, ReverseFK: "Webhook"},
+	})
+
+	webhook.AddMethod("Test",
+		`Test queues a delivery of this webhook for the given records, as if
+		they had just been created, written or deleted. The condition of the
+		webhook is not evaluated.`,
+		func(rc *RecordCollection, records RecordSet) *RecordCollection {
+			rc.EnsureOne()
+			recs := records.Collection()
+			if recs.ModelName() != rc.Get("Model").(string) {
+				log.Panic("Webhook tested with records of another model", "webhook", rc.Get("Name"),
+					"model", rc.Get("Model"), "records", recs.ModelName())
+			}
+			var ids []int64
+			for _, rec := range recs.Records() {
+				ids = append(ids, rc.queueWebhook(rec, nil).Ids()...)
+			}
+			return rc.env.Pool("WebhookDelivery").withIds(ids)
+		})
+
+	delivery.AddFields(map[string]FieldDefinition{
+		"Webhook":  Many2OneField{RelationModel: webhook, Required: true, OnDelete: Cascade, Index: true},
+		"Event":    CharField{Required: true},
+		"ResModel": CharField{String: "Resource Model", Index: true},
+		"ResID":    IntegerField{String: "Resource ID", BigInt: true, Index: true},
+		"Payload":  TextField{Required: true, Help: "JSON payload of the request"},
+		"State": SelectionField{Required: true, Index: true, Default: DefaultValue(webhookPending),
+			Selection: types.Selection{
+				webhookPending: "Pending",
+				webhookDone:    "Delivered",
+				webhookFailed:  "Failed",
+			}},
+		"Attempts":       IntegerField{NoCopy: true},
+		"NextAttempt":    DateTimeField{Index: true, NoCopy: true},
+		"ResponseStatus": IntegerField{NoCopy: true, Help: "HTTP status of the last response"},
+		"Error":          TextField{NoCopy: true, Help: "Reason of the failure of the last attempt"},
+		"DoneDate":       DateTimeField{String: "Delivery Date", NoCopy: true},
+	})
+	delivery.SetDefaultOrder("ID desc")
+
+	delivery.AddMethod("Send",
+		`Send sends immediately the pending deliveries of this RecordSet,
+		whatever their next attempt date.`,
+		func(rc *RecordCollection) {
+			for _, d := range rc.Records() {
+				if d.Get("State").(string) != webhookPending {
+					continue
+				}
+				d.deliverWebhook()
+			}
+		})
+
+	delivery.AddMethod("Retry",
+		`Retry queues again the failed deliveries of this RecordSet,
+		with a new series of attempts.`,
+		func(rc *RecordCollection) {
+			for _, d := range rc.Records() {
+				if d.Get("State").(string) == webhookFailed {
+					d.Call("Write", FieldMap{"State": webhookPending, "Attempts": int64(0), "NextAttempt": dates.Now()})
+				}
+			}
+		})
+
+	OnRecordCreated("", webhookHook(webhookCreate))
+	OnRecordWritten("", webhookHook(webhookWrite))
+	OnRecordDeleted("", webhookHook(webhookUnlink))
+}
+
+// webhookHook returns the RecordHook that queues
+// the deliveries of the webhooks of the given event.
+func webhookHook(event string) RecordHook {
+	return func(rc *RecordCollection, fields []string) {
+		if rc.model.isSystem() || rc.model.name == "Webhook" || rc.model.name == "WebhookDelivery" {
+			return
+		}
+		webhooks := webhookModel.Pool(rc.env).Sudo()
+		hooks := webhooks.Search(webhooks.Model().Field("Model").Equals(rc.model.name).
+			And().Field("Event").Equals(event).
+			And().Field("Active").Equals(true))
+		for _, hook := range hooks.Records() {
+			for _, rec := range rc.Records() {
+				if cond := strings.TrimSpace(hook.Get("Condition").(string)); cond != "" && event != webhookUnlink &&
+					!rec.EvaluateCondition(cond) {
+					continue
+				}
+				hook.queueWebhook(rec, fields)
+			}
+		}
+	}
+}
+
+// queueWebhook creates and returns a pending delivery of this webhook
+// for the given record. fields are the names of the fields that have
+// been set by the operation, if any.
+func (rc *RecordCollection) queueWebhook(rec *RecordCollection, fields []string) *RecordCollection {
+	event := rc.Get("Event").(string)
+	payload := map[string]interface{}{
+		"webhook": rc.Get("Name"),
+		"event":   event,
+		"model":   rec.ModelName(),
+		"id":      rec.ids[0],
+	}
+	if fields != nil {
+		payload["fields"] = fields
+	}
+	if event != webhookUnlink {
+		payload["url"] = rec.URL()
+		var dataFields []string
+		for _, f := range strings.Split(rc.Get("PayloadFields").(string), ",") {
+			if f = strings.TrimSpace(f); f != "" {
+				dataFields = append(dataFields, f)
+			}
+		}
+		if len(dataFields) > 0 {
+			payload["data"] = rec.Sudo().Read(dataFields...)[0]
+		}
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		log.Panic("Unable to encode webhook payload", "webhook", rc.Get("Name"), "error", err)
+	}
+	return webhookDeliveryModel.Pool(rc.env).Sudo().Call("Create", FieldMap{
+		"Webhook":     rc,
+		"Event":       event,
+		"ResModel":    rec.ModelName(),
+		"ResID":       rec.ids[0],
+		"Payload":     string(data),
+		"NextAttempt": dates.Now(),
+	}).(RecordSet).Collection()
+}
+
+// ProcessWebhooks sends all the pending webhook deliveries whose next attempt
+// date is reached and returns the number of deliveries that have been attempted.
+//
+// Each delivery is sent in its own transaction, in which it is first claimed
+// with a row lock that other transactions skip, so that this function can
+// be called concurrently by several server processes. It returns after the
+// current delivery once StopProcessing has been called.
+func ProcessWebhooks() int {
+	var count int
+	for !ProcessingStopped() {
+		var claimed bool
+		err := ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+			deliveries := env.claimWebhookDeliveries(1)
+			claimed = deliveries != nil
+			if claimed {
+				deliveries.deliverWebhook()
+			}
+		})
+		if err != nil {
+			log.Warn("Error while processing webhooks", "error", err)
+			return count
+		}
+		if !claimed {
+			return count
+		}
+		count++
+	}
+	return count
+}
+
+// claimWebhookDeliveries locks and returns at most limit due pending deliveries
+// that are not locked by another transaction, or nil if there is none.
+func (env Environment) claimWebhookDeliveries(limit int) *RecordCollection {
+	deliveryModel := Registry.MustGet("WebhookDelivery")
+	var ids []int64
+	env.cr.Select(&ids, fmt.Sprintf(`SELECT id FROM %s WHERE state = ? AND (next_attempt IS NULL OR next_attempt <= ?)
+		ORDER BY id LIMIT ? FOR UPDATE SKIP LOCKED`,
+		adapters[db.DriverName()].quoteTableName(deliveryModel.tableName)), webhookPending, dates.Now(), limit)
+	if len(ids) == 0 {
+		return nil
+	}
+	return env.Pool(deliveryModel.name).Sudo().withIds(ids)
+}
+
+// deliverWebhook sends this pending delivery and updates its state. Failed
+// deliveries are scheduled for a new attempt after a delay which doubles at
+// each attempt, until the maximum number of attempts of the webhook.
+func (rc *RecordCollection) deliverWebhook() {
+	rc.EnsureOne()
+	webhook := rc.Get("Webhook").(RecordSet).Collection()
+	payload := []byte(rc.Get("Payload").(string))
+	headers := map[string]string{
+		"Content-Type":      "application/json",
+		"User-Agent":        "Hexya-Webhook",
+		"X-Hexya-Event":     rc.Get("Event").(string),
+		"X-Hexya-Delivery":  strconv.FormatInt(rc.ids[0], 10),
+		"X-Hexya-Signature": webhookSignature(webhook.Get("Secret").(string), payload),
+	}
+	var (
+		status  int
+		failure string
+	)
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				failure = logging.LogPanicData(r).Error()
+			}
+		}()
+		var err error
+		status, err = postWebhook(webhook.Get("URL").(string), headers, payload)
+		if err != nil {
+			log.Panic("Unable to send webhook", "id", rc.ids[0], "url", webhook.Get("URL"), "error", err)
+		}
+		if status < 200 || status >= 300 {
+			log.Panic("Webhook rejected", "id", rc.ids[0], "url", webhook.Get("URL"), "status", status)
+		}
+	}()
+	attempts := rc.Get("Attempts").(int64) + 1
+	values := FieldMap{"Attempts": attempts, "ResponseStatus": int64(status), "Error": failure}
+	switch {
+	case failure == "":
+		values["State"] = webhookDone
+		values["DoneDate"] = dates.Now()
+	case attempts >= webhook.Get("MaxAttempts").(int64):
+		log.Warn("Webhook delivery failed", "id", rc.ids[0], "attempts", attempts, "error", failure)
+		values["State"] = webhookFailed
+	default:
+		values["NextAttempt"] = dates.Now().Add(WebhookRetryDelay << uint(attempts-1))
+	}
+	rc.Call("Write", values)
+}
+
+// webhookSignature returns the value of the X-Hexya-Signature header of the
+// given payload, i.e. its hex encoded HMAC-SHA256 with the given secret.
+func webhookSignature(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// postHTTPWebhook posts the given payload to the given URL
+func postHTTPWebhook(url string, headers map[string]string, payload []byte) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return 0, err
+	}
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	client := http.Client{Timeout: WebhookTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,36 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package presence
+
+import "sync"
+
+// A MemoryBackend is a Backend that broadcasts events to the
+// Trackers of the same process. It is mainly useful for tests.
+type MemoryBackend struct {
+	sync.RWMutex
+	handlers []func(Event)
+}
+
+// NewMemoryBackend returns a new MemoryBackend
+func NewMemoryBackend() *MemoryBackend {
+	return new(MemoryBackend)
+}
+
+// Publish sends the given event to all subscribers
+func (mb *MemoryBackend) Publish(evt Event) error {
+	mb.RLock()
+	defer mb.RUnlock()
+	for _, handler := range mb.handlers {
+		handler(evt)
+	}
+	return nil
+}
+
+// Subscribe registers handler to be called with all published events
+func (mb *MemoryBackend) Subscribe(handler func(Event)) error {
+	mb.Lock()
+	defer mb.Unlock()
+	mb.handlers = append(mb.handlers, handler)
+	return nil
+}
@@ -0,0 +1,13 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package presence
+
+import "github.com/hexya-erp/hexya/hexya/tools/logging"
+
+var log *logging.Logger
+
+func init() {
+	log = logging.GetLogger("presence")
+	Registry = NewTracker()
+}
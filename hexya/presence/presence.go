@@ -0,0 +1,273 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+// Package presence keeps track of the presence of users, that is whether
+// they are online or away, when they were last seen and which resources,
+// such as records, they are currently viewing.
+//
+// Presence is updated by the client connections through Heartbeat and kept
+// in memory. When the application runs on several instances, presence
+// updates are exchanged between instances through a Backend.
+package presence
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultOfflineTimeout is the default duration after which
+// a connection without heartbeat is considered offline.
+const DefaultOfflineTimeout = 2 * time.Minute
+
+// Registry is the presence Tracker of this instance
+var Registry *Tracker
+
+// A Status is the presence status of a user
+type Status string
+
+// Available presence statuses, from the least to the most present
+const (
+	Offline Status = "offline"
+	Away    Status = "away"
+	Online  Status = "online"
+)
+
+// rank returns the rank of this status to compare it with others
+func (s Status) rank() int {
+	switch s {
+	case Online:
+		return 2
+	case Away:
+		return 1
+	}
+	return 0
+}
+
+// A Presence is the presence of a user, merged from all their connections
+type Presence struct {
+	UID      int64     `json:"uid"`
+	Status   Status    `json:"status"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// An Event is the state of a connection at a given time.
+// Events are exchanged between instances through the Backend.
+type Event struct {
+	Instance   string    `json:"instance"`
+	Connection string    `json:"connection"`
+	UID        int64     `json:"uid"`
+	Status     Status    `json:"status"`
+	Resources  []string  `json:"resources,omitempty"`
+	Time       time.Time `json:"time"`
+}
+
+// A Backend broadcasts presence events between the instances of the application.
+type Backend interface {
+	// Publish sends the given event to all instances
+	Publish(evt Event) error
+	// Subscribe registers handler to be called with the events published by
+	// any instance, including this one.
+	Subscribe(handler func(Event)) error
+}
+
+// A connectionKey identifies a client connection across instances
+type connectionKey struct {
+	instance   string
+	connection string
+}
+
+// A Tracker keeps the presence of users in memory
+type Tracker struct {
+	sync.RWMutex
+	// OfflineTimeout is the duration after which a connection
+	// without heartbeat is considered offline.
+	OfflineTimeout time.Duration
+	instance       string
+	backend        Backend
+	connections    map[connectionKey]Event
+	lastSeen       map[int64]time.Time
+	lastVacuum     time.Time
+	now            func() time.Time
+}
+
+// NewTracker returns a new Tracker without Backend
+func NewTracker() *Tracker {
+	instance := make([]byte, 8)
+	rand.Read(instance)
+	return &Tracker{
+		OfflineTimeout: DefaultOfflineTimeout,
+		instance:       hex.EncodeToString(instance),
+		connections:    make(map[connectionKey]Event),
+		lastSeen:       make(map[int64]time.Time),
+		now:            time.Now,
+	}
+}
+
+// SetBackend sets the Backend through which this Tracker
+// exchanges presence events with other instances.
+func (t *Tracker) SetBackend(backend Backend) error {
+	t.Lock()
+	t.backend = backend
+	t.Unlock()
+	return backend.Subscribe(t.receive)
+}
+
+// Heartbeat updates the presence of the user uid on the given connection.
+//
+// It must be called periodically by each client connection, with the Away
+// status if the user is inactive, and with the resources the user is currently
+// viewing (see RecordResource). A connection is considered offline if no
+// heartbeat is received within OfflineTimeout.
+func (t *Tracker) Heartbeat(connection string, uid int64, status Status, resources ...string) {
+	t.update(Event{
+		Instance:   t.instance,
+		Connection: connection,
+		UID:        uid,
+		Status:     status,
+		Resources:  resources,
+		Time:       t.now(),
+	})
+}
+
+// Disconnect sets the given connection offline
+func (t *Tracker) Disconnect(connection string) {
+	t.RLock()
+	evt, ok := t.connections[connectionKey{instance: t.instance, connection: connection}]
+	t.RUnlock()
+	if !ok {
+		return
+	}
+	evt.Status = Offline
+	evt.Resources = nil
+	evt.Time = t.now()
+	t.update(evt)
+}
+
+// update applies the given local event and publishes it to the other instances
+func (t *Tracker) update(evt Event) {
+	t.apply(evt)
+	t.RLock()
+	backend := t.backend
+	t.RUnlock()
+	if backend == nil {
+		return
+	}
+	if err := backend.Publish(evt); err != nil {
+		log.Warn("Unable to publish presence event", "uid", evt.UID, "error", err)
+	}
+}
+
+// receive applies an event published by another instance
+func (t *Tracker) receive(evt Event) {
+	if evt.Instance == t.instance {
+		return
+	}
+	t.apply(evt)
+}
+
+// apply stores the given event in memory
+func (t *Tracker) apply(evt Event) {
+	t.Lock()
+	defer t.Unlock()
+	key := connectionKey{instance: evt.Instance, connection: evt.Connection}
+	if evt.Time.After(t.lastSeen[evt.UID]) {
+		t.lastSeen[evt.UID] = evt.Time
+	}
+	if evt.Status == Offline {
+		delete(t.connections, key)
+	} else if current, ok := t.connections[key]; !ok || !evt.Time.Before(current.Time) {
+		t.connections[key] = evt
+	}
+	t.vacuum()
+}
+
+// vacuum removes expired connections from memory.
+// It does nothing if it has been run less than OfflineTimeout ago.
+// The caller must hold the lock.
+func (t *Tracker) vacuum() {
+	now := t.now()
+	if now.Sub(t.lastVacuum) < t.OfflineTimeout {
+		return
+	}
+	for key, evt := range t.connections {
+		if t.expired(evt, now) {
+			delete(t.connections, key)
+		}
+	}
+	t.lastVacuum = now
+}
+
+// expired returns true if the given connection event has expired at time now
+func (t *Tracker) expired(evt Event, now time.Time) bool {
+	return now.Sub(evt.Time) > t.OfflineTimeout
+}
+
+// Get returns the presence of the user with the given uid,
+// merged from all their connections on all instances.
+//
+// LastSeen is kept after the user goes offline, but only
+// for the lifetime of the Tracker.
+func (t *Tracker) Get(uid int64) Presence {
+	return t.GetMulti(uid)[0]
+}
+
+// GetMulti returns the presences of the users with the given uids, in the same order.
+func (t *Tracker) GetMulti(uids ...int64) []Presence {
+	t.RLock()
+	defer t.RUnlock()
+	now := t.now()
+	presences := make(map[int64]*Presence)
+	for _, uid := range uids {
+		presences[uid] = &Presence{UID: uid, Status: Offline, LastSeen: t.lastSeen[uid]}
+	}
+	for _, evt := range t.connections {
+		p, ok := presences[evt.UID]
+		if !ok || t.expired(evt, now) {
+			continue
+		}
+		if evt.Status.rank() > p.Status.rank() {
+			p.Status = evt.Status
+		}
+	}
+	res := make([]Presence, len(uids))
+	for i, uid := range uids {
+		res[i] = *presences[uid]
+	}
+	return res
+}
+
+// Viewers returns the sorted uids of the users who are currently
+// viewing the given resource on any instance.
+func (t *Tracker) Viewers(resource string) []int64 {
+	t.RLock()
+	defer t.RUnlock()
+	now := t.now()
+	uids := make(map[int64]bool)
+	for _, evt := range t.connections {
+		if t.expired(evt, now) {
+			continue
+		}
+		for _, res := range evt.Resources {
+			if res == resource {
+				uids[evt.UID] = true
+				break
+			}
+		}
+	}
+	res := make([]int64, 0, len(uids))
+	for uid := range uids {
+		res = append(res, uid)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i] < res[j] })
+	return res
+}
+
+// RecordResource returns the resource name of the record
+// of the given model and id to be used in Heartbeat and Viewers.
+func RecordResource(model string, id int64) string {
+	return fmt.Sprintf("%s,%d", model, id)
+}
@@ -0,0 +1,57 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package presence
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPresence(t *testing.T) {
+	Convey("Testing presence tracking", t, func() {
+		now := time.Date(2017, 6, 1, 10, 0, 0, 0, time.UTC)
+		clock := func() time.Time { return now }
+		backend := NewMemoryBackend()
+		tracker1, tracker2 := NewTracker(), NewTracker()
+		tracker1.now, tracker2.now = clock, clock
+		So(tracker1.SetBackend(backend), ShouldBeNil)
+		So(tracker2.SetBackend(backend), ShouldBeNil)
+		Convey("Unknown users should be offline", func() {
+			So(tracker1.Get(1), ShouldResemble, Presence{UID: 1, Status: Offline})
+		})
+		Convey("Presence should be merged from all connections of all instances", func() {
+			tracker1.Heartbeat("conn1", 1, Away)
+			tracker2.Heartbeat("conn2", 1, Online)
+			tracker2.Heartbeat("conn3", 2, Away)
+			So(tracker1.GetMulti(1, 2, 3), ShouldResemble, []Presence{
+				{UID: 1, Status: Online, LastSeen: now},
+				{UID: 2, Status: Away, LastSeen: now},
+				{UID: 3, Status: Offline},
+			})
+			tracker2.Disconnect("conn2")
+			So(tracker1.Get(1).Status, ShouldEqual, Away)
+			So(tracker2.Get(1).Status, ShouldEqual, Away)
+		})
+		Convey("Viewers of a resource should be returned", func() {
+			tracker1.Heartbeat("conn1", 1, Online, RecordResource("Partner", 5))
+			tracker2.Heartbeat("conn2", 2, Away, RecordResource("Partner", 5), RecordResource("Partner", 6))
+			tracker2.Heartbeat("conn3", 3, Online, RecordResource("Partner", 6))
+			So(tracker1.Viewers("Partner,5"), ShouldResemble, []int64{1, 2})
+			So(tracker1.Viewers("Partner,6"), ShouldResemble, []int64{2, 3})
+			tracker2.Heartbeat("conn2", 2, Online)
+			So(tracker1.Viewers("Partner,5"), ShouldResemble, []int64{1})
+		})
+		Convey("Connections without heartbeat should expire", func() {
+			tracker1.Heartbeat("conn1", 1, Online, "Partner,5")
+			seen := now
+			now = now.Add(DefaultOfflineTimeout + time.Second)
+			So(tracker2.Get(1), ShouldResemble, Presence{UID: 1, Status: Offline, LastSeen: seen})
+			So(tracker2.Viewers("Partner,5"), ShouldBeEmpty)
+			tracker1.Heartbeat("conn2", 2, Online)
+			So(tracker1.connections, ShouldHaveLength, 1)
+		})
+	})
+}
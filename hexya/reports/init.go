@@ -0,0 +1,30 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package reports
+
+import (
+	"github.com/hexya-erp/hexya/hexya/models"
+	"github.com/hexya-erp/hexya/hexya/tools/logging"
+)
+
+var log *logging.Logger
+
+func init() {
+	log = logging.GetLogger("reports")
+	Registry = NewCollection()
+	RegisterConverter(FormatHTML, "text/html; charset=utf-8", renderHTML)
+	RegisterConverter(FormatPDF, "application/pdf", renderPDF)
+	RegisterConverter(FormatCSV, "text/csv; charset=utf-8", renderExport(models.ExportCSV))
+	RegisterConverter(FormatXLSX, "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", renderXLSX)
+	for _, pf := range []PaperFormat{
+		{Name: "A3", Width: 297, Height: 420},
+		{Name: "A4", Width: 210, Height: 297},
+		{Name: "A5", Width: 148, Height: 210},
+		{Name: "Letter", Width: 215.9, Height: 279.4},
+		{Name: "Legal", Width: 215.9, Height: 355.6},
+	} {
+		pf.MarginTop, pf.MarginBottom, pf.MarginLeft, pf.MarginRight = 10, 10, 10, 10
+		RegisterPaperFormat(pf)
+	}
+}
@@ -0,0 +1,197 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package reports
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/hexya-erp/hexya/hexya/models"
+)
+
+// A PaperFormat defines the size and the margins of the pages of PDF
+// reports. All dimensions are in millimeters and are given for the portrait
+// orientation. Width and Height are swapped for landscape reports.
+type PaperFormat struct {
+	Name         string
+	Width        float64
+	Height       float64
+	MarginTop    float64
+	MarginBottom float64
+	MarginLeft   float64
+	MarginRight  float64
+}
+
+// pageSize returns the width and height of the pages
+// of this PaperFormat in the given orientation.
+func (pf PaperFormat) pageSize(landscape bool) (float64, float64) {
+	if landscape {
+		return pf.Height, pf.Width
+	}
+	return pf.Width, pf.Height
+}
+
+// DefaultPaperFormat is the name of the paper format
+// of the reports that do not define one.
+var DefaultPaperFormat = "A4"
+
+var (
+	paperFormatsMutex sync.RWMutex
+	paperFormats      = make(map[string]PaperFormat)
+)
+
+// RegisterPaperFormat registers the given PaperFormat under its name,
+// replacing any paper format with the same name.
+func RegisterPaperFormat(pf PaperFormat) {
+	paperFormatsMutex.Lock()
+	defer paperFormatsMutex.Unlock()
+	paperFormats[pf.Name] = pf
+}
+
+// GetPaperFormat returns the PaperFormat with the given name
+// and false if no such paper format is registered.
+func GetPaperFormat(name string) (PaperFormat, bool) {
+	paperFormatsMutex.RLock()
+	defer paperFormatsMutex.RUnlock()
+	pf, ok := paperFormats[name]
+	return pf, ok
+}
+
+// A PDFEngine is an external program converting HTML documents to PDF
+type PDFEngine string
+
+// PDF engines supported by the PDF converter
+const (
+	// Wkhtmltopdf converts HTML to PDF with the wkhtmltopdf program
+	Wkhtmltopdf PDFEngine = "wkhtmltopdf"
+	// Chromium converts HTML to PDF by printing it with headless Chromium
+	Chromium PDFEngine = "chromium"
+)
+
+var (
+	// PDFEngineInUse is the engine used to render reports to PDF
+	PDFEngineInUse = Wkhtmltopdf
+	// PDFCommand is the path of the program of PDFEngineInUse.
+	// If empty, the engine name is looked up in the PATH.
+	PDFCommand string
+)
+
+// runCommand runs the given program with the given arguments, reading its
+// input from stdin and writing its output to stdout. It is a variable so
+// that tests can run without PDF engine.
+var runCommand = func(name string, args []string, stdin io.Reader, stdout io.Writer) error {
+	var stderr bytes.Buffer
+	cmd := exec.Command(name, args...)
+	cmd.Stdin = stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s failed: %s: %s", name, err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// renderPDF is the converter of the PDF format.
+// It converts the HTML rendering of the report with PDFEngineInUse.
+func renderPDF(report *Report, rs models.RecordSet, w io.Writer) error {
+	var html bytes.Buffer
+	if err := renderHTML(report, rs, &html); err != nil {
+		return err
+	}
+	paperFormat := report.PaperFormat
+	if paperFormat == "" {
+		paperFormat = DefaultPaperFormat
+	}
+	pf, ok := GetPaperFormat(paperFormat)
+	if !ok {
+		return fmt.Errorf("unknown paper format %s for report %s", paperFormat, report.ID)
+	}
+	doc := htmlDocument(html.String(), pf, report.Landscape)
+	command := PDFCommand
+	if command == "" {
+		command = string(PDFEngineInUse)
+	}
+	switch PDFEngineInUse {
+	case Wkhtmltopdf:
+		return runCommand(command, wkhtmltopdfArgs(pf, report.Landscape), strings.NewReader(doc), w)
+	case Chromium:
+		return printWithChromium(command, doc, w)
+	default:
+		return fmt.Errorf("unknown PDF engine %s", PDFEngineInUse)
+	}
+}
+
+// htmlDocument returns the given HTML rendering of a report as a complete
+// HTML document, with a page style for the given paper format. Renderings
+// that are already complete documents are returned as is.
+func htmlDocument(html string, pf PaperFormat, landscape bool) string {
+	if strings.Contains(strings.ToLower(html), "<html") {
+		return html
+	}
+	width, height := pf.pageSize(landscape)
+	style := fmt.Sprintf("<style>@page { size: %gmm %gmm; margin: %gmm %gmm %gmm %gmm; }</style>",
+		width, height, pf.MarginTop, pf.MarginRight, pf.MarginBottom, pf.MarginLeft)
+	return fmt.Sprintf("<!DOCTYPE html><html><head><meta charset=\"utf-8\"/>%s</head><body>%s</body></html>", style, html)
+}
+
+// wkhtmltopdfArgs returns the arguments of wkhtmltopdf to convert
+// its standard input to its standard output in the given paper format.
+func wkhtmltopdfArgs(pf PaperFormat, landscape bool) []string {
+	width, height := pf.pageSize(landscape)
+	mm := func(v float64) string {
+		return fmt.Sprintf("%gmm", v)
+	}
+	return []string{
+		"--quiet",
+		"--encoding", "utf-8",
+		"--page-width", mm(width),
+		"--page-height", mm(height),
+		"--margin-top", mm(pf.MarginTop),
+		"--margin-bottom", mm(pf.MarginBottom),
+		"--margin-left", mm(pf.MarginLeft),
+		"--margin-right", mm(pf.MarginRight),
+		"-", "-",
+	}
+}
+
+// printWithChromium converts the given HTML document to PDF with the given
+// headless Chromium program and writes it to w. The page size is given to
+// Chromium by the page style of the document.
+func printWithChromium(command, doc string, w io.Writer) error {
+	dir, err := ioutil.TempDir("", "hexya-report")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	htmlFile := filepath.Join(dir, "report.html")
+	pdfFile := filepath.Join(dir, "report.pdf")
+	if err = ioutil.WriteFile(htmlFile, []byte(doc), 0600); err != nil {
+		return err
+	}
+	args := []string{
+		"--headless",
+		"--disable-gpu",
+		"--no-sandbox",
+		"--no-pdf-header-footer",
+		"--print-to-pdf=" + pdfFile,
+		"file://" + htmlFile,
+	}
+	if err = runCommand(command, args, nil, ioutil.Discard); err != nil {
+		return err
+	}
+	pdf, err := os.Open(pdfFile)
+	if err != nil {
+		return err
+	}
+	defer pdf.Close()
+	_, err = io.Copy(w, pdf)
+	return err
+}
@@ -0,0 +1,285 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package reports
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/beevik/etree"
+	"github.com/hexya-erp/hexya/hexya/models"
+	"github.com/hexya-erp/hexya/hexya/tools/logging"
+)
+
+// Registry is the report collection of the application
+var Registry *Collection
+
+// A Format is an output format of a report
+type Format string
+
+// Formats provided by the framework. Other formats can be added by
+// registering a converter with RegisterConverter.
+const (
+	FormatHTML Format = "html"
+	FormatPDF  Format = "pdf"
+	FormatCSV  Format = "csv"
+	FormatXLSX Format = "xlsx"
+)
+
+// A Report is a printable document for the records of a model.
+//
+// The same report can be rendered in several formats: document formats
+// (such as HTML or PDF) use the Template, whereas tabular formats (such
+// as CSV or XLSX) export the Fields of the records.
+//
+// PDF pages have the registered PaperFormat with the given name, or
+// DefaultPaperFormat if it is empty, in landscape if Landscape is true.
+//
+// XLSX spreadsheets have a sheet with the Fields, if any, followed by
+// the Sheets of the report.
+type Report struct {
+	ID          string
+	Name        string
+	Model       string
+	Template    *template.Template
+	Fields      []string
+	Sheets      []Sheet
+	PaperFormat string
+	Landscape   bool
+}
+
+// A Sheet is an additional sheet of the XLSX rendering of a report,
+// with the given fields of the records.
+type Sheet struct {
+	Name   string
+	Fields []string
+}
+
+// A Converter renders the given report for the given records to w.
+type Converter func(report *Report, rs models.RecordSet, w io.Writer) error
+
+// A converter is a registered Converter with the
+// content type of its output.
+type converter struct {
+	contentType string
+	fnct        Converter
+}
+
+var (
+	convertersMutex sync.RWMutex
+	converters      = make(map[Format]converter)
+)
+
+// RegisterConverter registers the given Converter for the given format.
+// contentType is the MIME type of the converter's output.
+//
+// If a converter is already registered for this format, it is replaced.
+// This allows modules to provide their own rendering engine.
+func RegisterConverter(format Format, contentType string, fnct Converter) {
+	convertersMutex.Lock()
+	defer convertersMutex.Unlock()
+	converters[format] = converter{contentType: contentType, fnct: fnct}
+}
+
+// Formats returns the formats for which a converter is registered.
+func Formats() []Format {
+	convertersMutex.RLock()
+	defer convertersMutex.RUnlock()
+	var res []Format
+	for format := range converters {
+		res = append(res, format)
+	}
+	return res
+}
+
+// Render renders this report for the given records in the given format to w.
+// It returns the content type of the output.
+func (r *Report) Render(rs models.RecordSet, format Format, w io.Writer) (contentType string, rError error) {
+	if rs.ModelName() != r.Model {
+		return "", fmt.Errorf("report %s is for model %s, got %s", r.ID, r.Model, rs.ModelName())
+	}
+	convertersMutex.RLock()
+	conv, ok := converters[format]
+	convertersMutex.RUnlock()
+	if !ok {
+		return "", fmt.Errorf("no converter registered for format %s", format)
+	}
+	defer func() {
+		if rec := recover(); rec != nil {
+			rError = logging.LogPanicData(rec)
+		}
+	}()
+	if err := conv.fnct(r, rs, w); err != nil {
+		return "", err
+	}
+	return conv.contentType, nil
+}
+
+// A TemplateData is the data given to report templates
+type TemplateData struct {
+	Report  *Report
+	Records models.RecordSet
+}
+
+// renderHTML is the converter of the HTML format.
+// It executes the report's template.
+func renderHTML(report *Report, rs models.RecordSet, w io.Writer) error {
+	if report.Template == nil {
+		return fmt.Errorf("report %s has no template", report.ID)
+	}
+	return report.Template.Execute(w, TemplateData{Report: report, Records: rs})
+}
+
+// renderExport returns a converter that exports the
+// report's fields in the given format.
+func renderExport(format models.ExportFormat) Converter {
+	return func(report *Report, rs models.RecordSet, w io.Writer) error {
+		if len(report.Fields) == 0 {
+			return fmt.Errorf("report %s has no fields", report.ID)
+		}
+		rs.Collection().Export(w, report.Fields, format)
+		return nil
+	}
+}
+
+// renderXLSX is the converter of the XLSX format. It exports
+// the report's fields and sheets in a spreadsheet.
+func renderXLSX(report *Report, rs models.RecordSet, w io.Writer) error {
+	var sheets []models.ExportSheet
+	if len(report.Fields) > 0 {
+		sheets = append(sheets, models.ExportSheet{Name: report.Name, Records: rs.Collection(), Fields: report.Fields})
+	}
+	for _, sheet := range report.Sheets {
+		sheets = append(sheets, models.ExportSheet{Name: sheet.Name, Records: rs.Collection(), Fields: sheet.Fields})
+	}
+	if len(sheets) == 0 {
+		return fmt.Errorf("report %s has no fields", report.ID)
+	}
+	models.ExportSheets(w, sheets...)
+	return nil
+}
+
+// A Collection is a collection of reports
+type Collection struct {
+	sync.RWMutex
+	reports map[string]*Report
+}
+
+// NewCollection returns a pointer to a new Collection instance
+func NewCollection() *Collection {
+	return &Collection{
+		reports: make(map[string]*Report),
+	}
+}
+
+// Add adds the given report to this Collection,
+// replacing any report with the same ID.
+func (rc *Collection) Add(r *Report) {
+	rc.Lock()
+	defer rc.Unlock()
+	rc.reports[r.ID] = r
+}
+
+// GetByID returns the Report with the given id
+func (rc *Collection) GetByID(id string) *Report {
+	rc.RLock()
+	defer rc.RUnlock()
+	return rc.reports[id]
+}
+
+// MustGetByID returns the Report with the given id.
+// It panics if the id is not found in this Collection.
+func (rc *Collection) MustGetByID(id string) *Report {
+	report := rc.GetByID(id)
+	if report == nil {
+		log.Panic("Report does not exist", "report_id", id)
+	}
+	return report
+}
+
+// GetAllForModel returns all the reports of the given model.
+// Reports are returned in an arbitrary order.
+func (rc *Collection) GetAllForModel(modelName string) []*Report {
+	rc.RLock()
+	defer rc.RUnlock()
+	var res []*Report
+	for _, report := range rc.reports {
+		if report.Model == modelName {
+			res = append(res, report)
+		}
+	}
+	return res
+}
+
+// LoadFromEtree reads the report definition from the given etree.Element
+// and adds it to this Collection. The content of the element is the
+// HTML template of the report. Fields for tabular formats are given as
+// a comma separated list in the fields attribute. The paper_format and
+// orientation attributes set the pages of the PDF format. Additional
+// sheets of the XLSX format are given by sheet elements, which are not
+// part of the template.
+//
+//     <report id="my_report" name="My Report" model="Partner" fields="Name,Email"
+//             paper_format="Letter" orientation="landscape">
+//         <sheet name="Contacts" fields="Children.Name,Children.Phone"/>
+//         <h1>{{ .Report.Name }}</h1>
+//     </report>
+//
+// It panics if the paper format is not registered.
+func (rc *Collection) LoadFromEtree(element *etree.Element) {
+	report := Report{
+		ID:          element.SelectAttrValue("id", ""),
+		Name:        element.SelectAttrValue("name", ""),
+		Model:       element.SelectAttrValue("model", ""),
+		PaperFormat: element.SelectAttrValue("paper_format", ""),
+		Landscape:   element.SelectAttrValue("orientation", "portrait") == "landscape",
+	}
+	if _, ok := GetPaperFormat(report.PaperFormat); report.PaperFormat != "" && !ok {
+		log.Panic("Unknown paper format in report", "report_id", report.ID, "paper_format", report.PaperFormat)
+	}
+	report.Fields = splitFields(element.SelectAttrValue("fields", ""))
+	doc := etree.NewDocument()
+	for _, child := range append([]etree.Token{}, element.Copy().Child...) {
+		if elt, ok := child.(*etree.Element); ok && elt.Tag == "sheet" {
+			report.Sheets = append(report.Sheets, Sheet{
+				Name:   elt.SelectAttrValue("name", ""),
+				Fields: splitFields(elt.SelectAttrValue("fields", "")),
+			})
+			continue
+		}
+		doc.AddChild(child)
+	}
+	tmplStr, err := doc.WriteToString()
+	if err != nil {
+		log.Panic("Unable to read report template", "report_id", report.ID, "error", err)
+	}
+	if strings.TrimSpace(tmplStr) != "" {
+		tmpl, err := template.New(report.ID).Parse(tmplStr)
+		if err != nil {
+			log.Panic("Unable to parse report template", "report_id", report.ID, "error", err)
+		}
+		report.Template = tmpl
+	}
+	rc.Add(&report)
+}
+
+// splitFields returns the fields of the given comma separated list
+func splitFields(fields string) []string {
+	var res []string
+	for _, f := range strings.Split(fields, ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			res = append(res, f)
+		}
+	}
+	return res
+}
+
+// LoadFromEtree reads the report definition from the given etree.Element
+// and adds it to the report registry.
+func LoadFromEtree(element *etree.Element) {
+	Registry.LoadFromEtree(element)
+}
@@ -0,0 +1,154 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package reports
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/hexya-erp/hexya/hexya/models"
+	"github.com/hexya-erp/hexya/hexya/tools/xmlutils"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// dummyRecordSet is a RecordSet for testing reports without database
+type dummyRecordSet struct {
+	model string
+	ids   []int64
+}
+
+func (d dummyRecordSet) ModelName() string                    { return d.model }
+func (d dummyRecordSet) Ids() []int64                         { return d.ids }
+func (d dummyRecordSet) Env() models.Environment              { return models.Environment{} }
+func (d dummyRecordSet) Len() int                             { return len(d.ids) }
+func (d dummyRecordSet) IsEmpty() bool                        { return len(d.ids) == 0 }
+func (d dummyRecordSet) Collection() *models.RecordCollection { return nil }
+
+var _ models.RecordSet = dummyRecordSet{}
+
+var reportDef = `
+<report id="partner_report" name="Partner Report" model="Partner" fields="Name, Email">
+	<sheet name="Contacts" fields="Children.Name, Children.Email"/>
+	<h1>{{ .Report.Name }}</h1>
+	<p>{{ .Records.Len }} records</p>
+</report>
+`
+
+func TestReports(t *testing.T) {
+	Convey("Testing reports", t, func() {
+		LoadFromEtree(xmlutils.XMLToElement(reportDef))
+		report := Registry.MustGetByID("partner_report")
+		So(report.Name, ShouldEqual, "Partner Report")
+		So(report.Model, ShouldEqual, "Partner")
+		So(report.Fields, ShouldResemble, []string{"Name", "Email"})
+		So(report.Sheets, ShouldResemble, []Sheet{{Name: "Contacts", Fields: []string{"Children.Name", "Children.Email"}}})
+		So(Registry.GetAllForModel("Partner"), ShouldHaveLength, 1)
+		So(func() { Registry.MustGetByID("unknown_report") }, ShouldPanic)
+		rs := dummyRecordSet{model: "Partner", ids: []int64{1, 2}}
+		Convey("Rendering to HTML", func() {
+			var buf bytes.Buffer
+			contentType, err := report.Render(rs, FormatHTML, &buf)
+			So(err, ShouldBeNil)
+			So(contentType, ShouldStartWith, "text/html")
+			So(buf.String(), ShouldContainSubstring, "<h1>Partner Report</h1>")
+			So(buf.String(), ShouldContainSubstring, "<p>2 records</p>")
+			So(buf.String(), ShouldNotContainSubstring, "sheet")
+		})
+		Convey("Rendering to PDF", func() {
+			var (
+				cmdName  string
+				cmdArgs  []string
+				cmdInput string
+			)
+			origRunCommand := runCommand
+			defer func() {
+				runCommand = origRunCommand
+				PDFEngineInUse = Wkhtmltopdf
+				report.Landscape = false
+			}()
+			runCommand = func(name string, args []string, stdin io.Reader, stdout io.Writer) error {
+				cmdName, cmdArgs = name, args
+				if stdin != nil {
+					input, _ := ioutil.ReadAll(stdin)
+					cmdInput = string(input)
+				}
+				for _, arg := range args {
+					if strings.HasPrefix(arg, "--print-to-pdf=") {
+						return ioutil.WriteFile(strings.TrimPrefix(arg, "--print-to-pdf="), []byte("%PDF-chromium"), 0600)
+					}
+				}
+				_, err := io.WriteString(stdout, "%PDF-wkhtmltopdf")
+				return err
+			}
+			Convey("With wkhtmltopdf", func() {
+				var buf bytes.Buffer
+				contentType, err := report.Render(rs, FormatPDF, &buf)
+				So(err, ShouldBeNil)
+				So(contentType, ShouldEqual, "application/pdf")
+				So(buf.String(), ShouldEqual, "%PDF-wkhtmltopdf")
+				So(cmdName, ShouldEqual, "wkhtmltopdf")
+				So(strings.Join(cmdArgs, " "), ShouldContainSubstring, "--page-width 210mm --page-height 297mm")
+				So(cmdInput, ShouldContainSubstring, "<h1>Partner Report</h1>")
+				So(cmdInput, ShouldContainSubstring, "size: 210mm 297mm")
+			})
+			Convey("In landscape", func() {
+				report.Landscape = true
+				var buf bytes.Buffer
+				_, err := report.Render(rs, FormatPDF, &buf)
+				So(err, ShouldBeNil)
+				So(strings.Join(cmdArgs, " "), ShouldContainSubstring, "--page-width 297mm --page-height 210mm")
+			})
+			Convey("With headless Chromium", func() {
+				PDFEngineInUse = Chromium
+				var buf bytes.Buffer
+				_, err := report.Render(rs, FormatPDF, &buf)
+				So(err, ShouldBeNil)
+				So(buf.String(), ShouldEqual, "%PDF-chromium")
+				So(cmdName, ShouldEqual, "chromium")
+				So(cmdArgs, ShouldContain, "--headless")
+			})
+			Convey("With paper formats", func() {
+				LoadFromEtree(xmlutils.XMLToElement(`<report id="letter_report" model="Partner" paper_format="Letter" orientation="landscape"><p>Letter</p></report>`))
+				letterReport := Registry.MustGetByID("letter_report")
+				So(letterReport.PaperFormat, ShouldEqual, "Letter")
+				So(letterReport.Landscape, ShouldBeTrue)
+				var buf bytes.Buffer
+				_, err := letterReport.Render(rs, FormatPDF, &buf)
+				So(err, ShouldBeNil)
+				So(strings.Join(cmdArgs, " "), ShouldContainSubstring, "--page-width 279.4mm --page-height 215.9mm")
+				So(func() {
+					LoadFromEtree(xmlutils.XMLToElement(`<report id="bad_report" model="Partner" paper_format="Unknown"/>`))
+				}, ShouldPanic)
+			})
+		})
+		Convey("Rendering with a custom converter", func() {
+			RegisterConverter("txt", "text/plain", func(r *Report, rs models.RecordSet, w io.Writer) error {
+				_, err := fmt.Fprintf(w, "%s: %v", r.Name, rs.Ids())
+				return err
+			})
+			So(Formats(), ShouldContain, Format("txt"))
+			var buf bytes.Buffer
+			contentType, err := report.Render(rs, "txt", &buf)
+			So(err, ShouldBeNil)
+			So(contentType, ShouldEqual, "text/plain")
+			So(buf.String(), ShouldEqual, "Partner Report: [1 2]")
+		})
+		Convey("Rendering errors", func() {
+			var buf bytes.Buffer
+			_, err := report.Render(rs, "unknown", &buf)
+			So(err, ShouldNotBeNil)
+			_, err = report.Render(dummyRecordSet{model: "User"}, FormatHTML, &buf)
+			So(err, ShouldNotBeNil)
+			RegisterConverter("panic", "text/plain", func(r *Report, rs models.RecordSet, w io.Writer) error {
+				panic("converter failure")
+			})
+			_, err = report.Render(rs, "panic", &buf)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
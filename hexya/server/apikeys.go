@@ -0,0 +1,70 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/hexya-erp/hexya/hexya/models"
+	"github.com/hexya-erp/hexya/hexya/models/security"
+)
+
+// Keys of the Context holding the user and the
+// scopes of the API key of the request.
+const (
+	apiKeyUIDKey    = "hexya_api_key_uid"
+	apiKeyScopesKey = "hexya_api_key_scopes"
+)
+
+// handleAPIKeys is the middleware which authenticates the requests with an
+// "Authorization: Bearer <key>" header with the API key of the header.
+// Requests with an invalid, revoked or expired key are aborted with a 401
+// Unauthorized status.
+//
+// It is only set on the routes of the JSON-RPC API, which restricts the
+// methods that can be called to the scopes of the key. Other routes are not
+// authenticated by API keys, since their scopes could not be enforced.
+func handleAPIKeys(c *Context) {
+	auth := c.GetHeader("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return
+	}
+	uid, scopes, ok := checkAPIKey(strings.TrimPrefix(auth, "Bearer "))
+	if !ok {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+	c.Set(apiKeyUIDKey, uid)
+	c.Set(apiKeyScopesKey, scopes)
+}
+
+// checkAPIKey returns the id of the user and the scopes of the given API
+// key. It returns false if the key is invalid, revoked or expired.
+func checkAPIKey(key string) (int64, models.APIScopes, bool) {
+	if !strings.HasPrefix(key, models.APIKeyPrefix) {
+		return 0, nil, false
+	}
+	var (
+		uid    int64
+		scopes models.APIScopes
+		ok     bool
+	)
+	err := models.ExecuteInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+		uid, scopes, ok = env.CheckAPIKey(key)
+	})
+	if err != nil {
+		log.Warn("Unable to check API key", "error", err)
+		return 0, nil, false
+	}
+	return uid, scopes, ok
+}
+
+// APIScopes returns the scopes of the API key which authenticates this
+// request, or nil if the request is not authenticated by an API key.
+func (c *Context) APIScopes() models.APIScopes {
+	scopes, _ := c.Get(apiKeyScopesKey)
+	res, _ := scopes.(models.APIScopes)
+	return res
+}
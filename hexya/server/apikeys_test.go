@@ -0,0 +1,59 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/hexya-erp/hexya/hexya/models"
+	"github.com/hexya-erp/hexya/hexya/models/security"
+	"github.com/hexya-erp/hexya/hexya/models/types/dates"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// rpcRequest returns a JSON-RPC request calling the given
+// method of the given model with the given API key.
+func rpcRequest(key, model, method string) *http.Request {
+	body := fmt.Sprintf(`{"jsonrpc": "2.0", "id": 1, "params": {"model": "%s", "method": "%s", "args": [[]]}}`, model, method)
+	req, _ := http.NewRequest(http.MethodPost, RPCPath, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+key)
+	return req
+}
+
+func TestAPIKeys(t *testing.T) {
+	Convey("Testing API keys authentication", t, func() {
+		var key string
+		So(models.ExecuteInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			key = env.CreateAPIKey("Test key", dates.DateTime{}, "Currency.SearchCount")
+		}), ShouldBeNil)
+		Convey("A scoped key should call the methods of its scopes", func() {
+			w := performRequest(rpcRequest(key, "Currency", "SearchCount"))
+			So(w.Code, ShouldEqual, http.StatusOK)
+			So(w.Body.String(), ShouldContainSubstring, `"result"`)
+			So(w.Body.String(), ShouldNotContainSubstring, `"error"`)
+		})
+		Convey("A scoped key should be refused outside its scopes", func() {
+			w := performRequest(rpcRequest(key, "Currency", "FieldsGet"))
+			So(w.Code, ShouldEqual, http.StatusOK)
+			So(w.Body.String(), ShouldContainSubstring, `"error"`)
+			So(w.Body.String(), ShouldNotContainSubstring, `"result"`)
+			w = performRequest(rpcRequest(key, "APIKey", "SearchCount"))
+			So(w.Body.String(), ShouldContainSubstring, `"error"`)
+		})
+		Convey("Invalid keys should be refused", func() {
+			w := performRequest(rpcRequest(models.APIKeyPrefix+"0123456789abcdef0123", "Currency", "SearchCount"))
+			So(w.Code, ShouldEqual, http.StatusUnauthorized)
+		})
+		Convey("API keys should not authenticate other routes", func() {
+			req, _ := http.NewRequest(http.MethodGet, testEnvPath, nil)
+			req.Header.Set("Authorization", "Bearer "+key)
+			w := performRequest(req)
+			So(w.Code, ShouldEqual, http.StatusUnauthorized)
+		})
+	})
+}
@@ -0,0 +1,121 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package server
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hexya-erp/hexya/hexya/models"
+	"github.com/hexya-erp/hexya/hexya/models/security"
+	"github.com/hexya-erp/hexya/hexya/models/types"
+)
+
+// AuthPath is the path prefix of the authentication endpoints:
+//   - POST <AuthPath>/login logs the user in with a login and a password,
+//   - POST <AuthPath>/logout logs the user out,
+//   - POST <AuthPath>/reset_password/request sends a password reset mail,
+//   - POST <AuthPath>/reset_password sets a new password with a reset token.
+//
+// Set it to an empty string before PostInit to disable them.
+var AuthPath = "/web"
+
+// A loginRequest is the body of a login request
+type loginRequest struct {
+	Login    string `json:"login" form:"login" binding:"required"`
+	Password string `json:"password" form:"password" binding:"required"`
+}
+
+// A resetRequest is the body of a password reset request
+type resetRequest struct {
+	Login string `json:"login" form:"login" binding:"required"`
+}
+
+// A resetPasswordRequest is the body of a request setting a new password
+type resetPasswordRequest struct {
+	Token    string `json:"token" form:"token" binding:"required"`
+	Password string `json:"password" form:"password" binding:"required"`
+}
+
+// registerAuthRoutes creates the routes of the authentication endpoints
+func registerAuthRoutes() {
+	if AuthPath == "" {
+		return
+	}
+	root := hexyaServer.Group("/")
+	root.POST(AuthPath+"/login", handleLogin)
+	root.POST(AuthPath+"/logout", handleLogout)
+	root.POST(AuthPath+"/reset_password/request", handleResetRequest)
+	root.POST(AuthPath+"/reset_password", handleResetPassword)
+}
+
+// handleLogin authenticates the user with the login and password of the
+// request with the backends of security.AuthenticationRegistry, and binds
+// the session to this user. It returns the id of the user on success and
+// a 401 Unauthorized status otherwise.
+func handleLogin(c *Context) {
+	var req loginRequest
+	if err := c.Bind(&req); err != nil {
+		return
+	}
+	uid, err := security.AuthenticationRegistry.Authenticate(req.Login, req.Password, types.NewContext())
+	if err != nil {
+		log.Info("Failed login", "login", req.Login, "ip", c.ClientIP())
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+	if err = c.Login(uid); err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"uid": uid})
+}
+
+// handleLogout removes the session of the request
+func handleLogout(c *Context) {
+	if err := c.Logout(); err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// handleResetRequest sends a password reset mail to the user with the
+// login of the request. It always succeeds, so that it does not disclose
+// which logins exist.
+func handleResetRequest(c *Context) {
+	var req resetRequest
+	if err := c.Bind(&req); err != nil {
+		return
+	}
+	err := models.ExecuteInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+		env.RequestPasswordReset(req.Login)
+	})
+	if err != nil {
+		log.Warn("Unable to request password reset", "login", req.Login, "error", err)
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// handleResetPassword sets the password of the request for the user to whom
+// the token of the request has been sent. Invalid or expired tokens are
+// answered with a 400 Bad Request status.
+func handleResetPassword(c *Context) {
+	var req resetPasswordRequest
+	if err := c.Bind(&req); err != nil {
+		return
+	}
+	var ok bool
+	err := models.ExecuteInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+		ok = env.ResetPassword(req.Token, req.Password)
+	})
+	switch {
+	case err != nil:
+		c.AbortWithError(http.StatusInternalServerError, err)
+	case !ok:
+		c.AbortWithStatus(http.StatusBadRequest)
+	default:
+		c.Status(http.StatusNoContent)
+	}
+}
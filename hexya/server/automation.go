@@ -0,0 +1,25 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package server
+
+import (
+	"time"
+
+	"github.com/hexya-erp/hexya/hexya/models"
+)
+
+// AutomationPollInterval is the time between two runs of the time
+// based automation rules by the automation worker.
+var AutomationPollInterval = time.Minute
+
+func init() {
+	RegisterPollingWorker(RoleCron, "automation rules", &AutomationPollInterval, processAutomationRules)
+}
+
+// processAutomationRules runs the time based automation rules
+func processAutomationRules() {
+	if count := models.ProcessAutomationRules(); count > 0 {
+		log.Debug("Automation rules run", "count", count)
+	}
+}
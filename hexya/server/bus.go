@@ -0,0 +1,105 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package server
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/hexya-erp/hexya/hexya/bus"
+)
+
+// BusPath is the path of the longpolling endpoint with which clients get
+// the messages of the bus. Clients POST a JSON object with the channels
+// they subscribe to and the id of the last message they received:
+//
+//	{"channels": ["broadcast", "user,1"], "last": 42}
+//
+// and get the messages received since then, waiting for at most
+// PollTimeout if there is none yet:
+//
+//	{"last": 43, "messages": [{"id": 43, "channel": "user,1", "payload": {...}, "time": "..."}]}
+//
+// Clients that do not give a last id, or a last id unknown to this instance
+// because it has been restarted, get the id to start polling from
+// immediately, without messages. Message ids are specific to each instance,
+// so that clients of multi-instance deployments must always poll the same
+// instance.
+//
+// Set it to an empty string before PostInit to disable it.
+var BusPath = "/longpolling/poll"
+
+// PollTimeout is the maximum duration during which
+// the bus endpoint waits for new messages.
+var PollTimeout = 50 * time.Second
+
+// A busPollRequest is the body of a request to the bus endpoint
+type busPollRequest struct {
+	Channels []string `json:"channels"`
+	Last     *int64   `json:"last"`
+}
+
+// A busPollResponse is the body of the response of the bus endpoint
+type busPollResponse struct {
+	Last     int64         `json:"last"`
+	Messages []bus.Message `json:"messages"`
+}
+
+// registerBusRoutes creates the route of the bus endpoint
+func registerBusRoutes() {
+	if BusPath == "" {
+		return
+	}
+	root := hexyaServer.Group("/")
+	root.POST(BusPath, handleBusPoll)
+}
+
+// handleBusPoll returns the messages of the bus on the channels of the
+// request. It is not wrapped by WithEnvironment so as not to hold a database
+// transaction while waiting. Requests without authenticated user are aborted
+// with a 401 Unauthorized status, and requests subscribing to channels that
+// the user may not subscribe to with a 403 Forbidden status.
+func handleBusPoll(c *Context) {
+	uid := c.UID()
+	if uid == 0 {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+	var req busPollRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+	for _, channel := range req.Channels {
+		if !bus.Registry.CanSubscribe(uid, channel) {
+			c.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+	}
+	if req.Last == nil || *req.Last > bus.Registry.LastID() {
+		c.JSON(http.StatusOK, busPollResponse{Last: bus.Registry.LastID(), Messages: []bus.Message{}})
+		return
+	}
+	// Polls return immediately when the server stops
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	defer cancel()
+	go func() {
+		select {
+		case <-hexyaServer.Stopping():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	resp := busPollResponse{
+		Last:     *req.Last,
+		Messages: bus.Registry.Poll(ctx, req.Channels, *req.Last, PollTimeout),
+	}
+	for _, msg := range resp.Messages {
+		if msg.ID > resp.Last {
+			resp.Last = msg.ID
+		}
+	}
+	c.JSON(http.StatusOK, resp)
+}
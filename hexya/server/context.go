@@ -4,14 +4,19 @@
 package server
 
 import (
+	"crypto/sha1"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/contrib/sessions"
 	"github.com/gin-gonic/gin"
+	"github.com/hexya-erp/hexya/hexya/models"
+	"github.com/hexya-erp/hexya/hexya/models/types/dates"
 	"github.com/hexya-erp/hexya/hexya/tools/exceptions"
 )
 
@@ -63,7 +68,15 @@ func (c *Context) RPC(code int, obj interface{}, err ...error) {
 }
 
 // BindRPCParams binds the RPC parameters to the given data object.
+//
+// Each call is counted in the models.QuotaAPICalls quota. The request is
+// aborted with 429 Too Many Requests if the quota is exceeded.
 func (c *Context) BindRPCParams(data interface{}) {
+	if err := models.CheckCounterQuota(models.QuotaAPICalls, 1); err != nil {
+		c.AbortWithError(http.StatusTooManyRequests, err)
+		return
+	}
+	models.AddQuotaUsage(models.QuotaAPICalls, 1)
 	var req RequestRPC
 	if err := c.BindJSON(&req); err != nil {
 		c.AbortWithError(http.StatusBadRequest, err)
@@ -105,3 +118,57 @@ func (c *Context) HTTPGet(uri string) (*http.Response, error) {
 	client := http.Client{}
 	return client.Do(req)
 }
+
+// RecordsNotModified sets the ETag and Last-Modified headers of the response
+// from the last update dates of the records of rs, and checks them against the
+// If-None-Match and If-Modified-Since headers of the request.
+//
+// If the client's copy of the records is still fresh, the response is aborted
+// with a 304 Not Modified status and RecordsNotModified returns true. In this case,
+// the handler should return immediately:
+//
+//     if c.RecordsNotModified(rs) {
+//         return
+//     }
+func (c *Context) RecordsNotModified(rs models.RecordSet) bool {
+	rc := rs.Collection()
+	if _, exists := rc.Model().Fields().Get("LastUpdate"); !exists {
+		// This model does not track modifications
+		return false
+	}
+	var lastModified time.Time
+	hash := sha1.New()
+	fmt.Fprint(hash, rc.ModelName())
+	for _, rec := range rc.Records() {
+		lastUpdate := rec.Get("LastUpdate").(dates.DateTime).Time
+		if lastUpdate.After(lastModified) {
+			lastModified = lastUpdate
+		}
+		fmt.Fprintf(hash, ",%d:%d", rec.Ids()[0], lastUpdate.UnixNano())
+	}
+	etag := fmt.Sprintf(`W/"%x"`, hash.Sum(nil))
+	c.Header("ETag", etag)
+	if !lastModified.IsZero() {
+		c.Header("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+
+	if inm := c.Request.Header.Get("If-None-Match"); inm != "" {
+		// If-None-Match takes precedence over If-Modified-Since (RFC 7232)
+		for _, tag := range strings.Split(inm, ",") {
+			tag = strings.TrimSpace(tag)
+			if tag == etag || tag == "*" {
+				c.AbortWithStatus(http.StatusNotModified)
+				return true
+			}
+		}
+		return false
+	}
+	if ims := c.Request.Header.Get("If-Modified-Since"); ims != "" && !lastModified.IsZero() {
+		since, err := http.ParseTime(ims)
+		if err == nil && !lastModified.Truncate(time.Second).After(since) {
+			c.AbortWithStatus(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
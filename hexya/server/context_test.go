@@ -0,0 +1,66 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hexya-erp/hexya/hexya/models"
+	"github.com/hexya-erp/hexya/hexya/models/security"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRecordsNotModified(t *testing.T) {
+	Convey("Testing conditional requests on records", t, func() {
+		So(models.ExecuteInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			env.Pool("Currency").Call("Create", models.FieldMap{"Name": "XTS", "Symbol": "¤"})
+		}), ShouldBeNil)
+		Reset(func() {
+			models.ExecuteInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+				env.Pool("Currency").Search(env.Pool("Currency").Model().Field("Name").Equals("XTS")).Call("Unlink")
+			})
+		})
+		cookie := loginCookie()
+		get := func(header, value string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(http.MethodGet, testRecordsPath, nil)
+			req.AddCookie(cookie)
+			if header != "" {
+				req.Header.Set(header, value)
+			}
+			return performRequest(req)
+		}
+		w := get("", "")
+		So(w.Code, ShouldEqual, http.StatusOK)
+		etag := w.Header().Get("ETag")
+		lastModified := w.Header().Get("Last-Modified")
+		So(etag, ShouldStartWith, `W/"`)
+		So(lastModified, ShouldNotBeBlank)
+		Convey("Fresh copies should get a 304 status", func() {
+			w = get("If-None-Match", etag)
+			So(w.Code, ShouldEqual, http.StatusNotModified)
+			So(w.Body.Len(), ShouldEqual, 0)
+			w = get("If-None-Match", `W/"other", `+etag)
+			So(w.Code, ShouldEqual, http.StatusNotModified)
+			w = get("If-Modified-Since", lastModified)
+			So(w.Code, ShouldEqual, http.StatusNotModified)
+		})
+		Convey("Stale copies should get a 200 status", func() {
+			w = get("If-None-Match", `W/"other"`)
+			So(w.Code, ShouldEqual, http.StatusOK)
+			w = get("If-Modified-Since", time.Now().Add(-24*time.Hour).UTC().Format(http.TimeFormat))
+			So(w.Code, ShouldEqual, http.StatusOK)
+		})
+		Convey("Modified records should get a 200 status and a new ETag", func() {
+			So(models.ExecuteInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+				env.Pool("Currency").Search(env.Pool("Currency").Model().Field("Name").Equals("XTS")).Set("Symbol", "X")
+			}), ShouldBeNil)
+			w = get("If-None-Match", etag)
+			So(w.Code, ShouldEqual, http.StatusOK)
+			So(w.Header().Get("ETag"), ShouldNotEqual, etag)
+		})
+	})
+}
@@ -0,0 +1,59 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package server
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/hexya-erp/hexya/hexya/models"
+)
+
+// CronPollInterval is the time between two checks for due cron jobs
+// by the cron worker.
+var CronPollInterval = time.Minute
+
+// CronLivenessTimeout is the time after which the cron worker is reported
+// as stuck by the liveness endpoint if it has not started a new round of
+// cron jobs. It must be longer than the longest cron job.
+var CronLivenessTimeout = 30 * time.Minute
+
+// cronHeartbeat is the time in nanoseconds since the epoch at which the cron
+// worker last started a round of cron jobs, or 0 if it is not running.
+var cronHeartbeat int64
+
+func init() {
+	RegisterWorker(RoleCron, "cron", runCronJobs)
+}
+
+// runCronJobs runs the due cron jobs every CronPollInterval
+// until the stop channel is closed.
+func runCronJobs(stop <-chan struct{}) {
+	defer atomic.StoreInt64(&cronHeartbeat, 0)
+	poll(stop, CronPollInterval, processCronJobs)
+}
+
+// processCronJobs runs the due cron jobs
+func processCronJobs() {
+	atomic.StoreInt64(&cronHeartbeat, time.Now().UnixNano())
+	if count := models.ProcessCronJobs(); count > 0 {
+		log.Debug("Cron jobs processed", "count", count)
+	}
+}
+
+// checkCronRunner fails if the cron worker has not started a round
+// of cron jobs for more than CronPollInterval + CronLivenessTimeout.
+func checkCronRunner(ctx context.Context) error {
+	heartbeat := atomic.LoadInt64(&cronHeartbeat)
+	if heartbeat == 0 {
+		// The cron worker is not running in this process
+		return nil
+	}
+	if since := time.Since(time.Unix(0, heartbeat)); since > CronPollInterval+CronLivenessTimeout {
+		return fmt.Errorf("cron worker stuck for %s", since.Round(time.Second))
+	}
+	return nil
+}
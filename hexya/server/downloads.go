@@ -0,0 +1,25 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package server
+
+import (
+	"time"
+
+	"github.com/hexya-erp/hexya/hexya/models"
+)
+
+// DownloadPollInterval is the time between two checks for pending
+// background downloads by the downloads worker.
+var DownloadPollInterval = 5 * time.Second
+
+func init() {
+	RegisterPollingWorker(RoleJobRunner, "downloads", &DownloadPollInterval, processDownloads)
+}
+
+// processDownloads generates the pending downloads
+func processDownloads() {
+	if count := models.ProcessDownloads(); count > 0 {
+		log.Debug("Downloads processed", "count", count)
+	}
+}
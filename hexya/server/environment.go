@@ -0,0 +1,89 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package server
+
+import (
+	"net/http"
+
+	"github.com/hexya-erp/hexya/hexya/models"
+)
+
+// SessionUIDKey is the session key holding the id
+// of the user authenticated in this session.
+const SessionUIDKey = "uid"
+
+// envContextKey is the key of the Context holding
+// the models.Environment of the request.
+const envContextKey = "hexya_env"
+
+// UID returns the id of the user authenticated by the API key or in the
+// session of this request, or 0 if there is no authenticated user. API
+// keys only authenticate the requests of the JSON-RPC API.
+func (c *Context) UID() int64 {
+	if uid, ok := c.Get(apiKeyUIDKey); ok {
+		return uid.(int64)
+	}
+	uid, _ := c.Session().Get(SessionUIDKey).(int64)
+	return uid
+}
+
+// Env returns the models.Environment of this request. It is only available
+// in handlers wrapped by WithEnvironment, such as those registered with
+// RegisterRoute. It panics otherwise.
+func (c *Context) Env() models.Environment {
+	env, ok := c.Get(envContextKey)
+	if !ok {
+		log.Panic("No environment in this request", "path", c.Request.URL.Path)
+	}
+	return env.(models.Environment)
+}
+
+// WithEnvironment returns a HandlerFunc that calls the given handler in a
+// new models.Environment bound to the user authenticated in the session. The
+// Environment is available to the handler through the Env method of its
+// Context.
+//
+// The transaction of the Environment is committed after the handler returns,
+// unless the handler aborted the request with an error status or panicked,
+// in which cases it is rolled back. Requests without authenticated user are
+// aborted with a 401 Unauthorized status.
+//
+// Database serialization errors are retried by calling the handler again, so
+// handlers should only write the response once their database work is done.
+func WithEnvironment(handler HandlerFunc) HandlerFunc {
+	return func(c *Context) {
+		uid := c.UID()
+		if uid == 0 {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		err := models.ExecuteOrRollbackInNewEnvironment(uid, func(env models.Environment) bool {
+			c.Set(envContextKey, env)
+			handler(c)
+			return !c.IsAborted() || c.Writer.Status() < http.StatusBadRequest
+		})
+		if err != nil && !c.Writer.Written() {
+			c.AbortWithError(http.StatusInternalServerError, err)
+		}
+	}
+}
+
+// RegisterRoute registers the given handler for GET and POST requests on
+// the given path of the server. The handler is wrapped by WithEnvironment,
+// so that it is called in a transaction bound to the authenticated user:
+//
+//	server.RegisterRoute("/web/dataset/call", func(c *server.Context) {
+//		partners := h.Partner().NewSet(c.Env()).SearchAll()
+//		c.JSON(http.StatusOK, partners.Ids())
+//	})
+//
+// This function should be called in the init() or PreInit() function of the
+// modules. It panics if a route already exists for the given path. Routes that
+// must be inheritable by other modules should be declared in the controllers
+// package instead.
+func RegisterRoute(relativePath string, handler HandlerFunc) {
+	root := hexyaServer.Group("/")
+	root.GET(relativePath, WithEnvironment(handler))
+	root.POST(relativePath, WithEnvironment(handler))
+}
@@ -0,0 +1,318 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package server
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+
+	"github.com/hexya-erp/hexya/hexya/models"
+	"github.com/hexya-erp/hexya/hexya/models/security"
+	"github.com/hexya-erp/hexya/hexya/models/types"
+	"github.com/hexya-erp/hexya/hexya/tools/exceptions"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// GRPCServiceName is the full name of the gRPC service of model operations,
+// as declared in hexya.proto.
+const GRPCServiceName = "hexya.Models"
+
+// GRPCExportBatchSize is the default number of records read at once
+// by the Export streaming RPC.
+var GRPCExportBatchSize = 1000
+
+// grpcMethods maps the unary RPCs of the gRPC service to
+// the methods of the external API which implement them.
+var grpcMethods = map[string]string{
+	"Search": "search",
+	"Read":   "read",
+	"Create": "create",
+	"Write":  "write",
+	"Unlink": "unlink",
+}
+
+// NewGRPCServer returns a new gRPC server with the given options, on which the
+// service of model operations is registered. It is meant to be served on its
+// own port alongside the HTTP server.
+//
+// All RPCs take a google.protobuf.Struct request with the name of the model
+// and the parameters of the operation, and are executed as the user
+// authenticated by the "authorization" metadata of the call, which must
+// hold HTTP basic credentials or a bearer API key.
+func NewGRPCServer(opts ...grpc.ServerOption) *grpc.Server {
+	srv := grpc.NewServer(opts...)
+	desc := grpc.ServiceDesc{
+		ServiceName: GRPCServiceName,
+		HandlerType: (*interface{})(nil),
+		Methods: []grpc.MethodDesc{
+			{MethodName: "CallMethod", Handler: grpcUnaryHandler("CallMethod")},
+		},
+		Streams: []grpc.StreamDesc{
+			{StreamName: "Export", Handler: handleGRPCExport, ServerStreams: true},
+		},
+		Metadata: "hexya.proto",
+	}
+	for name := range grpcMethods {
+		desc.Methods = append(desc.Methods, grpc.MethodDesc{MethodName: name, Handler: grpcUnaryHandler(name)})
+	}
+	srv.RegisterService(&desc, struct{}{})
+	return srv
+}
+
+// grpcUnaryHandler returns the handler of the unary RPC with the given name
+func grpcUnaryHandler(name string) func(interface{}, context.Context, func(interface{}) error, grpc.UnaryServerInterceptor) (interface{}, error) {
+	return func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+		req := new(structpb.Struct)
+		if err := dec(req); err != nil {
+			return nil, err
+		}
+		handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+			return executeGRPC(ctx, name, req.(*structpb.Struct))
+		}
+		if interceptor == nil {
+			return handler(ctx, req)
+		}
+		info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + GRPCServiceName + "/" + name}
+		return interceptor(ctx, req, info, handler)
+	}
+}
+
+// executeGRPC executes the unary RPC with the given name and
+// request, and returns its result as a google.protobuf.Value.
+func executeGRPC(ctx context.Context, name string, req *structpb.Struct) (*structpb.Value, error) {
+	uid, scopes, err := grpcUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+	params := req.AsMap()
+	modelName, _ := params["model"].(string)
+	method, args, kwargs := grpcArguments(name, params)
+	if err := models.CheckCounterQuota(models.QuotaAPICalls, 1); err != nil {
+		return nil, status.Error(codes.ResourceExhausted, err.Error())
+	}
+	models.AddQuotaUsage(models.QuotaAPICalls, 1)
+	var (
+		result  interface{}
+		missing bool
+	)
+	err = traceRPC(grpcTraceContext(ctx), "grpc", modelName, method, func() error {
+		return models.ExecuteInNewEnvironment(uid, func(env models.Environment) {
+			if scopes.Allows(modelName, method) {
+				if missing = grpcMissingRecords(env, modelName, grpcList(params["ids"])); missing {
+					return
+				}
+			}
+			result = env.WithAPIScopes(scopes).ExecuteKW(modelName, method, args, kwargs)
+		})
+	})
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	if missing {
+		return nil, status.Error(codes.NotFound, "Records do not exist or have been deleted")
+	}
+	return grpcValue(result)
+}
+
+// grpcMissingRecords returns true if some of the records of the given model
+// with the given ids do not exist or cannot be seen by the user of env.
+//
+// It must only be called if the API key of the call allows the method,
+// so that records of other models cannot be probed.
+func grpcMissingRecords(env models.Environment, modelName string, ids []interface{}) bool {
+	if len(ids) == 0 {
+		return false
+	}
+	requested := make(map[interface{}]bool)
+	for _, id := range ids {
+		requested[id] = true
+	}
+	existing := env.ExecuteKW(modelName, "exists", []interface{}{ids}, nil).([]int64)
+	return len(existing) < len(requested)
+}
+
+// grpcArguments returns the external API method, positional arguments
+// and keyword arguments of the RPC with the given name and parameters.
+func grpcArguments(name string, params map[string]interface{}) (string, []interface{}, map[string]interface{}) {
+	kwargs := make(map[string]interface{})
+	if ctx, ok := params["context"]; ok {
+		kwargs["context"] = ctx
+	}
+	switch name {
+	case "Search":
+		for _, key := range []string{"offset", "limit", "order"} {
+			if value, ok := params[key]; ok {
+				kwargs[key] = value
+			}
+		}
+		return grpcMethods[name], []interface{}{grpcList(params["domain"])}, kwargs
+	case "Read":
+		return grpcMethods[name], []interface{}{grpcList(params["ids"]), grpcList(params["fields"])}, kwargs
+	case "Create":
+		return grpcMethods[name], []interface{}{params["values"]}, kwargs
+	case "Write":
+		return grpcMethods[name], []interface{}{grpcList(params["ids"]), params["values"]}, kwargs
+	case "Unlink":
+		return grpcMethods[name], []interface{}{grpcList(params["ids"])}, kwargs
+	}
+	method, _ := params["method"].(string)
+	args := append([]interface{}{grpcList(params["ids"])}, grpcList(params["args"])...)
+	return method, args, kwargs
+}
+
+// handleGRPCExport serves the Export streaming RPC, which sends the records
+// matching the domain of the request one by one, with the given fields.
+// Records are read by batches of batch_size records, which defaults to
+// GRPCExportBatchSize, in a single transaction.
+func handleGRPCExport(srv interface{}, stream grpc.ServerStream) error {
+	uid, scopes, err := grpcUser(stream.Context())
+	if err != nil {
+		return err
+	}
+	req := new(structpb.Struct)
+	if err = stream.RecvMsg(req); err != nil {
+		return err
+	}
+	params := req.AsMap()
+	modelName, _ := params["model"].(string)
+	batchSize := GRPCExportBatchSize
+	if size, ok := params["batch_size"].(float64); ok && size > 0 {
+		batchSize = int(size)
+	}
+	kwargs := make(map[string]interface{})
+	if ctx, ok := params["context"]; ok {
+		kwargs["context"] = ctx
+	}
+	if err = models.CheckCounterQuota(models.QuotaAPICalls, 1); err != nil {
+		return status.Error(codes.ResourceExhausted, err.Error())
+	}
+	models.AddQuotaUsage(models.QuotaAPICalls, 1)
+	var sendErr error
+	err = traceRPC(grpcTraceContext(stream.Context()), "grpc", modelName, "Export", func() error {
+		return models.ExecuteInNewEnvironment(uid, func(env models.Environment) {
+			env = env.WithAPIScopes(scopes)
+			ids := env.ExecuteKW(modelName, "search", []interface{}{grpcList(params["domain"])}, kwargs).([]int64)
+			for start := 0; start < len(ids) && sendErr == nil; start += batchSize {
+				end := start + batchSize
+				if end > len(ids) {
+					end = len(ids)
+				}
+				records := env.ExecuteKW(modelName, "read", []interface{}{ids[start:end], grpcList(params["fields"])}, kwargs)
+				value, err := grpcValue(records)
+				if err != nil {
+					sendErr = err
+					return
+				}
+				for _, rec := range value.GetListValue().GetValues() {
+					if sendErr = stream.SendMsg(rec.GetStructValue()); sendErr != nil {
+						return
+					}
+				}
+			}
+		})
+	})
+	if err != nil {
+		return grpcError(err)
+	}
+	return sendErr
+}
+
+// grpcUser returns the id of the user authenticated by the basic credentials
+// or the API key of the "authorization" metadata of the call with the given
+// context, and the scopes of the API key.
+func grpcUser(ctx context.Context) (int64, models.APIScopes, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, auth := range md.Get("authorization") {
+		if strings.HasPrefix(auth, "Bearer ") {
+			uid, scopes, ok := checkAPIKey(strings.TrimPrefix(auth, "Bearer "))
+			if !ok {
+				break
+			}
+			return uid, scopes, nil
+		}
+		if !strings.HasPrefix(auth, "Basic ") {
+			continue
+		}
+		data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(auth, "Basic "))
+		if err != nil {
+			break
+		}
+		creds := strings.SplitN(string(data), ":", 2)
+		if len(creds) != 2 {
+			break
+		}
+		uid, err := security.AuthenticationRegistry.Authenticate(creds[0], creds[1], types.NewContext())
+		if err != nil {
+			break
+		}
+		return uid, nil, nil
+	}
+	return 0, nil, status.Error(codes.Unauthenticated, "Access Denied")
+}
+
+// grpcErrorCodes are the gRPC status codes of the errors
+// whose messages start with the given strings.
+var grpcErrorCodes = []struct {
+	prefix string
+	code   codes.Code
+}{
+	{"You are not allowed", codes.PermissionDenied},
+	{"This API key does not allow", codes.PermissionDenied},
+	{"Unknown model", codes.NotFound},
+	{"Unknown method in model", codes.NotFound},
+	{"Unknown or private method in model", codes.NotFound},
+	{"Unknown external ID", codes.NotFound},
+}
+
+// grpcError returns the gRPC status error of the given error
+// returned by the execution of an RPC.
+//
+// Access errors are returned with the PermissionDenied code and unknown
+// models and methods with the NotFound code. Other user errors, such as
+// invalid arguments or failed constraints, are returned with the
+// InvalidArgument code and other errors with the Unknown code.
+func grpcError(err error) error {
+	userError, ok := err.(exceptions.UserError)
+	if !ok {
+		return status.Error(codes.Unknown, err.Error())
+	}
+	for _, ec := range grpcErrorCodes {
+		if strings.HasPrefix(userError.Message, ec.prefix) {
+			return status.Error(ec.code, userError.Message)
+		}
+	}
+	return status.Error(codes.InvalidArgument, userError.Message)
+}
+
+// grpcList returns the given value as a list,
+// or nil if it is not a list.
+func grpcList(value interface{}) []interface{} {
+	list, _ := value.([]interface{})
+	return list
+}
+
+// grpcValue returns the given result as a google.protobuf.Value. The result
+// is first converted to its JSON representation, so that Hexya types are
+// encoded as in the JSON-RPC API.
+func grpcValue(result interface{}) (*structpb.Value, error) {
+	data, err := json.Marshal(result)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	var value interface{}
+	if err = json.Unmarshal(data, &value); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	res, err := structpb.NewValue(value)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return res, nil
+}
@@ -0,0 +1,94 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package server
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"testing"
+
+	"github.com/hexya-erp/hexya/hexya/models"
+	"github.com/hexya-erp/hexya/hexya/models/security"
+	"github.com/hexya-erp/hexya/hexya/models/types/dates"
+	"github.com/hexya-erp/hexya/hexya/tools/exceptions"
+	. "github.com/smartystreets/goconvey/convey"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// grpcContext returns the context of a gRPC call
+// with the given authorization metadata.
+func grpcContext(auth string) context.Context {
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", auth))
+}
+
+// grpcRequest returns the request struct of an RPC with the given parameters.
+func grpcRequest(params map[string]interface{}) *structpb.Struct {
+	req, err := structpb.NewStruct(params)
+	if err != nil {
+		panic(err)
+	}
+	return req
+}
+
+func TestGRPCService(t *testing.T) {
+	Convey("Testing the gRPC service", t, func() {
+		var key string
+		So(models.ExecuteInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			key = env.CreateAPIKey("gRPC key", dates.DateTime{}, "Currency")
+		}), ShouldBeNil)
+		Convey("Calls should be authenticated", func() {
+			_, _, err := grpcUser(context.Background())
+			So(status.Code(err), ShouldEqual, codes.Unauthenticated)
+			_, _, err = grpcUser(grpcContext("Bearer " + models.APIKeyPrefix + "0123456789abcdef0123"))
+			So(status.Code(err), ShouldEqual, codes.Unauthenticated)
+			creds := base64.StdEncoding.EncodeToString([]byte("nobody:wrong"))
+			_, _, err = grpcUser(grpcContext("Basic " + creds))
+			So(status.Code(err), ShouldEqual, codes.Unauthenticated)
+			uid, scopes, err := grpcUser(grpcContext("Bearer " + key))
+			So(err, ShouldBeNil)
+			So(uid, ShouldEqual, security.SuperUserID)
+			So(scopes, ShouldResemble, models.APIScopes{"Currency"})
+			_, err = executeGRPC(context.Background(), "Search", grpcRequest(map[string]interface{}{"model": "Currency"}))
+			So(status.Code(err), ShouldEqual, codes.Unauthenticated)
+		})
+		Convey("Authenticated calls should be executed", func() {
+			res, err := executeGRPC(grpcContext("Bearer "+key), "Search", grpcRequest(map[string]interface{}{
+				"model": "Currency", "domain": []interface{}{}}))
+			So(err, ShouldBeNil)
+			So(res.GetListValue(), ShouldNotBeNil)
+		})
+		Convey("Errors should be returned with their status code", func() {
+			_, err := executeGRPC(grpcContext("Bearer "+key), "Search", grpcRequest(map[string]interface{}{
+				"model": "APIKey", "domain": []interface{}{}}))
+			So(status.Code(err), ShouldEqual, codes.PermissionDenied)
+			_, err = executeGRPC(grpcContext("Bearer "+key), "Read", grpcRequest(map[string]interface{}{
+				"model": "Currency", "ids": []interface{}{999999999}, "fields": []interface{}{"Name"}}))
+			So(status.Code(err), ShouldEqual, codes.NotFound)
+			_, err = executeGRPC(grpcContext("Bearer "+key), "Search", grpcRequest(map[string]interface{}{
+				"model": "NonExistentModel", "domain": []interface{}{}}))
+			So(status.Code(err), ShouldEqual, codes.NotFound)
+			_, err = executeGRPC(grpcContext("Bearer "+key), "Search", grpcRequest(map[string]interface{}{
+				"model": "Currency", "domain": []interface{}{[]interface{}{"no_such_field", "=", "EUR"}}}))
+			So(status.Code(err), ShouldEqual, codes.InvalidArgument)
+		})
+		Convey("Errors should be mapped to status codes", func() {
+			err := grpcError(exceptions.UserError{Message: "You are not allowed to execute this method"})
+			So(status.Code(err), ShouldEqual, codes.PermissionDenied)
+			So(status.Convert(err).Message(), ShouldEqual, "You are not allowed to execute this method")
+			err = grpcError(exceptions.UserError{Message: "This API key does not allow calling this method"})
+			So(status.Code(err), ShouldEqual, codes.PermissionDenied)
+			err = grpcError(exceptions.UserError{Message: "Unknown model"})
+			So(status.Code(err), ShouldEqual, codes.NotFound)
+			err = grpcError(exceptions.UserError{Message: "Missing required key in embedded record"})
+			So(status.Code(err), ShouldEqual, codes.InvalidArgument)
+			err = grpcError(errors.New("connection lost"))
+			So(status.Code(err), ShouldEqual, codes.Unknown)
+			So(status.Convert(err).Message(), ShouldEqual, "connection lost")
+		})
+	})
+}
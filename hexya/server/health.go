@@ -0,0 +1,194 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/hexya-erp/hexya/hexya/models"
+)
+
+// LivenessPath is the path of the liveness endpoint. It returns a HealthReport
+// with a 200 HTTP code if all the liveness checks pass, and 503 otherwise, in
+// which case the process should be restarted.
+const LivenessPath = "/healthz"
+
+// ReadinessPath is the path of the readiness endpoint. It returns a
+// HealthReport with a 200 HTTP code if the instance is ready and all the
+// readiness checks pass, and 503 otherwise, in which case the instance
+// should not receive requests.
+const ReadinessPath = "/readyz"
+
+// HealthCheckTimeout is the time after which a health check that has not
+// returned is reported as failed.
+var HealthCheckTimeout = 5 * time.Second
+
+// A HealthCheck returns an error if the part of the instance it checks is
+// unhealthy. It should return before ctx is done.
+type HealthCheck func(ctx context.Context) error
+
+// Health check results
+const (
+	HealthOK    = "ok"
+	HealthError = "error"
+)
+
+// A HealthReport is the JSON response of the health endpoints
+type HealthReport struct {
+	// Status is HealthOK if all the checks passed and HealthError otherwise
+	Status string `json:"status"`
+	// Startup is the startup status of the instance
+	Startup Status `json:"startup"`
+	// Checks are the results of the checks by name
+	Checks map[string]HealthCheckResult `json:"checks"`
+}
+
+// A HealthCheckResult is the result of a health check
+type HealthCheckResult struct {
+	// Status is HealthOK if the check passed and HealthError otherwise
+	Status string `json:"status"`
+	// Error is the error returned by the check, if any
+	Error string `json:"error,omitempty"`
+	// Duration is the execution time of the check in seconds
+	Duration float64 `json:"duration"`
+}
+
+type namedHealthCheck struct {
+	name  string
+	check HealthCheck
+}
+
+var (
+	livenessChecks  []namedHealthCheck
+	readinessChecks []namedHealthCheck
+)
+
+// RegisterLivenessCheck registers a check of the liveness endpoint with the
+// given name. Liveness checks must only fail if the process cannot recover
+// without being restarted.
+//
+// This function should be called in the init() or PreInit() function of the
+// modules.
+func RegisterLivenessCheck(name string, check HealthCheck) {
+	livenessChecks = append(livenessChecks, namedHealthCheck{name: name, check: check})
+}
+
+// RegisterReadinessCheck registers a check of the readiness endpoint with the
+// given name. Readiness checks are only run once the instance is ready.
+//
+// This function should be called in the init() or PreInit() function of the
+// modules.
+func RegisterReadinessCheck(name string, check HealthCheck) {
+	readinessChecks = append(readinessChecks, namedHealthCheck{name: name, check: check})
+}
+
+func init() {
+	RegisterLivenessCheck("cron", checkCronRunner)
+	RegisterReadinessCheck("database", models.CheckDatabase)
+	RegisterReadinessCheck("migrations", checkMigrations)
+	RegisterReadinessCheck("filestore", func(ctx context.Context) error {
+		return models.CheckFilestore()
+	})
+}
+
+// serveHealth answers the health endpoints. It returns false if the request
+// is not for a health endpoint.
+func serveHealth(w http.ResponseWriter, req *http.Request, currentStatus Status) bool {
+	var report HealthReport
+	switch req.URL.Path {
+	case LivenessPath:
+		report = runHealthChecks(req.Context(), livenessChecks)
+	case ReadinessPath:
+		report = HealthReport{Status: HealthError, Checks: map[string]HealthCheckResult{}}
+		if currentStatus == StatusReady {
+			report = runHealthChecks(req.Context(), readinessChecks)
+		}
+	default:
+		return false
+	}
+	report.Startup = currentStatus
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if report.Status != HealthOK {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(report)
+	return true
+}
+
+// runHealthChecks runs the given checks concurrently and returns their report.
+// Checks that do not return within HealthCheckTimeout fail.
+func runHealthChecks(ctx context.Context, checks []namedHealthCheck) HealthReport {
+	ctx, cancel := context.WithTimeout(ctx, HealthCheckTimeout)
+	defer cancel()
+	report := HealthReport{
+		Status: HealthOK,
+		Checks: make(map[string]HealthCheckResult),
+	}
+	var (
+		wg    sync.WaitGroup
+		mutex sync.Mutex
+	)
+	for _, nc := range checks {
+		wg.Add(1)
+		go func(nc namedHealthCheck) {
+			defer wg.Done()
+			start := time.Now()
+			err := runHealthCheck(ctx, nc.check)
+			result := HealthCheckResult{Status: HealthOK, Duration: time.Since(start).Seconds()}
+			if err != nil {
+				result.Status = HealthError
+				result.Error = err.Error()
+				log.Warn("Health check failed", "check", nc.name, "error", err)
+			}
+			mutex.Lock()
+			defer mutex.Unlock()
+			report.Checks[nc.name] = result
+			if err != nil {
+				report.Status = HealthError
+			}
+		}(nc)
+	}
+	wg.Wait()
+	return report
+}
+
+// runHealthCheck runs the given check and returns its error. It returns
+// the error of ctx if it is done before the check returns, and an error
+// if the check panics.
+func runHealthCheck(ctx context.Context, check HealthCheck) error {
+	res := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				res <- fmt.Errorf("health check panicked: %v", r)
+			}
+		}()
+		res <- check(ctx)
+	}()
+	select {
+	case err := <-res:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// checkMigrations fails if the database is being updated
+func checkMigrations(ctx context.Context) error {
+	held, err := models.MigrationLockHeld(ctx)
+	if err != nil {
+		return err
+	}
+	if held {
+		return errors.New("database is being updated")
+	}
+	return nil
+}
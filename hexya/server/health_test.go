@@ -0,0 +1,78 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hexya-erp/hexya/hexya/models"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// getHealth requests the health endpoint with the given path
+// and returns the response and its decoded HealthReport.
+func getHealth(path string) (*httptest.ResponseRecorder, HealthReport) {
+	w := performRequest(httptest.NewRequest(http.MethodGet, path, nil))
+	var report HealthReport
+	json.Unmarshal(w.Body.Bytes(), &report)
+	return w, report
+}
+
+func TestHealthEndpoints(t *testing.T) {
+	Convey("Testing the health endpoints", t, func() {
+		Convey("Instances with a working database should be ready", func() {
+			w, report := getHealth(ReadinessPath)
+			So(w.Code, ShouldEqual, http.StatusOK)
+			So(w.Header().Get("Cache-Control"), ShouldEqual, "no-store")
+			So(report.Status, ShouldEqual, HealthOK)
+			So(report.Startup, ShouldEqual, StatusReady)
+			So(report.Checks["database"].Status, ShouldEqual, HealthOK)
+			w, report = getHealth(LivenessPath)
+			So(w.Code, ShouldEqual, http.StatusOK)
+			So(report.Status, ShouldEqual, HealthOK)
+		})
+		Convey("Instances whose database is down should not be ready", func() {
+			models.DBClose()
+			Reset(connectTestDB)
+			w, report := getHealth(ReadinessPath)
+			So(w.Code, ShouldEqual, http.StatusServiceUnavailable)
+			So(report.Status, ShouldEqual, HealthError)
+			So(report.Checks["database"].Status, ShouldEqual, HealthError)
+			So(report.Checks["database"].Error, ShouldNotBeBlank)
+			w, _ = getHealth(LivenessPath)
+			So(w.Code, ShouldEqual, http.StatusOK)
+		})
+		Convey("Instances that are starting should not be ready", func() {
+			SetStatus(StatusMigrating)
+			Reset(func() {
+				SetStatus(StatusReady)
+			})
+			w, report := getHealth(ReadinessPath)
+			So(w.Code, ShouldEqual, http.StatusServiceUnavailable)
+			So(report.Startup, ShouldEqual, StatusMigrating)
+			w = performRequest(httptest.NewRequest(http.MethodGet, testEnvPath, nil))
+			So(w.Code, ShouldEqual, http.StatusServiceUnavailable)
+		})
+		Convey("The router should only be used once it is enabled", func() {
+			srv := &Server{Engine: gin.New()}
+			srv.GET("/test", func(c *gin.Context) {
+				c.Status(http.StatusOK)
+			})
+			w := httptest.NewRecorder()
+			srv.ServeHTTP(w, httptest.NewRequest(http.MethodGet, ReadinessPath, nil))
+			So(w.Code, ShouldEqual, http.StatusOK)
+			w = httptest.NewRecorder()
+			srv.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/test", nil))
+			So(w.Code, ShouldEqual, http.StatusServiceUnavailable)
+			srv.EnableRouter()
+			w = httptest.NewRecorder()
+			srv.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/test", nil))
+			So(w.Code, ShouldEqual, http.StatusOK)
+		})
+	})
+}
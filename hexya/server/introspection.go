@@ -0,0 +1,55 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package server
+
+import (
+	"net/http"
+
+	"github.com/hexya-erp/hexya/hexya/models"
+)
+
+// IntrospectionPath is the path prefix of the introspection endpoints, which
+// describe the models, fields and methods of the registry to the web client:
+//   - <IntrospectionPath>/models lists the names of the models,
+//   - <IntrospectionPath>/models/<model> describes the given model.
+//
+// Set it to an empty string before PostInit to disable them.
+var IntrospectionPath = "/web/introspection"
+
+// registerIntrospectionRoutes creates the routes of the introspection endpoints
+func registerIntrospectionRoutes() {
+	if IntrospectionPath == "" {
+		return
+	}
+	root := hexyaServer.Group("/")
+	root.GET(IntrospectionPath+"/models", WithEnvironment(handleIntrospectionModels))
+	root.GET(IntrospectionPath+"/models/:model", WithEnvironment(handleIntrospectionModel))
+}
+
+// handleIntrospectionModels returns the sorted list of the
+// names of the models that can be described.
+func handleIntrospectionModels(c *Context) {
+	descs := models.Registry.Describe()
+	names := make([]string, len(descs))
+	for i, desc := range descs {
+		names[i] = desc.Name
+	}
+	c.JSON(http.StatusOK, names)
+}
+
+// handleIntrospectionModel returns the description of the model of the
+// request, with the help and doc strings of its fields and methods.
+// Field strings are translated in the language given by the lang query
+// parameter, if any.
+func handleIntrospectionModel(c *Context) {
+	if _, ok := models.Registry.Get(c.Param("model")); !ok {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	env := c.Env()
+	if lang := c.Query("lang"); lang != "" {
+		env = env.WithContext("lang", lang)
+	}
+	c.JSON(http.StatusOK, env.DescribeModel(c.Param("model")))
+}
@@ -0,0 +1,222 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/hexya-erp/hexya/hexya/models"
+	"github.com/hexya-erp/hexya/hexya/models/types"
+	"github.com/hexya-erp/hexya/hexya/tools/exceptions"
+)
+
+// RPCPath is the path of the JSON-RPC endpoint calling model methods.
+// The model and method names can be appended to this path. Set it to
+// an empty string before PostInit to disable the endpoint.
+var RPCPath = "/web/dataset/call_kw"
+
+// JSON-RPC 2.0 error codes
+const (
+	RPCParseError     = -32700
+	RPCInvalidRequest = -32600
+	RPCMethodNotFound = -32601
+	RPCInvalidParams  = -32602
+	RPCServerError    = -32000
+)
+
+// CallKWParams are the params of a JSON-RPC request calling a model method.
+//
+// The first item of Args is the list of ids of the records on which the
+// method is called. The other items are the arguments of the method. The
+// only allowed key of KWArgs is "context", which sets the context of the
+// call.
+type CallKWParams struct {
+	Model  string                     `json:"model"`
+	Method string                     `json:"method"`
+	Args   []json.RawMessage          `json:"args"`
+	KWArgs map[string]json.RawMessage `json:"kwargs"`
+}
+
+// An rpcCall is a JSON-RPC request calling a model method
+type rpcCall struct {
+	JsonRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Method  string          `json:"method"`
+	Params  CallKWParams    `json:"params"`
+}
+
+// An rpcSuccess is the response to a successful rpcCall
+type rpcSuccess struct {
+	JsonRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result"`
+}
+
+// An rpcFailure is the response to a failed rpcCall
+type rpcFailure struct {
+	JsonRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Error   JSONRPCError    `json:"error"`
+}
+
+// registerRPCRoutes creates the routes of the JSON-RPC endpoint
+func registerRPCRoutes() {
+	if RPCPath == "" {
+		return
+	}
+	root := hexyaServer.Group("/")
+	root.POST(RPCPath, handleAPIKeys, handleRPC)
+	root.POST(RPCPath+"/:model/:method", handleAPIKeys, handleRPC)
+}
+
+// handleRPC executes the JSON-RPC calls of the request as the user
+// authenticated in the session or by an API key. Batches of calls are given as a JSON
+// array and are answered by an array of responses.
+//
+// Each call is executed in its own transaction, so that a failed call of
+// a batch does not roll back the others.
+func handleRPC(c *Context) {
+	uid := c.UID()
+	if uid == 0 {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+	body, err := ioutil.ReadAll(c.Request.Body)
+	if err != nil {
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+	if body = bytes.TrimSpace(body); len(body) == 0 || body[0] != '[' {
+		if resp, ok := c.callRPC(uid, body); ok {
+			c.JSON(http.StatusOK, resp)
+			return
+		}
+		c.Status(http.StatusNoContent)
+		return
+	}
+	var calls []json.RawMessage
+	if err := json.Unmarshal(body, &calls); err != nil {
+		c.JSON(http.StatusOK, rpcError(nil, RPCParseError, "Parse error", err.Error()))
+		return
+	}
+	if len(calls) == 0 {
+		c.JSON(http.StatusOK, rpcError(nil, RPCInvalidRequest, "Invalid Request", "empty batch"))
+		return
+	}
+	var responses []interface{}
+	for _, call := range calls {
+		if resp, ok := c.callRPC(uid, call); ok {
+			responses = append(responses, resp)
+		}
+	}
+	if len(responses) == 0 {
+		c.Status(http.StatusNoContent)
+		return
+	}
+	c.JSON(http.StatusOK, responses)
+}
+
+// callRPC executes the given JSON-RPC call as the given user and returns its
+// response. The second returned value is false if the call is a notification,
+// i.e. has no id, in which case no response must be sent.
+func (c *Context) callRPC(uid int64, data []byte) (interface{}, bool) {
+	var call rpcCall
+	if err := json.Unmarshal(data, &call); err != nil {
+		return rpcError(nil, RPCParseError, "Parse error", err.Error()), true
+	}
+	notification := len(call.ID) == 0
+	params := call.Params
+	if params.Model == "" {
+		params.Model = c.Param("model")
+	}
+	if params.Method == "" {
+		params.Method = c.Param("method")
+	}
+	if call.JsonRPC != "2.0" || params.Model == "" || params.Method == "" {
+		return rpcError(call.ID, RPCInvalidRequest, "Invalid Request", "jsonrpc must be 2.0 and model and method must be given"), !notification
+	}
+	model, ok := models.Registry.Get(params.Model)
+	if !ok {
+		return rpcError(call.ID, RPCMethodNotFound, "Method not found", "unknown model "+params.Model), !notification
+	}
+	if method, ok := model.Methods().Get(params.Method); !ok || method.IsPrivate() {
+		return rpcError(call.ID, RPCMethodNotFound, "Method not found", "unknown or private method "+params.Method), !notification
+	}
+	var ids []int64
+	if len(params.Args) > 0 {
+		if err := json.Unmarshal(params.Args[0], &ids); err != nil {
+			return rpcError(call.ID, RPCInvalidParams, "Invalid params", "first argument must be a list of ids"), !notification
+		}
+	}
+	var context *types.Context
+	for key, value := range params.KWArgs {
+		if key != "context" {
+			return rpcError(call.ID, RPCInvalidParams, "Invalid params", "unknown keyword argument "+key), !notification
+		}
+		context = new(types.Context)
+		if err := json.Unmarshal(value, context); err != nil {
+			return rpcError(call.ID, RPCInvalidParams, "Invalid params", err.Error()), !notification
+		}
+	}
+	if err := models.CheckCounterQuota(models.QuotaAPICalls, 1); err != nil {
+		return rpcError(call.ID, RPCServerError, "Quota exceeded", err.Error()), !notification
+	}
+	models.AddQuotaUsage(models.QuotaAPICalls, 1)
+	var result interface{}
+	err := traceRPC(c.Request.Context(), "jsonrpc", params.Model, params.Method, func() error {
+		return models.ExecuteInNewEnvironment(uid, func(env models.Environment) {
+			env = env.WithAPIScopes(c.APIScopes())
+			if context != nil {
+				env = env.WithNewContext(context)
+			}
+			var args []json.RawMessage
+			if len(params.Args) > 1 {
+				args = params.Args[1:]
+			}
+			result = model.Browse(env, ids).CallRPC(params.Method, args...)
+		})
+	})
+	if err != nil {
+		userError, _ := err.(exceptions.UserError)
+		return rpcFailure{
+			JsonRPC: "2.0",
+			ID:      rpcID(call.ID),
+			Error: JSONRPCError{
+				Code:    RPCServerError,
+				Message: "Hexya Server Error",
+				Data: JSONRPCErrorData{
+					Arguments:     []string{userError.Message},
+					ExceptionType: "user_error",
+					Debug:         userError.Debug,
+				},
+			},
+		}, !notification
+	}
+	return rpcSuccess{JsonRPC: "2.0", ID: rpcID(call.ID), Result: result}, !notification
+}
+
+// rpcError returns the response to a JSON-RPC call
+// with the given id that failed with the given error.
+func rpcError(id json.RawMessage, code int, message, data string) rpcFailure {
+	return rpcFailure{
+		JsonRPC: "2.0",
+		ID:      rpcID(id),
+		Error: JSONRPCError{
+			Code:    code,
+			Message: message,
+			Data:    data,
+		},
+	}
+}
+
+// rpcID returns the given JSON-RPC id, or null if it is empty
+func rpcID(id json.RawMessage) json.RawMessage {
+	if len(id) == 0 {
+		return json.RawMessage("null")
+	}
+	return id
+}
@@ -0,0 +1,25 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package server
+
+import (
+	"time"
+
+	"github.com/hexya-erp/hexya/hexya/models"
+)
+
+// MailPollInterval is the time between two checks for
+// due outgoing mails by the mail queue worker.
+var MailPollInterval = time.Minute
+
+func init() {
+	RegisterPollingWorker(RoleCron, "mail queue", &MailPollInterval, processMailQueue)
+}
+
+// processMailQueue sends the due outgoing mails
+func processMailQueue() {
+	if count := models.ProcessMailQueue(); count > 0 {
+		log.Debug("Mail queue processed", "count", count)
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package server
+
+import (
+	"net/http"
+
+	"github.com/hexya-erp/hexya/hexya/actions"
+	"github.com/hexya-erp/hexya/hexya/menus"
+	"github.com/hexya-erp/hexya/hexya/models"
+	"github.com/hexya-erp/hexya/hexya/views"
+)
+
+// NavigationPath is the path prefix of the endpoints with which the web
+// client renders the navigation of the user:
+//   - <NavigationPath>/menus returns the tree of the menus visible to the user,
+//   - <NavigationPath>/action/<id> returns the action with the given id,
+//   - <NavigationPath>/view/<model>/<type> returns the first view of the given
+//     type for the given model, or the view given by the view_id query
+//     parameter, with its arch resolved for the user and its fields metadata.
+//
+// Names are translated in the language given by the lang query parameter,
+// if any. Set it to an empty string before PostInit to disable them.
+var NavigationPath = "/web"
+
+// registerNavigationRoutes creates the routes of the navigation endpoints
+func registerNavigationRoutes() {
+	if NavigationPath == "" {
+		return
+	}
+	root := hexyaServer.Group("/")
+	root.GET(NavigationPath+"/menus", WithEnvironment(handleMenus))
+	root.GET(NavigationPath+"/action/:id", WithEnvironment(handleAction))
+	root.GET(NavigationPath+"/view/:model/:type", WithEnvironment(handleView))
+}
+
+// handleMenus returns the tree of the menus visible to the user
+func handleMenus(c *Context) {
+	env := c.Env()
+	c.JSON(http.StatusOK, menus.Registry.UserTree(c.navigationLang(), env.User().Groups()))
+}
+
+// handleAction returns the action with the id of the request, with its
+// translated name. Actions that are not visible to the user are answered
+// with a 404 Not Found status, as unknown actions.
+func handleAction(c *Context) {
+	action := actions.Registry.GetById(c.Param("id"))
+	if action == nil || !action.IsVisibleFor(c.Env().User().Groups()) {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	res := *action
+	res.Name = action.TranslatedName(c.navigationLang())
+	c.JSON(http.StatusOK, res)
+}
+
+// handleView returns the view of the request as a views.FieldsView. Unknown
+// models and views, and views that are not visible to the user, are answered
+// with a 404 Not Found status.
+func handleView(c *Context) {
+	modelName, viewType := c.Param("model"), views.ViewType(c.Param("type"))
+	if _, ok := models.Registry.Get(modelName); !ok {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	env := c.Env().WithContext("lang", c.navigationLang())
+	userGroups := env.User().Groups()
+	view := views.Registry.GetFirstViewForUser(modelName, viewType, userGroups)
+	if viewID := c.Query("view_id"); viewID != "" {
+		view = views.Registry.GetByID(viewID)
+		if view == nil || view.Model != modelName || view.Type != viewType || !view.IsVisibleFor(userGroups) {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+	}
+	c.JSON(http.StatusOK, view.FieldsViewGet(env))
+}
+
+// navigationLang returns the language given by the lang query
+// parameter of the request, or the language of its Environment.
+func (c *Context) navigationLang() string {
+	if lang := c.Query("lang"); lang != "" {
+		return lang
+	}
+	return c.Env().Context().GetString("lang")
+}
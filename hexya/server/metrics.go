@@ -0,0 +1,99 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hexya-erp/hexya/hexya/metrics"
+	"github.com/hexya-erp/hexya/hexya/models"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// MetricsPath is the path of the endpoint that exports the metrics of the
+// instance in the Prometheus format. It is answered even while the instance
+// is starting, without authentication, so that it should not be reachable
+// from outside the internal network.
+//
+// Set it to an empty string to disable it.
+var MetricsPath = "/metrics"
+
+// metricsHandler is the HTTP handler of the metrics endpoint
+var metricsHandler = metrics.Handler()
+
+// measureRequests is the middleware that updates the HTTP metrics.
+// Requests are labeled with their route instead of their path so that
+// the number of label values is bounded.
+func measureRequests(c *gin.Context) {
+	start := time.Now()
+	c.Next()
+	route := c.FullPath()
+	if route == "" {
+		route = "unmatched"
+	}
+	metrics.HTTPRequests.WithLabelValues(c.Request.Method, route, strconv.Itoa(c.Writer.Status())).Inc()
+	metrics.HTTPRequestDuration.WithLabelValues(c.Request.Method, route).Observe(time.Now().Sub(start).Seconds())
+}
+
+var (
+	dbConnectionsDesc = prometheus.NewDesc(prometheus.BuildFQName(metrics.Namespace, "db", "connections"),
+		"Number of connections of the database pool by state.", []string{"state"}, nil)
+	dbWaitCountDesc = prometheus.NewDesc(prometheus.BuildFQName(metrics.Namespace, "db", "wait_count_total"),
+		"Number of connections waited for because the database pool was exhausted.", nil, nil)
+	dbWaitDurationDesc = prometheus.NewDesc(prometheus.BuildFQName(metrics.Namespace, "db", "wait_duration_seconds_total"),
+		"Time spent waiting for a connection of the database pool.", nil, nil)
+	queueDepthDesc = prometheus.NewDesc(prometheus.BuildFQName(metrics.Namespace, "jobs", "queue_depth"),
+		"Number of items waiting to be processed by queue of background work.", []string{"queue"}, nil)
+)
+
+// A serverCollector is a prometheus.Collector of the metrics that are read
+// when they are scraped: the statistics of the database pool and the depths
+// of the queues of background work. They are only collected once the instance
+// is ready.
+type serverCollector struct{}
+
+// Describe sends the descriptors of the metrics of this collector to ch
+func (sc serverCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- dbConnectionsDesc
+	ch <- dbWaitCountDesc
+	ch <- dbWaitDurationDesc
+	ch <- queueDepthDesc
+}
+
+// Collect sends the current values of the metrics of this collector to ch
+func (sc serverCollector) Collect(ch chan<- prometheus.Metric) {
+	if GetStatus() != StatusReady {
+		return
+	}
+	stats := models.DBStats()
+	ch <- prometheus.MustNewConstMetric(dbConnectionsDesc, prometheus.GaugeValue, float64(stats.InUse), "in_use")
+	ch <- prometheus.MustNewConstMetric(dbConnectionsDesc, prometheus.GaugeValue, float64(stats.Idle), "idle")
+	ch <- prometheus.MustNewConstMetric(dbWaitCountDesc, prometheus.CounterValue, float64(stats.WaitCount))
+	ch <- prometheus.MustNewConstMetric(dbWaitDurationDesc, prometheus.CounterValue, stats.WaitDuration.Seconds())
+	depths, err := models.QueueDepths()
+	if err != nil {
+		log.Warn("Unable to collect queue depths", "error", err)
+		return
+	}
+	for queue, depth := range depths {
+		ch <- prometheus.MustNewConstMetric(queueDepthDesc, prometheus.GaugeValue, float64(depth), queue)
+	}
+}
+
+// serveMetrics answers the metrics endpoint and returns true
+// if the given request is a request to this endpoint.
+func serveMetrics(w http.ResponseWriter, req *http.Request) bool {
+	if MetricsPath == "" || req.URL.Path != MetricsPath {
+		return false
+	}
+	metricsHandler.ServeHTTP(w, req)
+	return true
+}
+
+func init() {
+	metrics.Registry.MustRegister(serverCollector{})
+}
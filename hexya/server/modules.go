@@ -25,6 +25,7 @@ import (
 	"github.com/hexya-erp/hexya/hexya/i18n"
 	"github.com/hexya-erp/hexya/hexya/menus"
 	"github.com/hexya-erp/hexya/hexya/models"
+	"github.com/hexya-erp/hexya/hexya/reports"
 	"github.com/hexya-erp/hexya/hexya/tools/generate"
 	"github.com/hexya-erp/hexya/hexya/views"
 )
@@ -35,6 +36,21 @@ type Module struct {
 	Name     string
 	PreInit  func()
 	PostInit func()
+	// OptionalExtensions are functions that extend the application only if the
+	// module with the name given as key is installed. They are typically used to
+	// add fields or methods to the models of another module without requiring it.
+	//
+	// Optional extensions are run after all PreInit functions and before
+	// the models are bootstrapped.
+	OptionalExtensions map[string]func()
+	// Version is the current version of the module, such as "1.2.0".
+	// It is recorded in the database when the module is installed or
+	// upgraded.
+	Version string
+	// Migrations are the functions that upgrade the database of an installed
+	// module, keyed by the module version they upgrade to. They are run only
+	// once, in version order, by RunMigrations.
+	Migrations map[string]models.Migration
 }
 
 // A ModulesList is a list of Module objects
@@ -49,6 +65,16 @@ func (ml *ModulesList) Names() []string {
 	return res
 }
 
+// Contains returns true if a module with the given name is in this ModulesList.
+func (ml *ModulesList) Contains(name string) bool {
+	for _, module := range *ml {
+		if module.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
 // Modules is the list of activated modules in the application
 var Modules ModulesList
 
@@ -59,25 +85,57 @@ func RegisterModule(mod *Module) {
 	Modules = append(Modules, mod)
 }
 
+// LoadOptionalExtensions runs the optional extensions of all installed modules
+// which depend on a module that is also installed.
+func LoadOptionalExtensions() {
+	for _, module := range Modules {
+		depNames := make([]string, 0, len(module.OptionalExtensions))
+		for depName := range module.OptionalExtensions {
+			depNames = append(depNames, depName)
+		}
+		sort.Strings(depNames)
+		for _, depName := range depNames {
+			if !Modules.Contains(depName) {
+				log.Debug("Skipping optional extension", "module", module.Name, "dependency", depName)
+				continue
+			}
+			log.Debug("Loading optional extension", "module", module.Name, "dependency", depName)
+			module.OptionalExtensions[depName]()
+		}
+	}
+}
+
+// RunMigrations runs the migration functions of the given stage of all
+// modules, in the order in which the modules have been registered, so that
+// the migrations of a module are run after those of its dependencies.
+func RunMigrations(stage models.MigrationStage) {
+	for _, module := range Modules {
+		models.RunMigrations(module.Name, module.Version, stage, module.Migrations)
+	}
+}
+
 // LoadInternalResources loads all data in the 'resources' directory, that are
 // - views,
 // - actions,
 // - menu items
+// - reports
 // Internal resources are defined in XML files.
 func LoadInternalResources() {
 	loadData("resources", "xml", loadXMLResourceFile)
 }
 
 // LoadDataRecords loads all the data records in the 'data' directory into the database.
-// Data records are defined in CSV files.
+// Data records are defined in CSV or XML files.
 func LoadDataRecords() {
 	loadData("data", "csv", models.LoadCSVDataFile)
+	loadData("data", "xml", models.LoadXMLDataFile)
 }
 
 // LoadDemoRecords loads all the data records in the 'demo' directory into the database.
-// Demo records are defined in CSV files.
+// Demo records are defined in CSV or XML files.
 func LoadDemoRecords() {
 	loadData("demo", "csv", models.LoadCSVDataFile)
+	loadData("demo", "xml", models.LoadXMLDataFile)
 }
 
 // LoadTranslations loads all translation data from the PO files in the 'i18n' directory
@@ -86,8 +144,8 @@ func LoadTranslations(langs []string) {
 	for _, mod := range Modules {
 		dataDir := filepath.Join(generate.HexyaDir, "hexya", "server", "i18n", mod.Name)
 		if _, err := os.Stat(dataDir); err != nil {
-			// No resources dir in this module
-			return
+			// No i18n dir in this module
+			continue
 		}
 		LoadModuleTranslations(dataDir, langs)
 	}
@@ -146,6 +204,8 @@ func loadXMLResourceFile(fileName string) {
 				actions.LoadFromEtree(object)
 			case "menuitem":
 				menus.LoadFromEtree(object)
+			case "report":
+				reports.LoadFromEtree(object)
 			default:
 				log.Panic("Unknown XML tag", "filename", fileName, "tag", object.Tag)
 			}
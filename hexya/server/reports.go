@@ -0,0 +1,67 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/hexya-erp/hexya/hexya/reports"
+)
+
+// ReportPath is the path prefix of the endpoint with which reports are
+// downloaded: <ReportPath>/<id>/<format>?ids=1,2,3 renders the report with
+// the given id for the records with the given ids in the given format, such
+// as pdf or html.
+//
+// Set it to an empty string before PostInit to disable it.
+var ReportPath = "/web/report"
+
+// registerReportRoutes creates the route of the report download endpoint
+func registerReportRoutes() {
+	if ReportPath == "" {
+		return
+	}
+	root := hexyaServer.Group("/")
+	root.GET(ReportPath+"/:id/:format", WithEnvironment(handleReport))
+}
+
+// handleReport renders the report of the request as an attachment. The
+// records are searched as the user, so that only the records they can read
+// are printed. Unknown reports and records are answered with a 404 Not Found
+// status, and invalid ids with a 400 Bad Request status.
+func handleReport(c *Context) {
+	report := reports.Registry.GetByID(c.Param("id"))
+	if report == nil {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	var ids []int64
+	for _, idStr := range strings.Split(c.Query("ids"), ",") {
+		id, err := strconv.ParseInt(strings.TrimSpace(idStr), 10, 64)
+		if err != nil {
+			c.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+		ids = append(ids, id)
+	}
+	records := c.Env().Pool(report.Model)
+	records = records.Search(records.Model().Field("ID").In(ids))
+	if records.IsEmpty() {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	format := reports.Format(c.Param("format"))
+	var buf bytes.Buffer
+	contentType, err := report.Render(records, format, &buf)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fmt.Sprintf("%s.%s", report.Name, format)))
+	c.Data(http.StatusOK, contentType, buf.Bytes())
+}
@@ -15,12 +15,14 @@
 package server
 
 import (
+	"context"
 	"crypto/tls"
 	"encoding/json"
 	"net/http"
 	"path/filepath"
+	"sync"
+	"sync/atomic"
 
-	"github.com/gin-gonic/contrib/sessions"
 	"github.com/gin-gonic/gin"
 	"github.com/hexya-erp/hexya/hexya/tools/generate"
 	"github.com/hexya-erp/hexya/hexya/tools/logging"
@@ -32,6 +34,25 @@ import (
 // It is internally a wrapper around a gin.Engine
 type Server struct {
 	*gin.Engine
+	mutex       sync.Mutex
+	httpServers []*http.Server
+	stopping    chan struct{}
+	stopped     bool
+	routing     int32
+}
+
+// EnableRouter makes the HTTP server forward the requests to the router.
+//
+// Until then, the router is never used, so that routes can be added while
+// the HTTP server is already listening. It must be called once all the
+// routes are registered.
+func (s *Server) EnableRouter() {
+	atomic.StoreInt32(&s.routing, 1)
+}
+
+// routerEnabled returns true if EnableRouter has been called
+func (s *Server) routerEnabled() bool {
+	return atomic.LoadInt32(&s.routing) == 1
 }
 
 // Group creates a new router group. You should add all the routes that have common middlwares or the same path prefix.
@@ -45,32 +66,28 @@ func (s *Server) Group(relativePath string, handlers ...HandlerFunc) *RouterGrou
 // Run attaches the router to a http.Server and starts listening and serving HTTP requests.
 // It is a shortcut for http.ListenAndServe(addr, router)
 // Note: this method will block the calling goroutine indefinitely unless an error happens.
-func (s *Server) Run(addr string) (err error) {
-	defer func() { log.Error("HTTP server stopped", err) }()
-
+func (s *Server) Run(addr string) error {
 	log.Info("Hexya is up and running HTTP", "address", addr)
-	err = http.ListenAndServe(addr, s)
-	return
+	return s.serve(&http.Server{Addr: addr, Handler: s}, func(srv *http.Server) error {
+		return srv.ListenAndServe()
+	})
 }
 
 // RunTLS attaches the router to a http.Server and starts listening and serving HTTPS (secure) requests.
 // It is a shortcut for http.ListenAndServeTLS(addr, certFile, keyFile, router)
 // Note: this method will block the calling goroutine indefinitely unless an error happens.
-func (s *Server) RunTLS(addr string, certFile string, keyFile string) (err error) {
-	defer func() { log.Error("HTTPS server stopped", err) }()
-
+func (s *Server) RunTLS(addr string, certFile string, keyFile string) error {
 	log.Info("Hexya is up and running HTTPS", "address", addr, "cert", certFile, "key", keyFile)
-	err = http.ListenAndServeTLS(addr, certFile, keyFile, s)
-	return
+	return s.serve(&http.Server{Addr: addr, Handler: s}, func(srv *http.Server) error {
+		return srv.ListenAndServeTLS(certFile, keyFile)
+	})
 }
 
 // RunAutoTLS attaches the router to a http.Server and starts listening and serving HTTPS (secure) requests on port 443
 // for all interfaces.
 // It automatically gets certificate for the given domain from Letsencrypt.
 // Note: this method will block the calling goroutine indefinitely unless an error happens.
-func (s *Server) RunAutoTLS(domain string) (err error) {
-	defer func() { log.Error("HTTPS server stopped", err) }()
-
+func (s *Server) RunAutoTLS(domain string) error {
 	log.Info("Hexya is up and running HTTPS auto", "domain", domain)
 
 	cacheDir := filepath.Join(viper.GetString("DataDir"), "autotls")
@@ -79,14 +96,72 @@ func (s *Server) RunAutoTLS(domain string) (err error) {
 		Prompt:     autocert.AcceptTOS,
 		HostPolicy: autocert.HostWhitelist(domain),
 	}
-	go http.ListenAndServe(":http", m.HTTPHandler(nil))
+	go s.serve(&http.Server{Addr: ":http", Handler: m.HTTPHandler(nil)}, func(srv *http.Server) error {
+		return srv.ListenAndServe()
+	})
 	srv := &http.Server{
 		Addr:      ":https",
 		TLSConfig: &tls.Config{GetCertificate: m.GetCertificate},
 		Handler:   s,
 	}
-	err = srv.ListenAndServeTLS("", "")
-	return
+	return s.serve(srv, func(srv *http.Server) error {
+		return srv.ListenAndServeTLS("", "")
+	})
+}
+
+// serve registers srv to be stopped by Shutdown and calls listen to start it.
+// It blocks until srv stops and returns nil if it has been stopped by Shutdown.
+func (s *Server) serve(srv *http.Server, listen func(*http.Server) error) error {
+	s.mutex.Lock()
+	if s.stopped {
+		s.mutex.Unlock()
+		return nil
+	}
+	s.httpServers = append(s.httpServers, srv)
+	s.mutex.Unlock()
+	err := listen(srv)
+	if err == http.ErrServerClosed {
+		log.Info("HTTP server stopped", "address", srv.Addr)
+		return nil
+	}
+	log.Error("HTTP server stopped", "address", srv.Addr, "error", err)
+	return err
+}
+
+// Stopping returns a channel that is closed when Shutdown is called.
+// Handlers that wait for a long time, such as long polling handlers,
+// should return when it is closed so that the server can stop.
+func (s *Server) Stopping() <-chan struct{} {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.stopping == nil {
+		s.stopping = make(chan struct{})
+	}
+	return s.stopping
+}
+
+// Shutdown gracefully stops the HTTP servers started by Run, RunTLS and
+// RunAutoTLS. They immediately stop accepting connections, and Shutdown
+// waits for the requests being served to finish. If ctx is done before,
+// the remaining connections are closed and the error of ctx is returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.Stopping()
+	s.mutex.Lock()
+	if !s.stopped {
+		close(s.stopping)
+		s.stopped = true
+	}
+	servers := s.httpServers
+	s.httpServers = nil
+	s.mutex.Unlock()
+	var res error
+	for _, srv := range servers {
+		if err := srv.Shutdown(ctx); err != nil {
+			srv.Close()
+			res = err
+		}
+	}
+	return res
 }
 
 // A RequestRPC is the message format expected from a client
@@ -139,11 +214,12 @@ func init() {
 	log = logging.GetLogger("server")
 	// Set to ReleaseMode now for tests and is overridden later (hexya/cmd/server.go)
 	gin.SetMode(gin.ReleaseMode)
-	hexyaServer = &Server{gin.New()}
-	store := sessions.NewCookieStore([]byte(">r&5#5T/sG-jnf=EW8$(WQX'-m2R6Gk*^qqr`CxEtG'wQ[/'G@`NYn^on?b!4G`9"),
-		[]byte("!WY9Q|}09!4Ke=@w0HS|]$u,p1f^k(5T"))
+	hexyaServer = &Server{Engine: gin.New()}
+	SetSessionStore(nil)
 	hexyaServer.Use(gin.Recovery())
-	hexyaServer.Use(sessions.Sessions("hexya-session", store))
+	hexyaServer.Use(traceRequests)
+	hexyaServer.Use(measureRequests)
+	hexyaServer.Use(handleSessions)
 	hexyaServer.Use(logging.LogForGin(log))
 }
 
@@ -151,8 +227,10 @@ func init() {
 // but before bootstrap.
 //
 // This function runs successively all PreInit() func of modules
+// and then loads the optional extensions of the modules.
 func PreInit() {
 	PreInitModules()
+	LoadOptionalExtensions()
 }
 
 // PreInitModules calls successively all PreInit functions of all installed modules
@@ -168,9 +246,27 @@ func PreInitModules() {
 // This is typically all actions that need to be done after bootstrapping the models.
 // This function:
 // - runs successively all PostInit() func of all modules,
+// - creates the authentication endpoints at AuthPath,
+// - creates the JSON-RPC endpoint at RPCPath,
+// - creates the XML-RPC endpoints of the Odoo external API at XMLRPCPath,
+// - creates the route redirecting to records at RecordRedirectPath,
+// - creates the introspection endpoints at IntrospectionPath,
+// - creates the menus and actions endpoints at NavigationPath,
+// - creates the endpoints of shared records at SharePath,
+// - creates the report download endpoint at ReportPath,
+// - creates the longpolling endpoint of the bus at BusPath,
 // - loads html templates from all modules.
 func PostInit() {
 	PostInitModules()
+	registerAuthRoutes()
+	registerRPCRoutes()
+	registerXMLRPCRoutes()
+	registerRecordRedirectRoute()
+	registerIntrospectionRoutes()
+	registerNavigationRoutes()
+	registerShareRoutes()
+	registerReportRoutes()
+	registerBusRoutes()
 	hexyaServer.LoadHTMLGlob(generate.HexyaDir + "/hexya/server/templates/**/*.html")
 }
 
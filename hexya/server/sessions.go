@@ -0,0 +1,402 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package server
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/gob"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis"
+	"github.com/gorilla/securecookie"
+	gsessions "github.com/gorilla/sessions"
+	"github.com/hexya-erp/hexya/hexya/models"
+	"github.com/hexya-erp/hexya/hexya/models/security"
+)
+
+var (
+	// SessionCookieName is the name of the cookie holding the session
+	SessionCookieName = "hexya-session"
+	// SessionMaxAge is the duration after which an inactive session expires
+	SessionMaxAge = 7 * 24 * time.Hour
+	// SessionCleanupInterval is the time between two deletions
+	// of the expired sessions by the sessions worker.
+	SessionCleanupInterval = time.Hour
+)
+
+// defaultSessionKeys are the keys of the session cookies when no
+// keys are given to SetSessionStore. They must be replaced in production.
+var defaultSessionKeys = [][]byte{
+	[]byte(">r&5#5T/sG-jnf=EW8$(WQX'-m2R6Gk*^qqr`CxEtG'wQ[/'G@`NYn^on?b!4G`9"),
+	[]byte("!WY9Q|}09!4Ke=@w0HS|]$u,p1f^k(5T"),
+}
+
+// A SessionStore persists the data of server side sessions, so that
+// session cookies only hold the signed id of the session.
+type SessionStore interface {
+	// Load returns the data of the session with the given id,
+	// or nil if there is no such session or if it has expired.
+	Load(sid string) ([]byte, error)
+	// Save stores the data of the session with the
+	// given id, which expires after maxAge.
+	Save(sid string, data []byte, maxAge time.Duration) error
+	// Delete deletes the session with the given id
+	Delete(sid string) error
+}
+
+// An expiringSessionStore is a SessionStore which must
+// delete its expired sessions periodically.
+type expiringSessionStore interface {
+	SessionStore
+	// DeleteExpired deletes the expired sessions
+	DeleteExpired() error
+}
+
+var sessionsConfig struct {
+	sync.RWMutex
+	store      SessionStore
+	serverSide *serverSideStore
+	handler    gin.HandlerFunc
+}
+
+func init() {
+	RegisterPollingWorker(RoleHTTP, "sessions", &SessionCleanupInterval, deleteExpiredSessions)
+}
+
+// SetSessionStore sets the store of the sessions of the server, whose cookies
+// are signed, and encrypted if an encryption key is given, with the given key
+// pairs as in gorilla's securecookie. If store is nil, the whole session data
+// is held in the cookie instead.
+//
+// It must be called before the server starts.
+func SetSessionStore(store SessionStore, keyPairs ...[]byte) {
+	if len(keyPairs) == 0 {
+		keyPairs = defaultSessionKeys
+	}
+	options := sessions.Options{
+		Path:     "/",
+		MaxAge:   int(SessionMaxAge / time.Second),
+		HttpOnly: true,
+	}
+	var (
+		contribStore sessions.Store
+		serverSide   *serverSideStore
+	)
+	if store == nil {
+		contribStore = sessions.NewCookieStore(keyPairs...)
+	} else {
+		serverSide = &serverSideStore{
+			backend: store,
+			codecs:  securecookie.CodecsFromPairs(keyPairs...),
+		}
+		contribStore = serverSide
+	}
+	contribStore.Options(options)
+	sessionsConfig.Lock()
+	defer sessionsConfig.Unlock()
+	sessionsConfig.store = store
+	sessionsConfig.serverSide = serverSide
+	sessionsConfig.handler = sessions.Sessions(SessionCookieName, contribStore)
+}
+
+// handleSessions is the middleware which loads the session of the
+// request from the store set by SetSessionStore.
+func handleSessions(c *gin.Context) {
+	sessionsConfig.RLock()
+	handler := sessionsConfig.handler
+	sessionsConfig.RUnlock()
+	handler(c)
+}
+
+// Login binds the session of this request to the user with the given id,
+// who is used by the Environments of the next requests of the session.
+//
+// With a SessionStore, the session is saved under a new id and its previous
+// id is deleted from the store, so that a session id known before the login
+// cannot be used to hijack the session of the user.
+func (c *Context) Login(uid int64) error {
+	session := c.Session()
+	session.Set(SessionUIDKey, uid)
+	sessionsConfig.RLock()
+	serverSide := sessionsConfig.serverSide
+	sessionsConfig.RUnlock()
+	if serverSide != nil {
+		if err := serverSide.renewID(c.Request, SessionCookieName); err != nil {
+			return err
+		}
+	}
+	return session.Save()
+}
+
+// Logout removes all the data of the session of this request,
+// which is deleted from the session store.
+func (c *Context) Logout() error {
+	session := c.Session()
+	session.Clear()
+	session.Options(sessions.Options{Path: "/", MaxAge: -1})
+	return session.Save()
+}
+
+// deleteExpiredSessions deletes the expired sessions of the session store
+func deleteExpiredSessions() {
+	sessionsConfig.RLock()
+	store, ok := sessionsConfig.store.(expiringSessionStore)
+	sessionsConfig.RUnlock()
+	if !ok {
+		return
+	}
+	if err := store.DeleteExpired(); err != nil {
+		log.Warn("Error while deleting expired sessions", "error", err)
+	}
+}
+
+// A serverSideStore is a sessions.Store whose data is stored in a
+// SessionStore, while the cookie only holds the signed session id.
+type serverSideStore struct {
+	backend SessionStore
+	codecs  []securecookie.Codec
+	options gsessions.Options
+}
+
+// Options sets the options of the cookies of this store
+func (s *serverSideStore) Options(options sessions.Options) {
+	s.options = gsessions.Options{
+		Path:     options.Path,
+		Domain:   options.Domain,
+		MaxAge:   options.MaxAge,
+		Secure:   options.Secure,
+		HttpOnly: options.HttpOnly,
+	}
+}
+
+// Get returns the session with the given name of the given request,
+// which is cached in the registry of the request.
+func (s *serverSideStore) Get(r *http.Request, name string) (*gsessions.Session, error) {
+	return gsessions.GetRegistry(r).Get(s, name)
+}
+
+// New returns the session with the given name of the given request, loaded
+// from the SessionStore, or a new session if the request has no valid
+// session cookie or if the session has expired.
+func (s *serverSideStore) New(r *http.Request, name string) (*gsessions.Session, error) {
+	session := gsessions.NewSession(s, name)
+	options := s.options
+	session.Options = &options
+	session.IsNew = true
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return session, nil
+	}
+	var sid string
+	if err = securecookie.DecodeMulti(name, cookie.Value, &sid, s.codecs...); err != nil {
+		return session, nil
+	}
+	data, err := s.backend.Load(sid)
+	if err != nil || data == nil {
+		return session, err
+	}
+	if err = gob.NewDecoder(bytes.NewReader(data)).Decode(&session.Values); err != nil {
+		return session, err
+	}
+	session.ID = sid
+	session.IsNew = false
+	return session, nil
+}
+
+// renewID deletes the session with the given name of the given request
+// from the SessionStore and resets its id, so that it is saved under a
+// new id by the next call to Save.
+func (s *serverSideStore) renewID(r *http.Request, name string) error {
+	session, err := s.Get(r, name)
+	if err != nil {
+		return err
+	}
+	if session.ID == "" {
+		return nil
+	}
+	if err = s.backend.Delete(session.ID); err != nil {
+		return err
+	}
+	session.ID = ""
+	return nil
+}
+
+// Save stores the given session in the SessionStore and writes its
+// cookie, or deletes it if its MaxAge is negative.
+func (s *serverSideStore) Save(r *http.Request, w http.ResponseWriter, session *gsessions.Session) error {
+	if session.Options.MaxAge < 0 {
+		if session.ID != "" {
+			if err := s.backend.Delete(session.ID); err != nil {
+				return err
+			}
+		}
+		http.SetCookie(w, gsessions.NewCookie(session.Name(), "", session.Options))
+		return nil
+	}
+	if session.ID == "" {
+		buf := make([]byte, 32)
+		if _, err := rand.Read(buf); err != nil {
+			return err
+		}
+		session.ID = strings.TrimRight(base32.StdEncoding.EncodeToString(buf), "=")
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(session.Values); err != nil {
+		return err
+	}
+	maxAge := time.Duration(session.Options.MaxAge) * time.Second
+	if maxAge == 0 {
+		maxAge = SessionMaxAge
+	}
+	if err := s.backend.Save(session.ID, buf.Bytes(), maxAge); err != nil {
+		return err
+	}
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.ID, s.codecs...)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, gsessions.NewCookie(session.Name(), encoded, session.Options))
+	return nil
+}
+
+// memorySessionStore is a SessionStore that keeps the sessions in memory
+type memorySessionStore struct {
+	sync.RWMutex
+	sessions map[string]memorySession
+}
+
+// memorySession is a session of a memorySessionStore
+type memorySession struct {
+	data       []byte
+	expiration time.Time
+}
+
+// NewMemorySessionStore returns a SessionStore that keeps the sessions in the
+// memory of this process. Sessions are lost when the process stops and are not
+// shared with other processes, so that it is only suitable for development
+// and single process deployments.
+func NewMemorySessionStore() SessionStore {
+	return &memorySessionStore{sessions: make(map[string]memorySession)}
+}
+
+// Load returns the data of the session with the given id
+func (s *memorySessionStore) Load(sid string) ([]byte, error) {
+	s.RLock()
+	defer s.RUnlock()
+	session, ok := s.sessions[sid]
+	if !ok || time.Now().After(session.expiration) {
+		return nil, nil
+	}
+	return session.data, nil
+}
+
+// Save stores the data of the session with the given id
+func (s *memorySessionStore) Save(sid string, data []byte, maxAge time.Duration) error {
+	s.Lock()
+	defer s.Unlock()
+	s.sessions[sid] = memorySession{data: data, expiration: time.Now().Add(maxAge)}
+	return nil
+}
+
+// Delete deletes the session with the given id
+func (s *memorySessionStore) Delete(sid string) error {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.sessions, sid)
+	return nil
+}
+
+// DeleteExpired deletes the expired sessions
+func (s *memorySessionStore) DeleteExpired() error {
+	s.Lock()
+	defer s.Unlock()
+	now := time.Now()
+	for sid, session := range s.sessions {
+		if now.After(session.expiration) {
+			delete(s.sessions, sid)
+		}
+	}
+	return nil
+}
+
+// dbSessionStore is a SessionStore that keeps the sessions in the database
+type dbSessionStore struct{}
+
+// NewDBSessionStore returns a SessionStore that keeps the sessions in the
+// Session model of the database, so that they are shared by all the processes
+// of the database. Each operation is executed in its own transaction.
+func NewDBSessionStore() SessionStore {
+	return dbSessionStore{}
+}
+
+// Load returns the data of the session with the given id
+func (s dbSessionStore) Load(sid string) ([]byte, error) {
+	var data []byte
+	err := models.ExecuteInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+		data = env.LoadSession(sid)
+	})
+	return data, err
+}
+
+// Save stores the data of the session with the given id
+func (s dbSessionStore) Save(sid string, data []byte, maxAge time.Duration) error {
+	return models.ExecuteInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+		env.SaveSession(sid, data, maxAge)
+	})
+}
+
+// Delete deletes the session with the given id
+func (s dbSessionStore) Delete(sid string) error {
+	return models.ExecuteInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+		env.DeleteSession(sid)
+	})
+}
+
+// DeleteExpired deletes the expired sessions
+func (s dbSessionStore) DeleteExpired() error {
+	return models.ExecuteInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+		if count := env.DeleteExpiredSessions(); count > 0 {
+			log.Debug("Expired sessions deleted", "count", count)
+		}
+	})
+}
+
+// redisSessionStore is a SessionStore that keeps the sessions in Redis
+type redisSessionStore struct {
+	client *redis.Client
+	prefix string
+}
+
+// NewRedisSessionStore returns a SessionStore that keeps the sessions in Redis
+// with the given client, under keys made of the given prefix and the session
+// id. Sessions are expired by Redis.
+func NewRedisSessionStore(client *redis.Client, prefix string) SessionStore {
+	return redisSessionStore{client: client, prefix: prefix}
+}
+
+// Load returns the data of the session with the given id
+func (s redisSessionStore) Load(sid string) ([]byte, error) {
+	data, err := s.client.Get(s.prefix + sid).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	return data, err
+}
+
+// Save stores the data of the session with the given id
+func (s redisSessionStore) Save(sid string, data []byte, maxAge time.Duration) error {
+	return s.client.Set(s.prefix+sid, data, maxAge).Err()
+}
+
+// Delete deletes the session with the given id
+func (s redisSessionStore) Delete(sid string) error {
+	return s.client.Del(s.prefix + sid).Err()
+}
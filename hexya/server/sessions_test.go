@@ -0,0 +1,55 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSessions(t *testing.T) {
+	Convey("Testing server side sessions", t, func() {
+		store := NewMemorySessionStore().(*memorySessionStore)
+		SetSessionStore(store)
+		Reset(func() {
+			SetSessionStore(nil)
+		})
+		w := performRequest(httptest.NewRequest(http.MethodGet, testSessionPath, nil))
+		So(w.Code, ShouldEqual, http.StatusOK)
+		cookies := w.Result().Cookies()
+		So(cookies, ShouldHaveLength, 1)
+		So(store.sessions, ShouldHaveLength, 1)
+		var oldID string
+		for sid := range store.sessions {
+			oldID = sid
+		}
+		Convey("Login should save the session under a new id", func() {
+			req := httptest.NewRequest(http.MethodGet, testLoginPath, nil)
+			req.AddCookie(cookies[0])
+			w = performRequest(req)
+			So(w.Code, ShouldEqual, http.StatusOK)
+			So(store.sessions, ShouldHaveLength, 1)
+			So(store.sessions, ShouldNotContainKey, oldID)
+			newCookies := w.Result().Cookies()
+			So(newCookies, ShouldHaveLength, 1)
+			So(newCookies[0].Value, ShouldNotEqual, cookies[0].Value)
+			Convey("The new session should be authenticated", func() {
+				req = httptest.NewRequest(http.MethodGet, testEnvPath, nil)
+				req.AddCookie(newCookies[0])
+				w = performRequest(req)
+				So(w.Code, ShouldEqual, http.StatusOK)
+				So(w.Body.String(), ShouldEqual, "1")
+			})
+			Convey("The session cookie set before login should not be authenticated", func() {
+				req = httptest.NewRequest(http.MethodGet, testEnvPath, nil)
+				req.AddCookie(cookies[0])
+				w = performRequest(req)
+				So(w.Code, ShouldEqual, http.StatusUnauthorized)
+			})
+		})
+	})
+}
@@ -0,0 +1,78 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package server
+
+import (
+	"net/http"
+
+	"github.com/hexya-erp/hexya/hexya/models"
+	"github.com/hexya-erp/hexya/hexya/models/security"
+)
+
+// SharePath is the path prefix of the endpoints giving access to the records
+// shared with a token, without user account:
+//   - GET <SharePath>/<token> returns the shared record,
+//   - POST <SharePath>/<token>/comment posts a comment on the shared record.
+//
+// Set it to an empty string before PostInit to disable them. If it is
+// changed, models.ShareURL must be overridden accordingly.
+var SharePath = "/share"
+
+// A shareComment is the body of a request posting a comment on a shared record
+type shareComment struct {
+	Author string `json:"author" form:"author"`
+	Body   string `json:"body" form:"body" binding:"required"`
+}
+
+// registerShareRoutes creates the routes of the share endpoints
+func registerShareRoutes() {
+	if SharePath == "" {
+		return
+	}
+	root := hexyaServer.Group("/")
+	root.GET(SharePath+"/:token", handleShareRead)
+	root.POST(SharePath+"/:token/comment", handleShareComment)
+}
+
+// handleShareRead returns the record shared by the token of the request.
+// Invalid, expired and revoked tokens are answered with a 404 Not Found
+// status, so that they cannot be told apart.
+func handleShareRead(c *Context) {
+	var (
+		record models.SharedRecord
+		ok     bool
+	)
+	err := models.ExecuteInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+		record, ok = env.ReadSharedRecord(c.Param("token"))
+	})
+	switch {
+	case err != nil:
+		c.AbortWithError(http.StatusForbidden, err)
+	case !ok:
+		c.AbortWithStatus(http.StatusNotFound)
+	default:
+		c.JSON(http.StatusOK, record)
+	}
+}
+
+// handleShareComment posts the comment of the request on the record shared
+// by its token, which must grant the comment access.
+func handleShareComment(c *Context) {
+	var comment shareComment
+	if err := c.Bind(&comment); err != nil {
+		return
+	}
+	var ok bool
+	err := models.ExecuteInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+		_, ok = env.CommentSharedRecord(c.Param("token"), comment.Author, comment.Body)
+	})
+	switch {
+	case err != nil:
+		c.AbortWithError(http.StatusForbidden, err)
+	case !ok:
+		c.AbortWithStatus(http.StatusNotFound)
+	default:
+		c.Status(http.StatusCreated)
+	}
+}
@@ -0,0 +1,124 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/hexya-erp/hexya/hexya/models"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// serveBlocking makes s serve on a local port a handler which blocks until
+// release is closed. It returns the address of the server, a channel
+// which is closed when a request reaches the handler and a channel which
+// receives the result of serve.
+func serveBlocking(s *Server, release <-chan struct{}) (string, <-chan struct{}, <-chan error) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	So(err, ShouldBeNil)
+	started := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.WriteHeader(http.StatusOK)
+	})
+	served := make(chan error, 1)
+	go func() {
+		served <- s.serve(&http.Server{Handler: handler}, func(srv *http.Server) error {
+			return srv.Serve(lis)
+		})
+	}()
+	return "http://" + lis.Addr().String(), started, served
+}
+
+// getStatus requests url in a goroutine and returns a channel which receives
+// the status code of the response, or 0 if the request failed.
+func getStatus(url string) <-chan int {
+	res := make(chan int, 1)
+	go func() {
+		resp, err := http.Get(url)
+		if err != nil {
+			res <- 0
+			return
+		}
+		resp.Body.Close()
+		res <- resp.StatusCode
+	}()
+	return res
+}
+
+func TestShutdown(t *testing.T) {
+	Convey("Testing the graceful shutdown", t, func() {
+		Convey("Shutdown should wait for the requests in progress", func() {
+			s := new(Server)
+			release := make(chan struct{})
+			url, started, served := serveBlocking(s, release)
+			status := getStatus(url)
+			<-started
+			shutdownErr := make(chan error, 1)
+			go func() {
+				shutdownErr <- s.Shutdown(context.Background())
+			}()
+			select {
+			case <-s.Stopping():
+			case <-time.After(time.Second):
+				t.Fatal("Stopping channel not closed by Shutdown")
+			}
+			select {
+			case <-shutdownErr:
+				t.Fatal("Shutdown returned before the request was finished")
+			case <-time.After(50 * time.Millisecond):
+			}
+			close(release)
+			So(<-status, ShouldEqual, http.StatusOK)
+			So(<-shutdownErr, ShouldBeNil)
+			So(<-served, ShouldBeNil)
+			So(s.serve(&http.Server{}, func(srv *http.Server) error {
+				t.Fatal("Server started after Shutdown")
+				return nil
+			}), ShouldBeNil)
+		})
+		Convey("Shutdown should close the requests that last longer than ctx", func() {
+			s := new(Server)
+			release := make(chan struct{})
+			defer close(release)
+			url, started, served := serveBlocking(s, release)
+			status := getStatus(url)
+			<-started
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			So(s.Shutdown(ctx), ShouldEqual, context.DeadlineExceeded)
+			So(<-status, ShouldEqual, 0)
+			So(<-served, ShouldBeNil)
+		})
+		Convey("StopWorkers should stop processing, then wait for the workers", func() {
+			// Processing stays stopped afterwards, which is fine as long as
+			// no test of this package processes the queues of models.
+			registeredWorkers := workers
+			workers = nil
+			Reset(func() {
+				workers = registeredWorkers
+			})
+			stopped := make(chan bool, 1)
+			release := make(chan struct{})
+			RegisterWorker(RoleJobRunner, "test", func(stop <-chan struct{}) {
+				<-stop
+				stopped <- models.ProcessingStopped()
+				<-release
+			})
+			StartWorkers()
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			So(StopWorkers(ctx), ShouldEqual, context.DeadlineExceeded)
+			So(<-stopped, ShouldBeTrue)
+			close(release)
+			workersWG.Wait()
+			So(StopWorkers(context.Background()), ShouldBeNil)
+		})
+	})
+}
@@ -0,0 +1,71 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package server
+
+import (
+	"net/http"
+	"sync"
+)
+
+// A Status is the startup status of a Hexya instance
+type Status string
+
+// Available statuses
+const (
+	// StatusStarting is the status of an instance that is bootstrapping
+	StatusStarting Status = "starting"
+	// StatusWaitingMigrations is the status of an instance that is waiting
+	// for another instance to update the database
+	StatusWaitingMigrations Status = "waiting_migrations"
+	// StatusMigrating is the status of an instance that is updating the database
+	StatusMigrating Status = "migrating"
+	// StatusReady is the status of an instance that serves requests
+	StatusReady Status = "ready"
+	// StatusStopping is the status of an instance that is shutting down and
+	// only finishes the requests and jobs in progress
+	StatusStopping Status = "stopping"
+)
+
+var (
+	status      = StatusStarting
+	statusMutex sync.RWMutex
+)
+
+// SetStatus sets the startup status of this instance.
+//
+// Until the status is StatusReady, the HTTP server only answers the health
+// endpoints and returns 503 Service Unavailable for all other requests.
+func SetStatus(s Status) {
+	statusMutex.Lock()
+	defer statusMutex.Unlock()
+	log.Info("Server status changed", "status", s)
+	status = s
+}
+
+// GetStatus returns the startup status of this instance.
+func GetStatus() Status {
+	statusMutex.RLock()
+	defer statusMutex.RUnlock()
+	return status
+}
+
+// ServeHTTP answers the health and metrics endpoints and forwards the
+// other requests to the router once it is enabled and this instance is ready.
+//
+// The router is not used before EnableRouter is called so that routes can be
+// safely added while the HTTP server is already listening.
+func (s *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	currentStatus := GetStatus()
+	if serveHealth(w, req, currentStatus) {
+		return
+	}
+	if serveMetrics(w, req) {
+		return
+	}
+	if !s.routerEnabled() || currentStatus != StatusReady {
+		http.Error(w, "Hexya is starting, please retry later", http.StatusServiceUnavailable)
+		return
+	}
+	s.Engine.ServeHTTP(w, req)
+}
@@ -0,0 +1,151 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/hexya-erp/hexya/hexya/models"
+	"github.com/hexya-erp/hexya/hexya/models/security"
+	"github.com/hexya-erp/hexya/hexya/tools/logging"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
+	"github.com/spf13/viper"
+)
+
+var dbArgs = struct {
+	Driver   string
+	User     string
+	Password string
+	DB       string
+	Debug    string
+}{}
+
+const (
+	// testEnvPath is the path of a route registered with RegisterRoute,
+	// which answers the id of the user of its Environment.
+	testEnvPath = "/test/env"
+	// testSessionPath is the path of a route which
+	// saves a value in the session of the request.
+	testSessionPath = "/test/session"
+	// testLoginPath is the path of a route which logs
+	// the admin user in the session of the request.
+	testLoginPath = "/test/login"
+	// testRecordsPath is the path of a route registered with RegisterRoute,
+	// which answers the ids of the "XTS" currency records unless they
+	// have not been modified.
+	testRecordsPath = "/test/records"
+)
+
+func TestMain(m *testing.M) {
+	initializeTests()
+	res := m.Run()
+	tearDownTests()
+	os.Exit(res)
+}
+
+func initializeTests() {
+	fmt.Printf("Initializing database for server\n")
+	dbArgs.Driver = os.Getenv("HEXYA_DB_DRIVER")
+	if dbArgs.Driver == "" {
+		dbArgs.Driver = "postgres"
+	}
+	dbArgs.User = os.Getenv("HEXYA_DB_USER")
+	if dbArgs.User == "" {
+		dbArgs.User = "hexya"
+	}
+	dbArgs.Password = os.Getenv("HEXYA_DB_PASSWORD")
+	if dbArgs.Password == "" {
+		dbArgs.Password = "hexya"
+	}
+	prefix := os.Getenv("HEXYA_DB_PREFIX")
+	if prefix == "" {
+		prefix = "hexya"
+	}
+
+	dbArgs.DB = fmt.Sprintf("%s_server_tests", prefix)
+	dbArgs.Debug = os.Getenv("HEXYA_DEBUG")
+
+	viper.Set("LogLevel", "crit")
+	if dbArgs.Debug != "" {
+		viper.Set("LogLevel", "debug")
+		viper.Set("LogStdout", true)
+	}
+	logging.Initialize()
+
+	admDB := sqlx.MustConnect(dbArgs.Driver, fmt.Sprintf("dbname=postgres sslmode=disable user=%s password=%s", dbArgs.User, dbArgs.Password))
+	admDB.MustExec(fmt.Sprintf("DROP DATABASE IF EXISTS %s", dbArgs.DB))
+	admDB.MustExec(fmt.Sprintf("CREATE DATABASE %s", dbArgs.DB))
+	admDB.Close()
+
+	connectTestDB()
+	models.BootStrap()
+	models.SyncDatabase()
+	SetStatus(StatusReady)
+
+	registerRPCRoutes()
+	RegisterRoute(testEnvPath, func(c *Context) {
+		c.JSON(http.StatusOK, c.Env().Uid())
+	})
+	RegisterRoute(testRecordsPath, func(c *Context) {
+		currencies := c.Env().Pool("Currency").Search(models.Registry.MustGet("Currency").Field("Name").Equals("XTS"))
+		if c.RecordsNotModified(currencies) {
+			return
+		}
+		c.JSON(http.StatusOK, currencies.Ids())
+	})
+	root := hexyaServer.Group("/")
+	root.GET(testSessionPath, func(c *Context) {
+		c.Session().Set("visited", true)
+		if err := c.Session().Save(); err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		c.Status(http.StatusOK)
+	})
+	root.GET(testLoginPath, func(c *Context) {
+		if err := c.Login(security.SuperUserID); err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		c.Status(http.StatusOK)
+	})
+	hexyaServer.EnableRouter()
+}
+
+// connectTestDB connects to the test database
+func connectTestDB() {
+	models.DBConnect(dbArgs.Driver, models.ConnectionParams{
+		DBName:   dbArgs.DB,
+		User:     dbArgs.User,
+		Password: dbArgs.Password,
+		SSLMode:  "disable",
+	})
+}
+
+func tearDownTests() {
+	models.DBClose()
+	fmt.Printf("Tearing down database for server\n")
+	admDB := sqlx.MustConnect(dbArgs.Driver, fmt.Sprintf("dbname=postgres sslmode=disable user=%s password=%s", dbArgs.User, dbArgs.Password))
+	admDB.MustExec(fmt.Sprintf("DROP DATABASE %s", dbArgs.DB))
+	admDB.Close()
+}
+
+// performRequest serves the given request with the server
+// and returns the recorded response.
+func performRequest(req *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	hexyaServer.ServeHTTP(w, req)
+	return w
+}
+
+// loginCookie returns the session cookie of the admin user
+func loginCookie() *http.Cookie {
+	w := performRequest(httptest.NewRequest(http.MethodGet, testLoginPath, nil))
+	return w.Result().Cookies()[0]
+}
@@ -0,0 +1,101 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package server
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hexya-erp/hexya/hexya/models"
+	"github.com/hexya-erp/hexya/hexya/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/metadata"
+)
+
+// traceRequests is the middleware that traces the HTTP requests. The span
+// of a request continues the trace given in its headers, if any, and is
+// the parent of the spans of the ORM of its handlers.
+func traceRequests(c *gin.Context) {
+	ctx := otel.GetTextMapPropagator().Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+	route := c.FullPath()
+	name := c.Request.Method + " " + route
+	if route == "" {
+		name = c.Request.Method
+	}
+	ctx, span := tracing.Tracer().Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("http.method", c.Request.Method),
+			attribute.String("http.route", route),
+			attribute.String("http.target", c.Request.URL.Path)))
+	defer span.End()
+	c.Request = c.Request.WithContext(ctx)
+	models.WithTraceContext(ctx, c.Next)
+	status := c.Writer.Status()
+	span.SetAttributes(attribute.Int("http.status_code", status))
+	if status >= http.StatusInternalServerError {
+		span.SetStatus(codes.Error, http.StatusText(status))
+	}
+}
+
+// traceRPC executes fnct in a span of the RPC call of the given method of the
+// given model with the given protocol, as a child of the span of ctx, and
+// returns the error of fnct.
+func traceRPC(ctx context.Context, system, model, method string, fnct func() error) error {
+	ctx, span := tracing.Tracer().Start(ctx, system+" "+model+"."+method,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			tracing.RPCSystemKey.String(system),
+			tracing.RPCMethodKey.String(model+"."+method),
+			tracing.ModelKey.String(model),
+			tracing.MethodKey.String(method)))
+	defer span.End()
+	var err error
+	models.WithTraceContext(ctx, func() {
+		err = fnct()
+	})
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return err
+}
+
+// grpcTraceContext returns ctx with the trace given in the metadata of the
+// incoming gRPC call, if any.
+func grpcTraceContext(ctx context.Context) context.Context {
+	md, _ := metadata.FromIncomingContext(ctx)
+	return otel.GetTextMapPropagator().Extract(ctx, metadataCarrier(md))
+}
+
+// A metadataCarrier is a propagation.TextMapCarrier of gRPC metadata
+type metadataCarrier metadata.MD
+
+// Get returns the first value of the given key
+func (mc metadataCarrier) Get(key string) string {
+	values := metadata.MD(mc).Get(key)
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// Set sets the value of the given key
+func (mc metadataCarrier) Set(key, value string) {
+	metadata.MD(mc).Set(key, value)
+}
+
+// Keys returns the keys of the metadata
+func (mc metadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(mc))
+	for key := range mc {
+		keys = append(keys, key)
+	}
+	return keys
+}
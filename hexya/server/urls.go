@@ -0,0 +1,37 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package server
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/hexya-erp/hexya/hexya/models"
+)
+
+// RecordRedirectPath is the path of the route redirecting to the web client
+// deep link of a record, given as <RecordRedirectPath>/<model>/<id>. Set it
+// to an empty string before PostInit to disable the route.
+var RecordRedirectPath = "/r"
+
+// registerRecordRedirectRoute creates the route redirecting to records
+func registerRecordRedirectRoute() {
+	if RecordRedirectPath == "" {
+		return
+	}
+	hexyaServer.Group("/").GET(RecordRedirectPath+"/:model/:id", handleRecordRedirect)
+}
+
+// handleRecordRedirect redirects to the web client deep link of the record
+// of the request. Access rights are not checked here but by the web client,
+// so that users who are not logged in are asked to log in first.
+func handleRecordRedirect(c *Context) {
+	_, ok := models.Registry.Get(c.Param("model"))
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if !ok || err != nil || id <= 0 {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	c.Redirect(http.StatusFound, models.RecordURL(c.Param("model"), id))
+}
@@ -0,0 +1,25 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package server
+
+import (
+	"time"
+
+	"github.com/hexya-erp/hexya/hexya/models"
+)
+
+// WebhookPollInterval is the time between two checks for due
+// webhook deliveries by the webhooks worker.
+var WebhookPollInterval = 5 * time.Second
+
+func init() {
+	RegisterPollingWorker(RoleJobRunner, "webhooks", &WebhookPollInterval, processWebhooks)
+}
+
+// processWebhooks sends the due webhook deliveries
+func processWebhooks() {
+	if count := models.ProcessWebhooks(); count > 0 {
+		log.Debug("Webhooks processed", "count", count)
+	}
+}
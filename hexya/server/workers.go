@@ -0,0 +1,167 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package server
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/hexya-erp/hexya/hexya/models"
+	"github.com/hexya-erp/hexya/hexya/tools/logging"
+)
+
+// A Role is a kind of work that a Hexya process performs. All roles share
+// the same code and database, so that processes with different roles can be
+// run and scaled independently, e.g. web workers and background workers.
+type Role string
+
+// Available roles
+const (
+	// RoleHTTP processes serve the HTTP requests of the clients
+	RoleHTTP Role = "http"
+	// RoleCron processes run the scheduled actions
+	RoleCron Role = "cron"
+	// RoleJobRunner processes run the queued background jobs
+	RoleJobRunner Role = "jobrunner"
+)
+
+// AllRoles is the list of all available roles. A process runs all
+// roles by default.
+var AllRoles = []Role{RoleHTTP, RoleCron, RoleJobRunner}
+
+// A WorkerFunc is a function that runs the background work of a role.
+// It must return when the stop channel is closed.
+type WorkerFunc func(stop <-chan struct{})
+
+// worker is a WorkerFunc registered for a role
+type worker struct {
+	name string
+	role Role
+	fnct WorkerFunc
+}
+
+var (
+	workers     []worker
+	roles       = make(map[Role]bool)
+	stopWorkers chan struct{}
+	workersWG   sync.WaitGroup
+)
+
+// RegisterWorker registers the given function to be run in its own goroutine
+// by the processes that have the given role. The name is only used for logging.
+//
+// This function should be called in the init() or PreInit() function of the
+// modules.
+func RegisterWorker(role Role, name string, fnct WorkerFunc) {
+	checkRole(role)
+	workers = append(workers, worker{name: name, role: role, fnct: fnct})
+}
+
+// RegisterPollingWorker registers a worker that calls the given function every
+// interval, starting when the worker starts, until the workers are stopped.
+// The interval is read when the worker starts, so that it can be changed
+// until then. Panics of the function are logged instead of stopping the worker.
+//
+// This function should be called in the init() or PreInit() function of the
+// modules.
+func RegisterPollingWorker(role Role, name string, interval *time.Duration, fnct func()) {
+	RegisterWorker(role, name, func(stop <-chan struct{}) {
+		poll(stop, *interval, fnct)
+	})
+}
+
+// poll calls the given function every interval until the stop channel is
+// closed, logging its panics.
+func poll(stop <-chan struct{}, interval time.Duration, fnct func()) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					logging.LogPanicData(r)
+				}
+			}()
+			fnct()
+		}()
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// SetRoles sets the roles of this process. It panics if a role is unknown
+// or if no role is given.
+func SetRoles(processRoles ...Role) {
+	if len(processRoles) == 0 {
+		log.Panic("At least one role must be given to the server")
+	}
+	roles = make(map[Role]bool)
+	for _, role := range processRoles {
+		checkRole(role)
+		roles[role] = true
+	}
+}
+
+// HasRole returns true if this process has the given role.
+// A process has all roles if SetRoles has not been called.
+func HasRole(role Role) bool {
+	if len(roles) == 0 {
+		return true
+	}
+	return roles[role]
+}
+
+// StartWorkers starts the registered workers of the roles of this process.
+func StartWorkers() {
+	stopWorkers = make(chan struct{})
+	for _, w := range workers {
+		if !HasRole(w.role) {
+			continue
+		}
+		workersWG.Add(1)
+		go func(w worker) {
+			defer workersWG.Done()
+			log.Info("Starting worker", "worker", w.name, "role", w.role)
+			w.fnct(stopWorkers)
+			log.Info("Worker stopped", "worker", w.name, "role", w.role)
+		}(w)
+	}
+}
+
+// StopWorkers stops the workers started by StartWorkers and waits for them
+// to return, which they do once their current job is done. It returns the
+// error of ctx if it is done before all workers returned.
+func StopWorkers(ctx context.Context) error {
+	if stopWorkers == nil {
+		return nil
+	}
+	models.StopProcessing()
+	close(stopWorkers)
+	stopWorkers = nil
+	done := make(chan struct{})
+	go func() {
+		workersWG.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// checkRole panics if the given role is not a known role
+func checkRole(role Role) {
+	for _, r := range AllRoles {
+		if r == role {
+			return
+		}
+	}
+	log.Panic("Unknown server role", "role", role)
+}
@@ -0,0 +1,39 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package server
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPollingWorkers(t *testing.T) {
+	Convey("Testing polling workers", t, func() {
+		calls := make(chan struct{}, 1)
+		stop := make(chan struct{})
+		done := make(chan struct{})
+		go func() {
+			poll(stop, time.Millisecond, func() {
+				select {
+				case calls <- struct{}{}:
+				default:
+				}
+				panic("polling error")
+			})
+			close(done)
+		}()
+		Convey("The function should be called again after a panic", func() {
+			<-calls
+			<-calls
+			close(stop)
+			select {
+			case <-done:
+			case <-time.After(time.Second):
+				t.Fatal("worker did not stop")
+			}
+		})
+	})
+}
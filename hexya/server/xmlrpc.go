@@ -0,0 +1,206 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/hexya-erp/hexya/hexya/models"
+	"github.com/hexya-erp/hexya/hexya/models/security"
+	"github.com/hexya-erp/hexya/hexya/models/types"
+	"github.com/hexya-erp/hexya/hexya/tools/exceptions"
+	"github.com/hexya-erp/hexya/hexya/tools/xmlrpc"
+)
+
+// XMLRPCPath is the path prefix of the XML-RPC endpoints of the Odoo external
+// API, <XMLRPCPath>/common and <XMLRPCPath>/object. Set it to an empty string
+// before PostInit to disable them.
+var XMLRPCPath = "/xmlrpc/2"
+
+// XMLRPCServerVersion is the Odoo server version reported by the version
+// method of the XML-RPC API. Some clients select the features they use
+// according to this version.
+var XMLRPCServerVersion = []interface{}{11, 0, 0, "final", 0, ""}
+
+// XML-RPC fault codes, as returned by Odoo
+const (
+	xmlrpcFaultApplication  = 1
+	xmlrpcFaultAccessDenied = 3
+)
+
+// registerXMLRPCRoutes creates the routes of the XML-RPC endpoints
+func registerXMLRPCRoutes() {
+	if XMLRPCPath == "" {
+		return
+	}
+	root := hexyaServer.Group("/")
+	root.POST(XMLRPCPath+"/common", handleXMLRPCCommon)
+	root.POST(XMLRPCPath+"/object", handleXMLRPCObject)
+}
+
+// handleXMLRPCCommon serves the common XML-RPC endpoint, which
+// provides the version, login and authenticate methods.
+func handleXMLRPCCommon(c *Context) {
+	method, params, err := xmlrpc.DecodeCall(c.Request.Body)
+	if err != nil {
+		c.xmlrpcFault(xmlrpcFaultApplication, err.Error())
+		return
+	}
+	switch method {
+	case "version":
+		c.xmlrpcResponse(map[string]interface{}{
+			"server_version":      fmt.Sprintf("%d.%d", XMLRPCServerVersion[0], XMLRPCServerVersion[1]),
+			"server_version_info": XMLRPCServerVersion,
+			"server_serie":        fmt.Sprintf("%d.%d", XMLRPCServerVersion[0], XMLRPCServerVersion[1]),
+			"protocol_version":    1,
+		})
+	case "login", "authenticate":
+		if len(params) < 3 {
+			c.xmlrpcFault(xmlrpcFaultApplication, "login expects the database, login and password")
+			return
+		}
+		login, _ := params[1].(string)
+		password, _ := params[2].(string)
+		if uid, _, ok := checkAPIKey(password); ok {
+			if xmlrpcUserLogin(uid) != login {
+				c.xmlrpcResponse(false)
+				return
+			}
+			c.xmlrpcResponse(uid)
+			return
+		}
+		uid, err := security.AuthenticationRegistry.Authenticate(login, password, types.NewContext())
+		if err != nil {
+			c.xmlrpcResponse(false)
+			return
+		}
+		c.xmlrpcResponse(uid)
+	default:
+		c.xmlrpcFault(xmlrpcFaultApplication, "Unknown method "+method)
+	}
+}
+
+// handleXMLRPCObject serves the object XML-RPC endpoint, which calls model
+// methods with the execute and execute_kw methods. Each request is
+// authenticated with the uid and password given in its parameters, and
+// executed in its own transaction. An API key can be given instead of the
+// password, in which case the call is restricted to the scopes of the key.
+func handleXMLRPCObject(c *Context) {
+	method, params, err := xmlrpc.DecodeCall(c.Request.Body)
+	if err != nil {
+		c.xmlrpcFault(xmlrpcFaultApplication, err.Error())
+		return
+	}
+	if (method != "execute" && method != "execute_kw") || len(params) < 5 {
+		c.xmlrpcFault(xmlrpcFaultApplication, "Unknown method "+method)
+		return
+	}
+	uid, _ := params[1].(int64)
+	password, _ := params[2].(string)
+	scopes, ok := checkXMLRPCUser(uid, password)
+	if !ok {
+		c.xmlrpcFault(xmlrpcFaultAccessDenied, "Access Denied")
+		return
+	}
+	modelName, _ := params[3].(string)
+	methodName, _ := params[4].(string)
+	args := params[5:]
+	var kwargs map[string]interface{}
+	if method == "execute_kw" {
+		args = nil
+		if len(params) > 5 {
+			args, _ = params[5].([]interface{})
+		}
+		if len(params) > 6 {
+			kwargs, _ = params[6].(map[string]interface{})
+		}
+	}
+	if err := models.CheckCounterQuota(models.QuotaAPICalls, 1); err != nil {
+		c.xmlrpcFault(xmlrpcFaultApplication, err.Error())
+		return
+	}
+	models.AddQuotaUsage(models.QuotaAPICalls, 1)
+	var result interface{}
+	err = traceRPC(c.Request.Context(), "xmlrpc", modelName, methodName, func() error {
+		return models.ExecuteInNewEnvironment(uid, func(env models.Environment) {
+			result = env.WithAPIScopes(scopes).ExecuteKW(modelName, methodName, args, kwargs)
+		})
+	})
+	if err != nil {
+		userError, _ := err.(exceptions.UserError)
+		c.xmlrpcFault(xmlrpcFaultApplication, userError.Message)
+		return
+	}
+	c.xmlrpcResponse(result)
+}
+
+// checkXMLRPCUser returns true if the given password, or API key,
+// authenticates the user with the given id. It also returns the scopes
+// of the API key, if the password is an API key.
+func checkXMLRPCUser(uid int64, password string) (models.APIScopes, bool) {
+	if uid == 0 {
+		return nil, false
+	}
+	if keyUID, scopes, ok := checkAPIKey(password); ok {
+		return scopes, keyUID == uid
+	}
+	login := xmlrpcUserLogin(uid)
+	if login == "" {
+		return nil, false
+	}
+	authUID, err := security.AuthenticationRegistry.Authenticate(login, password, types.NewContext())
+	return nil, err == nil && authUID == uid
+}
+
+// xmlrpcUserLogin returns the login of the user with the given id, or an
+// empty string if there is no such user. Users are looked up by the
+// models.UserLoginField field of the User model.
+func xmlrpcUserLogin(uid int64) string {
+	var login string
+	err := models.ExecuteInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+		users := env.Pool("User")
+		user := users.Search(users.Model().Field("ID").Equals(uid))
+		if !user.IsEmpty() {
+			login = user.Get(models.UserLoginField).(string)
+		}
+	})
+	if err != nil {
+		return ""
+	}
+	return login
+}
+
+// xmlrpcResponse writes the XML-RPC response returning the given result.
+// The result is first converted to its JSON representation, so that
+// Hexya types are encoded as in the JSON-RPC API.
+func (c *Context) xmlrpcResponse(result interface{}) {
+	data, err := json.Marshal(result)
+	if err != nil {
+		c.xmlrpcFault(xmlrpcFaultApplication, err.Error())
+		return
+	}
+	var value interface{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err = dec.Decode(&value); err != nil {
+		c.xmlrpcFault(xmlrpcFaultApplication, err.Error())
+		return
+	}
+	var buf bytes.Buffer
+	if err = xmlrpc.EncodeResponse(&buf, value); err != nil {
+		c.xmlrpcFault(xmlrpcFaultApplication, err.Error())
+		return
+	}
+	c.Data(http.StatusOK, "text/xml; charset=utf-8", buf.Bytes())
+}
+
+// xmlrpcFault writes the XML-RPC fault response with the given code and message
+func (c *Context) xmlrpcFault(code int, message string) {
+	var buf bytes.Buffer
+	xmlrpc.EncodeFault(&buf, xmlrpc.Fault{Code: code, String: message})
+	c.Data(http.StatusOK, "text/xml; charset=utf-8", buf.Bytes())
+}
@@ -6,6 +6,7 @@ package tests
 import (
 	"fmt"
 	"os"
+	"strconv"
 	"testing"
 
 	"github.com/hexya-erp/hexya/hexya/models"
@@ -15,7 +16,7 @@ import (
 	"github.com/spf13/viper"
 )
 
-var driver, user, password, prefix, debug string
+var driver, user, password, prefix, debug, template string
 
 // RunTests initializes the database, run the tests given by m and
 // tears the database down.
@@ -47,6 +48,11 @@ func RunTests(m *testing.M, moduleName string) {
 
 // InitializeTests initializes a database for the tests of the given module.
 // You probably want to use RunTests instead.
+//
+// If the HEXYA_DB_TEMPLATE environment variable is set, the database is
+// cloned from the given template database and only the module's changes are
+// synchronized. This is what the 'hexya test' command does to run the tests
+// of several modules in parallel.
 func InitializeTests(moduleName string) {
 	fmt.Printf("Initializing database for module %s\n", moduleName)
 	driver = os.Getenv("HEXYA_DB_DRIVER")
@@ -67,6 +73,7 @@ func InitializeTests(moduleName string) {
 	}
 	dbName := fmt.Sprintf("%s_%s_tests", prefix, moduleName)
 	debug = os.Getenv("HEXYA_DEBUG")
+	template = os.Getenv("HEXYA_DB_TEMPLATE")
 
 	viper.Set("LogLevel", "crit")
 	if debug != "" {
@@ -76,7 +83,12 @@ func InitializeTests(moduleName string) {
 	logging.Initialize()
 
 	db := sqlx.MustConnect(driver, fmt.Sprintf("dbname=postgres sslmode=disable user=%s password=%s", user, password))
-	db.MustExec(fmt.Sprintf("CREATE DATABASE %s", dbName))
+	if template != "" {
+		// Cloning the template is much faster than creating the schema from scratch
+		db.MustExec(fmt.Sprintf("CREATE DATABASE %s TEMPLATE %s", dbName, template))
+	} else {
+		db.MustExec(fmt.Sprintf("CREATE DATABASE %s", dbName))
+	}
 	db.Close()
 
 	models.DBConnect(driver, models.ConnectionParams{
@@ -85,12 +97,21 @@ func InitializeTests(moduleName string) {
 		Password: password,
 		SSLMode:  "disable",
 	})
+	server.LoadOptionalExtensions()
 	models.BootStrap()
 	models.SyncDatabase()
 	server.LoadDataRecords()
 	server.LoadDemoRecords()
 
 	server.PostInitModules()
+	server.GetServer().EnableRouter()
+	server.SetStatus(server.StatusReady)
+
+	// The query budget is only enforced on the tests themselves
+	if budget, err := strconv.Atoi(os.Getenv("HEXYA_DB_QUERY_BUDGET")); err == nil {
+		models.QueryBudget = budget
+	}
+	models.Testing = true
 }
 
 // TearDownTests tears down the tests for the given module
@@ -3,7 +3,7 @@
 
 /*
 Package b64image provides helper functions for manipulating
-base64 encoded PNG or JPEG images
+images, in particular base64 encoded PNG or JPEG images
 */
 package b64image
 
@@ -13,9 +13,11 @@ import (
 	"image"
 	"image/color"
 	"image/draw"
-	// Load jpeg driver
-	_ "image/jpeg"
+	// Load gif driver
+	_ "image/gif"
+	"image/jpeg"
 	"image/png"
+	"io"
 	"math/rand"
 	"strings"
 )
@@ -60,3 +62,62 @@ func ColorsEqual(clr1, clr2 color.Color) bool {
 	r2, g2, b2, a2 := clr2.RGBA()
 	return r1 == r2 && g1 == g2 && b1 == b2 && a1 == a2
 }
+
+// Resize returns the given image scaled down so that neither its width nor
+// its height exceeds size, keeping its aspect ratio. Each pixel of the result
+// is the average of the pixels of the original it covers.
+//
+// The image is returned as is if it already fits in size.
+func Resize(img image.Image, size int) image.Image {
+	srcW, srcH := img.Bounds().Dx(), img.Bounds().Dy()
+	if size <= 0 || (srcW <= size && srcH <= size) {
+		return img
+	}
+	dstW, dstH := size, size
+	if srcW > srcH {
+		dstH = srcH * size / srcW
+	} else {
+		dstW = srcW * size / srcH
+	}
+	if dstW < 1 {
+		dstW = 1
+	}
+	if dstH < 1 {
+		dstH = 1
+	}
+	src := image.NewNRGBA(image.Rect(0, 0, srcW, srcH))
+	draw.Draw(src, src.Bounds(), img, img.Bounds().Min, draw.Src)
+	dst := image.NewNRGBA(image.Rect(0, 0, dstW, dstH))
+	for y := 0; y < dstH; y++ {
+		y0, y1 := y*srcH/dstH, (y+1)*srcH/dstH
+		for x := 0; x < dstW; x++ {
+			x0, x1 := x*srcW/dstW, (x+1)*srcW/dstW
+			var r, g, b, a, n int
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					i := src.PixOffset(sx, sy)
+					r += int(src.Pix[i])
+					g += int(src.Pix[i+1])
+					b += int(src.Pix[i+2])
+					a += int(src.Pix[i+3])
+					n++
+				}
+			}
+			i := dst.PixOffset(x, y)
+			dst.Pix[i] = uint8(r / n)
+			dst.Pix[i+1] = uint8(g / n)
+			dst.Pix[i+2] = uint8(b / n)
+			dst.Pix[i+3] = uint8(a / n)
+		}
+	}
+	return dst
+}
+
+// Encode writes the given image to w in the given format, as returned by
+// image.Decode. JPEG images are written as JPEG, all others as PNG.
+func Encode(w io.Writer, img image.Image, format string) error {
+	if format == "jpeg" {
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: 90})
+	}
+	return png.Encode(w, img)
+}
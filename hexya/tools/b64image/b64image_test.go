@@ -4,6 +4,7 @@
 package b64image
 
 import (
+	"bytes"
 	"encoding/base64"
 	"image"
 	"image/color"
@@ -64,3 +65,33 @@ func TestColorize(t *testing.T) {
 		})
 	})
 }
+
+func TestResize(t *testing.T) {
+	Convey("Testing Resize function", t, func() {
+		imgData, _ := ioutil.ReadFile("testdata/avatar.png")
+		img, format, _ := image.Decode(bytes.NewReader(imgData))
+		Convey("Images larger than size should be scaled down", func() {
+			resized := Resize(img, 90)
+			So(resized.Bounds().Dx(), ShouldEqual, 90)
+			So(resized.Bounds().Dy(), ShouldEqual, 90)
+			So(ColorsEqual(resized.At(1, 1), color.RGBA{}), ShouldBeTrue)
+			So(ColorsEqual(resized.At(45, 45), color.RGBA{R: 217, G: 221, B: 226, A: 255}), ShouldBeTrue)
+		})
+		Convey("Aspect ratio should be kept", func() {
+			wide := image.NewRGBA(image.Rect(0, 0, 400, 100))
+			resized := Resize(wide, 200)
+			So(resized.Bounds().Dx(), ShouldEqual, 200)
+			So(resized.Bounds().Dy(), ShouldEqual, 50)
+		})
+		Convey("Images that fit in size should be returned as is", func() {
+			So(Resize(img, 200), ShouldEqual, img)
+		})
+		Convey("Resized images should be encoded in their original format", func() {
+			var buf bytes.Buffer
+			So(Encode(&buf, Resize(img, 32), format), ShouldBeNil)
+			_, newFormat, err := image.Decode(&buf)
+			So(err, ShouldBeNil)
+			So(newFormat, ShouldEqual, "png")
+		})
+	})
+}
@@ -0,0 +1,210 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+// Package cronexpr parses cron expressions and computes their next activation times.
+//
+// Expressions have five space separated fields:
+//
+//	minute  hour  day-of-month  month  day-of-week
+//	0-59    0-23  1-31          1-12   0-7 (0 and 7 are Sunday)
+//
+// Each field is either '*', a value, a range 'a-b', or a comma separated list
+// of these, optionally followed by a step '/n'. For instance '*/15 8-18 * * 1-5'
+// activates every quarter of an hour from 8:00 to 18:45 on week days.
+//
+// As in standard cron, if both the day-of-month and day-of-week fields are
+// restricted, a day matches if either of them matches.
+//
+// The following shortcuts are also available: @yearly, @monthly, @weekly,
+// @daily and @hourly.
+package cronexpr
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A Schedule is a parsed cron expression
+type Schedule struct {
+	source string
+	minute uint64
+	hour   uint64
+	dom    uint64
+	month  uint64
+	dow    uint64
+	anyDay bool
+	anyDow bool
+}
+
+// String returns the source of this Schedule
+func (s *Schedule) String() string {
+	return s.source
+}
+
+// bounds of a cron expression field
+type bounds struct {
+	name     string
+	min, max uint
+}
+
+var (
+	minuteBounds = bounds{"minute", 0, 59}
+	hourBounds   = bounds{"hour", 0, 23}
+	domBounds    = bounds{"day of month", 1, 31}
+	monthBounds  = bounds{"month", 1, 12}
+	dowBounds    = bounds{"day of week", 0, 7}
+)
+
+// shortcuts are the predefined schedules
+var shortcuts = map[string]string{
+	"@yearly":  "0 0 1 1 *",
+	"@monthly": "0 0 1 * *",
+	"@weekly":  "0 0 * * 0",
+	"@daily":   "0 0 * * *",
+	"@hourly":  "0 * * * *",
+}
+
+// Parse returns the Schedule of the given cron expression
+func Parse(source string) (*Schedule, error) {
+	spec := strings.TrimSpace(source)
+	if shortcut, ok := shortcuts[spec]; ok {
+		spec = shortcut
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron expression %q must have 5 fields, got %d", source, len(fields))
+	}
+	s := Schedule{source: source}
+	var err error
+	for i, f := range []struct {
+		bits *uint64
+		b    bounds
+	}{{&s.minute, minuteBounds}, {&s.hour, hourBounds}, {&s.dom, domBounds}, {&s.month, monthBounds}, {&s.dow, dowBounds}} {
+		*f.bits, err = parseField(fields[i], f.b)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cron expression %q: %s", source, err)
+		}
+	}
+	// Sunday is both 0 and 7
+	if s.dow&(1<<7) > 0 {
+		s.dow |= 1
+	}
+	s.anyDay = strings.HasPrefix(fields[2], "*")
+	s.anyDow = strings.HasPrefix(fields[4], "*")
+	return &s, nil
+}
+
+// MustParse returns the Schedule of the given cron expression.
+// It panics if the expression is invalid.
+func MustParse(source string) *Schedule {
+	s, err := Parse(source)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// parseField returns the bit set of the values of the given field
+func parseField(field string, b bounds) (uint64, error) {
+	var res uint64
+	for _, part := range strings.Split(field, ",") {
+		rangeExpr, step := part, uint(1)
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.ParseUint(part[i+1:], 10, 8)
+			if err != nil || n == 0 {
+				return 0, fmt.Errorf("invalid step in %s field: %q", b.name, part)
+			}
+			rangeExpr, step = part[:i], uint(n)
+		}
+		start, end := b.min, b.max
+		switch {
+		case rangeExpr == "*":
+		case strings.Contains(rangeExpr, "-"):
+			bnds := strings.SplitN(rangeExpr, "-", 2)
+			var err error
+			if start, err = parseValue(bnds[0], b); err != nil {
+				return 0, err
+			}
+			if end, err = parseValue(bnds[1], b); err != nil {
+				return 0, err
+			}
+			if start > end {
+				return 0, fmt.Errorf("invalid range in %s field: %q", b.name, part)
+			}
+		default:
+			val, err := parseValue(rangeExpr, b)
+			if err != nil {
+				return 0, err
+			}
+			start = val
+			if step == 1 {
+				end = val
+			}
+		}
+		for v := start; v <= end; v += step {
+			res |= 1 << v
+		}
+	}
+	return res, nil
+}
+
+// parseValue returns the given value of a field, checking its bounds
+func parseValue(value string, b bounds) (uint, error) {
+	v, err := strconv.ParseUint(value, 10, 8)
+	if err != nil || uint(v) < b.min || uint(v) > b.max {
+		return 0, fmt.Errorf("invalid value in %s field: %q (expected %d-%d)", b.name, value, b.min, b.max)
+	}
+	return uint(v), nil
+}
+
+// Next returns the first activation time of this Schedule strictly after t,
+// in the location of t. It returns the zero time if there is no activation
+// in the next five years, e.g. for "0 0 30 2 *".
+func (s *Schedule) Next(t time.Time) time.Time {
+	loc := t.Location()
+	t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, loc).Add(time.Minute)
+	yearLimit := t.Year() + 5
+
+wrap:
+	if t.Year() > yearLimit {
+		return time.Time{}
+	}
+	for s.month&(1<<uint(t.Month())) == 0 {
+		t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+		if t.Month() == time.January {
+			goto wrap
+		}
+	}
+	for !s.dayMatches(t) {
+		t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+		if t.Day() == 1 {
+			goto wrap
+		}
+	}
+	for s.hour&(1<<uint(t.Hour())) == 0 {
+		t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+		if t.Hour() == 0 {
+			goto wrap
+		}
+	}
+	for s.minute&(1<<uint(t.Minute())) == 0 {
+		t = t.Add(time.Minute)
+		if t.Minute() == 0 {
+			goto wrap
+		}
+	}
+	return t
+}
+
+// dayMatches returns true if the day of t matches the day of month
+// and day of week fields of this Schedule.
+func (s *Schedule) dayMatches(t time.Time) bool {
+	domMatch := s.dom&(1<<uint(t.Day())) > 0
+	dowMatch := s.dow&(1<<uint(t.Weekday())) > 0
+	if s.anyDay || s.anyDow {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
@@ -0,0 +1,66 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package cronexpr
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func nextOf(spec, from string) string {
+	t, err := time.Parse("2006-01-02 15:04", from)
+	So(err, ShouldBeNil)
+	next := MustParse(spec).Next(t)
+	if next.IsZero() {
+		return ""
+	}
+	return next.Format("2006-01-02 15:04")
+}
+
+func TestCronExpressions(t *testing.T) {
+	Convey("Testing cron expressions", t, func() {
+		Convey("Parsing valid expressions", func() {
+			for _, spec := range []string{"* * * * *", "*/15 8-18 * * 1-5", "0 0 1,15 * *", "5 4 * * 7", "@daily", " 0 12 * 1-6/2 0 "} {
+				_, err := Parse(spec)
+				So(err, ShouldBeNil)
+			}
+		})
+		Convey("Parsing invalid expressions", func() {
+			for _, spec := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *", "* * * * 8",
+				"*/0 * * * *", "5-1 * * * *", "a * * * *", "@never"} {
+				_, err := Parse(spec)
+				So(err, ShouldNotBeNil)
+			}
+			So(func() { MustParse("* *") }, ShouldPanic)
+		})
+		Convey("Computing next activations", func() {
+			So(nextOf("* * * * *", "2017-03-15 10:20"), ShouldEqual, "2017-03-15 10:21")
+			So(nextOf("*/15 * * * *", "2017-03-15 10:20"), ShouldEqual, "2017-03-15 10:30")
+			So(nextOf("0 * * * *", "2017-03-15 23:59"), ShouldEqual, "2017-03-16 00:00")
+			So(nextOf("30 8 * * *", "2017-03-15 08:30"), ShouldEqual, "2017-03-16 08:30")
+			So(nextOf("0 9 * * 1-5", "2017-03-17 10:00"), ShouldEqual, "2017-03-20 09:00")
+			So(nextOf("0 0 * * 7", "2017-03-15 10:00"), ShouldEqual, "2017-03-19 00:00")
+			So(nextOf("@monthly", "2017-12-15 10:00"), ShouldEqual, "2018-01-01 00:00")
+			So(nextOf("0 0 29 2 *", "2017-03-01 00:00"), ShouldEqual, "2020-02-29 00:00")
+			So(nextOf("0 0 30 2 *", "2017-03-01 00:00"), ShouldEqual, "")
+		})
+		Convey("Day of month and day of week should be combined with OR if both are set", func() {
+			So(nextOf("0 0 13 * 5", "2017-03-01 00:00"), ShouldEqual, "2017-03-03 00:00")
+			So(nextOf("0 0 13 * 5", "2017-03-11 00:00"), ShouldEqual, "2017-03-13 00:00")
+			So(nextOf("0 0 13 * *", "2017-03-01 00:00"), ShouldEqual, "2017-03-13 00:00")
+		})
+		Convey("Next activations should be in the location of the given time", func() {
+			paris, _ := time.LoadLocation("Europe/Paris")
+			next := MustParse("0 2 * * *").Next(time.Date(2017, 3, 24, 12, 0, 0, 0, paris))
+			So(next.Location(), ShouldEqual, paris)
+			So(next.Day(), ShouldEqual, 25)
+			So(next.Hour(), ShouldEqual, 2)
+			// 2:00 does not exist on DST change day
+			next = MustParse("0 2 * * *").Next(next)
+			So(next.Day(), ShouldEqual, 27)
+		})
+	})
+}
@@ -0,0 +1,210 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package expr
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+
+	"github.com/hexya-erp/hexya/hexya/models/types/dates"
+	"github.com/hexya-erp/hexya/hexya/tools/nbutils"
+)
+
+// Builtins are the functions available to all expressions, unless
+// a variable with the same name is given to the expression.
+//
+//	today()                 the current date (UTC)
+//	now()                   the current datetime
+//	date(s)                 the date of the "2006-01-02" formatted string s
+//	datetime(s)             the datetime of the "2006-01-02 15:04:05" formatted string s
+//	addDays(d, n)           the date or datetime d shifted by n days
+//	addWeeks(d, n)          the date or datetime d shifted by n weeks
+//	addMonths(d, n)         the date or datetime d shifted by n months
+//	addYears(d, n)          the date or datetime d shifted by n years
+//	len(v)                  the length of the string, list, map or Lengther v
+//	str(v)                  v formatted as a string
+//	int(v)                  v converted to an int64, strings are parsed
+//	float(v)                v converted to a float64, strings are parsed
+//	abs(x)                  the absolute value of x
+//	round(x, precision)     x rounded to a multiple of precision, e.g. 0.01
+//	min(x, y...)            the lowest of the given values
+//	max(x, y...)            the greatest of the given values
+var Builtins = map[string]Func{
+	"today": func(args ...interface{}) (interface{}, error) {
+		if err := checkArgs("today", args, 0); err != nil {
+			return nil, err
+		}
+		return dates.Today(), nil
+	},
+	"now": func(args ...interface{}) (interface{}, error) {
+		if err := checkArgs("now", args, 0); err != nil {
+			return nil, err
+		}
+		return dates.Now(), nil
+	},
+	"date": func(args ...interface{}) (interface{}, error) {
+		str, err := stringArg("date", args)
+		if err != nil {
+			return nil, err
+		}
+		return dates.ParseDate(dates.DefaultServerDateFormat, str)
+	},
+	"datetime": func(args ...interface{}) (interface{}, error) {
+		str, err := stringArg("datetime", args)
+		if err != nil {
+			return nil, err
+		}
+		return dates.ParseDateTime(dates.DefaultServerDateTimeFormat, str)
+	},
+	"addDays":   addPeriodFunc("addDays", dates.PeriodDay),
+	"addWeeks":  addPeriodFunc("addWeeks", dates.PeriodWeek),
+	"addMonths": addPeriodFunc("addMonths", dates.PeriodMonth),
+	"addYears":  addPeriodFunc("addYears", dates.PeriodYear),
+	"len": func(args ...interface{}) (interface{}, error) {
+		if err := checkArgs("len", args, 1); err != nil {
+			return nil, err
+		}
+		if l, ok := args[0].(Lengther); ok {
+			return int64(l.Len()), nil
+		}
+		val := reflect.ValueOf(args[0])
+		switch val.Kind() {
+		case reflect.String, reflect.Slice, reflect.Map:
+			return int64(val.Len()), nil
+		}
+		return nil, fmt.Errorf("len: %T value has no length", args[0])
+	},
+	"str": func(args ...interface{}) (interface{}, error) {
+		if err := checkArgs("str", args, 1); err != nil {
+			return nil, err
+		}
+		if args[0] == nil {
+			return "", nil
+		}
+		return fmt.Sprint(args[0]), nil
+	},
+	"int": func(args ...interface{}) (interface{}, error) {
+		if err := checkArgs("int", args, 1); err != nil {
+			return nil, err
+		}
+		switch v := normalize(args[0]).(type) {
+		case int64:
+			return v, nil
+		case float64:
+			return int64(v), nil
+		case bool:
+			if v {
+				return int64(1), nil
+			}
+			return int64(0), nil
+		case string:
+			return strconv.ParseInt(v, 10, 64)
+		}
+		return nil, fmt.Errorf("int: cannot convert %T value", args[0])
+	},
+	"float": func(args ...interface{}) (interface{}, error) {
+		if err := checkArgs("float", args, 1); err != nil {
+			return nil, err
+		}
+		if str, ok := args[0].(string); ok {
+			return strconv.ParseFloat(str, 64)
+		}
+		if num, ok := toNumber(args[0]); ok {
+			return num, nil
+		}
+		return nil, fmt.Errorf("float: cannot convert %T value", args[0])
+	},
+	"abs": func(args ...interface{}) (interface{}, error) {
+		if err := checkArgs("abs", args, 1); err != nil {
+			return nil, err
+		}
+		switch v := normalize(args[0]).(type) {
+		case int64:
+			if v < 0 {
+				return -v, nil
+			}
+			return v, nil
+		case float64:
+			return math.Abs(v), nil
+		}
+		return nil, fmt.Errorf("abs: %T value is not a number", args[0])
+	},
+	"round": func(args ...interface{}) (interface{}, error) {
+		if err := checkArgs("round", args, 2); err != nil {
+			return nil, err
+		}
+		value, ok := toNumber(args[0])
+		precision, pok := toNumber(args[1])
+		if !ok || !pok {
+			return nil, fmt.Errorf("round: arguments must be numbers")
+		}
+		return nbutils.Round(value, precision), nil
+	},
+	"min": extremumFunc("min", -1),
+	"max": extremumFunc("max", 1),
+}
+
+// checkArgs returns an error if args has not the given length
+func checkArgs(name string, args []interface{}, count int) error {
+	if len(args) != count {
+		return fmt.Errorf("%s: expected %d arguments, got %d", name, count, len(args))
+	}
+	return nil
+}
+
+// stringArg returns the single string argument of the given args
+func stringArg(name string, args []interface{}) (string, error) {
+	if err := checkArgs(name, args, 1); err != nil {
+		return "", err
+	}
+	str, ok := args[0].(string)
+	if !ok {
+		return "", fmt.Errorf("%s: expected a string argument, got %T", name, args[0])
+	}
+	return str, nil
+}
+
+// addPeriodFunc returns a Func that shifts a date or
+// datetime by the given number of periods.
+func addPeriodFunc(name string, period dates.Period) Func {
+	return func(args ...interface{}) (interface{}, error) {
+		if err := checkArgs(name, args, 2); err != nil {
+			return nil, err
+		}
+		count, ok := normalize(args[1]).(int64)
+		if !ok {
+			return nil, fmt.Errorf("%s: expected an integer, got %T", name, args[1])
+		}
+		switch d := args[0].(type) {
+		case dates.Date:
+			return d.AddPeriod(period, int(count)), nil
+		case dates.DateTime:
+			return d.AddPeriod(period, int(count)), nil
+		}
+		return nil, fmt.Errorf("%s: expected a date or datetime, got %T", name, args[0])
+	}
+}
+
+// extremumFunc returns a Func that returns the lowest (sign = -1)
+// or greatest (sign = 1) of its arguments.
+func extremumFunc(name string, sign int) Func {
+	return func(args ...interface{}) (interface{}, error) {
+		if len(args) == 0 {
+			return nil, fmt.Errorf("%s: expected at least one argument", name)
+		}
+		res := args[0]
+		for _, arg := range args[1:] {
+			cmp, err := compare(arg, res)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", name, err)
+			}
+			if cmp*sign > 0 {
+				res = arg
+			}
+		}
+		return res, nil
+	}
+}
@@ -0,0 +1,532 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+/*
+Package expr implements a small expression language to compute values from
+data files, automation rules or templates.
+
+Expressions are sandboxed: they can only access the variables and call the
+functions they are given, and they have no loops nor assignments. The
+language supports:
+
+  - int64, float64, string, boolean and null literals, and lists: [1, 2, 3]
+  - arithmetic: + - * / %, where / always returns a float64
+  - string concatenation with +
+  - comparisons: == != < <= > >= in, not in
+  - logical operators: and or not (or && || !)
+  - conditional expressions: cond ? valueIfTrue : valueIfFalse
+  - attributes of maps and Attributer values: record.Partner.Name
+  - indexing of lists and maps: values[0], context["lang"]
+  - calls to Func values: addDays(today(), 3)
+
+Dates and datetimes can be compared with each other and shifted with the
+built-in functions listed in Builtins.
+*/
+package expr
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/hexya-erp/hexya/hexya/models/types/dates"
+)
+
+// Vars are the variables available to an expression, by name.
+// Functions are given as Func values.
+type Vars map[string]interface{}
+
+// A Func is a function that can be called from an expression
+type Func func(args ...interface{}) (interface{}, error)
+
+// An Attributer is a value whose attributes can be accessed
+// with the dot syntax in expressions, such as records.
+type Attributer interface {
+	// Attribute returns the value of the attribute with the given name
+	// or an error if this value has no such attribute.
+	Attribute(name string) (interface{}, error)
+}
+
+// A Lengther is a value whose length can be
+// computed with the len() built-in function.
+type Lengther interface {
+	Len() int
+}
+
+// An Expression is a parsed expression that can be evaluated
+// several times with different variables.
+type Expression struct {
+	source string
+	root   node
+}
+
+// String returns the source of this expression
+func (e *Expression) String() string {
+	return e.source
+}
+
+// Eval evaluates this expression with the given variables and returns
+// its value. Variables take precedence over the Builtins functions.
+func (e *Expression) Eval(vars Vars) (interface{}, error) {
+	return e.root.eval(vars)
+}
+
+// Eval parses and evaluates the given source with the given variables.
+func Eval(source string, vars Vars) (interface{}, error) {
+	expression, err := Parse(source)
+	if err != nil {
+		return nil, err
+	}
+	return expression.Eval(vars)
+}
+
+// IsTrue returns the truth value of the given expression value.
+//
+// null, false, zero numbers, zero dates and empty strings, lists, maps
+// or Lengther values are false. All other values are true.
+func IsTrue(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case string:
+		return v != ""
+	case dates.Date:
+		return !v.IsZero()
+	case dates.DateTime:
+		return !v.IsZero()
+	case Lengther:
+		return v.Len() > 0
+	}
+	if num, ok := toNumber(value); ok {
+		return num != 0
+	}
+	val := reflect.ValueOf(value)
+	switch val.Kind() {
+	case reflect.Slice, reflect.Map:
+		return val.Len() > 0
+	}
+	return true
+}
+
+// A node is an element of the syntax tree of an expression
+type node interface {
+	eval(vars Vars) (interface{}, error)
+}
+
+// A literalNode is a constant value
+type literalNode struct {
+	value interface{}
+}
+
+func (n literalNode) eval(vars Vars) (interface{}, error) {
+	return n.value, nil
+}
+
+// An identNode is a variable or built-in function
+type identNode struct {
+	name string
+}
+
+func (n identNode) eval(vars Vars) (interface{}, error) {
+	if val, ok := vars[n.name]; ok {
+		return val, nil
+	}
+	if fnct, ok := Builtins[n.name]; ok {
+		return fnct, nil
+	}
+	return nil, fmt.Errorf("unknown variable %s", n.name)
+}
+
+// A listNode is a list of values
+type listNode struct {
+	items []node
+}
+
+func (n listNode) eval(vars Vars) (interface{}, error) {
+	res := make([]interface{}, len(n.items))
+	for i, item := range n.items {
+		val, err := item.eval(vars)
+		if err != nil {
+			return nil, err
+		}
+		res[i] = val
+	}
+	return res, nil
+}
+
+// An attributeNode is the access to an attribute of an object
+type attributeNode struct {
+	object node
+	name   string
+}
+
+func (n attributeNode) eval(vars Vars) (interface{}, error) {
+	obj, err := n.object.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	var values map[string]interface{}
+	switch o := obj.(type) {
+	case Attributer:
+		return o.Attribute(n.name)
+	case map[string]interface{}:
+		values = o
+	case Vars:
+		values = o
+	default:
+		return nil, fmt.Errorf("cannot access attribute %s of %T value", n.name, obj)
+	}
+	val, ok := values[n.name]
+	if !ok {
+		return nil, fmt.Errorf("unknown key %s", n.name)
+	}
+	return val, nil
+}
+
+// An indexNode is the access to an item of a list or map
+type indexNode struct {
+	object node
+	index  node
+}
+
+func (n indexNode) eval(vars Vars) (interface{}, error) {
+	obj, err := n.object.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	index, err := n.index.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	val := reflect.ValueOf(obj)
+	switch val.Kind() {
+	case reflect.Slice, reflect.String:
+		i, ok := toNumber(index)
+		if !ok || i != float64(int(i)) {
+			return nil, fmt.Errorf("invalid index %v", index)
+		}
+		if int(i) < 0 || int(i) >= val.Len() {
+			return nil, fmt.Errorf("index %d out of range", int(i))
+		}
+		if val.Kind() == reflect.String {
+			return string(val.String()[int(i)]), nil
+		}
+		return val.Index(int(i)).Interface(), nil
+	case reflect.Map:
+		if val.Type().Key().Kind() != reflect.String {
+			break
+		}
+		key, ok := index.(string)
+		if !ok {
+			return nil, fmt.Errorf("invalid key %v", index)
+		}
+		res := val.MapIndex(reflect.ValueOf(key).Convert(val.Type().Key()))
+		if !res.IsValid() {
+			return nil, fmt.Errorf("unknown key %s", key)
+		}
+		return res.Interface(), nil
+	}
+	return nil, fmt.Errorf("cannot index %T value", obj)
+}
+
+// A callNode is a function call
+type callNode struct {
+	function node
+	args     []node
+}
+
+func (n callNode) eval(vars Vars) (interface{}, error) {
+	fnct, err := n.function.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	var f Func
+	switch fn := fnct.(type) {
+	case Func:
+		f = fn
+	case func(...interface{}) (interface{}, error):
+		f = fn
+	default:
+		return nil, fmt.Errorf("%T value is not a function", fnct)
+	}
+	args := make([]interface{}, len(n.args))
+	for i, arg := range n.args {
+		if args[i], err = arg.eval(vars); err != nil {
+			return nil, err
+		}
+	}
+	return f(args...)
+}
+
+// A unaryNode is a unary operation
+type unaryNode struct {
+	op      string
+	operand node
+}
+
+func (n unaryNode) eval(vars Vars) (interface{}, error) {
+	val, err := n.operand.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	if n.op == "!" {
+		return !IsTrue(val), nil
+	}
+	switch v := normalize(val).(type) {
+	case int64:
+		return -v, nil
+	case float64:
+		return -v, nil
+	}
+	return nil, fmt.Errorf("cannot negate %T value", val)
+}
+
+// A logicalNode is a short-circuit and/or operation
+type logicalNode struct {
+	and   bool
+	left  node
+	right node
+}
+
+func (n logicalNode) eval(vars Vars) (interface{}, error) {
+	left, err := n.left.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	if IsTrue(left) != n.and {
+		return !n.and, nil
+	}
+	right, err := n.right.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	return IsTrue(right), nil
+}
+
+// A conditionalNode is a cond ? ifTrue : ifFalse expression
+type conditionalNode struct {
+	cond    node
+	ifTrue  node
+	ifFalse node
+}
+
+func (n conditionalNode) eval(vars Vars) (interface{}, error) {
+	cond, err := n.cond.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	if IsTrue(cond) {
+		return n.ifTrue.eval(vars)
+	}
+	return n.ifFalse.eval(vars)
+}
+
+// A binaryNode is an arithmetic or comparison operation
+type binaryNode struct {
+	op    string
+	left  node
+	right node
+}
+
+func (n binaryNode) eval(vars Vars) (interface{}, error) {
+	left, err := n.left.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	right, err := n.right.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "+", "-", "*", "/", "%":
+		return arithmetic(n.op, left, right)
+	case "==":
+		return equal(left, right), nil
+	case "!=":
+		return !equal(left, right), nil
+	case "in":
+		return contains(right, left)
+	case "not in":
+		res, err := contains(right, left)
+		return !res, err
+	}
+	cmp, err := compare(left, right)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "<":
+		return cmp < 0, nil
+	case "<=":
+		return cmp <= 0, nil
+	case ">":
+		return cmp > 0, nil
+	default:
+		return cmp >= 0, nil
+	}
+}
+
+// normalize returns the given value with integers converted
+// to int64 and floats converted to float64.
+func normalize(value interface{}) interface{} {
+	val := reflect.ValueOf(value)
+	switch val.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return val.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(val.Uint())
+	case reflect.Float32, reflect.Float64:
+		return val.Float()
+	}
+	return value
+}
+
+// toNumber returns the given value as a float64 and
+// true if it is a number, or false otherwise.
+func toNumber(value interface{}) (float64, bool) {
+	switch v := normalize(value).(type) {
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+// arithmetic returns the result of the given arithmetic operation
+func arithmetic(op string, left, right interface{}) (interface{}, error) {
+	left, right = normalize(left), normalize(right)
+	if l, ok := left.(string); ok && op == "+" {
+		if r, ok := right.(string); ok {
+			return l + r, nil
+		}
+	}
+	l, lok := left.(int64)
+	r, rok := right.(int64)
+	if lok && rok && op != "/" {
+		switch op {
+		case "+":
+			return l + r, nil
+		case "-":
+			return l - r, nil
+		case "*":
+			return l * r, nil
+		case "%":
+			if r == 0 {
+				return nil, fmt.Errorf("division by zero")
+			}
+			return l % r, nil
+		}
+	}
+	lf, lok := toNumber(left)
+	rf, rok := toNumber(right)
+	if !lok || !rok || op == "%" {
+		return nil, fmt.Errorf("unsupported operation %T %s %T", left, op, right)
+	}
+	switch op {
+	case "+":
+		return lf + rf, nil
+	case "-":
+		return lf - rf, nil
+	case "*":
+		return lf * rf, nil
+	}
+	if rf == 0 {
+		return nil, fmt.Errorf("division by zero")
+	}
+	return lf / rf, nil
+}
+
+// equal returns true if both values are equal.
+// Numbers are equal if they have the same value, whatever their type.
+func equal(left, right interface{}) bool {
+	if l, ok := toNumber(left); ok {
+		r, ok := toNumber(right)
+		return ok && l == r
+	}
+	if cmp, err := compare(left, right); err == nil {
+		return cmp == 0
+	}
+	return reflect.DeepEqual(left, right)
+}
+
+// compare returns -1, 0 or 1 if left is respectively lower than, equal to
+// or greater than right. It returns an error if values cannot be compared.
+func compare(left, right interface{}) (int, error) {
+	if l, ok := toNumber(left); ok {
+		if r, ok := toNumber(right); ok {
+			switch {
+			case l < r:
+				return -1, nil
+			case l > r:
+				return 1, nil
+			}
+			return 0, nil
+		}
+	}
+	switch l := left.(type) {
+	case string:
+		if r, ok := right.(string); ok {
+			return strings.Compare(l, r), nil
+		}
+	case dates.Date:
+		switch r := right.(type) {
+		case dates.Date:
+			return compareDates(l.Lower(r), l.Greater(r)), nil
+		case dates.DateTime:
+			return compareDates(l.Time.Before(r.Time), l.Time.After(r.Time)), nil
+		}
+	case dates.DateTime:
+		switch r := right.(type) {
+		case dates.DateTime:
+			return compareDates(l.Lower(r), l.Greater(r)), nil
+		case dates.Date:
+			return compareDates(l.Time.Before(r.Time), l.Time.After(r.Time)), nil
+		}
+	}
+	return 0, fmt.Errorf("cannot compare %T and %T values", left, right)
+}
+
+// compareDates returns the result of compare from
+// the lower and greater comparisons of two dates.
+func compareDates(lower, greater bool) int {
+	switch {
+	case lower:
+		return -1
+	case greater:
+		return 1
+	}
+	return 0
+}
+
+// contains returns true if the given container contains value.
+// Containers can be strings, lists or maps with string keys.
+func contains(container, value interface{}) (bool, error) {
+	if str, ok := container.(string); ok {
+		sub, ok := value.(string)
+		if !ok {
+			return false, fmt.Errorf("cannot search %T value in a string", value)
+		}
+		return strings.Contains(str, sub), nil
+	}
+	val := reflect.ValueOf(container)
+	switch val.Kind() {
+	case reflect.Slice:
+		for i := 0; i < val.Len(); i++ {
+			if equal(val.Index(i).Interface(), value) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case reflect.Map:
+		for _, key := range val.MapKeys() {
+			if equal(key.Interface(), value) {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	return false, fmt.Errorf("cannot search in %T value", container)
+}
@@ -0,0 +1,169 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package expr
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hexya-erp/hexya/hexya/models/types/dates"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// testRecord is an Attributer for tests
+type testRecord map[string]interface{}
+
+func (r testRecord) Attribute(name string) (interface{}, error) {
+	val, ok := r[name]
+	if !ok {
+		return nil, errors.New("unknown field")
+	}
+	return val, nil
+}
+
+func mustEval(source string, vars Vars) interface{} {
+	res, err := Eval(source, vars)
+	So(err, ShouldBeNil)
+	return res
+}
+
+func TestExpressions(t *testing.T) {
+	Convey("Testing expressions", t, func() {
+		Convey("Literals", func() {
+			So(mustEval("42", nil), ShouldEqual, int64(42))
+			So(mustEval("1.5", nil), ShouldEqual, 1.5)
+			So(mustEval(`'it\'s'`, nil), ShouldEqual, "it's")
+			So(mustEval(`"double"`, nil), ShouldEqual, "double")
+			So(mustEval("true", nil), ShouldEqual, true)
+			So(mustEval("null", nil), ShouldBeNil)
+			So(mustEval("[1, 'a', false]", nil), ShouldResemble, []interface{}{int64(1), "a", false})
+			So(mustEval("[]", nil), ShouldResemble, []interface{}{})
+		})
+		Convey("Arithmetic", func() {
+			So(mustEval("1 + 2 * 3", nil), ShouldEqual, int64(7))
+			So(mustEval("(1 + 2) * 3", nil), ShouldEqual, int64(9))
+			So(mustEval("7 / 2", nil), ShouldEqual, 3.5)
+			So(mustEval("7 % 4 - -1", nil), ShouldEqual, int64(4))
+			So(mustEval("1 + 0.5", nil), ShouldEqual, 1.5)
+			So(mustEval("'foo' + 'bar'", nil), ShouldEqual, "foobar")
+			So(mustEval("x * 2", Vars{"x": 21}), ShouldEqual, int64(42))
+			_, err := Eval("1 / 0", nil)
+			So(err, ShouldNotBeNil)
+			_, err = Eval("'a' - 1", nil)
+			So(err, ShouldNotBeNil)
+		})
+		Convey("Comparisons and logic", func() {
+			So(mustEval("1 < 2 and 2 <= 2", nil), ShouldEqual, true)
+			So(mustEval("1 == 1.0", nil), ShouldEqual, true)
+			So(mustEval("'a' != 'b' && !false", nil), ShouldEqual, true)
+			So(mustEval("not 1 > 2", nil), ShouldEqual, true)
+			So(mustEval("false or null", nil), ShouldEqual, false)
+			So(mustEval("2 in [1, 2, 3]", nil), ShouldEqual, true)
+			So(mustEval("'z' not in 'abc'", nil), ShouldEqual, true)
+			So(mustEval("x > 0 ? 'positive' : 'negative'", Vars{"x": -3}), ShouldEqual, "negative")
+			Convey("and/or should short-circuit", func() {
+				So(mustEval("false and unknown", nil), ShouldEqual, false)
+				So(mustEval("true or unknown", nil), ShouldEqual, true)
+			})
+			_, err := Eval("1 < 'a'", nil)
+			So(err, ShouldNotBeNil)
+		})
+		Convey("Attributes, indexes and calls", func() {
+			vars := Vars{
+				"record": testRecord{"Name": "John", "Partner": testRecord{"City": "Paris"}},
+				"env":    map[string]interface{}{"lang": "fr_FR"},
+				"ids":    []int64{4, 5, 6},
+				"double": Func(func(args ...interface{}) (interface{}, error) {
+					return args[0].(int64) * 2, nil
+				}),
+			}
+			So(mustEval("record.Name", vars), ShouldEqual, "John")
+			So(mustEval("record.Partner.City", vars), ShouldEqual, "Paris")
+			So(mustEval("env.lang", vars), ShouldEqual, "fr_FR")
+			So(mustEval("env['lang']", vars), ShouldEqual, "fr_FR")
+			So(mustEval("ids[1]", vars), ShouldEqual, int64(5))
+			So(mustEval("5 in ids", vars), ShouldEqual, true)
+			So(mustEval("double(len(ids))", vars), ShouldEqual, int64(6))
+			_, err := Eval("record.Unknown", vars)
+			So(err, ShouldNotBeNil)
+			_, err = Eval("ids[3]", vars)
+			So(err, ShouldNotBeNil)
+			_, err = Eval("record.Name()", vars)
+			So(err, ShouldNotBeNil)
+		})
+		Convey("Variables should shadow builtins", func() {
+			today := dates.Date{Time: dates.Today().AddDate(1, 0, 0).Time}
+			vars := Vars{"today": Func(func(args ...interface{}) (interface{}, error) { return today, nil })}
+			So(mustEval("today()", vars), ShouldResemble, today)
+		})
+		Convey("Unknown variables should fail", func() {
+			_, err := Eval("foo + 1", nil)
+			So(err, ShouldNotBeNil)
+		})
+		Convey("Syntax errors", func() {
+			for _, source := range []string{"1 +", "(1", "'abc", "a.", "1 2", "f(1,", "a ? b", "#", "and"} {
+				_, err := Parse(source)
+				So(err, ShouldNotBeNil)
+			}
+			So(func() { MustParse("1 +") }, ShouldPanic)
+		})
+		Convey("Parsed expressions can be evaluated several times", func() {
+			e := MustParse("x + 1")
+			So(e.String(), ShouldEqual, "x + 1")
+			res1, _ := e.Eval(Vars{"x": 1})
+			res2, _ := e.Eval(Vars{"x": 2})
+			So(res1, ShouldEqual, int64(2))
+			So(res2, ShouldEqual, int64(3))
+		})
+	})
+}
+
+func TestBuiltins(t *testing.T) {
+	Convey("Testing built-in functions", t, func() {
+		Convey("Dates", func() {
+			So(mustEval("date('2017-01-31')", nil), ShouldResemble, dates.Date{Time: mustParseDate("2017-01-31")})
+			So(mustEval("addMonths(date('2017-01-31'), 1)", nil).(dates.Date).String(), ShouldEqual, "2017-02-28")
+			So(mustEval("addDays(date('2017-01-31'), -31)", nil).(dates.Date).String(), ShouldEqual, "2016-12-31")
+			So(mustEval("addWeeks(datetime('2017-01-31 10:00:00'), 1)", nil).(dates.DateTime).String(), ShouldEqual, "2017-02-07 10:00:00")
+			So(mustEval("addYears(date('2016-02-29'), 1)", nil).(dates.Date).String(), ShouldEqual, "2017-02-28")
+			So(mustEval("date('2017-01-31') < date('2017-02-01')", nil), ShouldEqual, true)
+			So(mustEval("date('2017-01-31') == date('2017-01-31')", nil), ShouldEqual, true)
+			So(mustEval("today() <= now()", nil), ShouldEqual, true)
+			_, err := Eval("date('31/01/2017')", nil)
+			So(err, ShouldNotBeNil)
+			_, err = Eval("addDays('2017-01-31', 1)", nil)
+			So(err, ShouldNotBeNil)
+		})
+		Convey("Conversions", func() {
+			So(mustEval("str(12) + str(null)", nil), ShouldEqual, "12")
+			So(mustEval("int('12') + int(2.7) + int(true)", nil), ShouldEqual, int64(15))
+			So(mustEval("float('1.5') + float(1)", nil), ShouldEqual, 2.5)
+			So(mustEval("len('abc') + len([1])", nil), ShouldEqual, int64(4))
+		})
+		Convey("Numbers", func() {
+			So(mustEval("abs(-3)", nil), ShouldEqual, int64(3))
+			So(mustEval("abs(-1.5)", nil), ShouldEqual, 1.5)
+			So(mustEval("round(2.345, 0.01)", nil), ShouldEqual, 2.35)
+			So(mustEval("min(3, 1.5, 2)", nil), ShouldEqual, 1.5)
+			So(mustEval("max('a', 'c', 'b')", nil), ShouldEqual, "c")
+			_, err := Eval("max()", nil)
+			So(err, ShouldNotBeNil)
+		})
+		Convey("Wrong number of arguments", func() {
+			_, err := Eval("today(1)", nil)
+			So(err, ShouldNotBeNil)
+			_, err = Eval("len()", nil)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func mustParseDate(value string) time.Time {
+	d, err := dates.ParseDate(dates.DefaultServerDateFormat, value)
+	if err != nil {
+		panic(err)
+	}
+	return d.Time
+}
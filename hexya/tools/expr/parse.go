@@ -0,0 +1,449 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package expr
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// maxExpressionLength is the maximum length of an expression source.
+// Since expressions have no loops, this bounds their evaluation time.
+const maxExpressionLength = 10000
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenNumber
+	tokenString
+	tokenIdent
+	tokenOperator
+)
+
+// A token is a lexical element of an expression
+type token struct {
+	kind  tokenKind
+	value string
+	pos   int
+}
+
+// operators are the operators of the expression language,
+// two characters operators first.
+var operators = []string{"==", "!=", "<=", ">=", "&&", "||",
+	"+", "-", "*", "/", "%", "<", ">", "!", "(", ")", "[", "]", ",", ".", "?", ":"}
+
+// tokenize splits the given source into tokens
+func tokenize(source string) ([]token, error) {
+	var res []token
+	pos := 0
+	for pos < len(source) {
+		c := rune(source[pos])
+		switch {
+		case unicode.IsSpace(c):
+			pos++
+		case c >= '0' && c <= '9':
+			start := pos
+			for pos < len(source) && (source[pos] >= '0' && source[pos] <= '9' || source[pos] == '.') {
+				pos++
+			}
+			res = append(res, token{kind: tokenNumber, value: source[start:pos], pos: start})
+		case c == '\'' || c == '"':
+			str, end, err := scanString(source, pos)
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, token{kind: tokenString, value: str, pos: pos})
+			pos = end
+		case c == '_' || unicode.IsLetter(c):
+			start := pos
+			for pos < len(source) && (source[pos] == '_' || unicode.IsLetter(rune(source[pos])) || unicode.IsDigit(rune(source[pos]))) {
+				pos++
+			}
+			res = append(res, token{kind: tokenIdent, value: source[start:pos], pos: start})
+		default:
+			var op string
+			for _, o := range operators {
+				if strings.HasPrefix(source[pos:], o) {
+					op = o
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("unexpected character %q at position %d", c, pos)
+			}
+			res = append(res, token{kind: tokenOperator, value: op, pos: pos})
+			pos += len(op)
+		}
+	}
+	res = append(res, token{kind: tokenEOF, pos: pos})
+	return res, nil
+}
+
+// scanString returns the string literal starting at the given position
+// of source, and the position just after it.
+func scanString(source string, start int) (string, int, error) {
+	quote := source[start]
+	var buf bytes.Buffer
+	for pos := start + 1; pos < len(source); pos++ {
+		switch source[pos] {
+		case quote:
+			return buf.String(), pos + 1, nil
+		case '\\':
+			pos++
+			if pos == len(source) {
+				break
+			}
+			switch source[pos] {
+			case 'n':
+				buf.WriteByte('\n')
+			case 't':
+				buf.WriteByte('\t')
+			default:
+				buf.WriteByte(source[pos])
+			}
+		default:
+			buf.WriteByte(source[pos])
+		}
+	}
+	return "", 0, fmt.Errorf("unterminated string starting at position %d", start)
+}
+
+// A parser builds the syntax tree of an expression from its tokens.
+//
+// The grammar is, from the lowest to the highest precedence:
+//
+//	expression  = or [ "?" expression ":" expression ]
+//	or          = and { ( "or" | "||" ) and }
+//	and         = not { ( "and" | "&&" ) not }
+//	not         = ( "not" | "!" ) not | comparison
+//	comparison  = additive [ ( "==" | "!=" | "<" | "<=" | ">" | ">=" | "in" | "not" "in" ) additive ]
+//	additive    = term { ( "+" | "-" ) term }
+//	term        = unary { ( "*" | "/" | "%" ) unary }
+//	unary       = "-" unary | postfix
+//	postfix     = primary { "." ident | "(" [ args ] ")" | "[" expression "]" }
+//	primary     = number | string | "true" | "false" | "null" | ident | "(" expression ")" | "[" [ args ] "]"
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+// Parse parses the given source and returns the resulting Expression
+func Parse(source string) (*Expression, error) {
+	if len(source) > maxExpressionLength {
+		return nil, fmt.Errorf("expression is too long (%d characters, max %d)", len(source), maxExpressionLength)
+	}
+	tokens, err := tokenize(source)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	root, err := p.parseExpression()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokenEOF {
+		return nil, fmt.Errorf("unexpected %q at position %d", tok.value, tok.pos)
+	}
+	return &Expression{source: source, root: root}, nil
+}
+
+// MustParse parses the given source and returns the resulting
+// Expression. It panics if the source cannot be parsed.
+func MustParse(source string) *Expression {
+	res, err := Parse(source)
+	if err != nil {
+		panic(fmt.Errorf("unable to parse expression %q: %s", source, err))
+	}
+	return res
+}
+
+// peek returns the current token without consuming it
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+// next consumes and returns the current token
+func (p *parser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokenEOF {
+		p.pos++
+	}
+	return tok
+}
+
+// accept consumes the current token and returns true if it is
+// an operator or an identifier with one of the given values.
+func (p *parser) accept(values ...string) (string, bool) {
+	tok := p.peek()
+	if tok.kind != tokenOperator && tok.kind != tokenIdent {
+		return "", false
+	}
+	for _, v := range values {
+		if tok.value == v {
+			p.next()
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// expect consumes the current token or returns an error
+// if it is not the given operator.
+func (p *parser) expect(op string) error {
+	if _, ok := p.accept(op); !ok {
+		tok := p.peek()
+		return fmt.Errorf("expected %q at position %d, got %q", op, tok.pos, tok.value)
+	}
+	return nil
+}
+
+func (p *parser) parseExpression() (node, error) {
+	cond, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := p.accept("?"); !ok {
+		return cond, nil
+	}
+	ifTrue, err := p.parseExpression()
+	if err != nil {
+		return nil, err
+	}
+	if err = p.expect(":"); err != nil {
+		return nil, err
+	}
+	ifFalse, err := p.parseExpression()
+	if err != nil {
+		return nil, err
+	}
+	return conditionalNode{cond: cond, ifTrue: ifTrue, ifFalse: ifFalse}, nil
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := p.accept("or", "||"); !ok {
+			return left, nil
+		}
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = logicalNode{and: false, left: left, right: right}
+	}
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := p.accept("and", "&&"); !ok {
+			return left, nil
+		}
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = logicalNode{and: true, left: left, right: right}
+	}
+}
+
+func (p *parser) parseNot() (node, error) {
+	if _, ok := p.accept("not", "!"); ok {
+		operand, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return unaryNode{op: "!", operand: operand}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() (node, error) {
+	left, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+	op, ok := p.accept("==", "!=", "<", "<=", ">", ">=", "in")
+	if !ok {
+		if p.peek().value != "not" || p.tokens[p.pos+1].value != "in" {
+			return left, nil
+		}
+		p.pos += 2
+		op = "not in"
+	}
+	right, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+	return binaryNode{op: op, left: left, right: right}, nil
+}
+
+func (p *parser) parseAdditive() (node, error) {
+	left, err := p.parseTerm()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.accept("+", "-")
+		if !ok {
+			return left, nil
+		}
+		right, err := p.parseTerm()
+		if err != nil {
+			return nil, err
+		}
+		left = binaryNode{op: op, left: left, right: right}
+	}
+}
+
+func (p *parser) parseTerm() (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.accept("*", "/", "%")
+		if !ok {
+			return left, nil
+		}
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = binaryNode{op: op, left: left, right: right}
+	}
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if _, ok := p.accept("-"); ok {
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return unaryNode{op: "-", operand: operand}, nil
+	}
+	return p.parsePostfix()
+}
+
+func (p *parser) parsePostfix() (node, error) {
+	res, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.accept(".", "(", "[")
+		if !ok {
+			return res, nil
+		}
+		switch op {
+		case ".":
+			tok := p.next()
+			if tok.kind != tokenIdent {
+				return nil, fmt.Errorf("expected attribute name at position %d, got %q", tok.pos, tok.value)
+			}
+			res = attributeNode{object: res, name: tok.value}
+		case "(":
+			args, err := p.parseArgs(")")
+			if err != nil {
+				return nil, err
+			}
+			res = callNode{function: res, args: args}
+		case "[":
+			index, err := p.parseExpression()
+			if err != nil {
+				return nil, err
+			}
+			if err = p.expect("]"); err != nil {
+				return nil, err
+			}
+			res = indexNode{object: res, index: index}
+		}
+	}
+}
+
+// parseArgs parses a comma separated list of expressions
+// until the given closing operator, which is consumed.
+func (p *parser) parseArgs(closing string) ([]node, error) {
+	var res []node
+	if _, ok := p.accept(closing); ok {
+		return res, nil
+	}
+	for {
+		arg, err := p.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, arg)
+		if _, ok := p.accept(closing); ok {
+			return res, nil
+		}
+		if err = p.expect(","); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	tok := p.next()
+	switch tok.kind {
+	case tokenNumber:
+		if strings.Contains(tok.value, ".") {
+			val, err := strconv.ParseFloat(tok.value, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid number %q at position %d", tok.value, tok.pos)
+			}
+			return literalNode{value: val}, nil
+		}
+		val, err := strconv.ParseInt(tok.value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q at position %d", tok.value, tok.pos)
+		}
+		return literalNode{value: val}, nil
+	case tokenString:
+		return literalNode{value: tok.value}, nil
+	case tokenIdent:
+		switch tok.value {
+		case "true":
+			return literalNode{value: true}, nil
+		case "false":
+			return literalNode{value: false}, nil
+		case "null":
+			return literalNode{value: nil}, nil
+		case "and", "or", "not", "in":
+			return nil, fmt.Errorf("unexpected %q at position %d", tok.value, tok.pos)
+		}
+		return identNode{name: tok.value}, nil
+	case tokenOperator:
+		switch tok.value {
+		case "(":
+			res, err := p.parseExpression()
+			if err != nil {
+				return nil, err
+			}
+			if err = p.expect(")"); err != nil {
+				return nil, err
+			}
+			return res, nil
+		case "[":
+			items, err := p.parseArgs("]")
+			if err != nil {
+				return nil, err
+			}
+			return listNode{items: items}, nil
+		}
+	case tokenEOF:
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+	return nil, fmt.Errorf("unexpected %q at position %d", tok.value, tok.pos)
+}
@@ -0,0 +1,131 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package filestore
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const helloChecksum = "aaf4c61ddcc5e8a2dabede0f3b482cd9aea9434d"
+
+func TestLocalFilestore(t *testing.T) {
+	Convey("Testing local filestore", t, func() {
+		dir, err := ioutil.TempDir("", "hexya-filestore")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		store, err := NewLocal(filepath.Join(dir, "store"))
+		So(err, ShouldBeNil)
+		So(store.Exists(helloChecksum), ShouldBeFalse)
+		So(store.Write(helloChecksum, strings.NewReader("hello")), ShouldBeNil)
+		So(store.Exists(helloChecksum), ShouldBeTrue)
+		_, err = os.Stat(filepath.Join(dir, "store", "aa", helloChecksum))
+		So(err, ShouldBeNil)
+		reader, err := store.Open(helloChecksum)
+		So(err, ShouldBeNil)
+		content, _ := ioutil.ReadAll(reader)
+		reader.Close()
+		So(string(content), ShouldEqual, "hello")
+		_, err = store.Open("0000")
+		So(err, ShouldNotBeNil)
+		size, err := store.Size(helloChecksum)
+		So(err, ShouldBeNil)
+		So(size, ShouldEqual, 5)
+		_, err = store.Size("0000")
+		So(err, ShouldNotBeNil)
+		Convey("Contents should be listed and removed", func() {
+			checksums, err := store.Checksums(time.Now().Add(time.Minute))
+			So(err, ShouldBeNil)
+			So(checksums, ShouldResemble, []string{helloChecksum})
+			checksums, err = store.Checksums(time.Now().Add(-time.Hour))
+			So(err, ShouldBeNil)
+			So(checksums, ShouldBeEmpty)
+			So(store.Remove(helloChecksum), ShouldBeNil)
+			So(store.Exists(helloChecksum), ShouldBeFalse)
+			So(store.Remove(helloChecksum), ShouldBeNil)
+		})
+	})
+}
+
+func TestS3Filestore(t *testing.T) {
+	Convey("Testing S3 filestore", t, func() {
+		var mu sync.Mutex
+		objects := make(map[string][]byte)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=key/") {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case r.Method == "GET" && r.URL.Path == "/bucket":
+				fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><ListBucketResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/">`)
+				for key := range objects {
+					if strings.HasPrefix(key, "/bucket/"+r.URL.Query().Get("prefix")) {
+						fmt.Fprintf(w, "<Contents><Key>%s</Key><LastModified>2017-01-01T00:00:00.000Z</LastModified></Contents>",
+							strings.TrimPrefix(key, "/bucket/"))
+					}
+				}
+				fmt.Fprint(w, "<IsTruncated>false</IsTruncated></ListBucketResult>")
+			case r.Method == "DELETE":
+				delete(objects, r.URL.Path)
+				w.WriteHeader(http.StatusNoContent)
+			case r.Method == "PUT":
+				objects[r.URL.Path], _ = ioutil.ReadAll(r.Body)
+			case r.Method == "HEAD" || r.Method == "GET":
+				content, ok := objects[r.URL.Path]
+				if !ok {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+				w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+				w.Write(content)
+			}
+		}))
+		defer server.Close()
+		store := &S3{
+			Endpoint:  server.URL,
+			Region:    "us-east-1",
+			Bucket:    "bucket",
+			Prefix:    "files/",
+			AccessKey: "key",
+			SecretKey: "secret",
+		}
+		So(store.Exists(helloChecksum), ShouldBeFalse)
+		So(store.Write(helloChecksum, strings.NewReader("hello")), ShouldBeNil)
+		So(objects, ShouldContainKey, "/bucket/files/"+helloChecksum)
+		So(store.Exists(helloChecksum), ShouldBeTrue)
+		reader, err := store.Open(helloChecksum)
+		So(err, ShouldBeNil)
+		content, _ := ioutil.ReadAll(reader)
+		reader.Close()
+		So(string(content), ShouldEqual, "hello")
+		_, err = store.Open("0000")
+		So(err, ShouldNotBeNil)
+		size, err := store.Size(helloChecksum)
+		So(err, ShouldBeNil)
+		So(size, ShouldEqual, 5)
+		_, err = store.Size("0000")
+		So(err, ShouldNotBeNil)
+		Convey("Contents should be listed and removed", func() {
+			checksums, err := store.Checksums(time.Now())
+			So(err, ShouldBeNil)
+			So(checksums, ShouldResemble, []string{helloChecksum})
+			So(store.Remove(helloChecksum), ShouldBeNil)
+			So(store.Exists(helloChecksum), ShouldBeFalse)
+		})
+	})
+}
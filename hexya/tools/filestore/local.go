@@ -0,0 +1,112 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+// Package filestore provides implementations of the models.Filestore
+// interface to store the contents of attachment binary fields outside of
+// the database.
+package filestore
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// A Local filestore stores contents as files in a local directory.
+//
+// Each content is stored in a file named after its checksum, in a sub
+// directory named after the first two characters of the checksum so
+// that directories do not grow too large.
+type Local struct {
+	Dir string
+}
+
+// NewLocal returns a Local filestore in the given directory.
+// The directory is created if it does not exist.
+func NewLocal(dir string) (*Local, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &Local{Dir: dir}, nil
+}
+
+// path returns the path of the file of the given checksum
+func (l *Local) path(checksum string) string {
+	if len(checksum) < 2 {
+		return filepath.Join(l.Dir, checksum)
+	}
+	return filepath.Join(l.Dir, checksum[:2], checksum)
+}
+
+// Exists returns true if a content with the given checksum is stored
+func (l *Local) Exists(checksum string) bool {
+	_, err := os.Stat(l.path(checksum))
+	return err == nil
+}
+
+// Write stores the content read from r under the given checksum.
+//
+// The content is first written to a temporary file which is then renamed,
+// so that a partially written content is never visible.
+func (l *Local) Write(checksum string, r io.Reader) error {
+	fPath := l.path(checksum)
+	if err := os.MkdirAll(filepath.Dir(fPath), 0700); err != nil {
+		return err
+	}
+	tmpFile, err := ioutil.TempFile(filepath.Dir(fPath), "tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+	if _, err = io.Copy(tmpFile, r); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if err = tmpFile.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile.Name(), fPath)
+}
+
+// Open returns a reader on the content with the given checksum
+func (l *Local) Open(checksum string) (io.ReadCloser, error) {
+	return os.Open(l.path(checksum))
+}
+
+// Checksums returns the checksums of the contents that
+// have been stored before the given time.
+func (l *Local) Checksums(before time.Time) ([]string, error) {
+	var res []string
+	err := filepath.Walk(l.Dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || strings.HasPrefix(info.Name(), "tmp") || !info.ModTime().Before(before) {
+			return nil
+		}
+		res = append(res, info.Name())
+		return nil
+	})
+	return res, err
+}
+
+// Size returns the size in bytes of the content with the given checksum.
+func (l *Local) Size(checksum string) (int64, error) {
+	info, err := os.Stat(l.path(checksum))
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// Remove deletes the content with the given checksum.
+func (l *Local) Remove(checksum string) error {
+	err := os.Remove(l.path(checksum))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,246 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package filestore
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// An S3 filestore stores contents as objects in an S3 compatible bucket.
+//
+// Requests are signed with AWS Signature Version 4 and objects are
+// addressed in path style (Endpoint/Bucket/Prefix+checksum), so that
+// S3 compatible services such as Minio can be used as well.
+type S3 struct {
+	Endpoint  string
+	Region    string
+	Bucket    string
+	Prefix    string
+	AccessKey string
+	SecretKey string
+	Client    *http.Client
+}
+
+// Exists returns true if a content with the given checksum is stored
+func (s *S3) Exists(checksum string) bool {
+	resp, err := s.do("HEAD", checksum, nil, 0)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode == http.StatusOK
+}
+
+// Write stores the content read from r under the given checksum
+func (s *S3) Write(checksum string, r io.Reader) error {
+	size, err := contentLength(r)
+	if err != nil {
+		// Unknown length: we need to buffer the content
+		content, err := ioutil.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		r, size = bytes.NewReader(content), int64(len(content))
+	}
+	resp, err := s.do("PUT", checksum, r, size)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return s.responseError(resp)
+	}
+	return nil
+}
+
+// Open returns a reader on the content with the given checksum
+func (s *S3) Open(checksum string) (io.ReadCloser, error) {
+	resp, err := s.do("GET", checksum, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, s.responseError(resp)
+	}
+	return resp.Body, nil
+}
+
+// Checksums returns the checksums of the contents that
+// have been stored before the given time.
+func (s *S3) Checksums(before time.Time) ([]string, error) {
+	var res []string
+	var token string
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {s.Prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		resp, err := s.request("GET", "/"+s.Bucket, query, nil, 0)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			defer resp.Body.Close()
+			return nil, s.responseError(resp)
+		}
+		var result s3ListResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, object := range result.Contents {
+			if object.LastModified.Before(before) {
+				res = append(res, strings.TrimPrefix(object.Key, s.Prefix))
+			}
+		}
+		if !result.IsTruncated {
+			return res, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// Size returns the size in bytes of the content with the given checksum.
+func (s *S3) Size(checksum string) (int64, error) {
+	resp, err := s.do("HEAD", checksum, nil, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, s.responseError(resp)
+	}
+	return resp.ContentLength, nil
+}
+
+// Remove deletes the content with the given checksum.
+func (s *S3) Remove(checksum string) error {
+	resp, err := s.do("DELETE", checksum, nil, 0)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		return s.responseError(resp)
+	}
+	return nil
+}
+
+// s3ListResult is the result of a ListObjectsV2 request
+type s3ListResult struct {
+	Contents []struct {
+		Key          string
+		LastModified time.Time
+	}
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+// responseError returns an error describing the given failed response
+func (s *S3) responseError(resp *http.Response) error {
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("S3 request failed with status %s: %s", resp.Status, body)
+}
+
+// contentLength returns the length of the content of r if it can
+// be known without reading it.
+func contentLength(r io.Reader) (int64, error) {
+	switch rd := r.(type) {
+	case *bytes.Reader:
+		return int64(rd.Len()), nil
+	case *strings.Reader:
+		return int64(rd.Len()), nil
+	case *os.File:
+		fInfo, err := rd.Stat()
+		if err != nil {
+			return 0, err
+		}
+		pos, err := rd.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return 0, err
+		}
+		return fInfo.Size() - pos, nil
+	}
+	return 0, fmt.Errorf("unknown content length")
+}
+
+// do executes a signed request with the given method on
+// the object of the given checksum.
+func (s *S3) do(method, checksum string, body io.Reader, size int64) (*http.Response, error) {
+	return s.request(method, fmt.Sprintf("/%s/%s%s", s.Bucket, s.Prefix, checksum), nil, body, size)
+}
+
+// request executes a signed request with the given method
+// on the given path of the endpoint.
+func (s *S3) request(method, path string, query url.Values, body io.Reader, size int64) (*http.Response, error) {
+	u, err := url.Parse(s.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	u.Path = path
+	// Query parameters must be sorted and percent encoded to be signed
+	u.RawQuery = strings.Replace(query.Encode(), "+", "%20", -1)
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	s.sign(req, time.Now().UTC())
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return client.Do(req)
+}
+
+// sign adds the AWS Signature Version 4 headers to the given request.
+// The payload is not signed so that contents can be streamed.
+func (s *S3) sign(req *http.Request, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", "UNSIGNED-PAYLOAD")
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		fmt.Sprintf("host:%s\nx-amz-content-sha256:UNSIGNED-PAYLOAD\nx-amz-date:%s\n", req.URL.Host, amzDate),
+		signedHeaders,
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+	scope := fmt.Sprintf("%s/%s/s3/aws4_request", day, s.Region)
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, hex.EncodeToString(requestHash[:])}, "\n")
+	key := hmacSHA256([]byte("AWS4"+s.SecretKey), day)
+	key = hmacSHA256(key, s.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.AccessKey, scope, signedHeaders, signature))
+}
+
+// hmacSHA256 returns the HMAC-SHA256 of data with the given key
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -452,7 +452,7 @@ func addFieldTypesToModelData(mData *modelData) {
 				{Name: "Equals"}, {Name: "NotEquals"}, {Name: "Greater"}, {Name: "GreaterOrEqual"}, {Name: "Lower"},
 				{Name: "LowerOrEqual"}, {Name: "Like"}, {Name: "Contains"}, {Name: "NotContains"}, {Name: "IContains"},
 				{Name: "NotIContains"}, {Name: "ILike"}, {Name: "In", Multi: true}, {Name: "NotIn", Multi: true},
-				{Name: "ChildOf"},
+				{Name: "ChildOf"}, {Name: "Match"}, {Name: "Similar"},
 			},
 		})
 	}
@@ -548,7 +548,7 @@ func (m {{ .Name }}Model) NewSet(env models.Environment, modelName string) {{ .N
 // NewSet returns a new {{ .Name }}Set instance in the given Environment
 func (m {{ .Name }}Model) NewSet(env models.Environment) {{ .Name }}Set {
 	return {{ .Name }}Set{
-		RecordCollection: env.Pool("{{ .Name }}"),
+		RecordCollection: p{{ .Name }}Handle.Pool(env),
 	}
 }
 
@@ -604,12 +604,16 @@ func (m {{ .Name }}Model) Declare{{ .ModelType }}Model() {{ .Name }}Model {
 	return m
 }
 
+// p{{ .Name }}Handle is the handle of the {{ .Name }} model, so that
+// the model is not looked up by name in the registry at each call.
+var p{{ .Name }}Handle = models.NewModelHandle("{{ .Name }}")
+
 // {{ .Name }} returns the unique instance of the {{ .Name }}Model type
 // which is used to extend the {{ .Name }} model or to get a {{ .Name }}Set through
 // its NewSet() function.
 func {{ .Name }}() {{ .Name }}Model {
 	return {{ .Name }}Model{
-		Model: models.Registry.MustGet("{{ .Name }}"),
+		Model: p{{ .Name }}Handle.Model(),
 	}
 }
 
@@ -17,6 +17,8 @@ const (
 	ModelsPath = "github.com/hexya-erp/hexya/hexya/models"
 	// DatesPath is the go import path of the hexya/models/types/dates package
 	DatesPath = "github.com/hexya-erp/hexya/hexya/models/types/dates"
+	// TypesPath is the go import path of the hexya/models/types package
+	TypesPath = "github.com/hexya-erp/hexya/hexya/models/types"
 	// PoolPath is the go import path of the autogenerated pool package
 	PoolPath = "github.com/hexya-erp/hexya/pool"
 	// PoolModelPackage is the name of the pool package with model data
@@ -43,6 +43,13 @@ const (
 
 var currentFileSet *token.FileSet
 
+// fieldDefinitionTypes maps the names of the field definitions which do not
+// have a field type of the same name to the type of the fields they declare.
+var fieldDefinitionTypes = map[string]fieldtype.Type{
+	"Count": fieldtype.Integer,
+	"Image": fieldtype.Binary,
+}
+
 // A ModuleInfo is a wrapper around loader.Package with additional data to
 // describe a module.
 type ModuleInfo struct {
@@ -361,9 +368,16 @@ func parseAddFields(node *ast.CallExpr, modInfo *ModuleInfo, modelsData *map[str
 		case *ast.SelectorExpr:
 			typeStr = strings.TrimSuffix(ft.Sel.Name, "Field")
 		}
+		fType := fieldtype.Type(strings.ToLower(typeStr))
+		if ft, ok := fieldDefinitionTypes[typeStr]; ok {
+			fType = ft
+		}
 		var importPath string
-		if typeStr == "Date" || typeStr == "DateTime" {
+		switch typeStr {
+		case "Date", "DateTime":
 			importPath = DatesPath
+		case "EmbeddedList":
+			importPath = TypesPath
 		}
 
 		var fieldParams []ast.Expr
@@ -377,7 +391,7 @@ func parseAddFields(node *ast.CallExpr, modInfo *ModuleInfo, modelsData *map[str
 		fData := FieldASTData{
 			Name: fieldName,
 			Type: TypeData{
-				Type:       fieldtype.Type(strings.ToLower(typeStr)).DefaultGoType().String(),
+				Type:       fType.DefaultGoType().String(),
 				ImportPath: importPath,
 			},
 		}
@@ -531,7 +545,7 @@ func extractModel(ident ast.Expr) (string, error) {
 					fnIdent = ft.Sel
 				}
 				switch fnIdent.Name {
-				case "MustGet", "NewModel", "NewMixinModel", "NewTransientModel", "NewManualModel":
+				case "MustGet", "NewModel", "NewMixinModel", "NewTransientModel", "NewManualModel", "NewImmutableModel":
 					return strings.Trim(rd.Args[0].(*ast.BasicLit).Value, "\"`"), nil
 				case "createModel":
 					// This is a call from inside a NewXXXXModel function
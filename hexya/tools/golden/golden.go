@@ -0,0 +1,101 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+// Package golden compares the output of tests with golden files.
+//
+// A golden file holds the expected output of a test, such as the SQL schema
+// generated for a model or the query built for a search. Golden files are
+// committed with the tests, usually in a testdata directory, so that changes
+// of the output are reviewed explicitly in the diff of the golden files.
+//
+// Set the HEXYA_UPDATE_GOLDEN environment variable to write the actual output
+// to the golden files instead of comparing them:
+//
+//	HEXYA_UPDATE_GOLDEN=1 go test ./...
+package golden
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// UpdateEnvVar is the environment variable which, when set,
+// makes Compare write golden files instead of comparing them.
+const UpdateEnvVar = "HEXYA_UPDATE_GOLDEN"
+
+// Update returns true if golden files must be written
+// with the actual output instead of being compared.
+func Update() bool {
+	return os.Getenv(UpdateEnvVar) != ""
+}
+
+// Compare returns an error describing the first difference between actual and
+// the content of the golden file at path, or nil if they are equal.
+//
+// In update mode, the golden file and its directory are created or
+// overwritten with actual instead.
+func Compare(path string, actual string) error {
+	if Update() {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		return ioutil.WriteFile(path, []byte(actual), 0644)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("golden file %s does not exist, run the tests with %s=1 to create it", path, UpdateEnvVar)
+		}
+		return err
+	}
+	expected := string(data)
+	if actual == expected {
+		return nil
+	}
+	expLines := strings.Split(expected, "\n")
+	actLines := strings.Split(actual, "\n")
+	for i := 0; i < len(expLines) || i < len(actLines); i++ {
+		var exp, act string
+		if i < len(expLines) {
+			exp = expLines[i]
+		}
+		if i < len(actLines) {
+			act = actLines[i]
+		}
+		if exp != act || i >= len(expLines) || i >= len(actLines) {
+			return fmt.Errorf("output differs from golden file %s at line %d:\nexpected: %q\nactual:   %q", path, i+1, exp, act)
+		}
+	}
+	return nil
+}
+
+// ShouldMatchGolden is a goconvey assertion that checks that actual, which
+// must be a string or a []byte, matches the golden file whose path is given
+// as the only expected value:
+//
+//	So(model.SchemaSnapshot(), golden.ShouldMatchGolden, "testdata/model.golden")
+func ShouldMatchGolden(actual interface{}, expected ...interface{}) string {
+	if len(expected) != 1 {
+		return "This assertion requires exactly 1 comparison value: the golden file path"
+	}
+	path, ok := expected[0].(string)
+	if !ok {
+		return fmt.Sprintf("The golden file path must be a string (was %T)", expected[0])
+	}
+	var str string
+	switch act := actual.(type) {
+	case string:
+		str = act
+	case []byte:
+		str = string(act)
+	default:
+		return fmt.Sprintf("The actual value must be a string or a []byte (was %T)", actual)
+	}
+	if err := Compare(path, str); err != nil {
+		return err.Error()
+	}
+	return ""
+}
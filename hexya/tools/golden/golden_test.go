@@ -0,0 +1,59 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package golden
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestGolden(t *testing.T) {
+	Convey("Testing golden files", t, func() {
+		So(os.Unsetenv(UpdateEnvVar), ShouldBeNil)
+		Convey("Matching output should pass", func() {
+			So(Compare("testdata/sample.golden", "first line\nsecond line\n"), ShouldBeNil)
+			So("first line\nsecond line\n", ShouldMatchGolden, "testdata/sample.golden")
+			So([]byte("first line\nsecond line\n"), ShouldMatchGolden, "testdata/sample.golden")
+		})
+		Convey("Differing output should report the first different line", func() {
+			err := Compare("testdata/sample.golden", "first line\nother line\n")
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "at line 2")
+			So(err.Error(), ShouldContainSubstring, `"second line"`)
+			So(err.Error(), ShouldContainSubstring, `"other line"`)
+			So(ShouldMatchGolden("first line\n", "testdata/sample.golden"), ShouldContainSubstring, "at line 2")
+			So(ShouldMatchGolden("first line\nsecond line\n\n", "testdata/sample.golden"), ShouldContainSubstring, "at line 3")
+		})
+		Convey("Missing golden files should fail", func() {
+			err := Compare("testdata/missing.golden", "first line\n")
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, UpdateEnvVar)
+		})
+		Convey("Invalid assertion arguments should fail", func() {
+			So(ShouldMatchGolden("first line"), ShouldNotBeEmpty)
+			So(ShouldMatchGolden("first line", 12), ShouldNotBeEmpty)
+			So(ShouldMatchGolden(12, "testdata/sample.golden"), ShouldNotBeEmpty)
+		})
+		Convey("Update mode should write golden files", func() {
+			dir, err := ioutil.TempDir("", "hexya-golden")
+			So(err, ShouldBeNil)
+			defer os.RemoveAll(dir)
+			path := filepath.Join(dir, "sub", "new.golden")
+			So(os.Setenv(UpdateEnvVar, "1"), ShouldBeNil)
+			defer os.Unsetenv(UpdateEnvVar)
+			So(Update(), ShouldBeTrue)
+			So(Compare(path, "new content\n"), ShouldBeNil)
+			data, err := ioutil.ReadFile(path)
+			So(err, ShouldBeNil)
+			So(string(data), ShouldEqual, "new content\n")
+			So(os.Unsetenv(UpdateEnvVar), ShouldBeNil)
+			So("new content\n", ShouldMatchGolden, path)
+			So(ShouldMatchGolden("old content\n", path), ShouldContainSubstring, "at line 1")
+		})
+	})
+}
@@ -0,0 +1,160 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package sdk
+
+import "text/template"
+
+var goTemplate = template.Must(template.New("").Parse(`// This file is autogenerated by hexya sdk
+// DO NOT MODIFY THIS FILE - ANY CHANGES WILL BE OVERWRITTEN
+
+// Package {{ .Package }} is a client of the RPC API of a Hexya server
+package {{ .Package }}
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+)
+
+// An RPCError is an error returned by the server
+type RPCError struct {
+	Code    int             ` + "`json:\"code\"`" + `
+	Message string          ` + "`json:\"message\"`" + `
+	Data    json.RawMessage ` + "`json:\"data\"`" + `
+}
+
+// Error returns the message of the error
+func (e *RPCError) Error() string {
+	return e.Message
+}
+
+// A Client calls the RPC API of a Hexya server
+type Client struct {
+	// BaseURL is the URL of the server
+	BaseURL string
+	// Path is the path of the RPC endpoint on the server
+	Path string
+	// HTTPClient is the client used for requests. It should
+	// have a cookie jar to keep the session between calls.
+	HTTPClient *http.Client
+	lastID     int64
+}
+
+// NewClient returns a new Client for the server at the given base URL
+func NewClient(baseURL string, httpClient *http.Client) *Client {
+	return &Client{
+		BaseURL:    baseURL,
+		Path:       "{{ .RPCPath }}",
+		HTTPClient: httpClient,
+	}
+}
+
+// Call executes the given method of the given model on the records with the
+// given ids, and decodes its result into result if it is not nil.
+func (c *Client) Call(ctx context.Context, model, method string, ids []int64, args []interface{}, result interface{}) error {
+	if ids == nil {
+		ids = []int64{}
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      atomic.AddInt64(&c.lastID, 1),
+		"method":  "call",
+		"params": map[string]interface{}{
+			"model":  model,
+			"method": method,
+			"args":   append([]interface{}{ids}, args...),
+			"kwargs": map[string]interface{}{},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", fmt.Sprintf("%s%s/%s/%s", c.BaseURL, c.Path, model, method), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.HTTPClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var response struct {
+		Result json.RawMessage ` + "`json:\"result\"`" + `
+		Error  *RPCError       ` + "`json:\"error\"`" + `
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return err
+	}
+	if response.Error != nil {
+		return response.Error
+	}
+	if result == nil || len(response.Result) == 0 {
+		return nil
+	}
+	return json.Unmarshal(response.Result, result)
+}
+{{ range .Models }}{{ $model := . }}
+{{- range .Selections }}{{ $selection := . }}
+// {{ .Type }} is a value of the selection field of the same name
+type {{ .Type }} string
+
+// Values of {{ .Type }}
+const (
+{{- range .Values }}
+	{{ .Name }} {{ $selection.Type }} = "{{ .Key }}"
+{{- end }}
+)
+
+// {{ .Type }}Labels are the labels of the values of {{ .Type }}
+var {{ .Type }}Labels = map[{{ .Type }}]string{
+{{- range .Values }}
+	{{ .Name }}: {{ printf "%q" .Label }},
+{{- end }}
+}
+{{ end }}
+// {{ .Name }}Data holds the values of the fields of a {{ .Name }} record.
+// Relation fields are given as ids and nil fields are omitted.
+type {{ .Name }}Data struct {
+{{- range .Fields }}
+{{- if .Help }}
+	// {{ .Help }}
+{{- end }}
+	{{ .Name }} {{ .Type }} ` + "`json:\"{{ .JSON }},omitempty\"`" + `
+{{- end }}
+}
+
+// {{ .Name }}Model calls the methods of the {{ .Name }} model
+type {{ .Name }}Model struct {
+	client *Client
+}
+
+// {{ .Name }} returns the {{ .Name }}Model of this client
+func (c *Client) {{ .Name }}() {{ .Name }}Model {
+	return {{ .Name }}Model{client: c}
+}
+{{ range .Methods }}
+{{- range .Doc }}
+//{{ if . }} {{ . }}{{ end }}
+{{- end }}
+func (m {{ $model.Name }}Model) {{ .Name }}(ctx context.Context, ids []int64{{ range .Params }}, {{ .Name }} {{ .Type }}{{ end }}{{ with .Variadic }}, {{ .Name }} ...{{ .Type }}{{ end }}) {{ if .Result }}({{ .Result }}, error){{ else }}error{{ end }} {
+	args := []interface{}{ {{- range $i, $p := .Params }}{{ if $i }}, {{ end }}{{ $p.Name }}{{ end -}} }
+{{- with .Variadic }}
+	for _, arg := range {{ .Name }} {
+		args = append(args, arg)
+	}
+{{- end }}
+{{- if .Result }}
+	var res {{ .Result }}
+	err := m.client.Call(ctx, "{{ $model.Name }}", "{{ .Name }}", ids, args, &res)
+	return res, err
+{{- else }}
+	return m.client.Call(ctx, "{{ $model.Name }}", "{{ .Name }}", ids, args, nil)
+{{- end }}
+}
+{{ end }}
+{{- end }}`))
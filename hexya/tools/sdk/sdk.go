@@ -0,0 +1,400 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+// Package sdk generates typed client SDKs for the RPC API of the
+// application from the descriptions of the models of the registry.
+package sdk
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"text/template"
+	"unicode"
+
+	"github.com/hexya-erp/hexya/hexya/models"
+	"github.com/hexya-erp/hexya/hexya/models/fieldtype"
+	"github.com/hexya-erp/hexya/hexya/models/types/dates"
+)
+
+// A Language is a target language of a generated SDK
+type Language string
+
+// Supported SDK languages
+const (
+	TypeScript Language = "ts"
+	Go         Language = "go"
+)
+
+// DefaultRPCPath is the default path of the JSON-RPC endpoint called by SDKs.
+// The model and method names are appended to this path.
+const DefaultRPCPath = "/web/dataset/call_kw"
+
+// localMethods are the methods of the CommonMixin that only make
+// sense inside the server and are not included in SDKs.
+var localMethods = map[string]bool{
+	"Browse":           true,
+	"Cached":           true,
+	"CartesianProduct": true,
+	"Collate":          true,
+	"Equals":           true,
+	"Fetch":            true,
+	"Filtered":         true,
+	"Intersect":        true,
+	"Limit":            true,
+	"Load":             true,
+	"Offset":           true,
+	"OrderBy":          true,
+	"Sorted":           true,
+	"SortedByField":    true,
+	"SortedDefault":    true,
+	"Subtract":         true,
+	"Sudo":             true,
+	"Union":            true,
+	"WithContext":      true,
+	"WithEnv":          true,
+	"WithNewContext":   true,
+}
+
+var (
+	recordSetType   = reflect.TypeOf((*models.RecordSet)(nil)).Elem()
+	fieldMapperType = reflect.TypeOf((*models.FieldMapper)(nil)).Elem()
+	fieldNamerType  = reflect.TypeOf((*models.FieldNamer)(nil)).Elem()
+	dateType        = reflect.TypeOf(dates.Date{})
+	dateTimeType    = reflect.TypeOf(dates.DateTime{})
+)
+
+// An sdkModel is the data of a model given to the SDK templates
+type sdkModel struct {
+	Name       string
+	Fields     []sdkField
+	Selections []sdkSelection
+	Methods    []sdkMethod
+}
+
+// An sdkField is a field of the data type of a model
+type sdkField struct {
+	Name string
+	JSON string
+	Type string
+	Help string
+}
+
+// An sdkSelection is an enumeration type generated for a selection field
+type sdkSelection struct {
+	Type   string
+	Values []sdkSelectionValue
+}
+
+// An sdkSelectionValue is a value of an sdkSelection
+type sdkSelectionValue struct {
+	Name  string
+	Key   string
+	Label string
+}
+
+// An sdkMethod is a method of a model
+type sdkMethod struct {
+	Name     string
+	Doc      []string
+	Params   []sdkParam
+	Variadic *sdkParam
+	Result   string
+}
+
+// An sdkParam is a parameter of an sdkMethod
+type sdkParam struct {
+	Name string
+	Type string
+}
+
+// A typeMapper returns the type in the SDK language of the given Go type for
+// the given model, or false if the type cannot be used through the API.
+type typeMapper func(t reflect.Type, model string) (string, bool)
+
+// Generate writes to w the source code of a client SDK in the given language
+// for the models of the given descriptions. pkgName is the package name of
+// Go SDKs and is ignored for other languages.
+//
+// Methods whose parameters or result cannot be serialized are not included
+// in the SDK.
+func Generate(w io.Writer, lang Language, descs []models.ModelDescription, pkgName string) error {
+	var (
+		tmpl   *template.Template
+		mapper typeMapper
+	)
+	switch lang {
+	case TypeScript:
+		tmpl, mapper = typeScriptTemplate, typeScriptType
+	case Go:
+		tmpl, mapper = goTemplate, goType
+	default:
+		return fmt.Errorf("unknown SDK language: %s", lang)
+	}
+	sdkModels := make([]sdkModel, len(descs))
+	for i, desc := range descs {
+		sdkModels[i] = newSDKModel(desc, lang, mapper)
+	}
+	data := struct {
+		Package string
+		RPCPath string
+		Models  []sdkModel
+	}{
+		Package: pkgName,
+		RPCPath: DefaultRPCPath,
+		Models:  sdkModels,
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return err
+	}
+	src := buf.Bytes()
+	if lang == Go {
+		var err error
+		if src, err = format.Source(src); err != nil {
+			return err
+		}
+	}
+	_, err := w.Write(src)
+	return err
+}
+
+// newSDKModel returns the sdkModel of the given model description
+func newSDKModel(desc models.ModelDescription, lang Language, mapper typeMapper) sdkModel {
+	res := sdkModel{Name: desc.Name}
+	for _, field := range desc.Fields {
+		fType := fieldType(field, desc.Name, lang)
+		if field.Type == fieldtype.Selection {
+			res.Selections = append(res.Selections, newSDKSelection(desc.Name+field.Name, field))
+		}
+		res.Fields = append(res.Fields, sdkField{
+			Name: field.Name,
+			JSON: field.JSON,
+			Type: fType,
+			Help: strings.Join(strings.Fields(field.Help), " "),
+		})
+	}
+	for _, method := range desc.Methods {
+		if localMethods[method.Name] {
+			continue
+		}
+		if sdkMeth, ok := newSDKMethod(method, desc.Name, mapper); ok {
+			res.Methods = append(res.Methods, sdkMeth)
+		}
+	}
+	return res
+}
+
+// newSDKSelection returns the enumeration type of the given selection field
+func newSDKSelection(typeName string, field models.FieldDescription) sdkSelection {
+	res := sdkSelection{Type: typeName}
+	for key, label := range field.Selection {
+		res.Values = append(res.Values, sdkSelectionValue{
+			Name:  typeName + identifier(key),
+			Key:   key,
+			Label: label,
+		})
+	}
+	sort.Slice(res.Values, func(i, j int) bool {
+		return res.Values[i].Key < res.Values[j].Key
+	})
+	return res
+}
+
+// newSDKMethod returns the sdkMethod of the given method description.
+// The second returned value is false if the method cannot be called
+// through the API.
+func newSDKMethod(method models.MethodDescription, model string, mapper typeMapper) (sdkMethod, bool) {
+	res := sdkMethod{Name: method.Name, Doc: docLines(method.Doc)}
+	if len(res.Doc) == 0 {
+		res.Doc = []string{fmt.Sprintf("%s calls the %s method of the %s model", method.Name, method.Name, model)}
+	}
+	mType := method.Type
+	for i := 1; i < mType.NumIn(); i++ {
+		pType := mType.In(i)
+		variadic := mType.IsVariadic() && i == mType.NumIn()-1
+		if variadic {
+			pType = pType.Elem()
+		}
+		typ, ok := mapper(pType, model)
+		if !ok {
+			return res, false
+		}
+		param := sdkParam{Name: fmt.Sprintf("arg%d", i), Type: typ}
+		if variadic {
+			res.Variadic = &param
+			continue
+		}
+		res.Params = append(res.Params, param)
+	}
+	if mType.NumOut() > 0 {
+		typ, ok := mapper(mType.Out(0), model)
+		if !ok {
+			return res, false
+		}
+		res.Result = typ
+	}
+	return res, true
+}
+
+// fieldType returns the type in the given language of the given field
+// in the data type of the given model. Relation fields are given as ids.
+func fieldType(field models.FieldDescription, model string, lang Language) string {
+	var tsRes, goRes string
+	switch field.Type {
+	case fieldtype.Boolean:
+		tsRes, goRes = "boolean", "*bool"
+	case fieldtype.Integer:
+		tsRes, goRes = "number", "*int64"
+	case fieldtype.Float, fieldtype.Monetary:
+		tsRes, goRes = "number", "*float64"
+	case fieldtype.Selection:
+		tsRes, goRes = model+field.Name, "*"+model+field.Name
+	case fieldtype.Many2One, fieldtype.One2One, fieldtype.Rev2One:
+		tsRes, goRes = "number", "*int64"
+	case fieldtype.One2Many, fieldtype.Many2Many:
+		tsRes, goRes = "number[]", "[]int64"
+	default:
+		tsRes, goRes = "string", "*string"
+	}
+	if lang == Go {
+		return goRes
+	}
+	return tsRes
+}
+
+// typeScriptType returns the TypeScript type of the given Go type
+func typeScriptType(t reflect.Type, model string) (string, bool) {
+	switch {
+	case t.Implements(recordSetType):
+		return "number[]", true
+	case t.Implements(fieldMapperType):
+		return model + "Data", true
+	case t.Implements(fieldNamerType), t == dateType, t == dateTimeType:
+		return "string", true
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return "boolean", true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "number", true
+	case reflect.String:
+		return "string", true
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return "string", true
+		}
+		elem, ok := typeScriptType(t.Elem(), model)
+		return elem + "[]", ok
+	case reflect.Map:
+		if t.Key().Kind() != reflect.String {
+			return "", false
+		}
+		elem, ok := typeScriptType(t.Elem(), model)
+		return fmt.Sprintf("{ [key: string]: %s }", elem), ok
+	case reflect.Interface:
+		return "any", t.NumMethod() == 0
+	case reflect.Ptr:
+		return typeScriptType(t.Elem(), model)
+	case reflect.Struct:
+		return "any", true
+	}
+	return "", false
+}
+
+// goType returns the type in the Go SDK of the given Go type
+func goType(t reflect.Type, model string) (string, bool) {
+	switch {
+	case t.Implements(recordSetType):
+		return "[]int64", true
+	case t.Implements(fieldMapperType):
+		return "*" + model + "Data", true
+	case t.Implements(fieldNamerType), t == dateType, t == dateTimeType:
+		return "string", true
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return "bool", true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "int64", true
+	case reflect.Float32, reflect.Float64:
+		return "float64", true
+	case reflect.String:
+		return "string", true
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return "[]byte", true
+		}
+		elem, ok := goType(t.Elem(), model)
+		return "[]" + elem, ok
+	case reflect.Map:
+		if t.Key().Kind() != reflect.String {
+			return "", false
+		}
+		elem, ok := goType(t.Elem(), model)
+		return "map[string]" + elem, ok
+	case reflect.Interface:
+		return "interface{}", t.NumMethod() == 0
+	case reflect.Ptr:
+		return goType(t.Elem(), model)
+	case reflect.Struct:
+		return "json.RawMessage", true
+	}
+	return "", false
+}
+
+// docLines returns the lines of the given method documentation
+// without indentation and surrounding blank lines.
+func docLines(doc string) []string {
+	var res []string
+	for _, line := range strings.Split(strings.TrimSpace(doc), "\n") {
+		res = append(res, strings.TrimSpace(line))
+	}
+	if len(res) == 1 && res[0] == "" {
+		return nil
+	}
+	return res
+}
+
+// identifier returns the given selection key as a
+// title cased identifier, e.g. "in_progress" gives "InProgress".
+func identifier(key string) string {
+	var res []rune
+	upper := true
+	for _, r := range key {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		res = append(res, r)
+	}
+	return string(res)
+}
+
+// lowerFirst returns the given method name with its leading upper
+// case letters in lower case, e.g. "NameGet" gives "nameGet" and
+// "SQLQuery" gives "sqlQuery".
+func lowerFirst(name string) string {
+	runes := []rune(name)
+	for i := range runes {
+		if !unicode.IsUpper(runes[i]) {
+			break
+		}
+		if i > 0 && i+1 < len(runes) && unicode.IsLower(runes[i+1]) {
+			break
+		}
+		runes[i] = unicode.ToLower(runes[i])
+	}
+	return string(runes)
+}
@@ -0,0 +1,79 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package sdk
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/hexya-erp/hexya/hexya/models"
+	"github.com/hexya-erp/hexya/hexya/models/fieldtype"
+	"github.com/hexya-erp/hexya/hexya/models/types"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+var testDescriptions = []models.ModelDescription{{
+	Name: "Partner",
+	Fields: []models.FieldDescription{
+		{Name: "ID", JSON: "id", FieldInfo: &models.FieldInfo{Type: fieldtype.Integer}},
+		{Name: "Name", JSON: "name", FieldInfo: &models.FieldInfo{Type: fieldtype.Char, Help: "Name of\n\tthe partner"}},
+		{Name: "State", JSON: "state", FieldInfo: &models.FieldInfo{Type: fieldtype.Selection,
+			Selection: types.Selection{"draft": "Draft", "in_progress": "In Progress"}}},
+		{Name: "Children", JSON: "children_ids", FieldInfo: &models.FieldInfo{Type: fieldtype.One2Many}},
+	},
+	Methods: []models.MethodDescription{
+		{Name: "Create", Doc: "Create inserts a record.\n\t\tReturns the created RecordCollection.",
+			Type: reflect.TypeOf(func(*models.RecordCollection, models.FieldMapper) *models.RecordCollection { return nil })},
+		{Name: "Write", Type: reflect.TypeOf(func(*models.RecordCollection, models.FieldMapper, ...models.FieldNamer) bool { return false })},
+		{Name: "Search", Type: reflect.TypeOf(func(*models.RecordCollection, models.Conditioner) *models.RecordCollection { return nil })},
+		{Name: "Sudo", Type: reflect.TypeOf(func(*models.RecordCollection, ...int64) *models.RecordCollection { return nil })},
+	},
+}}
+
+func TestGoSDK(t *testing.T) {
+	Convey("Testing Go SDK generation", t, func() {
+		var buf bytes.Buffer
+		So(Generate(&buf, Go, testDescriptions, "client"), ShouldBeNil)
+		src := buf.String()
+		So(src, ShouldContainSubstring, "package client")
+		So(src, ShouldContainSubstring, `PartnerStateInProgress PartnerState = "in_progress"`)
+		So(src, ShouldContainSubstring, "// Name of the partner")
+		So(src, ShouldContainSubstring, "State    *PartnerState `json:\"state,omitempty\"`")
+		So(src, ShouldContainSubstring, "Children []int64       `json:\"children_ids,omitempty\"`")
+		So(src, ShouldContainSubstring, "// Create inserts a record.\n// Returns the created RecordCollection.\n")
+		So(src, ShouldContainSubstring, "func (m PartnerModel) Create(ctx context.Context, ids []int64, arg1 *PartnerData) ([]int64, error) {")
+		So(src, ShouldContainSubstring, "func (m PartnerModel) Write(ctx context.Context, ids []int64, arg1 *PartnerData, arg2 ...string) (bool, error) {")
+		Convey("Methods that cannot be called through the API should be skipped", func() {
+			So(src, ShouldNotContainSubstring, "Search(")
+			So(src, ShouldNotContainSubstring, "Sudo(")
+		})
+	})
+}
+
+func TestTypeScriptSDK(t *testing.T) {
+	Convey("Testing TypeScript SDK generation", t, func() {
+		var buf bytes.Buffer
+		So(Generate(&buf, TypeScript, testDescriptions, ""), ShouldBeNil)
+		src := buf.String()
+		So(src, ShouldContainSubstring, `export type PartnerState = "draft" | "in_progress";`)
+		So(src, ShouldContainSubstring, "    state?: PartnerState;\n    children_ids?: number[];\n")
+		So(src, ShouldContainSubstring, "create(ids: number[], arg1: PartnerData): Promise<number[]> {")
+		So(src, ShouldContainSubstring, `return this.client.call("Partner", "Write", ids, [arg1, ...arg2]);`)
+		So(src, ShouldNotContainSubstring, "search(")
+	})
+	Convey("Unknown languages should return an error", t, func() {
+		So(Generate(new(bytes.Buffer), Language("cobol"), testDescriptions, ""), ShouldNotBeNil)
+	})
+}
+
+func TestNames(t *testing.T) {
+	Convey("Testing name conversions", t, func() {
+		So(lowerFirst("NameGet"), ShouldEqual, "nameGet")
+		So(lowerFirst("SQLQuery"), ShouldEqual, "sqlQuery")
+		So(lowerFirst("ID"), ShouldEqual, "id")
+		So(identifier("in_progress"), ShouldEqual, "InProgress")
+		So(identifier("2-way"), ShouldEqual, "2Way")
+	})
+}
@@ -0,0 +1,82 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package sdk
+
+import "text/template"
+
+var typeScriptTemplate = template.Must(template.New("").Funcs(template.FuncMap{
+	"lowerFirst": lowerFirst,
+}).Parse(`// This file is autogenerated by hexya sdk
+// DO NOT MODIFY THIS FILE - ANY CHANGES WILL BE OVERWRITTEN
+
+// An RPCError is an error returned by the server
+export class RPCError extends Error {
+    constructor(public code: number, message: string, public data: any) {
+        super(message);
+    }
+}
+
+// A Client calls the RPC API of a Hexya server
+export class Client {
+    private lastID = 0;
+
+    constructor(public baseURL: string, public path: string = "{{ .RPCPath }}") {}
+
+    // call executes the given method of the given model on the records with the given ids
+    async call(model: string, method: string, ids: number[], args: any[]): Promise<any> {
+        this.lastID++;
+        const response = await fetch(` + "`${this.baseURL}${this.path}/${model}/${method}`" + `, {
+            method: "POST",
+            credentials: "include",
+            headers: {"Content-Type": "application/json"},
+            body: JSON.stringify({
+                jsonrpc: "2.0",
+                id: this.lastID,
+                method: "call",
+                params: {model: model, method: method, args: [ids, ...args], kwargs: {}},
+            }),
+        });
+        const data = await response.json();
+        if (data.error) {
+            throw new RPCError(data.error.code, data.error.message, data.error.data);
+        }
+        return data.result;
+    }
+}
+{{ range .Models }}{{ $model := . }}
+{{- range .Selections }}
+export type {{ .Type }} = {{ range $i, $v := .Values }}{{ if $i }} | {{ end }}"{{ $v.Key }}"{{ else }}string{{ end }};
+
+export const {{ .Type }}Labels: { [key: string]: string } = {
+{{- range .Values }}
+    "{{ .Key }}": {{ printf "%q" .Label }},
+{{- end }}
+};
+{{ end }}
+// {{ .Name }}Data holds the values of the fields of a {{ .Name }} record.
+// Relation fields are given as ids.
+export interface {{ .Name }}Data {
+{{- range .Fields }}
+{{- if .Help }}
+    // {{ .Help }}
+{{- end }}
+    {{ .JSON }}?: {{ .Type }};
+{{- end }}
+}
+
+// {{ .Name }}Model calls the methods of the {{ .Name }} model
+export class {{ .Name }}Model {
+    constructor(private client: Client) {}
+{{ range .Methods }}
+{{- if .Doc }}
+    /**{{ range .Doc }}
+     *{{ if . }} {{ . }}{{ end }}{{ end }}
+     */
+{{- end }}
+    {{ lowerFirst .Name }}(ids: number[]{{ range .Params }}, {{ .Name }}: {{ .Type }}{{ end }}{{ with .Variadic }}, ...{{ .Name }}: {{ .Type }}[]{{ end }}): Promise<{{ if .Result }}{{ .Result }}{{ else }}void{{ end }}> {
+        return this.client.call("{{ $model.Name }}", "{{ .Name }}", ids, [{{ range $i, $p := .Params }}{{ if $i }}, {{ end }}{{ $p.Name }}{{ end }}{{ if and .Params .Variadic }}, {{ end }}{{ with .Variadic }}...{{ .Name }}{{ end }}]);
+    }
+{{ end -}}
+}
+{{ end }}`))
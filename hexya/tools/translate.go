@@ -0,0 +1,16 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package tools
+
+import "github.com/hexya-erp/hexya/hexya/i18n"
+
+// Translate returns the translation of the given src string in the given
+// lang from the PO files loaded for the application's modules. If no
+// translation is found, src is returned.
+//
+// Use this function to translate strings outside of a RecordSet. Inside
+// model methods, use rs.T() which takes the language from the context.
+func Translate(lang, src string) string {
+	return i18n.TranslateCode(lang, "", src)
+}
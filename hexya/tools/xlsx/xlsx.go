@@ -0,0 +1,328 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+// Package xlsx provides a minimal streaming writer for Office Open XML
+// spreadsheets (.xlsx files).
+//
+// Rows are written directly to the underlying writer so that large
+// datasets can be exported without being held in memory.
+package xlsx
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cell styles indexes as defined in stylesXML. The styles
+// of custom number formats are numbered from styleCustom.
+const (
+	styleDefault = iota
+	styleDate
+	styleDateTime
+	styleCustom
+)
+
+// firstCustomNumFmtID is the id of the first custom number format.
+// Lower ids are reserved for the built-in formats of Excel.
+const firstCustomNumFmtID = 164
+
+// A Cell is a value that is written with the given Excel number format,
+// such as `#,##0.00` or the formats returned by NumberFormat and
+// CurrencyFormat. The Value is written as by WriteRow.
+type Cell struct {
+	Value  interface{}
+	Format string
+}
+
+// excelEpoch is the origin of Excel dates serial numbers
+var excelEpoch = time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+
+// A Writer writes an xlsx spreadsheet to an io.Writer
+type Writer struct {
+	zw      *zip.Writer
+	sheet   io.Writer
+	sheets  []string
+	row     int
+	closed  bool
+	formats []string
+	styles  map[string]int
+}
+
+// NewWriter returns a new Writer that writes to w.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{
+		zw:     zip.NewWriter(w),
+		styles: make(map[string]int),
+	}
+}
+
+// AddSheet starts a new sheet with the given name. All subsequent
+// rows are written to this sheet.
+func (w *Writer) AddSheet(name string) error {
+	if w.closed {
+		return errors.New("xlsx: writer is closed")
+	}
+	if err := w.closeSheet(); err != nil {
+		return err
+	}
+	w.sheets = append(w.sheets, name)
+	sheet, err := w.zw.Create(fmt.Sprintf("xl/worksheets/sheet%d.xml", len(w.sheets)))
+	if err != nil {
+		return err
+	}
+	w.sheet = sheet
+	w.row = 0
+	_, err = io.WriteString(w.sheet, xml.Header+
+		`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+	return err
+}
+
+// WriteRow writes a row with the given values to the current sheet.
+// A sheet named "Sheet1" is created if none has been added yet.
+//
+// Integers and floats are written as numbers, booleans as booleans,
+// time.Time values as dates and nil values as empty cells. Cell values
+// are written with their number format. All other values are written
+// as strings.
+func (w *Writer) WriteRow(values []interface{}) error {
+	if w.sheet == nil {
+		if err := w.AddSheet("Sheet1"); err != nil {
+			return err
+		}
+	}
+	w.row++
+	if _, err := fmt.Fprintf(w.sheet, `<row r="%d">`, w.row); err != nil {
+		return err
+	}
+	for i, value := range values {
+		if err := w.writeCell(fmt.Sprintf("%s%d", ColumnName(i), w.row), value, styleDefault); err != nil {
+			return err
+		}
+	}
+	_, err := io.WriteString(w.sheet, `</row>`)
+	return err
+}
+
+// writeCell writes the cell with the given reference, value and style.
+// Dates and times are written with the default date style if style is
+// the default style.
+func (w *Writer) writeCell(ref string, value interface{}, style int) error {
+	styleAttr := func(s int) string {
+		if s == styleDefault {
+			return ""
+		}
+		return fmt.Sprintf(` s="%d"`, s)
+	}
+	var err error
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case Cell:
+		return w.writeCell(ref, v.Value, w.styleForFormat(v.Format))
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		_, err = fmt.Fprintf(w.sheet, `<c r="%s"%s><v>%d</v></c>`, ref, styleAttr(style), v)
+	case float32:
+		_, err = fmt.Fprintf(w.sheet, `<c r="%s"%s><v>%s</v></c>`, ref, styleAttr(style), strconv.FormatFloat(float64(v), 'f', -1, 32))
+	case float64:
+		_, err = fmt.Fprintf(w.sheet, `<c r="%s"%s><v>%s</v></c>`, ref, styleAttr(style), strconv.FormatFloat(v, 'f', -1, 64))
+	case bool:
+		b := 0
+		if v {
+			b = 1
+		}
+		_, err = fmt.Fprintf(w.sheet, `<c r="%s" t="b"><v>%d</v></c>`, ref, b)
+	case time.Time:
+		if v.IsZero() {
+			return nil
+		}
+		if style == styleDefault {
+			style = styleDateTime
+			if v.Hour() == 0 && v.Minute() == 0 && v.Second() == 0 {
+				style = styleDate
+			}
+		}
+		_, err = fmt.Fprintf(w.sheet, `<c r="%s"%s><v>%s</v></c>`, ref, styleAttr(style),
+			strconv.FormatFloat(DateSerial(v), 'f', -1, 64))
+	default:
+		if _, err = fmt.Fprintf(w.sheet, `<c r="%s" t="inlineStr"><is><t xml:space="preserve">`, ref); err != nil {
+			return err
+		}
+		if err = xml.EscapeText(w.sheet, []byte(fmt.Sprintf("%v", v))); err != nil {
+			return err
+		}
+		_, err = io.WriteString(w.sheet, `</t></is></c>`)
+	}
+	return err
+}
+
+// styleForFormat returns the index of the style of the given number
+// format, creating it if needed. It returns the default style for
+// empty formats.
+func (w *Writer) styleForFormat(format string) int {
+	if format == "" {
+		return styleDefault
+	}
+	style, ok := w.styles[format]
+	if !ok {
+		style = styleCustom + len(w.formats)
+		w.formats = append(w.formats, format)
+		w.styles[format] = style
+	}
+	return style
+}
+
+// closeSheet terminates the current sheet if any
+func (w *Writer) closeSheet() error {
+	if w.sheet == nil {
+		return nil
+	}
+	_, err := io.WriteString(w.sheet, `</sheetData></worksheet>`)
+	w.sheet = nil
+	return err
+}
+
+// Close writes the workbook metadata and closes the spreadsheet.
+// It does not close the underlying writer.
+func (w *Writer) Close() error {
+	if w.closed {
+		return nil
+	}
+	if len(w.sheets) == 0 {
+		if err := w.AddSheet("Sheet1"); err != nil {
+			return err
+		}
+	}
+	if err := w.closeSheet(); err != nil {
+		return err
+	}
+	w.closed = true
+	files := [][2]string{
+		{"[Content_Types].xml", w.contentTypesXML()},
+		{"_rels/.rels", rootRelsXML},
+		{"xl/workbook.xml", w.workbookXML()},
+		{"xl/_rels/workbook.xml.rels", w.workbookRelsXML()},
+		{"xl/styles.xml", w.stylesXML()},
+	}
+	for _, file := range files {
+		f, err := w.zw.Create(file[0])
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(f, file[1]); err != nil {
+			return err
+		}
+	}
+	return w.zw.Close()
+}
+
+// contentTypesXML returns the content of the [Content_Types].xml file
+func (w *Writer) contentTypesXML() string {
+	res := xml.Header + `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+		`<Default Extension="xml" ContentType="application/xml"/>` +
+		`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+		`<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>`
+	for i := range w.sheets {
+		res += fmt.Sprintf(`<Override PartName="/xl/worksheets/sheet%d.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>`, i+1)
+	}
+	return res + `</Types>`
+}
+
+// workbookXML returns the content of the xl/workbook.xml file
+func (w *Writer) workbookXML() string {
+	res := xml.Header + `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" ` +
+		`xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets>`
+	for i, name := range w.sheets {
+		res += fmt.Sprintf(`<sheet name="%s" sheetId="%d" r:id="rId%d"/>`, escapeAttr(name), i+1, i+1)
+	}
+	return res + `</sheets></workbook>`
+}
+
+// workbookRelsXML returns the content of the xl/_rels/workbook.xml.rels file
+func (w *Writer) workbookRelsXML() string {
+	res := xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">`
+	for i := range w.sheets {
+		res += fmt.Sprintf(`<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet%d.xml"/>`, i+1, i+1)
+	}
+	res += fmt.Sprintf(`<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>`, len(w.sheets)+1)
+	return res + `</Relationships>`
+}
+
+// ColumnName returns the letters of the column with the given zero based index,
+// e.g. "A" for 0 and "AA" for 26.
+func ColumnName(index int) string {
+	var res string
+	for index >= 0 {
+		res = string(rune('A'+index%26)) + res
+		index = index/26 - 1
+	}
+	return res
+}
+
+// DateSerial returns the Excel serial number of the given time
+func DateSerial(t time.Time) float64 {
+	t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+	return t.Sub(excelEpoch).Hours() / 24
+}
+
+// NumberFormat returns the number format of numbers with
+// thousands separators and the given number of decimals.
+func NumberFormat(decimals int) string {
+	if decimals <= 0 {
+		return "#,##0"
+	}
+	return "#,##0." + strings.Repeat("0", decimals)
+}
+
+// CurrencyFormat returns the number format of amounts with the given
+// currency symbol and number of decimals. The symbol is put before the
+// amount if before is true, and after it otherwise.
+func CurrencyFormat(symbol string, decimals int, before bool) string {
+	quoted := `"` + strings.Replace(symbol, `"`, "", -1) + `"`
+	if before {
+		return quoted + NumberFormat(decimals)
+	}
+	return NumberFormat(decimals) + " " + quoted
+}
+
+// escapeAttr returns the given string escaped for use in an XML attribute
+func escapeAttr(s string) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
+
+const rootRelsXML = xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+	`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+	`</Relationships>`
+
+// stylesXML returns the content of the xl/styles.xml file
+func (w *Writer) stylesXML() string {
+	res := xml.Header + `<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">`
+	if len(w.formats) > 0 {
+		res += fmt.Sprintf(`<numFmts count="%d">`, len(w.formats))
+		for i, format := range w.formats {
+			res += fmt.Sprintf(`<numFmt numFmtId="%d" formatCode="%s"/>`, firstCustomNumFmtID+i, escapeAttr(format))
+		}
+		res += `</numFmts>`
+	}
+	res += `<fonts count="1"><font><sz val="11"/><name val="Calibri"/></font></fonts>` +
+		`<fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills>` +
+		`<borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders>` +
+		`<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>` +
+		fmt.Sprintf(`<cellXfs count="%d">`, styleCustom+len(w.formats)) +
+		`<xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/>` +
+		`<xf numFmtId="14" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>` +
+		`<xf numFmtId="22" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>`
+	for i := range w.formats {
+		res += fmt.Sprintf(`<xf numFmtId="%d" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>`, firstCustomNumFmtID+i)
+	}
+	return res + `</cellXfs></styleSheet>`
+}
@@ -0,0 +1,108 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package xlsx
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// readZipFile returns the content of the given file in the given xlsx data
+func readZipFile(data []byte, name string) string {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		panic(err)
+	}
+	for _, f := range zr.File {
+		if f.Name != name {
+			continue
+		}
+		rc, _ := f.Open()
+		content, _ := ioutil.ReadAll(rc)
+		rc.Close()
+		return string(content)
+	}
+	return ""
+}
+
+func TestColumnName(t *testing.T) {
+	Convey("Testing column names", t, func() {
+		So(ColumnName(0), ShouldEqual, "A")
+		So(ColumnName(25), ShouldEqual, "Z")
+		So(ColumnName(26), ShouldEqual, "AA")
+		So(ColumnName(27), ShouldEqual, "AB")
+		So(ColumnName(702), ShouldEqual, "AAA")
+	})
+}
+
+func TestDateSerial(t *testing.T) {
+	Convey("Testing Excel date serials", t, func() {
+		So(DateSerial(time.Date(1900, 1, 1, 0, 0, 0, 0, time.UTC)), ShouldEqual, 2)
+		So(DateSerial(time.Date(2017, 6, 1, 12, 0, 0, 0, time.UTC)), ShouldEqual, 42887.5)
+	})
+}
+
+func TestFormats(t *testing.T) {
+	Convey("Testing number formats", t, func() {
+		So(NumberFormat(0), ShouldEqual, "#,##0")
+		So(NumberFormat(3), ShouldEqual, "#,##0.000")
+		So(CurrencyFormat("$", 2, true), ShouldEqual, `"$"#,##0.00`)
+		So(CurrencyFormat("€", 2, false), ShouldEqual, `#,##0.00 "€"`)
+	})
+}
+
+func TestWriter(t *testing.T) {
+	Convey("Testing xlsx Writer", t, func() {
+		var buf bytes.Buffer
+		w := NewWriter(&buf)
+		So(w.WriteRow([]interface{}{"Name", "Age", "Staff", "Birthday"}), ShouldBeNil)
+		So(w.WriteRow([]interface{}{"Jane & John", 32, true, time.Date(1985, 3, 2, 0, 0, 0, 0, time.UTC)}), ShouldBeNil)
+		So(w.WriteRow([]interface{}{"Will", 1.5, false, nil}), ShouldBeNil)
+		So(w.WriteRow([]interface{}{
+			Cell{Value: 1234.5, Format: CurrencyFormat("€", 2, false)},
+			Cell{Value: 12, Format: NumberFormat(0)},
+			Cell{Value: 99.9, Format: CurrencyFormat("€", 2, false)},
+			Cell{Value: time.Date(2017, 6, 1, 0, 0, 0, 0, time.UTC), Format: "dd/mm/yyyy"},
+		}), ShouldBeNil)
+		So(w.AddSheet("Other <sheet>"), ShouldBeNil)
+		So(w.WriteRow([]interface{}{"Hello"}), ShouldBeNil)
+		So(w.Close(), ShouldBeNil)
+		data := buf.Bytes()
+		Convey("Workbook should list both sheets", func() {
+			wb := readZipFile(data, "xl/workbook.xml")
+			So(wb, ShouldContainSubstring, `<sheet name="Sheet1" sheetId="1" r:id="rId1"/>`)
+			So(wb, ShouldContainSubstring, `<sheet name="Other &lt;sheet&gt;" sheetId="2" r:id="rId2"/>`)
+		})
+		Convey("Cells should be typed", func() {
+			sheet := readZipFile(data, "xl/worksheets/sheet1.xml")
+			So(sheet, ShouldContainSubstring, `<c r="A2" t="inlineStr"><is><t xml:space="preserve">Jane &amp; John</t></is></c>`)
+			So(sheet, ShouldContainSubstring, `<c r="B2"><v>32</v></c>`)
+			So(sheet, ShouldContainSubstring, `<c r="C2" t="b"><v>1</v></c>`)
+			So(sheet, ShouldContainSubstring, `<c r="D2" s="1"><v>31108</v></c>`)
+			So(sheet, ShouldContainSubstring, `<c r="B3"><v>1.5</v></c>`)
+			So(sheet, ShouldNotContainSubstring, `D3`)
+			So(readZipFile(data, "xl/worksheets/sheet2.xml"), ShouldContainSubstring, "Hello")
+		})
+		Convey("Cells with number formats should have a custom style", func() {
+			sheet := readZipFile(data, "xl/worksheets/sheet1.xml")
+			So(sheet, ShouldContainSubstring, `<c r="A4" s="3"><v>1234.5</v></c>`)
+			So(sheet, ShouldContainSubstring, `<c r="B4" s="4"><v>12</v></c>`)
+			So(sheet, ShouldContainSubstring, `<c r="C4" s="3"><v>99.9</v></c>`)
+			So(sheet, ShouldContainSubstring, `<c r="D4" s="5"><v>42887</v></c>`)
+			styles := readZipFile(data, "xl/styles.xml")
+			So(styles, ShouldContainSubstring, `<numFmts count="3">`)
+			So(styles, ShouldContainSubstring, `<numFmt numFmtId="164" formatCode="#,##0.00 &#34;€&#34;"/>`)
+			So(styles, ShouldContainSubstring, `<numFmt numFmtId="165" formatCode="#,##0"/>`)
+			So(styles, ShouldContainSubstring, `<cellXfs count="6">`)
+		})
+		Convey("Writing after Close should fail", func() {
+			So(w.AddSheet("Late"), ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,264 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+// Package xmlrpc provides a minimal codec for the XML-RPC protocol, as
+// used by the external API of Odoo.
+//
+// Values are decoded as follows:
+//   - int, i4 and i8 as int64,
+//   - double as float64,
+//   - boolean as bool,
+//   - string and untyped values as string,
+//   - dateTime.iso8601 as time.Time,
+//   - base64 as []byte,
+//   - struct as map[string]interface{},
+//   - array as []interface{},
+//   - nil as nil.
+package xmlrpc
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DateTimeFormat is the format of dateTime.iso8601 values
+const DateTimeFormat = "20060102T15:04:05"
+
+// A Fault is an XML-RPC error response
+type Fault struct {
+	Code   int
+	String string
+}
+
+// Error returns the fault string of this Fault
+func (f Fault) Error() string {
+	return fmt.Sprintf("XML-RPC fault %d: %s", f.Code, f.String)
+}
+
+// xmlValue is the XML representation of an XML-RPC value
+type xmlValue struct {
+	Text     string     `xml:",chardata"`
+	Int      *string    `xml:"int"`
+	I4       *string    `xml:"i4"`
+	I8       *string    `xml:"i8"`
+	Double   *string    `xml:"double"`
+	Boolean  *string    `xml:"boolean"`
+	String   *string    `xml:"string"`
+	DateTime *string    `xml:"dateTime.iso8601"`
+	Base64   *string    `xml:"base64"`
+	Nil      *struct{}  `xml:"nil"`
+	Struct   *xmlStruct `xml:"struct"`
+	Array    *xmlArray  `xml:"array"`
+}
+
+// xmlStruct is the XML representation of an XML-RPC struct
+type xmlStruct struct {
+	Members []xmlMember `xml:"member"`
+}
+
+// xmlMember is the XML representation of a member of an XML-RPC struct
+type xmlMember struct {
+	Name  string   `xml:"name"`
+	Value xmlValue `xml:"value"`
+}
+
+// xmlArray is the XML representation of an XML-RPC array
+type xmlArray struct {
+	Values []xmlValue `xml:"data>value"`
+}
+
+// xmlMethodCall is the XML representation of an XML-RPC request
+type xmlMethodCall struct {
+	XMLName    xml.Name   `xml:"methodCall"`
+	MethodName string     `xml:"methodName"`
+	Params     []xmlValue `xml:"params>param>value"`
+}
+
+// decode returns the Go value of this xmlValue
+func (v xmlValue) decode() (interface{}, error) {
+	switch {
+	case v.Int != nil:
+		return strconv.ParseInt(strings.TrimSpace(*v.Int), 10, 64)
+	case v.I4 != nil:
+		return strconv.ParseInt(strings.TrimSpace(*v.I4), 10, 64)
+	case v.I8 != nil:
+		return strconv.ParseInt(strings.TrimSpace(*v.I8), 10, 64)
+	case v.Double != nil:
+		return strconv.ParseFloat(strings.TrimSpace(*v.Double), 64)
+	case v.Boolean != nil:
+		switch strings.TrimSpace(*v.Boolean) {
+		case "1":
+			return true, nil
+		case "0":
+			return false, nil
+		}
+		return nil, fmt.Errorf("xmlrpc: invalid boolean %q", *v.Boolean)
+	case v.String != nil:
+		return *v.String, nil
+	case v.DateTime != nil:
+		return time.Parse(DateTimeFormat, strings.TrimSpace(*v.DateTime))
+	case v.Base64 != nil:
+		return base64.StdEncoding.DecodeString(strings.TrimSpace(*v.Base64))
+	case v.Nil != nil:
+		return nil, nil
+	case v.Struct != nil:
+		res := make(map[string]interface{}, len(v.Struct.Members))
+		for _, member := range v.Struct.Members {
+			val, err := member.Value.decode()
+			if err != nil {
+				return nil, err
+			}
+			res[member.Name] = val
+		}
+		return res, nil
+	case v.Array != nil:
+		res := make([]interface{}, len(v.Array.Values))
+		for i, value := range v.Array.Values {
+			val, err := value.decode()
+			if err != nil {
+				return nil, err
+			}
+			res[i] = val
+		}
+		return res, nil
+	}
+	return v.Text, nil
+}
+
+// DecodeCall reads an XML-RPC request from r and returns
+// the name of the called method and its parameters.
+func DecodeCall(r io.Reader) (string, []interface{}, error) {
+	var call xmlMethodCall
+	if err := xml.NewDecoder(r).Decode(&call); err != nil {
+		return "", nil, err
+	}
+	params := make([]interface{}, len(call.Params))
+	for i, param := range call.Params {
+		val, err := param.decode()
+		if err != nil {
+			return "", nil, err
+		}
+		params[i] = val
+	}
+	return call.MethodName, params, nil
+}
+
+// EncodeResponse writes to w the XML-RPC response returning the given value.
+//
+// Besides the types returned by DecodeCall, values can be of any integer,
+// float, string, slice or map kind, json.Number, or pointers to them.
+// nil values are encoded as false, as Odoo does.
+func EncodeResponse(w io.Writer, value interface{}) error {
+	var buf bytes.Buffer
+	buf.WriteString(`<?xml version="1.0"?><methodResponse><params><param>`)
+	if err := encodeValue(&buf, reflect.ValueOf(value)); err != nil {
+		return err
+	}
+	buf.WriteString(`</param></params></methodResponse>`)
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+// EncodeFault writes to w the XML-RPC response with the given Fault
+func EncodeFault(w io.Writer, fault Fault) error {
+	var buf bytes.Buffer
+	buf.WriteString(`<?xml version="1.0"?><methodResponse><fault>`)
+	encodeValue(&buf, reflect.ValueOf(map[string]interface{}{
+		"faultCode":   fault.Code,
+		"faultString": fault.String,
+	}))
+	buf.WriteString(`</fault></methodResponse>`)
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+// encodeValue writes the XML-RPC representation of the given value to buf
+func encodeValue(buf *bytes.Buffer, v reflect.Value) error {
+	for v.IsValid() && (v.Kind() == reflect.Interface || v.Kind() == reflect.Ptr) {
+		if v.IsNil() {
+			break
+		}
+		v = v.Elem()
+	}
+	if !v.IsValid() || ((v.Kind() == reflect.Interface || v.Kind() == reflect.Ptr) && v.IsNil()) {
+		buf.WriteString("<value><boolean>0</boolean></value>")
+		return nil
+	}
+	switch val := v.Interface().(type) {
+	case time.Time:
+		fmt.Fprintf(buf, "<value><dateTime.iso8601>%s</dateTime.iso8601></value>", val.Format(DateTimeFormat))
+		return nil
+	case []byte:
+		fmt.Fprintf(buf, "<value><base64>%s</base64></value>", base64.StdEncoding.EncodeToString(val))
+		return nil
+	case json.Number:
+		if _, err := val.Int64(); err == nil {
+			fmt.Fprintf(buf, "<value><int>%s</int></value>", val)
+			return nil
+		}
+		fmt.Fprintf(buf, "<value><double>%s</double></value>", val)
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			buf.WriteString("<value><boolean>1</boolean></value>")
+		} else {
+			buf.WriteString("<value><boolean>0</boolean></value>")
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		fmt.Fprintf(buf, "<value><int>%d</int></value>", v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		fmt.Fprintf(buf, "<value><int>%d</int></value>", v.Uint())
+	case reflect.Float32, reflect.Float64:
+		fmt.Fprintf(buf, "<value><double>%s</double></value>", strconv.FormatFloat(v.Float(), 'f', -1, 64))
+	case reflect.String:
+		buf.WriteString("<value><string>")
+		if err := xml.EscapeText(buf, []byte(v.String())); err != nil {
+			return err
+		}
+		buf.WriteString("</string></value>")
+	case reflect.Slice, reflect.Array:
+		buf.WriteString("<value><array><data>")
+		for i := 0; i < v.Len(); i++ {
+			if err := encodeValue(buf, v.Index(i)); err != nil {
+				return err
+			}
+		}
+		buf.WriteString("</data></array></value>")
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return fmt.Errorf("xmlrpc: unsupported map key type %s", v.Type().Key())
+		}
+		keys := make([]string, 0, v.Len())
+		for _, key := range v.MapKeys() {
+			keys = append(keys, key.String())
+		}
+		sort.Strings(keys)
+		buf.WriteString("<value><struct>")
+		for _, key := range keys {
+			buf.WriteString("<member><name>")
+			if err := xml.EscapeText(buf, []byte(key)); err != nil {
+				return err
+			}
+			buf.WriteString("</name>")
+			if err := encodeValue(buf, v.MapIndex(reflect.ValueOf(key).Convert(v.Type().Key()))); err != nil {
+				return err
+			}
+			buf.WriteString("</member>")
+		}
+		buf.WriteString("</struct></value>")
+	default:
+		return fmt.Errorf("xmlrpc: unsupported type %s", v.Type())
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package xmlrpc
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+var callXML = `<?xml version='1.0'?>
+<methodCall>
+<methodName>execute_kw</methodName>
+<params>
+<param><value><string>db</string></value></param>
+<param><value><int>2</int></value></param>
+<param><value>secret &amp; co</value></param>
+<param><value><array><data>
+<value><array><data>
+<value><array><data>
+<value><string>is_company</string></value>
+<value><string>=</string></value>
+<value><boolean>1</boolean></value>
+</data></array></value>
+</data></array></value>
+</data></array></value></param>
+<param><value><struct>
+<member><name>limit</name><value><i4>5</i4></value></member>
+<member><name>rate</name><value><double>1.5</double></value></member>
+<member><name>date</name><value><dateTime.iso8601>20170601T12:30:00</dateTime.iso8601></value></member>
+<member><name>data</name><value><base64>aGV4eWE=</base64></value></member>
+<member><name>none</name><value><nil/></value></member>
+</struct></value></param>
+</params>
+</methodCall>`
+
+func TestDecodeCall(t *testing.T) {
+	Convey("Testing XML-RPC requests decoding", t, func() {
+		method, params, err := DecodeCall(strings.NewReader(callXML))
+		So(err, ShouldBeNil)
+		So(method, ShouldEqual, "execute_kw")
+		So(params, ShouldHaveLength, 5)
+		So(params[0], ShouldEqual, "db")
+		So(params[1], ShouldEqual, int64(2))
+		So(params[2], ShouldEqual, "secret & co")
+		So(params[3], ShouldResemble, []interface{}{[]interface{}{[]interface{}{"is_company", "=", true}}})
+		So(params[4], ShouldResemble, map[string]interface{}{
+			"limit": int64(5),
+			"rate":  1.5,
+			"date":  time.Date(2017, 6, 1, 12, 30, 0, 0, time.UTC),
+			"data":  []byte("hexya"),
+			"none":  nil,
+		})
+		Convey("Invalid values should return an error", func() {
+			_, _, err := DecodeCall(strings.NewReader(`<methodCall><methodName>m</methodName><params>
+<param><value><boolean>yes</boolean></value></param></params></methodCall>`))
+			So(err, ShouldNotBeNil)
+			_, _, err = DecodeCall(strings.NewReader(`<methodCall><methodName>`))
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestEncodeResponse(t *testing.T) {
+	Convey("Testing XML-RPC responses encoding", t, func() {
+		var buf bytes.Buffer
+		err := EncodeResponse(&buf, map[string]interface{}{
+			"ids":   []int64{1, 2},
+			"name":  "A & B",
+			"rate":  json.Number("2.5"),
+			"count": json.Number("3"),
+			"empty": nil,
+			"ok":    true,
+		})
+		So(err, ShouldBeNil)
+		So(buf.String(), ShouldEqual, `<?xml version="1.0"?><methodResponse><params><param><value><struct>`+
+			`<member><name>count</name><value><int>3</int></value></member>`+
+			`<member><name>empty</name><value><boolean>0</boolean></value></member>`+
+			`<member><name>ids</name><value><array><data><value><int>1</int></value><value><int>2</int></value></data></array></value></member>`+
+			`<member><name>name</name><value><string>A &amp; B</string></value></member>`+
+			`<member><name>ok</name><value><boolean>1</boolean></value></member>`+
+			`<member><name>rate</name><value><double>2.5</double></value></member>`+
+			`</struct></value></param></params></methodResponse>`)
+		Convey("Encoded values should be decoded identically", func() {
+			var buf bytes.Buffer
+			EncodeResponse(&buf, []interface{}{int64(4), "text", 1.25, false})
+			resp := strings.Replace(buf.String(), "methodResponse", "methodCall", -1)
+			_, params, err := DecodeCall(strings.NewReader(resp))
+			So(err, ShouldBeNil)
+			So(params, ShouldResemble, []interface{}{[]interface{}{int64(4), "text", 1.25, false}})
+		})
+		Convey("Unsupported types should return an error", func() {
+			So(EncodeResponse(&buf, struct{}{}), ShouldNotBeNil)
+			So(EncodeResponse(&buf, map[int]string{1: "a"}), ShouldNotBeNil)
+		})
+	})
+	Convey("Testing XML-RPC faults encoding", t, func() {
+		var buf bytes.Buffer
+		So(EncodeFault(&buf, Fault{Code: 3, String: "Access Denied"}), ShouldBeNil)
+		So(buf.String(), ShouldEqual, `<?xml version="1.0"?><methodResponse><fault><value><struct>`+
+			`<member><name>faultCode</name><value><int>3</int></value></member>`+
+			`<member><name>faultString</name><value><string>Access Denied</string></value></member>`+
+			`</struct></value></fault></methodResponse>`)
+	})
+}
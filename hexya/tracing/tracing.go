@@ -0,0 +1,74 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+// Package tracing holds the OpenTelemetry instrumentation of the application.
+//
+// Spans are created by the packages they trace, such as models for the
+// environments, the method calls, the CRUD operations and the SQL queries,
+// and server for the HTTP requests and the RPC calls. They are exported
+// through the global OpenTelemetry tracer provider, so that nothing is
+// exported until a provider is set with otel.SetTracerProvider.
+package tracing
+
+import (
+	"unicode/utf8"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// InstrumentationName is the name of the tracer of the application
+const InstrumentationName = "github.com/hexya-erp/hexya"
+
+// MaxStatementLength is the maximum length in bytes of the SQL queries
+// set on the spans. Longer queries are truncated.
+var MaxStatementLength = 2048
+
+// Attribute keys of the spans of the application
+const (
+	// ModelKey is the name of the model of a method call or an operation
+	ModelKey = attribute.Key("hexya.model")
+	// MethodKey is the name of a method called on a model
+	MethodKey = attribute.Key("hexya.method")
+	// SuperKey is true if the method call executes the next layer of the
+	// method, with Super()
+	SuperKey = attribute.Key("hexya.super")
+	// RecordsKey is the number of records of the RecordSet of a method call
+	// or an operation
+	RecordsKey = attribute.Key("hexya.records")
+	// RowsKey is the number of rows created, read, updated or deleted by an
+	// operation
+	RowsKey = attribute.Key("hexya.rows")
+	// UIDKey is the id of the user of an environment
+	UIDKey = attribute.Key("hexya.uid")
+	// RetriesKey is the number of times a transaction has been retried after
+	// a serialization error
+	RetriesKey = attribute.Key("hexya.retries")
+	// DBSystemKey is the database management system of a SQL query
+	DBSystemKey = attribute.Key("db.system")
+	// DBStatementKey is the truncated SQL of a query
+	DBStatementKey = attribute.Key("db.statement")
+	// RPCSystemKey is the protocol of an RPC call, such as "jsonrpc"
+	RPCSystemKey = attribute.Key("rpc.system")
+	// RPCMethodKey is the method of an RPC call
+	RPCMethodKey = attribute.Key("rpc.method")
+)
+
+// Tracer returns the tracer of the application
+func Tracer() trace.Tracer {
+	return otel.Tracer(InstrumentationName)
+}
+
+// Statement returns the attribute of the given SQL query,
+// truncated to MaxStatementLength bytes.
+func Statement(query string) attribute.KeyValue {
+	if len(query) <= MaxStatementLength {
+		return DBStatementKey.String(query)
+	}
+	cut := MaxStatementLength
+	for cut > 0 && !utf8.RuneStart(query[cut]) {
+		cut--
+	}
+	return DBStatementKey.String(query[:cut] + "...")
+}
@@ -0,0 +1,28 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package tracing
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestStatement(t *testing.T) {
+	Convey("Testing SQL statements attributes", t, func() {
+		defer func(length int) { MaxStatementLength = length }(MaxStatementLength)
+		MaxStatementLength = 10
+		Convey("Short queries should be kept as is", func() {
+			So(Statement("SELECT 1").Value.AsString(), ShouldEqual, "SELECT 1")
+			So(Statement("SELECT 123").Value.AsString(), ShouldEqual, "SELECT 123")
+		})
+		Convey("Long queries should be truncated", func() {
+			So(Statement("SELECT * FROM partner").Value.AsString(), ShouldEqual, "SELECT * F...")
+		})
+		Convey("Queries should not be truncated inside a character", func() {
+			So(Statement("SELECT 'é'").Value.AsString(), ShouldEqual, "SELECT 'é...")
+			So(Statement("SELECT 'aé'").Value.AsString(), ShouldEqual, "SELECT 'a...")
+		})
+	})
+}
@@ -6,6 +6,7 @@ package views
 import (
 	"github.com/beevik/etree"
 	"github.com/hexya-erp/hexya/hexya/models"
+	"github.com/hexya-erp/hexya/hexya/models/security"
 	"github.com/hexya-erp/hexya/hexya/tools/logging"
 )
 
@@ -17,6 +18,7 @@ var log *logging.Logger
 // - sets the type of the view from the arch root.
 // - extracts embedded views
 // - populates the fields map from the views arch.
+// - resolves the groups of the views and checks the groups of their elements.
 func BootStrap() {
 	if !models.BootStrapped() {
 		log.Panic("Models must be bootstrapped before bootstrapping views")
@@ -54,6 +56,10 @@ func BootStrap() {
 			if xmlView.Model != "" {
 				model = xmlView.Model
 			}
+			groupIDs := baseView.GroupIDs
+			if xmlView.Groups != "" {
+				groupIDs = splitGroups(xmlView.Groups)
+			}
 			priority := baseView.Priority
 			if xmlView.Priority != 0 {
 				priority = xmlView.Priority
@@ -68,6 +74,7 @@ func BootStrap() {
 				Type:        baseView.Type,
 				arches:      make(map[string]*etree.Element),
 				FieldParent: baseView.FieldParent,
+				GroupIDs:    groupIDs,
 			}
 			newView.updateViewFromXML(xmlView)
 			Registry.Add(&newView)
@@ -77,10 +84,31 @@ func BootStrap() {
 	// Post-process all views
 	for _, v := range Registry.views {
 		log.Debug("Postprocessing view", "viewID", v.ID, "model", v.Model, "Type", v.Type)
+		bootStrapGroups(v)
 		v.postProcess()
 	}
 }
 
+// bootStrapGroups resolves the groups of the given view and checks
+// that the groups of the elements of its arch exist.
+func bootStrapGroups(v *View) {
+	v.Groups = nil
+	for _, groupID := range v.GroupIDs {
+		group := security.Registry.GetGroup(groupID)
+		if group == nil {
+			log.Panic("Unknown group in view", "view", v.ID, "group", groupID)
+		}
+		v.Groups = append(v.Groups, group)
+	}
+	for _, elt := range v.arch.FindElements("//[@groups]") {
+		for _, groupID := range splitGroups(elt.SelectAttrValue("groups", "")) {
+			if security.Registry.GetGroup(groupID) == nil {
+				log.Panic("Unknown group in view element", "view", v.ID, "element", elt.Tag, "group", groupID)
+			}
+		}
+	}
+}
+
 func init() {
 	log = logging.GetLogger("views")
 	Registry = NewCollection()
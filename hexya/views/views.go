@@ -27,6 +27,7 @@ import (
 	"github.com/beevik/etree"
 	"github.com/hexya-erp/hexya/hexya/i18n"
 	"github.com/hexya-erp/hexya/hexya/models"
+	"github.com/hexya-erp/hexya/hexya/models/security"
 	"github.com/hexya-erp/hexya/hexya/tools/xmlutils"
 )
 
@@ -243,6 +244,17 @@ func (vc *Collection) GetFirstViewForModel(model string, viewType ViewType) *Vie
 	return vc.defaultViewForModel(model, viewType)
 }
 
+// GetFirstViewForUser returns the first view of type viewType for the given
+// model that is visible to a user with the given groups.
+func (vc *Collection) GetFirstViewForUser(model string, viewType ViewType, userGroups map[*security.Group]security.InheritanceInfo) *View {
+	for _, view := range vc.orderedViews[model] {
+		if view.Type == viewType && view.IsVisibleFor(userGroups) {
+			return view
+		}
+	}
+	return vc.defaultViewForModel(model, viewType)
+}
+
 // defaultViewForModel returns a default view for the given model and type
 func (vc *Collection) defaultViewForModel(model string, viewType ViewType) *View {
 	view := View{
@@ -300,14 +312,20 @@ func (vc *Collection) createNewViewFromXML(viewXML *ViewXML) {
 		name = viewXML.Name
 	}
 
+	model := viewXML.Model
+	if mi, ok := models.Registry.Get(model); ok {
+		// Use the current name of renamed models
+		model = mi.ModelName()
+	}
 	arch := xmlutils.XMLToElement(viewXML.Arch)
 	view := View{
 		ID:          viewXML.ID,
 		Name:        name,
-		Model:       viewXML.Model,
+		Model:       model,
 		Priority:    priority,
 		arch:        arch,
 		FieldParent: viewXML.FieldParent,
+		GroupIDs:    splitGroups(viewXML.Groups),
 		SubViews:    make(map[string]SubViews),
 		arches:      make(map[string]*etree.Element),
 	}
@@ -324,6 +342,8 @@ type View struct {
 	arch        *etree.Element
 	FieldParent string
 	Fields      []models.FieldNamer
+	GroupIDs    []string
+	Groups      []*security.Group
 	SubViews    map[string]SubViews
 	arches      map[string]*etree.Element
 }
@@ -349,6 +369,98 @@ func (v *View) Arch(lang string) *etree.Element {
 	return res
 }
 
+// IsVisibleFor returns true if this view can be shown to a user with
+// the given groups, i.e. if it has no groups or if the user belongs to
+// one of them.
+func (v *View) IsVisibleFor(userGroups map[*security.Group]security.InheritanceInfo) bool {
+	if len(v.Groups) == 0 {
+		return true
+	}
+	for _, group := range v.Groups {
+		if _, ok := userGroups[group]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// UserArch returns the arch of this view for the given language, as seen by
+// a user with the given groups: elements with a groups attribute are removed
+// if the user belongs to none of these groups, and groups attributes are
+// removed from the others.
+func (v *View) UserArch(lang string, userGroups map[*security.Group]security.InheritanceInfo) *etree.Element {
+	res := xmlutils.CopyElement(v.Arch(lang))
+	for _, elt := range append(res.FindElements("//[@groups]"), res) {
+		groupsAttr := elt.SelectAttr("groups")
+		if groupsAttr == nil {
+			continue
+		}
+		elt.RemoveAttr("groups")
+		if elt == res || elt.Parent() == nil || userInGroups(splitGroups(groupsAttr.Value), userGroups) {
+			continue
+		}
+		elt.Parent().RemoveChild(elt)
+	}
+	return res
+}
+
+// A FieldsView is the definition of a view as it is sent to the client,
+// with its arch resolved for the user and the metadata of its fields.
+//
+// The embedded views of a field are given in the Views map of its FieldInfo.
+type FieldsView struct {
+	ID          string                       `json:"view_id"`
+	Name        string                       `json:"name"`
+	Model       string                       `json:"model"`
+	Type        ViewType                     `json:"type"`
+	Arch        string                       `json:"arch"`
+	FieldParent string                       `json:"field_parent"`
+	Fields      map[string]*models.FieldInfo `json:"fields"`
+}
+
+// FieldsViewGet returns the definition of this view for the user of the
+// given Environment, translated in the language of its context.
+func (v *View) FieldsViewGet(env models.Environment) FieldsView {
+	return v.fieldsView(env, env.Context().GetString("lang"), env.User().Groups())
+}
+
+// fieldsView returns the FieldsView of this view in the given
+// language, for a user with the given groups.
+func (v *View) fieldsView(env models.Environment, lang string, userGroups map[*security.Group]security.InheritanceInfo) FieldsView {
+	arch := v.UserArch(lang, userGroups)
+	var fieldNames []models.FieldName
+	for _, f := range arch.FindElements("//field") {
+		if xmlutils.HasParentTag(f, "field") {
+			continue
+		}
+		fieldNames = append(fieldNames, models.FieldName(f.SelectAttrValue("name", "")))
+	}
+	fields := make(map[string]*models.FieldInfo)
+	if len(fieldNames) > 0 {
+		fields = env.Pool(v.Model).Call("FieldsGet", models.FieldsGetArgs{Fields: fieldNames}).(map[string]*models.FieldInfo)
+	}
+	model := models.Registry.MustGet(v.Model)
+	for fieldName, subViews := range v.SubViews {
+		fInfo, ok := fields[model.JSONizeFieldName(fieldName)]
+		if !ok {
+			continue
+		}
+		fInfo.Views = make(map[string]interface{})
+		for viewType, subView := range subViews {
+			fInfo.Views[string(viewType)] = subView.fieldsView(env, lang, userGroups)
+		}
+	}
+	return FieldsView{
+		ID:          v.ID,
+		Name:        v.Name,
+		Model:       v.Model,
+		Type:        v.Type,
+		Arch:        xmlutils.ElementToXML(arch),
+		FieldParent: v.FieldParent,
+		Fields:      fields,
+	}
+}
+
 // setViewType sets the Type field with the view type
 // scanned from arch
 func (v *View) setViewType() {
@@ -494,6 +606,14 @@ func (v *View) updateViewFromXML(viewXML *ViewXML) {
 		if modifyAction == nil {
 			log.Panic("Spec should include 'position' attribute", "xpath", xpath, "spec", xmlutils.ElementToXML(spec), "view", v.ID)
 		}
+		if viewXML.ID == "" && viewXML.Groups != "" && modifyAction.Value != "attributes" {
+			// Nodes added by a pure extension view are only visible to its groups
+			for _, node := range spec.ChildElements() {
+				if node.SelectAttr("groups") == nil {
+					node.CreateAttr("groups", viewXML.Groups)
+				}
+			}
+		}
 		switch modifyAction.Value {
 		case "before":
 			for _, node := range spec.ChildElements() {
@@ -556,6 +676,7 @@ type ViewXML struct {
 	Arch        string `xml:",innerxml"`
 	InheritID   string `xml:"inherit_id,attr"`
 	FieldParent string `xml:"field_parent,attr"`
+	Groups      string `xml:"groups,attr"`
 }
 
 // LoadFromEtree reads the view given etree.Element, creates or updates the view
@@ -564,6 +685,29 @@ func LoadFromEtree(element *etree.Element) {
 	Registry.LoadFromEtree(element)
 }
 
+// splitGroups returns the group IDs of the given
+// comma separated groups attribute value.
+func splitGroups(groups string) []string {
+	var res []string
+	for _, groupID := range strings.Split(groups, ",") {
+		if groupID = strings.TrimSpace(groupID); groupID != "" {
+			res = append(res, groupID)
+		}
+	}
+	return res
+}
+
+// userInGroups returns true if a user with the given
+// userGroups belongs to one of the given groups.
+func userInGroups(groupIDs []string, userGroups map[*security.Group]security.InheritanceInfo) bool {
+	for _, groupID := range groupIDs {
+		if _, ok := userGroups[security.Registry.GetGroup(groupID)]; ok {
+			return true
+		}
+	}
+	return false
+}
+
 // getInheritXPathFromSpec returns an XPath string that is suitable for
 // searching the base view and find the node to modify.
 func getInheritXPathFromSpec(spec *etree.Element) string {
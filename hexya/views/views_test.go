@@ -21,6 +21,7 @@ import (
 	"testing"
 
 	"github.com/hexya-erp/hexya/hexya/models"
+	"github.com/hexya-erp/hexya/hexya/models/security"
 	"github.com/hexya-erp/hexya/hexya/tools/xmlutils"
 	. "github.com/smartystreets/goconvey/convey"
 )
@@ -156,6 +157,26 @@ var viewDef10 = `
 </view>
 `
 
+var viewDef11 = `
+<view id="admin_partner_form" model="Partner" priority="8" groups="admin">
+	<form>
+		<field name="Name"/>
+		<field name="Email" groups="admin"/>
+	</form>
+</view>
+`
+
+var viewDef12 = `
+<view inherit_id="my_other_id" groups="admin">
+	<xpath expr="//field[@name='Email']" position="after">
+		<field name="Fax"/>
+	</xpath>
+	<field name="Function" position="after">
+		<field name="Phone" groups="everyone"/>
+	</field>
+</view>
+`
+
 func TestViews(t *testing.T) {
 	Convey("Creating View 1", t, func() {
 		LoadFromEtree(xmlutils.XMLToElement(viewDef1))
@@ -687,6 +708,80 @@ func TestViews(t *testing.T) {
 			So(vt.Type, ShouldEqual, ViewTypeTree)
 		})
 	})
+	Convey("Testing views visibility by groups", t, func() {
+		Registry = NewCollection()
+		LoadFromEtree(xmlutils.XMLToElement(viewDef2))
+		LoadFromEtree(xmlutils.XMLToElement(viewDef11))
+		LoadFromEtree(xmlutils.XMLToElement(viewDef12))
+		BootStrap()
+		adminGroups := map[*security.Group]security.InheritanceInfo{
+			security.GroupAdmin:    security.NativeGroup,
+			security.GroupEveryone: security.NativeGroup,
+		}
+		userGroups := map[*security.Group]security.InheritanceInfo{
+			security.GroupEveryone: security.NativeGroup,
+		}
+		adminView := Registry.GetByID("admin_partner_form")
+		So(adminView.Groups, ShouldContain, security.GroupAdmin)
+		Convey("Views with groups should only be visible to their members", func() {
+			So(adminView.IsVisibleFor(adminGroups), ShouldBeTrue)
+			So(adminView.IsVisibleFor(userGroups), ShouldBeFalse)
+			So(Registry.GetByID("my_other_id").IsVisibleFor(userGroups), ShouldBeTrue)
+			So(Registry.GetFirstViewForUser("Partner", ViewTypeForm, adminGroups).ID, ShouldEqual, "admin_partner_form")
+			So(Registry.GetFirstViewForUser("Partner", ViewTypeForm, userGroups).ID, ShouldEqual, "my_other_id")
+		})
+		Convey("Elements with groups should only be visible to their members", func() {
+			So(xmlutils.ElementToXML(adminView.UserArch("", adminGroups)), ShouldEqual, `<form>
+	<field name="name"/>
+	<field name="email"/>
+</form>
+`)
+			So(xmlutils.ElementToXML(adminView.UserArch("", userGroups)), ShouldEqual, `<form>
+	<field name="name"/>
+</form>
+`)
+		})
+		Convey("Elements added by extension views with groups should only be visible to their members", func() {
+			view := Registry.GetByID("my_other_id")
+			So(xmlutils.ElementToXML(view.UserArch("", adminGroups)), ShouldEqual, `<form>
+	<h1>
+		<field name="name"/>
+	</h1>
+	<group name="position_info">
+		<field name="function"/>
+		<field name="phone"/>
+	</group>
+	<group name="contact_data">
+		<field name="email"/>
+		<field name="fax"/>
+	</group>
+</form>
+`)
+			So(xmlutils.ElementToXML(view.UserArch("", userGroups)), ShouldEqual, `<form>
+	<h1>
+		<field name="name"/>
+	</h1>
+	<group name="position_info">
+		<field name="function"/>
+		<field name="phone"/>
+	</group>
+	<group name="contact_data">
+		<field name="email"/>
+	</group>
+</form>
+`)
+		})
+		Convey("Unknown groups should panic", func() {
+			Registry = NewCollection()
+			LoadFromEtree(xmlutils.XMLToElement(`
+<view id="unknown_group_view" model="Partner">
+	<form>
+		<field name="Name" groups="unknown_group"/>
+	</form>
+</view>`))
+			So(BootStrap, ShouldPanic)
+		})
+	})
 	Convey("Testing search view sanitizing", t, func() {
 		Registry = NewCollection()
 		LoadFromEtree(xmlutils.XMLToElement(viewDef10))